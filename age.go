@@ -0,0 +1,643 @@
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// this file implements the age format (https://age-encryption.org/v1) so
+// files can be exchanged with the age CLI and other implementations. it is
+// a separate format from crypt streams: its own header, a 16 byte file key
+// and ChaCha20-Poly1305 with 64KiB chunks, nothing here is configurable.
+//
+// X25519 recipients are *PublicKey and *PrivateKey, written as age1... and
+// AGE-SECRET-KEY-1... with AgeString. passwords are AgeScrypt.
+
+const (
+	ageIntro      = "age-encryption.org/v1\n"
+	ageMACPrefix  = "---"
+	ageFileKeyLen = 16
+	ageNonceLen   = 16
+	ageChunkSize  = 64 * 1024
+	ageColumns    = 64
+
+	ageX25519Label = "age-encryption.org/v1/X25519"
+	ageScryptLabel = "age-encryption.org/v1/scrypt"
+
+	// ageMaxLine limits header lines so a bad file can't make us buffer
+	// forever, and ageMaxStanzas limits how many recipients we'll try
+	ageMaxLine    = 64 * 1024
+	ageMaxStanzas = 1024
+
+	// DefaultAgeWorkFactor is the scrypt work factor (log2 N) used by
+	// AgeScrypt when none is given, the same as the age CLI.
+	DefaultAgeWorkFactor = 18
+
+	// maxAgeWorkFactor is the highest work factor AgeScrypt will accept
+	// when decrypting unless told otherwise, higher ones take too long to
+	// be anything but an attack.
+	maxAgeWorkFactor = 22
+)
+
+// AgeRecipient is something an age file can be encrypted to.
+type AgeRecipient interface {
+	ageWrap(fileKey []byte) (*ageStanza, error)
+}
+
+// AgeIdentity is something that can decrypt an age file. it returns
+// errNotForIdentity for stanzas that aren't for it.
+type AgeIdentity interface {
+	ageUnwrap(s *ageStanza) ([]byte, error)
+}
+
+// ageStanza is a recipient stanza from an age header.
+type ageStanza struct {
+	typ  string
+	args []string
+	body []byte
+}
+
+// AgeScrypt is a password recipient and identity for age files.
+// WorkFactor is log2 of the scrypt N parameter, when encrypting 0 means
+// DefaultAgeWorkFactor and when decrypting it is the most that will be
+// accepted, 0 meaning 22. a file encrypted with a password can't have any
+// other recipients.
+type AgeScrypt struct {
+	Password   []byte
+	WorkFactor int
+}
+
+func (s *AgeScrypt) ageWrap(fileKey []byte) (*ageStanza, error) {
+	logN := s.WorkFactor
+	if logN == 0 {
+		logN = DefaultAgeWorkFactor
+	}
+	if logN < 1 || logN > 30 {
+		return nil, errors.New("crypt: invalid age scrypt work factor")
+	}
+
+	salt, err := newNonce(randReader, 16)
+	if err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key(s.Password, append([]byte(ageScryptLabel), salt...), 1<<logN, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ageSeal(key, fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{b64.EncodeToString(salt), strconv.Itoa(logN)}
+	return &ageStanza{typ: "scrypt", args: args, body: body}, nil
+}
+
+func (s *AgeScrypt) ageUnwrap(st *ageStanza) ([]byte, error) {
+	if st.typ != "scrypt" {
+		return nil, errNotForIdentity
+	}
+	if len(st.args) != 2 || len(st.body) != ageFileKeyLen+tagSize {
+		return nil, errors.New("crypt: invalid age scrypt stanza")
+	}
+
+	salt, err := b64.DecodeString(st.args[0])
+	if err != nil || len(salt) != 16 {
+		return nil, errors.New("crypt: invalid age scrypt stanza")
+	}
+	logN, err := strconv.Atoi(st.args[1])
+	if err != nil || logN < 1 || strconv.Itoa(logN) != st.args[1] {
+		return nil, errors.New("crypt: invalid age scrypt stanza")
+	}
+
+	max := s.WorkFactor
+	if max == 0 {
+		max = maxAgeWorkFactor
+	}
+	if logN > max {
+		return nil, errors.New("crypt: age scrypt work factor is too high")
+	}
+
+	key, err := scrypt.Key(s.Password, append([]byte(ageScryptLabel), salt...), 1<<logN, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	fileKey, err := ageOpen(key, st.body)
+	if err != nil {
+		return nil, errors.New("crypt: incorrect age password")
+	}
+	return fileKey, nil
+}
+
+func (k *PublicKey) ageWrap(fileKey []byte) (*ageStanza, error) {
+	eph, err := ecdh.X25519().GenerateKey(randReader)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ageX25519Key(eph, k[:], eph.PublicKey().Bytes(), k[:])
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ageSeal(key, fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{b64.EncodeToString(eph.PublicKey().Bytes())}
+	return &ageStanza{typ: "X25519", args: args, body: body}, nil
+}
+
+func (k *PrivateKey) ageUnwrap(st *ageStanza) ([]byte, error) {
+	if st.typ != "X25519" {
+		return nil, errNotForIdentity
+	}
+	if len(st.args) != 1 || len(st.body) != ageFileKeyLen+tagSize {
+		return nil, errors.New("crypt: invalid age X25519 stanza")
+	}
+	ephPub, err := b64.DecodeString(st.args[0])
+	if err != nil || len(ephPub) != 32 {
+		return nil, errors.New("crypt: invalid age X25519 stanza")
+	}
+
+	priv, err := ecdh.X25519().NewPrivateKey(k[:])
+	if err != nil {
+		return nil, err
+	}
+	key, err := ageX25519Key(priv, ephPub, ephPub, k.Public()[:])
+	if err != nil {
+		return nil, err
+	}
+
+	fileKey, err := ageOpen(key, st.body)
+	if err != nil {
+		return nil, errNotForIdentity
+	}
+	return fileKey, nil
+}
+
+// ageX25519Key derives the key wrapping the file key from X25519 between
+// priv and peer.
+func ageX25519Key(priv *ecdh.PrivateKey, peer, ephPub, recipient []byte) ([]byte, error) {
+	pk, err := ecdh.X25519().NewPublicKey(peer)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := priv.ECDH(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := append(append([]byte{}, ephPub...), recipient...)
+	return hkdf.Key(sha256.New, shared, salt, ageX25519Label, 32)
+}
+
+// ageSeal and ageOpen wrap a file key, the wrapping key is only ever used
+// once so the nonce is all zeros.
+func ageSeal(key, fileKey []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil), nil
+}
+
+func ageOpen(key, body []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), body, nil)
+}
+
+// AgeString returns k as an age recipient, age1...
+func (k *PublicKey) AgeString() string {
+	return bech32Encode("age", k[:])
+}
+
+// AgeString returns k as an age identity, AGE-SECRET-KEY-1...
+func (k *PrivateKey) AgeString() string {
+	return strings.ToUpper(bech32Encode("age-secret-key-", k[:]))
+}
+
+// ParseAgeRecipient parses an age X25519 recipient, age1...
+func ParseAgeRecipient(s string) (*PublicKey, error) {
+	hrp, b, err := bech32Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if hrp != "age" || len(b) != 32 {
+		return nil, errors.New("crypt: not an age recipient")
+	}
+	return (*PublicKey)(b), nil
+}
+
+// ParseAgeIdentity parses an age X25519 identity, AGE-SECRET-KEY-1...
+func ParseAgeIdentity(s string) (*PrivateKey, error) {
+	hrp, b, err := bech32Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if hrp != "age-secret-key-" || len(b) != 32 {
+		return nil, errors.New("crypt: not an age identity")
+	}
+	return (*PrivateKey)(b), nil
+}
+
+// b64 is the base64 used everywhere in age headers, no padding and no
+// non-canonical encodings.
+var b64 = base64.RawStdEncoding.Strict()
+
+// ageHeaderMAC returns the MAC of header with a key derived from the file
+// key, agePayloadAEAD returns the AEAD for the payload.
+func ageHeaderMAC(fileKey, header []byte) ([]byte, error) {
+	key, err := hkdf.Key(sha256.New, fileKey, nil, "header", 32)
+	if err != nil {
+		return nil, err
+	}
+	m := hmac.New(sha256.New, key)
+	m.Write(header)
+	return m.Sum(nil), nil
+}
+
+func agePayloadAEAD(fileKey, nonce []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, fileKey, nonce, "payload", 32)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
+
+// ageChunkNonce is the 11 byte big endian chunk counter followed by 1 on
+// the final chunk.
+func ageChunkNonce(seq uint64, final bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	for i := 10; i >= 3; i-- {
+		nonce[i] = byte(seq)
+		seq >>= 8
+	}
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// AgeWriter encrypts to the age format, see NewAgeWriter.
+type AgeWriter struct {
+	w    io.Writer
+	aead cipher.AEAD
+
+	// buf holds a chunk of plaintext, like Writer a full chunk is held
+	// back in case it is the final one
+	buf []byte
+	seq uint64
+	err error
+}
+
+// NewAgeWriter writes an age header for recipients to w and returns an
+// AgeWriter encrypting everything written to it. Close must be called to
+// finish the file.
+func NewAgeWriter(w io.Writer, recipients ...AgeRecipient) (*AgeWriter, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("crypt: no recipients")
+	}
+	for _, r := range recipients {
+		if _, ok := r.(*AgeScrypt); ok && len(recipients) != 1 {
+			return nil, errors.New("crypt: an age password can't be used with other recipients")
+		}
+	}
+
+	fileKey, err := newNonce(randReader, ageFileKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	var hdr bytes.Buffer
+	hdr.WriteString(ageIntro)
+	for _, r := range recipients {
+		s, err := r.ageWrap(fileKey)
+		if err != nil {
+			return nil, err
+		}
+		writeAgeStanza(&hdr, s)
+	}
+	hdr.WriteString(ageMACPrefix)
+
+	mac, err := ageHeaderMAC(fileKey, hdr.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	hdr.WriteString(" " + b64.EncodeToString(mac) + "\n")
+
+	nonce, err := newNonce(randReader, ageNonceLen)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := agePayloadAEAD(fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+	hdr.Write(nonce)
+
+	if _, err := w.Write(hdr.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return &AgeWriter{w: w, aead: aead, buf: make([]byte, 0, ageChunkSize)}, nil
+}
+
+// writeAgeStanza writes s with its body wrapped at 64 columns, the last
+// line is always shorter than 64 so a body that fits exactly gets an empty
+// line after it.
+func writeAgeStanza(w *bytes.Buffer, s *ageStanza) {
+	w.WriteString("-> " + s.typ)
+	for _, a := range s.args {
+		w.WriteString(" " + a)
+	}
+	w.WriteByte('\n')
+
+	body := b64.EncodeToString(s.body)
+	for len(body) >= ageColumns {
+		w.WriteString(body[:ageColumns] + "\n")
+		body = body[ageColumns:]
+	}
+	w.WriteString(body + "\n")
+}
+
+// Write encrypts p, chunks are written once they are full and more data
+// arrives.
+func (w *AgeWriter) Write(p []byte) (total int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	for len(p) > 0 {
+		if len(w.buf) == ageChunkSize {
+			if err := w.flush(false); err != nil {
+				return total, err
+			}
+		}
+
+		n := min(len(p), ageChunkSize-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		total += n
+	}
+
+	return total, nil
+}
+
+// Close writes the final chunk, it does not close the underlying writer.
+func (w *AgeWriter) Close() error {
+	if w.err == ErrClosed {
+		return nil
+	} else if w.err != nil {
+		return w.err
+	}
+
+	if err := w.flush(true); err != nil {
+		return err
+	}
+	w.err = ErrClosed
+	return nil
+}
+
+func (w *AgeWriter) flush(final bool) error {
+	ct := w.aead.Seal(nil, ageChunkNonce(w.seq, final), w.buf, nil)
+	if _, err := w.w.Write(ct); err != nil {
+		w.err = err
+		return err
+	}
+
+	w.buf = w.buf[:0]
+	w.seq++
+	return nil
+}
+
+// AgeReader decrypts an age file, see NewAgeReader.
+type AgeReader struct {
+	r    *bufio.Reader
+	aead cipher.AEAD
+
+	// buf holds a sealed chunk and out its plaintext, they can't overlap
+	// since a chunk may have to be opened twice
+	buf   []byte
+	out   []byte
+	plain []byte
+	seq   uint64
+	eof   bool
+}
+
+// NewAgeReader reads an age header from r and returns an AgeReader
+// decrypting the payload with whichever of identities the file was
+// encrypted for.
+func NewAgeReader(r io.Reader, identities ...AgeIdentity) (*AgeReader, error) {
+	br := bufio.NewReader(r)
+
+	var hdr bytes.Buffer
+	line, err := ageReadLine(br, &hdr)
+	if err != nil {
+		return nil, err
+	}
+	if line+"\n" != ageIntro {
+		return nil, errors.New("crypt: not an age file")
+	}
+
+	var stanzas []*ageStanza
+	var mac []byte
+	for {
+		line, err := ageReadLine(br, &hdr)
+		if err != nil {
+			return nil, err
+		}
+
+		if rest, ok := strings.CutPrefix(line, ageMACPrefix+" "); ok {
+			// the MAC covers the header up to and including "---"
+			hdr.Truncate(hdr.Len() - len(line) - 1 + len(ageMACPrefix))
+			if mac, err = b64.DecodeString(rest); err != nil || len(mac) != sha256.Size {
+				return nil, errors.New("crypt: invalid age header MAC")
+			}
+			break
+		}
+
+		rest, ok := strings.CutPrefix(line, "-> ")
+		if !ok {
+			return nil, errors.New("crypt: invalid age header line")
+		}
+		if len(stanzas) == ageMaxStanzas {
+			return nil, errors.New("crypt: too many age recipients")
+		}
+		args := strings.Split(rest, " ")
+		for _, a := range args {
+			if a == "" {
+				return nil, errors.New("crypt: invalid age stanza arguments")
+			}
+		}
+		s := &ageStanza{typ: args[0], args: args[1:]}
+
+		for {
+			line, err := ageReadLine(br, &hdr)
+			if err != nil {
+				return nil, err
+			}
+			if len(line) > ageColumns {
+				return nil, errors.New("crypt: invalid age stanza body")
+			}
+			b, err := b64.DecodeString(line)
+			if err != nil {
+				return nil, errors.New("crypt: invalid age stanza body")
+			}
+			s.body = append(s.body, b...)
+			if len(line) < ageColumns {
+				break
+			}
+		}
+		stanzas = append(stanzas, s)
+	}
+
+	if len(stanzas) == 0 {
+		return nil, errors.New("crypt: age file has no recipients")
+	}
+	for _, s := range stanzas {
+		if s.typ == "scrypt" && len(stanzas) != 1 {
+			return nil, errors.New("crypt: age scrypt stanza must be the only one")
+		}
+	}
+
+	fileKey, err := ageUnwrap(stanzas, identities)
+	if err != nil {
+		return nil, err
+	}
+
+	want, err := ageHeaderMAC(fileKey, hdr.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, want) {
+		return nil, errors.New("crypt: age header MAC mismatch")
+	}
+
+	nonce := make([]byte, ageNonceLen)
+	if _, err := io.ReadFull(br, nonce); err != nil {
+		return nil, ErrTruncated
+	}
+	aead, err := agePayloadAEAD(fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AgeReader{r: br, aead: aead, buf: make([]byte, ageChunkSize+tagSize), out: make([]byte, 0, ageChunkSize)}, nil
+}
+
+// ageReadLine reads a line from r, adding it to hdr, and returns it without
+// the newline.
+func ageReadLine(r *bufio.Reader, hdr *bytes.Buffer) (string, error) {
+	var line []byte
+	for {
+		b, err := r.ReadSlice('\n')
+		line = append(line, b...)
+		if len(line) > ageMaxLine {
+			return "", errors.New("crypt: age header line is too long")
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err == io.EOF {
+			return "", ErrTruncated
+		} else if err != nil {
+			return "", err
+		}
+
+		hdr.Write(line)
+		return string(line[:len(line)-1]), nil
+	}
+}
+
+// ageUnwrap finds the file key in stanzas with one of identities.
+func ageUnwrap(stanzas []*ageStanza, identities []AgeIdentity) ([]byte, error) {
+	for _, id := range identities {
+		for _, s := range stanzas {
+			fileKey, err := id.ageUnwrap(s)
+			if err == errNotForIdentity {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			if len(fileKey) != ageFileKeyLen {
+				return nil, errors.New("crypt: invalid age file key")
+			}
+			return fileKey, nil
+		}
+	}
+
+	return nil, errors.New("crypt: no identity matched any age recipient")
+}
+
+// Read decrypts data into p.
+func (r *AgeReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk. a full chunk can be final too, in which
+// case nothing may follow it.
+func (r *AgeReader) next() error {
+	n, err := io.ReadFull(r.r, r.buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		if n < tagSize {
+			return ErrTruncated
+		}
+	} else if err != nil {
+		return err
+	}
+	chunk := r.buf[:n]
+
+	final := n < len(r.buf)
+	if !final {
+		r.plain, err = r.aead.Open(r.out[:0], ageChunkNonce(r.seq, false), chunk, nil)
+	}
+	if final || err != nil {
+		final = true
+		r.plain, err = r.aead.Open(r.out[:0], ageChunkNonce(r.seq, true), chunk, nil)
+		if err != nil {
+			return errors.New("crypt: age chunk failed to authenticate")
+		}
+	}
+
+	if final {
+		if len(r.plain) == 0 && r.seq != 0 {
+			return errors.New("crypt: age final chunk is empty")
+		}
+		if _, err := r.r.Peek(1); err != io.EOF {
+			return errors.New("crypt: trailing data after age final chunk")
+		}
+		r.eof = true
+	}
+
+	r.seq++
+	return nil
+}
@@ -0,0 +1,147 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"testing"
+)
+
+// ageVector was made by the age library for ageVectorIdentity.
+const (
+	ageVectorIdentity  = "AGE-SECRET-KEY-1A0Q66A7V4WRAVYXE206DL2NHG6WKUA7F4A9R04WUPAUHJYQ5UM4SJWHYRV"
+	ageVectorRecipient = "age12r2djp4lmvrsrxlgmmae47em3rhsy3495yqm7dzm7rwet7yk3vxqgxg52d"
+	ageVector          = "YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSBneUJpa2M5N0ZZVG1yVCtZQjZYVDBJNUowT0JIMkp4L2liR0RpSDc0QW1vClVwSTQ3MjJXdHFkRkd1M0FJSnVOb1h5YldNc3Y2TlppVnlwSVJZWFlzdmMKLS0tIElsNjFRS1grVEdRV0IyOUZuMkUwQUxGSUFETS9ndWlEUTRNcDRzek45T1UK2ZcCwDVTPOXAIUbRpkgOvZI2e3zwJdA2AQ80jFbbOeBUdMg8hLzaMyVXyXo1jnY="
+)
+
+// TestAgeVector decrypts a file made by age.
+func TestAgeVector(t *testing.T) {
+	t.Parallel()
+	priv, err := ParseAgeIdentity(ageVectorIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priv.AgeString() != ageVectorIdentity {
+		t.Fatal("identity did not round trip")
+	}
+	if priv.Public().AgeString() != ageVectorRecipient {
+		t.Fatal("wrong recipient for identity")
+	}
+	pub, err := ParseAgeRecipient(ageVectorRecipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *pub != *priv.Public() {
+		t.Fatal("recipient did not parse")
+	}
+
+	file, _ := base64.StdEncoding.DecodeString(ageVector)
+	r, err := NewAgeReader(bytes.NewReader(file), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello from age\n" {
+		t.Fatalf("got %q", got)
+	}
+
+	// a flipped bit anywhere must be caught
+	for i := range file {
+		bad := bytes.Clone(file)
+		bad[i] ^= 1
+		r, err := NewAgeReader(bytes.NewReader(bad), priv)
+		if err == nil {
+			_, err = io.ReadAll(r)
+		}
+		if err == nil {
+			t.Fatalf("flipped bit in byte %d went unnoticed", i)
+		}
+	}
+}
+
+// TestAge round trips files of sizes around the chunk size.
+func TestAge(t *testing.T) {
+	t.Parallel()
+	pub1, priv1, _ := GenerateKeyPair()
+	pub2, priv2, _ := GenerateKeyPair()
+	_, other, _ := GenerateKeyPair()
+
+	for _, size := range []int{0, 1, ageChunkSize - 1, ageChunkSize, ageChunkSize + 1, 3 * ageChunkSize} {
+		data := randBytes(size)
+		var buf bytes.Buffer
+		w, err := NewAgeWriter(&buf, pub1, pub2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, priv := range []*PrivateKey{priv1, priv2} {
+			r, err := NewAgeReader(bytes.NewReader(buf.Bytes()), other, priv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("size %d: %v", size, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("size %d did not round trip", size)
+			}
+		}
+
+		if _, err := NewAgeReader(bytes.NewReader(buf.Bytes()), other); err == nil {
+			t.Fatal("wrong identity opened the file")
+		}
+
+		r, err := NewAgeReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]), priv1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(r); err == nil {
+			t.Fatalf("size %d: truncated file read", size)
+		}
+	}
+}
+
+// TestAgeScrypt round trips a file encrypted with a password.
+func TestAgeScrypt(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	w, err := NewAgeWriter(&buf, &AgeScrypt{Password: []byte("hunter2"), WorkFactor: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("secret"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewAgeReader(bytes.NewReader(buf.Bytes()), &AgeScrypt{Password: []byte("hunter2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil || string(got) != "secret" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	if _, err := NewAgeReader(bytes.NewReader(buf.Bytes()), &AgeScrypt{Password: []byte("hunter3")}); err == nil {
+		t.Fatal("wrong password opened the file")
+	}
+	if _, err := NewAgeReader(bytes.NewReader(buf.Bytes()), &AgeScrypt{Password: []byte("hunter2"), WorkFactor: 9}); err == nil {
+		t.Fatal("work factor limit ignored")
+	}
+
+	pub, _, _ := GenerateKeyPair()
+	if _, err := NewAgeWriter(io.Discard, &AgeScrypt{Password: []byte("x")}, pub); err == nil {
+		t.Fatal("password mixed with other recipients")
+	}
+}
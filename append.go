@@ -0,0 +1,95 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"errors"
+	"io"
+)
+
+// EncryptAppend is Encrypt appending the ciphertext to dst, like the dst
+// of cipher.AEAD's Seal, so a buffer can be reused. it still sets up the
+// cipher on every call, use a Sealer to avoid that too.
+func EncryptAppend(dst, plaintext []byte, key *[32]byte, opts ...Option) ([]byte, error) {
+	s, err := NewSealer(key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s.EncryptAppend(dst, plaintext)
+}
+
+// DecryptAppend is Decrypt appending the plaintext to dst, which must not
+// overlap ciphertext.
+func DecryptAppend(dst, ciphertext []byte, key *[32]byte, opts ...Option) ([]byte, error) {
+	if len(ciphertext) == 0 {
+		return nil, errors.New("ciphertext is empty")
+	}
+
+	s, err := NewSealer(key, append([]Option{WithCipher(Cipher(ciphertext[0]))}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return s.DecryptAppend(dst, ciphertext)
+}
+
+// Sealer encrypts and decrypts in the format of Encrypt with a key and
+// options fixed up front, holding on to the cipher so hot paths sealing
+// lots of small records don't set it up again each time. with a dst that
+// has room, EncryptAppend and DecryptAppend don't allocate for any cipher
+// but Cascade. it is safe for concurrent use.
+type Sealer struct {
+	aead   cipher.AEAD
+	cipher Cipher
+	aad    []byte
+
+	// random is where nonces come from, convergence replaces it when set
+	random      io.Reader
+	convergence []byte
+}
+
+// NewSealer returns a Sealer for key, options apply as for Encrypt.
+func NewSealer(key *[32]byte, opts ...Option) (*Sealer, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.policy.check(c.cipher, 0); err != nil {
+		return nil, err
+	}
+
+	aead, err := c.cipher.NewAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sealer{
+		aead:        aead,
+		cipher:      c.cipher,
+		aad:         c.aad,
+		random:      c.random,
+		convergence: c.convergence,
+	}, nil
+}
+
+// EncryptAppend encrypts plaintext, appending the ciphertext to dst. the
+// result can be decrypted with Decrypt.
+func (s *Sealer) EncryptAppend(dst, plaintext []byte) ([]byte, error) {
+	random := s.random
+	if s.convergence != nil {
+		random = bytes.NewReader(convergentNonce(s.convergence, s.aead.NonceSize(), []byte{byte(s.cipher)}, s.aad, plaintext))
+	}
+	return sealAppend(dst, s.aead, s.cipher, random, plaintext, s.aad)
+}
+
+// DecryptAppend decrypts ciphertext made with the Sealer's key and cipher,
+// appending the plaintext to dst, which must not overlap ciphertext.
+func (s *Sealer) DecryptAppend(dst, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 {
+		return nil, errors.New("ciphertext is empty")
+	}
+	if Cipher(ciphertext[0]) != s.cipher {
+		return nil, errors.New("crypt: ciphertext was made with " + Cipher(ciphertext[0]).String() + ", not " + s.cipher.String())
+	}
+
+	return openAppend(dst, s.aead, ciphertext, s.aad)
+}
@@ -0,0 +1,74 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// TestAppend makes sure the append variants interoperate with Encrypt and
+// Decrypt and leave dst's contents alone.
+func TestAppend(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	data := randBytes(100)
+
+	for _, c := range ciphers {
+		prefix := []byte("prefix")
+		out, err := EncryptAppend(bytes.Clone(prefix), data, key, WithCipher(c), WithAAD([]byte("aad")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(out, prefix) {
+			t.Fatalf("%v: dst was overwritten", c)
+		}
+		ct := out[len(prefix):]
+		if got, err := Decrypt(ct, key, WithAAD([]byte("aad"))); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%v: Decrypt got %v", c, err)
+		}
+
+		sealed, _ := Encrypt(data, key, WithCipher(c))
+		got, err := DecryptAppend(bytes.Clone(prefix), sealed, key)
+		if err != nil || !bytes.Equal(got, append(bytes.Clone(prefix), data...)) {
+			t.Fatalf("%v: DecryptAppend got %v", c, err)
+		}
+
+		sealed, _ = Encrypt(data, key, WithCipher(c))
+		sealed[len(sealed)-1] ^= 1
+		if _, err := DecryptAppend(nil, sealed, key); !errors.Is(err, ErrAuthenticationFailed) {
+			t.Fatalf("%v: damaged ciphertext gave %v", c, err)
+		}
+	}
+
+	s, err := NewSealer(key, WithCipher(XChaCha20Poly1305))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := Encrypt(data, key, WithCipher(AES256GCM))
+	if _, err := s.DecryptAppend(nil, other); err == nil {
+		t.Fatal("a Sealer decrypted another cipher's ciphertext")
+	}
+}
+
+// TestSealerAllocs makes sure the hot path doesn't allocate.
+func TestSealerAllocs(t *testing.T) {
+	key := randKey()
+	s, err := NewSealer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randBytes(64)
+	ct := make([]byte, 0, 256)
+	pt := make([]byte, 0, 256)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		ct, _ = s.EncryptAppend(ct[:0], data)
+		pt, _ = s.DecryptAppend(pt[:0], ct)
+	})
+	if allocs != 0 {
+		t.Fatalf("%v allocations per run", allocs)
+	}
+	if !bytes.Equal(pt, data) {
+		t.Fatal("round trip failed")
+	}
+}
@@ -0,0 +1,320 @@
+package crypt
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// archiveParityGroup is how many chunks share a parity chunk in streams
+// made with WithArchiveProfile, about 6% overhead.
+const archiveParityGroup = 16
+
+// archiveKDF is the KDF WithArchiveProfile uses for password streams,
+// stronger than DefaultKDF since the data is expected to outlive today's
+// hardware by a long way.
+var archiveKDF = Argon2id{Time: 4, Memory: 256 * 1024, Threads: 4}
+
+// archiveDoc is stored in the header of archive streams, so whoever finds
+// the file in thirty years can decrypt it without this package.
+const archiveDoc = `crypt stream, archive profile. all integers big endian.
+header: "CRYPT" | version (1) | cipher (1) | chunk size (4) | fields length (2) | fields.
+fields: type (1) | length (2) | value, in increasing type order.
+1 = KDF for password streams: id (1) | params | salt. ids: 1 argon2id (time 4, memory KiB 4, threads 1),
+2 scrypt (log2 N 1, r 4, p 4), 3 pbkdf2-sha256 (iterations 4). salt is the rest. key is 32 bytes.
+2 = key ID (4). 3 = wrapped data keys, not authenticated. 4 = this text. 5 = parity group size (2).
+cipher 5 = cascade: inner AES-256-GCM with key HKDF-SHA256(key, no salt, "crypt cascade inner v1\x00"),
+outer XChaCha20-Poly1305 with key HKDF-SHA256(key, no salt, "crypt cascade outer v1\x00").
+seal = outer(nonce, inner(nonce[:12], plaintext, aad), aad), tags are 16 bytes each.
+chunks: sequence (8) | nonce (24) | ciphertext | tag (32). sequence counts from 0, top bit set on the last chunk.
+aad = header without field 3 | sequence. every chunk but the last holds exactly chunk size bytes.
+after every group of chunks, and after the last partial group, is a parity block the size of a full
+chunk: the XOR of the group's chunks, each zero padded to full size. it repairs one bad chunk per group.`
+
+// WithArchiveProfile makes a Writer use conservative settings for data
+// that has to be decryptable decades from now: the Cascade cipher, a
+// parity chunk after every 16 chunks so a damaged chunk can be rebuilt
+// with RepairArchive, a plain text description of the format in the
+// header, and a strong KDF for password streams whose parameters are in
+// the header like any password stream. check archives with
+// ValidateArchive. Readers handle archive streams without being told.
+func WithArchiveProfile() Option {
+	return func(c *config) error {
+		c.cipher = Cascade
+		c.kdf = archiveKDF
+		c.archive = true
+		return nil
+	}
+}
+
+// parityGroup returns the parity group size of a stream, 0 if it has none.
+func (h *header) parityGroup() (int, error) {
+	v, ok := h.fields[fieldParity]
+	if !ok {
+		return 0, nil
+	}
+	if len(v) != 2 || binary.BigEndian.Uint16(v) == 0 {
+		return 0, errBadHeader("invalid parity field")
+	}
+
+	return int(binary.BigEndian.Uint16(v)), nil
+}
+
+// parityWriter passes sealed chunks through to w, writing the XOR of each
+// group of chunks after them.
+type parityWriter struct {
+	w     io.Writer
+	frame int
+	group int
+
+	// parity is the XOR of the group so far, n is how many bytes of the
+	// group have been written
+	parity []byte
+	n      int
+}
+
+func newParityWriter(w io.Writer, frame, group int) *parityWriter {
+	return &parityWriter{w: w, frame: frame, group: group, parity: make([]byte, frame)}
+}
+
+func (p *parityWriter) Write(b []byte) (total int, err error) {
+	for len(b) > 0 {
+		k := min(len(b), p.frame*p.group-p.n)
+		n, err := p.w.Write(b[:k])
+		for i := range n {
+			p.parity[(p.n+i)%p.frame] ^= b[i]
+		}
+		p.n += n
+		total += n
+		if err != nil {
+			return total, err
+		}
+		b = b[k:]
+
+		if p.n == p.frame*p.group {
+			if err := p.flush(); err != nil {
+				return total, err
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// flush writes the parity of a group, partial or not.
+func (p *parityWriter) flush() error {
+	if p.n == 0 {
+		return nil
+	}
+	if _, err := p.w.Write(p.parity); err != nil {
+		return err
+	}
+
+	clear(p.parity)
+	p.n = 0
+	return nil
+}
+
+// parityReader strips the parity chunks from a stream. every group but the
+// last is exactly group+1 full frames, so a short group is the last one and
+// ends with its parity.
+type parityReader struct {
+	r     io.Reader
+	frame int
+
+	buf  []byte
+	data []byte
+	eof  bool
+}
+
+func newParityReader(r io.Reader, frame, group int) *parityReader {
+	return &parityReader{r: r, frame: frame, buf: make([]byte, (group+1)*frame)}
+}
+
+func (p *parityReader) Read(b []byte) (int, error) {
+	for len(p.data) == 0 {
+		if p.eof {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(p.r, p.buf)
+		switch err {
+		case nil:
+			p.data = p.buf[:n-p.frame]
+		case io.EOF, io.ErrUnexpectedEOF:
+			// anything too short to hold parity reads as truncated
+			p.data = p.buf[:max(0, n-p.frame)]
+			p.eof = true
+		default:
+			return 0, err
+		}
+	}
+
+	n := copy(b, p.data)
+	p.data = p.data[n:]
+	return n, nil
+}
+
+// ArchiveReport describes what ValidateArchive or RepairArchive found.
+type ArchiveReport struct {
+	// Chunks is the number of chunks in the stream
+	Chunks int64
+
+	// Damaged lists the chunks that failed to authenticate and were, or
+	// can be, rebuilt from parity
+	Damaged []int64
+
+	// DamagedParity counts parity chunks that didn't match their group
+	DamagedParity int
+}
+
+// ValidateArchive reads a whole stream made with WithArchiveProfile and
+// makes sure it can still be decrypted with key: it uses the archive
+// settings, every chunk authenticates or can be rebuilt from parity, and
+// the stream isn't truncated. the report lists damage that RepairArchive
+// would fix, which should be done while it still can be. WithAAD is the
+// only option that applies.
+func ValidateArchive(r io.Reader, key *[32]byte, opts ...Option) (*ArchiveReport, error) {
+	return scanArchive(nil, r, key, opts)
+}
+
+// RepairArchive copies the archive stream in src to dst, rebuilding any
+// chunk that fails to authenticate from its group's parity and rewriting
+// damaged parity. a group with more than one damaged chunk can't be
+// repaired. for streams with recipients, key is the data key.
+func RepairArchive(dst io.Writer, src io.Reader, key *[32]byte, opts ...Option) (*ArchiveReport, error) {
+	return scanArchive(dst, src, key, opts)
+}
+
+// scanArchive checks every group of an archive stream, writing the
+// repaired stream to dst if it isn't nil.
+func scanArchive(dst io.Writer, src io.Reader, key *[32]byte, opts []Option) (*ArchiveReport, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	h, raw, err := readHeader(src)
+	if err != nil {
+		return nil, err
+	}
+	group, err := h.parityGroup()
+	if err != nil {
+		return nil, err
+	}
+	if h.cipher != Cascade || group == 0 || string(h.fields[fieldDoc]) != archiveDoc {
+		return nil, errors.New("crypt: stream was not made with the archive profile")
+	}
+
+	aead, err := h.cipher.NewAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	aad := newChunkAAD(append(h.aad(), c.aad...))
+	overhead := sequenceSize + aead.NonceSize() + aead.Overhead()
+	frame := overhead + h.chunkSize
+
+	if dst != nil {
+		if _, err := dst.Write(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &ArchiveReport{}
+	buf := make([]byte, (group+1)*frame)
+	plain := make([]byte, frame)
+	final := false
+	for !final {
+		n, err := io.ReadFull(src, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			if err == io.EOF {
+				return report, ErrTruncated
+			}
+			return report, err
+		}
+		if n < frame+overhead {
+			return report, ErrTruncated
+		}
+		data, parity := buf[:n-frame], buf[n-frame:n]
+
+		// open every chunk of the group, remembering which failed
+		var bad []int
+		want := make([]byte, frame)
+		for i := 0; i*frame < len(data); i++ {
+			chunk := data[i*frame : min((i+1)*frame, len(data))]
+			for j, b := range chunk {
+				want[j] ^= b
+			}
+
+			seq := uint64(report.Chunks) + uint64(i)
+			if _, f, err := openChunk(aead, aad, append(plain[:0], chunk...), seq); err != nil {
+				bad = append(bad, i)
+			} else if f {
+				if (i+1)*frame < len(data) {
+					return report, errors.New("crypt: data after the final chunk")
+				}
+				final = true
+			}
+		}
+
+		switch {
+		case len(bad) > 1:
+			return report, errors.New("crypt: chunks " + strconv.FormatInt(report.Chunks+int64(bad[0]), 10) + " and " + strconv.FormatInt(report.Chunks+int64(bad[1]), 10) + " are both damaged, can't repair")
+		case len(bad) == 1:
+			// the XOR of the parity and every other chunk is the bad one
+			i := bad[0]
+			chunk := data[i*frame : min((i+1)*frame, len(data))]
+			for j := range want {
+				want[j] ^= parity[j]
+			}
+			for j := range chunk {
+				want[j] ^= chunk[j]
+			}
+			fixed := want[:len(chunk)]
+
+			seq := uint64(report.Chunks) + uint64(i)
+			_, f, err := openChunk(aead, aad, append(plain[:0], fixed...), seq)
+			if err != nil {
+				return report, errors.New("crypt: chunk " + strconv.FormatUint(seq, 10) + " is damaged and so is its parity, can't repair")
+			}
+			final = final || f
+			copy(chunk, fixed)
+			report.Damaged = append(report.Damaged, int64(seq))
+
+			// want is used up, recompute the parity for writing
+			clear(want)
+			for j, b := range data {
+				want[j%frame] ^= b
+			}
+		default:
+			for j := range want {
+				if want[j] != parity[j] {
+					report.DamagedParity++
+					break
+				}
+			}
+		}
+
+		report.Chunks += int64((len(data) + frame - 1) / frame)
+		if !final && n != len(buf) {
+			return report, ErrTruncated
+		}
+
+		if dst != nil {
+			if _, err := dst.Write(data); err != nil {
+				return report, err
+			}
+			if _, err := dst.Write(want); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	// the final chunk has to be the end of the stream
+	if n, _ := io.ReadFull(src, buf[:1]); n != 0 {
+		return report, errors.New("crypt: data after the final chunk")
+	}
+
+	return report, nil
+}
@@ -0,0 +1,144 @@
+package crypt
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"testing"
+)
+
+// archiveStream returns data encrypted with the archive profile.
+func archiveStream(t *testing.T, key *[32]byte, data []byte, chunkSize int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key, WithArchiveProfile(), WithChunkSize(chunkSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestArchive round trips archive streams of sizes around the parity group
+// through Reader and ReaderAt, and validates them.
+func TestArchive(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 64
+	group := archiveParityGroup * chunkSize
+
+	for _, size := range []int{0, 1, chunkSize, group - 1, group, group + 1, 3*group + 5} {
+		data := randBytes(size)
+		stream := archiveStream(t, key, data, chunkSize)
+
+		r, err := NewReader(bytes.NewReader(stream), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("size %d did not round trip", size)
+		}
+
+		ra, err := NewReaderAt(bytes.NewReader(stream), int64(len(stream)), key)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		got, err = io.ReadAll(io.NewSectionReader(ra, 0, ra.Size()))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("size %d did not round trip through ReaderAt", size)
+		}
+
+		report, err := ValidateArchive(bytes.NewReader(stream), key)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if len(report.Damaged) != 0 || report.DamagedParity != 0 {
+			t.Fatalf("size %d: intact stream reported damaged", size)
+		}
+	}
+
+	if _, err := ValidateArchive(bytes.NewReader(sealStream(t, key, randBytes(10), chunkSize)), key); err == nil {
+		t.Fatal("validated a stream without the archive profile")
+	}
+}
+
+// TestRepairArchive damages one chunk per group and repairs it.
+func TestRepairArchive(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 64
+	data := randBytes(3*archiveParityGroup*chunkSize + 10)
+	stream := archiveStream(t, key, data, chunkSize)
+
+	h, raw, err := readHeader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, _ := h.cipher.NewAEAD(key)
+	frame := sequenceSize + aead.NonceSize() + aead.Overhead() + chunkSize
+	groupSize := (archiveParityGroup + 1) * frame
+
+	chunk := func(i int) int { return len(raw) + i/archiveParityGroup*groupSize + i%archiveParityGroup*frame }
+	parity := len(raw) + groupSize - frame
+
+	damaged := bytes.Clone(stream)
+	damaged[chunk(16)+7] ^= 1
+	damaged[chunk(34)+1] ^= 1
+	damaged[chunk(48)+5] ^= 1 // the final chunk
+	damaged[parity+9] ^= 1
+
+	r, err := NewReader(bytes.NewReader(damaged), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("damaged stream read")
+	}
+
+	report, err := ValidateArchive(bytes.NewReader(damaged), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.Damaged, []int64{16, 34, 48}) || report.DamagedParity != 1 {
+		t.Fatalf("got %+v", report)
+	}
+
+	damaged[chunk(3)+20] ^= 1
+	damaged[parity+9] ^= 1
+	var repaired bytes.Buffer
+	report, err = RepairArchive(&repaired, bytes.NewReader(damaged), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.Damaged, []int64{3, 16, 34, 48}) {
+		t.Fatalf("got %+v", report)
+	}
+	if !bytes.Equal(repaired.Bytes(), stream) {
+		t.Fatal("repaired stream differs from the original")
+	}
+
+	// a damaged chunk can't be rebuilt from damaged parity
+	damaged[parity+9] ^= 1
+	if _, err := ValidateArchive(bytes.NewReader(damaged), key); err == nil {
+		t.Fatal("damaged chunk and parity went unnoticed")
+	}
+
+	// and two damaged chunks in a group is too many
+	damaged[parity+9] ^= 1
+	damaged[chunk(4)] ^= 1
+	if _, err := ValidateArchive(bytes.NewReader(damaged), key); err == nil {
+		t.Fatal("two damaged chunks in a group went unnoticed")
+	}
+}
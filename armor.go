@@ -0,0 +1,229 @@
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+// armor is PEM style text around base64, so ciphertext survives being
+// pasted into email, YAML or a ticket. lines are 64 characters.
+const (
+	armorBegin    = "-----BEGIN CRYPT MESSAGE-----"
+	armorEnd      = "-----END CRYPT MESSAGE-----"
+	armorLineSize = 64
+
+	// armorMaxLine is the longest line ArmorReader accepts, generous so
+	// other line lengths still work
+	armorMaxLine = 4096
+)
+
+// ArmorWriter encodes everything written to it as an armored message. it
+// goes between a Writer and the destination:
+//
+//	a := NewArmorWriter(dst)
+//	w, _ := NewWriter(a, key)
+//	...
+//	w.Close()
+//	a.Close()
+type ArmorWriter struct {
+	w io.Writer
+
+	// buf holds the bytes of a line that isn't complete yet
+	buf     [armorLineSize / 4 * 3]byte
+	n       int
+	started bool
+	err     error
+}
+
+// NewArmorWriter returns an ArmorWriter writing to w, Close must be called
+// to finish the message.
+func NewArmorWriter(w io.Writer) *ArmorWriter {
+	return &ArmorWriter{w: w}
+}
+
+// Write encodes p, whole lines are written as soon as they are complete.
+func (a *ArmorWriter) Write(p []byte) (total int, err error) {
+	if a.err != nil {
+		return 0, a.err
+	}
+	if !a.started {
+		if err := a.write([]byte(armorBegin + "\n")); err != nil {
+			return 0, err
+		}
+		a.started = true
+	}
+
+	for len(p) > 0 {
+		n := copy(a.buf[a.n:], p)
+		a.n += n
+		p = p[n:]
+		total += n
+
+		if a.n == len(a.buf) {
+			if err := a.flush(); err != nil {
+				return total, err
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// Close writes the last line and the end of the message, it does not close
+// the underlying writer. calling Close again does nothing.
+func (a *ArmorWriter) Close() error {
+	if a.err == ErrClosed {
+		return nil
+	}
+	if _, err := a.Write(nil); err != nil {
+		return err
+	}
+
+	if err := a.flush(); err != nil {
+		return err
+	}
+	if err := a.write([]byte(armorEnd + "\n")); err != nil {
+		return err
+	}
+	a.err = ErrClosed
+	return nil
+}
+
+// flush writes whatever is buffered as a line.
+func (a *ArmorWriter) flush() error {
+	if a.n == 0 {
+		return nil
+	}
+
+	line := make([]byte, base64.StdEncoding.EncodedLen(a.n), armorLineSize+1)
+	base64.StdEncoding.Encode(line, a.buf[:a.n])
+	a.n = 0
+	return a.write(append(line, '\n'))
+}
+
+func (a *ArmorWriter) write(b []byte) error {
+	if _, err := a.w.Write(b); err != nil {
+		a.err = err
+		return err
+	}
+	return nil
+}
+
+// ArmorReader decodes an armored message, it goes between the source and a
+// Reader:
+//
+//	r, err := NewReader(NewArmorReader(src), key)
+//
+// whitespace is allowed around the message and lines may end in \r\n,
+// anything else outside it is an error.
+type ArmorReader struct {
+	r *bufio.Reader
+
+	// data is decoded but unread. width is the length of the first line,
+	// a shorter or padded line is the last one before the end line
+	data    []byte
+	width   int
+	last    bool
+	started bool
+	done    bool
+	err     error
+}
+
+// NewArmorReader returns an ArmorReader reading from r.
+func NewArmorReader(r io.Reader) *ArmorReader {
+	return &ArmorReader{r: bufio.NewReaderSize(r, armorMaxLine)}
+}
+
+// Read decodes the message a line at a time.
+func (a *ArmorReader) Read(p []byte) (int, error) {
+	for len(a.data) == 0 {
+		if a.err != nil {
+			return 0, a.err
+		}
+		if err := a.next(); err != nil {
+			a.err = err
+		}
+	}
+
+	n := copy(p, a.data)
+	a.data = a.data[n:]
+	return n, nil
+}
+
+// next decodes the next line into a.data, returning io.EOF after the end
+// of the message.
+func (a *ArmorReader) next() error {
+	if a.done {
+		return io.EOF
+	}
+
+	line, err := a.line()
+	if err != nil {
+		return err
+	}
+
+	if !a.started {
+		// skip leading blank lines
+		for len(line) == 0 {
+			if line, err = a.line(); err != nil {
+				return err
+			}
+		}
+		if string(line) != armorBegin {
+			return errors.New("crypt: not an armored message")
+		}
+		a.started = true
+		return nil
+	}
+
+	if string(line) == armorEnd {
+		return a.end()
+	}
+	if a.last || len(line) == 0 || len(line)%4 != 0 || (a.width != 0 && len(line) > a.width) {
+		return errors.New("crypt: invalid armored message")
+	}
+	a.data = make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Strict().Decode(a.data, line)
+	if err != nil {
+		return errors.New("crypt: invalid armored message")
+	}
+	a.data = a.data[:n]
+	if a.width == 0 {
+		a.width = len(line)
+	}
+	a.last = line[len(line)-1] == '=' || len(line) < a.width
+	return nil
+}
+
+// end makes sure nothing but whitespace follows the message.
+func (a *ArmorReader) end() error {
+	rest, err := io.ReadAll(io.LimitReader(a.r, armorMaxLine))
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(rest)) != 0 {
+		return errors.New("crypt: data after the armored message")
+	}
+
+	a.done = true
+	return io.EOF
+}
+
+// line returns the next line without its line ending or trailing
+// whitespace, a message ending before its end line is truncated.
+func (a *ArmorReader) line() ([]byte, error) {
+	line, err := a.r.ReadSlice('\n')
+	switch {
+	case err == bufio.ErrBufferFull:
+		return nil, errors.New("crypt: armored message has a line that is too long")
+	case err == io.EOF && len(line) == 0:
+		return nil, ErrTruncated
+	case err != nil && err != io.EOF:
+		return nil, err
+	}
+
+	return bytes.TrimRight(line, " \t\r\n"), nil
+}
@@ -0,0 +1,88 @@
+package crypt
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// armorStream encrypts data through an ArmorWriter.
+func armorStream(t *testing.T, key *[32]byte, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	a := NewArmorWriter(&buf)
+	w, err := NewWriter(a, key, WithChunkSize(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// TestArmor round trips streams of different sizes through armor, with
+// line endings and whitespace changed the way email tends to.
+func TestArmor(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+
+	for _, size := range []int{0, 1, 47, 48, 200} {
+		data := randBytes(size)
+		armored := armorStream(t, key, data)
+		if !bytes.HasPrefix(armored, []byte(armorBegin+"\n")) || !bytes.HasSuffix(armored, []byte(armorEnd+"\n")) {
+			t.Fatalf("armor is\n%s", armored)
+		}
+		for _, line := range strings.Split(string(armored), "\n") {
+			if len(line) > armorLineSize && !strings.HasPrefix(line, "-----") {
+				t.Fatalf("line is %d characters", len(line))
+			}
+		}
+
+		mangled := "\n\n" + strings.ReplaceAll(string(armored), "\n", " \r\n") + "\n"
+		for _, in := range []string{string(armored), mangled} {
+			r, err := NewReader(NewArmorReader(iotest.OneByteReader(strings.NewReader(in))), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatal("data did not round trip")
+			}
+		}
+	}
+}
+
+// TestArmorInvalid makes sure damaged armor is rejected.
+func TestArmorInvalid(t *testing.T) {
+	t.Parallel()
+	armored := string(armorStream(t, randKey(), randBytes(100)))
+	lines := strings.Split(armored, "\n")
+
+	bad := map[string]string{
+		"no begin":    strings.Join(lines[1:], "\n"),
+		"no end":      strings.Join(lines[:len(lines)-2], "\n"),
+		"text before": "hi\n" + armored,
+		"text after":  armored + "hi\n",
+		"bad base64":  strings.Replace(armored, lines[1][:4], "!!!!", 1),
+		"after last":  strings.Join(append(lines[:len(lines)-2], lines[1], armorEnd), "\n"),
+		"after short": armorBegin + "\n" + lines[1] + "\nAAAA\n" + lines[2] + "\n" + armorEnd,
+		"longer line": armorBegin + "\nAAAA\n" + lines[1] + "\n" + armorEnd,
+		"long line":   armorBegin + "\n" + strings.Repeat("AAAA", armorMaxLine) + "\n" + armorEnd,
+	}
+	for name, in := range bad {
+		if _, err := io.ReadAll(NewArmorReader(strings.NewReader(in))); err == nil {
+			t.Fatalf("%s: accepted", name)
+		}
+	}
+}
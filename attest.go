@@ -0,0 +1,209 @@
+package crypt
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// attestations are in-toto statements in a DSSE envelope, the format
+// sigstore/cosign and other supply chain tooling verify.
+const (
+	dssePayloadType     = "application/vnd.in-toto+json"
+	statementType       = "https://in-toto.io/Statement/v1"
+	encryptionPredicate = "https://github.com/UlisseMini/crypt/attestation/encryption/v1"
+)
+
+// Attestation describes an encrypted artifact, it is what Attest signs and
+// VerifyAttestation returns.
+type Attestation struct {
+	// Name is the artifact's name, e.g. its file name
+	Name string
+
+	// Digest is the SHA-256 of the ciphertext
+	Digest [32]byte
+
+	// Cipher and ChunkSize are the parameters from the stream header
+	Cipher    Cipher
+	ChunkSize int
+
+	// KeyFingerprint identifies the key the artifact was encrypted with
+	// without revealing it
+	KeyFingerprint string
+}
+
+// statement is an in-toto statement with the predicate for encrypted
+// artifacts.
+type statement struct {
+	Type          string    `json:"_type"`
+	Subject       []subject `json:"subject"`
+	PredicateType string    `json:"predicateType"`
+	Predicate     predicate `json:"predicate"`
+}
+
+type subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type predicate struct {
+	Cipher         string `json:"cipher"`
+	ChunkSize      int    `json:"chunkSize"`
+	KeyFingerprint string `json:"keyFingerprint"`
+}
+
+// envelope is a DSSE envelope.
+type envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     string      `json:"payload"`
+	Signatures  []signature `json:"signatures"`
+}
+
+type signature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// Attest reads a stream made by Writer from ciphertext and returns a signed
+// in-toto attestation of its digest, encryption parameters and the
+// fingerprint of key, wrapped in a DSSE envelope. key must be the one the
+// stream was encrypted with, it is only used for the fingerprint.
+func Attest(name string, ciphertext io.Reader, key *[32]byte, signer ed25519.PrivateKey) ([]byte, error) {
+	h := sha256.New()
+	hdr, _, err := readHeader(io.TeeReader(ciphertext, h))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(h, ciphertext); err != nil {
+		return nil, err
+	}
+
+	fp, err := fingerprint(key)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(statement{
+		Type: statementType,
+		Subject: []subject{{
+			Name:   name,
+			Digest: map[string]string{"sha256": hex.EncodeToString(h.Sum(nil))},
+		}},
+		PredicateType: encryptionPredicate,
+		Predicate: predicate{
+			Cipher:         hdr.cipher.String(),
+			ChunkSize:      hdr.chunkSize,
+			KeyFingerprint: fp,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(envelope{
+		PayloadType: dssePayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures: []signature{{
+			KeyID: keyID(signer.Public().(ed25519.PublicKey)),
+			Sig:   base64.StdEncoding.EncodeToString(ed25519.Sign(signer, pae(dssePayloadType, payload))),
+		}},
+	})
+}
+
+// VerifyAttestation checks an envelope made by Attest was signed by pub and
+// returns what it attests. it is up to the caller to compare the digest
+// with the artifact they have.
+func VerifyAttestation(env []byte, pub ed25519.PublicKey) (*Attestation, error) {
+	var e envelope
+	if err := json.Unmarshal(env, &e); err != nil {
+		return nil, err
+	}
+	if e.PayloadType != dssePayloadType {
+		return nil, errors.New("crypt: attestation has the wrong payload type")
+	}
+	payload, err := base64.StdEncoding.DecodeString(e.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	verified := false
+	for _, sig := range e.Signatures {
+		b, err := base64.StdEncoding.DecodeString(sig.Sig)
+		if err == nil && ed25519.Verify(pub, pae(e.PayloadType, payload), b) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("crypt: attestation is not signed by the key")
+	}
+
+	var s statement
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return nil, err
+	}
+	if s.Type != statementType || s.PredicateType != encryptionPredicate || len(s.Subject) != 1 {
+		return nil, errors.New("crypt: attestation is not an encryption attestation")
+	}
+
+	a := &Attestation{
+		Name:           s.Subject[0].Name,
+		ChunkSize:      s.Predicate.ChunkSize,
+		KeyFingerprint: s.Predicate.KeyFingerprint,
+	}
+	digest, err := hex.DecodeString(s.Subject[0].Digest["sha256"])
+	if err != nil || len(digest) != len(a.Digest) {
+		return nil, errors.New("crypt: attestation has no valid sha256 digest")
+	}
+	copy(a.Digest[:], digest)
+
+	for _, c := range ciphers {
+		if c.String() == s.Predicate.Cipher {
+			a.Cipher = c
+		}
+	}
+	if a.Cipher == 0 {
+		return nil, errors.New("crypt: attestation has an unknown cipher")
+	}
+
+	return a, nil
+}
+
+// pae is the DSSE pre-authentication encoding, what actually gets signed.
+func pae(payloadType string, payload []byte) []byte {
+	b := []byte("DSSEv1 " + strconv.Itoa(len(payloadType)) + " " + payloadType + " " + strconv.Itoa(len(payload)) + " ")
+	return append(b, payload...)
+}
+
+// keyID identifies a signing key in an envelope, it is only a hint for
+// verifiers with several keys.
+func keyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// fingerprint returns a short identifier for key that reveals nothing about
+// it, see Key.Fingerprint.
+func fingerprint(key *[32]byte) (string, error) {
+	fp, err := rawFingerprint(key)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(fp), nil
+}
+
+// rawFingerprint is fingerprint before hex encoding.
+func rawFingerprint(key *[32]byte) ([]byte, error) {
+	fp, err := deriveKey(key, fingerprintInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return fp[:16], nil
+}
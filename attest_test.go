@@ -0,0 +1,61 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+)
+
+// TestAttest makes sure an attestation verifies with the signer's key only,
+// and records the right digest and parameters.
+func TestAttest(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := sealStream(t, key, randBytes(100), 64)
+
+	env, err := Attest("release.tar.enc", bytes.NewReader(stream), key, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := VerifyAttestation(env, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, _ := fingerprint(key)
+	if a.Name != "release.tar.enc" || a.Digest != sha256.Sum256(stream) ||
+		a.Cipher != DefaultCipher || a.ChunkSize != 64 || a.KeyFingerprint != fp {
+		t.Fatalf("attestation does not match: %+v", a)
+	}
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	if _, err := VerifyAttestation(env, other); err == nil {
+		t.Fatal("verified with the wrong key")
+	}
+
+	// change the payload, keeping the signature
+	var e envelope
+	if err := json.Unmarshal(env, &e); err != nil {
+		t.Fatal(err)
+	}
+	e.Payload = e.Payload[:len(e.Payload)-4] + "AAA="
+	tampered, _ := json.Marshal(e)
+	if _, err := VerifyAttestation(tampered, pub); err == nil {
+		t.Fatal("tampered payload was accepted")
+	}
+}
+
+// TestPAE checks the pre-authentication encoding against the DSSE spec.
+func TestPAE(t *testing.T) {
+	t.Parallel()
+	got := pae("http://example.com/HelloWorld", []byte("hello world"))
+	if want := "DSSEv1 29 http://example.com/HelloWorld 11 hello world"; string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
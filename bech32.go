@@ -0,0 +1,124 @@
+package crypt
+
+import (
+	"errors"
+	"strings"
+)
+
+// bech32 (BIP 173) is how age encodes keys as text. unlike BIP 173 there is
+// no length limit, age keys don't fit in 90 characters.
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i, g := range bech32Generator {
+			if (top>>i)&1 == 1 {
+				chk ^= g
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	out := make([]byte, 0, len(hrp)*2+1)
+	for i := range len(hrp) {
+		out = append(out, hrp[i]>>5)
+	}
+	out = append(out, 0)
+	for i := range len(hrp) {
+		out = append(out, hrp[i]&31)
+	}
+	return out
+}
+
+// convertBits regroups data from frombits to tobits wide groups.
+func convertBits(data []byte, frombits, tobits uint, pad bool) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	maxv := byte(1<<tobits - 1)
+	var out []byte
+	for _, b := range data {
+		if b>>frombits != 0 {
+			return nil, errors.New("crypt: invalid bech32 data")
+		}
+		acc = acc<<frombits | uint32(b)
+		bits += frombits
+		for bits >= tobits {
+			bits -= tobits
+			out = append(out, byte(acc>>bits)&maxv)
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(tobits-bits))&maxv)
+		}
+	} else if bits >= frombits || byte(acc<<(tobits-bits))&maxv != 0 {
+		return nil, errors.New("crypt: invalid bech32 padding")
+	}
+	return out, nil
+}
+
+// bech32Encode encodes data with the human readable part hrp, in lower
+// case.
+func bech32Encode(hrp string, data []byte) string {
+	hrp = strings.ToLower(hrp)
+	values, _ := convertBits(data, 8, 5, true)
+
+	check := append(bech32HRPExpand(hrp), values...)
+	check = append(check, 0, 0, 0, 0, 0, 0)
+	mod := bech32Polymod(check) ^ 1
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, v := range values {
+		sb.WriteByte(bech32Charset[v])
+	}
+	for i := range 6 {
+		sb.WriteByte(bech32Charset[(mod>>(5*(5-i)))&31])
+	}
+	return sb.String()
+}
+
+// bech32Decode decodes s, returning its human readable part in lower case.
+func bech32Decode(s string) (hrp string, data []byte, err error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("crypt: mixed case bech32 string")
+	}
+	s = strings.ToLower(s)
+
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("crypt: invalid bech32 separator")
+	}
+	hrp = s[:pos]
+	for i := range len(hrp) {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, errors.New("crypt: invalid bech32 character")
+		}
+	}
+
+	values := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, errors.New("crypt: invalid bech32 character")
+		}
+		values = append(values, byte(v))
+	}
+
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, errors.New("crypt: invalid bech32 checksum")
+	}
+
+	data, err = convertBits(values[:len(values)-6], 5, 8, false)
+	return hrp, data, err
+}
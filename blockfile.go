@@ -0,0 +1,320 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// block files start with blockMagic, a version, the cipher, the sector
+// size and a random file ID. each sector after it is nonce | ciphertext |
+// tag, sealed with the header and the sector number as additional data
+// under a key derived from the key given and the file ID.
+const (
+	blockMagic     = "CRYPTBLK"
+	blockVersion   = 1
+	blockIDSize    = 16
+	blockHeaderLen = len(blockMagic) + 1 + 1 + 4 + blockIDSize
+
+	// DefaultSectorSize is the sector size of a BlockFile when none is
+	// given, the page size of most file systems and databases.
+	DefaultSectorSize = 4096
+
+	// the key of each block file is derived from the key given
+	blockFileInfo = "crypt block file v1\x00"
+)
+
+// BlockStorage is what a BlockFile keeps its sectors in, such as an
+// *os.File.
+type BlockStorage interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// BlockFile is encrypted storage with random reads and writes, for virtual
+// disks, databases and the like. the plaintext is split into fixed size
+// sectors, each sealed on its own so any of them can be read or
+// overwritten without touching the others. a sector gets a fresh random
+// nonce every time it is written, a nonce derived from the sector number
+// alone would repeat when a sector is overwritten. the sector number and
+// the file header are bound into every sector instead, so sectors can't be
+// moved around or between files.
+//
+// the size is always a whole number of sectors, a write past the end fills
+// the gap with zeros. sectors removed from the end, or an old copy of a
+// sector put back in place, can not be detected: there is nothing to check
+// them against without a tree of hashes over the whole file.
+//
+// a BlockFile is safe for concurrent use.
+type BlockFile struct {
+	s          BlockStorage
+	aead       cipher.AEAD
+	header     []byte
+	sectorSize int
+
+	mu      sync.RWMutex
+	sectors int64
+	random  io.Reader
+}
+
+// CreateBlockFile writes a new, empty block file to s with sectors of
+// sectorSize bytes, 0 means DefaultSectorSize. WithCipher picks the
+// cipher, XChaCha20Poly1305 or AES256GCMSIV are good choices for files
+// whose sectors are rewritten many times.
+func CreateBlockFile(s BlockStorage, sectorSize int, key *[32]byte, opts ...Option) (*BlockFile, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.policy.check(c.cipher, headerVersion); err != nil {
+		return nil, err
+	}
+	if sectorSize == 0 {
+		sectorSize = DefaultSectorSize
+	}
+	if sectorSize < 0 || sectorSize > maxChunkSize {
+		return nil, errors.New("crypt: sector size must be between 1 byte and 64 MiB")
+	}
+
+	h := make([]byte, 0, blockHeaderLen)
+	h = append(h, blockMagic...)
+	h = append(h, blockVersion, byte(c.cipher))
+	h = binary.BigEndian.AppendUint32(h, uint32(sectorSize))
+	id, err := newNonce(c.random, blockIDSize)
+	if err != nil {
+		return nil, err
+	}
+	h = append(h, id...)
+
+	if _, err := s.WriteAt(h, 0); err != nil {
+		return nil, err
+	}
+	return newBlockFile(s, h, key, c)
+}
+
+// OpenBlockFile opens the block file in s, which is size bytes long.
+func OpenBlockFile(s BlockStorage, size int64, key *[32]byte, opts ...Option) (*BlockFile, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	h := make([]byte, blockHeaderLen)
+	if size < int64(len(h)) {
+		return nil, ErrTruncated
+	}
+	if _, err := s.ReadAt(h, 0); err != nil {
+		return nil, err
+	}
+	if string(h[:len(blockMagic)]) != blockMagic {
+		return nil, errBadHeader("not a block file")
+	}
+	if v := h[len(blockMagic)]; v != blockVersion {
+		return nil, errBadHeader("unsupported block file version")
+	}
+	if err := c.policy.check(Cipher(h[len(blockMagic)+1]), headerVersion); err != nil {
+		return nil, err
+	}
+
+	b, err := newBlockFile(s, h, key, c)
+	if err != nil {
+		return nil, err
+	}
+	body := size - int64(len(h))
+	if body%int64(b.frame()) != 0 {
+		return nil, ErrTruncated
+	}
+	b.sectors = body / int64(b.frame())
+	return b, nil
+}
+
+func newBlockFile(s BlockStorage, h []byte, key *[32]byte, c *config) (*BlockFile, error) {
+	sectorSize := int(binary.BigEndian.Uint32(h[len(blockMagic)+2:]))
+	if sectorSize == 0 || sectorSize > maxChunkSize {
+		return nil, errBadHeader("invalid sector size")
+	}
+
+	fileKey, err := deriveKey(key, blockFileInfo+string(h[len(h)-blockIDSize:]))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := Cipher(h[len(blockMagic)+1]).NewAEAD(fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlockFile{s: s, aead: aead, header: bytes.Clone(h), sectorSize: sectorSize, random: c.random}, nil
+}
+
+// Size returns the size of the plaintext, a whole number of sectors.
+func (b *BlockFile) Size() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.sectors * int64(b.sectorSize)
+}
+
+// SectorSize returns the size of a sector.
+func (b *BlockFile) SectorSize() int {
+	return b.sectorSize
+}
+
+// ReadAt decrypts len(p) bytes starting at off, the sectors holding them
+// are authenticated as a whole.
+func (b *BlockFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("crypt: negative offset")
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	buf := make([]byte, b.frame())
+	for n < len(p) {
+		i := off / int64(b.sectorSize)
+		if i >= b.sectors {
+			return n, io.EOF
+		}
+		plain, err := b.readSector(buf, i)
+		if err != nil {
+			return n, err
+		}
+
+		c := copy(p[n:], plain[off-i*int64(b.sectorSize):])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
+
+// WriteAt encrypts p to the sectors starting at off. sectors only partly
+// covered by p are read and rewritten, writing whole aligned sectors
+// avoids that. a sector is written with a single WriteAt on the storage,
+// whether that is atomic on a crash is up to the storage.
+func (b *BlockFile) WriteAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("crypt: negative offset")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ss := int64(b.sectorSize)
+	buf := make([]byte, b.frame())
+	plain := make([]byte, b.sectorSize)
+
+	// fill the gap between the end and off
+	clear(plain)
+	for b.sectors < off/ss {
+		if err := b.writeSector(buf, b.sectors, plain); err != nil {
+			return 0, err
+		}
+		b.sectors++
+	}
+
+	for n < len(p) {
+		i := off / ss
+		start := int(off - i*ss)
+		k := min(len(p)-n, b.sectorSize-start)
+
+		if k < b.sectorSize && i < b.sectors {
+			old, err := b.readSector(buf, i)
+			if err != nil {
+				return n, err
+			}
+			copy(plain, old)
+		} else {
+			clear(plain)
+		}
+		copy(plain[start:], p[n:n+k])
+
+		if err := b.writeSector(buf, i, plain); err != nil {
+			return n, err
+		}
+		if i >= b.sectors {
+			b.sectors = i + 1
+		}
+		n += k
+		off += int64(k)
+	}
+	return n, nil
+}
+
+// Truncate changes the size of b to size rounded up to a whole number of
+// sectors. growing it adds zero sectors, shrinking it cuts the storage,
+// which needs a Truncate method like the one of *os.File. a sector that is
+// cut off and grown back holds zeros, the data that was in it is gone.
+func (b *BlockFile) Truncate(size int64) error {
+	if size < 0 {
+		return errors.New("crypt: negative size")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sectors := (size + int64(b.sectorSize) - 1) / int64(b.sectorSize)
+	if sectors > b.sectors {
+		buf := make([]byte, b.frame())
+		plain := make([]byte, b.sectorSize)
+		for b.sectors < sectors {
+			if err := b.writeSector(buf, b.sectors, plain); err != nil {
+				return err
+			}
+			b.sectors++
+		}
+		return nil
+	}
+
+	t, ok := b.s.(interface{ Truncate(int64) error })
+	if !ok {
+		return errors.New("crypt: block storage can't be truncated")
+	}
+	if err := t.Truncate(b.offset(sectors)); err != nil {
+		return err
+	}
+	b.sectors = sectors
+	return nil
+}
+
+// frame is the size of a sealed sector.
+func (b *BlockFile) frame() int {
+	return b.aead.NonceSize() + b.sectorSize + b.aead.Overhead()
+}
+
+// offset is where sector i starts in the storage.
+func (b *BlockFile) offset(i int64) int64 {
+	return int64(len(b.header)) + i*int64(b.frame())
+}
+
+// sectorAAD is the additional data of sector i.
+func (b *BlockFile) sectorAAD(i int64) []byte {
+	return binary.BigEndian.AppendUint64(bytes.Clone(b.header), uint64(i))
+}
+
+// readSector reads and opens sector i using buf, returning the plaintext.
+func (b *BlockFile) readSector(buf []byte, i int64) ([]byte, error) {
+	if n, err := b.s.ReadAt(buf, b.offset(i)); n < len(buf) {
+		if err == nil || err == io.EOF {
+			err = ErrTruncated
+		}
+		return nil, &ChunkError{Chunk: i, Offset: b.offset(i), Err: err}
+	}
+
+	ns := b.aead.NonceSize()
+	plain, err := b.aead.Open(buf[ns:ns], buf[:ns], buf[ns:], b.sectorAAD(i))
+	if err != nil {
+		return nil, &ChunkError{Chunk: i, Offset: b.offset(i), Err: ErrAuthenticationFailed}
+	}
+	return plain, nil
+}
+
+// writeSector seals plain as sector i using buf and writes it.
+func (b *BlockFile) writeSector(buf []byte, i int64, plain []byte) error {
+	ns := b.aead.NonceSize()
+	if _, err := io.ReadFull(b.random, buf[:ns]); err != nil {
+		return errors.New("crypt: reading randomness failed: " + err.Error())
+	}
+	b.aead.Seal(buf[ns:ns], buf[:ns], plain, b.sectorAAD(i))
+
+	_, err := b.s.WriteAt(buf, b.offset(i))
+	return err
+}
@@ -0,0 +1,183 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlockFile(t *testing.T) {
+	key := randKey()
+	f, err := os.Create(filepath.Join(t.TempDir(), "disk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	b, err := CreateBlockFile(f, 512, key, WithCipher(XChaCha20Poly1305))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Size() != 0 {
+		t.Fatalf("new file has size %d", b.Size())
+	}
+
+	// mirror every write in a plain buffer and compare
+	want := make([]byte, 20*512)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for range 200 {
+		off := rng.IntN(len(want))
+		p := randBytes(rng.IntN(min(2000, len(want)-off)) + 1)
+		if n, err := b.WriteAt(p, int64(off)); err != nil || n != len(p) {
+			t.Fatalf("WriteAt(%d bytes, %d) = %d, %v", len(p), off, n, err)
+		}
+		copy(want[off:], p)
+	}
+	size := b.Size()
+	if size%512 != 0 {
+		t.Fatalf("size %d isn't a whole number of sectors", size)
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = OpenBlockFile(f, st.Size(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Size() != size {
+		t.Fatalf("reopened size %d, want %d", b.Size(), size)
+	}
+	got := make([]byte, size)
+	if _, err := b.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want[:size]) {
+		t.Fatal("contents differ")
+	}
+	if n, err := b.ReadAt(make([]byte, 10), size-5); n != 5 || err != io.EOF {
+		t.Fatalf("read over the end = %d, %v", n, err)
+	}
+
+	// a write past the end fills the gap with zeros
+	if _, err := b.WriteAt([]byte("end"), size+1000); err != nil {
+		t.Fatal(err)
+	}
+	gap := make([]byte, 1000)
+	if _, err := b.ReadAt(gap, size); err != nil || !bytes.Equal(gap, make([]byte, 1000)) {
+		t.Fatalf("gap not zero: %v", err)
+	}
+}
+
+func TestBlockFileTamper(t *testing.T) {
+	key := randKey()
+	s := &memStorage{}
+	b, err := CreateBlockFile(s, 0, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.WriteAt(randBytes(3*DefaultSectorSize), 0); err != nil {
+		t.Fatal(err)
+	}
+	frame := int64(b.frame())
+
+	// swapping two sectors fails to authenticate
+	swapped := bytes.Clone(s.b)
+	s0, s1 := swapped[blockHeaderLen:][:frame], swapped[blockHeaderLen:][frame:2*frame]
+	tmp := bytes.Clone(s0)
+	copy(s0, s1)
+	copy(s1, tmp)
+	b, err = OpenBlockFile(&memStorage{b: swapped}, int64(len(swapped)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ce *ChunkError
+	_, err = b.ReadAt(make([]byte, 10), 0)
+	if !errors.As(err, &ce) || ce.Chunk != 0 || !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("swapped sectors: %v", err)
+	}
+
+	// so does a sector copied from another file
+	other, err := CreateBlockFile(&memStorage{}, 0, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.WriteAt(make([]byte, DefaultSectorSize), 0)
+	mixed := bytes.Clone(s.b)
+	copy(mixed[blockHeaderLen:], other.s.(*memStorage).b[blockHeaderLen:])
+	b, _ = OpenBlockFile(&memStorage{b: mixed}, int64(len(mixed)), key)
+	if _, err := b.ReadAt(make([]byte, 10), 0); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("sector from another file: %v", err)
+	}
+
+	if _, err := OpenBlockFile(s, int64(len(s.b))-1, key); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("partial sector: %v", err)
+	}
+}
+
+// memStorage is a BlockStorage in memory.
+type memStorage struct {
+	b []byte
+}
+
+func (m *memStorage) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m.b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *memStorage) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(m.b) {
+		m.b = append(m.b, make([]byte, end-len(m.b))...)
+	}
+	return copy(m.b[off:], p), nil
+}
+
+func TestBlockFileTruncate(t *testing.T) {
+	key := randKey()
+	f, err := os.Create(filepath.Join(t.TempDir(), "disk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := CreateBlockFile(f, 512, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randBytes(5 * 512)
+	b.WriteAt(data, 0)
+
+	if err := b.Truncate(1000); err != nil || b.Size() != 1024 {
+		t.Fatalf("shrunk to %d: %v", b.Size(), err)
+	}
+	st, _ := f.Stat()
+	b, err = OpenBlockFile(f, st.Size(), key)
+	if err != nil || b.Size() != 1024 {
+		t.Fatalf("reopened at %d: %v", b.Size(), err)
+	}
+
+	if err := b.Truncate(2000); err != nil || b.Size() != 2048 {
+		t.Fatalf("grown to %d: %v", b.Size(), err)
+	}
+	got := make([]byte, 2048)
+	if _, err := b.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got[:1024], data[:1024]) || !bytes.Equal(got[1024:], make([]byte, 1024)) {
+		t.Fatal("contents after growing differ")
+	}
+
+	if err := (&BlockFile{s: &memStorage{}}).Truncate(-1); err == nil {
+		t.Fatal("negative size accepted")
+	}
+}
@@ -0,0 +1,116 @@
+package crypt
+
+import (
+	"crypto/ecdh"
+	"errors"
+)
+
+// PublicKey is an X25519 public key, anyone who has it can seal data that
+// only the holder of the matching PrivateKey can open.
+type PublicKey [32]byte
+
+// PrivateKey is an X25519 private key.
+type PrivateKey [32]byte
+
+// GenerateKeyPair returns a new X25519 key pair.
+func GenerateKeyPair() (*PublicKey, *PrivateKey, error) {
+	k, err := ecdh.X25519().GenerateKey(randReader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return (*PublicKey)(k.PublicKey().Bytes()), (*PrivateKey)(k.Bytes()), nil
+}
+
+// Public returns the public key for k.
+func (k *PrivateKey) Public() *PublicKey {
+	priv, err := ecdh.X25519().NewPrivateKey(k[:])
+	if err != nil {
+		panic(err) // only happens for the wrong length
+	}
+
+	return (*PublicKey)(priv.PublicKey().Bytes())
+}
+
+// SealToPublicKey encrypts plaintext so only the holder of the private key
+// for pub can decrypt it, without sharing a symmetric key. an ephemeral key
+// pair is made for every call and the key is derived from X25519 between it
+// and pub. output takes the form ephemeral public key|ciphertext where
+// ciphertext is what Encrypt produces.
+func SealToPublicKey(plaintext []byte, pub *PublicKey, opts ...Option) ([]byte, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.policy.check(c.cipher, 0); err != nil {
+		return nil, err
+	}
+
+	eph, err := ecdh.X25519().GenerateKey(c.random)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := boxKey(eph, pub[:], eph.PublicKey().Bytes(), pub)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := encrypt(c.random, c.cipher, plaintext, key, c.aad)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(eph.PublicKey().Bytes(), ciphertext...), nil
+}
+
+// OpenWithPrivateKey decrypts data made by SealToPublicKey for the public
+// key of priv.
+func OpenWithPrivateKey(ciphertext []byte, priv *PrivateKey, opts ...Option) ([]byte, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < 33 {
+		return nil, errors.New("crypt: sealed box is too short")
+	}
+	if err := c.policy.check(Cipher(ciphertext[32]), 0); err != nil {
+		return nil, err
+	}
+
+	key, err := openBoxKey(ciphertext[:32], priv)
+	if err != nil {
+		return nil, err
+	}
+
+	return decrypt(ciphertext[32:], key, c.aad)
+}
+
+// openBoxKey derives the key a box was sealed with from its ephemeral
+// public key and the recipient's private key.
+func openBoxKey(ephPub []byte, priv *PrivateKey) (*[32]byte, error) {
+	k, err := ecdh.X25519().NewPrivateKey(priv[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return boxKey(k, ephPub, ephPub, priv.Public())
+}
+
+// boxKey derives the key for a box from X25519 between priv and peer, one
+// of them the ephemeral key and the other the recipient's. both public keys
+// are bound into the key.
+func boxKey(priv *ecdh.PrivateKey, peer, ephPub []byte, recipient *PublicKey) (*[32]byte, error) {
+	pk, err := ecdh.X25519().NewPublicKey(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	// fails for low order points, which would give a known key
+	shared, err := priv.ECDH(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	return deriveKey((*[32]byte)(shared), sealedBoxInfo+string(ephPub)+string(recipient[:]))
+}
@@ -0,0 +1,49 @@
+package crypt
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestSealedBox makes sure only the recipient can open a box, and that the
+// ephemeral key can't be changed.
+func TestSealedBox(t *testing.T) {
+	t.Parallel()
+	pub, priv, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *priv.Public() != *pub {
+		t.Fatal("Public does not match the generated public key")
+	}
+	data := randBytes(smallSize)
+
+	box, err := SealToPublicKey(data, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := OpenWithPrivateKey(box, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("[%X] != [%X]", got, data)
+	}
+
+	_, other, _ := GenerateKeyPair()
+	if _, err := OpenWithPrivateKey(box, other); err == nil {
+		t.Fatal("opened with the wrong private key")
+	}
+
+	tampered := bytes.Clone(box)
+	tampered[0] ^= 1
+	if _, err := OpenWithPrivateKey(tampered, priv); err == nil {
+		t.Fatal("changed ephemeral key was accepted")
+	}
+
+	// a low order point as the ephemeral key must be refused
+	zero := append(make([]byte, 32), box[32:]...)
+	if _, err := OpenWithPrivateKey(zero, priv); err == nil {
+		t.Fatal("low order ephemeral key was accepted")
+	}
+}
@@ -0,0 +1,183 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"strconv"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Cipher identifies the AEAD used to encrypt data, it is stored in front of
+// ciphertexts so Decrypt can pick the right one.
+type Cipher byte
+
+const (
+	// AES256GCM is AES-256 in Galois/Counter Mode, it is the default and the
+	// fastest choice on CPUs with AES instructions.
+	AES256GCM Cipher = 1
+
+	// ChaCha20Poly1305 is ChaCha20-Poly1305 as defined in RFC 8439, it is
+	// faster than AES-GCM on CPUs without AES instructions such as many ARM
+	// and mobile chips.
+	ChaCha20Poly1305 Cipher = 2
+
+	// AES256GCMSIV is AES-256-GCM-SIV as defined in RFC 8452, it is
+	// nonce-misuse resistant: a repeated nonce only reveals that two
+	// messages were equal. use it when a lot of data is encrypted under one
+	// key or the source of random nonces can't be trusted.
+	AES256GCMSIV Cipher = 3
+
+	// XChaCha20Poly1305 is ChaCha20-Poly1305 with a 192-bit nonce, random
+	// nonces are safe to use for practically any number of messages under a
+	// single key, unlike the 96-bit nonces of the other ciphers.
+	XChaCha20Poly1305 Cipher = 4
+
+	// Cascade is AES-256-GCM inside XChaCha20-Poly1305 with independent
+	// keys derived from the one given, so data stays safe if either cipher
+	// is broken. it has a 24 byte nonce and a 32 byte tag, both tags must
+	// check out. it is slower than either alone and meant for data that has
+	// to stay secret for decades, see WithArchiveProfile.
+	Cascade Cipher = 5
+
+	// AES256CTRHMAC is AES-256 in counter mode with HMAC-SHA256 over the
+	// result, encrypt-then-MAC, for compliance regimes and interop targets
+	// that don't accept GCM. the encryption and MAC keys are derived from
+	// the one given with HKDF. it has a 16 byte nonce and a 32 byte tag
+	// and is slower than GCM.
+	AES256CTRHMAC Cipher = 6
+)
+
+// ciphers lists every supported cipher.
+var ciphers = []Cipher{AES256GCM, ChaCha20Poly1305, AES256GCMSIV, XChaCha20Poly1305, Cascade, AES256CTRHMAC}
+
+// tagSize is the size of the authentication tag of every cipher but
+// Cascade, which has two, and AES256CTRHMAC.
+const tagSize = 16
+
+// DefaultCipher is the cipher used when none is given.
+const DefaultCipher = AES256GCM
+
+// String returns the name of the cipher.
+func (c Cipher) String() string {
+	switch c {
+	case AES256GCM:
+		return "AES-256-GCM"
+	case ChaCha20Poly1305:
+		return "ChaCha20-Poly1305"
+	case AES256GCMSIV:
+		return "AES-256-GCM-SIV"
+	case XChaCha20Poly1305:
+		return "XChaCha20-Poly1305"
+	case Cascade:
+		return "AES-256-GCM+XChaCha20-Poly1305"
+	case AES256CTRHMAC:
+		return "AES-256-CTR+HMAC-SHA256"
+	}
+
+	return "Cipher(" + strconv.Itoa(int(c)) + ")"
+}
+
+// NewAEAD returns the AEAD for c using key, for packages building their own
+// formats on top of the ciphers supported here.
+func (c Cipher) NewAEAD(key *[32]byte) (cipher.AEAD, error) {
+	switch c {
+	case AES256GCM:
+		return newGCM(key)
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New(key[:])
+	case AES256GCMSIV:
+		return newGCMSIV(key)
+	case XChaCha20Poly1305:
+		return chacha20poly1305.NewX(key[:])
+	case Cascade:
+		return newCascade(key)
+	case AES256CTRHMAC:
+		return newCTRHMAC(key)
+	}
+
+	return nil, errors.New("unknown cipher " + c.String())
+}
+
+// nonceSize returns the nonce size of c without needing a key.
+func (c Cipher) nonceSize() int {
+	if c == XChaCha20Poly1305 || c == Cascade {
+		return chacha20poly1305.NonceSizeX
+	}
+	if c == AES256CTRHMAC {
+		return aes.BlockSize
+	}
+
+	return 12
+}
+
+// tagSize returns the size of the authentication tag of c.
+func (c Cipher) tagSize() int {
+	if c == Cascade || c == AES256CTRHMAC {
+		return 2 * tagSize
+	}
+
+	return tagSize
+}
+
+// newGCM skips allocating a cipher.Block and just returns the AEAD
+func newGCM(key *[32]byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	return gcm, err
+}
+
+// cascade seals with inner and then outer, inner uses the first 12 bytes of
+// the nonce. the keys are independent so sharing nonce bytes is fine.
+type cascade struct {
+	inner, outer cipher.AEAD
+}
+
+func newCascade(key *[32]byte) (cipher.AEAD, error) {
+	innerKey, err := deriveKey(key, cascadeInnerInfo)
+	if err != nil {
+		return nil, err
+	}
+	outerKey, err := deriveKey(key, cascadeOuterInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	inner, err := newGCM(innerKey)
+	if err != nil {
+		return nil, err
+	}
+	outer, err := chacha20poly1305.NewX(outerKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &cascade{inner: inner, outer: outer}, nil
+}
+
+func (c *cascade) NonceSize() int {
+	return c.outer.NonceSize()
+}
+
+func (c *cascade) Overhead() int {
+	return c.inner.Overhead() + c.outer.Overhead()
+}
+
+func (c *cascade) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	inner := c.inner.Seal(nil, nonce[:c.inner.NonceSize()], plaintext, additionalData)
+	return c.outer.Seal(dst, nonce, inner, additionalData)
+}
+
+func (c *cascade) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	inner, err := c.outer.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.inner.Open(dst, nonce[:c.inner.NonceSize()], inner, additionalData)
+}
@@ -0,0 +1,44 @@
+package crypt
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestCiphers makes sure every cipher round trips through Encrypt / Decrypt
+// and that Decrypt picks the cipher from the ciphertext.
+func TestCiphers(t *testing.T) {
+	t.Parallel()
+	for _, c := range ciphers {
+		key := randKey()
+		data := randBytes(smallSize)
+
+		encrypted, err := Encrypt(data, key, WithCipher(c))
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		if Cipher(encrypted[0]) != c {
+			t.Fatalf("%s: ciphertext records %s", c, Cipher(encrypted[0]))
+		}
+
+		if size, _ := EncryptedSize(int64(len(data)), WithCipher(c)); size != int64(len(encrypted)) {
+			t.Fatalf("%s: got %d bytes of ciphertext, want %d", c, len(encrypted), size)
+		}
+		min, max, err := DecryptedSizeBounds(int64(len(encrypted)))
+		if err != nil || int64(len(data)) < min || int64(len(data)) > max {
+			t.Fatalf("%s: %d not within [%d, %d]: %v", c, len(data), min, max, err)
+		}
+
+		decrypted, err := Decrypt(encrypted, key)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatalf("%s: [%X] != [%X]", c, decrypted, data)
+		}
+	}
+
+	if _, err := Encrypt(nil, randKey(), WithCipher(Cipher(0))); err == nil {
+		t.Fatal("expected an error for an unknown cipher")
+	}
+}
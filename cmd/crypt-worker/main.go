@@ -0,0 +1,18 @@
+// Command crypt-worker decrypts a single ciphertext for a crypt.Sandbox,
+// point Sandbox.Path at it to keep decryption out of the main binary. it
+// reads the key and ciphertext from stdin and writes the plaintext to
+// stdout, see crypt.SandboxWorker.
+package main
+
+import (
+	"os"
+
+	"github.com/UlisseMini/crypt"
+)
+
+func main() {
+	if err := crypt.SandboxWorker(os.Stdout, os.Stdin); err != nil {
+		os.Stderr.WriteString(err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"time"
+
+	"github.com/UlisseMini/crypt"
+)
+
+// clipboard is the system clipboard, tests swap it for one in memory.
+var clipboard clipboardIO = systemClipboard{}
+
+type clipboardIO interface {
+	read() ([]byte, error)
+	write(b []byte) error
+}
+
+// systemClipboard goes through the clipboard tool of the platform, there is
+// no portable way to reach the clipboard from Go.
+type systemClipboard struct{}
+
+// clipTools returns the commands that print the clipboard and that set it
+// to their stdin.
+func clipTools() (paste, set []string, err error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"pbpaste"}, []string{"pbcopy"}, nil
+	case "windows":
+		return []string{"powershell", "-NoProfile", "-Command", "Get-Clipboard -Raw"},
+			[]string{"powershell", "-NoProfile", "-Command", "[Console]::In.ReadToEnd() | Set-Clipboard"}, nil
+	}
+
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		if _, err := exec.LookPath("wl-paste"); err == nil {
+			return []string{"wl-paste", "-n"}, []string{"wl-copy"}, nil
+		}
+	}
+	if _, err := exec.LookPath("xclip"); err == nil {
+		return []string{"xclip", "-selection", "clipboard", "-o"}, []string{"xclip", "-selection", "clipboard", "-i"}, nil
+	}
+	if _, err := exec.LookPath("xsel"); err == nil {
+		return []string{"xsel", "--clipboard", "--output"}, []string{"xsel", "--clipboard", "--input"}, nil
+	}
+	return nil, nil, errors.New("clip needs wl-clipboard, xclip or xsel")
+}
+
+func (systemClipboard) read() ([]byte, error) {
+	paste, _, err := clipTools()
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command(paste[0], paste[1:]...).Output()
+}
+
+func (systemClipboard) write(b []byte) error {
+	_, set, err := clipTools()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(set[0], set[1:]...)
+	cmd.Stdin = bytes.NewReader(b)
+	return cmd.Run()
+}
+
+// clip encrypts the clipboard to an armored message or decrypts one in
+// it, for passing a secret through chat with a key both sides have. a
+// decrypted secret is cleared from the clipboard again after -clear, or on
+// an interrupt, unless something else was copied in the meantime.
+func clip(args []string, stderr io.Writer) error {
+	if len(args) == 0 || args[0] != "enc" && args[0] != "dec" {
+		io.WriteString(stderr, usage)
+		return errUsage
+	}
+	dec := args[0] == "dec"
+
+	fs := newFlagSet("clip", stderr)
+	keyFile := fs.String("k", "", "use the key in `file`")
+	passphrase := fs.Bool("p", false, "use a passphrase read from the terminal")
+	after := fs.Duration("clear", 45*time.Second, "clear the decrypted secret from the clipboard after `duration`, 0 leaves it")
+	if in, err := parse(fs, args[1:]); err != nil {
+		return err
+	} else if in != "" {
+		fs.Usage()
+		return errUsage
+	}
+	if countSet(*keyFile != "", *passphrase) != 1 {
+		io.WriteString(stderr, "crypt: clip needs exactly one of -k or -p\n")
+		return errUsage
+	}
+
+	var key *[32]byte
+	var pw []byte
+	var err error
+	if *keyFile != "" {
+		key, err = crypt.LoadKeyFile(*keyFile, nil)
+	} else {
+		pw, err = readPassphrase(!dec)
+	}
+	if err != nil {
+		return err
+	}
+
+	text, err := clipboard.read()
+	if err != nil {
+		return err
+	}
+	if len(text) == 0 {
+		return errors.New("the clipboard is empty")
+	}
+	if !dec {
+		var buf bytes.Buffer
+		a := crypt.NewArmorWriter(&buf)
+		var w *crypt.Writer
+		if key != nil {
+			w, err = crypt.NewWriter(a, key)
+		} else {
+			w, err = crypt.NewPasswordWriter(a, pw)
+		}
+		if err != nil {
+			return err
+		}
+		w.Write(text)
+		if err := w.Close(); err != nil {
+			return err
+		}
+		if err := a.Close(); err != nil {
+			return err
+		}
+		return clipboard.write(buf.Bytes())
+	}
+
+	var r *crypt.Reader
+	if key != nil {
+		r, err = crypt.NewReader(crypt.NewArmorReader(bytes.NewReader(text)), key)
+	} else {
+		r, err = crypt.NewPasswordReader(crypt.NewArmorReader(bytes.NewReader(text)), pw)
+	}
+	if err != nil {
+		return err
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if err := clipboard.write(plain); err != nil {
+		return err
+	}
+	if *after <= 0 {
+		return nil
+	}
+
+	io.WriteString(stderr, "crypt: the secret is in the clipboard until "+time.Now().Add(*after).Format(time.TimeOnly)+"\n")
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	select {
+	case <-time.After(*after):
+	case <-interrupt:
+	}
+
+	// leave whatever was copied since alone
+	now, err := clipboard.read()
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(now, plain) {
+		err = clipboard.write(nil)
+	}
+	clear(plain)
+	return err
+}
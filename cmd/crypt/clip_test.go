@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// memClipboard is a clipboard in memory that remembers everything written
+// to it.
+type memClipboard struct {
+	b      []byte
+	writes [][]byte
+}
+
+func (m *memClipboard) read() ([]byte, error) { return bytes.Clone(m.b), nil }
+
+func (m *memClipboard) write(b []byte) error {
+	m.b = bytes.Clone(b)
+	m.writes = append(m.writes, m.b)
+	return nil
+}
+
+func TestClip(t *testing.T) {
+	mem := &memClipboard{b: []byte("hunter2")}
+	defer func(c clipboardIO) { clipboard = c }(clipboard)
+	clipboard = mem
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+	run([]string{"keygen", "-o", keyFile}, nil, &bytes.Buffer{}, &bytes.Buffer{})
+
+	var stderr bytes.Buffer
+	if code := run([]string{"clip", "enc", "-k", keyFile}, nil, &bytes.Buffer{}, &stderr); code != exitOK {
+		t.Fatalf("clip enc: exit %d: %s", code, stderr.String())
+	}
+	if !strings.HasPrefix(string(mem.b), "-----BEGIN CRYPT MESSAGE-----") {
+		t.Fatalf("clipboard holds %q", mem.b)
+	}
+
+	if code := run([]string{"clip", "dec", "-k", keyFile, "-clear", "1ms"}, nil, &bytes.Buffer{}, &stderr); code != exitOK {
+		t.Fatalf("clip dec: exit %d: %s", code, stderr.String())
+	}
+	if len(mem.writes) != 3 || string(mem.writes[1]) != "hunter2" || len(mem.b) != 0 {
+		t.Fatalf("clipboard went through %q", mem.writes)
+	}
+
+	// an empty clipboard and one that isn't a message for the key
+	if code := run([]string{"clip", "dec", "-k", keyFile}, nil, &bytes.Buffer{}, &bytes.Buffer{}); code != exitError {
+		t.Fatalf("empty clipboard: exit %d, want %d", code, exitError)
+	}
+	mem.b = []byte("not a message")
+	if code := run([]string{"clip", "dec", "-k", keyFile}, nil, &bytes.Buffer{}, &bytes.Buffer{}); code == exitOK {
+		t.Fatal("decrypted something that isn't a message")
+	}
+	for _, args := range [][]string{{"clip"}, {"clip", "paste"}, {"clip", "enc"}, {"clip", "dec", "-k", keyFile, "extra"}} {
+		if code := run(args, nil, &bytes.Buffer{}, &bytes.Buffer{}); code != exitUsage {
+			t.Errorf("%q: exit %d, want %d", args, code, exitUsage)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/UlisseMini/crypt"
+)
+
+// childExit is returned when the command env ran exited unsuccessfully,
+// crypt exits with the same code.
+type childExit struct{ code int }
+
+func (e *childExit) Error() string { return "command exited with " + strconv.Itoa(e.code) }
+
+// env decrypts an env file into memory and runs a command with its
+// variables added to the environment, so secrets reach a CI job or a
+// service without their plaintext ever being written to disk.
+func env(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("env", stderr)
+	keyFile := fs.String("k", "", "decrypt with the key in `file`")
+	passphrase := fs.Bool("p", false, "decrypt with a passphrase read from the terminal")
+	identity := fs.String("i", "", "decrypt with the age or ssh identity in `file`")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	pos := fs.Args()
+	if len(pos) > 1 && pos[1] == "--" {
+		pos = append(pos[:1:1], pos[2:]...)
+	}
+	if len(pos) < 2 {
+		fs.Usage()
+		return errUsage
+	}
+	if countSet(*keyFile != "", *passphrase, *identity != "") != 1 {
+		fmt.Fprintln(stderr, "crypt: env needs exactly one of -k, -p or -i")
+		return errUsage
+	}
+
+	var open func(r io.Reader) (*crypt.Reader, error)
+	switch {
+	case *keyFile != "":
+		key, err := crypt.LoadKeyFile(*keyFile, nil)
+		if err != nil {
+			return err
+		}
+		open = func(r io.Reader) (*crypt.Reader, error) { return crypt.NewReader(r, key) }
+	case *passphrase:
+		pw, err := readPassphrase(false)
+		if err != nil {
+			return err
+		}
+		open = func(r io.Reader) (*crypt.Reader, error) { return crypt.NewPasswordReader(r, pw) }
+	default:
+		id, err := readIdentity(*identity)
+		if err != nil {
+			return err
+		}
+		open = func(r io.Reader) (*crypt.Reader, error) { return crypt.NewRecipientReader(r, id) }
+	}
+
+	f, err := os.Open(pos[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := open(f)
+	if err != nil {
+		return err
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	vars, err := parseEnv(plain)
+	clear(plain)
+	if err != nil {
+		return fmt.Errorf("%s: %w", pos[0], err)
+	}
+
+	cmd := exec.Command(pos[1], pos[2:]...)
+	cmd.Env = append(os.Environ(), vars...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// the command gets interrupts and terminations, crypt waits for it to
+	// exit rather than leave it behind
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		for sig := range sigs {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	err = cmd.Wait()
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && ee.ExitCode() > 0 {
+		return &childExit{ee.ExitCode()}
+	}
+	return err
+}
+
+// parseEnv parses an env file of NAME=value lines into the form of
+// os.Environ. blank lines and lines starting with # are skipped, an
+// export in front of a name is allowed and a value in matching single or
+// double quotes has them removed.
+func parseEnv(b []byte) ([]string, error) {
+	var vars []string
+	for i, line := range bytes.Split(b, []byte("\n")) {
+		s := strings.TrimSpace(string(line))
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		s = strings.TrimPrefix(s, "export ")
+		name, value, ok := strings.Cut(s, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t\x00") {
+			return nil, fmt.Errorf("line %d is not NAME=value", i+1)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		vars = append(vars, name+"="+value)
+	}
+	return vars, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseEnv(t *testing.T) {
+	vars, err := parseEnv([]byte("# secrets\n\nA=1\nexport B = \"two words\"\nC='x=y'\r\nD=\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(vars); got != "[A=1 B=two words C=x=y D=]" {
+		t.Fatalf("got %s", got)
+	}
+	for _, bad := range []string{"A", "=1", "A B=1"} {
+		if _, err := parseEnv([]byte(bad)); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
+
+func TestEnv(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh to run")
+	}
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	run([]string{"keygen", "-o", keyFile}, nil, &bytes.Buffer{}, &bytes.Buffer{})
+	envFile := filepath.Join(dir, "env.crypt")
+	run([]string{"encrypt", "-k", keyFile, "-o", envFile}, strings.NewReader("SECRET=hunter2\n"), &bytes.Buffer{}, &bytes.Buffer{})
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"env", "-k", keyFile, envFile, "--", sh, "-c", "echo $SECRET; exit 7"}, nil, &stdout, &stderr)
+	if code != 7 || stdout.String() != "hunter2\n" {
+		t.Fatalf("exit %d, printed %q: %s", code, stdout.String(), stderr.String())
+	}
+	if _, ok := os.LookupEnv("SECRET"); ok {
+		t.Fatal("the variable leaked into crypt's own environment")
+	}
+
+	other := filepath.Join(dir, "other")
+	run([]string{"keygen", "-o", other}, nil, &bytes.Buffer{}, &bytes.Buffer{})
+	if code := run([]string{"env", "-k", other, envFile, sh, "-c", "true"}, nil, &bytes.Buffer{}, &bytes.Buffer{}); code != exitWrongKey {
+		t.Fatalf("wrong key: exit %d, want %d", code, exitWrongKey)
+	}
+	if code := run([]string{"env", "-k", keyFile, envFile}, nil, &bytes.Buffer{}, &bytes.Buffer{}); code != exitUsage {
+		t.Fatalf("no command: exit %d, want %d", code, exitUsage)
+	}
+}
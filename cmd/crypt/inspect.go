@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/UlisseMini/crypt"
+)
+
+// streamInfo is what inspect prints about a file, the json field names are
+// part of the interface for scripts.
+type streamInfo struct {
+	File           string        `json:"file"`
+	Version        int           `json:"version,omitempty"`
+	Cipher         string        `json:"cipher,omitempty"`
+	ChunkSize      int           `json:"chunkSize,omitempty"`
+	HeaderSize     int           `json:"headerSize,omitempty"`
+	KDF            *kdfInfo      `json:"kdf,omitempty"`
+	KeyID          *uint32       `json:"keyId,omitempty"`
+	Recipients     int           `json:"recipients,omitempty"`
+	WrappedKey     []byte        `json:"wrappedKey,omitempty"`
+	Archive        bool          `json:"archive,omitempty"`
+	Detached       bool          `json:"detached,omitempty"`
+	CounterNonces  bool          `json:"counterNonces,omitempty"`
+	RekeyInterval  uint64        `json:"rekeyInterval,omitempty"`
+	Signer         string        `json:"signer,omitempty"`
+	Metadata       *metadataInfo `json:"metadata,omitempty"`
+	Timestamp      *time.Time    `json:"timestamp,omitempty"`
+	KeyFingerprint string        `json:"keyFingerprint,omitempty"`
+	StreamID       string        `json:"streamId,omitempty"`
+	ObjectID       string        `json:"objectId,omitempty"`
+	Error          string        `json:"error,omitempty"`
+}
+
+type kdfInfo struct {
+	Name   string    `json:"name"`
+	Params crypt.KDF `json:"params"`
+}
+
+type metadataInfo struct {
+	Name        string            `json:"name,omitempty"`
+	ModTime     *time.Time        `json:"modTime,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+// verifyResult is what verify prints about a file. Exit is the exit code
+// the file alone would give, Chunk and Offset locate a bad chunk.
+type verifyResult struct {
+	File   string `json:"file"`
+	OK     bool   `json:"ok"`
+	Exit   int    `json:"exit"`
+	Error  string `json:"error,omitempty"`
+	Chunk  *int64 `json:"chunk,omitempty"`
+	Offset *int64 `json:"offset,omitempty"`
+}
+
+func newStreamInfo(name string, info *crypt.StreamInfo) *streamInfo {
+	s := &streamInfo{
+		File:           name,
+		Version:        info.Version,
+		Cipher:         info.Cipher.String(),
+		ChunkSize:      info.ChunkSize,
+		HeaderSize:     info.HeaderSize,
+		Recipients:     info.Recipients,
+		WrappedKey:     info.WrappedKey,
+		Archive:        info.Archive,
+		Detached:       info.Detached,
+		CounterNonces:  info.CounterNonces,
+		RekeyInterval:  info.RekeyInterval,
+		KeyFingerprint: info.KeyFingerprint,
+		StreamID:       info.StreamID,
+		ObjectID:       info.ObjectID,
+	}
+	if info.KDF != nil {
+		s.KDF = &kdfInfo{Name: strings.ToLower(strings.TrimPrefix(fmt.Sprintf("%T", info.KDF), "crypt.")), Params: info.KDF}
+	}
+	if info.HasKeyID {
+		s.KeyID = &info.KeyID
+	}
+	if info.Signer != nil {
+		s.Signer = hex.EncodeToString(info.Signer)
+	}
+	if m := info.Metadata; m != nil {
+		s.Metadata = &metadataInfo{Name: m.Name, ContentType: m.ContentType, Extra: m.Extra}
+		if !m.ModTime.IsZero() {
+			s.Metadata.ModTime = &m.ModTime
+		}
+	}
+	if !info.Timestamp.IsZero() {
+		s.Timestamp = &info.Timestamp
+	}
+	return s
+}
+
+// print writes s as lines of name: value, leaving out what isn't set.
+func (s *streamInfo) print(w io.Writer) {
+	line := func(name string, v any) { fmt.Fprintf(w, "%-16s%v\n", name+":", v) }
+	line("file", s.File)
+	if s.Error != "" {
+		line("error", s.Error)
+		return
+	}
+	line("version", s.Version)
+	line("cipher", s.Cipher)
+	line("chunk size", s.ChunkSize)
+	line("header size", s.HeaderSize)
+	if s.KDF != nil {
+		line("kdf", fmt.Sprintf("%s %+v", s.KDF.Name, s.KDF.Params))
+	}
+	if s.KeyID != nil {
+		line("key id", *s.KeyID)
+	}
+	if s.Recipients != 0 {
+		line("recipients", s.Recipients)
+	}
+	if s.WrappedKey != nil {
+		line("wrapped key", hex.EncodeToString(s.WrappedKey))
+	}
+	for _, flag := range []struct {
+		name string
+		set  bool
+	}{{"archive", s.Archive}, {"detached", s.Detached}, {"counter nonces", s.CounterNonces}} {
+		if flag.set {
+			line(flag.name, "yes")
+		}
+	}
+	if s.RekeyInterval != 0 {
+		line("rekey interval", s.RekeyInterval)
+	}
+	if s.Signer != "" {
+		line("signer", s.Signer)
+	}
+	if m := s.Metadata; m != nil {
+		if m.Name != "" {
+			line("name", m.Name)
+		}
+		if m.ModTime != nil {
+			line("modified", m.ModTime.Format(time.RFC3339))
+		}
+		if m.ContentType != "" {
+			line("content type", m.ContentType)
+		}
+		for k, v := range m.Extra {
+			line(k, v)
+		}
+	}
+	if s.Timestamp != nil {
+		line("timestamp", s.Timestamp.Format(time.RFC3339))
+	}
+	if s.KeyFingerprint != "" {
+		line("key fingerprint", s.KeyFingerprint)
+	}
+	if s.StreamID != "" {
+		line("stream id", s.StreamID)
+	}
+	if s.ObjectID != "" {
+		line("object id", s.ObjectID)
+	}
+}
+
+// eachFile calls fn with every file named in files and its name, or with
+// stdin as "-" if there are none. it goes through all of them and returns
+// the first error.
+func eachFile(files []string, stdin io.Reader, fn func(name string, r io.Reader) error) error {
+	if len(files) == 0 {
+		return fn("-", stdin)
+	}
+	var first error
+	for _, name := range files {
+		err := func() error {
+			if name == "-" {
+				return fn(name, stdin)
+			}
+			f, err := os.Open(name)
+			if err != nil {
+				return fn(name, errReader{err})
+			}
+			defer f.Close()
+			return fn(name, f)
+		}()
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// errReader fails every read with err, so a file that doesn't open is
+// reported like one that doesn't read.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func inspect(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("inspect", stderr)
+	asJSON := fs.Bool("json", false, "print a json object per file")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	enc := json.NewEncoder(stdout)
+	return eachFile(fs.Args(), stdin, func(name string, r io.Reader) error {
+		info, err := crypt.Inspect(r)
+		s := &streamInfo{File: name}
+		if err == nil {
+			s = newStreamInfo(name, info)
+		} else {
+			s.Error = strings.TrimPrefix(err.Error(), "crypt: ")
+			err = fmt.Errorf("%s: %w", name, err)
+		}
+		if *asJSON {
+			enc.Encode(s)
+		} else {
+			s.print(stdout)
+			if len(fs.Args()) > 1 {
+				io.WriteString(stdout, "\n")
+			}
+		}
+		return err
+	})
+}
+
+func verify(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("verify", stderr)
+	keyFile := fs.String("k", "", "verify with the key in `file`")
+	passphrase := fs.Bool("p", false, "verify with a passphrase read from the terminal")
+	identity := fs.String("i", "", "verify with the age or ssh identity in `file`")
+	asJSON := fs.Bool("json", false, "print a json object per file")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if countSet(*keyFile != "", *passphrase, *identity != "") != 1 {
+		fmt.Fprintln(stderr, "crypt: verify needs exactly one of -k, -p or -i")
+		return errUsage
+	}
+
+	var check func(r io.Reader) error
+	switch {
+	case *keyFile != "":
+		key, err := crypt.LoadKeyFile(*keyFile, nil)
+		if err != nil {
+			return err
+		}
+		check = func(r io.Reader) error { return crypt.Verify(r, key) }
+	case *passphrase:
+		pw, err := readPassphrase(false)
+		if err != nil {
+			return err
+		}
+		check = func(r io.Reader) error { return drain(crypt.NewPasswordReader(r, pw)) }
+	default:
+		id, err := readIdentity(*identity)
+		if err != nil {
+			return err
+		}
+		check = func(r io.Reader) error { return drain(crypt.NewRecipientReader(r, id)) }
+	}
+
+	enc := json.NewEncoder(stdout)
+	return eachFile(fs.Args(), stdin, func(name string, r io.Reader) error {
+		err := check(r)
+		res := verifyResult{File: name, OK: err == nil, Exit: exitCode(err)}
+		if err != nil {
+			res.Error = strings.TrimPrefix(err.Error(), "crypt: ")
+			var ce *crypt.ChunkError
+			if errors.As(err, &ce) {
+				res.Chunk, res.Offset = &ce.Chunk, &ce.Offset
+			}
+			err = fmt.Errorf("%s: %w", name, err)
+		}
+		switch {
+		case *asJSON:
+			enc.Encode(res)
+		case err == nil:
+			fmt.Fprintln(stdout, name+": ok")
+		}
+		return err
+	})
+}
+
+// drain reads everything from a Reader that was just opened, for checking
+// streams Verify has no key for.
+func drain(r *crypt.Reader, err error) error {
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInspectVerify(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	run([]string{"keygen", "-o", keyFile}, nil, &bytes.Buffer{}, &bytes.Buffer{})
+
+	good := filepath.Join(dir, "good")
+	run([]string{"encrypt", "-k", keyFile, "-o", good}, strings.NewReader("some data"), &bytes.Buffer{}, &bytes.Buffer{})
+	ct, _ := os.ReadFile(good)
+	damaged := filepath.Join(dir, "damaged")
+	ct[len(ct)-1] ^= 1
+	os.WriteFile(damaged, ct, 0o600)
+	missing := filepath.Join(dir, "missing")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"inspect", good}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("inspect: exit %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "cipher:") || !strings.Contains(stdout.String(), "key fingerprint:") {
+		t.Fatalf("inspect printed\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	if code := run([]string{"inspect", "-json", good}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("inspect -json: exit %d: %s", code, stderr.String())
+	}
+	var info streamInfo
+	if err := json.Unmarshal(stdout.Bytes(), &info); err != nil || info.File != good || info.ChunkSize == 0 || info.KeyFingerprint == "" {
+		t.Fatalf("inspect -json printed %s: %v", stdout.Bytes(), err)
+	}
+
+	// every file is verified, the exit code is that of the first failure
+	stdout.Reset()
+	code := run([]string{"verify", "-k", keyFile, "-json", good, damaged, missing}, nil, &stdout, &stderr)
+	if code != exitAuth {
+		t.Fatalf("verify: exit %d, want %d", code, exitAuth)
+	}
+	var results []verifyResult
+	dec := json.NewDecoder(&stdout)
+	for dec.More() {
+		var res verifyResult
+		if err := dec.Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, res)
+	}
+	if len(results) != 3 || !results[0].OK || results[1].Exit != exitAuth || results[1].Chunk == nil || results[2].Exit != exitError {
+		t.Fatalf("verify -json gave %+v", results)
+	}
+
+	other := filepath.Join(dir, "other")
+	run([]string{"keygen", "-o", other}, nil, &bytes.Buffer{}, &bytes.Buffer{})
+	if code := run([]string{"verify", "-k", other, good}, nil, &bytes.Buffer{}, &bytes.Buffer{}); code != exitWrongKey {
+		t.Fatalf("verify with another key: exit %d, want %d", code, exitWrongKey)
+	}
+	if code := run([]string{"verify", good}, nil, &bytes.Buffer{}, &bytes.Buffer{}); code != exitUsage {
+		t.Fatalf("verify without a key: exit %d, want %d", code, exitUsage)
+	}
+}
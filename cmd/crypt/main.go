@@ -0,0 +1,543 @@
+// Command crypt encrypts and decrypts crypt streams from the shell.
+//
+//	crypt keygen [-pair] [-o file]
+//	crypt encrypt (-k keyfile | -p | -r recipient...) [-o out] [in]
+//	crypt decrypt (-k keyfile | -p | -i identity) [-o out] [in]
+//	crypt clip (enc | dec) (-k keyfile | -p) [-clear duration]
+//	crypt pipe [-d] -k keyfile [in [out]]
+//	crypt inspect [-json] [file...]
+//	crypt verify (-k keyfile | -p | -i identity) [-json] [file...]
+//	crypt env (-k keyfile | -p | -i identity) envfile [--] command [arg...]
+//	crypt spec export
+//
+// input defaults to stdin and output to stdout. a key file holds a key as
+// written by keygen, hex or base64, or by crypt.SaveKeyFile without a
+// passphrase, and must not be accessible to group or others. recipients are age X25519 recipients
+// (age1...) or ssh public keys, identities are files holding an age
+// identity (AGE-SECRET-KEY-1...) or an unencrypted ssh private key. -p
+// prompts for a passphrase on the terminal without echoing it.
+//
+// decrypting to a file writes it only once the whole stream has been
+// authenticated. when writing to stdout whatever was authenticated before
+// a failure has already been written, check the exit code.
+//
+// clip enc replaces the clipboard with an armored message of what it
+// holds, clip dec puts the secret back and clears it again after -clear,
+// 45s by default. it uses pbcopy, wl-clipboard, xclip or xsel.
+//
+// pipe encrypts, or with -d decrypts, data as it arrives instead of a
+// chunk at a time, for the middle of a pipeline like
+// tar c . | crypt pipe -k key | ssh host 'crypt pipe -d -k key | tar x'.
+// in and out can be named pipes. the decrypted data is written as it is
+// authenticated, a pipe that was cut off exits with 3 after the data that
+// did arrive. see crypt.EncryptPipe.
+//
+// inspect describes the header of each file without a key, verify checks
+// each file is intact without writing the plaintext anywhere. both go
+// through every file, or stdin if none is given. with -json they print one
+// json object per file and line, for scripts.
+//
+// env decrypts envfile in memory and runs command with its variables added
+// to the environment, passing on interrupts and its exit code. envfile is
+// NAME=value lines encrypted with crypt encrypt, # starts a comment.
+//
+// spec export prints the machine readable description of the stream
+// format, see crypt.FormatSpec, for implementations in other languages.
+//
+// exit codes, for the first file that failed. once env has started its
+// command it exits with the command's code instead.
+//
+//	0 success
+//	1 any other error, such as a file that can't be read
+//	2 bad usage
+//	3 the input failed to authenticate or was truncated
+//	4 the input wasn't encrypted for the key or identity
+//
+// encrypt -k records the fingerprint of the key, see
+// crypt.WithKeyFingerprint, so decrypting with another key gives 4 rather
+// than 3. a wrong passphrase can't be told from damage and gives 3.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/UlisseMini/crypt"
+	"golang.org/x/term"
+)
+
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+	exitAuth
+	exitWrongKey
+)
+
+const usage = `usage:
+  crypt keygen [-pair] [-o file]
+  crypt encrypt (-k keyfile | -p | -r recipient...) [-o out] [in]
+  crypt decrypt (-k keyfile | -p | -i identity) [-o out] [in]
+  crypt clip (enc | dec) (-k keyfile | -p) [-clear duration]
+  crypt pipe [-d] -k keyfile [in [out]]
+  crypt inspect [-json] [file...]
+  crypt verify (-k keyfile | -p | -i identity) [-json] [file...]
+  crypt env (-k keyfile | -p | -i identity) envfile [--] command [arg...]
+  crypt spec export
+`
+
+// errUsage is returned for bad command lines, the message has already been
+// printed.
+var errUsage = errors.New("usage")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command line args and returns the exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		io.WriteString(stderr, usage)
+		return exitUsage
+	}
+
+	var err error
+	switch args[0] {
+	case "keygen":
+		err = keygen(args[1:], stdout, stderr)
+	case "encrypt":
+		err = encrypt(args[1:], stdin, stdout, stderr)
+	case "decrypt":
+		err = decrypt(args[1:], stdin, stdout, stderr)
+	case "clip":
+		err = clip(args[1:], stderr)
+	case "pipe":
+		err = pipe(args[1:], stdin, stdout, stderr)
+	case "inspect":
+		err = inspect(args[1:], stdin, stdout, stderr)
+	case "verify":
+		err = verify(args[1:], stdin, stdout, stderr)
+	case "env":
+		err = env(args[1:], stdin, stdout, stderr)
+	case "spec":
+		err = spec(args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		io.WriteString(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "crypt: unknown command %q\n%s", args[0], usage)
+		return exitUsage
+	}
+
+	var ce *childExit
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	code := exitCode(err)
+	if code != exitOK && code != exitUsage {
+		fmt.Fprintln(stderr, "crypt:", strings.TrimPrefix(err.Error(), "crypt: "))
+	}
+	return code
+}
+
+// exitCode returns the exit code for err.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errUsage):
+		return exitUsage
+	case errors.Is(err, crypt.ErrWrongKey) || errors.Is(err, crypt.ErrKeyNotFound):
+		return exitWrongKey
+	case errors.Is(err, crypt.ErrAuthenticationFailed) || errors.Is(err, crypt.ErrTruncated) ||
+		errors.Is(err, crypt.ErrChunkOutOfOrder):
+		return exitAuth
+	}
+	return exitError
+}
+
+// listFlag collects a flag given more than once.
+type listFlag []string
+
+func (l *listFlag) String() string     { return strings.Join(*l, ",") }
+func (l *listFlag) Set(s string) error { *l = append(*l, s); return nil }
+
+// newFlagSet returns a flag set that prints the usage to stderr.
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		io.WriteString(stderr, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses args, allowing at most one positional argument which is
+// returned.
+func parse(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", errUsage
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return "", errUsage
+	}
+	return fs.Arg(0), nil
+}
+
+// spec prints the format spec, export is the only subcommand so far.
+func spec(args []string, stdout, stderr io.Writer) error {
+	if len(args) != 1 || args[0] != "export" {
+		io.WriteString(stderr, usage)
+		return errUsage
+	}
+	_, err := stdout.Write(crypt.FormatSpec())
+	return err
+}
+
+func keygen(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("keygen", stderr)
+	out := fs.String("o", "", "write the key to `file` instead of stdout")
+	pair := fs.Bool("pair", false, "generate an age identity, printing its recipient to stderr")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+
+	var text string
+	if *pair {
+		pub, priv, err := crypt.GenerateKeyPair()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stderr, "public key:", pub.AgeString())
+		text = "# public key: " + pub.AgeString() + "\n" + priv.AgeString() + "\n"
+	} else {
+		key, err := crypt.GenerateKey()
+		if err != nil {
+			return err
+		}
+		text = key.Base64() + "\n"
+	}
+
+	if *out == "" {
+		_, err := io.WriteString(stdout, text)
+		return err
+	}
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, text); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func encrypt(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("encrypt", stderr)
+	keyFile := fs.String("k", "", "encrypt with the key in `file`")
+	passphrase := fs.Bool("p", false, "encrypt with a passphrase read from the terminal")
+	var recipients listFlag
+	fs.Var(&recipients, "r", "encrypt to `recipient`, age1... or an ssh public key, may be repeated")
+	out := fs.String("o", "", "write to `file` instead of stdout")
+	in, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if countSet(*keyFile != "", *passphrase, len(recipients) > 0) != 1 {
+		fmt.Fprintln(stderr, "crypt: encrypt needs exactly one of -k, -p or -r")
+		return errUsage
+	}
+
+	return convert(in, *out, stdin, stdout, func(w io.Writer) (io.WriteCloser, error) {
+		switch {
+		case *keyFile != "":
+			key, err := crypt.LoadKeyFile(*keyFile, nil)
+			if err != nil {
+				return nil, err
+			}
+			return crypt.NewWriter(w, key, crypt.WithKeyFingerprint())
+		case *passphrase:
+			pw, err := readPassphrase(true)
+			if err != nil {
+				return nil, err
+			}
+			return crypt.NewPasswordWriter(w, pw)
+		}
+
+		rs := make([]crypt.Recipient, 0, len(recipients))
+		for _, s := range recipients {
+			r, err := parseRecipient(s)
+			if err != nil {
+				return nil, err
+			}
+			rs = append(rs, r)
+		}
+		return crypt.NewRecipientWriter(w, rs)
+	})
+}
+
+func decrypt(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("decrypt", stderr)
+	keyFile := fs.String("k", "", "decrypt with the key in `file`")
+	passphrase := fs.Bool("p", false, "decrypt with a passphrase read from the terminal")
+	identity := fs.String("i", "", "decrypt with the age or ssh identity in `file`")
+	out := fs.String("o", "", "write to `file` instead of stdout")
+	in, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if countSet(*keyFile != "", *passphrase, *identity != "") != 1 {
+		fmt.Fprintln(stderr, "crypt: decrypt needs exactly one of -k, -p or -i")
+		return errUsage
+	}
+
+	return convert(in, *out, stdin, stdout, func(w io.Writer) (io.WriteCloser, error) {
+		var open func(r io.Reader) (*crypt.Reader, error)
+		switch {
+		case *keyFile != "":
+			key, err := crypt.LoadKeyFile(*keyFile, nil)
+			if err != nil {
+				return nil, err
+			}
+			open = func(r io.Reader) (*crypt.Reader, error) { return crypt.NewReader(r, key) }
+		case *passphrase:
+			pw, err := readPassphrase(false)
+			if err != nil {
+				return nil, err
+			}
+			open = func(r io.Reader) (*crypt.Reader, error) { return crypt.NewPasswordReader(r, pw) }
+		default:
+			id, err := readIdentity(*identity)
+			if err != nil {
+				return nil, err
+			}
+			open = func(r io.Reader) (*crypt.Reader, error) { return crypt.NewRecipientReader(r, id) }
+		}
+		return newDecryptWriter(w, open), nil
+	})
+}
+
+func pipe(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("pipe", stderr)
+	keyFile := fs.String("k", "", "use the key in `file`")
+	dec := fs.Bool("d", false, "decrypt instead of encrypting")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() > 2 || *keyFile == "" {
+		fs.Usage()
+		return errUsage
+	}
+	key, err := crypt.LoadKeyFile(*keyFile, nil)
+	if err != nil {
+		return err
+	}
+
+	src, dst := stdin, stdout
+	if in := fs.Arg(0); in != "" && in != "-" {
+		f, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		src = f
+	}
+	var out *os.File
+	if name := fs.Arg(1); name != "" && name != "-" {
+		if out, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600); err != nil {
+			return err
+		}
+		defer out.Close()
+		dst = out
+	}
+
+	if *dec {
+		_, err = crypt.DecryptPipe(dst, src, key)
+	} else {
+		_, err = crypt.EncryptPipe(dst, src, key)
+	}
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		return out.Close()
+	}
+	return nil
+}
+
+// countSet returns how many of bs are true.
+func countSet(bs ...bool) int {
+	n := 0
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+// convert copies the file in, or stdin, through the writer made by wrap to
+// the file out, or stdout. a file is written under a temporary name and
+// renamed once everything succeeded, so a failure never leaves a partial
+// or unauthenticated output behind.
+func convert(in, out string, stdin io.Reader, stdout io.Writer, wrap func(io.Writer) (io.WriteCloser, error)) error {
+	src := stdin
+	if in != "" && in != "-" {
+		f, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		src = f
+	}
+
+	if out == "" || out == "-" {
+		w, err := wrap(stdout)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, src); err != nil {
+			return err
+		}
+		return w.Close()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(out), "."+filepath.Base(out)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w, err := wrap(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), out)
+}
+
+// decryptWriter decrypts what is written to it into w, the stream is read
+// on a pipe by a goroutine so decryption fits convert's writer shape.
+type decryptWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newDecryptWriter(w io.Writer, open func(io.Reader) (*crypt.Reader, error)) *decryptWriter {
+	pr, pw := io.Pipe()
+	d := &decryptWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := func() error {
+			r, err := open(pr)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(w, r)
+			return err
+		}()
+		// unblock the writer if we stopped early, the reader rejects
+		// anything after the final chunk so a clean stop read everything
+		pr.CloseWithError(err)
+		d.done <- err
+	}()
+	return d
+}
+
+func (d *decryptWriter) Write(p []byte) (int, error) {
+	n, err := d.pw.Write(p)
+	if err != nil {
+		// report the decryption error rather than the closed pipe
+		if derr := <-d.done; derr != nil {
+			d.done <- derr
+			return n, derr
+		}
+	}
+	return n, err
+}
+
+func (d *decryptWriter) Close() error {
+	d.pw.Close()
+	return <-d.done
+}
+
+// parseRecipient parses an age recipient or an ssh public key.
+func parseRecipient(s string) (crypt.Recipient, error) {
+	if strings.HasPrefix(s, "age1") {
+		return crypt.ParseAgeRecipient(s)
+	}
+	return crypt.ParseSSHRecipient([]byte(s))
+}
+
+// readIdentity reads an identity file made by keygen -pair, or an
+// unencrypted ssh private key. comment and blank lines are skipped.
+func readIdentity(name string) (crypt.Identity, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(string(b), "-----BEGIN") {
+		return crypt.ParseSSHIdentity(b)
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return crypt.ParseAgeIdentity(line)
+	}
+	return nil, errors.New(name + ": no identity found")
+}
+
+// readPassphrase prompts for a passphrase on the terminal without echo,
+// asking twice when confirm is set. the terminal is opened directly so
+// stdin and stdout stay free for data.
+func readPassphrase(confirm bool) ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.New("-p needs a terminal to read the passphrase from")
+	}
+	defer tty.Close()
+
+	prompt := func(p string) ([]byte, error) {
+		io.WriteString(tty, p)
+		b, err := term.ReadPassword(int(tty.Fd()))
+		io.WriteString(tty, "\n")
+		return b, err
+	}
+
+	pw, err := prompt("passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(pw) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	if confirm {
+		again, err := prompt("confirm passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if string(again) != string(pw) {
+			return nil, errors.New("passphrases don't match")
+		}
+	}
+	return pw, nil
+}
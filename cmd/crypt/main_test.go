@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/UlisseMini/crypt"
+)
+
+func TestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if code := run([]string{"keygen", "-o", keyFile}, nil, &bytes.Buffer{}, &bytes.Buffer{}); code != exitOK {
+		t.Fatalf("keygen: exit %d", code)
+	}
+
+	plain := bytes.Repeat([]byte("hello cli "), 100_000)
+	var ct, stderr bytes.Buffer
+	if code := run([]string{"encrypt", "-k", keyFile}, bytes.NewReader(plain), &ct, &stderr); code != exitOK {
+		t.Fatalf("encrypt: exit %d: %s", code, stderr.String())
+	}
+
+	in := filepath.Join(dir, "in.crypt")
+	out := filepath.Join(dir, "out")
+	if err := os.WriteFile(in, ct.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if code := run([]string{"decrypt", "-k", keyFile, "-o", out, in}, nil, &bytes.Buffer{}, &stderr); code != exitOK {
+		t.Fatalf("decrypt: exit %d: %s", code, stderr.String())
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("round trip mismatch")
+	}
+
+	// a damaged stream exits with exitAuth and leaves no output file
+	damaged := bytes.Clone(ct.Bytes())
+	damaged[len(damaged)/2] ^= 1
+	out2 := filepath.Join(dir, "out2")
+	stderr.Reset()
+	code := run([]string{"decrypt", "-k", keyFile, "-o", out2}, bytes.NewReader(damaged), &bytes.Buffer{}, &stderr)
+	if code != exitAuth {
+		t.Fatalf("damaged: exit %d, want %d: %s", code, exitAuth, stderr.String())
+	}
+	if _, err := os.Stat(out2); !os.IsNotExist(err) {
+		t.Fatal("damaged stream left an output file")
+	}
+
+	code = run([]string{"decrypt", "-k", keyFile}, bytes.NewReader(ct.Bytes()[:ct.Len()-10]), &bytes.Buffer{}, &stderr)
+	if code != exitAuth {
+		t.Fatalf("truncated: exit %d, want %d", code, exitAuth)
+	}
+
+	other := filepath.Join(dir, "other")
+	run([]string{"keygen", "-o", other}, nil, &bytes.Buffer{}, &bytes.Buffer{})
+	if code := run([]string{"decrypt", "-k", other}, bytes.NewReader(ct.Bytes()), &bytes.Buffer{}, &stderr); code != exitWrongKey {
+		t.Fatalf("wrong key: exit %d, want %d", code, exitWrongKey)
+	}
+
+	// key files from crypt.SaveKeyFile work as well
+	key, err := crypt.LoadKeyFile(keyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemFile := filepath.Join(dir, "key.pem")
+	if err := crypt.SaveKeyFile(pemFile, key, nil); err != nil {
+		t.Fatal(err)
+	}
+	if code := run([]string{"decrypt", "-k", pemFile}, bytes.NewReader(ct.Bytes()), &bytes.Buffer{}, &stderr); code != exitOK {
+		t.Fatalf("pem key file: exit %d: %s", code, stderr.String())
+	}
+}
+
+func TestRecipients(t *testing.T) {
+	dir := t.TempDir()
+	idFile := filepath.Join(dir, "id")
+	var stderr bytes.Buffer
+	if code := run([]string{"keygen", "-pair", "-o", idFile}, nil, &bytes.Buffer{}, &stderr); code != exitOK {
+		t.Fatalf("keygen: exit %d", code)
+	}
+	recipient := strings.TrimSpace(strings.TrimPrefix(stderr.String(), "public key:"))
+
+	var ct bytes.Buffer
+	if code := run([]string{"encrypt", "-r", recipient}, strings.NewReader("secret"), &ct, &stderr); code != exitOK {
+		t.Fatalf("encrypt: exit %d: %s", code, stderr.String())
+	}
+	var pt bytes.Buffer
+	if code := run([]string{"decrypt", "-i", idFile}, &ct, &pt, &stderr); code != exitOK {
+		t.Fatalf("decrypt: exit %d: %s", code, stderr.String())
+	}
+	if pt.String() != "secret" {
+		t.Fatalf("got %q", pt.String())
+	}
+
+	// someone else's identity
+	other := filepath.Join(dir, "other")
+	run([]string{"keygen", "-pair", "-o", other}, nil, &bytes.Buffer{}, &bytes.Buffer{})
+	ct.Reset()
+	run([]string{"encrypt", "-r", recipient}, strings.NewReader("secret"), &ct, &stderr)
+	if code := run([]string{"decrypt", "-i", other}, &ct, &bytes.Buffer{}, &bytes.Buffer{}); code != exitWrongKey {
+		t.Fatalf("wrong identity: exit %d, want %d", code, exitWrongKey)
+	}
+}
+
+func TestPipe(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	run([]string{"keygen", "-o", keyFile}, nil, &bytes.Buffer{}, &bytes.Buffer{})
+
+	var ct, pt, stderr bytes.Buffer
+	if code := run([]string{"pipe", "-k", keyFile}, strings.NewReader("through the pipe"), &ct, &stderr); code != exitOK {
+		t.Fatalf("pipe: exit %d: %s", code, stderr.String())
+	}
+	enc := filepath.Join(dir, "enc")
+	out := filepath.Join(dir, "out")
+	os.WriteFile(enc, ct.Bytes(), 0o600)
+	if code := run([]string{"pipe", "-d", "-k", keyFile, enc, out}, nil, &pt, &stderr); code != exitOK {
+		t.Fatalf("pipe -d: exit %d: %s", code, stderr.String())
+	}
+	if got, _ := os.ReadFile(out); string(got) != "through the pipe" {
+		t.Fatalf("got %q", got)
+	}
+
+	if code := run([]string{"pipe", "-d", "-k", keyFile}, bytes.NewReader(ct.Bytes()[:ct.Len()-1]), &pt, &stderr); code != exitAuth {
+		t.Fatalf("cut off pipe: exit %d, want %d", code, exitAuth)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"frobnicate"},
+		{"encrypt"},
+		{"encrypt", "-k", "a", "-p"},
+		{"decrypt", "-k", "a", "b", "c"},
+		{"keygen", "-bogus"},
+		{"pipe"},
+		{"pipe", "-k", "a", "b", "c", "d"},
+		{"spec"},
+		{"spec", "import"},
+	} {
+		if code := run(args, nil, &bytes.Buffer{}, &bytes.Buffer{}); code != exitUsage {
+			t.Errorf("%q: exit %d, want %d", args, code, exitUsage)
+		}
+	}
+}
+
+func TestSpecExport(t *testing.T) {
+	var stdout bytes.Buffer
+	if code := run([]string{"spec", "export"}, nil, &stdout, &bytes.Buffer{}); code != exitOK {
+		t.Fatalf("spec export: exit %d", code)
+	}
+	if !bytes.Equal(stdout.Bytes(), crypt.FormatSpec()) {
+		t.Fatal("spec export didn't print the format spec")
+	}
+}
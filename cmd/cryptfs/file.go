@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/UlisseMini/crypt"
+)
+
+// encFile is a file of the cipher directory, a crypt.BlockFile whose first
+// sector holds the size of the plaintext, since a block file is always a
+// whole number of sectors. the plaintext starts at the second sector.
+//
+// bytes of the last sector past the size are kept zero, so growing the
+// file with Truncate or a write past the end reads back zeros.
+type encFile struct {
+	f      *os.File
+	b      *crypt.BlockFile
+	sector int64
+	size   int64
+}
+
+// createFile writes a new, empty encrypted file to f.
+func createFile(f *os.File, key *[32]byte, sectorSize int) (*encFile, error) {
+	b, err := crypt.CreateBlockFile(f, sectorSize, key, crypt.WithCipher(crypt.XChaCha20Poly1305))
+	if err != nil {
+		return nil, err
+	}
+	e := &encFile{f: f, b: b, sector: int64(b.SectorSize())}
+	if err := e.writeSize(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// openFile opens the encrypted file in f.
+func openFile(f *os.File, key *[32]byte) (*encFile, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	b, err := crypt.OpenBlockFile(f, st.Size(), key)
+	if err != nil {
+		return nil, err
+	}
+	e := &encFile{f: f, b: b, sector: int64(b.SectorSize())}
+	var buf [8]byte
+	if _, err := b.ReadAt(buf[:], 0); err != nil {
+		return nil, err
+	}
+	e.size = int64(binary.BigEndian.Uint64(buf[:]))
+	if b.Size() < e.sector || e.size > b.Size()-e.sector {
+		return nil, errors.New("cryptfs: file size doesn't match its sectors")
+	}
+	return e, nil
+}
+
+// fileSize returns the plaintext size of the encrypted file name.
+func fileSize(name string, key *[32]byte) (int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	e, err := openFile(f, key)
+	if err != nil {
+		return 0, err
+	}
+	return e.size, nil
+}
+
+func (e *encFile) Size() int64 {
+	return e.size
+}
+
+func (e *encFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= e.size {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(p)), e.size-off))
+	if _, err := e.b.ReadAt(p[:n], e.sector+off); err != nil {
+		return 0, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (e *encFile) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("cryptfs: negative offset")
+	}
+	n, err := e.b.WriteAt(p, e.sector+off)
+	if end := off + int64(n); end > e.size {
+		e.size = end
+		if err2 := e.writeSize(); err == nil {
+			err = err2
+		}
+	}
+	return n, err
+}
+
+// Truncate changes the size of the plaintext.
+func (e *encFile) Truncate(size int64) error {
+	if size < 0 {
+		return errors.New("cryptfs: negative size")
+	}
+	if size < e.size {
+		// zero what is left of the new last sector past the size
+		end := min(e.size, (size+e.sector-1)/e.sector*e.sector)
+		if end > size {
+			if _, err := e.b.WriteAt(make([]byte, end-size), e.sector+size); err != nil {
+				return err
+			}
+		}
+	}
+	if err := e.b.Truncate(e.sector + size); err != nil {
+		return err
+	}
+	e.size = size
+	return e.writeSize()
+}
+
+func (e *encFile) Sync() error {
+	return e.f.Sync()
+}
+
+func (e *encFile) Close() error {
+	return e.f.Close()
+}
+
+func (e *encFile) writeSize() error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(e.size))
+	_, err := e.b.WriteAt(buf[:], 0)
+	return err
+}
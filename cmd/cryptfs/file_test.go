@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func randKey() *[32]byte {
+	var k [32]byte
+	rand.Read(k[:])
+	return &k
+}
+
+func TestEncFile(t *testing.T) {
+	key := randKey()
+	name := filepath.Join(t.TempDir(), "f")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := createFile(f, key, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// want mirrors what the file should hold
+	want := make([]byte, 150)
+	rand.Read(want)
+	if _, err := e.WriteAt(want, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.WriteAt([]byte("tail"), 200); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, make([]byte, 50)...)
+	want = append(want, "tail"...)
+
+	// shrinking into a sector and growing again reads back zeros
+	if err := e.Truncate(130); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Truncate(180); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want[:130], make([]byte, 50)...)
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if size, err := fileSize(name, key); err != nil || size != int64(len(want)) {
+		t.Fatalf("fileSize = %d, %v", size, err)
+	}
+	f, err = os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	e, err = openFile(f, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(io.NewSectionReader(e, 0, 1<<20))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("read back %d bytes, %v", len(got), err)
+	}
+
+	if _, err := fileSize(name, randKey()); err == nil {
+		t.Fatal("opened with the wrong key")
+	}
+}
@@ -0,0 +1,838 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// attrValid is how long in seconds the kernel may cache names and
+// attributes. everything goes through the mount, so they only change
+// behind its back when the cipher directory is changed directly.
+const attrValid = 1
+
+const rootNode = 1
+
+// server answers the kernel's requests for a mounted tree, one at a time.
+type server struct {
+	t  *tree
+	fd int
+
+	nodes    map[uint64]*node
+	paths    map[string]*node
+	nextNode uint64
+
+	handles    map[uint64]*handle
+	nextHandle uint64
+}
+
+// node is a file or directory the kernel knows by a node id.
+type node struct {
+	id      uint64
+	path    string
+	lookups uint64
+
+	// deleted is set once the node has been removed or renamed over, it
+	// lives on while the kernel holds it
+	deleted bool
+
+	// file is shared by the open handles of the node
+	file     *encFile
+	writable bool
+	opens    int
+
+	// size caches the plaintext size of the file while it isn't open,
+	// valid while the disk file has diskSize and mtime
+	size     int64
+	diskSize int64
+	mtime    time.Time
+}
+
+// handle is an open file or directory.
+type handle struct {
+	n *node
+
+	// dir is the listing of an open directory, taken when reading starts
+	dir []entry
+}
+
+// mount serves t at mountpoint until it is unmounted, or on an interrupt
+// which unmounts it.
+func mount(t *tree, mountpoint string) error {
+	mountpoint, err := filepath.Abs(mountpoint)
+	if err != nil {
+		return err
+	}
+	fd, err := fuseMount(mountpoint)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		if _, ok := <-sig; ok {
+			fuseUnmount(mountpoint)
+		}
+	}()
+
+	return newServer(t, fd).serve()
+}
+
+func newServer(t *tree, fd int) *server {
+	root := &node{id: rootNode, path: "", lookups: 1}
+	return &server{
+		t:          t,
+		fd:         fd,
+		nodes:      map[uint64]*node{rootNode: root},
+		paths:      map[string]*node{"": root},
+		nextNode:   rootNode + 1,
+		handles:    map[uint64]*handle{},
+		nextHandle: 1,
+	}
+}
+
+// serve answers requests until the file system is unmounted.
+func (s *server) serve() error {
+	buf := make([]byte, bufSize)
+	for {
+		n, err := unix.Read(s.fd, buf)
+		switch err {
+		case nil:
+		case unix.EINTR, unix.EAGAIN, unix.ENOENT:
+			// ENOENT is a request that was interrupted before we read it
+			continue
+		case unix.ENODEV:
+			return nil
+		default:
+			return &os.PathError{Op: "read", Path: "/dev/fuse", Err: err}
+		}
+
+		req, err := parseRequest(buf[:n])
+		if err != nil {
+			return err
+		}
+		if req.op == opDestroy {
+			s.reply(req, 0, nil)
+			s.closeAll()
+			return nil
+		}
+		s.handle(req)
+	}
+}
+
+// reply answers req with an error number or, when it is 0, with out.
+func (s *server) reply(req *request, e syscall.Errno, out []byte) {
+	if e != 0 {
+		out = nil
+	}
+	b := make([]byte, outHeaderLen, outHeaderLen+len(out))
+	ne.PutUint32(b, uint32(outHeaderLen+len(out)))
+	ne.PutUint32(b[4:], uint32(-int32(e)))
+	ne.PutUint64(b[8:], req.unique)
+	// a failed reply means the request was interrupted, nobody is waiting
+	// for it anymore
+	unix.Write(s.fd, append(b, out...))
+}
+
+func (s *server) handle(req *request) {
+	d := &decoder{b: req.body}
+	var out []byte
+	var err error
+	switch req.op {
+	case opForget:
+		s.forget(req.node, d.u64())
+		return
+	case opBatchForget:
+		count := d.u32()
+		d.skip(4)
+		for range count {
+			id, n := d.u64(), d.u64()
+			if d.bad {
+				break
+			}
+			s.forget(id, n)
+		}
+		return
+	case opInterrupt:
+		// requests are answered one at a time, there is nothing to stop
+		return
+	case opInit:
+		out, err = s.init(d)
+	case opLookup:
+		out, err = s.lookup(req.node, d.name())
+	case opGetattr:
+		out, err = s.getattr(req.node)
+	case opSetattr:
+		out, err = s.setattr(req.node, d)
+	case opMkdir:
+		mode := d.u32()
+		d.skip(4)
+		out, err = s.mkdir(req.node, d.name(), mode)
+	case opUnlink:
+		err = s.unlink(req.node, d.name())
+	case opRmdir:
+		err = s.rmdir(req.node, d.name())
+	case opRename:
+		newDir := d.u64()
+		from := d.name()
+		err = s.rename(req.node, from, newDir, d.name())
+	case opOpen:
+		out, err = s.open(req.node, d.u32())
+	case opCreate:
+		flags, mode := d.u32(), d.u32()
+		d.skip(8)
+		out, err = s.create(req.node, d.name(), flags, mode)
+	case opRead:
+		fh, off, size := d.u64(), d.u64(), d.u32()
+		out, err = s.read(fh, int64(off), int(size))
+	case opWrite:
+		fh, off, size := d.u64(), d.u64(), d.u32()
+		d.skip(20)
+		if data := d.take(int(size)); !d.bad {
+			out, err = s.write(fh, int64(off), data)
+		}
+	case opFlush:
+	case opFsync:
+		err = s.fsync(d.u64())
+	case opRelease:
+		s.release(d.u64())
+	case opOpendir:
+		out, err = s.opendir(req.node)
+	case opReaddir:
+		fh, off, size := d.u64(), d.u64(), d.u32()
+		out, err = s.readdir(fh, int(off), int(size))
+	case opReleasedir:
+		delete(s.handles, d.u64())
+	case opFsyncdir:
+	case opStatfs:
+		out, err = s.statfs()
+	default:
+		err = unix.ENOSYS
+	}
+	if d.bad {
+		err = unix.EIO
+	}
+	if err != nil {
+		s.reply(req, errno(err), nil)
+		return
+	}
+	s.reply(req, 0, out)
+}
+
+func (s *server) init(d *decoder) ([]byte, error) {
+	major, minor, readahead, flags := d.u32(), d.u32(), d.u32(), d.u32()
+	if major != fuseMajor || minor < minMinor {
+		return nil, unix.EPROTO
+	}
+	out := ne.AppendUint32(nil, fuseMajor)
+	out = ne.AppendUint32(out, min(minor, fuseMinor))
+	out = ne.AppendUint32(out, readahead)
+	out = ne.AppendUint32(out, flags&initBigWrites)
+	out = ne.AppendUint16(out, 0)
+	out = ne.AppendUint16(out, 0)
+	out = ne.AppendUint32(out, maxWrite)
+	return append(out, make([]byte, initOutLen-len(out))...), nil
+}
+
+// node returns the live node id.
+func (s *server) node(id uint64) (*node, error) {
+	n, ok := s.nodes[id]
+	if !ok || n.deleted {
+		return nil, unix.ENOENT
+	}
+	return n, nil
+}
+
+// child returns the path of name in the directory id.
+func (s *server) child(id uint64, name string) (string, error) {
+	dir, err := s.node(id)
+	if err != nil {
+		return "", err
+	}
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return "", unix.EINVAL
+	}
+	return path.Join(dir.path, name), nil
+}
+
+// remember returns the node for p, counting a lookup of it.
+func (s *server) remember(p string) *node {
+	n, ok := s.paths[p]
+	if !ok {
+		n = &node{id: s.nextNode, path: p}
+		s.nextNode++
+		s.nodes[n.id] = n
+		s.paths[p] = n
+	}
+	n.lookups++
+	return n
+}
+
+func (s *server) forget(id, lookups uint64) {
+	n, ok := s.nodes[id]
+	if !ok || id == rootNode {
+		return
+	}
+	n.lookups -= min(lookups, n.lookups)
+	if n.lookups == 0 && n.opens == 0 {
+		delete(s.nodes, id)
+		if s.paths[n.path] == n {
+			delete(s.paths, n.path)
+		}
+	}
+}
+
+// detach marks the node at p, if any, as deleted.
+func (s *server) detach(p string) {
+	if n, ok := s.paths[p]; ok {
+		n.deleted = true
+		delete(s.paths, p)
+	}
+}
+
+// attr returns the attributes of n, or of p when n is nil.
+func (s *server) attr(n *node, p string) (*attr, error) {
+	var st unix.Stat_t
+	var disk string
+	if n != nil && n.file != nil {
+		if err := unix.Fstat(int(n.file.f.Fd()), &st); err != nil {
+			return nil, err
+		}
+	} else {
+		if n != nil {
+			p = n.path
+		}
+		var err error
+		if disk, err = s.t.diskPath(p); err != nil {
+			return nil, err
+		}
+		if err := unix.Lstat(disk, &st); err != nil {
+			return nil, err
+		}
+	}
+
+	a := &attr{
+		ino:   st.Ino,
+		size:  uint64(st.Size),
+		atime: st.Atim,
+		mtime: st.Mtim,
+		ctime: st.Ctim,
+		mode:  st.Mode,
+		nlink: uint32(st.Nlink),
+		uid:   st.Uid,
+		gid:   st.Gid,
+	}
+	switch st.Mode & unix.S_IFMT {
+	case unix.S_IFDIR:
+	case unix.S_IFREG:
+		size, err := s.fileSize(n, disk, &st)
+		if err != nil {
+			return nil, err
+		}
+		a.size = uint64(size)
+		a.nlink = 1
+	default:
+		return nil, unix.ENOENT
+	}
+	a.blocks = (a.size + 511) / 512
+	return a, nil
+}
+
+// fileSize returns the plaintext size of a file, from its open encFile or
+// else from the file on disk.
+func (s *server) fileSize(n *node, disk string, st *unix.Stat_t) (int64, error) {
+	if n != nil && n.file != nil {
+		return n.file.Size(), nil
+	}
+	mtime := time.Unix(st.Mtim.Unix())
+	if n != nil && n.diskSize == st.Size && n.mtime.Equal(mtime) && !n.mtime.IsZero() {
+		return n.size, nil
+	}
+	size, err := fileSize(disk, s.t.key)
+	if err != nil {
+		return 0, err
+	}
+	if n != nil {
+		n.size, n.diskSize, n.mtime = size, st.Size, mtime
+	}
+	return size, nil
+}
+
+func (s *server) entry(p string) ([]byte, error) {
+	a, err := s.attr(nil, p)
+	if err != nil {
+		return nil, err
+	}
+	return appendEntry(nil, s.remember(p).id, a), nil
+}
+
+func (s *server) lookup(parent uint64, name string) ([]byte, error) {
+	p, err := s.child(parent, name)
+	if err != nil {
+		return nil, err
+	}
+	return s.entry(p)
+}
+
+func (s *server) getattr(id uint64) ([]byte, error) {
+	n, ok := s.nodes[id]
+	if !ok || n.deleted && n.file == nil {
+		return nil, unix.ENOENT
+	}
+	a, err := s.attr(n, "")
+	if err != nil {
+		return nil, err
+	}
+	return appendAttr(nil, a), nil
+}
+
+func (s *server) setattr(id uint64, d *decoder) ([]byte, error) {
+	valid := d.u32()
+	d.skip(12)
+	size := d.u64()
+	d.skip(8)
+	atime, mtime := d.u64(), d.u64()
+	d.skip(8)
+	atimeNsec, mtimeNsec := d.u32(), d.u32()
+	d.skip(4)
+	mode := d.u32()
+	d.skip(4)
+	uid, gid := d.u32(), d.u32()
+	if d.bad {
+		return nil, unix.EIO
+	}
+
+	n, ok := s.nodes[id]
+	if !ok || n.deleted && n.file == nil {
+		return nil, unix.ENOENT
+	}
+	// a deleted node can only be an open file, which is changed through
+	// its descriptor
+	name := ""
+	if !n.deleted {
+		disk, err := s.t.diskPath(n.path)
+		if err != nil {
+			return nil, err
+		}
+		name = disk
+	} else {
+		name = "/proc/self/fd/" + strconv.Itoa(int(n.file.f.Fd()))
+	}
+
+	if valid&setSize != 0 {
+		if err := s.truncate(n, name, int64(size)); err != nil {
+			return nil, err
+		}
+	}
+	if valid&setMode != 0 {
+		if err := unix.Chmod(name, mode&0o7777); err != nil {
+			return nil, err
+		}
+	}
+	if valid&(setUID|setGID) != 0 {
+		u, g := -1, -1
+		if valid&setUID != 0 {
+			u = int(uid)
+		}
+		if valid&setGID != 0 {
+			g = int(gid)
+		}
+		if err := unix.Chown(name, u, g); err != nil {
+			return nil, err
+		}
+	}
+	if valid&(setAtime|setMtime|setAtimeNow|setMtimeNow) != 0 {
+		ts := []unix.Timespec{{Nsec: unix.UTIME_OMIT}, {Nsec: unix.UTIME_OMIT}}
+		switch {
+		case valid&setAtimeNow != 0:
+			ts[0].Nsec = unix.UTIME_NOW
+		case valid&setAtime != 0:
+			ts[0] = unix.Timespec{Sec: int64(atime), Nsec: int64(atimeNsec)}
+		}
+		switch {
+		case valid&setMtimeNow != 0:
+			ts[1].Nsec = unix.UTIME_NOW
+		case valid&setMtime != 0:
+			ts[1] = unix.Timespec{Sec: int64(mtime), Nsec: int64(mtimeNsec)}
+		}
+		if err := unix.UtimesNanoAt(unix.AT_FDCWD, name, ts, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	a, err := s.attr(n, "")
+	if err != nil {
+		return nil, err
+	}
+	return appendAttr(nil, a), nil
+}
+
+// truncate changes the plaintext size of the file of n, at disk unless it
+// is open.
+func (s *server) truncate(n *node, disk string, size int64) error {
+	if n.file != nil {
+		if !n.writable {
+			return unix.EACCES
+		}
+		return n.file.Truncate(size)
+	}
+	f, err := os.OpenFile(disk, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	e, err := openFile(f, s.t.key)
+	if err != nil {
+		return err
+	}
+	return e.Truncate(size)
+}
+
+func (s *server) mkdir(parent uint64, name string, mode uint32) ([]byte, error) {
+	p, err := s.child(parent, name)
+	if err != nil {
+		return nil, err
+	}
+	if s.t.reserved(p) {
+		return nil, unix.EPERM
+	}
+	if err := s.t.mkdir(p, os.FileMode(mode&0o777)); err != nil {
+		return nil, err
+	}
+	if mode&0o7000 != 0 {
+		disk, _ := s.t.diskPath(p)
+		unix.Chmod(disk, mode&0o7777)
+	}
+	return s.entry(p)
+}
+
+func (s *server) unlink(parent uint64, name string) error {
+	p, err := s.child(parent, name)
+	if err != nil {
+		return err
+	}
+	disk, err := s.t.diskPath(p)
+	if err != nil {
+		return err
+	}
+	if err := unix.Unlink(disk); err != nil {
+		return err
+	}
+	s.detach(p)
+	return nil
+}
+
+func (s *server) rmdir(parent uint64, name string) error {
+	p, err := s.child(parent, name)
+	if err != nil {
+		return err
+	}
+	if err := s.t.rmdir(p); err != nil {
+		return err
+	}
+	s.detach(p)
+	return nil
+}
+
+func (s *server) rename(fromDir uint64, fromName string, toDir uint64, toName string) error {
+	from, err := s.child(fromDir, fromName)
+	if err != nil {
+		return err
+	}
+	to, err := s.child(toDir, toName)
+	if err != nil {
+		return err
+	}
+	if s.t.reserved(to) {
+		return unix.EPERM
+	}
+	if from == to {
+		return nil
+	}
+	if strings.HasPrefix(to, from+"/") {
+		return unix.EINVAL
+	}
+	if err := s.t.rename(from, to); err != nil {
+		return err
+	}
+
+	s.detach(to)
+	for p, n := range s.paths {
+		if p == from || strings.HasPrefix(p, from+"/") {
+			delete(s.paths, p)
+			n.path = to + strings.TrimPrefix(p, from)
+		}
+	}
+	for _, n := range s.nodes {
+		if !n.deleted && (n.path == to || strings.HasPrefix(n.path, to+"/")) {
+			s.paths[n.path] = n
+		}
+	}
+	return nil
+}
+
+// openFile opens the file of n for a new handle.
+func (s *server) openFile(n *node, write bool) (uint64, error) {
+	if n.file == nil {
+		disk, err := s.t.diskPath(n.path)
+		if err != nil {
+			return 0, err
+		}
+		f, err := os.OpenFile(disk, os.O_RDWR, 0)
+		writable := err == nil
+		if errors.Is(err, os.ErrPermission) && !write {
+			f, err = os.Open(disk)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if st, err := f.Stat(); err != nil || !st.Mode().IsRegular() {
+			f.Close()
+			return 0, unix.EISDIR
+		}
+		e, err := openFile(f, s.t.key)
+		if err != nil {
+			f.Close()
+			return 0, err
+		}
+		n.file, n.writable = e, writable
+	}
+	if write && !n.writable {
+		return 0, unix.EACCES
+	}
+	return s.newHandle(n), nil
+}
+
+func (s *server) newHandle(n *node) uint64 {
+	fh := s.nextHandle
+	s.nextHandle++
+	s.handles[fh] = &handle{n: n}
+	n.opens++
+	return fh
+}
+
+func (s *server) open(id uint64, flags uint32) ([]byte, error) {
+	n, err := s.node(id)
+	if err != nil {
+		return nil, err
+	}
+	fh, err := s.openFile(n, flags&unix.O_ACCMODE != unix.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	return appendOpen(nil, fh), nil
+}
+
+func (s *server) create(parent uint64, name string, flags, mode uint32) ([]byte, error) {
+	p, err := s.child(parent, name)
+	if err != nil {
+		return nil, err
+	}
+	if s.t.reserved(p) {
+		return nil, unix.EPERM
+	}
+	disk, err := s.t.diskPath(p)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(disk, os.O_RDWR|os.O_CREATE|os.O_EXCL, os.FileMode(mode&0o777))
+	if errors.Is(err, os.ErrExist) && flags&unix.O_EXCL == 0 {
+		// made since the kernel looked, open it like open(2) would
+		out, err := s.entry(p)
+		if err != nil {
+			return nil, err
+		}
+		n := s.paths[p]
+		fh, err := s.openFile(n, true)
+		if err != nil {
+			s.forget(n.id, 1)
+			return nil, err
+		}
+		if flags&unix.O_TRUNC != 0 {
+			if err := n.file.Truncate(0); err != nil {
+				s.release(fh)
+				s.forget(n.id, 1)
+				return nil, err
+			}
+		}
+		return appendOpen(out, fh), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	e, err := createFile(f, s.t.key, s.t.sectorSize)
+	if err != nil {
+		f.Close()
+		os.Remove(disk)
+		return nil, err
+	}
+
+	s.detach(p)
+	n := s.remember(p)
+	n.file, n.writable = e, true
+	a, err := s.attr(n, "")
+	if err != nil {
+		s.forget(n.id, 1)
+		return nil, err
+	}
+	return appendOpen(appendEntry(nil, n.id, a), s.newHandle(n)), nil
+}
+
+func (s *server) openHandle(fh uint64) (*handle, error) {
+	h, ok := s.handles[fh]
+	if !ok || h.n.file == nil {
+		return nil, unix.EBADF
+	}
+	return h, nil
+}
+
+func (s *server) read(fh uint64, off int64, size int) ([]byte, error) {
+	h, err := s.openHandle(fh)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	n, err := h.n.file.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func (s *server) write(fh uint64, off int64, data []byte) ([]byte, error) {
+	h, err := s.openHandle(fh)
+	if err != nil {
+		return nil, err
+	}
+	if !h.n.writable {
+		return nil, unix.EBADF
+	}
+	n, err := h.n.file.WriteAt(data, off)
+	if err != nil {
+		return nil, err
+	}
+	return ne.AppendUint32(ne.AppendUint32(nil, uint32(n)), 0), nil
+}
+
+func (s *server) fsync(fh uint64) error {
+	h, err := s.openHandle(fh)
+	if err != nil {
+		return err
+	}
+	return h.n.file.Sync()
+}
+
+func (s *server) release(fh uint64) {
+	h, ok := s.handles[fh]
+	if !ok {
+		return
+	}
+	delete(s.handles, fh)
+	n := h.n
+	if n.opens--; n.opens == 0 && n.file != nil {
+		n.file.Close()
+		n.file = nil
+		n.mtime = time.Time{}
+		if n.lookups == 0 {
+			s.forget(n.id, 0)
+		}
+	}
+}
+
+// closeAll closes every open file, at unmount.
+func (s *server) closeAll() {
+	for fh := range s.handles {
+		s.release(fh)
+	}
+}
+
+func (s *server) opendir(id uint64) ([]byte, error) {
+	n, err := s.node(id)
+	if err != nil {
+		return nil, err
+	}
+	fh := s.nextHandle
+	s.nextHandle++
+	s.handles[fh] = &handle{n: n}
+	return appendOpen(nil, fh), nil
+}
+
+// readdir lists entries from index off, the listing is taken again when
+// reading starts over.
+func (s *server) readdir(fh uint64, off, size int) ([]byte, error) {
+	h, ok := s.handles[fh]
+	if !ok {
+		return nil, unix.EBADF
+	}
+	if off == 0 || h.dir == nil {
+		if h.n.deleted {
+			return nil, unix.ENOENT
+		}
+		entries, err := s.t.list(h.n.path)
+		if err != nil {
+			return nil, err
+		}
+		h.dir = entries
+	}
+
+	out := make([]byte, 0, size)
+	for i := off; i < len(h.dir)+2; i++ {
+		var ok bool
+		switch i {
+		case 0:
+			out, ok = appendDirent(out, size, 1, 1, unix.DT_DIR, ".")
+		case 1:
+			out, ok = appendDirent(out, size, 1, 2, unix.DT_DIR, "..")
+		default:
+			e := h.dir[i-2]
+			typ, ino := uint32(unix.DT_REG), uint64(1)
+			if e.de.IsDir() {
+				typ = unix.DT_DIR
+			}
+			if info, err := e.de.Info(); err == nil {
+				if st, ok := info.Sys().(*syscall.Stat_t); ok {
+					ino = st.Ino
+				}
+			}
+			out, ok = appendDirent(out, size, ino, uint64(i+1), typ, e.name)
+		}
+		if !ok {
+			break
+		}
+	}
+	return out, nil
+}
+
+func (s *server) statfs() ([]byte, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(s.t.root, &st); err != nil {
+		return nil, err
+	}
+	namelen := uint32(st.Namelen)
+	if s.t.names != nil {
+		// what an encrypted name of 255 bytes decrypts to
+		namelen = 175
+	}
+	out := ne.AppendUint64(nil, st.Blocks)
+	out = ne.AppendUint64(out, st.Bfree)
+	out = ne.AppendUint64(out, st.Bavail)
+	out = ne.AppendUint64(out, st.Files)
+	out = ne.AppendUint64(out, st.Ffree)
+	out = ne.AppendUint32(out, uint32(st.Bsize))
+	out = ne.AppendUint32(out, namelen)
+	out = ne.AppendUint32(out, uint32(st.Frsize))
+	return append(out, make([]byte, 28)...), nil
+}
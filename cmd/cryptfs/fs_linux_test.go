@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"syscall"
+	"testing"
+)
+
+// mountTest mounts a new cipher directory and returns it with the
+// mountpoint, the test is skipped where FUSE can't be mounted.
+func mountTest(t *testing.T, names bool) (cipher, mnt string) {
+	key := randKey()
+	cipher, mnt = t.TempDir(), t.TempDir()
+	if err := initDir(cipher, key, names); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConfig(cipher, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := newTree(cipher, key, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the server runs in this process, it needs a P of its own while a
+	// test goroutine waits in the kernel for its answer
+	procs := runtime.GOMAXPROCS(max(runtime.GOMAXPROCS(0), 2))
+	t.Cleanup(func() { runtime.GOMAXPROCS(procs) })
+	fd, err := fuseMount(mnt)
+	if err != nil {
+		t.Skip("can't mount FUSE here:", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- newServer(tr, fd).serve() }()
+	t.Cleanup(func() {
+		if err := fuseUnmount(mnt); err != nil {
+			t.Error(err)
+		}
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+		syscall.Close(fd)
+	})
+	return cipher, mnt
+}
+
+func TestMount(t *testing.T) {
+	for _, names := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plain names", true: "encrypted names"}[names], func(t *testing.T) {
+			cipher, mnt := mountTest(t, names)
+
+			big := make([]byte, 300_000)
+			rand.Read(big)
+			if err := os.MkdirAll(filepath.Join(mnt, "docs", "old"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(mnt, "docs", "big.bin"), big, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := os.ReadFile(filepath.Join(mnt, "docs", "big.bin")); err != nil || !bytes.Equal(got, big) {
+				t.Fatalf("read back %d bytes, %v", len(got), err)
+			}
+			if st, err := os.Stat(filepath.Join(mnt, "docs", "big.bin")); err != nil || st.Size() != int64(len(big)) {
+				t.Fatalf("stat: %v, %v", st, err)
+			}
+
+			// writing in the middle only changes what is written
+			f, err := os.OpenFile(filepath.Join(mnt, "docs", "big.bin"), os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.WriteAt([]byte("patch"), 5000); err != nil {
+				t.Fatal(err)
+			}
+			copy(big[5000:], "patch")
+			if err := f.Truncate(10_000); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := os.ReadFile(filepath.Join(mnt, "docs", "big.bin")); err != nil || !bytes.Equal(got, big[:10_000]) {
+				t.Fatalf("after patch read back %d bytes, %v", len(got), err)
+			}
+
+			if err := os.Rename(filepath.Join(mnt, "docs"), filepath.Join(mnt, "papers")); err != nil {
+				t.Fatal(err)
+			}
+			des, err := os.ReadDir(filepath.Join(mnt, "papers"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, de := range des {
+				got = append(got, de.Name())
+			}
+			if !slices.Equal(got, []string{"big.bin", "old"}) {
+				t.Fatalf("listing = %q", got)
+			}
+			if err := os.Remove(filepath.Join(mnt, "papers")); !errors.Is(err, syscall.ENOTEMPTY) && !errors.Is(err, syscall.EEXIST) {
+				t.Fatalf("removing a full directory: %v", err)
+			}
+			if err := os.Remove(filepath.Join(mnt, "papers", "old")); err != nil {
+				t.Fatal(err)
+			}
+
+			// only ciphertext reaches the disk
+			found := false
+			filepath.WalkDir(cipher, func(p string, d os.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				b, err := os.ReadFile(p)
+				if err != nil {
+					return err
+				}
+				if bytes.Contains(b, []byte("patch")) || bytes.Contains(b, big[:64]) {
+					t.Errorf("%s holds plaintext", p)
+				}
+				found = found || d.Name() == "big.bin"
+				return nil
+			})
+			if found == names {
+				t.Fatalf("plain name on disk: %v", found)
+			}
+		})
+	}
+}
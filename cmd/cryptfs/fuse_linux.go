@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// this is the part of the FUSE protocol, see linux/fuse.h, that cryptfs
+// needs. messages use the byte order of the machine.
+
+const (
+	opLookup      = 1
+	opForget      = 2
+	opGetattr     = 3
+	opSetattr     = 4
+	opMkdir       = 9
+	opUnlink      = 10
+	opRmdir       = 11
+	opRename      = 12
+	opOpen        = 14
+	opRead        = 15
+	opWrite       = 16
+	opStatfs      = 17
+	opRelease     = 18
+	opFsync       = 20
+	opFlush       = 25
+	opInit        = 26
+	opOpendir     = 27
+	opReaddir     = 28
+	opReleasedir  = 29
+	opFsyncdir    = 30
+	opCreate      = 35
+	opInterrupt   = 36
+	opDestroy     = 38
+	opBatchForget = 42
+)
+
+const (
+	fuseMajor = 7
+	fuseMinor = 31
+
+	// the kernel needs at least this, for create_in with its umask
+	minMinor = 12
+
+	inHeaderLen  = 40
+	outHeaderLen = 16
+	attrLen      = 88
+	initOutLen   = 64
+
+	initBigWrites = 1 << 5
+
+	maxWrite = 128 << 10
+
+	// bufSize holds the largest write and its headers
+	bufSize = maxWrite + 4096
+)
+
+// setattr_in valid bits.
+const (
+	setMode     = 1 << 0
+	setUID      = 1 << 1
+	setGID      = 1 << 2
+	setSize     = 1 << 3
+	setAtime    = 1 << 4
+	setMtime    = 1 << 5
+	setAtimeNow = 1 << 7
+	setMtimeNow = 1 << 8
+)
+
+var ne = binary.NativeEndian
+
+// request is a message from the kernel.
+type request struct {
+	op     uint32
+	unique uint64
+	node   uint64
+	body   []byte
+}
+
+func parseRequest(b []byte) (*request, error) {
+	if len(b) < inHeaderLen || int(ne.Uint32(b)) != len(b) {
+		return nil, errors.New("cryptfs: short FUSE request")
+	}
+	return &request{
+		op:     ne.Uint32(b[4:]),
+		unique: ne.Uint64(b[8:]),
+		node:   ne.Uint64(b[16:]),
+		body:   b[inHeaderLen:],
+	}, nil
+}
+
+// decoder reads the fields of a request body in order. a body that is too
+// short sets bad and reads zeros.
+type decoder struct {
+	b   []byte
+	bad bool
+}
+
+func (d *decoder) take(n int) []byte {
+	if len(d.b) < n {
+		d.bad = true
+		d.b = nil
+		return make([]byte, n)
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) u32() uint32 { return ne.Uint32(d.take(4)) }
+func (d *decoder) u64() uint64 { return ne.Uint64(d.take(8)) }
+func (d *decoder) skip(n int)  { d.take(n) }
+
+// name reads a NUL terminated name.
+func (d *decoder) name() string {
+	i := bytes.IndexByte(d.b, 0)
+	if i < 0 {
+		d.bad = true
+		return ""
+	}
+	s := string(d.b[:i])
+	d.b = d.b[i+1:]
+	return s
+}
+
+// attr is struct fuse_attr.
+type attr struct {
+	ino, size, blocks   uint64
+	atime, mtime, ctime unix.Timespec
+	mode, nlink         uint32
+	uid, gid, rdev      uint32
+}
+
+func (a *attr) append(b []byte) []byte {
+	for _, v := range []uint64{a.ino, a.size, a.blocks, uint64(a.atime.Sec), uint64(a.mtime.Sec), uint64(a.ctime.Sec)} {
+		b = ne.AppendUint64(b, v)
+	}
+	for _, v := range []uint32{uint32(a.atime.Nsec), uint32(a.mtime.Nsec), uint32(a.ctime.Nsec), a.mode, a.nlink, a.uid, a.gid, a.rdev, sectorSize, 0} {
+		b = ne.AppendUint32(b, v)
+	}
+	return b
+}
+
+// appendEntry appends struct fuse_entry_out.
+func appendEntry(b []byte, node uint64, a *attr) []byte {
+	b = ne.AppendUint64(b, node)
+	b = ne.AppendUint64(b, 0)
+	b = ne.AppendUint64(b, attrValid)
+	b = ne.AppendUint64(b, attrValid)
+	b = ne.AppendUint64(b, 0)
+	return a.append(b)
+}
+
+// appendAttr appends struct fuse_attr_out.
+func appendAttr(b []byte, a *attr) []byte {
+	b = ne.AppendUint64(b, attrValid)
+	b = ne.AppendUint64(b, 0)
+	return a.append(b)
+}
+
+// appendOpen appends struct fuse_open_out.
+func appendOpen(b []byte, fh uint64) []byte {
+	b = ne.AppendUint64(b, fh)
+	return ne.AppendUint64(b, 0)
+}
+
+// appendDirent appends struct fuse_dirent padded to 8 bytes, or returns b
+// unchanged if it doesn't fit in max.
+func appendDirent(b []byte, max int, ino, off uint64, typ uint32, name string) ([]byte, bool) {
+	n := 24 + len(name)
+	n += -n & 7
+	if len(b)+n > max {
+		return b, false
+	}
+	b = ne.AppendUint64(b, ino)
+	b = ne.AppendUint64(b, off)
+	b = ne.AppendUint32(b, uint32(len(name)))
+	b = ne.AppendUint32(b, typ)
+	b = append(b, name...)
+	return append(b, make([]byte, -(24+len(name))&7)...), true
+}
+
+// fuseMount mounts a FUSE file system at mountpoint, returning the
+// /dev/fuse descriptor to serve it on. as root it mounts directly,
+// otherwise it has fusermount3 or fusermount do it.
+func fuseMount(mountpoint string) (int, error) {
+	fd, err := unix.Open("/dev/fuse", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fusermount(mountpoint)
+	}
+	opts := fmt.Sprintf("fd=%d,rootmode=40000,user_id=%d,group_id=%d,default_permissions", fd, os.Getuid(), os.Getgid())
+	err = unix.Mount("cryptfs", mountpoint, "fuse.cryptfs", unix.MS_NOSUID|unix.MS_NODEV, opts)
+	if err == nil {
+		return fd, nil
+	}
+	unix.Close(fd)
+	if err != unix.EPERM {
+		return -1, &os.PathError{Op: "mount", Path: mountpoint, Err: err}
+	}
+	return fusermount(mountpoint)
+}
+
+// fusermount mounts through the setuid fusermount helper, which passes
+// the descriptor back over a socket named in _FUSE_COMMFD.
+func fusermount(mountpoint string) (int, error) {
+	bin, err := fusermountBin()
+	if err != nil {
+		return -1, err
+	}
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	local, remote := fds[0], os.NewFile(uintptr(fds[1]), "fusermount")
+	defer unix.Close(local)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(bin, "-o", "fsname=cryptfs,subtype=cryptfs,nosuid,nodev,default_permissions", "--", mountpoint)
+	cmd.ExtraFiles = []*os.File{remote}
+	cmd.Env = append(os.Environ(), "_FUSE_COMMFD=3")
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	remote.Close()
+	if err != nil {
+		return -1, fmt.Errorf("cryptfs: %s: %v: %s", bin, err, strings.TrimSpace(stderr.String()))
+	}
+
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(local, make([]byte, 4), oob, 0)
+	if err != nil {
+		return -1, err
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) == 0 {
+		return -1, errors.New("cryptfs: fusermount didn't pass a descriptor")
+	}
+	got, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil || len(got) == 0 {
+		return -1, errors.New("cryptfs: fusermount didn't pass a descriptor")
+	}
+	unix.CloseOnExec(got[0])
+	return got[0], nil
+}
+
+func fusermountBin() (string, error) {
+	for _, name := range []string{"fusermount3", "fusermount"} {
+		if bin, err := exec.LookPath(name); err == nil {
+			return bin, nil
+		}
+	}
+	return "", errors.New("cryptfs: mounting as a user needs fusermount3 or fusermount")
+}
+
+// fuseUnmount lazily unmounts mountpoint, the file system goes away once
+// nothing uses it anymore.
+func fuseUnmount(mountpoint string) error {
+	err := unix.Unmount(mountpoint, unix.MNT_DETACH)
+	if err != unix.EPERM {
+		return err
+	}
+	bin, err := fusermountBin()
+	if err != nil {
+		return err
+	}
+	out, err := exec.Command(bin, "-u", "-z", "--", mountpoint).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cryptfs: %s: %v: %s", bin, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// errno returns the error number to reply with for err.
+func errno(err error) syscall.Errno {
+	var e syscall.Errno
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, os.ErrNotExist):
+		return unix.ENOENT
+	case errors.Is(err, os.ErrExist):
+		return unix.EEXIST
+	case errors.Is(err, os.ErrPermission):
+		return unix.EACCES
+	}
+	return unix.EIO
+}
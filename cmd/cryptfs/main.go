@@ -0,0 +1,219 @@
+// Command cryptfs mounts a directory of encrypted files with FUSE, so
+// programs can use the plaintext like any other directory while only
+// ciphertext is ever written to disk.
+//
+//	cryptfs init [-names] -k keyfile cipherdir
+//	cryptfs mount -k keyfile cipherdir mountpoint
+//
+// init sets up an empty cipherdir for the key, -names encrypts file names
+// as well as contents. mount serves the plaintext of cipherdir at
+// mountpoint until it is unmounted or cryptfs gets an interrupt. the key
+// file holds a key as written by crypt keygen, hex or base64, or by
+// crypt.SaveKeyFile without a passphrase, and must not be accessible to
+// group or others.
+//
+// every file is a crypt block file, see crypt.BlockFile, so reads and
+// writes only touch the sectors they need. the first sector holds the
+// size of the plaintext. encrypted names are bound to a random id of their
+// directory kept in .cryptfs.dirid. like block files themselves, the
+// cipher directory doesn't detect files being swapped, rolled back or
+// removed by someone with write access to it.
+//
+// mounting is supported on linux, as root or through fusermount3.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/UlisseMini/crypt"
+)
+
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+)
+
+const usage = `usage:
+  cryptfs init [-names] -k keyfile cipherdir
+  cryptfs mount -k keyfile cipherdir mountpoint
+`
+
+const (
+	// confName is the config file in the root of a cipher directory
+	confName = "cryptfs.conf"
+
+	// dirIDName holds the id of a directory when names are encrypted
+	dirIDName = ".cryptfs.dirid"
+
+	sectorSize = 4096
+)
+
+// errUsage is returned for bad command lines, the message has already been
+// printed.
+var errUsage = errors.New("usage")
+
+// config is what confName holds.
+type config struct {
+	Version      int    `json:"version"`
+	EncryptNames bool   `json:"encryptNames"`
+	SectorSize   int    `json:"sectorSize"`
+	Fingerprint  string `json:"fingerprint"`
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command line args and returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		io.WriteString(stderr, usage)
+		return exitUsage
+	}
+
+	var err error
+	switch args[0] {
+	case "init":
+		err = initCmd(args[1:], stderr)
+	case "mount":
+		err = mountCmd(args[1:], stderr)
+	case "help", "-h", "-help", "--help":
+		io.WriteString(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "cryptfs: unknown command %q\n%s", args[0], usage)
+		return exitUsage
+	}
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errUsage):
+		return exitUsage
+	}
+	fmt.Fprintln(stderr, "cryptfs:", strings.TrimPrefix(strings.TrimPrefix(err.Error(), "crypt: "), "cryptfs: "))
+	return exitError
+}
+
+// parse parses args, which have to leave n positional arguments.
+func parse(fs *flag.FlagSet, args []string, n int, stderr io.Writer) ([]string, error) {
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		io.WriteString(stderr, usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	if fs.NArg() != n {
+		fs.Usage()
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+func initCmd(args []string, stderr io.Writer) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	keyFile := fs.String("k", "", "read the key from `file`")
+	names := fs.Bool("names", false, "encrypt file names")
+	pos, err := parse(fs, args, 1, stderr)
+	if err != nil {
+		return err
+	}
+	if *keyFile == "" {
+		fs.Usage()
+		return errUsage
+	}
+	key, err := crypt.LoadKeyFile(*keyFile, nil)
+	if err != nil {
+		return err
+	}
+	return initDir(pos[0], key, *names)
+}
+
+// initDir sets up dir, which has to be empty or not exist yet, as a cipher
+// directory for key.
+func initDir(dir string, key *[32]byte, names bool) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(des) > 0 {
+		return errors.New("cryptfs: " + dir + " is not empty")
+	}
+
+	c := config{Version: 1, EncryptNames: names, SectorSize: sectorSize, Fingerprint: (*crypt.Key)(key).Fingerprint()}
+	b, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	if names {
+		if err := writeDirID(dir); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(filepath.Join(dir, confName), append(b, '\n'), 0o600)
+}
+
+// loadConfig reads the config of the cipher directory dir and checks it
+// was made for key.
+func loadConfig(dir string, key *[32]byte) (*config, error) {
+	b, err := os.ReadFile(filepath.Join(dir, confName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errors.New("cryptfs: " + dir + " is not a cipher directory, run cryptfs init first")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("cryptfs: %s: %w", confName, err)
+	}
+	if c.Version != 1 {
+		return nil, fmt.Errorf("cryptfs: unsupported version %d", c.Version)
+	}
+	if c.SectorSize <= 0 {
+		return nil, errors.New("cryptfs: invalid sector size")
+	}
+	if fp := (*crypt.Key)(key).Fingerprint(); fp != c.Fingerprint {
+		return nil, fmt.Errorf("cryptfs: wrong key, %s is for %s and the key is %s", dir, c.Fingerprint, fp)
+	}
+	return &c, nil
+}
+
+func mountCmd(args []string, stderr io.Writer) error {
+	fs := flag.NewFlagSet("mount", flag.ContinueOnError)
+	keyFile := fs.String("k", "", "read the key from `file`")
+	pos, err := parse(fs, args, 2, stderr)
+	if err != nil {
+		return err
+	}
+	if *keyFile == "" {
+		fs.Usage()
+		return errUsage
+	}
+	key, err := crypt.LoadKeyFile(*keyFile, nil)
+	if err != nil {
+		return err
+	}
+	c, err := loadConfig(pos[0], key)
+	if err != nil {
+		return err
+	}
+	t, err := newTree(pos[0], key, c)
+	if err != nil {
+		return err
+	}
+	return mount(t, pos[1])
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/UlisseMini/crypt"
+)
+
+func TestInit(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString(randKey()[:])+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cipher := filepath.Join(dir, "cipher")
+	var stderr bytes.Buffer
+	if code := run([]string{"init", "-names", "-k", keyFile, cipher}, &bytes.Buffer{}, &stderr); code != exitOK {
+		t.Fatalf("init: exit %d: %s", code, stderr.String())
+	}
+	if code := run([]string{"init", "-k", keyFile, cipher}, &bytes.Buffer{}, &stderr); code != exitError {
+		t.Fatalf("init of a used directory: exit %d", code)
+	}
+	if code := run([]string{"mount", "-k", keyFile, cipher}, &bytes.Buffer{}, &stderr); code != exitUsage {
+		t.Fatalf("mount without a mountpoint: exit %d", code)
+	}
+
+	key, err := crypt.LoadKeyFile(keyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConfig(cipher, key)
+	if err != nil || !c.EncryptNames || c.SectorSize != sectorSize {
+		t.Fatalf("loadConfig = %+v, %v", c, err)
+	}
+	if _, err := loadConfig(cipher, randKey()); err == nil || !strings.Contains(err.Error(), "wrong key") {
+		t.Fatalf("wrong key: %v", err)
+	}
+	if _, err := loadConfig(dir, key); err == nil {
+		t.Fatal("loaded a directory that wasn't set up")
+	}
+}
+
+func TestTree(t *testing.T) {
+	for _, names := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plain names", true: "encrypted names"}[names], func(t *testing.T) {
+			key := randKey()
+			dir := t.TempDir()
+			if err := initDir(dir, key, names); err != nil {
+				t.Fatal(err)
+			}
+			c, err := loadConfig(dir, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr, err := newTree(dir, key, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tr.mkdir("a", 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := tr.mkdir("a/b", 0o755); err != nil {
+				t.Fatal(err)
+			}
+			disk, err := tr.diskPath("a/b/f")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(disk, nil, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "a")); names != errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("plain name on disk: %v", err)
+			}
+
+			// renaming a directory keeps what is in it
+			if err := tr.rename("a", "c"); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := tr.list("c/b")
+			if err != nil || len(entries) != 1 || entries[0].name != "f" {
+				t.Fatalf("list = %v, %v", entries, err)
+			}
+			if err := tr.rmdir("c/b"); !errors.Is(err, syscall.ENOTEMPTY) {
+				t.Fatalf("rmdir of a full directory: %v", err)
+			}
+
+			// our own files are hidden
+			entries, err = tr.list("")
+			if err != nil || len(entries) != 1 || entries[0].name != "c" {
+				t.Fatalf("root = %v, %v", entries, err)
+			}
+			if _, err := tr.diskPath(confName); err == nil {
+				t.Fatal("config file reachable")
+			}
+		})
+	}
+}
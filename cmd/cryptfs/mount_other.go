@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func mount(t *tree, mountpoint string) error {
+	return errors.New("cryptfs: FUSE mounts are only supported on linux")
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/UlisseMini/crypt"
+)
+
+// tree maps plaintext paths, slash separated and relative to the root with
+// "" for the root itself, to the files of the cipher directory.
+//
+// with encrypted names every directory holds a random id in dirIDName and
+// the names in it are encrypted bound to that id, rather than to the path,
+// so renaming a directory doesn't have to touch what is in it.
+type tree struct {
+	root       string
+	key        *[32]byte
+	names      *crypt.NameCipher
+	sectorSize int
+
+	// ids caches directory ids by disk path
+	ids map[string]string
+}
+
+// entry is a name in a plaintext directory listing and its file in the
+// cipher directory.
+type entry struct {
+	name string
+	de   fs.DirEntry
+}
+
+func newTree(root string, key *[32]byte, c *config) (*tree, error) {
+	t := &tree{root: root, key: key, sectorSize: c.SectorSize, ids: map[string]string{}}
+	if c.EncryptNames {
+		names, err := crypt.NewNameCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		t.names = names
+	}
+	return t, nil
+}
+
+// reserved reports whether p is one of our own files, which are hidden and
+// can't be created through the mount.
+func (t *tree) reserved(p string) bool {
+	return p == confName || path.Base(p) == dirIDName
+}
+
+// diskPath returns the path in the cipher directory of p.
+func (t *tree) diskPath(p string) (string, error) {
+	if t.reserved(p) {
+		return "", fs.ErrNotExist
+	}
+	if t.names == nil {
+		return filepath.Join(t.root, filepath.FromSlash(p)), nil
+	}
+	disk := t.root
+	if p == "" {
+		return disk, nil
+	}
+	for _, elem := range strings.Split(p, "/") {
+		id, err := t.dirID(disk)
+		if err != nil {
+			return "", err
+		}
+		enc, err := t.names.EncryptName(id, elem)
+		if err != nil {
+			return "", syscall.ENAMETOOLONG
+		}
+		disk = filepath.Join(disk, enc)
+	}
+	return disk, nil
+}
+
+// dirID returns the id of the directory disk.
+func (t *tree) dirID(disk string) (string, error) {
+	if id, ok := t.ids[disk]; ok {
+		return id, nil
+	}
+	b, err := os.ReadFile(filepath.Join(disk, dirIDName))
+	if err != nil {
+		return "", err
+	}
+	id := strings.TrimSpace(string(b))
+	t.ids[disk] = id
+	return id, nil
+}
+
+// writeDirID gives the directory disk a new id.
+func writeDirID(disk string) error {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return os.WriteFile(filepath.Join(disk, dirIDName), []byte(hex.EncodeToString(id)+"\n"), 0o444)
+}
+
+// list returns the plaintext directory p sorted by name. names that don't
+// decrypt are skipped, they aren't ours.
+func (t *tree) list(p string) ([]entry, error) {
+	disk, err := t.diskPath(p)
+	if err != nil {
+		return nil, err
+	}
+	des, err := os.ReadDir(disk)
+	if err != nil {
+		return nil, err
+	}
+	var id string
+	if t.names != nil {
+		if id, err = t.dirID(disk); err != nil {
+			return nil, err
+		}
+	}
+
+	var entries []entry
+	for _, de := range des {
+		if !de.Type().IsRegular() && !de.IsDir() {
+			continue
+		}
+		name := de.Name()
+		if t.names != nil {
+			if name, err = t.names.DecryptName(id, name); err != nil {
+				continue
+			}
+		}
+		if !t.reserved(path.Join(p, name)) {
+			entries = append(entries, entry{name: name, de: de})
+		}
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return strings.Compare(a.name, b.name) })
+	return entries, nil
+}
+
+func (t *tree) mkdir(p string, perm fs.FileMode) error {
+	disk, err := t.diskPath(p)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(disk, perm); err != nil {
+		return err
+	}
+	if t.names != nil {
+		if err := writeDirID(disk); err != nil {
+			os.Remove(disk)
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tree) rmdir(p string) error {
+	disk, err := t.diskPath(p)
+	if err != nil {
+		return err
+	}
+	if t.names == nil {
+		return os.Remove(disk)
+	}
+
+	des, err := os.ReadDir(disk)
+	if err != nil {
+		return err
+	}
+	if len(des) > 1 || len(des) == 1 && des[0].Name() != dirIDName {
+		return syscall.ENOTEMPTY
+	}
+	idFile := filepath.Join(disk, dirIDName)
+	id, err := os.ReadFile(idFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(idFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(disk); err != nil {
+		os.WriteFile(idFile, id, 0o444)
+		return err
+	}
+	clear(t.ids)
+	return nil
+}
+
+func (t *tree) rename(from, to string) error {
+	diskFrom, err := t.diskPath(from)
+	if err != nil {
+		return err
+	}
+	diskTo, err := t.diskPath(to)
+	if err != nil {
+		return err
+	}
+	if t.names != nil {
+		// an empty directory renamed over is removed first, its id file
+		// would make the rename fail
+		src, err := os.Stat(diskFrom)
+		if err != nil {
+			return err
+		}
+		if st, err := os.Stat(diskTo); err == nil && st.IsDir() && src.IsDir() {
+			if err := t.rmdir(to); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Rename(diskFrom, diskTo); err != nil {
+		return err
+	}
+	clear(t.ids)
+	return nil
+}
@@ -0,0 +1,110 @@
+package crypt
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// the first byte of the plaintext of an Encoder's stream says how values
+// are encoded, so a Decoder needs no options to match.
+const (
+	codecJSON = 'j'
+	codecGob  = 'g'
+)
+
+// Encoder writes Go values to an encrypted stream, encoded as JSON or with
+// WithGob as gob. values are framed by their encoding and the stream is
+// ended by Close, a Decoder reports a stream cut short as ErrTruncated
+// rather than just running out of values.
+type Encoder struct {
+	w   *Writer
+	enc interface{ Encode(any) error }
+}
+
+// WithGob makes an Encoder encode values with encoding/gob instead of JSON,
+// which keeps types JSON can't, such as maps with struct keys, and is
+// more compact for many values of the same type.
+func WithGob() Option {
+	return func(c *config) error {
+		c.gob = true
+		return nil
+	}
+}
+
+// NewEncoder returns an Encoder writing to w with key, options apply as for
+// NewWriter.
+func NewEncoder(w io.Writer, key *[32]byte, opts ...Option) (*Encoder, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	sw, err := newWriter(w, key, c, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Encoder{w: sw}
+	codec := byte(codecJSON)
+	if c.gob {
+		codec = codecGob
+		e.enc = gob.NewEncoder(sw)
+	} else {
+		e.enc = json.NewEncoder(sw)
+	}
+	if _, err := sw.Write([]byte{codec}); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Encode writes v to the stream. values are buffered until a chunk is full
+// or Close is called.
+func (e *Encoder) Encode(v any) error {
+	return e.enc.Encode(v)
+}
+
+// Close ends the stream, it does not close the underlying writer.
+func (e *Encoder) Close() error {
+	return e.w.Close()
+}
+
+// Decoder reads values written by an Encoder.
+type Decoder struct {
+	r   *Reader
+	dec interface{ Decode(any) error }
+}
+
+// NewDecoder returns a Decoder reading from r with key, options apply as
+// for NewReader.
+func NewDecoder(r io.Reader, key *[32]byte, opts ...Option) (*Decoder, error) {
+	sr, err := NewReader(r, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var codec [1]byte
+	if _, err := io.ReadFull(sr, codec[:]); err != nil {
+		if err == io.EOF {
+			err = errors.New("crypt: stream was not written by an Encoder")
+		}
+		return nil, err
+	}
+	d := &Decoder{r: sr}
+	switch codec[0] {
+	case codecJSON:
+		d.dec = json.NewDecoder(sr)
+	case codecGob:
+		d.dec = gob.NewDecoder(sr)
+	default:
+		return nil, errors.New("crypt: stream was not written by an Encoder")
+	}
+	return d, nil
+}
+
+// Decode reads the next value into v, it returns io.EOF once the stream
+// has ended cleanly after the last value.
+func (d *Decoder) Decode(v any) error {
+	return d.dec.Decode(v)
+}
@@ -0,0 +1,77 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type codecRecord struct {
+	Name  string
+	Count int
+	Tags  []string
+}
+
+func TestEncoder(t *testing.T) {
+	key := randKey()
+	records := []codecRecord{{"a", 1, nil}, {"b", 2, []string{"x", "y"}}, {"c", 3, []string{"z"}}}
+	for _, opts := range [][]Option{nil, {WithGob()}} {
+		var buf bytes.Buffer
+		enc, err := NewEncoder(&buf, key, append(opts, WithChunkSize(64))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range 20 {
+			if err := enc.Encode(records[i%len(records)]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		dec, err := NewDecoder(bytes.NewReader(buf.Bytes()), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range 20 {
+			var got codecRecord
+			if err := dec.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, records[i%len(records)]) {
+				t.Fatalf("record %d = %+v", i, got)
+			}
+		}
+		if err := dec.Decode(new(codecRecord)); err != io.EOF {
+			t.Fatalf("after the last value: %v", err)
+		}
+
+		// drop the final chunk
+		_, header, _ := readHeader(bytes.NewReader(buf.Bytes()))
+		final := (buf.Len() - len(header)) % (64 + enc.w.ChunkOverhead())
+		dec, err = NewDecoder(bytes.NewReader(buf.Bytes()[:buf.Len()-final]), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for err == nil {
+			err = dec.Decode(new(codecRecord))
+		}
+		if !errors.Is(err, ErrTruncated) {
+			t.Fatalf("truncated stream: %v", err)
+		}
+	}
+}
+
+func TestDecoderNotEncoded(t *testing.T) {
+	key := randKey()
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, key)
+	w.Write([]byte("{}"))
+	w.Close()
+	if _, err := NewDecoder(&buf, key); err == nil {
+		t.Fatal("plain stream decoded")
+	}
+}
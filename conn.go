@@ -0,0 +1,775 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// connections start with a handshake:
+//
+//	client -> server: hello
+//	server -> client: hello | server finished
+//	client -> server: client finished
+//
+// hello is connMagic | version (1) | cipher (1) | has static key (1) |
+// largest frame (4) | ephemeral X25519 key (32) | static X25519 key (32,
+// if present). both sides send frames of at most the smaller of the two
+// largest frames, and refuse longer ones before reading them. the
+// secret is HKDF-SHA256 with the pre-shared key as salt over X25519 of the
+// ephemeral keys, then of the client's ephemeral and the server's static
+// key and of the client's static and the server's ephemeral key, when
+// those are present. the finished messages and a key for each direction are
+// expanded from it with the SHA-256 of both hellos. after that each
+// direction is a sequence of frames: length (4) | ciphertext | tag, sealed
+// with the sender's cipher, a nonce holding the frame's sequence number
+// and that number as additional data. Close sends an empty frame with the
+// top bit of its sequence number set, so a cut connection can be told from
+// a closed one. control frames have the second bit set instead and hold a
+// single byte, connPing or connPong. ExportKeyingMaterial expands from an exporter secret,
+// also expanded from the secret, that is never used for anything else.
+const (
+	connMagic   = "CRYPTCON"
+	connVersion = 1
+	connHello   = len(connMagic) + 3 + 4 + 32
+
+	// connInfo prefixes the labels of every key of a connection
+	connInfo = "crypt conn v1\x00"
+
+	// controlFrame marks the sequence number of a control frame
+	controlFrame = 1 << 62
+
+	// control frame types, a ping is answered with a pong
+	connPing = 1
+	connPong = 2
+)
+
+// errPeerDead is returned by Read when nothing arrived for the timeout of
+// WithKeepAlive.
+var errPeerDead = errors.New("crypt: peer sent nothing within the keep-alive timeout")
+
+// WithConnIdentity authenticates a connection made by Client, Server, Dial
+// or Listen with X25519 keys, instead of or on top of a pre-shared key.
+// priv is this side's key, it may be nil for a side that only checks who
+// it is talking to. peers are the keys the other side may use, with none
+// any key is accepted, so without a pre-shared key this side doesn't know
+// who it is talking to, like a TLS server that doesn't ask for client
+// certificates. static public keys are sent in the clear.
+func WithConnIdentity(priv *PrivateKey, peers ...*PublicKey) Option {
+	return func(c *config) error {
+		c.connIdentity = &connIdentity{priv: priv, peers: slices.Clone(peers)}
+		return nil
+	}
+}
+
+// WithPeerVerifier makes a connection call verify with the static key the
+// other side sent, after any check against the peers of WithConnIdentity,
+// for keys that are looked up or pinned elsewhere. an error from verify,
+// or a peer that sent no key, fails the handshake with a *PeerKeyError.
+// the handshake fails anyway if the peer doesn't hold the private key.
+func WithPeerVerifier(verify func(*PublicKey) error) Option {
+	return func(c *config) error {
+		if verify == nil {
+			return errors.New("crypt: nil peer verifier")
+		}
+		c.peerVerifier = verify
+		return nil
+	}
+}
+
+// PeerKeyError is returned by the handshake when the other side's key is
+// not accepted, it matches ErrAuthenticationFailed.
+type PeerKeyError struct {
+	// Key is the key the peer sent, nil if it sent none
+	Key *PublicKey
+
+	// Fingerprint is the SHA-256 of Key in hex, empty if it sent none
+	Fingerprint string
+
+	// Err is what the verifier of WithPeerVerifier returned, nil when the
+	// key isn't one of the peers of WithConnIdentity
+	Err error
+}
+
+// newPeerKeyError returns a PeerKeyError for key, which may be nil.
+func newPeerKeyError(key []byte, err error) *PeerKeyError {
+	e := &PeerKeyError{Err: err}
+	if key != nil {
+		sum := sha256.Sum256(key)
+		e.Key = (*PublicKey)(bytes.Clone(key))
+		e.Fingerprint = hex.EncodeToString(sum[:])
+	}
+	return e
+}
+
+func (e *PeerKeyError) Error() string {
+	msg := "crypt: peer sent no key"
+	if e.Key != nil {
+		msg = "crypt: peer's key " + e.Fingerprint + " is not accepted"
+	}
+	if e.Err != nil {
+		msg += ": " + strings.TrimPrefix(e.Err.Error(), "crypt: ")
+	}
+	return msg
+}
+
+func (e *PeerKeyError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{ErrAuthenticationFailed}
+	}
+	return []error{ErrAuthenticationFailed, e.Err}
+}
+
+// WithKeepAlive makes a connection send an authenticated ping whenever it
+// has sent nothing for interval, so an idle connection isn't dropped by a
+// NAT or firewall, and makes Read fail once nothing at all arrived for
+// timeout, pings included, so a dead peer is noticed. a timeout of 0 only
+// sends pings. pings are answered by the other side's Read, with or
+// without the option, so it has to keep a Read pending, as a server does,
+// or set the option too. interval has to be less than timeout.
+func WithKeepAlive(interval, timeout time.Duration) Option {
+	return func(c *config) error {
+		if interval <= 0 || timeout < 0 || timeout != 0 && timeout <= interval {
+			return errors.New("crypt: keep-alive interval has to be positive and less than the timeout")
+		}
+		c.keepAlive, c.keepAliveTimeout = interval, timeout
+		return nil
+	}
+}
+
+// connIdentity is the X25519 side of connection authentication.
+type connIdentity struct {
+	priv  *PrivateKey
+	peers []*PublicKey
+}
+
+// Conn is a net.Conn encrypted and authenticated with a key shared by
+// both ends, or X25519 keys with WithConnIdentity, for internal tools that
+// want an encrypted connection without certificates. the handshake gives
+// forward secrecy, a pre-shared key or private key that leaks later doesn't
+// reveal past connections. it runs on the first Read or Write, or call
+// Handshake. Read returns ErrTruncated if the connection ends without the
+// other side calling Close. there is no session resumption or early data,
+// every connection runs the full handshake, keep one open with
+// WithKeepAlive rather than reconnecting often. for many logical streams
+// over one connection run a multiplexer such as yamux on top of a Conn.
+type Conn struct {
+	net.Conn
+
+	isClient bool
+	psk      *[32]byte
+	id       *connIdentity
+	cipher   Cipher
+	policy   *SecurityPolicy
+
+	// frame is the largest frame this side wants, after the handshake
+	// the smaller of it and the other side's
+	frame int
+
+	handshakeMu  sync.Mutex
+	handshakeErr error
+	handshaked   bool
+
+	// peerKey is the static key the other side sent, nil if it sent none
+	peerKey *PublicKey
+
+	// verify checks peerKey, see WithPeerVerifier
+	verify func(*PublicKey) error
+
+	// exporter is the secret ExportKeyingMaterial expands
+	exporter []byte
+
+	// keepAlive and keepAliveTimeout are from WithKeepAlive, stop ends
+	// the goroutine sending pings
+	keepAlive        time.Duration
+	keepAliveTimeout time.Duration
+	stop             chan struct{}
+	stopOnce         sync.Once
+
+	// readDeadline is the deadline set by SetDeadline or
+	// SetReadDeadline, which the keep-alive timeout mustn't outlast
+	deadlineMu   sync.Mutex
+	readDeadline time.Time
+
+	wmu  sync.Mutex
+	out  cipher.AEAD
+	wseq uint64
+	wbuf []byte
+	werr error
+
+	// lastWrite is when a frame was last sent
+	lastWrite time.Time
+
+	rmu   sync.Mutex
+	in    cipher.AEAD
+	rseq  uint64
+	rbuf  []byte
+	plain []byte
+	rerr  error
+}
+
+// Client returns a Conn for the client side of conn. key is the
+// pre-shared key, it may be nil with WithConnIdentity. WithCipher sets the
+// cipher this side sends with, the other side may use a different one
+// that WithSecurityPolicy allows. WithChunkSize sets the largest frame,
+// both sides use the smaller of theirs.
+func Client(conn net.Conn, key *[32]byte, opts ...Option) (*Conn, error) {
+	return newConn(conn, true, key, opts)
+}
+
+// Server returns a Conn for the server side of conn, see Client.
+func Server(conn net.Conn, key *[32]byte, opts ...Option) (*Conn, error) {
+	return newConn(conn, false, key, opts)
+}
+
+func newConn(conn net.Conn, isClient bool, key *[32]byte, opts []Option) (*Conn, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil && c.connIdentity == nil {
+		return nil, errors.New("crypt: connections need a pre-shared key or WithConnIdentity")
+	}
+	if err := c.policy.check(c.cipher, headerVersion); err != nil {
+		return nil, err
+	}
+
+	return &Conn{
+		Conn: conn, isClient: isClient, psk: key, id: c.connIdentity, cipher: c.cipher, policy: c.policy, frame: c.chunkSize,
+		verify: c.peerVerifier, keepAlive: c.keepAlive, keepAliveTimeout: c.keepAliveTimeout, stop: make(chan struct{}),
+	}, nil
+}
+
+// Dial connects to address and runs the client handshake, see Client.
+func Dial(network, address string, key *[32]byte, opts ...Option) (*Conn, error) {
+	nc, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Client(nc, key, opts...)
+	if err == nil {
+		err = c.Handshake()
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Listen listens on address and returns a listener whose connections are
+// wrapped with Server. the handshake is left to the first Read or Write,
+// so a slow client doesn't hold up Accept.
+func Listen(network, address string, key *[32]byte, opts ...Option) (net.Listener, error) {
+	if _, err := newConn(nil, false, key, opts); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &connListener{Listener: l, key: key, opts: slices.Clone(opts)}, nil
+}
+
+type connListener struct {
+	net.Listener
+	key  *[32]byte
+	opts []Option
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	nc, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return Server(nc, l.key, l.opts...)
+}
+
+// PeerKey returns the static key the other side authenticated with, nil
+// if it used none or the handshake hasn't run.
+func (c *Conn) PeerKey() *PublicKey {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+	return c.peerKey
+}
+
+// Handshake runs the handshake if it hasn't run yet. a wrong key or an
+// unexpected peer fails with ErrAuthenticationFailed.
+func (c *Conn) Handshake() error {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+	if !c.handshaked {
+		c.handshakeErr = c.handshake()
+		c.handshaked = true
+		if c.handshakeErr != nil {
+			c.peerKey = nil
+		}
+	}
+	return c.handshakeErr
+}
+
+func (c *Conn) handshake() error {
+	eph, err := ecdh.X25519().GenerateKey(randReader)
+	if err != nil {
+		return err
+	}
+	var static *ecdh.PrivateKey
+	if c.id != nil && c.id.priv != nil {
+		if static, err = ecdh.X25519().NewPrivateKey(c.id.priv[:]); err != nil {
+			return err
+		}
+	}
+
+	hello := append([]byte(connMagic), connVersion, byte(c.cipher), 0)
+	hello = binary.BigEndian.AppendUint32(hello, uint32(c.frame))
+	hello = append(hello, eph.PublicKey().Bytes()...)
+	if static != nil {
+		hello[len(connMagic)+2] = 1
+		hello = append(hello, static.PublicKey().Bytes()...)
+	}
+
+	var peer []byte
+	if c.isClient {
+		if err := writeAll(c.Conn, hello); err != nil {
+			return err
+		}
+		if peer, err = readHello(c.Conn); err != nil {
+			return err
+		}
+	} else {
+		if peer, err = readHello(c.Conn); err != nil {
+			return err
+		}
+	}
+	peerCipher := Cipher(peer[len(connMagic)+1])
+	if err := c.policy.check(peerCipher, headerVersion); err != nil {
+		return err
+	}
+	peerFrame := int(binary.BigEndian.Uint32(peer[len(connMagic)+3:]))
+	if peerFrame < 1 || peerFrame > maxChunkSize {
+		return errors.New("crypt: peer asked for an invalid frame size")
+	}
+	peerEph := peer[len(connMagic)+7 : connHello]
+	var peerStatic []byte
+	if len(peer) > connHello {
+		peerStatic = peer[connHello:]
+		c.peerKey = (*PublicKey)(bytes.Clone(peerStatic))
+	}
+	if c.id != nil && len(c.id.peers) > 0 {
+		if peerStatic == nil || !slices.ContainsFunc(c.id.peers, func(k *PublicKey) bool { return bytes.Equal(k[:], peerStatic) }) {
+			return newPeerKeyError(peerStatic, nil)
+		}
+	}
+	if c.verify != nil {
+		if peerStatic == nil {
+			return newPeerKeyError(nil, nil)
+		}
+		if err := c.verify((*PublicKey)(bytes.Clone(peerStatic))); err != nil {
+			return newPeerKeyError(peerStatic, err)
+		}
+	}
+
+	// the DH results are always in client, server order
+	ikm, err := dh(eph, peerEph)
+	if err != nil {
+		return err
+	}
+	clientHello, serverHello := hello, peer
+	var es, se []byte
+	if c.isClient {
+		if peerStatic != nil {
+			es, err = dh(eph, peerStatic)
+		}
+		if err == nil && static != nil {
+			se, err = dh(static, peerEph)
+		}
+	} else {
+		clientHello, serverHello = peer, hello
+		if static != nil {
+			es, err = dh(static, peerEph)
+		}
+		if err == nil && peerStatic != nil {
+			se, err = dh(eph, peerStatic)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	ikm = append(append(ikm, es...), se...)
+
+	var salt []byte
+	if c.psk != nil {
+		salt = c.psk[:]
+	}
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return err
+	}
+	transcript := sha256.Sum256(append(bytes.Clone(clientHello), serverHello...))
+	expand := func(label string) ([]byte, error) {
+		return hkdf.Expand(sha256.New, prk, connInfo+label+string(transcript[:]), 32)
+	}
+	clientFinished, err := expand("client finished")
+	if err != nil {
+		return err
+	}
+	serverFinished, err := expand("server finished")
+	if err != nil {
+		return err
+	}
+	clientKey, err := expand("client key")
+	if err != nil {
+		return err
+	}
+	serverKey, err := expand("server key")
+	if err != nil {
+		return err
+	}
+	exporter, err := expand("exporter")
+	if err != nil {
+		return err
+	}
+
+	finished := make([]byte, 32)
+	badPeer := &detailError{msg: "crypt: connection handshake failed, the keys don't match", err: ErrAuthenticationFailed}
+	if c.isClient {
+		if _, err := io.ReadFull(c.Conn, finished); err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare(finished, serverFinished) != 1 {
+			return badPeer
+		}
+		if err := writeAll(c.Conn, clientFinished); err != nil {
+			return err
+		}
+	} else {
+		if err := writeAll(c.Conn, append(hello, serverFinished...)); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(c.Conn, finished); err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare(finished, clientFinished) != 1 {
+			return badPeer
+		}
+	}
+
+	outKey, inKey := clientKey, serverKey
+	if !c.isClient {
+		outKey, inKey = serverKey, clientKey
+	}
+	if c.out, err = c.cipher.NewAEAD((*[32]byte)(outKey)); err != nil {
+		return err
+	}
+	if c.in, err = peerCipher.NewAEAD((*[32]byte)(inKey)); err != nil {
+		return err
+	}
+	c.frame = min(c.frame, peerFrame)
+	c.exporter = exporter
+	if c.keepAlive > 0 {
+		c.lastWrite = time.Now()
+		go c.pinger()
+	}
+	return nil
+}
+
+// pinger sends a ping whenever nothing was sent for the keep-alive
+// interval, until the connection is closed or a write fails.
+func (c *Conn) pinger() {
+	t := time.NewTimer(c.keepAlive)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+		}
+
+		c.wmu.Lock()
+		if c.werr != nil {
+			c.wmu.Unlock()
+			return
+		}
+		if time.Since(c.lastWrite) >= c.keepAlive {
+			if err := c.writeFrame([]byte{connPing}, c.wseq|controlFrame); err != nil {
+				c.werr = err
+			}
+		}
+		next := time.Until(c.lastWrite.Add(c.keepAlive))
+		c.wmu.Unlock()
+		t.Reset(next)
+	}
+}
+
+// pong answers a ping, on its own goroutine so Read doesn't wait for a
+// Write in progress.
+func (c *Conn) pong() {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.werr == nil {
+		if err := c.writeFrame([]byte{connPong}, c.wseq|controlFrame); err != nil {
+			c.werr = err
+		}
+	}
+}
+
+// ExportKeyingMaterial returns length bytes of keying material bound to
+// this connection, label and context, like the TLS exporter, running the
+// handshake first if it hasn't run. both sides get the same bytes, for
+// deriving more keys such as one to sign application tokens that only
+// hold on this connection. labels of different uses must differ and can't
+// hold a NUL byte, context may be nil. length can be at most 8160.
+func (c *Conn) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	if strings.IndexByte(label, 0) >= 0 {
+		return nil, errors.New("crypt: exporter label holds a NUL byte")
+	}
+	if err := c.Handshake(); err != nil {
+		return nil, err
+	}
+	info := connInfo + "export\x00" + label + "\x00" + string(context)
+	return hkdf.Expand(sha256.New, c.exporter, info, length)
+}
+
+// readHello reads the other side's hello.
+func readHello(r io.Reader) ([]byte, error) {
+	hello := make([]byte, connHello, connHello+32)
+	if _, err := io.ReadFull(r, hello); err != nil {
+		return nil, err
+	}
+	if string(hello[:len(connMagic)]) != connMagic || hello[len(connMagic)] != connVersion {
+		return nil, errors.New("crypt: not an encrypted connection")
+	}
+	switch hello[len(connMagic)+2] {
+	case 0:
+	case 1:
+		hello = hello[:connHello+32]
+		if _, err := io.ReadFull(r, hello[connHello:]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("crypt: not an encrypted connection")
+	}
+	return hello, nil
+}
+
+// dh is X25519 between priv and the public key peer.
+func dh(priv *ecdh.PrivateKey, peer []byte) ([]byte, error) {
+	pk, err := ecdh.X25519().NewPublicKey(peer)
+	if err != nil {
+		return nil, err
+	}
+	// fails for low order points, which would give a known secret
+	return priv.ECDH(pk)
+}
+
+func writeAll(w io.Writer, b []byte) error {
+	n, err := w.Write(b)
+	if err == nil && n != len(b) {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+// Write encrypts p in frames of up to the chunk size and sends them.
+func (c *Conn) Write(p []byte) (total int, err error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.werr != nil {
+		return 0, c.werr
+	}
+
+	for len(p) > 0 {
+		n := min(len(p), c.frame)
+		if err := c.writeFrame(p[:n], c.wseq); err != nil {
+			c.werr = err
+			return total, err
+		}
+		p = p[n:]
+		total += n
+	}
+	return total, nil
+}
+
+// Close tells the other side the connection ended cleanly and closes the
+// underlying connection.
+func (c *Conn) Close() error {
+	c.stopOnce.Do(func() { close(c.stop) })
+
+	c.handshakeMu.Lock()
+	done := c.handshaked && c.handshakeErr == nil
+	c.handshakeMu.Unlock()
+
+	if done {
+		c.wmu.Lock()
+		if c.werr == nil {
+			c.writeFrame(nil, c.wseq|finalChunk)
+			c.werr = ErrClosed
+		}
+		c.wmu.Unlock()
+	}
+	return c.Conn.Close()
+}
+
+func (c *Conn) writeFrame(p []byte, seq uint64) error {
+	nonce := make([]byte, c.out.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	var aad [sequenceSize]byte
+	binary.BigEndian.PutUint64(aad[:], seq)
+
+	buf := binary.BigEndian.AppendUint32(c.wbuf[:0], uint32(len(p)+c.out.Overhead()))
+	buf = c.out.Seal(buf, nonce, p, aad[:])
+	c.wbuf = buf
+	c.wseq++
+	c.lastWrite = time.Now()
+	return writeAll(c.Conn, buf)
+}
+
+// SetDeadline sets the read and write deadlines of the underlying
+// connection, see net.Conn.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	c.readDeadline = t
+	c.deadlineMu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the underlying connection,
+// see net.Conn.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	c.readDeadline = t
+	c.deadlineMu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// readFull reads len(b) bytes for readFrame. with a keep-alive timeout the
+// read fails with errPeerDead once nothing arrived for it, unless the
+// deadline set by SetReadDeadline comes first.
+func (c *Conn) readFull(b []byte) error {
+	if c.keepAliveTimeout == 0 {
+		_, err := io.ReadFull(c.Conn, b)
+		return err
+	}
+
+	c.deadlineMu.Lock()
+	user := c.readDeadline
+	c.deadlineMu.Unlock()
+	for len(b) > 0 {
+		deadline := time.Now().Add(c.keepAliveTimeout)
+		if !user.IsZero() && user.Before(deadline) {
+			deadline = user
+		}
+		if err := c.Conn.SetReadDeadline(deadline); err != nil {
+			return err
+		}
+		n, err := c.Conn.Read(b)
+		b = b[n:]
+		if n > 0 {
+			continue
+		}
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() && !deadline.Equal(user) {
+			return errPeerDead
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return c.Conn.SetReadDeadline(user)
+}
+
+// Read decrypts the next frame into p, returning io.EOF once the other
+// side has closed the connection.
+func (c *Conn) Read(p []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	for len(c.plain) == 0 {
+		if c.rerr != nil {
+			return 0, c.rerr
+		}
+		if err := c.readFrame(); err != nil {
+			c.rerr = err
+			return 0, err
+		}
+	}
+	n := copy(p, c.plain)
+	c.plain = c.plain[n:]
+	return n, nil
+}
+
+func (c *Conn) readFrame() error {
+	var length [4]byte
+	if err := c.readFull(length[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return err
+	}
+	n := int(binary.BigEndian.Uint32(length[:]))
+	overhead := c.in.Overhead()
+	if n < overhead || n > c.frame+overhead {
+		return ErrAuthenticationFailed
+	}
+	if cap(c.rbuf) < n {
+		c.rbuf = make([]byte, n)
+	}
+	buf := c.rbuf[:n]
+	if err := c.readFull(buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return err
+	}
+
+	seq := c.rseq
+	nonce := make([]byte, c.in.NonceSize())
+	var aad [sequenceSize]byte
+	if n == overhead {
+		// maybe the close frame
+		binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq|finalChunk)
+		binary.BigEndian.PutUint64(aad[:], seq|finalChunk)
+		if _, err := c.in.Open(nil, nonce, buf, aad[:]); err == nil {
+			return io.EOF
+		}
+	}
+	if n == overhead+1 {
+		// maybe a control frame
+		binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq|controlFrame)
+		binary.BigEndian.PutUint64(aad[:], seq|controlFrame)
+		if msg, err := c.in.Open(nil, nonce, buf, aad[:]); err == nil {
+			c.rseq++
+			if msg[0] == connPing {
+				go c.pong()
+			}
+			return nil
+		}
+	}
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	binary.BigEndian.PutUint64(aad[:], seq)
+	plain, err := c.in.Open(buf[:0], nonce, buf, aad[:])
+	if err != nil {
+		return ErrAuthenticationFailed
+	}
+	c.rseq++
+	c.plain = plain
+	return nil
+}
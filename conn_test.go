@@ -0,0 +1,359 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// connPair runs a handshake over a pipe, returning both ends and the
+// handshake errors.
+func connPair(t *testing.T, clientKey, serverKey *[32]byte, clientOpts, serverOpts []Option) (*Conn, *Conn, error, error) {
+	t.Helper()
+	a, b := net.Pipe()
+	client, err := Client(a, clientKey, clientOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := Server(b, serverKey, serverOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		err := server.Handshake()
+		if err != nil {
+			b.Close()
+		}
+		errc <- err
+	}()
+	cerr := client.Handshake()
+	if cerr != nil {
+		a.Close()
+	}
+	return client, server, cerr, <-errc
+}
+
+func TestConn(t *testing.T) {
+	key := randKey()
+	client, server, cerr, serr := connPair(t, key, key, []Option{WithCipher(ChaCha20Poly1305), WithChunkSize(1000)}, nil)
+	if cerr != nil || serr != nil {
+		t.Fatal(cerr, serr)
+	}
+
+	msg := randBytes(10_000)
+	go func() {
+		client.Write(msg)
+		client.Close()
+	}()
+	got, err := io.ReadAll(server)
+	if err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("ReadAll: %d bytes, %v", len(got), err)
+	}
+
+	// the server went away without Close
+	client, server, cerr, serr = connPair(t, key, key, nil, nil)
+	if cerr != nil || serr != nil {
+		t.Fatal(cerr, serr)
+	}
+	go func() {
+		server.Write([]byte("pong"))
+		server.Conn.Close()
+	}()
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+
+	if _, err := client.Read(buf); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("cut connection: %v", err)
+	}
+}
+
+func TestConnWrongKey(t *testing.T) {
+	_, _, cerr, serr := connPair(t, randKey(), randKey(), nil, nil)
+	if !errors.Is(cerr, ErrAuthenticationFailed) || serr == nil {
+		t.Fatalf("client %v, server %v", cerr, serr)
+	}
+}
+
+func TestConnPolicy(t *testing.T) {
+	key := randKey()
+	strict := WithSecurityPolicy(SecurityPolicy{Ciphers: []Cipher{AES256GCMSIV}})
+
+	// the server doesn't let the client send with a cipher its policy
+	// forbids, even though its own is allowed
+	_, _, _, serr := connPair(t, key, key, []Option{WithCipher(ChaCha20Poly1305)}, []Option{WithCipher(AES256GCMSIV), strict})
+	if serr == nil {
+		t.Fatal("server accepted a cipher its policy forbids")
+	}
+	_, _, cerr, serr := connPair(t, key, key, []Option{WithCipher(AES256GCMSIV)}, []Option{WithCipher(AES256GCMSIV), strict})
+	if cerr != nil || serr != nil {
+		t.Fatal(cerr, serr)
+	}
+}
+
+func TestConnFrameSize(t *testing.T) {
+	key := randKey()
+	client, server, cerr, serr := connPair(t, key, key, []Option{WithChunkSize(1 << 20)}, []Option{WithChunkSize(1000)})
+	if cerr != nil || serr != nil {
+		t.Fatal(cerr, serr)
+	}
+	if client.frame != 1000 || server.frame != 1000 {
+		t.Fatalf("frame sizes %d and %d, want the smaller", client.frame, server.frame)
+	}
+
+	msg := randBytes(10_000)
+	go func() {
+		client.Write(msg)
+		// a frame longer than agreed is refused from its length alone
+		client.Conn.Write([]byte{0, 1, 0, 0})
+	}()
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(server, got); err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if _, err := server.Read(got); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("long frame: %v", err)
+	}
+}
+
+func TestConnIdentity(t *testing.T) {
+	serverPub, serverPriv, _ := GenerateKeyPair()
+	clientPub, clientPriv, _ := GenerateKeyPair()
+	_, otherPriv, _ := GenerateKeyPair()
+
+	// the client checks the server, the server accepts any client
+	client, server, cerr, serr := connPair(t, nil, nil,
+		[]Option{WithConnIdentity(nil, serverPub)},
+		[]Option{WithConnIdentity(serverPriv)})
+	if cerr != nil || serr != nil {
+		t.Fatal(cerr, serr)
+	}
+	if !bytes.Equal(client.PeerKey()[:], serverPub[:]) || server.PeerKey() != nil {
+		t.Fatal("wrong peer keys")
+	}
+
+	// mutual
+	_, server, cerr, serr = connPair(t, nil, nil,
+		[]Option{WithConnIdentity(clientPriv, serverPub)},
+		[]Option{WithConnIdentity(serverPriv, clientPub)})
+	if cerr != nil || serr != nil {
+		t.Fatal(cerr, serr)
+	}
+	if !bytes.Equal(server.PeerKey()[:], clientPub[:]) {
+		t.Fatal("server didn't see the client's key")
+	}
+
+	// a server pretending with the wrong private key but the right public
+	// one can't finish the handshake
+	_, _, cerr, _ = connPair(t, nil, nil,
+		[]Option{WithConnIdentity(nil, serverPub)},
+		[]Option{WithConnIdentity(otherPriv)})
+	if !errors.Is(cerr, ErrAuthenticationFailed) {
+		t.Fatalf("impostor server: %v", cerr)
+	}
+
+	// an unknown client is turned away, and the error names its key
+	_, _, _, serr = connPair(t, nil, nil,
+		[]Option{WithConnIdentity(otherPriv, serverPub)},
+		[]Option{WithConnIdentity(serverPriv, clientPub)})
+	var pke *PeerKeyError
+	if !errors.Is(serr, ErrAuthenticationFailed) || !errors.As(serr, &pke) || !bytes.Equal(pke.Key[:], otherPriv.Public()[:]) || pke.Fingerprint == "" {
+		t.Fatalf("unknown client: %v", serr)
+	}
+}
+
+func TestConnPeerVerifier(t *testing.T) {
+	serverPub, serverPriv, _ := GenerateKeyPair()
+	clientPub, clientPriv, _ := GenerateKeyPair()
+	errRevoked := errors.New("key is revoked")
+
+	var seen *PublicKey
+	accept := WithPeerVerifier(func(k *PublicKey) error { seen = k; return nil })
+	_, _, cerr, serr := connPair(t, nil, nil,
+		[]Option{WithConnIdentity(clientPriv, serverPub)},
+		[]Option{WithConnIdentity(serverPriv), accept})
+	if cerr != nil || serr != nil {
+		t.Fatal(cerr, serr)
+	}
+	if seen == nil || !bytes.Equal(seen[:], clientPub[:]) {
+		t.Fatal("verifier didn't see the client's key")
+	}
+
+	reject := WithPeerVerifier(func(*PublicKey) error { return errRevoked })
+	_, _, _, serr = connPair(t, nil, nil,
+		[]Option{WithConnIdentity(clientPriv, serverPub)},
+		[]Option{WithConnIdentity(serverPriv), reject})
+	var pke *PeerKeyError
+	if !errors.Is(serr, ErrAuthenticationFailed) || !errors.Is(serr, errRevoked) || !errors.As(serr, &pke) || !bytes.Equal(pke.Key[:], clientPub[:]) {
+		t.Fatalf("rejected client: %v", serr)
+	}
+
+	// a client without a key can't be verified
+	_, _, _, serr = connPair(t, nil, nil,
+		[]Option{WithConnIdentity(nil, serverPub)},
+		[]Option{WithConnIdentity(serverPriv), accept})
+	if !errors.As(serr, &pke) || pke.Key != nil {
+		t.Fatalf("client without a key: %v", serr)
+	}
+}
+
+func TestDialListen(t *testing.T) {
+	key := randKey()
+	l, err := Listen("tcp", "127.0.0.1:0", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, io.LimitReader(c, 5))
+	}()
+
+	c, err := Dial("tcp", l.Addr().String(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("echo: %q, %v", buf, err)
+	}
+	if _, err := c.Read(buf); err != io.EOF {
+		t.Fatalf("after the server closed: %v", err)
+	}
+}
+
+func TestConnExportKeyingMaterial(t *testing.T) {
+	key := randKey()
+	client, server, cerr, serr := connPair(t, key, key, nil, nil)
+	if cerr != nil || serr != nil {
+		t.Fatal(cerr, serr)
+	}
+	a, err := client.ExportKeyingMaterial("tokens", []byte("user 1"), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := server.ExportKeyingMaterial("tokens", []byte("user 1"), 32)
+	if err != nil || !bytes.Equal(a, b) {
+		t.Fatalf("the sides export different material: %v", err)
+	}
+	for _, other := range [][]byte{
+		mustExport(t, client, "other", []byte("user 1"), 32),
+		mustExport(t, client, "tokens", []byte("user 2"), 32),
+		mustExport(t, client, "tokens", nil, 32),
+	} {
+		if bytes.Equal(a, other) {
+			t.Fatal("different labels or contexts export the same material")
+		}
+	}
+	if _, err := client.ExportKeyingMaterial("tokens", nil, 255*32+1); err == nil {
+		t.Fatal("exported more than HKDF can")
+	}
+	if _, err := client.ExportKeyingMaterial("tokens\x00user 1", nil, 32); err == nil {
+		t.Fatal("a label with a NUL byte was accepted")
+	}
+
+	// another connection with the same key exports something else
+	client2, _, cerr, serr := connPair(t, key, key, nil, nil)
+	if cerr != nil || serr != nil {
+		t.Fatal(cerr, serr)
+	}
+	if bytes.Equal(a, mustExport(t, client2, "tokens", []byte("user 1"), 32)) {
+		t.Fatal("material isn't bound to the connection")
+	}
+}
+
+func mustExport(t *testing.T, c *Conn, label string, context []byte, length int) []byte {
+	t.Helper()
+	b, err := c.ExportKeyingMaterial(label, context, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestConnKeepAlive(t *testing.T) {
+	key := randKey()
+	keepAlive := []Option{WithKeepAlive(10*time.Millisecond, 200*time.Millisecond)}
+
+	// the client only hears the server's pongs while both are idle, far
+	// longer than the timeout
+	client, server, cerr, serr := connPair(t, key, key, keepAlive, nil)
+	if cerr != nil || serr != nil {
+		t.Fatal(cerr, serr)
+	}
+	got := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 5)
+		_, err := io.ReadFull(server, buf)
+		if err == nil && string(buf) != "hello" {
+			err = errors.New("got " + string(buf))
+		}
+		got <- err
+	}()
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Read(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(600 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("idle connection: %v", err)
+	default:
+	}
+	client.Write([]byte("hello"))
+	if err := <-got; err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	if err := <-done; err != io.EOF {
+		t.Fatalf("after Close: %v", err)
+	}
+
+	// a server that went quiet without closing is noticed
+	client, server, cerr, serr = connPair(t, key, key, keepAlive, nil)
+	if cerr != nil || serr != nil {
+		t.Fatal(cerr, serr)
+	}
+	go io.Copy(io.Discard, server.Conn)
+	start := time.Now()
+	if _, err := client.Read(make([]byte, 1)); err != errPeerDead {
+		t.Fatalf("dead peer: %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Fatal("dead peer took too long to notice")
+	}
+	client.Close()
+
+	// a deadline before the timeout is reported as a deadline
+	client, server, cerr, serr = connPair(t, key, key, keepAlive, nil)
+	if cerr != nil || serr != nil {
+		t.Fatal(cerr, serr)
+	}
+	go io.Copy(io.Discard, server.Conn)
+	client.SetReadDeadline(time.Now().Add(time.Millisecond))
+	var ne net.Error
+	if _, err := client.Read(make([]byte, 1)); !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("deadline: %v", err)
+	}
+	client.Close()
+
+	for _, bad := range [][2]time.Duration{{0, time.Second}, {time.Second, time.Second}, {time.Second, -1}} {
+		if _, err := Client(nil, key, WithKeepAlive(bad[0], bad[1])); err == nil {
+			t.Errorf("WithKeepAlive(%v, %v) accepted", bad[0], bad[1])
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package crypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// WithConvergentEncryption makes Encrypt and NewWriter derive nonces from
+// an HMAC of the plaintext under a key derived from convergenceKey instead
+// of reading them from the entropy source, so the same plaintext with the
+// same key, convergence key and options always encrypts to the same
+// ciphertext. that is what lets backup and dedup systems store identical
+// data once, and it is also the cost: anyone who sees two ciphertexts
+// learns whether their plaintexts are equal (for streams, whether chunks at
+// the same position are), and anyone holding the convergence key can
+// confirm a guess of the plaintext. use a secret convergence key per user
+// or tenant, never a well known one, and leave this off for anything that
+// doesn't need deduplicating.
+//
+// nonces are 96 bits of HMAC-SHA256 for most ciphers, so like any random
+// nonce they stay unique for far more distinct plaintexts than one key
+// should encrypt. it can't be combined with WithCounterNonces, whose
+// streams start from a random value, and password and recipient streams
+// have random salts and data keys so they never converge.
+func WithConvergentEncryption(convergenceKey *[32]byte) Option {
+	return func(c *config) error {
+		key, err := deriveKey(convergenceKey, convergentInfo)
+		if err != nil {
+			return err
+		}
+
+		c.convergence = key[:]
+		return nil
+	}
+}
+
+// convergentNonce returns a nonce of size bytes derived from parts with
+// the MAC key from WithConvergentEncryption. parts are length prefixed so
+// moving bytes between them changes the nonce.
+func convergentNonce(key []byte, size int, parts ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, p := range parts {
+		mac.Write(binary.BigEndian.AppendUint64(nil, uint64(len(p))))
+		mac.Write(p)
+	}
+
+	return mac.Sum(nil)[:size]
+}
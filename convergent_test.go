@@ -0,0 +1,86 @@
+package crypt
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// TestConvergentEncryption makes sure the same plaintext and keys give the
+// same ciphertext, which still decrypts as usual, and anything else
+// changes it.
+func TestConvergentEncryption(t *testing.T) {
+	t.Parallel()
+	key, ck := randKey(), randKey()
+	data := randBytes(1000)
+
+	a, err := Encrypt(data, key, WithConvergentEncryption(ck))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := Encrypt(bytes.Clone(data), key, WithConvergentEncryption(ck))
+	if !bytes.Equal(a, b) {
+		t.Fatal("the same plaintext encrypted differently")
+	}
+	if got, err := Decrypt(a, key); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("got %v", err)
+	}
+
+	other := bytes.Clone(data)
+	other[0] ^= 1
+	for name, c := range map[string][]byte{
+		"plaintext":       must(Encrypt(other, key, WithConvergentEncryption(ck))),
+		"convergence key": must(Encrypt(data, key, WithConvergentEncryption(randKey()))),
+		"aad":             must(Encrypt(data, key, WithConvergentEncryption(ck), WithAAD([]byte("x")))),
+		"no convergence":  must(Encrypt(data, key)),
+	} {
+		if bytes.Equal(a[1:1+DefaultCipher.nonceSize()], c[1:1+DefaultCipher.nonceSize()]) {
+			t.Fatalf("changing the %s kept the nonce", name)
+		}
+	}
+
+	seal := func(data []byte, concurrency int) []byte {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, key, WithChunkSize(64), WithConcurrency(concurrency), WithConvergentEncryption(ck))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	stream := seal(data, 1)
+	if !bytes.Equal(stream, seal(data, 4)) {
+		t.Fatal("the same stream encrypted differently")
+	}
+	r, _ := NewReader(bytes.NewReader(stream), key)
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("got %v", err)
+	}
+
+	// only the chunk that changed differs
+	changed := bytes.Clone(data)
+	changed[200] ^= 1
+	frame := sequenceSize + DefaultCipher.nonceSize() + DefaultCipher.tagSize() + 64
+	_, raw, _ := readHeader(bytes.NewReader(stream))
+	other = seal(changed, 1)
+	for i := range len(data)/64 + 1 {
+		chunk := func(s []byte) []byte { return s[len(raw)+i*frame : min(len(raw)+(i+1)*frame, len(s))] }
+		if same := bytes.Equal(chunk(stream), chunk(other)); same != (i != 200/64) {
+			t.Fatalf("chunk %d: same is %v", i, same)
+		}
+	}
+
+	if _, err := NewWriter(io.Discard, key, WithConvergentEncryption(ck), WithCounterNonces()); err == nil {
+		t.Fatal("convergent encryption was allowed with counter nonces")
+	}
+}
+
+func must(b []byte, err error) []byte {
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
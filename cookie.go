@@ -0,0 +1,88 @@
+package crypt
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"time"
+)
+
+// cookieInfo starts the additional data of cookies, ahead of their name.
+const cookieInfo = "crypt cookie v1\x00"
+
+// MaxCookieSize is the most browsers are required to store for a cookie,
+// name and value together.
+const MaxCookieSize = 4096
+
+// ErrCookieExpired is returned by DecodeCookie for a cookie past its
+// maxAge.
+var ErrCookieExpired = errors.New("crypt: cookie has expired")
+
+// EncodeCookie encrypts value for a cookie called name, returning it
+// base64url encoded. the cookie is bound to name, so it can't be passed off
+// as a different cookie, and with maxAge above 0 DecodeCookie refuses it
+// once that long has passed whatever the browser does. WithAAD binds it to
+// something more, such as a purpose. the result is an error if it would
+// go over MaxCookieSize, about 3000 bytes of value with the default cipher.
+func EncodeCookie(name string, value []byte, key *[32]byte, maxAge time.Duration, opts ...Option) (string, error) {
+	opts, err := cookieOpts(name, opts)
+	if err != nil {
+		return "", err
+	}
+
+	var expires int64
+	if maxAge > 0 {
+		expires = time.Now().Add(maxAge).Unix()
+	}
+	plain := binary.BigEndian.AppendUint64(nil, uint64(expires))
+	ct, err := Encrypt(append(plain, value...), key, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	s := base64.RawURLEncoding.EncodeToString(ct)
+	if len(name)+1+len(s) > MaxCookieSize {
+		return "", errors.New("crypt: cookie " + name + " is too big")
+	}
+	return s, nil
+}
+
+// DecodeCookie decrypts the value of a cookie called name made by
+// EncodeCookie, opts must match the ones it was given.
+func DecodeCookie(name, cookie string, key *[32]byte, opts ...Option) ([]byte, error) {
+	opts, err := cookieOpts(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(name)+1+len(cookie) > MaxCookieSize {
+		return nil, errors.New("crypt: cookie " + name + " is too big")
+	}
+
+	ct, err := base64.RawURLEncoding.DecodeString(cookie)
+	if err != nil {
+		return nil, errors.New("crypt: cookie " + name + " is not base64url")
+	}
+	plain, err := Decrypt(ct, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(plain) < 8 {
+		return nil, errors.New("crypt: cookie " + name + " is too short")
+	}
+	if expires := int64(binary.BigEndian.Uint64(plain)); expires != 0 && time.Now().Unix() >= expires {
+		return nil, ErrCookieExpired
+	}
+	return plain[8:], nil
+}
+
+// cookieOpts returns opts with the additional data replaced by the cookie
+// prefix, name and whatever additional data opts held.
+func cookieOpts(name string, opts []Option) ([]Option, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	aad := append([]byte(cookieInfo+name+"\x00"), c.aad...)
+	return append(slices.Clip(opts), WithAAD(aad)), nil
+}
@@ -0,0 +1,57 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookie(t *testing.T) {
+	key := randKey()
+	purpose := WithAAD([]byte("session"))
+
+	v, err := EncodeCookie("sid", []byte("user=42"), key, time.Hour, purpose)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the value needs no quoting in a Set-Cookie header
+	if c := (&http.Cookie{Name: "sid", Value: v}).String(); c != "sid="+v {
+		t.Fatalf("cookie %q", c)
+	}
+	got, err := DecodeCookie("sid", v, key, purpose)
+	if err != nil || string(got) != "user=42" {
+		t.Fatalf("DecodeCookie = %q, %v", got, err)
+	}
+
+	if _, err := DecodeCookie("other", v, key, purpose); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("renamed cookie: %v", err)
+	}
+	if _, err := DecodeCookie("sid", v, key, WithAAD([]byte("csrf"))); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("other purpose: %v", err)
+	}
+
+	expired, err := EncodeCookie("sid", []byte("x"), key, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeCookie("sid", expired, key); err != nil {
+		t.Fatalf("cookie without maxAge: %v", err)
+	}
+	expired, err = EncodeCookie("sid", []byte("x"), key, time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeCookie("sid", expired, key); !errors.Is(err, ErrCookieExpired) {
+		t.Fatalf("expired cookie: %v", err)
+	}
+
+	if _, err := EncodeCookie("sid", bytes.Repeat([]byte("a"), 3100), key, 0); err == nil {
+		t.Fatal("oversized cookie encoded")
+	}
+	if _, err := DecodeCookie("sid", strings.Repeat("A", MaxCookieSize), key); err == nil {
+		t.Fatal("oversized cookie decoded")
+	}
+}
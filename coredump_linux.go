@@ -0,0 +1,20 @@
+package crypt
+
+import "golang.org/x/sys/unix"
+
+// DisableCoreDumps stops the process from writing core dumps, so keys in
+// memory can't end up on disk when it crashes. on linux this also marks the
+// process undumpable, which stops other processes of the same user from
+// attaching to it or reading its memory.
+func DisableCoreDumps() error {
+	if err := unix.Setrlimit(unix.RLIMIT_CORE, &unix.Rlimit{}); err != nil {
+		return err
+	}
+
+	return unix.Prctl(unix.PR_SET_DUMPABLE, 0, 0, 0, 0)
+}
+
+// dontDump leaves b out of core dumps.
+func dontDump(b []byte) error {
+	return unix.Madvise(b, unix.MADV_DONTDUMP)
+}
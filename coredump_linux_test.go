@@ -0,0 +1,22 @@
+package crypt
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestDisableCoreDumps checks the core size limit is zero afterwards.
+func TestDisableCoreDumps(t *testing.T) {
+	if err := DisableCoreDumps(); err != nil {
+		t.Fatal(err)
+	}
+
+	var lim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_CORE, &lim); err != nil {
+		t.Fatal(err)
+	}
+	if lim.Cur != 0 {
+		t.Fatalf("core limit is %d", lim.Cur)
+	}
+}
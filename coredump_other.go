@@ -0,0 +1,11 @@
+//go:build !unix
+
+package crypt
+
+import "errors"
+
+// DisableCoreDumps stops the process from writing core dumps, it is not
+// supported on this platform.
+func DisableCoreDumps() error {
+	return errors.New("crypt: disabling core dumps is not supported on this platform")
+}
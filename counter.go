@@ -0,0 +1,99 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+)
+
+// noncePrefixSize is the size of the random per stream value streams made
+// with WithCounterNonces store in their header.
+const noncePrefixSize = 16
+
+// WithCounterNonces makes a Writer derive chunk nonces from the chunk
+// sequence number instead of storing a random nonce in every chunk. a
+// random value in the header gives every stream its own key, derived from
+// the key with HKDF, and the rest of the nonce. chunks are smaller by the
+// nonce size, no randomness is read per chunk and there is no limit on
+// how many chunks a key can seal. Readers handle these streams without
+// being told.
+func WithCounterNonces() Option {
+	return func(c *config) error {
+		c.counterNonces = true
+		return nil
+	}
+}
+
+// streamAEAD returns the AEAD for the chunks of the stream with header h.
+func streamAEAD(h *header, key *[32]byte) (cipher.AEAD, error) {
+	newAEAD := h.cipher.NewAEAD
+	if prefix, ok := h.fields[fieldNoncePrefix]; ok {
+		if len(prefix) != noncePrefixSize {
+			return nil, errBadHeader("invalid nonce prefix field")
+		}
+		newAEAD = func(key *[32]byte) (cipher.AEAD, error) {
+			return newCounterAEAD(h.cipher, key, prefix)
+		}
+	}
+
+	interval, err := h.rekeyInterval()
+	if err != nil {
+		return nil, err
+	}
+	if interval != 0 {
+		return newRekeyAEAD(newAEAD, key, interval)
+	}
+	return newAEAD(key)
+}
+
+// newCounterAEAD returns the counterAEAD for the stream key key.
+func newCounterAEAD(c Cipher, key *[32]byte, prefix []byte) (cipher.AEAD, error) {
+	b, err := hkdf.Key(sha256.New, key[:], prefix, counterNonceInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := c.NewAEAD((*[32]byte)(b))
+	if err != nil {
+		return nil, err
+	}
+
+	return &counterAEAD{aead: aead, prefix: prefix}, nil
+}
+
+// counterAEAD takes no nonce, it makes one from the sequence number at the
+// end of the additional data of every chunk (see chunkAAD) followed by as
+// much of the prefix as fits. the sequence number goes first since the
+// Cascade cipher uses only the start of the nonce for its inner cipher.
+type counterAEAD struct {
+	aead   cipher.AEAD
+	prefix []byte
+}
+
+func (c *counterAEAD) NonceSize() int {
+	return 0
+}
+
+func (c *counterAEAD) Overhead() int {
+	return c.aead.Overhead()
+}
+
+func (c *counterAEAD) nonce(aad []byte) []byte {
+	nonce := make([]byte, 0, c.aead.NonceSize())
+	nonce = append(nonce, aad[len(aad)-sequenceSize:]...)
+	return append(nonce, c.prefix[:c.aead.NonceSize()-sequenceSize]...)
+}
+
+func (c *counterAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != 0 || len(additionalData) < sequenceSize {
+		panic("crypt: counterAEAD used for something other than a chunk")
+	}
+	return c.aead.Seal(dst, c.nonce(additionalData), plaintext, additionalData)
+}
+
+func (c *counterAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != 0 || len(additionalData) < sequenceSize {
+		return nil, errors.New("crypt: counterAEAD used for something other than a chunk")
+	}
+	return c.aead.Open(dst, c.nonce(additionalData), ciphertext, additionalData)
+}
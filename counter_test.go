@@ -0,0 +1,82 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// TestCounterNonces makes sure streams with counter nonces round trip with
+// every cipher and reader, drop the per chunk nonce and still catch
+// reordered chunks.
+func TestCounterNonces(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 32
+	data := randBytes(5*chunkSize + 7)
+
+	for _, c := range ciphers {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, key, WithCipher(c), WithChunkSize(chunkSize), WithCounterNonces(), WithConcurrency(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		stream := buf.Bytes()
+
+		info, err := Inspect(bytes.NewReader(stream))
+		if err != nil || !info.CounterNonces {
+			t.Fatalf("%v: got %+v, %v", c, info, err)
+		}
+		frame := sequenceSize + chunkSize + c.tagSize()
+		if want := info.HeaderSize + 5*frame + sequenceSize + 7 + c.tagSize(); len(stream) != want {
+			t.Fatalf("%v: stream is %d bytes, want %d", c, len(stream), want)
+		}
+
+		r, err := NewReader(bytes.NewReader(stream), key, WithConcurrency(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%v: got %v", c, err)
+		}
+		ra, err := NewReaderAt(bytes.NewReader(stream), int64(len(stream)), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, 10)
+		if _, err := ra.ReadAt(got, chunkSize+3); err != nil || !bytes.Equal(got, data[chunkSize+3:chunkSize+13]) {
+			t.Fatalf("%v: ReadAt got %v", c, err)
+		}
+
+		// swap the first two chunks, sequence numbers included
+		swapped := bytes.Clone(stream)
+		first := stream[info.HeaderSize : info.HeaderSize+frame]
+		second := stream[info.HeaderSize+frame : info.HeaderSize+2*frame]
+		copy(swapped[info.HeaderSize:], second)
+		copy(swapped[info.HeaderSize+frame:], first)
+		r, _ = NewReader(bytes.NewReader(swapped), key)
+		if _, err := io.ReadAll(r); !errors.Is(err, ErrChunkOutOfOrder) {
+			t.Fatalf("%v: swapped chunks gave %v", c, err)
+		}
+	}
+
+	// every stream gets its own key, so the same data doesn't repeat
+	var a, b bytes.Buffer
+	for _, buf := range []*bytes.Buffer{&a, &b} {
+		w, _ := NewWriter(buf, key, WithCounterNonces())
+		w.Write(data)
+		w.Close()
+	}
+	if bytes.Equal(a.Bytes()[a.Len()-32:], b.Bytes()[b.Len()-32:]) {
+		t.Fatal("two streams sealed the same")
+	}
+
+	if _, err := NewWriter(io.Discard, key, WithCounterNonces(), WithArchiveProfile()); err == nil {
+		t.Fatal("counter nonces were allowed with the archive profile")
+	}
+}
@@ -3,200 +3,1374 @@
 package crypt
 
 import (
-	"crypto/aes"
+	"bytes"
+	"context"
 	"crypto/cipher"
+	"crypto/ed25519"
 	"crypto/rand"
+	"encoding/binary"
 	"errors"
+	"hash"
 	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // DefaultBlockSize is the default size for blocks / chunks of encrypted
-// data. can be changed in NewReader and NewWriter
+// data. can be changed with WithChunkSize
 const DefaultBlockSize = 32 * 1024
 
+// every chunk of a stream is framed as
+//
+//	sequence | nonce | ciphertext | tag
+//
+// where sequence is a big endian uint64 holding the chunk's index, with the
+// top bit set on the final chunk. the stream header and sequence are the
+// chunk's additional data, so chunks can't be reordered, dropped, duplicated
+// or moved between streams, and a stream cut short is detected because its
+// final chunk is missing. every chunk but the final one holds exactly the
+// chunk size of plaintext.
+const (
+	sequenceSize = 8
+	finalChunk   = 1 << 63
+)
+
+// randReader is where all randomness comes from, it is only ever replaced by
+// debug builds.
+var randReader io.Reader = rand.Reader
+
+var (
+	// ErrAuthenticationFailed is returned when a chunk fails to
+	// authenticate, because it was corrupted or the key is wrong. with the
+	// wrong key it is the very first chunk that fails.
+	ErrAuthenticationFailed = errors.New("crypt: chunk failed to authenticate")
+
+	// ErrTruncated is returned by a Reader when the stream ends before its
+	// final chunk.
+	ErrTruncated = errors.New("crypt: stream is truncated")
+
+	// ErrChunkOutOfOrder is returned by a Reader when a chunk authenticates
+	// but is not the next one in the stream, meaning chunks were reordered,
+	// duplicated or dropped.
+	ErrChunkOutOfOrder = errors.New("crypt: chunk is out of order")
+
+	// ErrOutOfOrder is the old name of ErrChunkOutOfOrder.
+	//
+	// Deprecated: use ErrChunkOutOfOrder.
+	ErrOutOfOrder = ErrChunkOutOfOrder
+
+	// ErrBadHeader is returned when a stream header is malformed or asks
+	// for something this version doesn't support.
+	ErrBadHeader = errors.New("crypt: invalid stream header")
+
+	// ErrKeyNotFound is returned when the key a stream needs isn't there,
+	// a key id that isn't in the Keyring or a stream not encrypted for an
+	// identity.
+	ErrKeyNotFound = errors.New("crypt: key not found")
+
+	// ErrWrongKey is returned when a stream made with WithKeyFingerprint
+	// is read with another key, it wraps ErrAuthenticationFailed.
+	ErrWrongKey error = &detailError{msg: "crypt: stream was encrypted with another key", err: ErrAuthenticationFailed}
+
+	// ErrClosed is returned when writing to a Writer after Close.
+	ErrClosed = errors.New("crypt: write to closed Writer")
+
+	// ErrExpired is returned for ciphertexts older than WithTTL allows.
+	ErrExpired = errors.New("crypt: ciphertext has expired")
+
+	// ErrDigestMismatch is returned by a Reader when the plaintext doesn't
+	// match the digest given with WithExpectedDigest.
+	ErrDigestMismatch = errors.New("crypt: plaintext digest doesn't match")
+
+	// ErrTooLarge is returned by Decrypt and a Reader when the plaintext
+	// is larger than WithMaxSize allows.
+	ErrTooLarge = errors.New("crypt: plaintext is larger than the size limit")
+)
+
+// errors returned by this package match the sentinels above with errors.Is,
+// and errors about a chunk are a *ChunkError saying which one.
+
+// ChunkError reports the chunk of a stream that failed to authenticate or
+// was out of place.
+type ChunkError struct {
+	// Chunk is the index of the chunk, counting from 0
+	Chunk int64
+
+	// Offset is where the chunk starts in the stream, or where it should
+	// have started for a truncated stream
+	Offset int64
+
+	// StreamID is the ID of the stream, see WithStreamID, empty if it
+	// has none
+	StreamID string
+
+	Err error
+}
+
+func (e *ChunkError) Error() string {
+	if e.StreamID != "" {
+		return "crypt: stream " + e.StreamID + " chunk " + strconv.FormatInt(e.Chunk, 10) + " at offset " + strconv.FormatInt(e.Offset, 10) + ": " + strings.TrimPrefix(e.Err.Error(), "crypt: ")
+	}
+	return "crypt: chunk " + strconv.FormatInt(e.Chunk, 10) + " at offset " + strconv.FormatInt(e.Offset, 10) + ": " + strings.TrimPrefix(e.Err.Error(), "crypt: ")
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// detailError is one of the sentinel errors with a more specific message.
+type detailError struct {
+	msg string
+	err error
+}
+
+func (e *detailError) Error() string {
+	return e.msg
+}
+
+func (e *detailError) Unwrap() error {
+	return e.err
+}
+
+// errBadHeader returns an ErrBadHeader saying what is wrong.
+func errBadHeader(msg string) error {
+	return &detailError{msg: "crypt: " + msg, err: ErrBadHeader}
+}
+
 // Reader implements the io.Reader interface, read data will be decrypted,
 // see NewReader for more information
 type Reader struct {
 	// r is the underlying reader
 	r io.Reader
 
-	// the gcm to be used
-	gcm cipher.AEAD
+	// the AEAD to be used
+	aead cipher.AEAD
+
+	// buf holds a batch of sealed chunks, one per unit of concurrency.
+	// chunk size comes from the stream header
+	buf         []byte
+	chunkSize   int
+	concurrency int
+
+	// plain is a buffer of plaintext, for when not all of a chunk is
+	// requested by the caller. chunks holds the rest of the batch
+	plain  []byte
+	chunks [][]byte
+
+	// header is the stream header as authenticated in the additional data
+	// of every chunk, see header.aad
+	header []byte
+
+	// seq is the index of the next chunk
+	seq uint64
+
+	// eof is set once the final chunk has been read
+	eof bool
+
+	// err is set once reading a chunk failed, the stream can't be trusted
+	// past that point so every later read fails with it too
+	err error
+
+	// withhold is the most plaintext buffered before anything is released,
+	// see WithholdUntilComplete. 0 releases each chunk once it
+	// authenticates
+	withhold int64
+
+	// metadata is from the header, see WithMetadata
+	metadata *Metadata
+
+	// verifier checks the signature of signed streams, see WithSigner
+	verifier *signVerifier
+
+	// start is the size of the header and frame the size of a chunk in
+	// the stream, group the parity group size, for ChunkError offsets
+	start int64
+	frame int64
+	group int64
+
+	progress progress
+
+	// ctx and limiter are from WithContext and WithRateLimit
+	ctx     context.Context
+	limiter *limiter
+
+	// aads, batch and finals are scratch space for readChunks, one entry
+	// per unit of concurrency, so reading doesn't allocate per chunk
+	aads   [][]byte
+	batch  [][]byte
+	finals []bool
 
-	// buf must be sized equal to the chunk size used in encryption
-	buf []byte
+	// sum is the plaintext digest so far and sumOut the final one, expect
+	// what it has to be. see WithDigest and WithExpectedDigest
+	sum    hash.Hash
+	sumOut []byte
+	expect []byte
 
-	// plain is a buffer of plaintext, for when not all of buf is requested
-	// by the caller
-	plain []byte
+	// matched is the key WithCandidateKeys found, see MatchedKey
+	matched *[32]byte
+
+	// resumable is set when a read deadline passing doesn't end the
+	// stream, pending is how much of buf was read before it did
+	resumable bool
+	pending   int
+
+	// id is the stream ID, see WithStreamID
+	id string
+
+	// objectID is the id the key was derived with, see NewDerivedWriter
+	objectID string
+
+	// maxSize is the most plaintext the stream may hold and read how much
+	// it has, see WithMaxSize
+	maxSize int64
+	read    int64
 }
 
-// Writer implements the io.Writer interface, written data will be passed
-// to an underlying writer and encrypted
+// Writer implements the io.WriteCloser interface, written data will be
+// encrypted and passed to an underlying writer. Close must be called to
+// write the final chunk, without it a Reader will report ErrTruncated.
 // see NewWriter for more information
 type Writer struct {
 	// w is the underlying reader
 	w io.Writer
 
-	// the gcm to be used
-	gcm cipher.AEAD
+	// the AEAD to be used
+	aead cipher.AEAD
+
+	// buffer will be allocated the correct size by the constructer, one
+	// chunk per unit of concurrency. n is how much of it holds data
+	// waiting to be sealed
+	buf         []byte
+	n           int
+	chunkSize   int
+	concurrency int
+
+	// spare is swapped with buf by ReadFrom, out holds sealed chunks
+	spare []byte
+	out   []byte
+
+	// header is the stream header as authenticated in the additional data
+	// of every chunk, see header.aad
+	header []byte
+
+	// seq is the index of the next chunk
+	seq uint64
+
+	// err is set once the stream is closed or a write failed, after which
+	// nothing more can be written
+	err error
+
+	// parity is set for archive streams, w writes through it
+	parity *parityWriter
+
+	// signer signs signed streams, digest is the plaintext so far. see
+	// WithSigner
+	signer ed25519.PrivateKey
+	digest hash.Hash
+
+	// random is where nonces come from, nonces holds those for a batch of
+	// chunks. they are read before sealing in parallel so a reproducible
+	// source gives the same stream with any concurrency
+	random io.Reader
+	nonces []byte
 
-	// buffer will be allocated the correct size by the constructer
-	buf []byte
+	// aads holds the additional data of a batch of chunks, see
+	// newChunkAAD
+	aads [][]byte
+
+	progress progress
+
+	// ctx and limiter are from WithContext and WithRateLimit
+	ctx     context.Context
+	limiter *limiter
+
+	// convergence derives nonces from the plaintext instead, see
+	// WithConvergentEncryption
+	convergence []byte
+
+	// mmap makes ReadFrom map files, see WithMmap
+	mmap bool
+
+	// sum is the plaintext digest so far and sumOut the final one, see
+	// WithDigest
+	sum    hash.Hash
+	sumOut []byte
+
+	// id is the stream ID, see WithStreamID
+	id string
+
+	// sink is what the stream is written to, see Size
+	sink *sinkWriter
 }
 
-// Write encrypts data then saves it to a buffer. once the buffer limit is reached
-// it encrypts the buffer and writes it to the underlying writer
-func (w Writer) Write(p []byte) (total int, err error) {
+// Write saves data to a buffer. once the buffer is full and there is more
+// data it encrypts the buffer and writes it to the underlying writer, a full
+// buffer is held back in case it turns out to be the final chunk.
+func (w *Writer) Write(p []byte) (total int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.hash(p)
+
 	// while we have data to write continue,
 	for len(p) != 0 {
+		// if buf is full write to the underlying writer
+		if w.n == len(w.buf) {
+			if err := w.writeChunks(w.buf, false); err != nil {
+				w.err = err
+				return total, err
+			}
+			w.n = 0
+		}
+
 		// copy into buf
-		n := copy(w.buf[:], p)
+		n := copy(w.buf[w.n:], p)
+		w.n += n
+		p = p[n:]
 		total += n
+	}
 
-		// if buf is full write to the underlying writer
-		if n == len(w.buf) {
-			// encrypt first
-			nonce := newNonce(w.gcm.NonceSize())
-			ciphertext := w.gcm.Seal(nonce, nonce, p, nil)
-			nw, err := w.w.Write(w.buf)
+	return total, nil
+}
+
+// Close encrypts whatever is buffered as the final chunk of the stream and
+// writes it, a stream always ends with a final chunk even if it is empty.
+// it does not close the underlying writer. calling Close again does nothing.
+func (w *Writer) Close() error {
+	if w.err == ErrClosed {
+		return nil
+	} else if w.err != nil {
+		return w.err
+	}
+
+	p := w.buf[:w.n]
+	if w.signer != nil {
+		// the signature may not fit in what is left of buf, in which case
+		// whole bufs go out first
+		p = append(bytes.Clone(p), w.signature()...)
+		for len(p) > len(w.buf) {
+			if err := w.writeChunks(p[:len(w.buf)], false); err != nil {
+				w.err = err
+				return err
+			}
+			p = p[len(w.buf):]
+		}
+	}
+	if err := w.writeChunks(p, true); err != nil {
+		w.err = err
+		return err
+	}
+	if w.parity != nil {
+		if err := w.parity.flush(); err != nil {
+			w.err = err
+			return err
+		}
+	}
+
+	w.n = 0
+	w.err = ErrClosed
+	for _, b := range [][]byte{w.buf, w.spare, w.out} {
+		if b != nil {
+			putBuf(b)
+		}
+	}
+	w.buf, w.spare, w.out = nil, nil, nil
+	if w.sum != nil {
+		w.sumOut = w.sum.Sum(nil)
+	}
+	return nil
+}
+
+// Wipe zeroes the plaintext w has buffered and ends the stream without
+// writing its final chunk, so a Reader will report ErrTruncated. it is for
+// abandoning a stream, after Close there is nothing left to wipe. the
+// cipher's key schedule can't be reached and is left to the garbage
+// collector.
+func (w *Writer) Wipe() {
+	w.n = 0
+	w.err = ErrClosed
+	for _, b := range [][]byte{w.buf, w.spare, w.out} {
+		if b != nil {
+			putBuf(b)
+		}
+	}
+	w.buf, w.spare, w.out = nil, nil, nil
+}
+
+// ReadFrom encrypts everything read from r until io.EOF, reading straight
+// into the chunk buffer so io.Copy doesn't need an intermediate one. like
+// Write it does not end the stream, Close still has to be called. see
+// WithMmap for files.
+func (w *Writer) ReadFrom(r io.Reader) (total int64, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if f, ok := r.(mappable); ok && w.mmap {
+		if total, ok, err := w.readFromMmap(f); ok {
+			return total, err
+		}
+	}
+
+	for {
+		var n int
+		if w.n == len(w.buf) {
+			// buf is full, it can only be sealed once we know more data
+			// follows, so read into the spare buffer first
+			if w.spare == nil {
+				w.spare = getBuf(len(w.buf))
+			}
+			n, err = r.Read(w.spare)
+			w.hash(w.spare[:n])
+			if n > 0 {
+				if err := w.writeChunks(w.buf, false); err != nil {
+					w.err = err
+					return total, err
+				}
+				w.buf, w.spare = w.spare, w.buf
+				w.n = n
+			}
+		} else {
+			n, err = r.Read(w.buf[w.n:])
+			w.hash(w.buf[w.n : w.n+n])
+			w.n += n
+		}
+		total += int64(n)
+
+		if err == io.EOF {
+			return total, nil
+		} else if err != nil {
+			return total, err
+		}
+	}
+}
+
+// writeChunks seals p as one or more chunks and writes them to the
+// underlying writer, final marks the last of them as the end of the stream.
+// with concurrency the chunks are sealed in parallel.
+func (w *Writer) writeChunks(p []byte, final bool) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+
+	n := max(1, (len(p)+w.chunkSize-1)/w.chunkSize)
+	frame := w.ChunkOverhead() + w.chunkSize
+	if w.out == nil {
+		w.out = getBuf(w.concurrency * frame)
+		w.nonces = make([]byte, w.concurrency*w.aead.NonceSize())
+		w.aads = newChunkAADs(w.header, w.concurrency)
+	}
+	ns := w.aead.NonceSize()
+	if w.convergence == nil {
+		if _, err := io.ReadFull(w.random, w.nonces[:n*ns]); err != nil {
+			return errors.New("crypt: reading randomness failed: " + err.Error())
+		}
+	}
+
+	if w.concurrency == 1 {
+		// a closure would be allocated for every chunk
+		for i := range n {
+			w.sealChunk(p, i, n, final)
+		}
+	} else {
+		parallel(n, w.concurrency, func(i int) error {
+			w.sealChunk(p, i, n, final)
+			return nil
+		})
+	}
+	w.seq += uint64(n)
+
+	out := w.out[:len(p)+n*w.ChunkOverhead()]
+	if err := w.limiter.wait(w.ctx, len(out)); err != nil {
+		return err
+	}
+	nw, err := w.w.Write(out)
+
+	// make sure it wrote all the bytes
+	if err != nil {
+		return err
+	} else if nw != len(out) {
+		// if some was not read decryption will fail so raise an error now
+		return io.ErrShortWrite
+	}
+
+	w.progress.add(len(p), n)
+	return nil
+}
+
+// sealChunk seals chunk i of the n in p into its frame of w.out.
+func (w *Writer) sealChunk(p []byte, i, n int, final bool) {
+	frame := w.ChunkOverhead() + w.chunkSize
+	ns := w.aead.NonceSize()
+	chunk := p[min(i*w.chunkSize, len(p)):min((i+1)*w.chunkSize, len(p))]
+	seq := w.seq + uint64(i)
+	if final && i == n-1 {
+		seq |= finalChunk
+	}
+
+	// every chunk before the last is full, so each has its own frame
+	out := w.out[i*frame : i*frame : (i+1)*frame]
+	out = binary.BigEndian.AppendUint64(out, seq)
+	nonce := w.nonces[i*ns : (i+1)*ns]
+	if w.convergence != nil {
+		copy(nonce, convergentNonce(w.convergence, ns, w.header, out[:sequenceSize], chunk))
+	}
+	out = append(out, nonce...)
+	aad := w.aads[i]
+	copy(aad[len(aad)-sequenceSize:], out[:sequenceSize])
+	w.aead.Seal(out, nonce, chunk, aad)
+	if debug {
+		trace("seal chunk %d final=%v len=%d nonce=%x aad=%x", w.seq+uint64(i), seq&finalChunk != 0, len(chunk), nonce, aad)
+	}
+}
+
+// parallel calls f for every i in [0, n), on separate goroutines when
+// workers is more than one, and returns the error from the lowest i that
+// failed. callers keep n within their concurrency.
+func parallel(n, workers int, f func(i int) error) error {
+	if workers <= 1 || n == 1 {
+		for i := range n {
+			if err := f(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = f(i)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newChunkAAD returns a buffer for the additional data of the chunks of a
+// stream with header, the header followed by room for the sequence number
+// of each chunk, filled in by openChunk and the Writer.
+func newChunkAAD(header []byte) []byte {
+	aad := make([]byte, len(header)+sequenceSize)
+	copy(aad, header)
+	return aad
+}
 
-			// make sure it wrote all the bytes
+// newChunkAADs returns a newChunkAAD buffer for each of n chunks sealed or
+// opened at once.
+func newChunkAADs(header []byte, n int) [][]byte {
+	aads := make([][]byte, n)
+	for i := range aads {
+		aads[i] = newChunkAAD(header)
+	}
+	return aads
+}
+
+// Read decrypts the next chunk when there is no plaintext left from the
+// last one and copies as much of it as fits into p.
+//
+// the underlying reader may return any amount per Read, as sockets and
+// pipes do. if it fails with os.ErrDeadlineExceeded, such as a net.Conn
+// past its read deadline, Read can be called again once the deadline has
+// been moved and carries on where it was. any other error, and deadlines
+// on archive, detached or withheld streams, end the stream.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// WriteTo decrypts the rest of the stream into w, each chunk is written
+// straight from the buffer it was decrypted in so io.Copy doesn't need an
+// intermediate one.
+func (r *Reader) WriteTo(w io.Writer) (total int64, err error) {
+	for {
+		if len(r.plain) != 0 {
+			n, err := w.Write(r.plain)
+			r.plain = r.plain[n:]
+			total += int64(n)
 			if err != nil {
-				return total + nw, err
-			} else if nw != len(ciphertext) {
-				// if some was not read decryption will fail so raise an error now
-				err = io.ErrShortWrite
+				return total, err
+			} else if len(r.plain) != 0 {
+				return total, io.ErrShortWrite
 			}
+		}
 
-			total += nw
+		if err := r.next(); err == io.EOF {
+			return total, nil
+		} else if err != nil {
+			return total, err
 		}
 	}
+}
 
-	return total, nil
+// Wipe zeroes the plaintext r has decrypted but not yet returned, after
+// which every Read fails. the cipher's key schedule can't be
+// reached and is left to the garbage collector.
+func (r *Reader) Wipe() {
+	clear(r.plain)
+	for _, chunk := range r.chunks {
+		clear(chunk)
+	}
+	if r.buf != nil {
+		putBuf(r.buf)
+	}
+	r.buf, r.plain, r.chunks = nil, nil, nil
+	r.err = errors.New("crypt: read from a wiped Reader")
+}
+
+// next moves r.plain on to the next decrypted chunk, reading another batch
+// once they have all been used. it returns io.EOF after the final chunk.
+//
+// plaintext only ever reaches r.plain once the chunk it came from, and every
+// other chunk in its batch, has authenticated. a failure is sticky, so
+// nothing after a bad chunk is released either.
+func (r *Reader) next() error {
+	if r.err != nil {
+		return r.err
+	}
+
+	if len(r.chunks) == 0 {
+		var err error
+		if r.withhold > 0 {
+			err = r.readAll()
+		} else {
+			err = r.readChunks()
+		}
+		if err != nil {
+			if err != io.EOF && !(r.resumable && errors.Is(err, os.ErrDeadlineExceeded)) {
+				r.err = err
+			} else if err == io.EOF && r.buf != nil {
+				// nothing points into buf once the stream is done
+				putBuf(r.buf)
+				r.buf = nil
+			}
+			return err
+		}
+
+		if r.maxSize != 0 {
+			for _, chunk := range r.chunks {
+				r.read += int64(len(chunk))
+			}
+			if r.read > r.maxSize {
+				r.chunks, r.err = nil, ErrTooLarge
+				return r.err
+			}
+		}
+		if r.verifier != nil {
+			r.chunks = r.verifier.push(r.chunks)
+			if r.eof {
+				if err := r.verifier.verify(); err != nil {
+					r.chunks, r.err = nil, err
+					return err
+				}
+			}
+			if len(r.chunks) == 0 {
+				r.chunks = [][]byte{nil}
+			}
+		}
+		if r.sum != nil {
+			if err := r.checkDigest(); err != nil {
+				r.chunks, r.err = nil, err
+				return err
+			}
+		}
+	}
+
+	r.plain, r.chunks = r.chunks[0], r.chunks[1:]
+	return nil
 }
 
-// Read will read a full block, decrypt it and copy it into p
-// it will continue to do this until p is filled
-func (r Reader) Read(p []byte) (int, error) {
-	if len(p) < r.gcm.NonceSize() {
-		return 0, errors.New("buffer can't be smaller then gcm.NonceSize")
+// readAll reads and authenticates the whole stream into a single chunk, for
+// WithholdUntilComplete.
+func (r *Reader) readAll() error {
+	if r.eof {
+		return io.EOF
 	}
 
-	buf := make([]byte, len(p)+r.gcm.Overhead())
-	n, err := r.r.Read(buf)
+	var all []byte
+	for !r.eof {
+		if err := r.readChunks(); err != nil {
+			clear(all)
+			return err
+		}
+		for _, chunk := range r.chunks {
+			if int64(len(all)+len(chunk)) > r.withhold {
+				clear(all)
+				return errors.New("crypt: stream is larger than WithholdUntilComplete allows")
+			}
+			// chunks point into r.buf which the next batch reuses
+			all = append(all, chunk...)
+		}
+	}
+
+	r.chunks = [][]byte{all}
+	return nil
+}
+
+// readChunks reads a batch of chunks and decrypts them into r.chunks, in
+// parallel with concurrency.
+func (r *Reader) readChunks() error {
+	if r.eof {
+		return io.EOF
+	}
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+
+	// sources such as sockets and pipes return less than asked for, so
+	// the batch is read with ReadFull and only a short read that ends
+	// the source counts as the end of the stream
+	n, err := io.ReadFull(r.r, r.buf[r.pending:])
+	n += r.pending
+	r.pending = 0
+	if err == io.EOF && n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil || err == io.ErrUnexpectedEOF {
+		if err := r.limiter.wait(r.ctx, n); err != nil {
+			return err
+		}
+	}
+	if err == io.EOF {
+		return r.chunkError(r.seq, ErrTruncated)
+	} else if err != nil && err != io.ErrUnexpectedEOF {
+		if r.resumable && errors.Is(err, os.ErrDeadlineExceeded) {
+			// keep what was read for when Read is called again
+			r.pending = n
+		}
+		return r.chunkError(r.seq, err)
+	}
+
+	frame := r.ChunkOverhead() + r.chunkSize
+	count := (n + frame - 1) / frame
+	if r.concurrency == 1 {
+		// a closure would be allocated for every chunk
+		err = r.openBatchChunk(0, n)
+	} else {
+		err = parallel(count, r.concurrency, func(i int) error {
+			return r.openBatchChunk(i, n)
+		})
+	}
 	if err != nil {
-		return 0, err
+		return err
 	}
-	ciphertext := buf[:n]
 
-	// decrypt the data
-	b, err := r.gcm.Open(nil,
-		ciphertext[:r.gcm.NonceSize()],
-		ciphertext[r.gcm.NonceSize():],
-		nil,
-	)
+	chunks, finals := r.batch[:count], r.finals[:count]
+	for i, final := range finals[:count-1] {
+		if final {
+			return r.chunkError(r.seq+uint64(i)+1, errors.New("crypt: data after the final chunk"))
+		}
+	}
 
+	// a short read means the underlying reader ended, which is only
+	// allowed after the final chunk
+	final := finals[count-1]
+	if !final && n != len(r.buf) {
+		return r.chunkError(r.seq+uint64(count), ErrTruncated)
+	}
+
+	r.seq += uint64(count)
+	r.eof = final
+	r.chunks = chunks
+	r.progress.add(n-count*r.ChunkOverhead(), count)
+	return nil
+}
+
+// openBatchChunk opens chunk i of the n bytes in r.buf into r.batch and
+// r.finals.
+func (r *Reader) openBatchChunk(i, n int) error {
+	frame := r.ChunkOverhead() + r.chunkSize
+	chunk := r.buf[i*frame : min((i+1)*frame, n)]
+	var err error
+	r.batch[i], r.finals[i], err = openChunk(r.aead, r.aads[i], chunk, r.seq+uint64(i))
 	if err != nil {
-		return 0, err
+		return r.chunkError(r.seq+uint64(i), err)
 	}
+	return nil
+}
 
-	return copy(p, b), nil
+// chunkError returns err for chunk seq with its offset in the stream.
+func (r *Reader) chunkError(seq uint64, err error) error {
+	i := int64(seq)
+	if r.group != 0 {
+		i += i / r.group
+	}
+	return &ChunkError{Chunk: int64(seq), Offset: r.start + i*r.frame, StreamID: r.id, Err: err}
 }
 
-// NewReader creates and returns a reader, the reader will decrypt aes-gcm data using key
-// and read chunks with size bufSize if bufSize is nil it will use its default
-// defined in DefaultBlockSize
-func NewReader(r io.Reader, key *[32]byte, bufSize int) (Reader, error) {
-	if bufSize == 0 {
-		bufSize = DefaultBlockSize
+// openChunk decrypts a sealed chunk in place, making sure it is chunk seq of
+// the stream whose newChunkAAD buffer is aad. final reports whether it is the
+// last chunk.
+func openChunk(aead cipher.AEAD, aad, chunk []byte, seq uint64) (plain []byte, final bool, err error) {
+	if len(chunk) < sequenceSize+aead.NonceSize()+aead.Overhead() {
+		return nil, false, ErrTruncated
 	}
 
-	gcm, err := newGCM(key)
+	s := chunk[:sequenceSize]
+	nonce := chunk[sequenceSize : sequenceSize+aead.NonceSize()]
+	ciphertext := chunk[len(s)+len(nonce):]
+	copy(aad[len(aad)-sequenceSize:], s)
+	if debug {
+		trace("open chunk %d len=%d nonce=%x aad=%x", seq, len(ciphertext), nonce, aad)
+	}
+	plain, err = aead.Open(ciphertext[:0], nonce, ciphertext, aad)
 	if err != nil {
-		return Reader{}, err
+		return nil, false, ErrAuthenticationFailed
 	}
 
-	return Reader{
-		gcm: gcm,
-		r:   r,
-		buf: make([]byte, bufSize),
-	}, nil
+	// the chunk is authentic, now make sure it is the one we expected
+	n := binary.BigEndian.Uint64(s)
+	if n&^finalChunk != seq {
+		return nil, false, ErrChunkOutOfOrder
+	}
+
+	return plain, n&finalChunk != 0, nil
+}
+
+// NonceSize returns the size of the nonce stored in front of each chunk.
+func (r *Reader) NonceSize() int {
+	return r.aead.NonceSize()
+}
+
+// Overhead returns the size of the authentication tag on each chunk.
+func (r *Reader) Overhead() int {
+	return r.aead.Overhead()
 }
 
-// NewWriter creates a new writer using w and key. bufSize can be left nil
-// to use the default specified in DefaultBlockSize
-func NewWriter(w io.Writer, key *[32]byte, bufSize int) (Writer, error) {
-	// default bufSize to 1k at a time
-	if bufSize == 0 {
-		bufSize = DefaultBlockSize
+// ChunkOverhead returns how many bytes each chunk on the wire is larger than
+// the plaintext it holds.
+func (r *Reader) ChunkOverhead() int {
+	return sequenceSize + r.aead.NonceSize() + r.aead.Overhead()
+}
+
+// NonceSize returns the size of the nonce stored in front of each chunk.
+func (w *Writer) NonceSize() int {
+	return w.aead.NonceSize()
+}
+
+// Overhead returns the size of the authentication tag on each chunk.
+func (w *Writer) Overhead() int {
+	return w.aead.Overhead()
+}
+
+// ChunkOverhead returns how many bytes each chunk on the wire is larger than
+// the plaintext it holds.
+func (w *Writer) ChunkOverhead() int {
+	return sequenceSize + w.aead.NonceSize() + w.aead.Overhead()
+}
+
+// NewReader creates and returns a reader, the reader will decrypt data using
+// key. it reads the stream header straight away, the chunk size and cipher
+// are taken from it so WithChunkSize and WithCipher are ignored. see
+// WithConcurrency to decrypt several chunks at once.
+func NewReader(r io.Reader, key *[32]byte, opts ...Option) (*Reader, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
 	}
 
-	gcm, err := newGCM(key)
+	h, _, err := readHeader(r)
 	if err != nil {
-		return Writer{}, err
+		return nil, err
+	}
+	if err := c.policy.check(h.cipher, h.version); err != nil {
+		return nil, err
+	}
+	if len(c.candidates) == 0 {
+		return newReader(r, key, c, h)
+	}
+
+	r, key, err = trialKey(r, key, c, h)
+	if err != nil {
+		return nil, err
+	}
+	sr, err := newReader(r, key, c, h)
+	if err != nil {
+		return nil, err
+	}
+	sr.matched = key
+	return sr, nil
+}
+
+// newReader returns a Reader for the rest of a stream whose header has been
+// read from r.
+func newReader(r io.Reader, key *[32]byte, c *config, h *header) (*Reader, error) {
+	aead, err := streamAEAD(h, key)
+	if err != nil {
+		return nil, err
 	}
 
-	return Writer{
-		gcm: gcm,
-		w:   w,
-		buf: make([]byte, bufSize),
+	if c.maxSize != 0 && int64(h.chunkSize) > max(c.maxSize, DefaultBlockSize) {
+		// the chunk buffer is allocated before anything is read
+		return nil, ErrTooLarge
+	}
+	frame := sequenceSize + aead.NonceSize() + h.chunkSize + aead.Overhead()
+	group, err := h.parityGroup()
+	if err != nil {
+		return nil, err
+	}
+	if group != 0 {
+		r = newParityReader(r, frame, group)
+	}
+	if _, ok := h.fields[fieldDetached]; ok != (c.tagsIn != nil) {
+		if ok {
+			return nil, errors.New("crypt: stream has detached tags, use WithTagsFrom")
+		}
+		return nil, errors.New("crypt: WithTagsFrom given for a stream without detached tags")
+	}
+	wire := frame
+	if c.tagsIn != nil {
+		r = newDetachedReader(r, c.tagsIn, frame, aead.Overhead())
+		wire -= aead.Overhead()
+	}
+	metadata, err := h.metadata()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkStreamTTL(h); err != nil {
+		return nil, err
+	}
+	if err := checkFingerprint(h, key); err != nil {
+		return nil, err
+	}
+	id, err := h.streamID()
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := newSignVerifier(h.fields[fieldSigner], c.trusted, append(h.aad(), c.aad...))
+	if err != nil {
+		return nil, err
+	}
+
+	header := append(h.aad(), c.aad...)
+	var sum hash.Hash
+	if c.digest != 0 {
+		sum = c.digest.New()
+	}
+	return &Reader{
+		aead:        aead,
+		r:           r,
+		buf:         getBuf(c.concurrency * frame),
+		chunkSize:   h.chunkSize,
+		concurrency: c.concurrency,
+		header:      header,
+		withhold:    c.withhold,
+		metadata:    metadata,
+		verifier:    verifier,
+		start:       int64(len(h.encode())),
+		frame:       int64(wire),
+		group:       int64(group),
+		aads:        newChunkAADs(header, c.concurrency),
+		batch:       make([][]byte, c.concurrency),
+		finals:      make([]bool, c.concurrency),
+		progress:    progress{fn: c.progress},
+		ctx:         c.ctx,
+		limiter:     newLimiter(c.rateLimit),
+		sum:         sum,
+		expect:      c.expectDigest,
+		resumable:   group == 0 && c.tagsIn == nil && c.withhold == 0,
+		id:          id,
+		objectID:    string(h.fields[fieldObjectID]),
+		maxSize:     c.maxSize,
 	}, nil
 }
 
-// newGCM skips allocating a cipher.Block and just returns the AEAD
-func newGCM(key *[32]byte) (cipher.AEAD, error) {
-	block, err := aes.NewCipher(key[:])
+// NewWriter creates a new writer using w and key, see WithChunkSize,
+// WithCipher and WithConcurrency to change the defaults. the stream header recording them is
+// written to w straight away, so the reader doesn't need to be told.
+func NewWriter(w io.Writer, key *[32]byte, opts ...Option) (*Writer, error) {
+	c, err := newConfig(opts)
 	if err != nil {
 		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
-	return gcm, err
+	return newWriter(w, key, c, nil)
 }
 
-// Encrypt encrypts data using 256-bit AES-GCM. This both hides the content of
-// the data and provides a check that it hasn't been altered. Output takes the
-// form nonce|ciphertext|tag where '|' indicates concatenation.
-func Encrypt(plaintext []byte, key *[32]byte) (ciphertext []byte, err error) {
-	gcm, err := newGCM(key)
+// newWriter returns a Writer for c, writing a header with fields to w.
+func newWriter(w io.Writer, key *[32]byte, c *config, fields map[byte][]byte) (*Writer, error) {
+	if err := c.policy.check(c.cipher, headerVersion); err != nil {
+		return nil, err
+	}
+
+	if c.archive && c.tagsOut != nil {
+		return nil, errors.New("crypt: the archive profile can't be used with detached tags")
+	}
+	if c.convergence != nil && c.counterNonces {
+		return nil, errors.New("crypt: convergent encryption can't be used with counter nonces")
+	}
+	if c.archive && (c.counterNonces || c.rekey != 0) {
+		// the format description in archive headers doesn't cover them
+		return nil, errors.New("crypt: the archive profile can't be used with counter nonces or rekeying")
+	}
+	if c.archive || c.metadata != nil || c.tagsOut != nil || c.signer != nil || c.counterNonces || c.rekey != 0 || c.timestamp || c.fingerprint || c.streamID {
+		fields = maps.Clone(fields)
+		if fields == nil {
+			fields = make(map[byte][]byte)
+		}
+	}
+	if c.metadata != nil {
+		fields[fieldMetadata] = c.metadata
+	}
+	if c.tagsOut != nil {
+		fields[fieldDetached] = []byte{}
+	}
+	if c.signer != nil {
+		fields[fieldSigner] = c.signer.Public().(ed25519.PublicKey)
+	}
+	if c.counterNonces {
+		prefix, err := newNonce(c.random, noncePrefixSize)
+		if err != nil {
+			return nil, err
+		}
+		fields[fieldNoncePrefix] = prefix
+	}
+	if c.rekey != 0 {
+		fields[fieldRekey] = binary.BigEndian.AppendUint64(nil, uint64(max(1, c.rekey/int64(c.chunkSize))))
+	}
+	if c.timestamp {
+		fields[fieldTimestamp] = binary.BigEndian.AppendUint64(nil, uint64(time.Now().Unix()))
+	}
+	if c.fingerprint {
+		fp, err := fingerprintField(key, fields)
+		if err != nil {
+			return nil, err
+		}
+		fields[fieldFingerprint] = fp
+	}
+	if c.streamID {
+		id, err := newNonce(c.random, streamIDSize)
+		if err != nil {
+			return nil, err
+		}
+		fields[fieldStreamID] = id
+	}
+	if c.archive {
+		fields[fieldDoc] = []byte(archiveDoc)
+		fields[fieldParity] = binary.BigEndian.AppendUint16(nil, archiveParityGroup)
+	}
+
+	h := &header{
+		version:   headerVersion,
+		cipher:    c.cipher,
+		chunkSize: c.chunkSize,
+		fields:    fields,
+	}
+	raw, err := h.marshal()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := streamAEAD(h, key)
+	if err != nil {
+		return nil, err
+	}
+	sink := newSinkWriter(w, c)
+	if _, err := sink.Write(raw); err != nil {
+		return nil, err
+	}
+
+	wr := writerFor(sink, aead, c, h)
+	wr.sink = sink
+	return wr, nil
+}
+
+// writerFor returns a Writer for the stream with header h, after the
+// header has been written.
+func writerFor(w io.Writer, aead cipher.AEAD, c *config, h *header) *Writer {
+	wr := &Writer{
+		aead:        aead,
+		w:           w,
+		buf:         getBuf(c.concurrency * c.chunkSize),
+		chunkSize:   c.chunkSize,
+		concurrency: c.concurrency,
+		header:      append(h.aad(), c.aad...),
+		random:      c.random,
+		convergence: c.convergence,
+		progress:    progress{fn: c.progress},
+		ctx:         c.ctx,
+		limiter:     newLimiter(c.rateLimit),
+		mmap:        c.mmap,
+	}
+	if c.archive {
+		wr.parity = newParityWriter(w, wr.ChunkOverhead()+c.chunkSize, archiveParityGroup)
+		wr.w = wr.parity
+	}
+	if c.signer != nil {
+		wr.signer = c.signer
+		wr.digest = newSignDigest(wr.header)
+	}
+	if c.tagsOut != nil {
+		wr.w = &detachedWriter{w: w, tags: c.tagsOut, frame: wr.ChunkOverhead() + c.chunkSize, tagSize: aead.Overhead()}
+	}
+	if c.digest != 0 {
+		wr.sum = c.digest.New()
+	}
+	// the header was made by us, the ID is well formed
+	wr.id, _ = h.streamID()
+
+	return wr
+}
+
+// Encrypt encrypts data using 256-bit AES-GCM or the cipher given with
+// WithCipher. This both hides the content of the data and provides a check
+// that it hasn't been altered. Output takes the form
+// cipher|nonce|ciphertext|tag where '|' indicates concatenation and cipher
+// is a single byte identifying the algorithm.
+func Encrypt(plaintext []byte, key *[32]byte, opts ...Option) (ciphertext []byte, err error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.policy.check(c.cipher, 0); err != nil {
+		return nil, err
+	}
+
+	var issued []byte
+	aad := c.aad
+	if c.timestamp {
+		issued = binary.BigEndian.AppendUint64(nil, uint64(time.Now().Unix()))
+		aad = append(slices.Clip(issued), aad...)
+	}
+
+	random := c.random
+	if c.convergence != nil {
+		random = bytes.NewReader(convergentNonce(c.convergence, c.cipher.nonceSize(), []byte{byte(c.cipher)}, aad, plaintext))
+	}
+	ct, err := encrypt(random, c.cipher, plaintext, key, aad)
+	if err != nil || issued == nil {
+		return ct, err
+	}
+	return append(issued, ct...), nil
+}
+
+// Decrypt decrypts data made by Encrypt, the cipher is read from the input so
+// it doesn't need to be given. This both hides the content of the data and
+// provides a check that it hasn't been altered. Expects input form
+// cipher|nonce|ciphertext|tag where '|' indicates concatenation.
+//
+// data from before the cipher byte was added, nonce|ciphertext|tag sealed
+// with AES-256-GCM, is accepted with WithLegacyFormat: when the input
+// doesn't decrypt in the current form it is tried in the old one. the two
+// can't be told apart by looking, but only the right one authenticates. to
+// move such data to the current form decrypt it and encrypt it again.
+func Decrypt(ciphertext []byte, key *[32]byte, opts ...Option) (plaintext []byte, err error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	aad := c.aad
+	if c.timestamp || c.ttl != 0 {
+		if len(ciphertext) < timestampSize {
+			return nil, errors.New("crypt: ciphertext is too short to hold a timestamp")
+		}
+		issued := ciphertext[:timestampSize]
+		if err := c.checkTTL(issued); err != nil {
+			return nil, err
+		}
+		aad = append(slices.Clip(issued), aad...)
+		ciphertext = ciphertext[timestampSize:]
+	}
+	if len(ciphertext) != 0 {
+		cipher := Cipher(ciphertext[0])
+		if err := c.policy.check(cipher, 0); err != nil {
+			if c.legacy {
+				if plaintext, legacyErr := decryptLegacy(ciphertext, key, aad, c); legacyErr == nil {
+					return plaintext, nil
+				}
+			}
+			return nil, err
+		}
+		if c.maxSize != 0 && int64(len(ciphertext)-1-cipher.nonceSize()-cipher.tagSize()) > c.maxSize {
+			return nil, ErrTooLarge
+		}
+	}
+
+	plaintext, err = decrypt(ciphertext, key, aad)
+	if err != nil && c.legacy {
+		if legacy, legacyErr := decryptLegacy(ciphertext, key, aad, c); legacyErr == nil {
+			return legacy, nil
+		}
+	}
+	return plaintext, err
+}
+
+// decryptLegacy decrypts nonce|ciphertext|tag sealed with AES-256-GCM, the
+// form Encrypt used before the cipher byte. the security policy and size
+// limit apply as for the current form, and a policy with a MinVersion
+// refuses it.
+func decryptLegacy(ciphertext []byte, key *[32]byte, aad []byte, c *config) ([]byte, error) {
+	if err := c.policy.check(AES256GCM, 0); err != nil {
+		return nil, err
+	}
+	if c.policy != nil && c.policy.MinVersion != 0 {
+		return nil, errors.New("crypt: the legacy format is older than the security policy allows")
+	}
+	aead, err := AES256GCM.NewAEAD(key)
 	if err != nil {
 		return nil, err
 	}
 
-	nonce := newNonce(gcm.NonceSize())
-	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+	ns := aead.NonceSize()
+	if len(ciphertext) < ns+aead.Overhead() {
+		return nil, errors.New("crypt: ciphertext can't be smaller then its nonce and tag")
+	}
+	if c.maxSize != 0 && int64(len(ciphertext)-ns-aead.Overhead()) > c.maxSize {
+		return nil, ErrTooLarge
+	}
+	return aead.Open(nil, ciphertext[:ns], ciphertext[ns:], aad)
 }
 
-// Decrypt decrypts data using 256-bit AES-GCM. This both hides the content of
-// the data and provides a check that it hasn't been altered. Expects input
-// form nonce|ciphertext|tag where '|' indicates concatenation.
-func Decrypt(ciphertext []byte, key *[32]byte) (plaintext []byte, err error) {
-	gcm, err := newGCM(key)
+// encrypt is Encrypt with additional authenticated data, aad is not
+// stored in the output and must be passed again to decrypt. the nonce is
+// read from random.
+func encrypt(random io.Reader, c Cipher, plaintext []byte, key *[32]byte, aad []byte) ([]byte, error) {
+	aead, err := c.NewAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return sealAppend(nil, aead, c, random, plaintext, aad)
+}
 
-	if len(ciphertext) < gcm.NonceSize() {
-		return nil, errors.New("ciphertext can't be smaller then gcm.NonceSize")
+// decrypt is the inverse of encrypt.
+func decrypt(ciphertext []byte, key *[32]byte, aad []byte) ([]byte, error) {
+	if len(ciphertext) == 0 {
+		return nil, errors.New("ciphertext is empty")
 	}
 
-	return gcm.Open(nil,
-		ciphertext[:gcm.NonceSize()],
-		ciphertext[gcm.NonceSize():],
-		nil,
+	aead, err := Cipher(ciphertext[0]).NewAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return openAppend(nil, aead, ciphertext, aad)
+}
+
+// sealAppend appends cipher|nonce|ciphertext|tag for plaintext to dst,
+// reading the nonce from random straight into dst.
+func sealAppend(dst []byte, aead cipher.AEAD, c Cipher, random io.Reader, plaintext, aad []byte) ([]byte, error) {
+	ns := aead.NonceSize()
+	dst = slices.Grow(dst, 1+ns+len(plaintext)+aead.Overhead())
+	dst = append(dst, byte(c))
+	nonce := dst[len(dst) : len(dst)+ns]
+	if _, err := io.ReadFull(random, nonce); err != nil {
+		return nil, errors.New("crypt: reading randomness failed: " + err.Error())
+	}
+	if debug {
+		trace("encrypt cipher=%v nonce=%x aad=%x", c, nonce, aad)
+	}
+
+	return aead.Seal(dst[:len(dst)+ns], nonce, plaintext, aad), nil
+}
+
+// openAppend appends the plaintext of ciphertext, which has already been
+// checked to be for aead's cipher, to dst.
+func openAppend(dst []byte, aead cipher.AEAD, ciphertext, aad []byte) ([]byte, error) {
+	ciphertext = ciphertext[1:]
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext can't be smaller then the nonce size")
+	}
+
+	out, err := aead.Open(dst,
+		ciphertext[:aead.NonceSize()],
+		ciphertext[aead.NonceSize():],
+		aad,
 	)
+	if err != nil {
+		return nil, ErrAuthenticationFailed
+	}
+	return out, nil
 }
 
-// newNonce returns a new nonce for cryptograpic use
-// if the source for secure randomness fails it will panic
-func newNonce(size int) []byte {
-	nonce := make([]byte, size)
-	n, err := io.ReadFull(rand.Reader, nonce)
+// EncryptedSize returns the length of the ciphertext Encrypt produces for a
+// plaintext of plaintextLen bytes with the same opts, so callers can
+// pre-allocate storage or enforce quotas before encrypting.
+func EncryptedSize(plaintextLen int64, opts ...Option) (int64, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return 0, err
+	}
+
+	size := 1 + int64(c.cipher.nonceSize()) + plaintextLen + int64(c.cipher.tagSize())
+	if c.timestamp {
+		size += timestampSize
+	}
+	return size, nil
+}
+
+// DecryptedSizeBounds returns the smallest and largest plaintext that a
+// ciphertext of ciphertextLen bytes from Encrypt can decrypt to, given the
+// opts Decrypt would be called with. the bounds differ because ciphers use
+// different nonce sizes, callers should use max when allocating. like
+// Decrypt it ignores WithCipher, the cipher is read from the ciphertext,
+// but only ciphers WithSecurityPolicy allows are considered. WithTimestamp
+// and WithTTL account for the timestamp.
+func DecryptedSizeBounds(ciphertextLen int64, opts ...Option) (min, max int64, err error) {
+	c, err := newConfig(opts)
 	if err != nil {
-		panic(err)
+		return 0, 0, err
+	}
+	if c.timestamp || c.ttl != 0 {
+		ciphertextLen -= timestampSize
 	}
 
-	if n != size {
-		panic("cryptograpic source of secure random failed")
+	minOverhead, maxOverhead := int64(-1), int64(0)
+	for _, cipher := range ciphers {
+		if c.policy.check(cipher, 0) != nil {
+			continue
+		}
+		overhead := int64(1 + cipher.nonceSize() + cipher.tagSize())
+		if minOverhead == -1 || overhead < minOverhead {
+			minOverhead = overhead
+		}
+		if overhead > maxOverhead {
+			maxOverhead = overhead
+		}
+	}
+	if minOverhead == -1 {
+		return 0, 0, errors.New("crypt: the security policy allows no cipher")
+	}
+
+	if ciphertextLen < minOverhead {
+		return 0, 0, errors.New("ciphertext can't be smaller then its cipher, nonce and tag")
+	}
+
+	max = ciphertextLen - minOverhead
+	min = ciphertextLen - maxOverhead
+	if min < 0 {
+		min = 0
+	}
+
+	return min, max, nil
+}
+
+// newNonce returns a new nonce for cryptograpic use read from random,
+// failing if random does.
+func newNonce(random io.Reader, size int) ([]byte, error) {
+	nonce := make([]byte, size)
+	if _, err := io.ReadFull(random, nonce); err != nil {
+		return nil, errors.New("crypt: reading randomness failed: " + err.Error())
 	}
 
-	return nonce
+	return nonce, nil
 }
@@ -3,10 +3,15 @@ package crypt
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 const (
@@ -49,34 +54,241 @@ func TestSmall(t *testing.T) {
 	}
 }
 
+// TestLegacyDecrypt decrypts data in the form Encrypt used before the
+// cipher byte, the fixture was made by the Encrypt of that time.
+func TestLegacyDecrypt(t *testing.T) {
+	t.Parallel()
+	k, _ := hex.DecodeString("db642a73dd595006cd40a3fcdb96f84fbf6f0fa24ed346d3632045d98d1266cb")
+	key := (*[32]byte)(k)
+	fixture, _ := hex.DecodeString("5868e8f77d0c45929ae4d563a62c9f914a8b3893aeb3d531527ed538decb0f639848a97a43a48e53f187e832f89558ea5b053a39c753a3c3a40cab6eacde6785d67823")
+	if _, err := Decrypt(fixture, key); err == nil {
+		t.Fatal("legacy form was accepted without WithLegacyFormat")
+	}
+	got, err := Decrypt(fixture, key, WithLegacyFormat())
+	if err != nil || string(got) != "sealed before the cipher byte was added" {
+		t.Fatalf("Decrypt = %q, %v", got, err)
+	}
+
+	// a nonce starting with a cipher byte first fails in the current form
+	gcm, _ := AES256GCM.NewAEAD(key)
+	nonce := randBytes(gcm.NonceSize())
+	nonce[0] = byte(ChaCha20Poly1305)
+	legacy := gcm.Seal(nonce, nonce, []byte("hello"), nil)
+	if got, err := Decrypt(legacy, key, WithLegacyFormat()); err != nil || string(got) != "hello" {
+		t.Fatalf("Decrypt = %q, %v", got, err)
+	}
+
+	if _, err := Decrypt(fixture, randKey(), WithLegacyFormat()); err == nil {
+		t.Fatal("decrypted with the wrong key")
+	}
+	if _, err := Decrypt(fixture, key, WithLegacyFormat(), WithSecurityPolicy(SecurityPolicy{Ciphers: []Cipher{ChaCha20Poly1305}})); err == nil {
+		t.Fatal("decrypted AES-256-GCM against the security policy")
+	}
+	if _, err := Decrypt(fixture, key, WithLegacyFormat(), WithSecurityPolicy(SecurityPolicy{MinVersion: 1})); err == nil {
+		t.Fatal("decrypted the legacy form against the security policy")
+	}
+	if _, err := Decrypt(fixture, key, WithLegacyFormat(), WithMaxSize(8)); err == nil {
+		t.Fatal("decrypted more than WithMaxSize allows")
+	}
+}
+
+// TestSizes makes sure EncryptedSize and DecryptedSizeBounds agree with what
+// Encrypt actually produces.
+func TestSizes(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	for _, n := range []int{0, 1, smallSize, 1024} {
+		encrypted, err := Encrypt(randBytes(n), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, _ := EncryptedSize(int64(n)); got != int64(len(encrypted)) {
+			t.Fatalf("EncryptedSize(%d) = %d, want %d", n, got, len(encrypted))
+		}
+
+		min, max, err := DecryptedSizeBounds(int64(len(encrypted)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(n) < min || int64(n) > max {
+			t.Fatalf("%d not within [%d, %d]", n, min, max)
+		}
+	}
+
+	if _, _, err := DecryptedSizeBounds(12); err == nil {
+		t.Fatal("expected an error for a ciphertext without room for a tag")
+	}
+
+	// every option that changes the size of the output, alone and together
+	var sets [][]Option
+	for _, c := range ciphers {
+		sets = append(sets, []Option{WithCipher(c)}, []Option{WithCipher(c), WithTimestamp()})
+	}
+	sets = append(sets, []Option{WithTimestamp()}, []Option{WithTimestamp(), WithAAD([]byte("aad")), WithConvergentEncryption(randKey())})
+	for _, opts := range sets {
+		encrypted, err := Encrypt(randBytes(100), key, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := EncryptedSize(100, opts...); err != nil || got != int64(len(encrypted)) {
+			t.Fatalf("EncryptedSize with %d options = %d, %v, want %d", len(opts), got, err, len(encrypted))
+		}
+		min, max, err := DecryptedSizeBounds(int64(len(encrypted)), opts...)
+		if err != nil || min > 100 || max < 100 {
+			t.Fatalf("DecryptedSizeBounds with %d options = [%d, %d], %v", len(opts), min, max, err)
+		}
+	}
+
+	// the timestamp is not plaintext, and a policy narrows the bounds to
+	// the ciphers it allows
+	encrypted, err := Encrypt(randBytes(100), key, WithTimestamp())
+	if err != nil {
+		t.Fatal(err)
+	}
+	min, max, err := DecryptedSizeBounds(int64(len(encrypted)), WithTTL(time.Hour))
+	if err != nil || min > 100 || max < 100 {
+		t.Fatalf("bounds with a timestamp = [%d, %d], %v", min, max, err)
+	}
+	if _, without, _ := DecryptedSizeBounds(int64(len(encrypted))); without != max+timestampSize {
+		t.Fatalf("bounds without the timestamp option = %d, want %d", without, max+timestampSize)
+	}
+	policy := WithSecurityPolicy(SecurityPolicy{Ciphers: []Cipher{DefaultCipher}})
+	if min, max, err := DecryptedSizeBounds(int64(len(encrypted)), WithTimestamp(), policy); err != nil || min != 100 || max != 100 {
+		t.Fatalf("bounds for one cipher = [%d, %d], %v", min, max, err)
+	}
+}
+
+// TestOverhead makes sure the stream types report the sizes used by the
+// one-shot format.
+func TestOverhead(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []interface {
+		NonceSize() int
+		Overhead() int
+		ChunkOverhead() int
+	}{r, w} {
+		if s.NonceSize() != DefaultCipher.nonceSize() || s.Overhead() != tagSize {
+			t.Fatalf("%T: got nonce %d tag %d", s, s.NonceSize(), s.Overhead())
+		}
+		if s.ChunkOverhead() != sequenceSize+DefaultCipher.nonceSize()+tagSize {
+			t.Fatalf("%T: got chunk overhead %d", s, s.ChunkOverhead())
+		}
+	}
+}
+
+// TestSequence makes sure reordered, duplicated, dropped and truncated
+// chunks are all detected.
+func TestSequence(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 16
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key, WithChunkSize(chunkSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerLen := buf.Len()
+	if _, err := w.Write(randBytes(3*chunkSize + 5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// split the stream back into its header and chunks
+	stream := buf.Bytes()
+	frame := w.ChunkOverhead() + chunkSize
+	header := stream[:headerLen]
+	var chunks [][]byte
+	for rest := stream[headerLen:]; len(rest) != 0; {
+		n := min(frame, len(rest))
+		chunks = append(chunks, rest[:n])
+		rest = rest[n:]
+	}
+
+	join := func(chunks ...[]byte) []byte {
+		return bytes.Join(append([][]byte{header}, chunks...), nil)
+	}
+
+	tt := []struct {
+		name   string
+		stream []byte
+		want   error
+	}{
+		{"intact", join(chunks...), nil},
+		{"dropped final", join(chunks[:3]...), ErrTruncated},
+		{"dropped middle", join(chunks[0], chunks[2], chunks[3]), ErrChunkOutOfOrder},
+		{"swapped", join(chunks[1], chunks[0], chunks[2], chunks[3]), ErrChunkOutOfOrder},
+		{"duplicated", join(chunks[0], chunks[0], chunks[1], chunks[2], chunks[3]), ErrChunkOutOfOrder},
+		{"cut", join(chunks...)[:len(stream)-frame/2], ErrTruncated},
+	}
+
+	for _, tc := range tt {
+		// a concurrent reader opens them all in one batch, it must come to
+		// the same conclusion
+		for _, c := range []int{1, 8} {
+			r, err := NewReader(bytes.NewReader(tc.stream), key, WithConcurrency(c))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := io.ReadAll(r); !errors.Is(err, tc.want) {
+				t.Fatalf("%s, concurrency %d: got %v, want %v", tc.name, c, err, tc.want)
+			}
+		}
+	}
+}
+
 // test encryption & decryption with files
 func TestFiles(t *testing.T) {
 	t.Parallel()
 
-	// files to be tested
-	tt := []string{
-		"rand500MB",
-		"rand100MB",
+	// sizes of the files to be tested, chunk aligned and not
+	tt := []int{
+		0,
+		8 * 32 * 1024,
+		3*1024*1024 + 17,
 	}
 
 	// key will be constant throughout the tests
 	key := randKey()
 
-	// all test files will be inside the testdata folder
-	for _, pName := range tt {
+	// all test files will be inside a temporary folder
+	dir := t.TempDir()
+	for _, size := range tt {
+		pName := fmt.Sprintf("rand%d", size)
+
 		// the path to the plain file
-		pPath := "testdata" + string(os.PathSeparator) + pName
+		pPath := filepath.Join(dir, pName)
 
 		// name and path for the encrypted files
 		eName := pName + ".enc"
-		ePath := "testdata" + string(os.PathSeparator) + pName
+		ePath := filepath.Join(dir, eName)
 
 		// name and path for the decrypted files
 		dName := pName + ".dec"
-		dPath := "testdata" + string(os.PathSeparator) + pName
+		dPath := filepath.Join(dir, dName)
 
 		// inside function here so that defer will not wait till the test ends.
 		t.Run("TestFile: "+pName, func(t *testing.T) {
+			if err := os.WriteFile(pPath, randBytes(size), filePerm); err != nil {
+				t.Fatal(err)
+			}
+
 			// open the plain file as read only
 			pFile, err := os.Open(pPath)
 			if err != nil {
@@ -85,14 +297,14 @@ func TestFiles(t *testing.T) {
 			defer pFile.Close()
 
 			// open and create the file to hold the encrypted data
-			eFile, err := os.OpenFile(ePath, os.O_CREATE|os.O_WRONLY, filePerm)
+			eFile, err := os.OpenFile(ePath, os.O_CREATE|os.O_RDWR, filePerm)
 			if err != nil {
 				t.Fatal(err)
 			}
 			defer eFile.Close()
 
 			// now we can create the cryptograpic writer
-			encSteam, err := NewWriter(eFile, key, 32*1024)
+			encSteam, err := NewWriter(eFile, key, WithChunkSize(32*1024))
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -102,21 +314,31 @@ func TestFiles(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
+			if err := encSteam.Close(); err != nil {
+				t.Fatal(err)
+			}
 
 			// if the encrypted file and the plain file are equal then fail
-			err = notEqual(eFile, pFile)
-			if err == nil {
+			plain, _ := os.ReadFile(pPath)
+			encrypted, _ := os.ReadFile(ePath)
+			if bytes.Equal(plain, encrypted) {
 				t.Fatalf("%s and %s are equal", pName, eName)
 			}
 
 			// create the decryption stream
-			decStream, err := NewReader(eFile, key, 32*1024)
+			if _, err := eFile.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			decStream, err := NewReader(eFile, key)
 			if err != nil {
 				t.Fatal(err)
 			}
 
 			// create decrypted file
 			dFile, err := os.OpenFile(dPath, os.O_CREATE|os.O_WRONLY, filePerm)
+			if err != nil {
+				t.Fatal(err)
+			}
 			defer dFile.Close()
 
 			// copy the decrypted stream into the decrypted file
@@ -126,54 +348,472 @@ func TestFiles(t *testing.T) {
 			}
 
 			// decrypted file and plain file should now be equal
-			err = notEqual(dFile, pFile)
-			if err != nil {
+			decrypted, _ := os.ReadFile(dPath)
+			if !bytes.Equal(decrypted, plain) {
 				t.Fatalf("%s and %s should be equal", dName, pName)
 			}
 		})
 	}
 }
 
+// TestClose makes sure the final partial chunk is written by Close, and that
+// a stream that was never closed is reported as truncated.
+func TestClose(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 16
+
+	for _, n := range []int{0, 1, chunkSize, chunkSize + 1, 4 * chunkSize} {
+		data := randBytes(n)
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, key, WithChunkSize(chunkSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+
+		// without Close the stream can't be read to the end
+		r, err := NewReader(bytes.NewReader(buf.Bytes()), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(r); !errors.Is(err, ErrTruncated) {
+			t.Fatalf("%d bytes: unclosed stream gave %v", n, err)
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal("second Close failed:", err)
+		}
+		if _, err := w.Write([]byte{1}); err != ErrClosed {
+			t.Fatalf("Write after Close gave %v", err)
+		}
+
+		r, err = NewReader(&buf, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatalf("%d bytes did not round trip", n)
+		}
+	}
+}
+
 // notEqual returns an error if r1 and r2 are not equal
 // intended use to be with files.
-func notEqual(r1 io.Reader, r2 io.Reader) error {
-	errNotEqual := errors.New("not equal")
-	buf1 := make([]byte, 8)
-	buf2 := make([]byte, 8)
+// TestWriteSizes makes sure the stream is the same whatever size the writes
+// come in, and when fed through ReadFrom.
+func TestWriteSizes(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 64
+	data := randBytes(10*chunkSize + 7)
+
+	tt := []struct {
+		name  string
+		write func(w *Writer) error
+	}{
+		{"single", func(w *Writer) error {
+			_, err := w.Write(data)
+			return err
+		}},
+		{"bytes", func(w *Writer) error {
+			for i := range data {
+				if _, err := w.Write(data[i : i+1]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"uneven", func(w *Writer) error {
+			for p, n := data, 1; len(p) != 0; n = n*3 + 1 {
+				n = min(n%(3*chunkSize), len(p))
+				if _, err := w.Write(p[:n]); err != nil {
+					return err
+				}
+				p = p[n:]
+			}
+			return nil
+		}},
+		{"copy", func(w *Writer) error {
+			_, err := io.Copy(w, bytes.NewReader(data))
+			return err
+		}},
+		{"copy bytes", func(w *Writer) error {
+			_, err := io.Copy(w, iotest.OneByteReader(bytes.NewReader(data)))
+			return err
+		}},
+		{"copy half", func(w *Writer) error {
+			_, err := io.Copy(w, iotest.HalfReader(bytes.NewReader(data)))
+			return err
+		}},
+	}
 
-	// If we get too meny errors then we'll return nil, errNotEqual should happen
-	// before that though.
-	errn := 0
+	for _, tc := range tt {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, key, WithChunkSize(chunkSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tc.write(w); err != nil {
+			t.Fatal(tc.name, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
 
-	for errn < 5 {
-		_, err := r1.Read(buf1[:])
+		// every stream must have the same framing
+		want := headerFixedSize + 11*w.ChunkOverhead() + len(data)
+		if buf.Len() != want {
+			t.Fatalf("%s: stream is %d bytes, want %d", tc.name, buf.Len(), want)
+		}
+
+		r, err := NewReader(&buf, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatalf("%s: data did not round trip", tc.name)
+		}
+	}
+}
+
+// TestWriteTo makes sure WriteTo picks up after a partial Read and stops at
+// a truncated stream.
+func TestWriteTo(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	data := randBytes(5*64 + 3)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key, WithChunkSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+
+	r, err := NewReader(bytes.NewReader(stream), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := make([]byte, 10)
+	if _, err := io.ReadFull(r, first); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if n, err := r.WriteTo(&out); err != nil || n != int64(len(data)-10) {
+		t.Fatalf("WriteTo gave %d, %v", n, err)
+	}
+	if !bytes.Equal(append(first, out.Bytes()...), data) {
+		t.Fatal("data did not round trip")
+	}
+
+	r, err = NewReader(bytes.NewReader(stream[:len(stream)-1]), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, r); err == nil {
+		t.Fatal("truncated stream was accepted")
+	}
+}
+
+// TestConcurrency makes sure concurrent writers produce the same framing
+// and every reader can read what every writer wrote.
+func TestConcurrency(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 16
+
+	for _, size := range []int{0, 5, 4 * chunkSize, 13*chunkSize + 3} {
+		data := randBytes(size)
+		for _, wc := range []int{1, 3, 8} {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, key, WithChunkSize(chunkSize), WithConcurrency(wc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.Copy(w, iotest.HalfReader(bytes.NewReader(data))); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			chunks := max(1, (size+chunkSize-1)/chunkSize)
+			if want := headerFixedSize + chunks*w.ChunkOverhead() + size; buf.Len() != want {
+				t.Fatalf("concurrency %d: stream is %d bytes, want %d", wc, buf.Len(), want)
+			}
+
+			for _, rc := range []int{1, 2, 8} {
+				r, err := NewReader(bytes.NewReader(buf.Bytes()), key, WithConcurrency(rc))
+				if err != nil {
+					t.Fatal(err)
+				}
+				decrypted, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("written with %d, read with %d: %v", wc, rc, err)
+				}
+				if !bytes.Equal(decrypted, data) {
+					t.Fatalf("written with %d, read with %d: data did not round trip", wc, rc)
+				}
+			}
+		}
+	}
+}
+
+// readUntilError reads r a byte at a time, returning what was released
+// before the first error.
+func readUntilError(r io.Reader) ([]byte, error) {
+	var out []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		out = append(out, b[:n]...)
+		if err != nil {
+			return out, err
+		}
+	}
+}
+
+// TestNoUnauthenticatedPlaintext flips every bit of a stream in turn and
+// makes sure a Reader never releases plaintext from the damaged chunk or
+// anything after it, nor anything at all once it has failed.
+func TestNoUnauthenticatedPlaintext(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 16
+	data := randBytes(3*chunkSize + 5)
+	stream := sealStream(t, key, data, chunkSize)
+	h, raw, _ := readHeader(bytes.NewReader(stream))
+	frame := sequenceSize + h.cipher.nonceSize() + h.cipher.tagSize() + chunkSize
+
+	for _, concurrency := range []int{1, 3} {
+		for i := len(raw); i < len(stream); i++ {
+			for bit := range 8 {
+				damaged := bytes.Clone(stream)
+				damaged[i] ^= 1 << bit
+				r, err := NewReader(bytes.NewReader(damaged), key, WithConcurrency(concurrency))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := readUntilError(r)
+				if err == io.EOF {
+					t.Fatalf("byte %d bit %d: damage went unnoticed", i, bit)
+				}
+				if limit := (i - len(raw)) / frame * chunkSize; len(got) > limit || !bytes.Equal(got, data[:len(got)]) {
+					t.Fatalf("byte %d bit %d: released %d bytes, at most %d authenticated", i, bit, len(got), limit)
+				}
+				if n, err2 := r.Read(make([]byte, 1)); n != 0 || err2 != err {
+					t.Fatalf("byte %d bit %d: read %d bytes after failing", i, bit, n)
+				}
+			}
+		}
+	}
+}
+
+// TestWithholdUntilComplete makes sure nothing is released from a stream
+// that turns out to be truncated or too large.
+func TestWithholdUntilComplete(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 16
+	data := randBytes(5 * chunkSize)
+	stream := sealStream(t, key, data, chunkSize)
+
+	r, err := NewReader(bytes.NewReader(stream), key, WithholdUntilComplete(int64(len(data))), WithConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := readUntilError(r)
+	if err != io.EOF || !bytes.Equal(got, data) {
+		t.Fatalf("got %d bytes and %v", len(got), err)
+	}
+
+	truncated := stream[:len(stream)-sequenceSize-chunkSize]
+	r, _ = NewReader(bytes.NewReader(truncated), key, WithholdUntilComplete(1<<20))
+	if got, err := readUntilError(r); len(got) != 0 || !errors.Is(err, ErrTruncated) {
+		t.Fatalf("got %d bytes and %v from a truncated stream", len(got), err)
+	}
+
+	r, _ = NewReader(bytes.NewReader(stream), key, WithholdUntilComplete(int64(len(data)-1)))
+	if got, err := readUntilError(r); len(got) != 0 || err == io.EOF {
+		t.Fatalf("got %d bytes and %v from a stream over the limit", len(got), err)
+	}
+
+	if _, err := NewReader(bytes.NewReader(stream), key, WithholdUntilComplete(0)); err == nil {
+		t.Fatal("accepted a limit of 0")
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 16
+	data := randBytes(5 * chunkSize)
+	stream := sealStream(t, key, data, chunkSize)
+
+	r, err := NewReader(bytes.NewReader(stream), key, WithMaxSize(int64(len(data))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readUntilError(r); err != io.EOF || !bytes.Equal(got, data) {
+		t.Fatalf("got %d bytes and %v", len(got), err)
+	}
+	r, _ = NewReader(bytes.NewReader(stream), key, WithMaxSize(int64(len(data)-1)))
+	if got, err := readUntilError(r); len(got) > len(data)-1 || !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("got %d bytes and %v from a stream over the limit", len(got), err)
+	}
+
+	// a header asking for huge chunks is refused before they are allocated
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, key, WithChunkSize(4*DefaultBlockSize))
+	w.Close()
+	if _, err := NewReader(&buf, key, WithMaxSize(1<<10)); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("chunk size over the limit: %v", err)
+	}
+
+	ct, _ := Encrypt(data, key)
+	if _, err := Decrypt(ct, key, WithMaxSize(int64(len(data)))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decrypt(ct, key, WithMaxSize(int64(len(data)-1))); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Decrypt over the limit: %v", err)
+	}
+	if _, err := Decrypt(ct, key, WithMaxSize(0)); err == nil {
+		t.Fatal("accepted a limit of 0")
+	}
+}
+
+// benchmarkStream encrypts then decrypts 64MiB with the given concurrency.
+func benchmarkStream(b *testing.B, concurrency int) {
+	key := randKey()
+	data := randBytes(64 << 20)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+
+	for b.Loop() {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, key, WithConcurrency(concurrency))
 		if err != nil {
-			errn++
+			b.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			b.Fatal(err)
 		}
-		_, err = r2.Read(buf2[:])
+		if err := w.Close(); err != nil {
+			b.Fatal(err)
+		}
+
+		r, err := NewReader(&buf, key, WithConcurrency(concurrency))
 		if err != nil {
-			errn++
+			b.Fatal(err)
 		}
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStream(b *testing.B) {
+	for _, c := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("concurrency=%d", c), func(b *testing.B) {
+			benchmarkStream(b, c)
+		})
+	}
+}
 
-		// if the chunk is not equal return errNotEqual
-		if !bytes.Equal(buf1, buf2) {
-			return errNotEqual
+// BenchmarkCopy encrypts 4GiB with io.Copy and decrypts 1GiB, the time
+// should be all sealing and opening with allocations a handful per stream
+// rather than per chunk.
+func BenchmarkCopy(b *testing.B) {
+	key := randKey()
+	b.Run("encrypt", func(b *testing.B) {
+		b.SetBytes(4 << 30)
+		b.ReportAllocs()
+		for b.Loop() {
+			w, _ := NewWriter(io.Discard, key)
+			if _, err := io.Copy(w, io.LimitReader(zeroReader{}, 4<<30)); err != nil {
+				b.Fatal(err)
+			}
+			w.Close()
 		}
+	})
+
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, key)
+	io.Copy(w, io.LimitReader(zeroReader{}, 1<<30))
+	w.Close()
+	stream := buf.Bytes()
+	b.Run("decrypt", func(b *testing.B) {
+		b.SetBytes(1 << 30)
+		b.ReportAllocs()
+		for b.Loop() {
+			r, _ := NewReader(bytes.NewReader(stream), key)
+			if _, err := io.Copy(io.Discard, r); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// TestStreamAllocs makes sure streaming doesn't allocate per chunk.
+func TestStreamAllocs(t *testing.T) {
+	key := randKey()
+	const chunkSize = 1024
+	w, _ := NewWriter(io.Discard, key, WithChunkSize(chunkSize))
+	chunk := randBytes(chunkSize)
+	allocs := testing.AllocsPerRun(100, func() {
+		w.Write(chunk)
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocations per chunk written", allocs)
 	}
 
-	return nil
+	var buf bytes.Buffer
+	w, _ = NewWriter(&buf, key, WithChunkSize(chunkSize))
+	for range 200 {
+		w.Write(chunk)
+	}
+	w.Close()
+	r, _ := NewReader(&buf, key, WithChunkSize(chunkSize))
+	p := make([]byte, chunkSize)
+	allocs = testing.AllocsPerRun(100, func() {
+		io.ReadFull(r, p)
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocations per chunk read", allocs)
+	}
 }
 
 // randKey returns a random key for encryption
 // it will panic if rand.Reader fails.
 func randKey() *[32]byte {
-	randomKey := &[32]byte{}
-	_, err := io.ReadFull(rand.Reader, randomKey[:])
+	key, err := GenerateKey()
 	if err != nil {
 		panic(err)
 	}
 
-	return randomKey
+	return (*[32]byte)(key)
 }
 
 // return s random bytes
@@ -187,3 +827,68 @@ func randBytes(s int) []byte {
 
 	return b
 }
+
+// TestErrors makes sure failures match the sentinel errors and say which
+// chunk they were about.
+func TestErrors(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 16
+	stream := sealStream(t, key, randBytes(6*chunkSize), chunkSize)
+	_, raw, _ := readHeader(bytes.NewReader(stream))
+	frame := sequenceSize + DefaultCipher.nonceSize() + DefaultCipher.tagSize() + chunkSize
+
+	read := func(stream []byte, key *[32]byte, c int) error {
+		r, err := NewReader(bytes.NewReader(stream), key, WithConcurrency(c))
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(r)
+		return err
+	}
+
+	for _, c := range []int{1, 8} {
+		var ce *ChunkError
+		err := read(stream, randKey(), c)
+		if !errors.Is(err, ErrAuthenticationFailed) || !errors.As(err, &ce) || ce.Chunk != 0 || ce.Offset != int64(len(raw)) {
+			t.Fatalf("wrong key, concurrency %d: got %v", c, err)
+		}
+
+		damaged := bytes.Clone(stream)
+		damaged[len(raw)+4*frame+sequenceSize] ^= 1
+		damaged[len(raw)+5*frame+sequenceSize] ^= 1
+		err = read(damaged, key, c)
+		if !errors.Is(err, ErrAuthenticationFailed) || !errors.As(err, &ce) || ce.Chunk != 4 || ce.Offset != int64(len(raw)+4*frame) {
+			t.Fatalf("damaged, concurrency %d: got %v", c, err)
+		}
+
+		err = read(stream[:len(raw)+2*frame], key, c)
+		if !errors.Is(err, ErrTruncated) || !errors.As(err, &ce) || ce.Chunk != 2 {
+			t.Fatalf("truncated, concurrency %d: got %v", c, err)
+		}
+	}
+
+	bad := bytes.Clone(stream)
+	bad[len(headerMagic)] = 9
+	if err := read(bad, key, 1); !errors.Is(err, ErrBadHeader) {
+		t.Fatalf("bad header: got %v", err)
+	}
+
+	var buf bytes.Buffer
+	kr := NewKeyring()
+	kr.Add(1, key)
+	w, _ := kr.NewWriter(&buf)
+	w.Close()
+	if _, err := NewKeyring().NewReader(&buf); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("keyring: got %v", err)
+	}
+
+	a, _ := GenerateKey()
+	b, _ := GenerateKey()
+	buf.Reset()
+	w, _ = NewRecipientWriter(&buf, []Recipient{a})
+	w.Close()
+	if _, err := NewRecipientReader(&buf, b); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("recipients: got %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+//go:build cryptdebug
+
+package crypt
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strconv"
+)
+
+// building with -tags cryptdebug prints every derived key, nonce and
+// additional data to stderr, so implementers of readers in other languages
+// can find where their bytes differ from ours. setting CRYPT_DEBUG_SEED to
+// a number also makes all randomness deterministic, so the same input gives
+// the same output every run. NEVER use this build for real data.
+const debug = true
+
+func init() {
+	fmt.Fprintln(os.Stderr, "crypt: debug build, keys and nonces will be printed")
+
+	if s, ok := os.LookupEnv("CRYPT_DEBUG_SEED"); ok {
+		seed, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			panic("crypt: CRYPT_DEBUG_SEED must be a number")
+		}
+
+		var key [32]byte
+		for i := range 8 {
+			key[i] = byte(seed >> (8 * i))
+		}
+		randReader = rand.NewChaCha8(key)
+	}
+}
+
+// trace prints a debug line to stderr.
+func trace(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "crypt: "+format+"\n", args...)
+}
@@ -0,0 +1,112 @@
+package crypt
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"io"
+)
+
+// HKDF info prefixes, each kind of derived key gets its own so keys derived
+// for one purpose can never collide with keys derived for another.
+const (
+	objectKeyInfo   = "crypt object key v1\x00"
+	tenantKeyInfo   = "crypt tenant key v1\x00"
+	fingerprintInfo = "crypt key fingerprint v1\x00"
+	sealedBoxInfo   = "crypt sealed box v1\x00"
+	recipientInfo   = "crypt recipient v1\x00"
+	timeTagInfo     = "crypt time tag v1\x00"
+
+	// the per stream key of streams with counter nonces
+	counterNonceInfo = "crypt counter nonces v1\x00"
+
+	// each key of a stream that rekeys is derived from the one before
+	rekeyInfo = "crypt rekey v1\x00"
+
+	// the MAC key of WithConvergentEncryption
+	convergentInfo = "crypt convergent nonce v1\x00"
+
+	// the two keys of the Cascade cipher
+	cascadeInnerInfo = "crypt cascade inner v1\x00"
+	cascadeOuterInfo = "crypt cascade outer v1\x00"
+
+	// the two keys of the AES256CTRHMAC cipher
+	ctrEncInfo = "crypt ctr hmac enc v1\x00"
+	ctrMACInfo = "crypt ctr hmac mac v1\x00"
+)
+
+// DeriveKey derives a unique key for the object identified by id (a path,
+// S3 key, row ID...) from master using HKDF-SHA256. the same master and id
+// always give the same key, so only id has to be stored next to the
+// ciphertext and one master key can cover any number of objects without a
+// key database. NewDerivedWriter stores id in the stream header.
+func DeriveKey(master *[32]byte, id string) (*[32]byte, error) {
+	return deriveKey(master, objectKeyInfo+id)
+}
+
+// NewDerivedWriter is NewWriter with the key DeriveKey gives for master
+// and id. id is stored in the stream header, so NewDerivedReader can
+// derive the key again from master alone. it isn't secret, but every
+// chunk authenticates it.
+func NewDerivedWriter(w io.Writer, master *[32]byte, id string, opts ...Option) (*Writer, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := DeriveKey(master, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return newWriter(w, key, c, map[byte][]byte{fieldObjectID: []byte(id)})
+}
+
+// NewDerivedReader is NewReader for streams made by NewDerivedWriter, the
+// key is derived from master and the id in the stream header.
+// Reader.ObjectID returns the id.
+func NewDerivedReader(r io.Reader, master *[32]byte, opts ...Option) (*Reader, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	h, _, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.policy.check(h.cipher, h.version); err != nil {
+		return nil, err
+	}
+
+	id, ok := h.fields[fieldObjectID]
+	if !ok {
+		return nil, errBadHeader("stream has no object id")
+	}
+	key, err := DeriveKey(master, string(id))
+	if err != nil {
+		return nil, err
+	}
+
+	return newReader(r, key, c, h)
+}
+
+// ObjectID returns the id the stream key was derived with, see
+// NewDerivedWriter, empty for other streams.
+func (r *Reader) ObjectID() string {
+	return r.objectID
+}
+
+// deriveKey expands master into a new key bound to info.
+func deriveKey(master *[32]byte, info string) (*[32]byte, error) {
+	b, err := hkdf.Key(sha256.New, master[:], nil, info, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &[32]byte{}
+	copy(key[:], b)
+	if debug {
+		trace("derive info=%q key=%x", info, key[:])
+	}
+	return key, nil
+}
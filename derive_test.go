@@ -0,0 +1,94 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// TestDeriveKey makes sure derived keys are stable per id and distinct
+// between ids.
+func TestDeriveKey(t *testing.T) {
+	t.Parallel()
+	master := randKey()
+
+	a1, err := DeriveKey(master, "bucket/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, err := DeriveKey(master, "bucket/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := DeriveKey(master, "bucket/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *a1 != *a2 {
+		t.Fatal("same id derived different keys")
+	}
+	if *a1 == *b || *a1 == *master {
+		t.Fatal("derived key is not unique")
+	}
+}
+
+// TestDerivedStream makes sure a stream made with NewDerivedWriter can be
+// read with the master key alone.
+func TestDerivedStream(t *testing.T) {
+	t.Parallel()
+	master := randKey()
+	data := randBytes(1000)
+
+	var buf bytes.Buffer
+	w, err := NewDerivedWriter(&buf, master, "bucket/a.txt", WithChunkSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+
+	r, err := NewDerivedReader(bytes.NewReader(stream), master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, data) || r.ObjectID() != "bucket/a.txt" {
+		t.Fatalf("got %d bytes for %q: %v", len(got), r.ObjectID(), err)
+	}
+
+	// the derived key reads it too, the master key alone doesn't
+	key, _ := DeriveKey(master, "bucket/a.txt")
+	r, err = NewReader(bytes.NewReader(stream), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := NewReader(bytes.NewReader(stream), master); err == nil {
+		if _, err := io.ReadAll(r); err == nil {
+			t.Fatal("read with the master key")
+		}
+	}
+	if r, err := NewDerivedReader(bytes.NewReader(stream), randKey()); err == nil {
+		if _, err := io.ReadAll(r); err == nil {
+			t.Fatal("read with another master key")
+		}
+	}
+	if info, err := Inspect(bytes.NewReader(stream)); err != nil || info.ObjectID != "bucket/a.txt" {
+		t.Fatalf("Inspect: %+v, %v", info, err)
+	}
+
+	// streams without an id can't be read this way
+	var plain bytes.Buffer
+	w, _ = NewWriter(&plain, master)
+	w.Close()
+	if _, err := NewDerivedReader(&plain, master); !errors.Is(err, ErrBadHeader) {
+		t.Fatalf("stream without an id: %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package crypt
+
+import (
+	"errors"
+	"io"
+)
+
+// WithDetachedTags makes a Writer send the authentication tag of every
+// chunk to tags instead of the stream, so the tags can travel on their own
+// while the ciphertext stays byte for byte the same. readers need the tags too, see WithTagsFrom. it can't be combined
+// with WithArchiveProfile.
+func WithDetachedTags(tags io.Writer) Option {
+	return func(c *config) error {
+		c.tagsOut = tags
+		return nil
+	}
+}
+
+// WithTagsFrom gives a Reader the tags written by WithDetachedTags. a
+// stream with detached tags can't be read without them, and nothing is
+// released before its tag has been read and checked as usual.
+func WithTagsFrom(tags io.Reader) Option {
+	return func(c *config) error {
+		c.tagsIn = tags
+		return nil
+	}
+}
+
+// detachedWriter splits the frames written to it, passing everything but
+// the tag to w and the tag to tags. the Writer writes whole frames, only the
+// final one may be short.
+type detachedWriter struct {
+	w, tags io.Writer
+	frame   int
+	tagSize int
+}
+
+func (d *detachedWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		f := p[:min(len(p), d.frame)]
+		if len(f) < d.tagSize {
+			return total, errors.New("crypt: partial frame written")
+		}
+
+		data, tag := f[:len(f)-d.tagSize], f[len(f)-d.tagSize:]
+		if _, err := d.w.Write(data); err != nil {
+			return total, err
+		}
+		if _, err := d.tags.Write(tag); err != nil {
+			return total, err
+		}
+
+		total += len(f)
+		p = p[len(f):]
+	}
+
+	return total, nil
+}
+
+// detachedReader puts the tags back after each frame, so the Reader sees an
+// ordinary stream.
+type detachedReader struct {
+	r, tags io.Reader
+	frame   int
+	tagSize int
+
+	buf  []byte
+	data []byte
+	eof  bool
+}
+
+func newDetachedReader(r, tags io.Reader, frame, tagSize int) *detachedReader {
+	return &detachedReader{r: r, tags: tags, frame: frame, tagSize: tagSize, buf: make([]byte, frame)}
+}
+
+func (d *detachedReader) Read(p []byte) (int, error) {
+	for len(d.data) == 0 {
+		if d.eof {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.data)
+	d.data = d.data[n:]
+	return n, nil
+}
+
+// next reads a frame without its tag and then the tag. a short frame is the
+// last one, after which there must be no more tags. a missing final chunk
+// is left for the Reader to report.
+func (d *detachedReader) next() error {
+	n, err := io.ReadFull(d.r, d.buf[:d.frame-d.tagSize])
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		d.eof = true
+	default:
+		return err
+	}
+	if n > 0 {
+		if _, err := io.ReadFull(d.tags, d.buf[n:n+d.tagSize]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return errors.New("crypt: detached tags are truncated")
+			}
+			return err
+		}
+		d.data = d.buf[:n+d.tagSize]
+	}
+
+	if d.eof {
+		var b [1]byte
+		if n, _ := io.ReadFull(d.tags, b[:]); n != 0 {
+			return errors.New("crypt: detached tags are longer than the stream")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package crypt
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// TestDetachedTags round trips streams with their tags written separately
+// and makes sure the ciphertext is only the header, sequence numbers,
+// nonces and encrypted data.
+func TestDetachedTags(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 16
+
+	for _, size := range []int{0, 5, 2 * chunkSize, 3*chunkSize + 1} {
+		data := randBytes(size)
+		var stream, tags bytes.Buffer
+		w, err := NewWriter(&stream, key, WithChunkSize(chunkSize), WithDetachedTags(&tags), WithConcurrency(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		chunks := max(1, (size+chunkSize-1)/chunkSize)
+		if tags.Len() != chunks*w.Overhead() {
+			t.Fatalf("size %d: %d bytes of tags for %d chunks", size, tags.Len(), chunks)
+		}
+
+		r, err := NewReader(iotest.HalfReader(bytes.NewReader(stream.Bytes())), key, WithTagsFrom(iotest.OneByteReader(bytes.NewReader(tags.Bytes()))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("size %d: data did not round trip", size)
+		}
+
+		if _, err := NewReader(bytes.NewReader(stream.Bytes()), key); err == nil {
+			t.Fatal("read a detached stream without its tags")
+		}
+	}
+}
+
+// TestDetachedTagsInvalid makes sure tags that don't match the stream are
+// caught.
+func TestDetachedTagsInvalid(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	var stream, tags bytes.Buffer
+	w, _ := NewWriter(&stream, key, WithChunkSize(16), WithDetachedTags(&tags))
+	w.Write(randBytes(40))
+	w.Close()
+
+	flipped := bytes.Clone(tags.Bytes())
+	flipped[0] ^= 1
+	bad := [][]byte{
+		flipped,
+		tags.Bytes()[:tags.Len()-1],
+		append(bytes.Clone(tags.Bytes()), 0),
+		nil,
+	}
+	for i, b := range bad {
+		r, err := NewReader(bytes.NewReader(stream.Bytes()), key, WithTagsFrom(bytes.NewReader(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(r); err == nil {
+			t.Fatalf("%d: bad tags accepted", i)
+		}
+	}
+
+	if _, err := NewReader(bytes.NewReader(sealStream(t, key, nil, 16)), key, WithTagsFrom(&tags)); err == nil {
+		t.Fatal("accepted tags for a stream without detached tags")
+	}
+	if _, err := NewWriter(io.Discard, key, WithArchiveProfile(), WithDetachedTags(io.Discard)); err == nil {
+		t.Fatal("accepted detached tags with the archive profile")
+	}
+}
@@ -0,0 +1,83 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+
+	// registers crypto.BLAKE2b_256 and friends for WithDigest
+	_ "golang.org/x/crypto/blake2b"
+)
+
+// WithDigest makes NewWriter and NewReader hash the plaintext with h as it
+// goes by, such as crypto.SHA256 or crypto.BLAKE2b_256, for end to end
+// checksums without a second pass over the data. the digest is available
+// from Writer.Digest once Close returns and from Reader.Digest once Read
+// returns io.EOF. it covers the plaintext only, not a signature added by
+// WithSigner, and isn't stored in the stream.
+func WithDigest(h crypto.Hash) Option {
+	return func(c *config) error {
+		if !h.Available() {
+			return errors.New("crypt: digest hash is not available")
+		}
+		c.digest = h
+		return nil
+	}
+}
+
+// WithExpectedDigest is WithDigest for a Reader that also has to end up
+// with sum, such as one recorded by the Writer in a backup manifest. the
+// final batch of chunks is withheld until the digest matches, otherwise
+// Read fails with ErrDigestMismatch.
+func WithExpectedDigest(h crypto.Hash, sum []byte) Option {
+	return func(c *config) error {
+		if err := WithDigest(h)(c); err != nil {
+			return err
+		}
+		if len(sum) != h.Size() {
+			return errors.New("crypt: expected digest is the wrong size for its hash")
+		}
+		c.expectDigest = bytes.Clone(sum)
+		return nil
+	}
+}
+
+// Digest returns the digest of the plaintext given with WithDigest, nil
+// before Close has succeeded.
+func (w *Writer) Digest() []byte {
+	return bytes.Clone(w.sumOut)
+}
+
+// Digest returns the digest of the plaintext given with WithDigest, nil
+// before Read has returned io.EOF.
+func (r *Reader) Digest() []byte {
+	return bytes.Clone(r.sumOut)
+}
+
+// hash adds plaintext to the digests of w.
+func (w *Writer) hash(p []byte) {
+	if w.digest != nil {
+		w.digest.Write(p)
+	}
+	if w.sum != nil {
+		w.sum.Write(p)
+	}
+}
+
+// checkDigest adds a batch of chunks to r's digest, checking it against
+// the expected one at the end of the stream.
+func (r *Reader) checkDigest() error {
+	for _, chunk := range r.chunks {
+		r.sum.Write(chunk)
+	}
+	if !r.eof {
+		return nil
+	}
+
+	sum := r.sum.Sum(nil)
+	if r.expect != nil && !bytes.Equal(sum, r.expect) {
+		return ErrDigestMismatch
+	}
+	r.sumOut = sum
+	return nil
+}
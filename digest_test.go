@@ -0,0 +1,66 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestDigest(t *testing.T) {
+	key := randKey()
+	data := randBytes(3*1024 + 5)
+	want := sha256.Sum256(data)
+
+	var ct bytes.Buffer
+	w, err := NewWriter(&ct, key, WithChunkSize(1024), WithDigest(crypto.SHA256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if w.Digest() != nil {
+		t.Fatal("digest before Close")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.Digest(), want[:]) {
+		t.Fatalf("writer digest %x, want %x", w.Digest(), want)
+	}
+
+	r, err := NewReader(bytes.NewReader(ct.Bytes()), key, WithExpectedDigest(crypto.SHA256, want[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(r.Digest(), want[:]) {
+		t.Fatalf("reader digest %x", r.Digest())
+	}
+
+	wrong := blake2b.Sum256(data)
+	r, err = NewReader(bytes.NewReader(ct.Bytes()), key, WithExpectedDigest(crypto.SHA256, wrong[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("wrong digest: %v", err)
+	}
+
+	// any available hash works
+	r, _ = NewReader(bytes.NewReader(ct.Bytes()), key, WithExpectedDigest(crypto.BLAKE2b_256, wrong[:]))
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatalf("BLAKE2b: %v", err)
+	}
+
+	if _, err := NewReader(bytes.NewReader(ct.Bytes()), key, WithExpectedDigest(crypto.SHA256, want[:16])); err == nil {
+		t.Fatal("short digest accepted")
+	}
+}
@@ -0,0 +1,84 @@
+package crypt
+
+import (
+	"crypto"
+	"errors"
+	"hash"
+	"io"
+)
+
+// WithDryRun makes NewWriter and the writers built on it go through all
+// of the encryption but write nothing, not even the header, so w may be
+// nil. Writer.Size reports how large the stream would have been, and
+// Writer.Digest and Writer.CiphertextDigest what the stream's digests
+// would have been, for planning a backup or content addressing data before
+// storing it. the chunks are still sealed, so a dry run costs as much CPU
+// as the real thing.
+func WithDryRun() Option {
+	return func(c *config) error {
+		c.dryRun = true
+		return nil
+	}
+}
+
+// WithCiphertextDigest makes NewWriter and the writers built on it hash
+// the stream they write with h, header included, available from
+// Writer.CiphertextDigest once Close returns. random nonces make every
+// stream different, but with WithConvergentEncryption the same plaintext
+// gives the same stream and so the same digest, which a dry run can
+// compute to address content before it is stored.
+func WithCiphertextDigest(h crypto.Hash) Option {
+	return func(c *config) error {
+		if !h.Available() {
+			return errors.New("crypt: digest hash is not available")
+		}
+		c.ciphertextDigest = h
+		return nil
+	}
+}
+
+// Size returns how many bytes of the stream have been written, or would
+// have been with WithDryRun.
+func (w *Writer) Size() int64 {
+	if w.sink == nil {
+		return 0
+	}
+	return w.sink.n
+}
+
+// CiphertextDigest returns the digest of the stream given with
+// WithCiphertextDigest, nil before Close has succeeded.
+func (w *Writer) CiphertextDigest() []byte {
+	if w.err != ErrClosed || w.sink == nil || w.sink.sum == nil {
+		return nil
+	}
+	return w.sink.sum.Sum(nil)
+}
+
+// sinkWriter is what a Writer writes its stream to, it counts the bytes,
+// hashes them for WithCiphertextDigest and drops them for WithDryRun.
+type sinkWriter struct {
+	w   io.Writer
+	n   int64
+	sum hash.Hash
+}
+
+func newSinkWriter(w io.Writer, c *config) *sinkWriter {
+	s := &sinkWriter{w: w}
+	if c.dryRun {
+		s.w = io.Discard
+	}
+	if c.ciphertextDigest != 0 {
+		s.sum = c.ciphertextDigest.New()
+	}
+	return s
+}
+
+func (s *sinkWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.n += int64(n)
+	if s.sum != nil {
+		s.sum.Write(p[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,50 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha256"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	data := randBytes(100_000)
+	opts := []Option{WithConvergentEncryption(randKey()), WithChunkSize(4096), WithCiphertextDigest(crypto.SHA256)}
+
+	dry, err := NewWriter(nil, key, append(opts, WithDryRun())...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dry.Write(data)
+	if err := dry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if w.CiphertextDigest() != nil {
+		t.Fatal("digest before Close")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := crypto.SHA256.New()
+	sum.Write(buf.Bytes())
+	if dry.Size() != int64(buf.Len()) || w.Size() != int64(buf.Len()) {
+		t.Fatalf("dry run size %d, real size %d, wrote %d", dry.Size(), w.Size(), buf.Len())
+	}
+	if !bytes.Equal(dry.CiphertextDigest(), sum.Sum(nil)) || !bytes.Equal(w.CiphertextDigest(), sum.Sum(nil)) {
+		t.Fatal("dry run digest doesn't match the stream")
+	}
+
+	if _, err := NewMultipartWriter(key, nil, 1<<20, WithDryRun()); err == nil {
+		t.Fatal("dry run multipart upload was accepted")
+	}
+}
@@ -0,0 +1,172 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// EditFile decrypts the stream in path to a temporary file, runs command
+// with the temporary file's path appended to its arguments, and if the
+// program changed the file encrypts it back over path, keeping the chunk
+// size and cipher unless opts say otherwise. the program gets the
+// process's stdin, stdout and stderr, so an editor such as
+// []string{"vim"} works. it reports whether path was rewritten.
+//
+// the plaintext only exists in a directory only the current user can
+// enter, on linux under /dev/shm so it stays in memory. it is overwritten
+// and removed afterwards along with anything else the program left there,
+// such as swap files, even if the program fails. a memfd would keep it off
+// the file system entirely but editors save by renaming a new file over
+// the old one, which needs a real directory.
+func EditFile(path string, key *[32]byte, command []string, opts ...Option) (changed bool, err error) {
+	if len(command) == 0 {
+		return false, errors.New("crypt: no command to run")
+	}
+	c, err := newConfig(opts)
+	if err != nil {
+		return false, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h, _, err := readHeader(f)
+	if err != nil {
+		return false, err
+	}
+	if err := c.policy.check(h.cipher, h.version); err != nil {
+		return false, err
+	}
+	r, err := newReader(f, key, c, h)
+	if err != nil {
+		return false, err
+	}
+
+	dir, err := os.MkdirTemp(editTempDir(), "crypt-edit-")
+	if err != nil {
+		return false, err
+	}
+	defer wipeDir(dir)
+
+	tmp := filepath.Join(dir, filepath.Base(path)+".plain")
+	plain, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return false, err
+	}
+	before := &bytes.Buffer{}
+	_, err = r.WriteTo(io.MultiWriter(plain, before))
+	if cerr := plain.Close(); err == nil {
+		err = cerr
+	}
+	defer clear(before.Bytes())
+	if err != nil {
+		return false, err
+	}
+	perm := os.FileMode(0o600)
+	if st, err := f.Stat(); err == nil {
+		perm = st.Mode().Perm()
+	}
+	f.Close()
+
+	cmd := exec.Command(command[0], append(command[1:], tmp)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return false, err
+	}
+
+	after, err := os.ReadFile(tmp)
+	if err != nil {
+		return false, err
+	}
+	defer clear(after)
+	if bytes.Equal(after, before.Bytes()) {
+		return false, nil
+	}
+
+	// write next to path and rename, so a failure leaves the old file
+	out, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(out.Name())
+
+	keep := []Option{WithChunkSize(h.chunkSize), WithCipher(h.cipher)}
+	if _, ok := h.fields[fieldNoncePrefix]; ok {
+		keep = append(keep, WithCounterNonces())
+	}
+	if interval, _ := h.rekeyInterval(); interval != 0 {
+		keep = append(keep, WithRekeyAfter(int64(interval)*int64(h.chunkSize)))
+	}
+	opts = append(keep, opts...)
+	w, err := NewWriter(out, key, opts...)
+	if err == nil {
+		_, err = w.Write(after)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := os.Chmod(out.Name(), perm); err != nil {
+		return false, err
+	}
+	if err := os.Rename(out.Name(), path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// editTempDir is where EditFile puts plaintext, memory backed when there is
+// such a place.
+func editTempDir() string {
+	if runtime.GOOS == "linux" {
+		if st, err := os.Stat("/dev/shm"); err == nil && st.IsDir() {
+			return "/dev/shm"
+		}
+	}
+	return os.TempDir()
+}
+
+// wipeDir overwrites every regular file under dir with zeros and removes
+// it all. on flash storage or a journaling file system the old data may
+// survive the overwrite, it is a best effort.
+func wipeDir(dir string) {
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return nil
+		}
+		wipeFile(f)
+		f.Close()
+		return nil
+	})
+
+	os.RemoveAll(dir)
+}
+
+// zeroReader reads zeros forever.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
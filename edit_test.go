@@ -0,0 +1,67 @@
+package crypt
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestEditFile edits an encrypted file with shell commands standing in for
+// an editor, and makes sure the plaintext is gone afterwards.
+func TestEditFile(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	key := randKey()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.enc")
+	if err := os.WriteFile(path, sealStream(t, key, []byte("old"), 16), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	seen := filepath.Join(dir, "seen")
+
+	changed, err := EditFile(path, key, []string{"sh", "-c", `echo "$0" > ` + seen})
+	if err != nil || changed {
+		t.Fatalf("unchanged edit: %v %v", changed, err)
+	}
+	tmp, _ := os.ReadFile(seen)
+	if _, err := os.Stat(filepath.Dir(strings.TrimSpace(string(tmp)))); !os.IsNotExist(err) {
+		t.Fatalf("plaintext directory %s is still there", tmp)
+	}
+
+	changed, err = EditFile(path, key, []string{"sh", "-c", `cat "$0" > "$0.new" && printf ' new' >> "$0.new" && mv "$0.new" "$0"`})
+	if err != nil || !changed {
+		t.Fatalf("edit: %v %v", changed, err)
+	}
+	f, _ := os.Open(path)
+	defer f.Close()
+	r, err := NewReader(f, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if _, err := got.ReadFrom(r); err != nil || got.String() != "old new" {
+		t.Fatalf("got %q, %v", got.String(), err)
+	}
+	if st, _ := os.Stat(path); st.Mode().Perm() != 0o640 {
+		t.Fatalf("mode changed to %v", st.Mode())
+	}
+	if r.chunkSize != 16 {
+		t.Fatalf("chunk size changed to %d", r.chunkSize)
+	}
+
+	before, _ := os.ReadFile(path)
+	if _, err := EditFile(path, key, []string{"sh", "-c", `echo x > "$0"; exit 1`}); err == nil {
+		t.Fatal("failing command was not reported")
+	}
+	if _, err := EditFile(path, randKey(), []string{"true"}); err == nil {
+		t.Fatal("edited with the wrong key")
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(before, after) {
+		t.Fatal("file changed after failed edits")
+	}
+}
@@ -0,0 +1,432 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// fileNameInfo derives the key file names are encrypted with, see
+// WithEncryptedNames.
+const fileNameInfo = "crypt file names v1\x00"
+
+// maxEncryptedName is the longest name most file systems allow, encrypted
+// names longer than it can't be stored.
+const maxEncryptedName = 255
+
+// EncryptedFS is an fs.FS over a directory on disk whose files are
+// streams, each decrypted on Open. it implements fs.ReadDirFS and
+// fs.StatFS as well, and files support Seek and ReadAt, so it works with
+// http.FS, template.ParseFS and the like. sizes are those of the
+// plaintext. WriteFile, Create, MkdirAll and Remove change the directory.
+//
+// every file is bound to its name with WithAAD, so files can't be swapped
+// or moved on disk without failing to authenticate. with
+// WithEncryptedNames the names on disk are encrypted too, deterministically
+// so lookups don't need to scan directories, each bound to its directory.
+// directory structure and the size of every file are not hidden.
+//
+// like os.DirFS it doesn't stop symlinks on disk from leading out of dir.
+type EncryptedFS struct {
+	dir  string
+	key  *[32]byte
+	opts []Option
+
+	// names encrypts file names, nil if they are stored as is
+	names *NameCipher
+}
+
+// WithEncryptedNames makes an EncryptedFS encrypt file and directory names
+// on disk. names of more than about 175 bytes can't be encrypted.
+func WithEncryptedNames() Option {
+	return func(c *config) error {
+		c.encryptNames = true
+		return nil
+	}
+}
+
+// NewEncryptedFS returns an EncryptedFS over dir, encrypting with key.
+// opts apply to every file written as well as read, except WithAAD which
+// is replaced by the file's name.
+func NewEncryptedFS(dir string, key *[32]byte, opts ...Option) (*EncryptedFS, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &EncryptedFS{dir: dir, key: key, opts: slices.Clone(opts)}
+	if c.encryptNames {
+		if e.names, err = NewNameCipher(key); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// Open opens the named file for reading, decrypting it. directories can be
+// opened too and implement fs.ReadDirFile.
+func (e *EncryptedFS) Open(name string) (fs.File, error) {
+	p, err := e.diskPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, pathError("open", name, err)
+	}
+
+	info := &encryptedFileInfo{FileInfo: st, name: path.Base(name), size: st.Size()}
+	if st.IsDir() {
+		return &encryptedDir{fs: e, f: f, name: name, info: info}, nil
+	}
+	r, err := NewReaderAt(f, st.Size(), e.key, e.fileOpts(name)...)
+	if err != nil {
+		f.Close()
+		return nil, pathError("open", name, err)
+	}
+	info.size = r.Size()
+
+	return &encryptedFile{f: f, SectionReader: io.NewSectionReader(r, 0, r.Size()), info: info}, nil
+}
+
+// Stat returns the file info of the named file, with its plaintext size.
+func (e *EncryptedFS) Stat(name string) (fs.FileInfo, error) {
+	f, err := e.Open(name)
+	if err != nil {
+		return nil, pathError("stat", name, errors.Unwrap(err))
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+// ReadDir reads the named directory, returning its entries sorted by name.
+// entries whose names can't be decrypted are skipped.
+func (e *EncryptedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := e.Open(name)
+	if err != nil {
+		return nil, pathError("readdir", name, errors.Unwrap(err))
+	}
+	defer f.Close()
+	d, ok := f.(*encryptedDir)
+	if !ok {
+		return nil, pathError("readdir", name, errors.New("not a directory"))
+	}
+
+	entries, err := d.ReadDir(-1)
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, err
+}
+
+// WriteFile encrypts data to the named file, replacing it if it exists.
+func (e *EncryptedFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	w, err := e.create("writefile", name, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.abort()
+		return pathError("writefile", name, err)
+	}
+	return w.Close()
+}
+
+// Create returns a writer encrypting to the named file with mode 0o600.
+// nothing is visible under name until Close succeeds, when it replaces
+// any file already there.
+func (e *EncryptedFS) Create(name string) (io.WriteCloser, error) {
+	return e.create("create", name, 0o600)
+}
+
+// MkdirAll creates the named directory along with any parents that don't
+// exist yet.
+func (e *EncryptedFS) MkdirAll(name string, perm fs.FileMode) error {
+	p, err := e.diskPath("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p, perm); err != nil {
+		return pathError("mkdir", name, err)
+	}
+	return nil
+}
+
+// Remove removes the named file or empty directory.
+func (e *EncryptedFS) Remove(name string) error {
+	p, err := e.diskPath("remove", name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil {
+		return pathError("remove", name, err)
+	}
+	return nil
+}
+
+func (e *EncryptedFS) create(op, name string, perm fs.FileMode) (*encryptedFileWriter, error) {
+	if name == "." {
+		return nil, pathError(op, name, fs.ErrInvalid)
+	}
+	p, err := e.diskPath(op, name)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".crypt-tmp-*")
+	if err != nil {
+		return nil, pathError(op, name, err)
+	}
+	w, err := NewWriter(tmp, e.key, e.fileOpts(name)...)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, pathError(op, name, err)
+	}
+
+	return &encryptedFileWriter{Writer: w, tmp: tmp, path: p, name: name, op: op}, nil
+}
+
+// fileOpts returns the options for the stream of the named file.
+func (e *EncryptedFS) fileOpts(name string) []Option {
+	return append(slices.Clip(e.opts), WithAAD([]byte(name)))
+}
+
+// diskPath returns the path on disk of the named file, encrypting each
+// element of its name with its parent directory as the additional data.
+func (e *EncryptedFS) diskPath(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", pathError(op, name, fs.ErrInvalid)
+	}
+	if name == "." {
+		return e.dir, nil
+	}
+	if e.names == nil {
+		return filepath.Join(e.dir, filepath.FromSlash(name)), nil
+	}
+
+	elems := strings.Split(name, "/")
+	disk := make([]string, len(elems)+1)
+	disk[0] = e.dir
+	for i, elem := range elems {
+		enc, err := e.names.EncryptName(path.Join(elems[:i]...), elem)
+		if err != nil {
+			return "", pathError(op, name, err)
+		}
+		disk[i+1] = enc
+	}
+	return filepath.Join(disk...), nil
+}
+
+// plainName returns the name of an entry on disk in the directory dir,
+// false if it isn't one of ours.
+func (e *EncryptedFS) plainName(dir, disk string) (string, bool) {
+	if strings.HasPrefix(disk, ".crypt-tmp-") {
+		return "", false
+	}
+	if e.names == nil {
+		return disk, true
+	}
+
+	if dir == "." {
+		dir = ""
+	}
+	plain, err := e.names.DecryptName(dir, disk)
+	return plain, err == nil
+}
+
+// NameCipher encrypts file names deterministically, so a name can be
+// looked up without decrypting every name in its directory. each name is
+// bound to a context, such as the path or an ID of its directory, and only
+// decrypts with the same one, so names can't be moved between directories
+// and the same name in two of them looks different. see WithEncryptedNames.
+//
+// encrypted names are unpadded base64url, names of more than about 175
+// bytes don't fit in the 255 most file systems allow.
+type NameCipher struct {
+	aead cipher.AEAD
+}
+
+// NewNameCipher returns a NameCipher with a key derived from key.
+func NewNameCipher(key *[32]byte) (*NameCipher, error) {
+	nameKey, err := deriveKey(key, fileNameInfo)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCMSIV(nameKey)
+	if err != nil {
+		return nil, err
+	}
+	return &NameCipher{aead: aead}, nil
+}
+
+// EncryptName encrypts name, a single path element, bound to context.
+func (n *NameCipher) EncryptName(context, name string) (string, error) {
+	if !validName(name) {
+		return "", errors.New("crypt: " + strconv.Quote(name) + " is not a file name")
+	}
+	enc := base64.RawURLEncoding.EncodeToString(n.aead.Seal(nil, make([]byte, n.aead.NonceSize()), []byte(name), []byte(context)))
+	if len(enc) > maxEncryptedName {
+		return "", errors.New("crypt: name too long to encrypt")
+	}
+	return enc, nil
+}
+
+// DecryptName decrypts a name from EncryptName, which has to be given the
+// same context. the error matches ErrAuthenticationFailed for names that
+// aren't ours.
+func (n *NameCipher) DecryptName(context, enc string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		return "", &detailError{msg: "crypt: name is not base64", err: ErrAuthenticationFailed}
+	}
+	plain, err := n.aead.Open(nil, make([]byte, n.aead.NonceSize()), b, []byte(context))
+	if err != nil || !validName(string(plain)) {
+		return "", &detailError{msg: "crypt: name failed to authenticate", err: ErrAuthenticationFailed}
+	}
+	return string(plain), nil
+}
+
+// validName reports whether name is a single element of a path.
+func validName(name string) bool {
+	return name != "." && fs.ValidPath(name) && !strings.Contains(name, "/")
+}
+
+func pathError(op, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// encryptedFileInfo is the file info of a file on disk with its plaintext
+// name and size.
+type encryptedFileInfo struct {
+	fs.FileInfo
+	name string
+	size int64
+}
+
+func (i *encryptedFileInfo) Name() string { return i.name }
+func (i *encryptedFileInfo) Size() int64  { return i.size }
+func (i *encryptedFileInfo) Sys() any     { return nil }
+
+// encryptedFile is a file opened by EncryptedFS.Open.
+type encryptedFile struct {
+	*io.SectionReader
+	f    *os.File
+	info *encryptedFileInfo
+}
+
+func (f *encryptedFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *encryptedFile) Close() error               { return f.f.Close() }
+
+// encryptedDir is a directory opened by EncryptedFS.Open.
+type encryptedDir struct {
+	fs   *EncryptedFS
+	f    *os.File
+	name string
+	info *encryptedFileInfo
+}
+
+func (d *encryptedDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *encryptedDir) Close() error               { return d.f.Close() }
+
+func (d *encryptedDir) Read([]byte) (int, error) {
+	return 0, pathError("read", d.name, errors.New("is a directory"))
+}
+
+// ReadDir returns up to n entries as fs.ReadDirFile does, in the order
+// they are on disk.
+func (d *encryptedDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	for n <= 0 || len(entries) < n {
+		disk, err := d.f.ReadDir(max(n-len(entries), 0))
+		for _, de := range disk {
+			if name, ok := d.fs.plainName(d.name, de.Name()); ok {
+				entries = append(entries, &encryptedDirEntry{DirEntry: de, fs: d.fs, name: path.Join(d.name, name)})
+			}
+		}
+		if n <= 0 {
+			if err != nil {
+				return entries, pathError("readdir", d.name, err)
+			}
+			return entries, nil
+		}
+		if err != nil {
+			if err == io.EOF && len(entries) > 0 {
+				err = nil
+			}
+			return entries, err
+		}
+	}
+	return entries, nil
+}
+
+// encryptedDirEntry is an entry read from an encryptedDir, name is its
+// full plaintext name.
+type encryptedDirEntry struct {
+	fs.DirEntry
+	fs   *EncryptedFS
+	name string
+}
+
+func (d *encryptedDirEntry) Name() string { return path.Base(d.name) }
+
+// Info opens the file to find its plaintext size.
+func (d *encryptedDirEntry) Info() (fs.FileInfo, error) {
+	return d.fs.Stat(d.name)
+}
+
+// encryptedFileWriter encrypts to a temporary file that is renamed over
+// the real one on Close.
+type encryptedFileWriter struct {
+	*Writer
+	tmp  *os.File
+	path string
+	name string
+	op   string
+}
+
+func (w *encryptedFileWriter) Close() error {
+	err := w.Writer.Close()
+	if err == nil {
+		err = w.tmp.Sync()
+	}
+	if cerr := w.tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(w.tmp.Name(), w.path)
+	}
+	if err != nil {
+		os.Remove(w.tmp.Name())
+		return pathError(w.op, w.name, err)
+	}
+	return nil
+}
+
+// abort throws away what was written.
+func (w *encryptedFileWriter) abort() {
+	w.tmp.Close()
+	os.Remove(w.tmp.Name())
+}
+
+var (
+	_ fs.ReadDirFS   = (*EncryptedFS)(nil)
+	_ fs.StatFS      = (*EncryptedFS)(nil)
+	_ fs.ReadDirFile = (*encryptedDir)(nil)
+	_ io.ReadSeeker  = (*encryptedFile)(nil)
+	_ io.ReaderAt    = (*encryptedFile)(nil)
+)
@@ -0,0 +1,146 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEncryptedFS(t *testing.T) {
+	for _, names := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plain names", true: "encrypted names"}[names], func(t *testing.T) {
+			key := randKey()
+			dir := t.TempDir()
+			opts := []Option{WithChunkSize(1024)}
+			if names {
+				opts = append(opts, WithEncryptedNames())
+			}
+			efs, err := NewEncryptedFS(dir, key, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			big := randBytes(5000)
+			if err := efs.MkdirAll("static/css", 0o755); err != nil {
+				t.Fatal(err)
+			}
+			for name, data := range map[string][]byte{
+				"index.html":          []byte("<h1>hi</h1>"),
+				"static/big.bin":      big,
+				"static/css/site.css": []byte("body{}"),
+			} {
+				if err := efs.WriteFile(name, data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			w, err := efs.Create("static/empty")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := fstest.TestFS(efs, "index.html", "static/big.bin", "static/css/site.css", "static/empty"); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := fs.ReadFile(efs, "static/big.bin")
+			if err != nil || !bytes.Equal(got, big) {
+				t.Fatalf("ReadFile: %v", err)
+			}
+
+			// names on disk are only readable without WithEncryptedNames
+			_, err = os.Stat(filepath.Join(dir, "index.html"))
+			if names != errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("plain name on disk: %v", err)
+			}
+
+			srv := httptest.NewServer(http.FileServerFS(efs))
+			defer srv.Close()
+			req, _ := http.NewRequest("GET", srv.URL+"/static/big.bin", nil)
+			req.Header.Set("Range", "bytes=100-199")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, big[100:200]) {
+				t.Fatalf("range request: %s, %d bytes", resp.Status, len(body))
+			}
+
+			if err := efs.Remove("static/empty"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := efs.Stat("static/empty"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("Stat after Remove: %v", err)
+			}
+		})
+	}
+}
+
+func TestEncryptedFSSwap(t *testing.T) {
+	key := randKey()
+	dir := t.TempDir()
+	efs, err := NewEncryptedFS(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := efs.WriteFile("a", []byte("a"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := efs.WriteFile("b", []byte("b"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// files are bound to their names
+	if err := os.Rename(filepath.Join(dir, "a"), filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := efs.Open("b"); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("moved file opened: %v", err)
+	}
+
+	if _, err := efs.Open("../etc/passwd"); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("invalid path: %v", err)
+	}
+}
+
+func TestNameCipher(t *testing.T) {
+	t.Parallel()
+	n, err := NewNameCipher(randKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := n.EncryptName("dir", "secret.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := n.EncryptName("dir", "secret.txt"); again != enc {
+		t.Fatal("names aren't deterministic")
+	}
+	if other, _ := n.EncryptName("other", "secret.txt"); other == enc {
+		t.Fatal("name isn't bound to its context")
+	}
+	if got, err := n.DecryptName("dir", enc); err != nil || got != "secret.txt" {
+		t.Fatalf("DecryptName = %q, %v", got, err)
+	}
+	if _, err := n.DecryptName("other", enc); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("wrong context: %v", err)
+	}
+
+	for _, bad := range []string{"", ".", "..", "a/b", string(make([]byte, 200))} {
+		if _, err := n.EncryptName("", bad); err == nil {
+			t.Fatalf("%q encrypted", bad)
+		}
+	}
+}
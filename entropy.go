@@ -0,0 +1,187 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"sync"
+	"time"
+)
+
+// EntropyPool is a Fortuna style generator (Ferguson, Schneier and Kohno,
+// Cryptography Engineering, chapter 9) that mixes extra entropy sources,
+// such as a hardware RNG or jitter measurements, into the output of
+// crypto/rand. events from the sources are spread over 32 pools which are
+// folded into the generator key on a schedule, so an attacker who controls
+// some of the inputs or has seen the state can't predict the output for
+// long. fresh crypto/rand output is also mixed in on every Read, so the
+// output is never weaker than crypto/rand alone.
+type EntropyPool struct {
+	mu sync.Mutex
+
+	// key and counter are the AES-256-CTR generator state
+	key     [32]byte
+	counter [aes.BlockSize]byte
+
+	pools [entropyPools]hash.Hash
+
+	// pool0 counts the bytes added to the first pool since the last reseed
+	pool0 int
+
+	// next is the pool each source adds its next event to
+	next [256]byte
+
+	reseeds    uint64
+	lastReseed time.Time
+}
+
+// Fortuna parameters, see the book.
+const (
+	entropyPools    = 32
+	minPoolSize     = 64
+	reseedInterval  = 100 * time.Millisecond
+	maxRequestBytes = 1 << 20
+)
+
+// NewEntropyPool returns a pool whose generator key is seeded from
+// crypto/rand, feed it with AddEntropy.
+func NewEntropyPool() (*EntropyPool, error) {
+	p := &EntropyPool{}
+	if _, err := io.ReadFull(rand.Reader, p.key[:]); err != nil {
+		return nil, err
+	}
+	for i := range p.pools {
+		p.pools[i] = sha256.New()
+	}
+
+	return p, nil
+}
+
+// AddEntropy adds an event from source, a number the caller picks for each
+// entropy source, e.g. 0 for a hardware RNG and 1 for interrupt timings.
+// events from one source go to each pool in turn, as Fortuna requires.
+// events longer than 32 bytes are hashed first.
+func (p *EntropyPool) AddEntropy(source byte, data []byte) {
+	if len(data) > 32 {
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := p.next[source]
+	p.next[source] = (i + 1) % entropyPools
+	p.pools[i].Write([]byte{source, byte(len(data))})
+	p.pools[i].Write(data)
+	if i == 0 {
+		p.pool0 += len(data)
+	}
+}
+
+// Read fills b with random bytes.
+func (p *EntropyPool) Read(b []byte) (int, error) {
+	var fresh [32]byte
+	if _, err := io.ReadFull(rand.Reader, fresh[:]); err != nil {
+		return 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pool0 >= minPoolSize && time.Since(p.lastReseed) >= reseedInterval {
+		p.reseed()
+	}
+	p.rekey(fresh[:])
+
+	n := 0
+	for n < len(b) {
+		// limit how much is generated from one key
+		n += p.generate(b[n:min(len(b), n+maxRequestBytes)])
+		p.rekey(nil)
+	}
+
+	return n, nil
+}
+
+// reseed folds the pools into the key, pool i is used on every 2^i-th
+// reseed so the later pools build up enough entropy to recover from a
+// compromise even if an attacker feeds the early pools.
+func (p *EntropyPool) reseed() {
+	p.reseeds++
+	var seed []byte
+	for i := range p.pools {
+		if p.reseeds%(1<<i) != 0 {
+			break
+		}
+
+		seed = p.pools[i].Sum(seed)
+		p.pools[i].Reset()
+	}
+
+	p.pool0 = 0
+	p.lastReseed = time.Now()
+	p.rekey(seed)
+}
+
+// rekey replaces the key with a hash of the old key, extra and generator
+// output, so earlier output can't be recovered from the new key.
+func (p *EntropyPool) rekey(extra []byte) {
+	var out [32]byte
+	p.generate(out[:])
+
+	h := sha256.New()
+	h.Write(p.key[:])
+	h.Write(out[:])
+	h.Write(extra)
+	h.Sum(p.key[:0])
+}
+
+// generate fills b from AES-256-CTR under the current key.
+func (p *EntropyPool) generate(b []byte) int {
+	block, err := aes.NewCipher(p.key[:])
+	if err != nil {
+		panic(err) // the key is always 32 bytes
+	}
+
+	clear(b)
+	cipher.NewCTR(block, p.counter[:]).XORKeyStream(b, b)
+
+	// move the counter past the blocks just used
+	blocks := uint64(len(b)+aes.BlockSize-1) / aes.BlockSize
+	for i := len(p.counter) - 1; i >= 0 && blocks != 0; i-- {
+		sum := uint64(p.counter[i]) + blocks&0xff
+		p.counter[i] = byte(sum)
+		blocks = blocks>>8 + sum>>8
+	}
+
+	return len(b)
+}
+
+// SetEntropySource makes the package take all its randomness (keys,
+// nonces, salts) from r instead of crypto/rand, e.g. an EntropyPool. it
+// must be called before anything else in the package is used, typically at
+// the start of main, and is not safe to call concurrently with anything.
+func SetEntropySource(r io.Reader) {
+	randReader = r
+}
+
+// WithEntropySource makes a single call take its randomness (nonces, data
+// keys, salts) from r instead of the package's source, see
+// SetEntropySource. a reader seeded with a fixed value makes streams
+// reproducible for tests, never do that for real data since reused nonces
+// give away the plaintext. if r fails the call returns its error.
+func WithEntropySource(r io.Reader) Option {
+	return func(c *config) error {
+		if r == nil {
+			return errors.New("crypt: nil entropy source")
+		}
+
+		c.random = r
+		return nil
+	}
+}
@@ -0,0 +1,111 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"testing"
+	"testing/iotest"
+)
+
+// TestEntropyPool makes sure the pool produces distinct output and reseeds
+// on the Fortuna schedule.
+func TestEntropyPool(t *testing.T) {
+	t.Parallel()
+	p, err := NewEntropyPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.key == [32]byte{} {
+		t.Fatal("generator key wasn't seeded")
+	}
+
+	a, b := make([]byte, 100), make([]byte, 100)
+	p.Read(a)
+	p.Read(b)
+	if bytes.Equal(a, b) || bytes.Equal(a, make([]byte, 100)) {
+		t.Fatal("pool output repeated")
+	}
+
+	// events go to each pool in turn, two rounds fill the first pool enough
+	// to reseed
+	for range 2 * entropyPools {
+		p.AddEntropy(7, randBytes(32))
+	}
+	if p.pool0 != minPoolSize {
+		t.Fatalf("pool 0 holds %d bytes", p.pool0)
+	}
+	p.Read(a)
+	if p.reseeds != 1 || p.pool0 != 0 {
+		t.Fatalf("got %d reseeds, pool 0 at %d", p.reseeds, p.pool0)
+	}
+
+	// large reads cross the per key limit
+	big := make([]byte, 2*maxRequestBytes+5)
+	if n, err := p.Read(big); err != nil || n != len(big) {
+		t.Fatalf("Read gave %d, %v", n, err)
+	}
+	if bytes.Equal(big[:32], big[maxRequestBytes:maxRequestBytes+32]) {
+		t.Fatal("output repeated across a rekey")
+	}
+}
+
+// TestCounter checks the generator counter carries across bytes.
+func TestCounter(t *testing.T) {
+	t.Parallel()
+	p, _ := NewEntropyPool()
+	p.counter[15] = 0xff
+	p.generate(make([]byte, 2*16))
+	if p.counter[15] != 1 || p.counter[14] != 1 {
+		t.Fatalf("counter is %x", p.counter)
+	}
+}
+
+// TestWithEntropySource makes sure a seeded source gives the same stream at
+// any concurrency and a failing one is an error rather than a panic.
+func TestWithEntropySource(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	data := randBytes(10 * 64)
+
+	seal := func(random io.Reader, concurrency int) ([]byte, error) {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, key, WithChunkSize(64), WithConcurrency(concurrency), WithEntropySource(random))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		err = w.Close()
+		return buf.Bytes(), err
+	}
+
+	seed := [32]byte{'s', 'e', 'e', 'd'}
+	a, err := seal(rand.NewChaCha8(seed), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := seal(rand.NewChaCha8(seed), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Fatal("the same source gave different streams")
+	}
+	if c, _ := seal(rand.NewChaCha8([32]byte{}), 1); bytes.Equal(a, c) {
+		t.Fatal("different sources gave the same stream")
+	}
+
+	failing := iotest.ErrReader(errors.New("no entropy"))
+	if _, err := seal(failing, 1); err == nil {
+		t.Fatal("sealed a stream without randomness")
+	}
+	if _, err := Encrypt(data, key, WithEntropySource(failing)); err == nil {
+		t.Fatal("encrypted without randomness")
+	}
+	if _, err := NewPasswordWriter(io.Discard, []byte("pw"), WithEntropySource(failing)); err == nil {
+		t.Fatal("made a salt without randomness")
+	}
+}
@@ -0,0 +1,87 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+)
+
+// ctrHMAC is AES-256-CTR then HMAC-SHA256 over
+//
+//	additional data | nonce | ciphertext | len(additional data) | len(ciphertext)
+//
+// with the lengths as big endian uint64s so no two inputs share a MAC. the
+// nonce is the initial counter block.
+type ctrHMAC struct {
+	block cipher.Block
+	mac   func() hash.Hash
+}
+
+func newCTRHMAC(key *[32]byte) (cipher.AEAD, error) {
+	encKey, err := deriveKey(key, ctrEncInfo)
+	if err != nil {
+		return nil, err
+	}
+	macKey, err := deriveKey(key, ctrMACInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(encKey[:])
+	if err != nil {
+		return nil, err
+	}
+	return &ctrHMAC{block: block, mac: func() hash.Hash { return hmac.New(sha256.New, macKey[:]) }}, nil
+}
+
+func (c *ctrHMAC) NonceSize() int {
+	return aes.BlockSize
+}
+
+func (c *ctrHMAC) Overhead() int {
+	return sha256.Size
+}
+
+func (c *ctrHMAC) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != aes.BlockSize {
+		panic("crypt: incorrect nonce length given to AES-CTR+HMAC")
+	}
+	ret, out := sliceForAppend(dst, len(plaintext)+sha256.Size)
+	ct := out[:len(plaintext)]
+	cipher.NewCTR(c.block, nonce).XORKeyStream(ct, plaintext)
+	c.tag(out[len(plaintext):len(plaintext)], nonce, ct, additionalData)
+	return ret
+}
+
+func (c *ctrHMAC) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != aes.BlockSize {
+		panic("crypt: incorrect nonce length given to AES-CTR+HMAC")
+	}
+	if len(ciphertext) < sha256.Size {
+		return nil, errOpen
+	}
+	ct, tag := ciphertext[:len(ciphertext)-sha256.Size], ciphertext[len(ciphertext)-sha256.Size:]
+	if !hmac.Equal(c.tag(nil, nonce, ct, additionalData), tag) {
+		return nil, errOpen
+	}
+
+	ret, out := sliceForAppend(dst, len(ct))
+	cipher.NewCTR(c.block, nonce).XORKeyStream(out, ct)
+	return ret, nil
+}
+
+// tag appends the MAC of ciphertext to dst.
+func (c *ctrHMAC) tag(dst, nonce, ciphertext, additionalData []byte) []byte {
+	m := c.mac()
+	m.Write(additionalData)
+	m.Write(nonce)
+	m.Write(ciphertext)
+	var lengths [16]byte
+	binary.BigEndian.PutUint64(lengths[:], uint64(len(additionalData)))
+	binary.BigEndian.PutUint64(lengths[8:], uint64(len(ciphertext)))
+	m.Write(lengths[:])
+	return m.Sum(dst)
+}
@@ -0,0 +1,69 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestCTRHMAC(t *testing.T) {
+	key := randKey()
+	data := randBytes(3*DefaultBlockSize + 7)
+
+	var ct bytes.Buffer
+	w, err := NewWriter(&ct, key, WithCipher(AES256CTRHMAC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(ct.Bytes()), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("stream round trip: %v", err)
+	}
+
+	// every bit of the ciphertext and tag is covered by the MAC
+	for _, i := range []int{ct.Len() / 2, ct.Len() - 1} {
+		bad := bytes.Clone(ct.Bytes())
+		bad[i] ^= 1
+		r, err := NewReader(bytes.NewReader(bad), key)
+		if err == nil {
+			_, err = io.ReadAll(r)
+		}
+		if !errors.Is(err, ErrAuthenticationFailed) {
+			t.Fatalf("flipped byte %d: %v", i, err)
+		}
+	}
+}
+
+func TestCTRHMACAdditionalData(t *testing.T) {
+	key := randKey()
+	aead, err := AES256CTRHMAC.NewAEAD(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := randBytes(aead.NonceSize())
+
+	// moving bytes between the additional data and the ciphertext has to
+	// change the tag
+	sealed := aead.Seal(nil, nonce, []byte("bc"), []byte("a"))
+	if _, err := aead.Open(nil, nonce, sealed, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := aead.Open(nil, nonce, sealed, []byte("ab")); err == nil {
+		t.Fatal("opened with different additional data")
+	}
+	if _, err := aead.Open(nil, nonce, sealed[:10], nil); err == nil {
+		t.Fatal("opened a short ciphertext")
+	}
+}
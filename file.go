@@ -0,0 +1,123 @@
+package crypt
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// EncryptFile encrypts the file at path in place, as NewWriter would with
+// key and opts. the stream is written to a temporary file in the same
+// directory, synced and renamed over path, so a crash leaves either the old
+// file or the new one, never a mix. permissions and ownership are kept.
+// the plaintext is then overwritten with zeros through the old file, which
+// needs write access to it. on flash storage or a journaling or copy on
+// write file system the old data may survive, so it is a best effort.
+// files with more than one hard link are refused, the other links would
+// keep the plaintext.
+func EncryptFile(path string, key *[32]byte, opts ...Option) error {
+	return replaceFile(path, true, func(dst io.Writer, src io.Reader) error {
+		w, err := NewWriter(dst, key, opts...)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, src); err != nil {
+			return err
+		}
+		return w.Close()
+	})
+}
+
+// DecryptFile decrypts the stream at path in place, the same way
+// EncryptFile encrypts it. the file is only replaced once the whole stream
+// has authenticated, on any error path is left as it was and the partial
+// plaintext is overwritten and removed.
+func DecryptFile(path string, key *[32]byte, opts ...Option) error {
+	return replaceFile(path, false, func(dst io.Writer, src io.Reader) error {
+		r, err := NewReader(src, key, opts...)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(dst, r)
+		return err
+	})
+}
+
+// replaceFile atomically replaces the file at path with convert's output,
+// wiping the old contents afterwards if wipe is set.
+func replaceFile(path string, wipe bool, convert func(dst io.Writer, src io.Reader) error) (err error) {
+	flag := os.O_RDONLY
+	if wipe {
+		flag = os.O_RDWR
+	}
+	src, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	st, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if !st.Mode().IsRegular() {
+		return errors.New("crypt: " + path + " is not a regular file")
+	}
+	if wipe && hardLinks(st) > 1 {
+		return errors.New("crypt: " + path + " has other hard links that would keep the plaintext")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			wipeFile(tmp)
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err := convert(tmp, src); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(st.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := chownLike(tmp, st); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	if wipe {
+		return wipeFile(src)
+	}
+	return nil
+}
+
+// wipeFile overwrites the contents of f with zeros and syncs it.
+func wipeFile(f *os.File) error {
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, zeroReader{}, st.Size()); err != nil {
+		return err
+	}
+	return f.Sync()
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package crypt
+
+import (
+	"io/fs"
+	"os"
+)
+
+// chownLike does nothing, files have no unix owner on this platform.
+func chownLike(f *os.File, st fs.FileInfo) error {
+	return nil
+}
+
+// hardLinks returns 1, link counts aren't available on this platform.
+func hardLinks(st fs.FileInfo) uint64 {
+	return 1
+}
+
+// syncDir does nothing, directories can't be synced on this platform.
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,106 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptFile(t *testing.T) {
+	key := randKey()
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	plain := randBytes(3*DefaultBlockSize + 11)
+	if err := os.WriteFile(path, plain, 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	// keep the old inode around to check it was wiped
+	old, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+
+	if err := EncryptFile(path, key); err != nil {
+		t.Fatal(err)
+	}
+	ct, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(ct), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pt, err := io.ReadAll(r); err != nil || !bytes.Equal(pt, plain) {
+		t.Fatalf("encrypted file doesn't decrypt: %v", err)
+	}
+	if st, err := os.Stat(path); err != nil || st.Mode().Perm() != 0o640 {
+		t.Fatalf("mode = %v, %v", st.Mode(), err)
+	}
+	wiped := make([]byte, len(plain))
+	if _, err := old.ReadAt(wiped, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wiped, make([]byte, len(plain))) {
+		t.Fatal("old plaintext was not wiped")
+	}
+
+	if err := DecryptFile(path, key); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("decrypted file differs: %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("temporary files left behind: %v %v", entries, err)
+	}
+}
+
+func TestDecryptFileDamaged(t *testing.T) {
+	key := randKey()
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, randBytes(2*DefaultBlockSize), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncryptFile(path, key); err != nil {
+		t.Fatal(err)
+	}
+	ct, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct[len(ct)-1] ^= 1
+	if err := os.WriteFile(path, ct, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DecryptFile(path, key); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("got %v, want ErrAuthenticationFailed", err)
+	}
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, ct) {
+		t.Fatal("damaged file was replaced")
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Fatalf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestEncryptFileHardLinks(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a")
+	if err := os.WriteFile(path, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(path, filepath.Join(dir, "b")); err != nil {
+		t.Skip(err)
+	}
+	if err := EncryptFile(path, randKey()); err == nil {
+		t.Fatal("file with two links encrypted")
+	}
+}
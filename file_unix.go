@@ -0,0 +1,44 @@
+//go:build unix
+
+package crypt
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// chownLike gives f the owner and group of the file st describes, if they
+// differ from f's.
+func chownLike(f *os.File, st fs.FileInfo) error {
+	want, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	cur, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if have, ok := cur.Sys().(*syscall.Stat_t); ok && have.Uid == want.Uid && have.Gid == want.Gid {
+		return nil
+	}
+	return f.Chown(int(want.Uid), int(want.Gid))
+}
+
+// hardLinks returns the number of links to the file st describes.
+func hardLinks(st fs.FileInfo) uint64 {
+	if s, ok := st.Sys().(*syscall.Stat_t); ok {
+		return uint64(s.Nlink)
+	}
+	return 1
+}
+
+// syncDir syncs the directory dir, so a rename in it survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
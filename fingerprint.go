@@ -0,0 +1,57 @@
+package crypt
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+)
+
+// WithKeyFingerprint records the fingerprint of the key in the stream
+// header, see Key.Fingerprint. Inspect reports it and readers given another
+// key fail up front with an error naming both fingerprints and matching
+// ErrWrongKey instead of a bare authentication failure, so operators can
+// tell which key a file needs.
+//
+// the fingerprint is not secret but it does link every stream written with
+// the same key. it only applies to streams encrypted with a key given
+// directly, not to password, recipient or KMS streams whose keys operators
+// never hold.
+func WithKeyFingerprint() Option {
+	return func(c *config) error {
+		c.fingerprint = true
+		return nil
+	}
+}
+
+// fingerprintField returns the fingerprint field of a stream encrypted
+// with key whose header holds fields.
+func fingerprintField(key *[32]byte, fields map[byte][]byte) ([]byte, error) {
+	for _, f := range []byte{fieldKDF, fieldRecipients, fieldKMS} {
+		if _, ok := fields[f]; ok {
+			return nil, errors.New("crypt: WithKeyFingerprint only applies to streams encrypted with a key given directly")
+		}
+	}
+	return rawFingerprint(key)
+}
+
+// checkFingerprint makes sure key is the one the stream with header h was
+// written with, if it recorded a fingerprint.
+func checkFingerprint(h *header, key *[32]byte) error {
+	want, ok := h.fields[fieldFingerprint]
+	if !ok {
+		return nil
+	}
+	if len(want) != 16 {
+		return errBadHeader("invalid key fingerprint field")
+	}
+
+	got, err := rawFingerprint(key)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		msg := "crypt: stream was encrypted with key " + hex.EncodeToString(want) + ", not " + hex.EncodeToString(got)
+		return &detailError{msg: msg, err: ErrWrongKey}
+	}
+	return nil
+}
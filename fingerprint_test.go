@@ -0,0 +1,71 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestKeyFingerprint(t *testing.T) {
+	t.Parallel()
+	key, other := (*Key)(randKey()), (*Key)(randKey())
+
+	fp := key.Fingerprint()
+	if len(fp) != 32 || fp != key.Fingerprint() || fp == other.Fingerprint() {
+		t.Fatalf("fingerprints %s and %s", fp, other.Fingerprint())
+	}
+	if strings.Contains(fp, key.Hex()[:8]) || !strings.Contains(key.String(), fp) {
+		t.Fatalf("String() = %s", key)
+	}
+
+	same := *key
+	if !key.Equal(&same) || key.Equal(other) || !ConstantTimeEqual((*[32]byte)(key), (*[32]byte)(&same)) {
+		t.Fatal("Equal is wrong")
+	}
+}
+
+func TestWithKeyFingerprint(t *testing.T) {
+	key, wrong := randKey(), randKey()
+	data := randBytes(100)
+
+	var ct bytes.Buffer
+	w, err := NewWriter(&ct, key, WithKeyFingerprint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := Inspect(bytes.NewReader(ct.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.KeyFingerprint != (*Key)(key).Fingerprint() {
+		t.Fatalf("Inspect reports fingerprint %q", info.KeyFingerprint)
+	}
+
+	r, err := NewReader(bytes.NewReader(ct.Bytes()), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("round trip: %v", err)
+	}
+
+	_, err = NewReader(bytes.NewReader(ct.Bytes()), wrong)
+	if !errors.Is(err, ErrWrongKey) || !errors.Is(err, ErrAuthenticationFailed) || !strings.Contains(err.Error(), info.KeyFingerprint) {
+		t.Fatalf("wrong key: %v", err)
+	}
+	_, err = NewReaderAt(bytes.NewReader(ct.Bytes()), int64(ct.Len()), wrong)
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("wrong key with NewReaderAt: %v", err)
+	}
+
+	if _, err := NewPasswordWriter(io.Discard, []byte("password"), WithKeyFingerprint()); err == nil {
+		t.Fatal("fingerprint recorded for a password stream")
+	}
+}
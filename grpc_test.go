@@ -0,0 +1,53 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// jsonCodec stands in for the gRPC proto codec.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return "json" }
+
+func TestGRPCCodec(t *testing.T) {
+	kr := NewKeyring()
+	kr.Add(1, randKey())
+	kr.SetPrimary(1)
+	codec := NewGRPCCodec(jsonCodec{}, kr)
+	if codec.Name() != "json" {
+		t.Fatalf("Name = %q", codec.Name())
+	}
+
+	type msg struct{ Secret string }
+	data, err := codec.Marshal(msg{"hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("hunter2")) {
+		t.Fatal("payload isn't encrypted")
+	}
+
+	// rotate, old payloads still decode
+	kr.Add(2, randKey())
+	kr.SetPrimary(2)
+	var got msg
+	if err := codec.Unmarshal(data, &got); err != nil || got.Secret != "hunter2" {
+		t.Fatalf("Unmarshal = %+v, %v", got, err)
+	}
+
+	kr.Remove(1)
+	if err := codec.Unmarshal(data, &got); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("removed key: %v", err)
+	}
+
+	data, _ = codec.Marshal(msg{"x"})
+	data[len(data)-1] ^= 1
+	if err := codec.Unmarshal(data, &got); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("tampered payload: %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"maps"
+	"math"
+	"strconv"
+)
+
+// every stream starts with a header, authenticated as part of the additional
+// data of every chunk, laid out as
+//
+//	magic | version | cipher | chunk size | fields length | fields
+//
+// where magic is the 5 bytes "CRYPT", version and cipher are single bytes,
+// chunk size is a big endian uint32 and fields length a big endian uint16.
+// fields is a list of type (1 byte), length (big endian uint16), value
+// entries for everything optional.
+const (
+	headerMagic   = "CRYPT"
+	headerVersion = 1
+
+	// headerFixedSize is the size of a header with no fields
+	headerFixedSize = len(headerMagic) + 1 + 1 + 4 + 2
+
+	// maxChunkSize bounds the chunk size a header may ask for, so a hostile
+	// stream can't make the Reader allocate gigabytes.
+	maxChunkSize = 64 << 20
+)
+
+// errHeaderTooLarge is returned when the header fields don't fit in the
+// 64 KiB the fields length allows, such as with many recipients and a lot
+// of metadata.
+var errHeaderTooLarge = errors.New("crypt: header fields are too large to fit in the header")
+
+// header describes how a stream was written.
+type header struct {
+	version   byte
+	cipher    Cipher
+	chunkSize int
+
+	// fields holds the optional entries by type
+	fields map[byte][]byte
+}
+
+// header field types.
+const (
+	// fieldKDF holds the KDF, its parameters and salt for streams made by
+	// NewPasswordWriter
+	fieldKDF = 1
+
+	// fieldKeyID holds the ID of the Keyring key a stream was written with,
+	// as a big endian uint32
+	fieldKeyID = 2
+
+	// fieldRecipients holds the data key wrapped for each recipient of a
+	// stream made by NewRecipientWriter. it is left out of the additional
+	// data so recipients can be changed without touching the chunks
+	fieldRecipients = 3
+
+	// fieldDoc holds a plain text description of the format, for archive
+	// streams
+	fieldDoc = 4
+
+	// fieldParity holds the number of chunks per parity chunk as a big
+	// endian uint16, see WithArchiveProfile
+	fieldParity = 5
+
+	// fieldMetadata holds the key value pairs set with WithMetadata
+	fieldMetadata = 6
+
+	// fieldDetached is empty and marks a stream whose tags were written
+	// elsewhere, see WithDetachedTags
+	fieldDetached = 7
+
+	// fieldSigner holds the ed25519 public key of a signed stream, see
+	// WithSigner
+	fieldSigner = 8
+
+	// fieldNoncePrefix holds the random value chunk nonces and the stream
+	// key are derived from, see WithCounterNonces
+	fieldNoncePrefix = 9
+
+	// fieldRekey holds the number of chunks sealed with each key as a big
+	// endian uint64, see WithRekeyAfter
+	fieldRekey = 10
+
+	// fieldTimestamp holds when the stream was written in seconds since
+	// the Unix epoch as a big endian uint64, see WithTimestamp
+	fieldTimestamp = 11
+
+	// fieldKMS holds the data key of a stream made by NewKMSWriter as
+	// wrapped by its KeyProvider
+	fieldKMS = 12
+
+	// fieldFingerprint holds the fingerprint of the stream key, see
+	// WithKeyFingerprint
+	fieldFingerprint = 13
+
+	// fieldStreamID holds the random ID of the stream, see WithStreamID
+	fieldStreamID = 14
+
+	// fieldObjectID holds the id the stream key was derived from the
+	// master key with, see NewDerivedWriter
+	fieldObjectID = 15
+)
+
+// knownHeaderFields lists the field types this version understands, a
+// header with any other field is rejected since we can't know what it
+// changes about the stream.
+var knownHeaderFields = map[byte]bool{
+	fieldKDF:         true,
+	fieldKeyID:       true,
+	fieldRecipients:  true,
+	fieldDoc:         true,
+	fieldParity:      true,
+	fieldMetadata:    true,
+	fieldDetached:    true,
+	fieldSigner:      true,
+	fieldNoncePrefix: true,
+	fieldRekey:       true,
+	fieldTimestamp:   true,
+	fieldKMS:         true,
+	fieldFingerprint: true,
+	fieldStreamID:    true,
+	fieldObjectID:    true,
+}
+
+// marshal encodes h, it fails if a field or all of them together are too
+// long for their length prefix, rather than write a header that can't be
+// read back.
+func (h *header) marshal() ([]byte, error) {
+	n := 0
+	for _, v := range h.fields {
+		if len(v) > math.MaxUint16 {
+			return nil, errHeaderTooLarge
+		}
+		n += 3 + len(v)
+	}
+	if n > math.MaxUint16 {
+		return nil, errHeaderTooLarge
+	}
+	return h.encode(), nil
+}
+
+// encode encodes h without checking the lengths, for headers that were
+// read or already marshalled.
+func (h *header) encode() []byte {
+	var fields []byte
+	for typ := 0; typ < 256; typ++ {
+		v, ok := h.fields[byte(typ)]
+		if !ok {
+			continue
+		}
+
+		fields = append(fields, byte(typ))
+		fields = binary.BigEndian.AppendUint16(fields, uint16(len(v)))
+		fields = append(fields, v...)
+	}
+
+	b := make([]byte, 0, headerFixedSize+len(fields))
+	b = append(b, headerMagic...)
+	b = append(b, h.version, byte(h.cipher))
+	b = binary.BigEndian.AppendUint32(b, uint32(h.chunkSize))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(fields)))
+	b = append(b, fields...)
+	if debug {
+		trace("header %x", b)
+	}
+	return b
+}
+
+// aad returns the header as it is authenticated by every chunk, which is
+// the encoded header without the recipients field. fields must be in order
+// for a header to be read, so this is the raw header for other streams.
+func (h *header) aad() []byte {
+	if _, ok := h.fields[fieldRecipients]; !ok {
+		return h.encode()
+	}
+
+	c := *h
+	c.fields = maps.Clone(h.fields)
+	delete(c.fields, fieldRecipients)
+	return c.encode()
+}
+
+// readHeader reads and validates a header from r, it returns the header and
+// the raw bytes it was parsed from.
+func readHeader(r io.Reader) (*header, []byte, error) {
+	raw := make([]byte, headerFixedSize)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil, errBadHeader("stream is too short to hold a header")
+		}
+		return nil, nil, err
+	}
+
+	if !bytes.HasPrefix(raw, []byte(headerMagic)) {
+		return nil, nil, errBadHeader("stream does not start with a crypt header")
+	}
+
+	p := raw[len(headerMagic):]
+	h := &header{
+		version:   p[0],
+		cipher:    Cipher(p[1]),
+		chunkSize: int(binary.BigEndian.Uint32(p[2:6])),
+		fields:    make(map[byte][]byte),
+	}
+
+	if h.version != headerVersion {
+		return nil, nil, errBadHeader("unsupported stream version " + strconv.Itoa(int(h.version)))
+	}
+	if h.chunkSize <= 0 || h.chunkSize > maxChunkSize {
+		return nil, nil, errBadHeader("invalid chunk size " + strconv.Itoa(h.chunkSize))
+	}
+
+	fields := make([]byte, binary.BigEndian.Uint16(p[6:8]))
+	if _, err := io.ReadFull(r, fields); err != nil {
+		return nil, nil, errBadHeader("stream is too short to hold a header")
+	}
+	raw = append(raw, fields...)
+
+	last := -1
+	for len(fields) != 0 {
+		if len(fields) < 3 {
+			return nil, nil, errBadHeader("malformed header field")
+		}
+
+		typ, n := fields[0], int(binary.BigEndian.Uint16(fields[1:3]))
+		fields = fields[3:]
+		if len(fields) < n {
+			return nil, nil, errBadHeader("malformed header field")
+		}
+		if !knownHeaderFields[typ] {
+			return nil, nil, errBadHeader("unknown header field " + strconv.Itoa(int(typ)))
+		}
+		// fields are in increasing order, so a header only has one encoding
+		if int(typ) <= last {
+			return nil, nil, errBadHeader("duplicate or out of order header field " + strconv.Itoa(int(typ)))
+		}
+		last = int(typ)
+
+		h.fields[typ] = fields[:n]
+		fields = fields[n:]
+	}
+
+	return h, raw, nil
+}
@@ -0,0 +1,88 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// TestHeader makes sure a Reader picks up the chunk size and cipher from the
+// header without being told, and that the header is authenticated.
+func TestHeader(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	data := randBytes(3 * 100)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key, WithChunkSize(100), WithCipher(ChaCha20Poly1305))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+
+	r, err := NewReader(bytes.NewReader(stream), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("decrypted data does not match")
+	}
+
+	// changing the header must be caught, either when parsing it or when
+	// the first chunk fails to authenticate
+	for i := range headerFixedSize {
+		tampered := bytes.Clone(stream)
+		tampered[i] ^= 1
+
+		r, err := NewReader(bytes.NewReader(tampered), key)
+		if err != nil {
+			continue
+		}
+		if _, err := io.ReadAll(r); err == nil {
+			t.Fatalf("flipping header byte %d was not detected", i)
+		}
+	}
+}
+
+// TestHeaderFields makes sure optional fields round trip and unknown ones
+// are rejected.
+func TestHeaderFields(t *testing.T) {
+	t.Parallel()
+	h := &header{
+		version:   headerVersion,
+		cipher:    DefaultCipher,
+		chunkSize: DefaultBlockSize,
+		fields:    map[byte][]byte{200: []byte("value")},
+	}
+
+	if _, _, err := readHeader(bytes.NewReader(h.encode())); err == nil {
+		t.Fatal("unknown header field was accepted")
+	}
+
+	h.fields = nil
+	raw := h.encode()
+	got, gotRaw, err := readHeader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, gotRaw) || got.chunkSize != h.chunkSize || got.cipher != h.cipher {
+		t.Fatal("header did not round trip")
+	}
+
+	// huge chunk sizes must be refused before anything is allocated
+	binary.BigEndian.PutUint32(raw[len(headerMagic)+2:], maxChunkSize+1)
+	if _, _, err := readHeader(bytes.NewReader(raw)); err == nil {
+		t.Fatal("oversized chunk size was accepted")
+	}
+}
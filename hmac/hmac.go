@@ -0,0 +1,70 @@
+// Package hmac provides HMAC-SHA256 for when data only needs to be tamper
+// proof, not secret, such as signed URLs or cached blobs. tags are plain
+// HMAC-SHA256 of the data keyed with the whole 32 byte key, so anything
+// else that speaks HMAC-SHA256 can check them.
+//
+// use a key of its own, not one that also encrypts, so a tag never depends
+// on an encryption key. crypt.DeriveKey can make one from a master key.
+package hmac
+
+import (
+	stdhmac "crypto/hmac"
+	"crypto/sha256"
+	"hash"
+	"io"
+)
+
+// Size is the size of a tag in bytes.
+const Size = sha256.Size
+
+// Sign returns the tag of data under key.
+func Sign(data []byte, key *[32]byte) []byte {
+	m := stdhmac.New(sha256.New, key[:])
+	m.Write(data)
+	return m.Sum(nil)
+}
+
+// Verify reports whether sig is the tag of data under key, in constant
+// time.
+func Verify(data, sig []byte, key *[32]byte) bool {
+	return stdhmac.Equal(Sign(data, key), sig)
+}
+
+// Writer computes the tag of everything written to it, passing the data on
+// to an underlying writer if there is one, so data can be tagged while it
+// is streamed somewhere.
+type Writer struct {
+	w   io.Writer
+	mac hash.Hash
+}
+
+// NewWriter returns a Writer tagging with key and writing through to w, w
+// may be nil to only compute the tag.
+func NewWriter(w io.Writer, key *[32]byte) *Writer {
+	return &Writer{w: w, mac: stdhmac.New(sha256.New, key[:])}
+}
+
+// Write adds p to the tag and writes it to the underlying writer, only
+// what the underlying writer accepted is tagged.
+func (w *Writer) Write(p []byte) (int, error) {
+	n := len(p)
+	var err error
+	if w.w != nil {
+		n, err = w.w.Write(p)
+	}
+
+	w.mac.Write(p[:n])
+	return n, err
+}
+
+// Sum returns the tag of everything written so far, more can be written
+// after.
+func (w *Writer) Sum() []byte {
+	return w.mac.Sum(nil)
+}
+
+// Verify reports whether sig is the tag of everything written so far, in
+// constant time.
+func (w *Writer) Verify(sig []byte) bool {
+	return stdhmac.Equal(w.Sum(), sig)
+}
@@ -0,0 +1,63 @@
+package hmac
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// TestSign checks a tag against one from another HMAC-SHA256
+// implementation, RFC 4231 test case 1 with the key stretched to 32 bytes,
+// and makes sure changed data or tags fail.
+func TestSign(t *testing.T) {
+	t.Parallel()
+	key := &[32]byte{}
+	for i := range key {
+		key[i] = 0x0b
+	}
+	data := []byte("Hi There")
+
+	sig := Sign(data, key)
+	if got := hex.EncodeToString(sig); got != "198a607eb44bfbc69903a0f1cf2bbdc5ba0aa3f3d9ae3c1c7a3b1696a0b68cf7" {
+		t.Fatalf("got %s", got)
+	}
+	if !Verify(data, sig, key) {
+		t.Fatal("tag did not verify")
+	}
+
+	sig[0] ^= 1
+	if Verify(data, sig, key) || Verify(data, sig[:Size-1], key) || Verify([]byte("Hi there"), Sign(data, key), key) {
+		t.Fatal("bad tag verified")
+	}
+}
+
+// TestWriter makes sure the streaming tag matches Sign and the data is
+// passed through.
+func TestWriter(t *testing.T) {
+	t.Parallel()
+	key := &[32]byte{}
+	rand.Read(key[:])
+	data := make([]byte, 1000)
+	rand.Read(data)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, key)
+	if _, err := io.Copy(w, iotest.HalfReader(bytes.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("data was not passed through")
+	}
+	if !bytes.Equal(w.Sum(), Sign(data, key)) || !w.Verify(Sign(data, key)) {
+		t.Fatal("streaming tag does not match")
+	}
+
+	only := NewWriter(nil, key)
+	only.Write(data)
+	if !only.Verify(Sign(data, key)) {
+		t.Fatal("tag without an underlying writer does not match")
+	}
+}
@@ -0,0 +1,102 @@
+package crypt
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"io"
+)
+
+// KeyWrapper wraps and unwraps keys with a key encryption key it holds,
+// such as an AES key in an HSM used through a PKCS#11 session's
+// C_WrapKey and C_UnwrapKey or C_Encrypt and C_Decrypt, where the KEK can't
+// be extracted. see HardwareKeyProvider.
+type KeyWrapper interface {
+	WrapKey(ctx context.Context, key []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// HardwareKeyProvider returns a KeyProvider that generates data keys itself
+// and has w wrap them, for hardware that can wrap keys but not generate
+// data keys the way a KMS does. use it with NewKMSWriter and NewKMSReader.
+//
+// the key that never leaves the hardware is the KEK. each stream still has
+// its data key in memory while it is read or written, sealing every chunk
+// on the token would be far too slow for streams of any size.
+func HardwareKeyProvider(w KeyWrapper) KeyProvider {
+	return wrapperProvider{w}
+}
+
+type wrapperProvider struct {
+	w KeyWrapper
+}
+
+func (p wrapperProvider) GenerateDataKey(ctx context.Context) (*[32]byte, []byte, error) {
+	key := &[32]byte{}
+	if _, err := io.ReadFull(randReader, key[:]); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := p.w.WrapKey(ctx, key[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, wrapped, nil
+}
+
+func (p wrapperProvider) Decrypt(ctx context.Context, wrapped []byte) (*[32]byte, error) {
+	b, err := p.w.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 32 {
+		return nil, errors.New("crypt: unwrapped data key is not 32 bytes")
+	}
+	return (*[32]byte)(b), nil
+}
+
+// DecrypterKeyProvider returns a KeyProvider wrapping data keys to the RSA
+// public key of d with RSA-OAEP and SHA-256 and unwrapping them with d.
+// RSA keys on PKCS#11 tokens, YubiKey PIV slots and cloud KMSes are
+// available as a crypto.Decrypter from their Go libraries, so the private
+// key stays in the hardware. writing only needs the public key, a token
+// only has to be present to read.
+func DecrypterKeyProvider(d crypto.Decrypter) (KeyProvider, error) {
+	pub, ok := d.Public().(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("crypt: DecrypterKeyProvider needs an RSA key")
+	}
+	if pub.N.BitLen() < 2048 {
+		return nil, errors.New("crypt: RSA keys under 2048 bits are too weak")
+	}
+	return decrypterProvider{d: d, pub: pub}, nil
+}
+
+type decrypterProvider struct {
+	d   crypto.Decrypter
+	pub *rsa.PublicKey
+}
+
+func (p decrypterProvider) GenerateDataKey(context.Context) (*[32]byte, []byte, error) {
+	key := &[32]byte{}
+	if _, err := io.ReadFull(randReader, key[:]); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), randReader, p.pub, key[:], nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, wrapped, nil
+}
+
+func (p decrypterProvider) Decrypt(_ context.Context, wrapped []byte) (*[32]byte, error) {
+	b, err := p.d.Decrypt(randReader, wrapped, &rsa.OAEPOptions{Hash: crypto.SHA256})
+	if err != nil {
+		return nil, &detailError{msg: "crypt: unwrapping data key: " + err.Error(), err: ErrKeyNotFound}
+	}
+	if len(b) != 32 {
+		return nil, errors.New("crypt: unwrapped data key is not 32 bytes")
+	}
+	return (*[32]byte)(b), nil
+}
@@ -0,0 +1,84 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"testing"
+)
+
+// tokenWrapper stands in for an HSM session holding kek.
+type tokenWrapper struct {
+	kek   []byte
+	calls int
+}
+
+func (w *tokenWrapper) WrapKey(_ context.Context, key []byte) ([]byte, error) {
+	w.calls++
+	return aesKeyWrap(w.kek, key)
+}
+
+func (w *tokenWrapper) UnwrapKey(_ context.Context, wrapped []byte) ([]byte, error) {
+	w.calls++
+	return aesKeyUnwrap(w.kek, wrapped)
+}
+
+// kmsRoundTrip writes data with p and reads it back.
+func kmsRoundTrip(t *testing.T, p KeyProvider, data []byte) error {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewKMSWriter(&buf, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewKMSReader(&buf, p)
+	if err != nil {
+		return err
+	}
+	got, err := io.ReadAll(r)
+	if err == nil && !bytes.Equal(got, data) {
+		t.Fatal("data did not round trip")
+	}
+	return err
+}
+
+func TestHardwareKeyProvider(t *testing.T) {
+	token := &tokenWrapper{kek: randBytes(32)}
+	if err := kmsRoundTrip(t, HardwareKeyProvider(token), randBytes(100)); err != nil {
+		t.Fatal(err)
+	}
+	if token.calls != 2 {
+		t.Fatalf("token used %d times", token.calls)
+	}
+}
+
+func TestDecrypterKeyProvider(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := DecrypterKeyProvider(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kmsRoundTrip(t, p, randBytes(100)); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	var buf bytes.Buffer
+	w, _ := NewKMSWriter(&buf, p)
+	w.Close()
+	q, _ := DecrypterKeyProvider(other)
+	if _, err := NewKMSReader(&buf, q); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("wrong key: %v", err)
+	}
+}
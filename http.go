@@ -0,0 +1,203 @@
+package crypt
+
+import (
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// ContentEncoding is the Content-Encoding of bodies encrypted by Handler
+// and Transport, each body is a stream as written by NewWriter.
+const ContentEncoding = "crypt"
+
+// Handler wraps h so request bodies are decrypted before h reads them and
+// response bodies are encrypted as h writes them, for payloads that have
+// to stay encrypted through proxies that can't be trusted. requests with a
+// body that isn't encrypted get 415 Unsupported Media Type without h
+// running, every response body is encrypted. a request body that fails to
+// authenticate gives h a read error wrapping ErrAuthenticationFailed,
+// always check it. opts apply to both directions, use Transport with the
+// same key and options on the client.
+func Handler(h http.Handler, key *[32]byte, opts ...Option) http.Handler {
+	opts = slices.Clone(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if encrypted(r.Header) {
+			r.Body = newLazyReader(r.Body, key, opts)
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = -1
+		} else if r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0 {
+			http.Error(w, "request body must be encrypted", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		ew := &encryptingResponse{ResponseWriter: w, key: key, opts: opts}
+		h.ServeHTTP(ew, r)
+		ew.close()
+	})
+}
+
+// encrypted reports whether a body with header is a stream.
+func encrypted(header http.Header) bool {
+	return strings.EqualFold(strings.TrimSpace(header.Get("Content-Encoding")), ContentEncoding)
+}
+
+// encryptingResponse encrypts the body written to it, the stream starts
+// with the first Write or WriteHeader and ends when the handler returns.
+type encryptingResponse struct {
+	http.ResponseWriter
+	key  *[32]byte
+	opts []Option
+
+	w   *Writer
+	err error
+}
+
+func (e *encryptingResponse) WriteHeader(code int) {
+	if e.w != nil || e.err != nil {
+		return
+	}
+
+	h := e.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", ContentEncoding)
+	e.ResponseWriter.WriteHeader(code)
+	e.w, e.err = NewWriter(e.ResponseWriter, e.key, e.opts...)
+}
+
+func (e *encryptingResponse) Write(p []byte) (int, error) {
+	if e.w == nil && e.err == nil {
+		if e.Header().Get("Content-Type") == "" {
+			// detect the type from the plaintext, not the ciphertext
+			e.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		e.WriteHeader(http.StatusOK)
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+	return e.w.Write(p)
+}
+
+// close ends the stream, even for a handler that wrote nothing.
+func (e *encryptingResponse) close() {
+	if e.w == nil && e.err == nil {
+		e.WriteHeader(http.StatusOK)
+	}
+	if e.w != nil {
+		e.w.Close()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the ResponseWriter.
+func (e *encryptingResponse) Unwrap() http.ResponseWriter {
+	return e.ResponseWriter
+}
+
+// Transport is an http.RoundTripper that encrypts request bodies and
+// decrypts response bodies, the client side of Handler. responses with a
+// body that isn't encrypted are refused with an error, so nothing in
+// between can answer in the server's place. bodies are streamed, neither
+// is held in memory.
+type Transport struct {
+	// Base makes the requests, http.DefaultTransport if nil
+	Base http.RoundTripper
+
+	key  *[32]byte
+	opts []Option
+}
+
+// NewTransport returns a Transport encrypting with key on top of base.
+func NewTransport(base http.RoundTripper, key *[32]byte, opts ...Option) *Transport {
+	return &Transport{Base: base, key: key, opts: slices.Clone(opts)}
+}
+
+// RoundTrip encrypts the body of req, sends it with Base and returns the
+// response with its body decrypted.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	req = req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = t.encryptBody(req.Body)
+		if getBody := req.GetBody; getBody != nil {
+			req.GetBody = func() (io.ReadCloser, error) {
+				body, err := getBody()
+				if err != nil {
+					return nil, err
+				}
+				return t.encryptBody(body), nil
+			}
+		}
+		req.ContentLength = -1
+		req.Header.Del("Content-Length")
+		req.Header.Set("Content-Encoding", ContentEncoding)
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if !encrypted(resp.Header) {
+		if resp.ContentLength == 0 || req.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+			return resp, nil
+		}
+		resp.Body.Close()
+		return nil, &detailError{msg: "crypt: response body is not encrypted", err: ErrAuthenticationFailed}
+	}
+
+	resp.Body = newLazyReader(resp.Body, t.key, t.opts)
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	return resp, nil
+}
+
+// encryptBody returns a body that reads as body encrypted.
+func (t *Transport) encryptBody(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+		w, err := NewWriter(pw, t.key, t.opts...)
+		if err == nil {
+			_, err = io.Copy(w, body)
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// lazyReader decrypts a body, reading the stream header on the first Read
+// so nothing blocks before the body is wanted.
+type lazyReader struct {
+	body io.ReadCloser
+	key  *[32]byte
+	opts []Option
+
+	r   *Reader
+	err error
+}
+
+func newLazyReader(body io.ReadCloser, key *[32]byte, opts []Option) *lazyReader {
+	return &lazyReader{body: body, key: key, opts: opts}
+}
+
+func (l *lazyReader) Read(p []byte) (int, error) {
+	if l.r == nil && l.err == nil {
+		l.r, l.err = NewReader(l.body, l.key, l.opts...)
+	}
+	if l.err != nil {
+		return 0, l.err
+	}
+	return l.r.Read(p)
+}
+
+func (l *lazyReader) Close() error {
+	return l.body.Close()
+}
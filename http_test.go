@@ -0,0 +1,115 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTP(t *testing.T) {
+	key := randKey()
+	var seen []byte
+	srv := httptest.NewServer(Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		seen = body
+		w.Write(bytes.ToUpper(body))
+	}), key))
+	defer srv.Close()
+
+	// a proxy in between only sees ciphertext
+	var wire []byte
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequest(r.Method, srv.URL+r.URL.Path, r.Body)
+		req.Header = r.Header.Clone()
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		wire, _ = io.ReadAll(resp.Body)
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		w.Write(wire)
+	}))
+	defer proxy.Close()
+
+	client := &http.Client{Transport: NewTransport(nil, key)}
+	msg := strings.Repeat("attack at dawn ", 10_000)
+	resp, err := client.Post(proxy.URL+"/echo", "text/plain", strings.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(seen) != msg || string(got) != strings.ToUpper(msg) {
+		t.Fatalf("round trip failed: %s", got[:min(len(got), 100)])
+	}
+	if bytes.Contains(wire, []byte("ATTACK")) {
+		t.Fatal("proxy saw plaintext")
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	// plaintext requests are refused
+	resp, err = http.Post(srv.URL, "text/plain", strings.NewReader("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("plaintext request: %s", resp.Status)
+	}
+
+	// a GET has no body to encrypt
+	resp, err = client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(resp.Body); err != nil || len(got) != 0 {
+		t.Fatalf("GET: %q, %v", got, err)
+	}
+	resp.Body.Close()
+}
+
+func TestHTTPWrongKey(t *testing.T) {
+	srv := httptest.NewServer(Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}), randKey()))
+	defer srv.Close()
+
+	client := &http.Client{Transport: NewTransport(nil, randKey())}
+	resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong key: %s", resp.Status)
+	}
+
+	// a plaintext answer from something in between is refused
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("trust me"))
+	}))
+	defer plain.Close()
+	if _, err := client.Get(plain.URL); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("plaintext response: %v", err)
+	}
+}
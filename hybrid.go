@@ -0,0 +1,112 @@
+package crypt
+
+import (
+	"crypto/hpke"
+	"encoding/binary"
+	"errors"
+)
+
+// hybrid stanzas wrap the data key with HPKE (RFC 9180), the body is
+//
+//	KEM id | KDF id | AEAD id | enc | ciphertext
+//
+// with the ids big endian uint16s from the HPKE registry, so suites other
+// than ML-KEM-768 with X25519 can be added later.
+
+// hybridInfo is the HPKE info of hybrid stanzas.
+const hybridInfo = "crypt hybrid recipient v1\x00"
+
+// the HPKE suite new hybrid stanzas are sealed with.
+var (
+	hybridKEM  = hpke.MLKEM768X25519()
+	hybridKDF  = hpke.HKDFSHA256()
+	hybridAEAD = hpke.AES256GCM()
+)
+
+// HybridPublicKey is a recipient whose streams stay confidential even
+// against a future quantum computer: the data key is wrapped with HPKE
+// using ML-KEM-768 together with X25519, so it is safe as long as either
+// holds. streams for several recipients are only as strong as the weakest,
+// mixing hybrid recipients with *PublicKey ones gives up the protection.
+type HybridPublicKey struct {
+	pk hpke.PublicKey
+}
+
+// HybridPrivateKey is the identity for a HybridPublicKey.
+type HybridPrivateKey struct {
+	sk hpke.PrivateKey
+}
+
+// GenerateHybridKeyPair returns a new ML-KEM-768 + X25519 key pair.
+func GenerateHybridKeyPair() (*HybridPublicKey, *HybridPrivateKey, error) {
+	sk, err := hybridKEM.GenerateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &HybridPublicKey{sk.PublicKey()}, &HybridPrivateKey{sk}, nil
+}
+
+// ParseHybridPublicKey decodes a public key from HybridPublicKey.Bytes.
+func ParseHybridPublicKey(b []byte) (*HybridPublicKey, error) {
+	pk, err := hybridKEM.NewPublicKey(b)
+	if err != nil {
+		return nil, errors.New("crypt: invalid hybrid public key")
+	}
+	return &HybridPublicKey{pk}, nil
+}
+
+// ParseHybridPrivateKey decodes a private key from HybridPrivateKey.Bytes.
+func ParseHybridPrivateKey(b []byte) (*HybridPrivateKey, error) {
+	sk, err := hybridKEM.NewPrivateKey(b)
+	if err != nil {
+		return nil, errors.New("crypt: invalid hybrid private key")
+	}
+	return &HybridPrivateKey{sk}, nil
+}
+
+// Bytes returns the encoded public key, 1216 bytes.
+func (k *HybridPublicKey) Bytes() []byte {
+	return k.pk.Bytes()
+}
+
+// Bytes returns the encoded private key, a 32 byte seed.
+func (k *HybridPrivateKey) Bytes() ([]byte, error) {
+	return k.sk.Bytes()
+}
+
+// Public returns the public key for k.
+func (k *HybridPrivateKey) Public() *HybridPublicKey {
+	return &HybridPublicKey{k.sk.PublicKey()}
+}
+
+func (k *HybridPublicKey) wrap(_ Cipher, dataKey *[32]byte) (byte, []byte, error) {
+	body := binary.BigEndian.AppendUint16(nil, hybridKEM.ID())
+	body = binary.BigEndian.AppendUint16(body, hybridKDF.ID())
+	body = binary.BigEndian.AppendUint16(body, hybridAEAD.ID())
+	sealed, err := hpke.Seal(k.pk, hybridKDF, hybridAEAD, []byte(hybridInfo), dataKey[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	return stanzaHybrid, append(body, sealed...), nil
+}
+
+func (k *HybridPrivateKey) unwrap(typ byte, body []byte) (*[32]byte, error) {
+	if typ != stanzaHybrid || len(body) < 6 || binary.BigEndian.Uint16(body) != k.sk.KEM().ID() {
+		return nil, errNotForIdentity
+	}
+	kdf, err := hpke.NewKDF(binary.BigEndian.Uint16(body[2:]))
+	if err != nil {
+		return nil, errBadHeader("unknown HPKE KDF in hybrid stanza")
+	}
+	aead, err := hpke.NewAEAD(binary.BigEndian.Uint16(body[4:]))
+	if err != nil {
+		return nil, errBadHeader("unknown HPKE AEAD in hybrid stanza")
+	}
+
+	// as with keys, failing to open the stanza means it is someone else's
+	b, err := hpke.Open(k.sk, kdf, aead, []byte(hybridInfo), body[6:])
+	if err != nil || len(b) != 32 {
+		return nil, errNotForIdentity
+	}
+	return (*[32]byte)(b), nil
+}
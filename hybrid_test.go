@@ -0,0 +1,63 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestHybridRecipient(t *testing.T) {
+	hpub, hpriv, err := GenerateHybridKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	xpub, xpriv, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randBytes(1000)
+
+	// classic and hybrid recipients in one stream
+	var buf bytes.Buffer
+	w, err := NewRecipientWriter(&buf, []Recipient{xpub, hpub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the private key survives encoding
+	b, err := hpriv.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hpriv, err = ParseHybridPrivateKey(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(hpriv.Public().Bytes(), hpub.Bytes()) {
+		t.Fatal("public key changed")
+	}
+
+	for _, id := range []Identity{hpriv, xpriv} {
+		r, err := NewRecipientReader(bytes.NewReader(buf.Bytes()), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%T: %v", id, err)
+		}
+	}
+
+	_, other, _ := GenerateHybridKeyPair()
+	if _, err := NewRecipientReader(bytes.NewReader(buf.Bytes()), other); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("other identity: %v", err)
+	}
+
+	if _, err := ParseHybridPublicKey(hpub.Bytes()[:100]); err == nil {
+		t.Fatal("short public key parsed")
+	}
+}
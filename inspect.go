@@ -0,0 +1,150 @@
+package crypt
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"time"
+)
+
+// StreamInfo is what the header of a stream says about it, see Inspect.
+type StreamInfo struct {
+	Version   int
+	Cipher    Cipher
+	ChunkSize int
+
+	// KDF is set for password streams, with the parameters the key was
+	// derived with
+	KDF KDF
+
+	// KeyID is the id of the Keyring key the stream was written with,
+	// valid if HasKeyID is set
+	KeyID    uint32
+	HasKeyID bool
+
+	// Recipients is how many recipients the data key is wrapped for
+	Recipients int
+
+	// WrappedKey is the data key of a stream made by NewKMSWriter as
+	// wrapped by its KeyProvider
+	WrappedKey []byte
+
+	// Archive is set for streams made with WithArchiveProfile
+	Archive bool
+
+	// Detached is set for streams whose tags were written elsewhere
+	Detached bool
+
+	// CounterNonces is set for streams made with WithCounterNonces
+	CounterNonces bool
+
+	// RekeyInterval is how many chunks are sealed with each key of a
+	// stream made with WithRekeyAfter, 0 if it doesn't rekey
+	RekeyInterval uint64
+
+	// Signer is the key a signed stream claims to be signed with
+	Signer ed25519.PublicKey
+
+	// Metadata is the stream's metadata, nil if it has none
+	Metadata *Metadata
+
+	// Timestamp is when a stream made with WithTimestamp was written, the
+	// zero time for other streams
+	Timestamp time.Time
+
+	// KeyFingerprint is the fingerprint of the key a stream made with
+	// WithKeyFingerprint was written with, see Key.Fingerprint
+	KeyFingerprint string
+
+	// StreamID is the ID of the stream in hex, see WithStreamID, empty if
+	// it has none
+	StreamID string
+
+	// ObjectID is the id the key of a stream made by NewDerivedWriter was
+	// derived with, empty for other streams
+	ObjectID string
+
+	// HeaderSize is the size of the header in bytes, the chunks start
+	// right after it
+	HeaderSize int
+}
+
+// Inspect reads the header of a stream from r and describes it without
+// needing the key, so a file can be routed to the right key or shown to a
+// person. nothing in the header is authenticated until the stream is read
+// with its key, so don't trust any of it for more than that. r is left
+// just past the header.
+func Inspect(r io.Reader) (*StreamInfo, error) {
+	h, raw, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &StreamInfo{
+		Version:    int(h.version),
+		Cipher:     h.cipher,
+		ChunkSize:  h.chunkSize,
+		HeaderSize: len(raw),
+	}
+
+	if b, ok := h.fields[fieldKDF]; ok {
+		if info.KDF, _, err = parseKDF(b); err != nil {
+			return nil, err
+		}
+	}
+	if b, ok := h.fields[fieldKeyID]; ok {
+		if len(b) != 4 {
+			return nil, errBadHeader("invalid key id field")
+		}
+		info.KeyID, info.HasKeyID = binary.BigEndian.Uint32(b), true
+	}
+	if stanzas, ok := h.fields[fieldRecipients]; ok {
+		for len(stanzas) != 0 {
+			if len(stanzas) < 3 || len(stanzas) < 3+int(binary.BigEndian.Uint16(stanzas[1:])) {
+				return nil, errBadHeader("malformed recipient stanza")
+			}
+			stanzas = stanzas[3+int(binary.BigEndian.Uint16(stanzas[1:])):]
+			info.Recipients++
+		}
+	}
+	info.WrappedKey = h.fields[fieldKMS]
+	group, err := h.parityGroup()
+	if err != nil {
+		return nil, err
+	}
+	info.Archive = group != 0
+	_, info.Detached = h.fields[fieldDetached]
+	_, info.CounterNonces = h.fields[fieldNoncePrefix]
+	if info.RekeyInterval, err = h.rekeyInterval(); err != nil {
+		return nil, err
+	}
+	if b, ok := h.fields[fieldSigner]; ok {
+		if len(b) != ed25519.PublicKeySize {
+			return nil, errBadHeader("invalid signer field")
+		}
+		info.Signer = ed25519.PublicKey(b)
+	}
+	if info.Metadata, err = h.metadata(); err != nil {
+		return nil, err
+	}
+	if b, ok := h.fields[fieldTimestamp]; ok {
+		if len(b) != timestampSize {
+			return nil, errBadHeader("invalid timestamp field")
+		}
+		info.Timestamp = time.Unix(int64(binary.BigEndian.Uint64(b)), 0)
+	}
+	if b, ok := h.fields[fieldFingerprint]; ok {
+		if len(b) != 16 {
+			return nil, errBadHeader("invalid key fingerprint field")
+		}
+		info.KeyFingerprint = hex.EncodeToString(b)
+	}
+
+	if info.StreamID, err = h.streamID(); err != nil {
+		return nil, err
+	}
+	info.ObjectID = string(h.fields[fieldObjectID])
+
+	return info, nil
+}
@@ -0,0 +1,79 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io"
+	"testing"
+)
+
+// TestInspect makes sure Inspect describes streams of every kind without a
+// key.
+func TestInspect(t *testing.T) {
+	t.Parallel()
+	write := func(f func(w io.Writer) (*Writer, error)) []byte {
+		var buf bytes.Buffer
+		w, err := f(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("inspect me"))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	key := randKey()
+	stream := write(func(w io.Writer) (*Writer, error) {
+		return NewWriter(w, key, WithCipher(AES256GCM), WithChunkSize(1024), WithMetadata(Metadata{Name: "a.txt"}))
+	})
+	info, err := Inspect(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, raw, _ := readHeader(bytes.NewReader(stream))
+	if info.Version != headerVersion || info.Cipher != AES256GCM || info.ChunkSize != 1024 || info.HeaderSize != len(raw) {
+		t.Fatalf("got %+v", info)
+	}
+	if info.KDF != nil || info.HasKeyID || info.Recipients != 0 || info.Archive || info.Detached || info.Signer != nil {
+		t.Fatalf("got %+v", info)
+	}
+	if info.Metadata == nil || info.Metadata.Name != "a.txt" {
+		t.Fatalf("got metadata %+v", info.Metadata)
+	}
+
+	kdf := PBKDF2{Iterations: 1000}
+	stream = write(func(w io.Writer) (*Writer, error) {
+		return NewPasswordWriter(w, []byte("hunter2"), WithKDF(kdf))
+	})
+	if info, err := Inspect(bytes.NewReader(stream)); err != nil || info.KDF != kdf {
+		t.Fatalf("password: got %+v, %v", info, err)
+	}
+
+	kr := NewKeyring()
+	kr.Add(42, key)
+	stream = write(func(w io.Writer) (*Writer, error) { return kr.NewWriter(w) })
+	if info, err := Inspect(bytes.NewReader(stream)); err != nil || !info.HasKeyID || info.KeyID != 42 {
+		t.Fatalf("keyring: got %+v, %v", info, err)
+	}
+
+	a, _ := GenerateKey()
+	b, _ := GenerateKey()
+	stream = write(func(w io.Writer) (*Writer, error) { return NewRecipientWriter(w, []Recipient{a, b}) })
+	if info, err := Inspect(bytes.NewReader(stream)); err != nil || info.Recipients != 2 {
+		t.Fatalf("recipients: got %+v, %v", info, err)
+	}
+
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	stream = write(func(w io.Writer) (*Writer, error) {
+		return NewWriter(w, key, WithArchiveProfile(), WithSigner(priv))
+	})
+	if info, err := Inspect(bytes.NewReader(stream)); err != nil || !info.Archive || !pub.Equal(info.Signer) {
+		t.Fatalf("archive: got %+v, %v", info, err)
+	}
+
+	if _, err := Inspect(bytes.NewReader([]byte("not a stream at all"))); err == nil {
+		t.Fatal("inspected garbage")
+	}
+}
@@ -0,0 +1,217 @@
+package crypt
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// JWE (RFC 7516) tokens in compact serialization, content is always
+// encrypted with A256GCM. the key is either used directly ("dir") or
+// wrapped with a key derived from a password ("PBES2-HS512+A256KW", RFC
+// 7518 section 4.8). the expected algorithm is fixed by the function called,
+// never taken from the token.
+const (
+	jweDir   = "dir"
+	jwePBES2 = "PBES2-HS512+A256KW"
+	jweEnc   = "A256GCM"
+
+	// DefaultJWEIterations is the PBKDF2 iteration count used by
+	// EncryptJWEWithPassword when 0 is given.
+	DefaultJWEIterations = 600_000
+
+	// minJWEIterations and maxJWEIterations bound the count accepted from a
+	// token, the low end from RFC 7518 and the high end so a token can't
+	// make us spin for minutes.
+	minJWEIterations = 1000
+	maxJWEIterations = 10_000_000
+)
+
+// jweHeader is the JOSE header of a token.
+type jweHeader struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+	P2s string `json:"p2s,omitempty"`
+	P2c int    `json:"p2c,omitempty"`
+
+	// set only so tokens using them can be rejected
+	Zip  string   `json:"zip,omitempty"`
+	Crit []string `json:"crit,omitempty"`
+}
+
+// b64url is the base64 used by JOSE.
+var b64url = base64.RawURLEncoding
+
+// EncryptJWE encrypts plaintext as a compact JWE token using key directly
+// ("alg":"dir", "enc":"A256GCM").
+func EncryptJWE(plaintext []byte, key *[32]byte) (string, error) {
+	return sealJWE(&jweHeader{Alg: jweDir, Enc: jweEnc}, nil, key[:], plaintext)
+}
+
+// DecryptJWE decrypts a token made by EncryptJWE or any other JOSE library
+// using dir and A256GCM.
+func DecryptJWE(token string, key *[32]byte) ([]byte, error) {
+	_, parts, err := parseJWE(token, jweDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts[1]) != 0 {
+		return nil, errors.New("crypt: dir JWE has an encrypted key")
+	}
+
+	return openJWE(parts, key[:])
+}
+
+// EncryptJWEWithPassword encrypts plaintext as a compact JWE token for a
+// password ("alg":"PBES2-HS512+A256KW", "enc":"A256GCM"). iterations is the
+// PBKDF2 count, 0 means DefaultJWEIterations.
+func EncryptJWEWithPassword(plaintext, password []byte, iterations int) (string, error) {
+	if iterations == 0 {
+		iterations = DefaultJWEIterations
+	}
+	if iterations < minJWEIterations || iterations > maxJWEIterations {
+		return "", errors.New("crypt: invalid JWE iteration count")
+	}
+
+	salt, err := newNonce(randReader, 16)
+	if err != nil {
+		return "", err
+	}
+	h := &jweHeader{Alg: jwePBES2, Enc: jweEnc, P2s: b64url.EncodeToString(salt), P2c: iterations}
+	kek, err := jweKEK(password, salt, iterations)
+	if err != nil {
+		return "", err
+	}
+
+	cek, err := newNonce(randReader, 32)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := aesKeyWrap(kek, cek)
+	if err != nil {
+		return "", err
+	}
+
+	return sealJWE(h, wrapped, cek, plaintext)
+}
+
+// DecryptJWEWithPassword decrypts a token made by EncryptJWEWithPassword or
+// any other JOSE library using PBES2-HS512+A256KW and A256GCM.
+func DecryptJWEWithPassword(token string, password []byte) ([]byte, error) {
+	h, parts, err := parseJWE(token, jwePBES2)
+	if err != nil {
+		return nil, err
+	}
+	if h.P2c < minJWEIterations || h.P2c > maxJWEIterations {
+		return nil, errors.New("crypt: invalid JWE iteration count")
+	}
+	salt, err := b64url.DecodeString(h.P2s)
+	if err != nil || len(salt) < 8 {
+		return nil, errors.New("crypt: invalid JWE salt")
+	}
+
+	kek, err := jweKEK(password, salt, h.P2c)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := aesKeyUnwrap(kek, parts[1])
+	if err != nil || len(cek) != 32 {
+		return nil, errors.New("crypt: incorrect JWE password")
+	}
+
+	return openJWE(parts, cek)
+}
+
+// jweKEK derives the key wrapping key from a password, the salt is the
+// algorithm name, a zero byte and p2s.
+func jweKEK(password, salt []byte, iterations int) ([]byte, error) {
+	s := append([]byte(jwePBES2+"\x00"), salt...)
+	return pbkdf2.Key(sha512.New, string(password), s, iterations, 32)
+}
+
+// sealJWE encrypts plaintext with cek and assembles the token.
+func sealJWE(h *jweHeader, encryptedKey, cek, plaintext []byte) (string, error) {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+	protected := b64url.EncodeToString(b)
+
+	gcm, err := newGCM((*[32]byte)(cek))
+	if err != nil {
+		return "", err
+	}
+	iv, err := newNonce(randReader, gcm.NonceSize())
+	if err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, plaintext, []byte(protected))
+	ct, tag := sealed[:len(plaintext)], sealed[len(plaintext):]
+
+	return strings.Join([]string{
+		protected,
+		b64url.EncodeToString(encryptedKey),
+		b64url.EncodeToString(iv),
+		b64url.EncodeToString(ct),
+		b64url.EncodeToString(tag),
+	}, "."), nil
+}
+
+// parseJWE splits a token, checking its header asks for alg and A256GCM.
+// parts holds the raw protected header followed by the other four parts
+// decoded.
+func parseJWE(token, alg string) (*jweHeader, [5][]byte, error) {
+	var parts [5][]byte
+	fields := strings.Split(token, ".")
+	if len(fields) != 5 {
+		return nil, parts, errors.New("crypt: not a compact JWE")
+	}
+
+	parts[0] = []byte(fields[0])
+	for i, f := range fields[1:] {
+		b, err := b64url.DecodeString(f)
+		if err != nil {
+			return nil, parts, errors.New("crypt: invalid JWE encoding")
+		}
+		parts[i+1] = b
+	}
+
+	b, err := b64url.DecodeString(fields[0])
+	if err != nil {
+		return nil, parts, errors.New("crypt: invalid JWE encoding")
+	}
+	h := &jweHeader{}
+	if err := json.Unmarshal(b, h); err != nil {
+		return nil, parts, errors.New("crypt: invalid JWE header")
+	}
+	if h.Alg != alg || h.Enc != jweEnc {
+		return nil, parts, errors.New("crypt: unexpected JWE algorithm " + h.Alg + " " + h.Enc)
+	}
+	if h.Zip != "" || len(h.Crit) != 0 {
+		return nil, parts, errors.New("crypt: unsupported JWE header parameters")
+	}
+
+	return h, parts, nil
+}
+
+// openJWE decrypts a parsed token with cek.
+func openJWE(parts [5][]byte, cek []byte) ([]byte, error) {
+	gcm, err := newGCM((*[32]byte)(cek))
+	if err != nil {
+		return nil, err
+	}
+	iv, ct, tag := parts[2], parts[3], parts[4]
+	if len(iv) != gcm.NonceSize() || len(tag) != tagSize {
+		return nil, errors.New("crypt: invalid JWE")
+	}
+
+	sealed := append(append([]byte{}, ct...), tag...)
+	plaintext, err := gcm.Open(nil, iv, sealed, parts[0])
+	if err != nil {
+		return nil, errors.New("crypt: JWE failed to authenticate")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,74 @@
+package crypt
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestJWE round trips tokens for a key and a password and makes sure a
+// token for one can't be opened as the other.
+func TestJWE(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	plaintext := []byte(`{"sub":"1234"}`)
+
+	token, err := EncryptJWE(plaintext, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(token, ".") != 4 {
+		t.Fatalf("%q is not compact serialization", token)
+	}
+	got, err := DecryptJWE(token, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(plaintext) {
+		t.Fatalf("got %q", got)
+	}
+	if _, err := DecryptJWE(token, randKey()); err == nil {
+		t.Fatal("decrypted with the wrong key")
+	}
+
+	pwToken, err := EncryptJWEWithPassword(plaintext, []byte("hunter2"), minJWEIterations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = DecryptJWEWithPassword(pwToken, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(plaintext) {
+		t.Fatalf("got %q", got)
+	}
+	if _, err := DecryptJWEWithPassword(pwToken, []byte("hunter3")); err == nil {
+		t.Fatal("decrypted with the wrong password")
+	}
+
+	if _, err := DecryptJWE(pwToken, key); err == nil {
+		t.Fatal("password token accepted as dir")
+	}
+	if _, err := DecryptJWEWithPassword(token, []byte("hunter2")); err == nil {
+		t.Fatal("dir token accepted as password")
+	}
+
+	// the header is authenticated
+	parts := strings.Split(token, ".")
+	parts[0] = b64url.EncodeToString([]byte(`{"alg":"dir","enc":"A256GCM","kid":"x"}`))
+	if _, err := DecryptJWE(strings.Join(parts, "."), key); err == nil {
+		t.Fatal("modified header accepted")
+	}
+}
+
+// TestJWEVector decrypts a token made by another JOSE library.
+func TestJWEVector(t *testing.T) {
+	t.Parallel()
+	const token = "eyJhbGciOiJQQkVTMi1IUzUxMitBMjU2S1ciLCJlbmMiOiJBMjU2R0NNIiwicDJjIjoxMDAwLCJwMnMiOiJUYXNJMGRrV3pIM3VrbTJhZzUwaDlRIn0.jkv4Jp1d0Gsu3nU5yLLc8BgpVqD4jxp_kS3y6jXUwPc8uR0GUxGrow.taVcFZ2Fn7fCOo3C.FmTmYF7HKchRqgVs.4i-Efd4FvSvQopV_XkYrYg"
+	got, err := DecryptJWEWithPassword(token, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "pw from jose" {
+		t.Fatalf("got %q", got)
+	}
+}
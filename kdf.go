@@ -0,0 +1,304 @@
+package crypt
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF derives a key from a password, see WithKDF. Argon2id, Scrypt and
+// PBKDF2 implement it, the KDF and its parameters are stored in the stream
+// header so the reader doesn't need to be told which was used.
+type KDF interface {
+	// DeriveKey derives a key from password and salt.
+	DeriveKey(password, salt []byte) (*[32]byte, error)
+
+	// id identifies the KDF in the header and params encodes its
+	// parameters, validate checks them. atLeast reports whether every
+	// cost parameter is at least the one of min, a KDF with the same id
+	id() byte
+	params() []byte
+	validate() error
+	atLeast(min KDF) bool
+}
+
+// KDF identifiers in the stream header.
+const (
+	kdfArgon2id = 1
+	kdfScrypt   = 2
+	kdfPBKDF2   = 3
+)
+
+// saltSize is the size of the random salt stored with the KDF.
+const saltSize = 16
+
+// limits on the parameters a header may ask for, so a hostile stream can't
+// make the Reader spend minutes or gigabytes deriving a key, nor get it to
+// derive with parameters so small they protect nothing.
+const (
+	minArgon2Memory    = 64      // KiB
+	maxArgon2Memory    = 1 << 20 // KiB
+	maxArgon2Time      = 16
+	minScryptLogN      = 10
+	maxScryptLogN      = 20
+	maxScryptR         = 32
+	maxScryptP         = 16
+	maxScryptMemory    = 1 << 30 // 128 * N * r bytes
+	minPBKDF2Iteration = 1000
+	maxPBKDF2Iteration = 10_000_000
+)
+
+// DefaultKDF is used by NewPasswordWriter without WithKDF, it is Argon2id
+// with the second recommended parameters from RFC 9106.
+var DefaultKDF KDF = Argon2id{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+// Argon2id is the Argon2id KDF, Memory is in KiB.
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// DeriveKey derives a key from password and salt.
+func (a Argon2id) DeriveKey(password, salt []byte) (*[32]byte, error) {
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+
+	return (*[32]byte)(argon2.IDKey(password, salt, a.Time, a.Memory, a.Threads, 32)), nil
+}
+
+func (a Argon2id) id() byte { return kdfArgon2id }
+
+func (a Argon2id) validate() error {
+	if a.Time < 1 || a.Time > maxArgon2Time || a.Memory < max(minArgon2Memory, 8*uint32(a.Threads)) ||
+		a.Memory > maxArgon2Memory || a.Threads < 1 {
+		return errors.New("crypt: invalid argon2id parameters")
+	}
+
+	return nil
+}
+
+func (a Argon2id) atLeast(min KDF) bool {
+	m := min.(Argon2id)
+	return a.Time >= m.Time && a.Memory >= m.Memory
+}
+
+func (a Argon2id) params() []byte {
+	b := binary.BigEndian.AppendUint32(nil, a.Time)
+	b = binary.BigEndian.AppendUint32(b, a.Memory)
+	return append(b, a.Threads)
+}
+
+// Scrypt is the scrypt KDF, N must be a power of two.
+type Scrypt struct {
+	N, R, P int
+}
+
+// DeriveKey derives a key from password and salt.
+func (s Scrypt) DeriveKey(password, salt []byte) (*[32]byte, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key(password, salt, s.N, s.R, s.P, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return (*[32]byte)(key), nil
+}
+
+func (s Scrypt) id() byte { return kdfScrypt }
+
+func (s Scrypt) validate() error {
+	if s.N < 1<<minScryptLogN || s.N > 1<<maxScryptLogN || s.N&(s.N-1) != 0 ||
+		s.R < 1 || s.R > maxScryptR || s.P < 1 || s.P > maxScryptP ||
+		128*int64(s.N)*int64(s.R) > maxScryptMemory {
+		return errors.New("crypt: invalid scrypt parameters")
+	}
+
+	return nil
+}
+
+func (s Scrypt) atLeast(min KDF) bool {
+	m := min.(Scrypt)
+	return s.N >= m.N && s.R >= m.R && s.P >= m.P
+}
+
+func (s Scrypt) params() []byte {
+	logN := byte(0)
+	for n := s.N; n > 1; n >>= 1 {
+		logN++
+	}
+
+	b := []byte{logN}
+	b = binary.BigEndian.AppendUint32(b, uint32(s.R))
+	return binary.BigEndian.AppendUint32(b, uint32(s.P))
+}
+
+// PBKDF2 is PBKDF2 with HMAC-SHA256, for environments that require it.
+type PBKDF2 struct {
+	Iterations int
+}
+
+// DeriveKey derives a key from password and salt.
+func (p PBKDF2) DeriveKey(password, salt []byte) (*[32]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	key, err := pbkdf2.Key(sha256.New, string(password), salt, p.Iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return (*[32]byte)(key), nil
+}
+
+func (p PBKDF2) id() byte { return kdfPBKDF2 }
+
+func (p PBKDF2) validate() error {
+	if p.Iterations < minPBKDF2Iteration || p.Iterations > maxPBKDF2Iteration {
+		return errors.New("crypt: invalid pbkdf2 parameters")
+	}
+
+	return nil
+}
+
+func (p PBKDF2) atLeast(min KDF) bool {
+	return p.Iterations >= min.(PBKDF2).Iterations
+}
+
+func (p PBKDF2) params() []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(p.Iterations))
+}
+
+// WithKDF sets the KDF NewPasswordWriter derives the key with, the default
+// is DefaultKDF. NewPasswordReader reads the KDF from the stream so it
+// ignores this option.
+func WithKDF(kdf KDF) Option {
+	return func(c *config) error {
+		// catch bad parameters now rather than when the key is derived
+		if err := kdf.validate(); err != nil {
+			return err
+		}
+
+		c.kdf = kdf
+		return nil
+	}
+}
+
+// marshalKDF encodes kdf and salt for the header, as id | params | salt.
+func marshalKDF(kdf KDF, salt []byte) []byte {
+	return append(append([]byte{kdf.id()}, kdf.params()...), salt...)
+}
+
+// parseKDF decodes a header field made by marshalKDF.
+func parseKDF(b []byte) (KDF, []byte, error) {
+	errMalformed := errBadHeader("malformed kdf header field")
+	if len(b) < 1+saltSize {
+		return nil, nil, errMalformed
+	}
+	id, params, salt := b[0], b[1:len(b)-saltSize], b[len(b)-saltSize:]
+
+	var kdf KDF
+	switch {
+	case id == kdfArgon2id && len(params) == 9:
+		kdf = Argon2id{
+			Time:    binary.BigEndian.Uint32(params),
+			Memory:  binary.BigEndian.Uint32(params[4:]),
+			Threads: params[8],
+		}
+	case id == kdfScrypt && len(params) == 9 && params[0] < 32:
+		kdf = Scrypt{
+			N: 1 << params[0],
+			R: int(binary.BigEndian.Uint32(params[1:])),
+			P: int(binary.BigEndian.Uint32(params[5:])),
+		}
+	case id == kdfPBKDF2 && len(params) == 4:
+		kdf = PBKDF2{Iterations: int(binary.BigEndian.Uint32(params))}
+	default:
+		return nil, nil, errMalformed
+	}
+	// refuse out of range parameters before anything is derived with them
+	if err := kdf.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return kdf, salt, nil
+}
+
+// NewPasswordWriter is NewWriter with a key derived from password, see
+// WithKDF. a random salt and the KDF parameters are stored in the stream
+// header.
+func NewPasswordWriter(w io.Writer, password []byte, opts ...Option) (*Writer, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.policy.check(c.cipher, headerVersion); err != nil {
+		return nil, err
+	}
+	if err := c.policy.checkKDF(c.kdf); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(c.random, salt); err != nil {
+		return nil, err
+	}
+
+	key, err := c.kdf.DeriveKey(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	if debug {
+		trace("password kdf=%+v salt=%x key=%x", c.kdf, salt, key[:])
+	}
+
+	return newWriter(w, key, c, map[byte][]byte{fieldKDF: marshalKDF(c.kdf, salt)})
+}
+
+// NewPasswordReader is NewReader for streams made by NewPasswordWriter, the
+// key is derived from password with the KDF in the stream header.
+func NewPasswordReader(r io.Reader, password []byte, opts ...Option) (*Reader, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	h, _, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.policy.check(h.cipher, h.version); err != nil {
+		return nil, err
+	}
+	field, ok := h.fields[fieldKDF]
+	if !ok {
+		return nil, errors.New("crypt: stream is not password protected")
+	}
+	kdf, salt, err := parseKDF(field)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.policy.checkKDF(kdf); err != nil {
+		return nil, err
+	}
+
+	key, err := kdf.DeriveKey(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	if debug {
+		trace("password kdf=%+v salt=%x key=%x", kdf, salt, key[:])
+	}
+
+	return newReader(r, key, c, h)
+}
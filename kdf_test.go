@@ -0,0 +1,113 @@
+package crypt
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// TestKDF makes sure every KDF round trips through the header, and the
+// wrong password or a plain stream is refused.
+func TestKDF(t *testing.T) {
+	t.Parallel()
+	password := []byte("correct horse battery staple")
+	data := randBytes(100)
+
+	// cheap parameters, the defaults are too slow for tests
+	kdfs := []KDF{
+		Argon2id{Time: 1, Memory: 64, Threads: 1},
+		Scrypt{N: 1 << 10, R: 8, P: 1},
+		PBKDF2{Iterations: 1000},
+	}
+	for _, kdf := range kdfs {
+		var buf bytes.Buffer
+		w, err := NewPasswordWriter(&buf, password, WithKDF(kdf), WithChunkSize(64))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		stream := buf.Bytes()
+
+		r, err := NewPasswordReader(bytes.NewReader(stream), password)
+		if err != nil {
+			t.Fatalf("%T: %v", kdf, err)
+		}
+		decrypted, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%T: %v", kdf, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatalf("%T: data did not round trip", kdf)
+		}
+
+		r, err = NewPasswordReader(bytes.NewReader(stream), []byte("wrong"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(r); err == nil {
+			t.Fatalf("%T: wrong password was accepted", kdf)
+		}
+
+		// the parameters are read back exactly
+		h, _, err := readHeader(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _, err := parseKDF(h.fields[fieldKDF]); err != nil || got != kdf {
+			t.Fatalf("%T: parsed as %v, %v", kdf, got, err)
+		}
+	}
+
+	if _, err := NewPasswordReader(bytes.NewReader(sealStream(t, randKey(), data, 64)), password); err == nil {
+		t.Fatal("stream without a kdf was accepted")
+	}
+
+	bad := []KDF{Argon2id{}, Scrypt{N: 1000, R: 8, P: 1}, PBKDF2{}, Argon2id{Time: 1, Memory: 1 << 30, Threads: 1}}
+	for _, kdf := range bad {
+		if _, err := NewPasswordWriter(&bytes.Buffer{}, password, WithKDF(kdf)); err == nil {
+			t.Fatalf("%+v was accepted", kdf)
+		}
+	}
+}
+
+// TestKDFLimits rewrites the KDF parameters in a stream header to ones out
+// of range, the reader has to refuse them before deriving anything.
+func TestKDFLimits(t *testing.T) {
+	t.Parallel()
+	password := []byte("hunter2")
+	for _, tc := range []struct{ good, bad KDF }{
+		{Argon2id{Time: 1, Memory: 64, Threads: 1}, Argon2id{Time: 64, Memory: 4 << 20, Threads: 1}},
+		{Argon2id{Time: 1, Memory: 64, Threads: 1}, Argon2id{Time: 1, Memory: 8, Threads: 1}},
+		{Scrypt{N: 1 << 10, R: 8, P: 1}, Scrypt{N: 1 << 24, R: 1024, P: 1}},
+		{Scrypt{N: 1 << 10, R: 8, P: 1}, Scrypt{N: 1 << 20, R: 32, P: 1}},
+		{Scrypt{N: 1 << 10, R: 8, P: 1}, Scrypt{N: 2, R: 8, P: 1}},
+		{PBKDF2{Iterations: 1000}, PBKDF2{Iterations: 100_000_000}},
+		{PBKDF2{Iterations: 1000}, PBKDF2{Iterations: 1}},
+	} {
+		var buf bytes.Buffer
+		w, err := NewPasswordWriter(&buf, password, WithKDF(tc.good))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		old := append([]byte{tc.good.id()}, tc.good.params()...)
+		stream := bytes.Replace(buf.Bytes(), old, append([]byte{tc.bad.id()}, tc.bad.params()...), 1)
+
+		if _, err := NewPasswordReader(bytes.NewReader(stream), password); err == nil {
+			t.Errorf("%+v was accepted", tc.bad)
+		}
+		if _, err := Inspect(bytes.NewReader(stream)); err == nil {
+			t.Errorf("%+v was inspected", tc.bad)
+		}
+		if _, err := NewPasswordWriter(io.Discard, password, WithKDF(tc.bad)); err == nil {
+			t.Errorf("%+v was accepted for writing", tc.bad)
+		}
+	}
+}
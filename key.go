@@ -0,0 +1,196 @@
+package crypt
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// minDistinctKeyBytes is the fewest distinct byte values a key may contain,
+// 32 random bytes have around 30 so anything below this was almost certainly
+// not generated randomly.
+const minDistinctKeyBytes = 16
+
+// Key is a 256-bit key. the rest of the package takes keys as *[32]byte, a
+// *Key converts with (*[32]byte)(k).
+type Key [32]byte
+
+// GenerateKey returns a new random key.
+func GenerateKey() (*Key, error) {
+	k := &Key{}
+	if _, err := io.ReadFull(randReader, k[:]); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// ParseKey decodes a key from hex or base64 (standard or url alphabet,
+// padded or not), and rejects it if it doesn't look random.
+func ParseKey(s string) (*Key, error) {
+	key, err := decodeKey(s)
+	return (*Key)(key), err
+}
+
+// Validate returns an error if k was obviously not generated randomly, see
+// ParseKey.
+func (k *Key) Validate() error {
+	return validateKey((*[32]byte)(k))
+}
+
+// Hex returns k hex encoded.
+func (k *Key) Hex() string {
+	return hex.EncodeToString(k[:])
+}
+
+// Base64 returns k in standard padded base64.
+func (k *Key) Base64() string {
+	return base64.StdEncoding.EncodeToString(k[:])
+}
+
+// Fingerprint returns a short identifier for k, 32 hex digits derived from
+// it with HKDF. it is stable, safe to log and reveals nothing about the key,
+// so operators can tell keys apart without printing them. streams written
+// with WithKeyFingerprint record it, see StreamInfo.KeyFingerprint.
+func (k *Key) Fingerprint() string {
+	fp, err := fingerprint((*[32]byte)(k))
+	if err != nil {
+		return "?"
+	}
+
+	return fp
+}
+
+// Equal reports whether k and other are the same key, in constant time.
+func (k *Key) Equal(other *Key) bool {
+	return ConstantTimeEqual((*[32]byte)(k), (*[32]byte)(other))
+}
+
+// Wipe zeroes k, for when it is no longer needed. copies made elsewhere,
+// such as by the garbage collector moving it, are out of reach, see
+// LockedKey for keys that never leave one place.
+func (k *Key) Wipe() {
+	clear(k[:])
+}
+
+// String returns k's fingerprint rather than the key itself, so a key that
+// ends up in a log or error message doesn't leak.
+func (k *Key) String() string {
+	return "Key(" + k.Fingerprint() + ")"
+}
+
+// ConstantTimeEqual reports whether a and b are the same key without
+// leaking through timing where they differ. keys should never be compared
+// with == or bytes.Equal.
+func ConstantTimeEqual(a, b *[32]byte) bool {
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// MarshalText encodes k as base64, for config files.
+func (k *Key) MarshalText() ([]byte, error) {
+	return []byte(k.Base64()), nil
+}
+
+// UnmarshalText decodes a key the same way as ParseKey.
+func (k *Key) UnmarshalText(text []byte) error {
+	key, err := ParseKey(string(text))
+	if err != nil {
+		return err
+	}
+
+	*k = *key
+	return nil
+}
+
+// LoadKeyFromEnv reads a key from the environment variable name. the value
+// may be hex or base64 (standard or url alphabet, padded or not) and must
+// decode to exactly 32 bytes that look random. the variable is unset once
+// read so child processes don't inherit it, the Go runtime keeps its own
+// copy of the environment so this is the best that can be done.
+func LoadKeyFromEnv(name string) (*[32]byte, error) {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, errors.New("environment variable " + name + " is not set")
+	}
+	os.Unsetenv(name)
+
+	key, err := decodeKey(s)
+	if err != nil {
+		return nil, errors.New("environment variable " + name + ": " + err.Error())
+	}
+
+	return key, nil
+}
+
+// decodeKey decodes a hex or base64 encoded key and validates it.
+func decodeKey(s string) (*[32]byte, error) {
+	s = strings.TrimSpace(s)
+
+	var b []byte
+	var err error
+	if len(s) == hex.EncodedLen(32) {
+		b, err = hex.DecodeString(s)
+	} else {
+		b, err = decodeBase64(s)
+	}
+	if err != nil {
+		return nil, errors.New("key is not valid hex or base64")
+	}
+
+	if len(b) != 32 {
+		return nil, errors.New("key must be 32 bytes")
+	}
+
+	key := &[32]byte{}
+	copy(key[:], b)
+	return key, validateKey(key)
+}
+
+// decodeBase64 tries each base64 alphabet and padding in turn.
+func decodeBase64(s string) ([]byte, error) {
+	var err error
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding,
+		base64.RawStdEncoding,
+		base64.URLEncoding,
+		base64.RawURLEncoding,
+	} {
+		var b []byte
+		if b, err = enc.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+
+	return nil, err
+}
+
+// validateKey rejects keys that were obviously not generated randomly, such
+// as all zeros or a passphrase pasted in place of a key. it can't prove a key
+// is random, only catch the common mistakes.
+func validateKey(key *[32]byte) error {
+	var seen [256]bool
+	distinct := 0
+	printable := true
+	for _, c := range key {
+		if !seen[c] {
+			seen[c] = true
+			distinct++
+		}
+		if c < 0x20 || c > 0x7e {
+			printable = false
+		}
+	}
+
+	if distinct < minDistinctKeyBytes {
+		return errors.New("key has too little entropy")
+	}
+	if printable {
+		return errors.New("key is printable text, it looks like a passphrase")
+	}
+
+	return nil
+}
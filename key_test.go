@@ -0,0 +1,93 @@
+package crypt
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestLoadKeyFromEnv loads keys in every supported encoding and makes sure
+// bad keys are rejected.
+func TestLoadKeyFromEnv(t *testing.T) {
+	key := randKey()
+	const name = "CRYPT_TEST_KEY"
+
+	good := []string{
+		hex.EncodeToString(key[:]),
+		base64.StdEncoding.EncodeToString(key[:]),
+		base64.RawURLEncoding.EncodeToString(key[:]),
+	}
+	for _, v := range good {
+		t.Setenv(name, v)
+		got, err := LoadKeyFromEnv(name)
+		if err != nil {
+			t.Fatalf("%q: %v", v, err)
+		}
+		if *got != *key {
+			t.Fatalf("%q decoded to the wrong key", v)
+		}
+		if _, ok := os.LookupEnv(name); ok {
+			t.Fatal("variable was not unset")
+		}
+	}
+
+	bad := []string{
+		"",
+		"not a key",
+		hex.EncodeToString(key[:16]),
+		hex.EncodeToString(make([]byte, 32)),
+		base64.StdEncoding.EncodeToString([]byte("abcdefghijklmnopqrstuvwxyz012345")),
+	}
+	for _, v := range bad {
+		t.Setenv(name, v)
+		if _, err := LoadKeyFromEnv(name); err == nil {
+			t.Fatalf("%q should have been rejected", v)
+		}
+	}
+
+	os.Unsetenv(name)
+	if _, err := LoadKeyFromEnv(name); err == nil {
+		t.Fatal("expected an error for an unset variable")
+	}
+}
+
+// TestKey round trips a generated key through every encoding, and makes
+// sure String doesn't give it away.
+func TestKey(t *testing.T) {
+	t.Parallel()
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := key.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{key.Hex(), key.Base64()} {
+		got, err := ParseKey(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got != *key {
+			t.Fatalf("%q parsed to the wrong key", s)
+		}
+	}
+
+	text, _ := key.MarshalText()
+	var got Key
+	if err := got.UnmarshalText(text); err != nil || got != *key {
+		t.Fatal("key did not round trip through text")
+	}
+	if err := got.UnmarshalText([]byte("nope")); err == nil {
+		t.Fatal("bad text was accepted")
+	}
+
+	if s := key.String(); strings.Contains(s, key.Hex()[:8]) {
+		t.Fatalf("String leaks the key: %s", s)
+	}
+	if _, err := ParseKey(hex.EncodeToString(make([]byte, 32))); err == nil {
+		t.Fatal("all zero key was accepted")
+	}
+}
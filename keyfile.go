@@ -0,0 +1,179 @@
+package crypt
+
+import (
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"runtime"
+	"strconv"
+)
+
+// key file PEM block types, the key is either stored as is or sealed with
+// a key derived from a passphrase. a sealed key's block holds the length
+// of the KDF field as a big endian uint16, the KDF field as in stream
+// headers and the key as made by Encrypt with the KDF field as additional
+// data.
+const (
+	keyFileType          = "CRYPT KEY"
+	encryptedKeyFileType = "CRYPT ENCRYPTED KEY"
+
+	// maxKeyFileSize is far more than any key file needs
+	maxKeyFileSize = 64 << 10
+)
+
+// ErrPassphraseRequired is returned by LoadKeyFile for a key file protected
+// by a passphrase when none is given.
+var ErrPassphraseRequired = errors.New("crypt: key file is protected by a passphrase")
+
+// SaveKeyFile writes key to a new file at path with mode 0600, as a PEM
+// block so it is recognizable. with a passphrase the key is encrypted with
+// a key derived from it with DefaultKDF or the one given by WithKDF,
+// WithCipher picks the cipher. an existing file is never overwritten.
+func SaveKeyFile(path string, key *[32]byte, passphrase []byte, opts ...Option) (err error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	block := &pem.Block{Type: keyFileType, Bytes: key[:]}
+	if passphrase != nil {
+		if err := c.policy.checkKDF(c.kdf); err != nil {
+			return err
+		}
+		salt := make([]byte, saltSize)
+		if _, err := io.ReadFull(c.random, salt); err != nil {
+			return err
+		}
+		kek, err := c.kdf.DeriveKey(passphrase, salt)
+		if err != nil {
+			return err
+		}
+		field := marshalKDF(c.kdf, salt)
+		sealed, err := Encrypt(key[:], kek, WithCipher(c.cipher), WithAAD(field), WithEntropySource(c.random))
+		if err != nil {
+			return err
+		}
+		b := binary.BigEndian.AppendUint16(nil, uint16(len(field)))
+		block = &pem.Block{Type: encryptedKeyFileType, Bytes: append(append(b, field...), sealed...)}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+	if err := pem.Encode(f, block); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadKeyFile reads a key written by SaveKeyFile, or a file holding just a
+// key in hex or base64 as ParseKey accepts. on unix it refuses a
+// file that its group or others can access, since the key may already
+// have leaked. passphrase is only needed for protected keys, without it
+// they fail with ErrPassphraseRequired. WithSecurityPolicy applies to the
+// cipher of protected keys.
+func LoadKeyFile(path string, passphrase []byte, opts ...Option) (*[32]byte, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyFileMode(path, st); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(f, maxKeyFileSize))
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		key, err := decodeKey(string(data))
+		if err != nil {
+			return nil, errors.New("crypt: " + path + " is not a key file")
+		}
+		return key, nil
+	}
+	switch block.Type {
+	case keyFileType:
+		if len(block.Bytes) != 32 {
+			return nil, errors.New("crypt: key in " + path + " is not 32 bytes")
+		}
+		return (*[32]byte)(block.Bytes), nil
+	case encryptedKeyFileType:
+		if passphrase == nil {
+			return nil, ErrPassphraseRequired
+		}
+		return openKeyFile(block.Bytes, passphrase, c)
+	default:
+		return nil, errors.New("crypt: " + path + " holds a " + strconv.Quote(block.Type) + " block, not a key")
+	}
+}
+
+// openKeyFile decrypts the key in a protected key file block.
+func openKeyFile(b, passphrase []byte, c *config) (*[32]byte, error) {
+	if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+		return nil, errors.New("crypt: malformed protected key file")
+	}
+	field, sealed := b[2:2+int(binary.BigEndian.Uint16(b))], b[2+int(binary.BigEndian.Uint16(b)):]
+	kdf, salt, err := parseKDF(field)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.policy.checkKDF(kdf); err != nil {
+		return nil, err
+	}
+	if len(sealed) != 0 {
+		if err := c.policy.check(Cipher(sealed[0]), 0); err != nil {
+			return nil, err
+		}
+	}
+
+	kek, err := kdf.DeriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	key, err := Decrypt(sealed, kek, WithAAD(field))
+	if err != nil {
+		return nil, &detailError{msg: "crypt: wrong passphrase or corrupted key file", err: ErrAuthenticationFailed}
+	}
+	if len(key) != 32 {
+		return nil, errors.New("crypt: protected key is not 32 bytes")
+	}
+	return (*[32]byte)(key), nil
+}
+
+// checkKeyFileMode refuses key files with any permission for group or
+// others. Windows doesn't have unix permissions to check.
+func checkKeyFileMode(path string, st fs.FileInfo) error {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		return nil
+	}
+	if perm := st.Mode().Perm(); perm&0o077 != 0 {
+		return errors.New("crypt: key file " + path + " has mode " + perm.String() + ", it must not be accessible to group or others (chmod 600)")
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package crypt
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// Keyring holds several keys by ID, one of which is the primary. streams
+// are written with the primary key and record its ID in their header, so
+// readers pick the right key on their own. rotating keys is a matter of
+// adding a new key and making it the primary, old streams still decrypt as
+// long as their key stays in the keyring.
+type Keyring struct {
+	mu         sync.RWMutex
+	keys       map[uint32]*[32]byte
+	primary    uint32
+	hasPrimary bool
+}
+
+// NewKeyring returns an empty keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[uint32]*[32]byte)}
+}
+
+// Add adds key under id, ids can't be reused while the old key is still in
+// the keyring. the first key added becomes the primary.
+func (k *Keyring) Add(id uint32, key *[32]byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.keys[id]; ok {
+		return errors.New("crypt: key " + strconv.FormatUint(uint64(id), 10) + " is already in the keyring")
+	}
+
+	// copy key so the caller can't change it from under us
+	c := *key
+	k.keys[id] = &c
+	if !k.hasPrimary {
+		k.primary, k.hasPrimary = id, true
+	}
+
+	return nil
+}
+
+// SetPrimary makes the key with id the one new streams are written with.
+func (k *Keyring) SetPrimary(id uint32) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.keys[id]; !ok {
+		return errUnknownKey(id)
+	}
+
+	k.primary, k.hasPrimary = id, true
+	return nil
+}
+
+// Remove removes the key with id, streams written with it can no longer be
+// read. the primary can't be removed.
+func (k *Keyring) Remove(id uint32) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.hasPrimary && id == k.primary {
+		return errors.New("crypt: the primary key can't be removed")
+	}
+
+	delete(k.keys, id)
+	return nil
+}
+
+// Key returns the key with id.
+func (k *Keyring) Key(id uint32) (*[32]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, errUnknownKey(id)
+	}
+
+	return key, nil
+}
+
+// Primary returns the primary key and its id.
+func (k *Keyring) Primary() (uint32, *[32]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if !k.hasPrimary {
+		return 0, nil, errors.New("crypt: keyring is empty")
+	}
+
+	return k.primary, k.keys[k.primary], nil
+}
+
+// NewWriter is NewWriter with the primary key, its id is stored in the
+// stream header.
+func (k *Keyring) NewWriter(w io.Writer, opts ...Option) (*Writer, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	id, key, err := k.Primary()
+	if err != nil {
+		return nil, err
+	}
+
+	return newWriter(w, key, c, map[byte][]byte{fieldKeyID: binary.BigEndian.AppendUint32(nil, id)})
+}
+
+// NewReader is NewReader with the key whose id is in the stream header.
+func (k *Keyring) NewReader(r io.Reader, opts ...Option) (*Reader, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	h, _, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.policy.check(h.cipher, h.version); err != nil {
+		return nil, err
+	}
+
+	field, ok := h.fields[fieldKeyID]
+	if !ok || len(field) != 4 {
+		return nil, errBadHeader("stream has no key id")
+	}
+	key, err := k.Key(binary.BigEndian.Uint32(field))
+	if err != nil {
+		return nil, err
+	}
+
+	return newReader(r, key, c, h)
+}
+
+// errUnknownKey is returned when a key id isn't in the keyring.
+func errUnknownKey(id uint32) error {
+	return &detailError{msg: "crypt: key " + strconv.FormatUint(uint64(id), 10) + " is not in the keyring", err: ErrKeyNotFound}
+}
@@ -0,0 +1,82 @@
+package crypt
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// TestKeyring rotates the primary key and makes sure streams written before
+// and after both decrypt, until the old key is removed.
+func TestKeyring(t *testing.T) {
+	t.Parallel()
+	k := NewKeyring()
+	if _, err := k.NewWriter(&bytes.Buffer{}); err == nil {
+		t.Fatal("empty keyring wrote a stream")
+	}
+
+	write := func(data []byte) []byte {
+		var buf bytes.Buffer
+		w, err := k.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	read := func(stream []byte) ([]byte, error) {
+		r, err := k.NewReader(bytes.NewReader(stream))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	if err := k.Add(1, randKey()); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Add(1, randKey()); err == nil {
+		t.Fatal("key id was reused")
+	}
+	oldData := randBytes(100)
+	oldStream := write(oldData)
+
+	if err := k.Add(2, randKey()); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetPrimary(2); err != nil {
+		t.Fatal(err)
+	}
+	newData := randBytes(100)
+	newStream := write(newData)
+
+	for _, tc := range []struct{ stream, data []byte }{{oldStream, oldData}, {newStream, newData}} {
+		got, err := read(tc.stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, tc.data) {
+			t.Fatal("data did not round trip")
+		}
+	}
+
+	if err := k.Remove(2); err == nil {
+		t.Fatal("removed the primary key")
+	}
+	if err := k.Remove(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := read(oldStream); err == nil {
+		t.Fatal("read a stream whose key was removed")
+	}
+
+	// a stream without a key id can't be read through a keyring
+	if _, err := read(sealStream(t, randKey(), nil, 16)); err == nil {
+		t.Fatal("read a stream without a key id")
+	}
+}
@@ -0,0 +1,78 @@
+// Package keystore keeps crypt keys in the operating system's own secret
+// storage instead of files next to the data they protect: the login
+// Keychain on macOS, the Secret Service (GNOME Keyring, KWallet) on Linux
+// and other unixes through secret-tool, and DPAPI protected files on
+// Windows. keys are stored per user under a service name, usually the
+// application, and an account name, so one application can keep several.
+//
+// on Windows DPAPI ties the file to the user's login, anything running as
+// the user can still unprotect it, as is the case for every backend here.
+package keystore
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ErrNotFound is returned by Get for a key that isn't stored.
+var ErrNotFound = errors.New("keystore: key not found")
+
+// label is the description stored next to keys where the backend has one.
+const label = "crypt key"
+
+// backend stores secrets by service and account.
+type backend interface {
+	set(service, account string, secret []byte) error
+	get(service, account string) ([]byte, error)
+	delete(service, account string) error
+}
+
+// native is the backend of this system, set by the file for it.
+var native backend
+
+// Set stores key under service and account, replacing any key there.
+func Set(service, account string, key *[32]byte) error {
+	if err := validName(service, account); err != nil {
+		return err
+	}
+	return native.set(service, account, []byte(hex.EncodeToString(key[:])))
+}
+
+// Get returns the key stored under service and account, ErrNotFound if
+// there is none.
+func Get(service, account string) (*[32]byte, error) {
+	if err := validName(service, account); err != nil {
+		return nil, err
+	}
+	secret, err := native.get(service, account)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &[32]byte{}
+	if n, err := hex.Decode(key[:], []byte(strings.TrimSpace(string(secret)))); err != nil || n != 32 {
+		return nil, errors.New("keystore: stored value for " + service + "/" + account + " is not a crypt key")
+	}
+	return key, nil
+}
+
+// Delete removes the key stored under service and account, deleting one
+// that isn't there is not an error.
+func Delete(service, account string) error {
+	if err := validName(service, account); err != nil {
+		return err
+	}
+	return native.delete(service, account)
+}
+
+// validName checks service and account can be used by every backend, on
+// Windows they become file names.
+func validName(service, account string) error {
+	for _, s := range []string{service, account} {
+		if s == "" || s == "." || s == ".." || strings.ContainsAny(s, `/\:*?"<>|`+"\x00") {
+			return errors.New("keystore: invalid service or account name " + `"` + s + `"`)
+		}
+	}
+	return nil
+}
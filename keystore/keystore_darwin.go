@@ -0,0 +1,82 @@
+package keystore
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// errSecItemNotFound is the exit status of security for a missing item.
+const errSecItemNotFound = 44
+
+func init() {
+	native = keychain{}
+}
+
+// keychain stores keys as generic passwords in the login Keychain.
+type keychain struct{}
+
+// set runs security interactively and sends the command on its stdin, so
+// the secret never appears on a command line where other processes could
+// see it.
+func (keychain) set(service, account string, secret []byte) error {
+	// names can't hold quotes or backslashes, see validName, so quoting
+	// them is enough as long as they stay on one line
+	if strings.ContainsAny(service+account, "\r\n") {
+		return errors.New("keystore: Keychain: service and account names can't hold line breaks")
+	}
+	var line []byte
+	for _, arg := range []string{"add-generic-password", "-U", "-s", service, "-a", account, "-l", label, "-w"} {
+		line = append(line, `"`+arg+`" `...)
+	}
+	line = append(line, '"')
+	line = append(line, secret...)
+	line = append(line, "\"\n"...)
+	defer clear(line)
+
+	var stderr strings.Builder
+	cmd := exec.Command("/usr/bin/security", "-i")
+	cmd.Stdin = bytes.NewReader(line)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	// in interactive mode a failed command is only reported on stderr
+	if s := strings.TrimSpace(stderr.String()); err == nil && s != "" {
+		err = errors.New(s)
+	}
+	if err != nil {
+		return securityError(err)
+	}
+	return nil
+}
+
+func (keychain) get(service, account string) ([]byte, error) {
+	out, err := exec.Command("/usr/bin/security", "find-generic-password", "-s", service, "-a", account, "-w").Output()
+	if err != nil {
+		return nil, securityError(err)
+	}
+	return out, nil
+}
+
+func (keychain) delete(service, account string) error {
+	err := security("delete-generic-password", "-s", service, "-a", account)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func security(args ...string) error {
+	if err := exec.Command("/usr/bin/security", args...).Run(); err != nil {
+		return securityError(err)
+	}
+	return nil
+}
+
+func securityError(err error) error {
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == errSecItemNotFound {
+		return ErrNotFound
+	}
+	return errors.New("keystore: Keychain: " + err.Error())
+}
@@ -0,0 +1,17 @@
+//go:build !unix && !windows
+
+package keystore
+
+import "errors"
+
+func init() {
+	native = unsupported{}
+}
+
+type unsupported struct{}
+
+var errUnsupported = errors.New("keystore: no key storage on this system")
+
+func (unsupported) set(string, string, []byte) error   { return errUnsupported }
+func (unsupported) get(string, string) ([]byte, error) { return nil, errUnsupported }
+func (unsupported) delete(string, string) error        { return errUnsupported }
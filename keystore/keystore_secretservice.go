@@ -0,0 +1,68 @@
+//go:build unix && !darwin
+
+package keystore
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+func init() {
+	native = secretService{}
+}
+
+// secretService stores keys through the Secret Service API with
+// secret-tool, which ships with libsecret. the secret goes to it on stdin.
+type secretService struct{}
+
+func (secretService) set(service, account string, secret []byte) error {
+	cmd := exec.Command("secret-tool", "store", "--label="+label+" for "+service, "service", service, "account", account)
+	cmd.Stdin = bytes.NewReader(secret)
+	return secretToolRun(cmd)
+}
+
+func (secretService) get(service, account string) ([]byte, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	cmd.Stdout = &stdout
+	if err := secretToolRun(cmd); err != nil {
+		// lookup exits with 1 and prints nothing when there is no match
+		var exit *exec.ExitError
+		if errors.As(errors.Unwrap(err), &exit) && stdout.Len() == 0 {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if stdout.Len() == 0 {
+		return nil, ErrNotFound
+	}
+	return stdout.Bytes(), nil
+}
+
+func (secretService) delete(service, account string) error {
+	return secretToolRun(exec.Command("secret-tool", "clear", "service", service, "account", account))
+}
+
+// secretToolRun runs cmd, including what secret-tool said in the error.
+func secretToolRun(cmd *exec.Cmd) error {
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := "keystore: secret-tool: " + err.Error()
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			msg += ": " + s
+		}
+		return &toolError{msg: msg, err: err}
+	}
+	return nil
+}
+
+type toolError struct {
+	msg string
+	err error
+}
+
+func (e *toolError) Error() string { return e.msg }
+func (e *toolError) Unwrap() error { return e.err }
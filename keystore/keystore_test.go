@@ -0,0 +1,73 @@
+package keystore
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// memBackend is an in memory backend.
+type memBackend map[string][]byte
+
+func (m memBackend) set(service, account string, secret []byte) error {
+	m[service+"/"+account] = secret
+	return nil
+}
+
+func (m memBackend) get(service, account string) ([]byte, error) {
+	s, ok := m[service+"/"+account]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s, nil
+}
+
+func (m memBackend) delete(service, account string) error {
+	delete(m, service+"/"+account)
+	return nil
+}
+
+func TestKeystore(t *testing.T) {
+	defer func(b backend) { native = b }(native)
+	mem := memBackend{}
+	native = mem
+
+	key := &[32]byte{}
+	rand.Read(key[:])
+	if err := Set("backup-tool", "default", key); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Get("backup-tool", "default")
+	if err != nil || *got != *key {
+		t.Fatalf("Get = %x, %v", got, err)
+	}
+	if _, err := Get("backup-tool", "other"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("missing key: %v", err)
+	}
+
+	// backends may hand the secret back with a newline
+	mem["backup-tool/default"] = append(mem["backup-tool/default"], '\n')
+	if got, err := Get("backup-tool", "default"); err != nil || *got != *key {
+		t.Fatalf("Get with newline = %x, %v", got, err)
+	}
+	mem["backup-tool/default"] = []byte("hunter2")
+	if _, err := Get("backup-tool", "default"); err == nil {
+		t.Fatal("non key value returned")
+	}
+
+	if err := Delete("backup-tool", "default"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Get("backup-tool", "default"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("deleted key: %v", err)
+	}
+
+	for _, name := range []string{"", "..", "a/b", `a\b`, "c:"} {
+		if err := Set(name, "default", key); err == nil {
+			t.Errorf("service %q accepted", name)
+		}
+		if _, err := Get("app", name); err == nil {
+			t.Errorf("account %q accepted", name)
+		}
+	}
+}
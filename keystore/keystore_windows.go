@@ -0,0 +1,83 @@
+package keystore
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func init() {
+	native = dpapi{}
+}
+
+// dpapi stores keys in files under the user's config directory, encrypted
+// for the user with CryptProtectData. the service and account are mixed in
+// as entropy so files can't be swapped.
+type dpapi struct{}
+
+func (dpapi) path(service, account string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, service, account+".key"), nil
+}
+
+func (d dpapi) set(service, account string, secret []byte) error {
+	p, err := d.path(service, account)
+	if err != nil {
+		return err
+	}
+	blob, err := dpapiCall(windows.CryptProtectData, secret, service, account)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(p, blob, 0o600)
+}
+
+func (d dpapi) get(service, account string) ([]byte, error) {
+	p, err := d.path(service, account)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return dpapiCall(func(in *windows.DataBlob, _ *uint16, entropy *windows.DataBlob, reserved uintptr, prompt *windows.CryptProtectPromptStruct, flags uint32, out *windows.DataBlob) error {
+		return windows.CryptUnprotectData(in, nil, entropy, reserved, prompt, flags, out)
+	}, blob, service, account)
+}
+
+func (d dpapi) delete(service, account string) error {
+	p, err := d.path(service, account)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// dpapiCall runs CryptProtectData or CryptUnprotectData over in.
+func dpapiCall(fn func(*windows.DataBlob, *uint16, *windows.DataBlob, uintptr, *windows.CryptProtectPromptStruct, uint32, *windows.DataBlob) error, in []byte, service, account string) ([]byte, error) {
+	entropy := []byte("crypt keystore\x00" + service + "\x00" + account)
+	inBlob := windows.DataBlob{Size: uint32(len(in)), Data: unsafe.SliceData(in)}
+	entropyBlob := windows.DataBlob{Size: uint32(len(entropy)), Data: unsafe.SliceData(entropy)}
+	var out windows.DataBlob
+	if err := fn(&inBlob, nil, &entropyBlob, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return nil, errors.New("keystore: DPAPI: " + err.Error())
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return append([]byte(nil), unsafe.Slice(out.Data, out.Size)...), nil
+}
@@ -0,0 +1,181 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// keyWrapIV is the default initial value from RFC 3394.
+var keyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// keyWrapPadIV is the first half of the alternative initial value from
+// RFC 5649, followed by the length of the key.
+var keyWrapPadIV = []byte{0xa6, 0x59, 0x59, 0xa6}
+
+// errKeyUnwrap is returned when a wrapped key fails its integrity check.
+var errKeyUnwrap = &detailError{msg: "crypt: wrapped key failed to authenticate", err: ErrAuthenticationFailed}
+
+// WrapKey wraps key with kek using AES key wrap (RFC 3394), the format
+// HSMs, KMSes and JWE use to move keys around. key must be a multiple of 8
+// bytes and at least 16, see WrapKeyWithPadding for other sizes. the
+// result is 8 bytes longer than key.
+//
+// wrapping is deterministic and only meant for keys, which are random,
+// use Encrypt for anything else.
+func WrapKey(kek *[32]byte, key []byte) ([]byte, error) {
+	return aesKeyWrap(kek[:], key)
+}
+
+// UnwrapKey reverses WrapKey, failing with ErrAuthenticationFailed if
+// wrapped wasn't made with kek.
+func UnwrapKey(kek *[32]byte, wrapped []byte) ([]byte, error) {
+	return aesKeyUnwrap(kek[:], wrapped)
+}
+
+// WrapKeyWithPadding wraps key of any length from 1 to 2^32-1 bytes with
+// kek using AES key wrap with padding (RFC 5649).
+func WrapKeyWithPadding(kek *[32]byte, key []byte) ([]byte, error) {
+	return aesKeyWrapPad(kek[:], key)
+}
+
+// UnwrapKeyWithPadding reverses WrapKeyWithPadding.
+func UnwrapKeyWithPadding(kek *[32]byte, wrapped []byte) ([]byte, error) {
+	return aesKeyUnwrapPad(kek[:], wrapped)
+}
+
+// aesKeyWrap wraps key with kek using AES key wrap (RFC 3394). key must be
+// a multiple of 8 bytes and at least 16.
+func aesKeyWrap(kek, key []byte) ([]byte, error) {
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, errors.New("crypt: key wrap input must be a multiple of 8 bytes")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return keyWrap(block, keyWrapIV, key), nil
+}
+
+// aesKeyUnwrap reverses aesKeyWrap, failing if wrapped wasn't made with kek.
+func aesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, errors.New("crypt: invalid wrapped key")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	out := keyUnwrap(block, wrapped)
+	if subtle.ConstantTimeCompare(out[:8], keyWrapIV) != 1 {
+		return nil, errKeyUnwrap
+	}
+	return out[8:], nil
+}
+
+// aesKeyWrapPad wraps key with kek using AES key wrap with padding
+// (RFC 5649).
+func aesKeyWrapPad(kek, key []byte) ([]byte, error) {
+	if len(key) == 0 || uint64(len(key)) > 1<<32-1 {
+		return nil, errors.New("crypt: key wrap input must be 1 to 2^32-1 bytes")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := binary.BigEndian.AppendUint32(append([]byte{}, keyWrapPadIV...), uint32(len(key)))
+	padded := make([]byte, (len(key)+7)/8*8)
+	copy(padded, key)
+	if len(padded) == 8 {
+		// a single block is encrypted as is
+		out := append(iv, padded...)
+		block.Encrypt(out, out)
+		return out, nil
+	}
+	return keyWrap(block, iv, padded), nil
+}
+
+// aesKeyUnwrapPad reverses aesKeyWrapPad.
+func aesKeyUnwrapPad(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 16 || len(wrapped)%8 != 0 {
+		return nil, errors.New("crypt: invalid wrapped key")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	if len(wrapped) == 16 {
+		out = make([]byte, 16)
+		block.Decrypt(out, wrapped)
+	} else {
+		out = keyUnwrap(block, wrapped)
+	}
+
+	// the length has to fall in the last 8 bytes and the padding be zeros
+	n := int(binary.BigEndian.Uint32(out[4:8]))
+	padded := len(out) - 8
+	if subtle.ConstantTimeCompare(out[:4], keyWrapPadIV) != 1 || n > padded || n <= padded-8 {
+		return nil, errKeyUnwrap
+	}
+	var pad byte
+	for _, b := range out[8+n:] {
+		pad |= b
+	}
+	if pad != 0 {
+		return nil, errKeyUnwrap
+	}
+	return out[8 : 8+n], nil
+}
+
+// keyWrap runs the wrapping process of RFC 3394 over key with the initial
+// value iv.
+func keyWrap(block cipher.Block, iv, key []byte) []byte {
+	n := len(key) / 8
+	out := make([]byte, 8+len(key))
+	copy(out, iv)
+	copy(out[8:], key)
+
+	var b [16]byte
+	for j := range 6 {
+		for i := 1; i <= n; i++ {
+			copy(b[:8], out[:8])
+			copy(b[8:], out[i*8:])
+			block.Encrypt(b[:], b[:])
+
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(out[:8], binary.BigEndian.Uint64(b[:8])^t)
+			copy(out[i*8:], b[8:])
+		}
+	}
+
+	return out
+}
+
+// keyUnwrap reverses keyWrap, the initial value is left in the first 8
+// bytes of the result for the caller to check.
+func keyUnwrap(block cipher.Block, wrapped []byte) []byte {
+	n := len(wrapped)/8 - 1
+	out := make([]byte, len(wrapped))
+	copy(out, wrapped)
+
+	var b [16]byte
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(out[:8])^t)
+			copy(b[8:], out[i*8:])
+			block.Decrypt(b[:], b[:])
+
+			copy(out[:8], b[:8])
+			copy(out[i*8:], b[8:])
+		}
+	}
+
+	return out
+}
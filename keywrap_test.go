@@ -0,0 +1,97 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// TestAESKeyWrap checks the 256 bit key, 256 bit data vector from RFC 3394
+// section 4.6.
+func TestAESKeyWrap(t *testing.T) {
+	t.Parallel()
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F")
+	key, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F")
+	want, _ := hex.DecodeString("28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21")
+
+	got, err := aesKeyWrap(kek, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %x", got)
+	}
+
+	unwrapped, err := aesKeyUnwrap(kek, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped, key) {
+		t.Fatal("key did not round trip")
+	}
+
+	got[10] ^= 1
+	if _, err := aesKeyUnwrap(kek, got); err == nil {
+		t.Fatal("tampered key unwrapped")
+	}
+}
+
+// TestAESKeyWrapPad checks the vectors from RFC 5649 section 6.
+func TestAESKeyWrapPad(t *testing.T) {
+	t.Parallel()
+	kek, _ := hex.DecodeString("5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8")
+	for _, v := range []struct{ key, want string }{
+		{"c37b7e6492584340bed12207808941155068f738", "138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a"},
+		{"466f7250617369", "afbeb0f07dfbf5419200f2ccb50bb24f"},
+	} {
+		key, _ := hex.DecodeString(v.key)
+		want, _ := hex.DecodeString(v.want)
+		got, err := aesKeyWrapPad(kek, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("got %x", got)
+		}
+		unwrapped, err := aesKeyUnwrapPad(kek, got)
+		if err != nil || !bytes.Equal(unwrapped, key) {
+			t.Fatalf("unwrap: %x, %v", unwrapped, err)
+		}
+	}
+}
+
+func TestWrapKey(t *testing.T) {
+	t.Parallel()
+	kek, dek := randKey(), randKey()
+	wrapped, err := WrapKey(kek, dek[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnwrapKey(kek, wrapped)
+	if err != nil || !bytes.Equal(got, dek[:]) {
+		t.Fatalf("UnwrapKey: %v", err)
+	}
+	if _, err := UnwrapKey(randKey(), wrapped); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("wrong kek: %v", err)
+	}
+	if _, err := WrapKey(kek, []byte("short")); err == nil {
+		t.Fatal("unpadded wrap of 5 bytes")
+	}
+
+	for _, n := range []int{1, 8, 9, 16, 33} {
+		key := randBytes(n)
+		wrapped, err := WrapKeyWithPadding(kek, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := UnwrapKeyWithPadding(kek, wrapped)
+		if err != nil || !bytes.Equal(got, key) {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		// a padded wrap is never accepted as an unpadded one
+		if _, err := UnwrapKey(kek, wrapped); err == nil {
+			t.Fatalf("%d bytes: padded wrap unwrapped without padding", n)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// KeyProvider generates and unwraps data keys for envelope encryption,
+// usually a KMS such as AWS KMS, GCP Cloud KMS or Vault's transit engine
+// holding a key that never leaves it. see NewKMSWriter.
+type KeyProvider interface {
+	// GenerateDataKey returns a new random data key along with it wrapped
+	// by the provider
+	GenerateDataKey(ctx context.Context) (key *[32]byte, wrapped []byte, err error)
+
+	// Decrypt unwraps a data key returned by GenerateDataKey
+	Decrypt(ctx context.Context, wrapped []byte) (*[32]byte, error)
+}
+
+// NewKMSWriter is NewWriter with a new data key from p, which is stored in
+// the stream header wrapped by p. the stream can only be read by asking p
+// to unwrap it again, see NewKMSReader. WithContext is passed on to p.
+func NewKMSWriter(w io.Writer, p KeyProvider, opts ...Option) (*Writer, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, wrapped, err := p.GenerateDataKey(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) == 0 || len(wrapped) > math.MaxUint16 {
+		return nil, errors.New("crypt: key provider returned a wrapped key that doesn't fit in a header")
+	}
+
+	return newWriter(w, dataKey, c, map[byte][]byte{fieldKMS: wrapped})
+}
+
+// NewKMSReader is NewReader for streams made by NewKMSWriter, the data key
+// is unwrapped by p.
+func NewKMSReader(r io.Reader, p KeyProvider, opts ...Option) (*Reader, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	h, _, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.policy.check(h.cipher, h.version); err != nil {
+		return nil, err
+	}
+
+	wrapped, ok := h.fields[fieldKMS]
+	if !ok {
+		return nil, errBadHeader("stream has no wrapped data key")
+	}
+	dataKey, err := p.Decrypt(c.ctx, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	return newReader(r, dataKey, c, h)
+}
+
+// LocalKeyProvider is a KeyProvider wrapping data keys with a key
+// encryption key it holds, using AES key wrap. it is for tests and for
+// keeping a KEK outside the data it protects without running a KMS.
+type LocalKeyProvider struct {
+	KEK *[32]byte
+}
+
+// GenerateDataKey implements KeyProvider.
+func (p LocalKeyProvider) GenerateDataKey(context.Context) (*[32]byte, []byte, error) {
+	key := &[32]byte{}
+	if _, err := io.ReadFull(randReader, key[:]); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := WrapKey(p.KEK, key[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, wrapped, nil
+}
+
+// Decrypt implements KeyProvider.
+func (p LocalKeyProvider) Decrypt(_ context.Context, wrapped []byte) (*[32]byte, error) {
+	b, err := UnwrapKey(p.KEK, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 32 {
+		return nil, errors.New("crypt: wrapped data key is not 32 bytes")
+	}
+	return (*[32]byte)(b), nil
+}
+
+// VaultTransit is a KeyProvider using a key in HashiCorp Vault's transit
+// secrets engine (or OpenBao's), over its HTTP API. the token needs update
+// on the datakey and decrypt endpoints of the key.
+type VaultTransit struct {
+	// Address is where Vault is, such as https://vault.example.com:8200
+	Address string
+
+	// Token authenticates to Vault
+	Token string
+
+	// Mount is where the transit engine is mounted, "transit" if empty
+	Mount string
+
+	// Key is the name of the transit key
+	Key string
+
+	// Client makes the requests, http.DefaultClient if nil
+	Client *http.Client
+}
+
+// GenerateDataKey implements KeyProvider.
+func (v *VaultTransit) GenerateDataKey(ctx context.Context) (*[32]byte, []byte, error) {
+	var resp struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	}
+	if err := v.call(ctx, "datakey/plaintext", map[string]any{"bits": 256}, &resp); err != nil {
+		return nil, nil, err
+	}
+	key, err := vaultKey(resp.Plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, []byte(resp.Ciphertext), nil
+}
+
+// Decrypt implements KeyProvider.
+func (v *VaultTransit) Decrypt(ctx context.Context, wrapped []byte) (*[32]byte, error) {
+	var resp struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := v.call(ctx, "decrypt", map[string]any{"ciphertext": string(wrapped)}, &resp); err != nil {
+		return nil, err
+	}
+	return vaultKey(resp.Plaintext)
+}
+
+// call posts req to the transit endpoint op of the key, decoding the data
+// of the response into resp.
+func (v *VaultTransit) call(ctx context.Context, op string, req, resp any) error {
+	mount := v.Mount
+	if mount == "" {
+		mount = "transit"
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	u := strings.TrimSuffix(v.Address, "/") + "/v1/" + strings.Trim(mount, "/") + "/" + op + "/" + url.PathEscape(v.Key)
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("X-Vault-Token", v.Token)
+	r.Header.Set("Content-Type", "application/json")
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var out struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&out); err != nil && res.StatusCode == http.StatusOK {
+		return errors.New("crypt: decoding Vault response: " + err.Error())
+	}
+	if res.StatusCode != http.StatusOK {
+		msg := res.Status
+		if len(out.Errors) > 0 {
+			msg = strings.Join(out.Errors, "; ")
+		}
+		return errors.New("crypt: Vault transit " + op + ": " + msg)
+	}
+	return json.Unmarshal(out.Data, resp)
+}
+
+// vaultKey decodes a base64 data key from Vault.
+func vaultKey(plaintext string) (*[32]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(plaintext)
+	if err != nil || len(b) != 32 {
+		return nil, errors.New("crypt: Vault returned an invalid data key")
+	}
+	return (*[32]byte)(b), nil
+}
+
+var (
+	_ KeyProvider = LocalKeyProvider{}
+	_ KeyProvider = (*VaultTransit)(nil)
+)
@@ -0,0 +1,106 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestKMSWriter(t *testing.T) {
+	p := LocalKeyProvider{KEK: randKey()}
+	data := randBytes(1000)
+
+	var buf bytes.Buffer
+	w, err := NewKMSWriter(&buf, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := Inspect(bytes.NewReader(buf.Bytes()))
+	if err != nil || len(info.WrappedKey) != 40 {
+		t.Fatalf("Inspect: %+v, %v", info, err)
+	}
+
+	r, err := NewKMSReader(bytes.NewReader(buf.Bytes()), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read: %v", err)
+	}
+
+	if _, err := NewKMSReader(bytes.NewReader(buf.Bytes()), LocalKeyProvider{KEK: randKey()}); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("wrong KEK: %v", err)
+	}
+}
+
+// fakeVault serves the transit datakey and decrypt endpoints for key,
+// "wrapping" data keys by base64 encoding them.
+func fakeVault(t *testing.T, key string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+		var req map[string]any
+		json.NewDecoder(r.Body).Decode(&req)
+		var data map[string]string
+		switch r.URL.Path {
+		case "/v1/transit/datakey/plaintext/" + key:
+			dk := base64.StdEncoding.EncodeToString(randBytes(32))
+			data = map[string]string{"plaintext": dk, "ciphertext": "vault:v1:" + dk}
+		case "/v1/transit/decrypt/" + key:
+			ct, _ := req["ciphertext"].(string)
+			data = map[string]string{"plaintext": strings.TrimPrefix(ct, "vault:v1:")}
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}))
+}
+
+func TestVaultTransit(t *testing.T) {
+	srv := fakeVault(t, "backups")
+	defer srv.Close()
+	v := &VaultTransit{Address: srv.URL, Token: "s.token", Key: "backups"}
+
+	var buf bytes.Buffer
+	w, err := NewKMSWriter(&buf, v, WithContext(context.Background()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("hello"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("vault:v1:")) {
+		t.Fatal("wrapped key not in the header")
+	}
+
+	r, err := NewKMSReader(bytes.NewReader(buf.Bytes()), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || string(got) != "hello" {
+		t.Fatalf("read: %q, %v", got, err)
+	}
+
+	bad := &VaultTransit{Address: srv.URL, Token: "wrong", Key: "backups"}
+	if _, err := NewKMSReader(bytes.NewReader(buf.Bytes()), bad); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("bad token: %v", err)
+	}
+}
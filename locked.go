@@ -0,0 +1,50 @@
+package crypt
+
+import "runtime"
+
+// LockedKey is a key in memory of its own, locked so it is never swapped to
+// disk and on linux left out of core dumps. the garbage collector never
+// moves or copies it, so Destroy leaves no trace of it in the process.
+// long running servers can use it to bound how long a key lingers.
+//
+// it is only supported on unix, where the amount of memory a process may
+// lock is limited by RLIMIT_MEMLOCK.
+type LockedKey struct {
+	mem     []byte
+	cleanup runtime.Cleanup
+}
+
+// NewLockedKey returns a zeroed LockedKey, for the key to be read or copied
+// into with Key. a copy passed in has to be wiped separately.
+func NewLockedKey() (*LockedKey, error) {
+	mem, err := lockedAlloc(32)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &LockedKey{mem: mem}
+	k.cleanup = runtime.AddCleanup(k, func(mem []byte) { lockedFree(mem) }, mem)
+	return k, nil
+}
+
+// Key returns the key, valid until Destroy. it is nil afterwards.
+func (k *LockedKey) Key() *[32]byte {
+	if k.mem == nil {
+		return nil
+	}
+	return (*[32]byte)(k.mem[:32])
+}
+
+// Destroy zeroes the key and unlocks and frees its memory. anything still
+// using the pointer returned by Key will crash, calling it again does
+// nothing. a LockedKey that is never destroyed is freed by the garbage
+// collector once unreachable.
+func (k *LockedKey) Destroy() error {
+	if k.mem == nil {
+		return nil
+	}
+	k.cleanup.Stop()
+	mem := k.mem
+	k.mem = nil
+	return lockedFree(mem)
+}
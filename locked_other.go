@@ -0,0 +1,15 @@
+//go:build !unix
+
+package crypt
+
+import "errors"
+
+// lockedAlloc fails, memory can't be locked on this platform.
+func lockedAlloc(n int) ([]byte, error) {
+	return nil, errors.New("crypt: locked memory is not supported on this platform")
+}
+
+// lockedFree does nothing since lockedAlloc never succeeds.
+func lockedFree(mem []byte) error {
+	return nil
+}
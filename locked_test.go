@@ -0,0 +1,96 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+)
+
+func TestLockedKey(t *testing.T) {
+	k, err := NewLockedKey()
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		if err == nil {
+			t.Fatal("locked memory on an unsupported platform")
+		}
+		return
+	}
+	if err != nil {
+		t.Skipf("can't lock memory here: %v", err)
+	}
+	copy(k.Key()[:], randBytes(32))
+
+	data := randBytes(100)
+	ct, err := Encrypt(data, k.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Decrypt(ct, k.Key()); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("round trip: %v", err)
+	}
+
+	if err := k.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if k.Key() != nil {
+		t.Fatal("Key returned after Destroy")
+	}
+	if err := k.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWipe(t *testing.T) {
+	key := randKey()
+	k := Key(*key)
+	k.Wipe()
+	if k != (Key{}) {
+		t.Fatal("key not zeroed")
+	}
+
+	// a wiped writer leaves a truncated stream behind
+	var ct bytes.Buffer
+	w, err := NewWriter(&ct, key, WithChunkSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(randBytes(3000))
+	buf := w.buf
+	w.Wipe()
+	if !bytes.Equal(buf, make([]byte, len(buf))) {
+		t.Fatal("writer buffer not zeroed")
+	}
+	if _, err := w.Write([]byte("more")); err == nil {
+		t.Fatal("write after Wipe")
+	}
+	r, err := NewReader(bytes.NewReader(ct.Bytes()), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("wiped stream: %v", err)
+	}
+
+	// a wiped reader zeroes what it hasn't returned yet
+	data := randBytes(3000)
+	ct.Reset()
+	w, _ = NewWriter(&ct, key, WithChunkSize(1024))
+	w.Write(data)
+	w.Close()
+	r, err = NewReader(bytes.NewReader(ct.Bytes()), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(r, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	rest := r.plain
+	r.Wipe()
+	if len(rest) == 0 || !bytes.Equal(rest, make([]byte, len(rest))) {
+		t.Fatal("reader plaintext not zeroed")
+	}
+	if _, err := r.Read(make([]byte, 10)); err == nil {
+		t.Fatal("read after Wipe")
+	}
+}
@@ -0,0 +1,38 @@
+//go:build unix
+
+package crypt
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockedAlloc maps at least n bytes of memory of its own and locks it.
+func lockedAlloc(n int) ([]byte, error) {
+	size := (n + os.Getpagesize() - 1) &^ (os.Getpagesize() - 1)
+	mem, err := unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Mlock(mem); err != nil {
+		unix.Munmap(mem)
+		return nil, &detailError{msg: "crypt: locking memory: " + err.Error(), err: err}
+	}
+	if err := dontDump(mem); err != nil {
+		lockedFree(mem)
+		return nil, err
+	}
+
+	return mem[:n], nil
+}
+
+// lockedFree zeroes memory from lockedAlloc and frees it.
+func lockedFree(mem []byte) error {
+	mem = mem[:cap(mem)]
+	clear(mem)
+	if err := unix.Munlock(mem); err != nil {
+		return err
+	}
+	return unix.Munmap(mem)
+}
@@ -0,0 +1,166 @@
+package crypt
+
+import (
+	"encoding/binary"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Metadata describes the plaintext of a stream, stored in the header where
+// anyone can read it but authenticated with every chunk so it can't be
+// changed. see WithMetadata.
+type Metadata struct {
+	// Name is the original file name
+	Name string
+
+	// ModTime is the modification time of the original file, stored to the
+	// nanosecond
+	ModTime time.Time
+
+	// ContentType is the MIME type of the plaintext
+	ContentType string
+
+	// Extra holds anything else, keys can't be empty or one of the names
+	// used for the fields above
+	Extra map[string]string
+}
+
+// metadata keys for the named fields, the rest of the keys come from Extra.
+const (
+	metaName        = "name"
+	metaModTime     = "mtime"
+	metaContentType = "content-type"
+
+	// maxMetadataSize bounds the encoded metadata, the whole header has to
+	// fit in 64 KiB
+	maxMetadataSize = 16 << 10
+)
+
+// WithMetadata stores m in the header of streams written by NewWriter and
+// the other stream writers. it is readable without the key, don't put
+// anything secret in it. Reader.Metadata returns it.
+func WithMetadata(m Metadata) Option {
+	return func(c *config) error {
+		b, err := m.marshal()
+		if err != nil {
+			return err
+		}
+
+		c.metadata = b
+		return nil
+	}
+}
+
+// Metadata returns the metadata stored with WithMetadata, nil if there is
+// none. it is only authentic once a chunk has been read, Read makes sure of
+// that before returning anything.
+func (r *Reader) Metadata() *Metadata {
+	return r.metadata
+}
+
+// Metadata returns the metadata stored with WithMetadata, nil if there is
+// none. the final chunk was authenticated by NewReaderAt, so it is
+// authentic.
+func (r *ReaderAt) Metadata() *Metadata {
+	return r.metadata
+}
+
+// marshal encodes m as a count followed by key length (1 byte), key, value
+// length (big endian uint16), value entries sorted by key.
+func (m *Metadata) marshal() ([]byte, error) {
+	kv := make(map[string]string, len(m.Extra)+3)
+	for k, v := range m.Extra {
+		if k == "" || k == metaName || k == metaModTime || k == metaContentType {
+			return nil, errors.New("crypt: invalid metadata key " + strconv.Quote(k))
+		}
+		kv[k] = v
+	}
+	if m.Name != "" {
+		kv[metaName] = m.Name
+	}
+	if !m.ModTime.IsZero() {
+		kv[metaModTime] = strconv.FormatInt(m.ModTime.UnixNano(), 10)
+	}
+	if m.ContentType != "" {
+		kv[metaContentType] = m.ContentType
+	}
+
+	b := binary.BigEndian.AppendUint16(nil, uint16(len(kv)))
+	for _, k := range slices.Sorted(maps.Keys(kv)) {
+		if len(k) > 255 {
+			return nil, errors.New("crypt: metadata key is longer than 255 bytes")
+		}
+		b = append(b, byte(len(k)))
+		b = append(b, k...)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(kv[k])))
+		b = append(b, kv[k]...)
+		if len(b) > maxMetadataSize {
+			return nil, errors.New("crypt: metadata is larger than 16 KiB")
+		}
+	}
+
+	return b, nil
+}
+
+// metadata returns the metadata in a header, nil if there is none.
+func (h *header) metadata() (*Metadata, error) {
+	b, ok := h.fields[fieldMetadata]
+	if !ok {
+		return nil, nil
+	}
+
+	invalid := errBadHeader("invalid metadata field")
+	if len(b) < 2 {
+		return nil, invalid
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+
+	m := &Metadata{}
+	last := ""
+	for i := range n {
+		if len(b) < 1 || len(b) < 1+int(b[0])+2 {
+			return nil, invalid
+		}
+		k := string(b[1 : 1+b[0]])
+		b = b[1+len(k):]
+		l := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+l {
+			return nil, invalid
+		}
+		v := string(b[2 : 2+l])
+		b = b[2+l:]
+
+		// sorted and unique, so there is only one encoding
+		if k == "" || (i > 0 && k <= last) {
+			return nil, invalid
+		}
+		last = k
+
+		switch k {
+		case metaName:
+			m.Name = v
+		case metaContentType:
+			m.ContentType = v
+		case metaModTime:
+			ns, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, invalid
+			}
+			m.ModTime = time.Unix(0, ns)
+		default:
+			if m.Extra == nil {
+				m.Extra = make(map[string]string)
+			}
+			m.Extra[k] = v
+		}
+	}
+	if len(b) != 0 {
+		return nil, invalid
+	}
+
+	return m, nil
+}
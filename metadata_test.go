@@ -0,0 +1,95 @@
+package crypt
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMetadata round trips metadata through a stream and makes sure
+// changing it is caught.
+func TestMetadata(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	m := Metadata{
+		Name:        "report.pdf",
+		ModTime:     time.Unix(1700000000, 123456789),
+		ContentType: "application/pdf",
+		Extra:       map[string]string{"owner": "alice", "empty": ""},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key, WithMetadata(m), WithChunkSize(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(randBytes(40))
+	w.Close()
+	stream := buf.Bytes()
+
+	r, err := NewReader(bytes.NewReader(stream), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := r.Metadata()
+	if got == nil || got.Name != m.Name || !got.ModTime.Equal(m.ModTime) || got.ContentType != m.ContentType ||
+		len(got.Extra) != 2 || got.Extra["owner"] != "alice" {
+		t.Fatalf("got %+v", got)
+	}
+	ra, err := NewReaderAt(bytes.NewReader(stream), int64(len(stream)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ra.Metadata().Name != m.Name {
+		t.Fatalf("ReaderAt got %+v", ra.Metadata())
+	}
+
+	// same length name, so only authentication can catch it
+	changed := bytes.Replace(stream, []byte("report.pdf"), []byte("REPORT.pdf"), 1)
+	r, err = NewReader(bytes.NewReader(changed), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("changed metadata went unnoticed")
+	}
+
+	r, _ = NewReader(bytes.NewReader(sealStream(t, key, nil, 16)), key)
+	if r.Metadata() != nil {
+		t.Fatal("stream without metadata has some")
+	}
+}
+
+// TestMetadataInvalid makes sure bad metadata is refused when writing and
+// reading.
+func TestMetadataInvalid(t *testing.T) {
+	t.Parallel()
+	bad := []Metadata{
+		{Extra: map[string]string{"": "x"}},
+		{Extra: map[string]string{"name": "x"}},
+		{Extra: map[string]string{strings.Repeat("k", 256): "x"}},
+		{Name: strings.Repeat("n", maxMetadataSize)},
+	}
+	for _, m := range bad {
+		if _, err := NewWriter(io.Discard, randKey(), WithMetadata(m)); err == nil {
+			t.Fatalf("accepted %+v", m)
+		}
+	}
+
+	fields := [][]byte{
+		{0},
+		{0, 1, 1, 'a', 0},
+		{0, 2, 1, 'b', 0, 0, 1, 'a', 0, 0},
+		{0, 2, 1, 'a', 0, 0, 1, 'a', 0, 0},
+		{0, 1, 5, 'm', 't', 'i', 'm', 'e', 0, 1, 'x'},
+		{0, 0, 1},
+	}
+	for _, f := range fields {
+		h := &header{fields: map[byte][]byte{fieldMetadata: f}}
+		if _, err := h.metadata(); err == nil {
+			t.Fatalf("parsed %x", f)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package crypt
+
+import (
+	"io"
+	"os"
+)
+
+// WithMmap makes a Writer's ReadFrom, and so io.Copy into a Writer,
+// memory map a regular file it is given and seal chunks straight out of
+// the mapping, instead of reading the file through a buffer first. for
+// files larger than RAM this saves copying every byte, and the page cache
+// can drop pages as soon as they are sealed. the stream is the same as
+// without it. the file must not be truncated while it is read, on most
+// systems that kills the process. it does nothing on platforms without
+// mmap and for anything other than a regular *os.File.
+//
+// a Reader opens chunks in its own buffer since decryption can't happen
+// in place in a read only mapping, so there is nothing for it to gain and
+// it ignores this option.
+func WithMmap() Option {
+	return func(c *config) error {
+		c.mmap = true
+		return nil
+	}
+}
+
+// mappable is a file that can be mapped. io.Copy hands ReadFrom an
+// *os.File wrapped to hide its WriteTo method, so this matches both.
+type mappable interface {
+	io.Seeker
+	Fd() uintptr
+	Stat() (os.FileInfo, error)
+}
+
+// readFromMmap encrypts the rest of f from its mapping, ok is false if f
+// can't be mapped and has to be read normally. like ReadFrom the last
+// buffer of data is held back in case it is the final chunk, it is copied
+// into w.buf.
+func (w *Writer) readFromMmap(f mappable) (total int64, ok bool, err error) {
+	off, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false, nil
+	}
+	data, unmap, err := mapFile(f, off)
+	if err != nil || data == nil {
+		return 0, false, nil
+	}
+	defer unmap()
+	w.hash(data)
+
+	// top up a partly filled buffer first so chunks stay aligned
+	if w.n != 0 {
+		n := copy(w.buf[w.n:], data)
+		w.n += n
+		data = data[n:]
+		total += int64(n)
+		if len(data) != 0 {
+			if err := w.writeChunks(w.buf, false); err != nil {
+				w.err = err
+				return total, true, err
+			}
+			w.n = 0
+		}
+	}
+
+	for len(data) > len(w.buf) {
+		if err := w.writeChunks(data[:len(w.buf)], false); err != nil {
+			w.err = err
+			return total, true, err
+		}
+		data = data[len(w.buf):]
+		total += int64(len(w.buf))
+	}
+	if len(data) != 0 {
+		w.n = copy(w.buf, data)
+		total += int64(w.n)
+	}
+
+	// leave f where reading it would have
+	if _, err := f.Seek(off+total, io.SeekStart); err != nil {
+		return total, true, err
+	}
+	return total, true, nil
+}
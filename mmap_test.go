@@ -0,0 +1,75 @@
+package crypt
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithMmap(t *testing.T) {
+	key := randKey()
+	seed := randBytes(1 << 20)
+	plain := randBytes(300_000)
+	path := filepath.Join(t.TempDir(), "big")
+	if err := os.WriteFile(path, plain, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// encrypt writes prefix, then the file from off with io.Copy
+	encrypt := func(prefix []byte, off int64, opts ...Option) []byte {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+
+		var ct bytes.Buffer
+		opts = append(opts, WithEntropySource(bytes.NewReader(seed)), WithChunkSize(1000), WithConcurrency(4))
+		w, err := NewWriter(&ct, key, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(prefix)
+		if n, err := io.Copy(w, f); err != nil || n != int64(len(plain))-off {
+			t.Fatalf("copied %d, %v", n, err)
+		}
+		if pos, _ := f.Seek(0, io.SeekCurrent); pos != int64(len(plain)) {
+			t.Fatalf("file left at %d", pos)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return ct.Bytes()
+	}
+
+	for _, tc := range []struct {
+		prefix []byte
+		off    int64
+	}{
+		{nil, 0},
+		{[]byte("abc"), 0},
+		{nil, 12345},
+		{randBytes(4000), 299_999},
+		{nil, 300_000},
+	} {
+		want := encrypt(tc.prefix, tc.off)
+		got := encrypt(tc.prefix, tc.off, WithMmap())
+		if !bytes.Equal(got, want) {
+			t.Fatalf("prefix %d off %d: mapped stream differs", len(tc.prefix), tc.off)
+		}
+
+		r, err := NewReader(bytes.NewReader(got), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pt, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(pt, append(bytes.Clone(tc.prefix), plain[tc.off:]...)) {
+			t.Fatalf("prefix %d off %d: round trip failed: %v", len(tc.prefix), tc.off, err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package crypt
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// mapFile maps f read only from off to its end, returning nil if there is
+// nothing to map or f is not a regular file.
+func mapFile(f mappable, off int64) ([]byte, func() error, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !st.Mode().IsRegular() || off >= st.Size() || st.Size()-off > int64(^uint(0)>>1) {
+		return nil, nil, nil
+	}
+
+	// the mapping has to start on a page boundary
+	start := off &^ int64(os.Getpagesize()-1)
+	m, err := unix.Mmap(int(f.Fd()), start, int(st.Size()-start), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	unix.Madvise(m, unix.MADV_SEQUENTIAL)
+
+	return m[off-start:], func() error { return unix.Munmap(m) }, nil
+}
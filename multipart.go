@@ -0,0 +1,264 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strconv"
+)
+
+// UploadPartFunc uploads part number n of an object, numbered from 1 like
+// S3 multipart uploads, returning its ETag or whatever else the store
+// needs to complete the upload. data isn't used by the MultipartWriter
+// after the call, it can be kept.
+type UploadPartFunc func(n int, data []byte) (etag string, err error)
+
+// Manifest records the parts of a multipart upload, for completing it and
+// for resuming it with ResumeMultipartWriter. it marshals to JSON.
+type Manifest struct {
+	// Header is the stream header, which is not secret
+	Header []byte `json:"header"`
+
+	// PartSize is the part size the upload was started with
+	PartSize int `json:"partSize"`
+
+	// Parts are the parts uploaded so far, in order
+	Parts []ManifestPart `json:"parts"`
+
+	// Complete is set once the final part has been uploaded
+	Complete bool `json:"complete"`
+}
+
+// ManifestPart is one uploaded part, Size is the length of the part and
+// PlaintextSize how much of the input it holds.
+type ManifestPart struct {
+	Number        int    `json:"number"`
+	Size          int    `json:"size"`
+	PlaintextSize int64  `json:"plaintextSize"`
+	ETag          string `json:"etag"`
+}
+
+// Offset returns how much of the input the uploaded parts hold, where
+// input given to a resumed MultipartWriter picks up from.
+func (m *Manifest) Offset() int64 {
+	var n int64
+	for _, p := range m.Parts {
+		n += p.PlaintextSize
+	}
+	return n
+}
+
+// MultipartWriter encrypts a stream like NewWriter, cutting it into parts
+// that are handed to an UploadPartFunc as soon as they are full, so objects
+// can be backed up to S3 and the like without buffering them whole. the
+// parts joined in order are the stream, readable with NewReader.
+//
+// parts hold whole chunks, partSize is rounded down to a multiple of the
+// chunk size plus overhead and the first part holds the header as well.
+// all parts but the last are the same size. stores with a minimum part size
+// such as S3's 5 MiB need partSize at least a chunk over it.
+//
+// a part that fails to upload is kept and tried again on the next Write or
+// Close, the error is returned but doesn't end the stream. after a crash
+// the upload can be resumed from a saved Manifest.
+type MultipartWriter struct {
+	w        *Writer
+	parts    *partBuffer
+	upload   UploadPartFunc
+	manifest Manifest
+	closed   bool
+}
+
+// NewMultipartWriter returns a MultipartWriter encrypting with key into
+// parts of about partSize bytes. options apply as for NewWriter, except
+// WithArchive and WithDetachedTags which don't cut into parts and WithDryRun
+// which has nothing to upload.
+func NewMultipartWriter(key *[32]byte, upload UploadPartFunc, partSize int, opts ...Option) (*MultipartWriter, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if c.archive || c.tagsOut != nil || c.dryRun {
+		return nil, errors.New("crypt: multipart uploads can't be used with the archive profile, detached tags or a dry run")
+	}
+
+	parts := &partBuffer{}
+	w, err := NewWriter(parts, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newMultipartWriter(w, parts, upload, partSize, Manifest{Header: bytes.Clone(parts.buf), PartSize: partSize})
+}
+
+// ResumeMultipartWriter carries on the upload recorded in m after its last
+// part, its input has to start at m.Offset() of the original input. key
+// and options have to be the ones the upload was started with. uploads of
+// signed streams and ones using counter nonces can't be resumed, the
+// signature covers all of the input and counter nonces would be used again
+// for what may be different data.
+func ResumeMultipartWriter(m *Manifest, key *[32]byte, upload UploadPartFunc, opts ...Option) (*MultipartWriter, error) {
+	if m.Complete {
+		return nil, errors.New("crypt: multipart upload is already complete")
+	}
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	h, raw, err := readHeader(bytes.NewReader(m.Header))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != len(m.Header) {
+		return nil, errBadHeader("trailing data after the header in the manifest")
+	}
+	if err := c.policy.check(h.cipher, h.version); err != nil {
+		return nil, err
+	}
+	for _, f := range []byte{fieldSigner, fieldNoncePrefix, fieldParity, fieldDetached} {
+		if _, ok := h.fields[f]; ok {
+			return nil, errors.New("crypt: multipart upload of this stream can't be resumed")
+		}
+	}
+	aead, err := streamAEAD(h, key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.chunkSize = h.chunkSize
+	parts := &partBuffer{}
+	w := writerFor(parts, aead, c, h)
+	offset := m.Offset()
+	if offset%int64(h.chunkSize) != 0 {
+		return nil, errors.New("crypt: manifest parts don't end on a chunk")
+	}
+	w.seq = uint64(offset / int64(h.chunkSize))
+
+	manifest := *m
+	manifest.Parts = slices.Clone(m.Parts)
+	return newMultipartWriter(w, parts, upload, m.PartSize, manifest)
+}
+
+func newMultipartWriter(w *Writer, parts *partBuffer, upload UploadPartFunc, partSize int, m Manifest) (*MultipartWriter, error) {
+	frame := w.ChunkOverhead() + w.chunkSize
+	if partSize < frame {
+		return nil, errors.New("crypt: part size " + strconv.Itoa(partSize) + " is smaller than a chunk")
+	}
+	parts.frame = frame
+	parts.overhead = w.ChunkOverhead()
+	parts.perPart = partSize / frame
+	parts.limit = parts.perPart * frame
+	if len(m.Parts) == 0 {
+		parts.limit += len(m.Header)
+	}
+	parts.header = len(m.Header)
+	parts.first = len(m.Parts) == 0
+
+	return &MultipartWriter{w: w, parts: parts, upload: upload, manifest: m}, nil
+}
+
+// Write encrypts p, uploading any parts it fills. an upload error is
+// returned with all of p taken, the part is uploaded again by the next
+// Write or Close.
+func (m *MultipartWriter) Write(p []byte) (int, error) {
+	if m.closed {
+		return 0, ErrClosed
+	}
+	if err := m.flush(); err != nil {
+		return 0, err
+	}
+	n, err := m.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, m.flush()
+}
+
+// Close ends the stream and uploads the remaining parts. if an upload
+// fails Close can be called again to retry it.
+func (m *MultipartWriter) Close() error {
+	if !m.closed {
+		if err := m.flush(); err != nil {
+			return err
+		}
+		if err := m.w.Close(); err != nil {
+			return err
+		}
+		m.closed = true
+		m.parts.cut(true)
+	}
+	if err := m.flush(); err != nil {
+		return err
+	}
+	m.manifest.Complete = true
+	return nil
+}
+
+// Manifest returns a copy of the manifest of the parts uploaded so far, to
+// be saved after each Write for resuming, or used to complete the upload
+// once Close has returned nil.
+func (m *MultipartWriter) Manifest() *Manifest {
+	c := m.manifest
+	c.Parts = slices.Clone(c.Parts)
+	return &c
+}
+
+// flush uploads the parts that are ready, stopping at the first error.
+func (m *MultipartWriter) flush() error {
+	for len(m.parts.ready) > 0 {
+		p := m.parts.ready[0]
+		n := len(m.manifest.Parts) + 1
+		etag, err := m.upload(n, p.data)
+		if err != nil {
+			return &detailError{msg: "crypt: uploading part " + strconv.Itoa(n) + ": " + err.Error(), err: err}
+		}
+		m.manifest.Parts = append(m.manifest.Parts, ManifestPart{Number: n, Size: len(p.data), PlaintextSize: p.plain, ETag: etag})
+		m.parts.ready = m.parts.ready[1:]
+	}
+	return nil
+}
+
+// partBuffer is what a MultipartWriter's Writer writes to, it collects the
+// stream and cuts it into parts of whole chunks.
+type partBuffer struct {
+	buf   []byte
+	ready []part
+
+	// frame is the size of a sealed chunk, overhead what sealing adds to
+	// it and perPart how many make a part. limit is the size of the
+	// current part, which for the first includes the header bytes
+	frame    int
+	overhead int
+	perPart  int
+	limit    int
+	header   int
+	first    bool
+}
+
+// part is a part ready to upload and the length of its plaintext.
+type part struct {
+	data  []byte
+	plain int64
+}
+
+func (b *partBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	b.cut(false)
+	return len(p), nil
+}
+
+// cut moves full parts from buf to ready, and what is left too if final.
+func (b *partBuffer) cut(final bool) {
+	for b.limit > 0 && (len(b.buf) >= b.limit || final && len(b.buf) > 0) {
+		n := min(b.limit, len(b.buf))
+		sealed := n
+		if b.first {
+			sealed -= b.header
+		}
+		frames := (sealed + b.frame - 1) / b.frame
+		b.ready = append(b.ready, part{data: bytes.Clone(b.buf[:n]), plain: int64(sealed - frames*b.overhead)})
+
+		b.buf = slices.Delete(b.buf, 0, n)
+		b.first = false
+		b.limit = b.perPart * b.frame
+	}
+}
@@ -0,0 +1,137 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+)
+
+// partStore is an object store taking parts, failing the uploads in fail.
+type partStore struct {
+	parts map[int][]byte
+	fail  map[int]int
+}
+
+func (s *partStore) upload(n int, data []byte) (string, error) {
+	if s.fail[n] > 0 {
+		s.fail[n]--
+		return "", errors.New("connection reset")
+	}
+	s.parts[n] = data
+	return "etag-" + strconv.Itoa(n), nil
+}
+
+// object joins the parts listed in m.
+func (s *partStore) object(t *testing.T, m *Manifest) []byte {
+	var b []byte
+	for i, p := range m.Parts {
+		if p.Number != i+1 || len(s.parts[p.Number]) != p.Size {
+			t.Fatalf("part %d: %+v", i+1, p)
+		}
+		b = append(b, s.parts[p.Number]...)
+	}
+	return b
+}
+
+func TestMultipartWriter(t *testing.T) {
+	key := randKey()
+	plain := randBytes(10*1024 + 7)
+	store := &partStore{parts: map[int][]byte{}, fail: map[int]int{2: 1}}
+
+	w, err := NewMultipartWriter(key, store.upload, 3000, WithChunkSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed bool
+	for p := plain; len(p) > 0; p = p[min(len(p), 500):] {
+		if _, err := w.Write(p[:min(len(p), 500)]); err != nil {
+			failed = true
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !failed {
+		t.Fatal("failed upload not reported")
+	}
+
+	m := w.Manifest()
+	if !m.Complete || len(m.Parts) < 4 {
+		t.Fatalf("manifest: %+v", m)
+	}
+	for _, p := range m.Parts[1 : len(m.Parts)-1] {
+		if p.Size != m.Parts[1].Size || p.PlaintextSize != 2*1024 {
+			t.Fatalf("uneven part: %+v", p)
+		}
+	}
+	if m.Offset() != int64(len(plain)) {
+		t.Fatalf("Offset = %d", m.Offset())
+	}
+
+	r, err := NewReader(bytes.NewReader(store.object(t, m)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("decrypt: %v", err)
+	}
+}
+
+func TestResumeMultipartWriter(t *testing.T) {
+	key := randKey()
+	plain := randBytes(20 * 1024)
+	store := &partStore{parts: map[int][]byte{}, fail: map[int]int{3: 100}}
+
+	w, err := NewMultipartWriter(key, store.upload, 4200, WithChunkSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err == nil {
+		t.Fatal("failed upload not reported")
+	}
+
+	// the process dies, the manifest it saved is all that is left
+	saved, err := json.Marshal(w.Manifest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(saved, &m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Parts) != 2 || m.Offset() != 6*1024 {
+		t.Fatalf("manifest: %+v", m)
+	}
+
+	store.fail = nil
+	w, err = ResumeMultipartWriter(&m, key, store.upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain[m.Offset():]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(store.object(t, w.Manifest())), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("decrypt: %v", err)
+	}
+
+	if _, err := ResumeMultipartWriter(w.Manifest(), key, store.upload); err == nil {
+		t.Fatal("completed upload resumed")
+	}
+	if _, err := NewMultipartWriter(key, store.upload, 100, WithChunkSize(1024)); err == nil {
+		t.Fatal("part smaller than a chunk accepted")
+	}
+}
@@ -0,0 +1,197 @@
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// deterministicInfo is the HKDF info for the key deterministic nonces are
+// derived with, it must never be used to encrypt.
+const deterministicInfo = "crypt deterministic nonce v1\x00"
+
+// NDJSONCipher encrypts and decrypts selected fields of newline delimited
+// JSON, leaving the rest of each record readable. encrypted values are
+// replaced by a base64url string holding nonce|ciphertext|tag of the fields
+// original JSON, with the field path bound in as additional data so values
+// can't be moved between fields.
+//
+// fields listed as deterministic always encrypt the same value to the same
+// ciphertext so they can still be used as join keys. this leaks which records
+// share a value, only use it on fields that need it.
+type NDJSONCipher struct {
+	aead cipher.AEAD
+
+	// macKey derives nonces for deterministic fields
+	macKey []byte
+
+	// paths of the fields to encrypt, split on '.'
+	paths [][]string
+
+	deterministic map[string]bool
+}
+
+// NewNDJSONCipher returns a cipher for the given field paths, nested fields
+// are addressed with dots like "user.email". paths in deterministic are
+// encrypted deterministically and don't need repeating in fields.
+func NewNDJSONCipher(key *[32]byte, fields, deterministic []string) (*NDJSONCipher, error) {
+	aead, err := DefaultCipher.NewAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	macKey, err := deriveKey(key, deterministicInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &NDJSONCipher{
+		aead:          aead,
+		macKey:        macKey[:],
+		deterministic: make(map[string]bool),
+	}
+
+	for _, f := range fields {
+		c.paths = append(c.paths, strings.Split(f, "."))
+	}
+	for _, f := range deterministic {
+		c.deterministic[f] = true
+		c.paths = append(c.paths, strings.Split(f, "."))
+	}
+
+	return c, nil
+}
+
+// Encrypt reads records from src and writes them to dst with the configured
+// fields encrypted. records missing a field are passed through without it.
+// key order within changed objects is not preserved.
+func (c *NDJSONCipher) Encrypt(dst io.Writer, src io.Reader) error {
+	return c.process(dst, src, c.seal)
+}
+
+// Decrypt reverses Encrypt.
+func (c *NDJSONCipher) Decrypt(dst io.Writer, src io.Reader) error {
+	return c.process(dst, src, c.open)
+}
+
+// process applies fn to every configured field of every line in src.
+func (c *NDJSONCipher) process(dst io.Writer, src io.Reader, fn func(path string, v json.RawMessage) (json.RawMessage, error)) error {
+	br := bufio.NewReader(src)
+	bw := bufio.NewWriter(dst)
+
+	for lineNo := 1; ; lineNo++ {
+		line, err := br.ReadBytes('\n')
+		if len(line) != 0 {
+			out, perr := c.processLine(bytes.TrimSpace(line), fn)
+			if perr != nil {
+				return errors.New("line " + strconv.Itoa(lineNo) + ": " + perr.Error())
+			}
+			bw.Write(out)
+			bw.WriteByte('\n')
+		}
+
+		if err == io.EOF {
+			return bw.Flush()
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// processLine applies fn to the configured fields of a single record.
+func (c *NDJSONCipher) processLine(line []byte, fn func(path string, v json.RawMessage) (json.RawMessage, error)) ([]byte, error) {
+	if len(line) == 0 {
+		return line, nil
+	}
+
+	record := json.RawMessage(line)
+	for _, p := range c.paths {
+		var err error
+		record, err = applyPath(record, p, strings.Join(p, "."), fn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return record, nil
+}
+
+// applyPath walks path inside the JSON object v and replaces the value at its
+// end with fn's result.
+func applyPath(v json.RawMessage, path []string, full string, fn func(path string, v json.RawMessage) (json.RawMessage, error)) (json.RawMessage, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(v, &obj); err != nil || obj == nil {
+		// not an object, so the path doesn't exist here
+		return v, nil
+	}
+
+	field, ok := obj[path[0]]
+	if !ok {
+		return v, nil
+	}
+
+	var err error
+	if len(path) == 1 {
+		field, err = fn(full, field)
+	} else {
+		field, err = applyPath(field, path[1:], full, fn)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	obj[path[0]] = field
+	return json.Marshal(obj)
+}
+
+// seal encrypts the JSON value v of the field at path.
+func (c *NDJSONCipher) seal(path string, v json.RawMessage) (json.RawMessage, error) {
+	var plain bytes.Buffer
+	if err := json.Compact(&plain, v); err != nil {
+		return nil, err
+	}
+
+	var nonce []byte
+	if c.deterministic[path] {
+		mac := hmac.New(sha256.New, c.macKey)
+		mac.Write([]byte(path))
+		mac.Write([]byte{0})
+		mac.Write(plain.Bytes())
+		nonce = mac.Sum(nil)[:c.aead.NonceSize()]
+	} else {
+		var err error
+		if nonce, err = newNonce(randReader, c.aead.NonceSize()); err != nil {
+			return nil, err
+		}
+	}
+
+	sealed := c.aead.Seal(nonce, nonce, plain.Bytes(), []byte(path))
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(sealed))
+}
+
+// open decrypts a value made by seal.
+func (c *NDJSONCipher) open(path string, v json.RawMessage) (json.RawMessage, error) {
+	var s string
+	if err := json.Unmarshal(v, &s); err != nil {
+		return nil, errors.New(path + " is not an encrypted value")
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return nil, errors.New(path + " is not an encrypted value")
+	}
+
+	return c.aead.Open(nil,
+		sealed[:c.aead.NonceSize()],
+		sealed[c.aead.NonceSize():],
+		[]byte(path),
+	)
+}
@@ -0,0 +1,342 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"slices"
+	"time"
+)
+
+// Option configures a Reader, Writer, Encrypt or Decrypt. options that don't
+// apply to what they are passed to are ignored.
+type Option func(*config) error
+
+// config holds the settings options can change.
+type config struct {
+	// chunkSize is the size of plaintext sealed in each chunk of a stream
+	chunkSize int
+
+	// cipher is the AEAD used when encrypting
+	cipher Cipher
+
+	// concurrency is how many chunks of a stream are sealed or opened at
+	// once
+	concurrency int
+
+	// policy is checked before anything is encrypted or decrypted
+	policy *SecurityPolicy
+
+	// kdf derives keys from passwords
+	kdf KDF
+
+	// archive adds the format description and parity to streams, see
+	// WithArchiveProfile
+	archive bool
+
+	// withhold is the most plaintext a Reader buffers before releasing
+	// any, see WithholdUntilComplete
+	withhold int64
+
+	// maxSize is the most plaintext Decrypt or a Reader accepts, see
+	// WithMaxSize
+	maxSize int64
+
+	// legacy makes Decrypt also try the form from before the cipher byte,
+	// see WithLegacyFormat
+	legacy bool
+
+	// aad is authenticated along with the data but not stored, see WithAAD
+	aad []byte
+
+	// metadata is the encoded header field set by WithMetadata
+	metadata []byte
+
+	// tagsOut and tagsIn carry detached tags, see WithDetachedTags
+	tagsOut io.Writer
+	tagsIn  io.Reader
+
+	// signer signs streams, trusted are the keys accepted from them. see
+	// WithSigner
+	signer  ed25519.PrivateKey
+	trusted []ed25519.PublicKey
+
+	// counterNonces derives chunk nonces from their sequence number, see
+	// WithCounterNonces
+	counterNonces bool
+
+	// rekey is how much plaintext is sealed with each key, see
+	// WithRekeyAfter
+	rekey int64
+
+	// convergence is the MAC key nonces are derived from, see
+	// WithConvergentEncryption
+	convergence []byte
+
+	// progress is called after every batch of chunks, see WithProgress
+	progress func(bytes, chunks int64)
+
+	// ctx stops streams once done, rateLimit paces them. see WithContext
+	// and WithRateLimit
+	ctx       context.Context
+	rateLimit int64
+
+	// random is where keys, nonces and salts come from, see
+	// WithEntropySource
+	random io.Reader
+
+	// encryptNames makes an EncryptedFS encrypt names on disk, see
+	// WithEncryptedNames
+	encryptNames bool
+
+	// mmap makes Writer.ReadFrom map files, see WithMmap
+	mmap bool
+
+	// connIdentity authenticates connections with X25519 keys, see
+	// WithConnIdentity
+	connIdentity *connIdentity
+
+	// peerVerifier checks the other side's key, see WithPeerVerifier
+	peerVerifier func(*PublicKey) error
+
+	// keepAlive is how long a connection may go without sending before it
+	// pings, keepAliveTimeout how long without receiving before the peer
+	// counts as dead. see WithKeepAlive
+	keepAlive, keepAliveTimeout time.Duration
+
+	// gob makes an Encoder use encoding/gob, see WithGob
+	gob bool
+
+	// timestamp records when something was encrypted, ttl is how old it
+	// may be when decrypted. see WithTimestamp and WithTTL
+	timestamp bool
+	ttl       time.Duration
+
+	// fingerprint records the key's fingerprint in stream headers, see
+	// WithKeyFingerprint
+	fingerprint bool
+
+	// streamID gives streams a random ID, see WithStreamID
+	streamID bool
+
+	// digest is the hash of the plaintext streams compute and expectDigest
+	// what a Reader has to end up with, see WithDigest
+	digest       crypto.Hash
+	expectDigest []byte
+
+	// dryRun discards what a Writer writes and ciphertextDigest hashes
+	// it, see WithDryRun and WithCiphertextDigest
+	dryRun           bool
+	ciphertextDigest crypto.Hash
+
+	// candidates are keys NewReader tries in turn, see WithCandidateKeys
+	candidates []*[32]byte
+}
+
+// newConfig applies opts on top of the defaults.
+func newConfig(opts []Option) (*config, error) {
+	c := &config{
+		chunkSize:   DefaultBlockSize,
+		cipher:      DefaultCipher,
+		concurrency: 1,
+		kdf:         DefaultKDF,
+		random:      randReader,
+		ctx:         context.Background(),
+	}
+
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// WithChunkSize sets the size of the plaintext sealed in each chunk of a
+// stream, the default is DefaultBlockSize. it must be at most 64 MiB.
+func WithChunkSize(n int) Option {
+	return func(c *config) error {
+		if n <= 0 || n > maxChunkSize {
+			return errors.New("chunk size must be between 1 byte and 64 MiB")
+		}
+
+		c.chunkSize = n
+		return nil
+	}
+}
+
+// WithCipher sets the cipher used to encrypt, the default is DefaultCipher.
+// Decrypt and NewReader read the cipher from their input so they ignore this
+// option.
+func WithCipher(cipher Cipher) Option {
+	return func(c *config) error {
+		if _, err := cipher.NewAEAD(&[32]byte{}); err != nil {
+			return err
+		}
+
+		c.cipher = cipher
+		return nil
+	}
+}
+
+// WithConcurrency makes a Reader or Writer seal or open up to n chunks at
+// once on separate goroutines, runtime.NumCPU() is a good choice for large
+// streams. the output is the same as without it, but n chunks are buffered
+// at a time so memory use grows with n. the default is 1.
+func WithConcurrency(n int) Option {
+	return func(c *config) error {
+		if n < 1 || n > 1024 {
+			return errors.New("concurrency must be between 1 and 1024")
+		}
+
+		c.concurrency = n
+		return nil
+	}
+}
+
+// WithholdUntilComplete makes a Reader decrypt and authenticate the whole
+// stream, final chunk included, before Read returns any of it. without it
+// each chunk is released once it authenticates, so a truncated or tampered
+// stream can still have handed over the chunks before the damage. max is
+// the most plaintext that will be held in memory, longer streams fail
+// without releasing anything.
+func WithholdUntilComplete(max int64) Option {
+	return func(c *config) error {
+		if max <= 0 {
+			return errors.New("withhold limit must be positive")
+		}
+
+		c.withhold = max
+		return nil
+	}
+}
+
+// WithMaxSize makes Decrypt and a Reader refuse plaintext larger than n
+// bytes with ErrTooLarge, for ciphertexts from untrusted users. Decrypt
+// checks before decrypting anything, a Reader fails once more than n bytes
+// have authenticated, without releasing the chunks that went over. a
+// Reader also refuses streams whose header asks for chunks larger than n,
+// or than DefaultBlockSize if that is larger, since the chunk buffer is
+// allocated when the header is read.
+func WithMaxSize(n int64) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return errors.New("max size must be positive")
+		}
+
+		c.maxSize = n
+		return nil
+	}
+}
+
+// WithLegacyFormat makes Decrypt also accept data from before the cipher
+// byte was added, nonce|ciphertext|tag sealed with AES-256-GCM. it is only
+// tried when the input doesn't decrypt in the current form, so wrong keys
+// and damaged data cost two decryptions. a SecurityPolicy with a
+// MinVersion refuses the old form, as does one not allowing AES-256-GCM.
+func WithLegacyFormat() Option {
+	return func(c *config) error {
+		c.legacy = true
+		return nil
+	}
+}
+
+// WithAAD binds additional authenticated data to what Encrypt, NewWriter
+// or SealToPublicKey produce, such as a record ID, tenant or file path. it
+// isn't stored, the same data has to be given to decrypt, so ciphertext
+// moved to a different context fails to authenticate. for streams it is
+// authenticated in every chunk after the header.
+func WithAAD(aad []byte) Option {
+	return func(c *config) error {
+		c.aad = bytes.Clone(aad)
+		return nil
+	}
+}
+
+// WithContext makes a Reader or Writer stop with ctx's error once ctx is
+// done, checked before every batch of chunks and while waiting on
+// WithRateLimit.
+func WithContext(ctx context.Context) Option {
+	return func(c *config) error {
+		if ctx == nil {
+			return errors.New("crypt: nil context")
+		}
+
+		c.ctx = ctx
+		return nil
+	}
+}
+
+// SecurityPolicy is a floor on the parameters that will be used, see
+// WithSecurityPolicy. the zero value allows everything.
+type SecurityPolicy struct {
+	// Ciphers lists the ciphers that may be used, nil allows all of them
+	Ciphers []Cipher
+
+	// MinTagSize is the shortest authentication tag accepted in bytes
+	MinTagSize int
+
+	// MinVersion is the oldest stream header version accepted, it doesn't
+	// apply to Encrypt and Decrypt which have no version, except that any
+	// MinVersion refuses the legacy form allowed by WithLegacyFormat
+	MinVersion byte
+
+	// MinKDF holds the weakest parameters allowed for password streams and
+	// protected key files, one entry per KDF. a KDF has to be listed, with
+	// every cost parameter at least the listed one. nil allows all of them
+	MinKDF []KDF
+}
+
+// WithSecurityPolicy makes Encrypt, Decrypt, NewWriter, NewReader,
+// NewReaderAt and the password and key file functions refuse to use
+// anything weaker than p, so the floor can be set in one place instead of
+// audited at every call site.
+func WithSecurityPolicy(p SecurityPolicy) Option {
+	return func(c *config) error {
+		c.policy = &p
+		return nil
+	}
+}
+
+// check returns an error if cipher or version fall below the policy, a nil
+// policy allows everything. version 0 is not checked.
+func (p *SecurityPolicy) check(cipher Cipher, version byte) error {
+	if p == nil {
+		return nil
+	}
+
+	if p.Ciphers != nil && !slices.Contains(p.Ciphers, cipher) {
+		return errors.New("crypt: cipher " + cipher.String() + " is not allowed by the security policy")
+	}
+	if cipher.tagSize() < p.MinTagSize {
+		return errors.New("crypt: tag is shorter than the security policy allows")
+	}
+	if version != 0 && version < p.MinVersion {
+		return errors.New("crypt: stream version is older than the security policy allows")
+	}
+
+	return nil
+}
+
+// checkKDF returns an error if kdf isn't in the policy's MinKDF or is
+// weaker than its entry there.
+func (p *SecurityPolicy) checkKDF(kdf KDF) error {
+	if p == nil || p.MinKDF == nil {
+		return nil
+	}
+
+	for _, m := range p.MinKDF {
+		if m.id() != kdf.id() {
+			continue
+		}
+		if !kdf.atLeast(m) {
+			return errors.New("crypt: kdf parameters are weaker than the security policy allows")
+		}
+		return nil
+	}
+	return errors.New("crypt: kdf is not allowed by the security policy")
+}
@@ -0,0 +1,171 @@
+package crypt
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// TestOptions makes sure options are applied and invalid ones are rejected
+// by every constructor.
+func TestOptions(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+
+	w, err := NewWriter(&bytes.Buffer{}, key, WithChunkSize(1024), WithCipher(XChaCha20Poly1305))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w.buf) != 1024 || w.NonceSize() != XChaCha20Poly1305.nonceSize() {
+		t.Fatal("options were not applied to the writer")
+	}
+
+	bad := []Option{WithChunkSize(0), WithChunkSize(-1), WithCipher(Cipher(0)), WithConcurrency(0)}
+	for _, opt := range bad {
+		if _, err := NewWriter(&bytes.Buffer{}, key, opt); err == nil {
+			t.Fatal("NewWriter accepted a bad option")
+		}
+		if _, err := NewReader(&bytes.Buffer{}, key, opt); err == nil {
+			t.Fatal("NewReader accepted a bad option")
+		}
+		if _, err := Encrypt(nil, key, opt); err == nil {
+			t.Fatal("Encrypt accepted a bad option")
+		}
+	}
+}
+
+// TestSecurityPolicy makes sure everything that encrypts or decrypts
+// follows the policy.
+func TestSecurityPolicy(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	strict := WithSecurityPolicy(SecurityPolicy{Ciphers: []Cipher{AES256GCMSIV}})
+
+	if _, err := Encrypt(nil, key, strict); err == nil {
+		t.Fatal("Encrypt used a cipher the policy doesn't allow")
+	}
+	if _, err := NewWriter(&bytes.Buffer{}, key, strict); err == nil {
+		t.Fatal("NewWriter used a cipher the policy doesn't allow")
+	}
+
+	encrypted, err := Encrypt(nil, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decrypt(encrypted, key, strict); err == nil {
+		t.Fatal("Decrypt accepted a cipher the policy doesn't allow")
+	}
+	stream := sealStream(t, key, nil, 16)
+	if _, err := NewReader(bytes.NewReader(stream), key, strict); err == nil {
+		t.Fatal("NewReader accepted a cipher the policy doesn't allow")
+	}
+	if _, err := NewReaderAt(bytes.NewReader(stream), int64(len(stream)), key, strict); err == nil {
+		t.Fatal("NewReaderAt accepted a cipher the policy doesn't allow")
+	}
+
+	for _, p := range []SecurityPolicy{{MinTagSize: 32}, {MinVersion: headerVersion + 1}} {
+		if _, err := NewReader(bytes.NewReader(stream), key, WithSecurityPolicy(p)); err == nil {
+			t.Fatalf("NewReader accepted a stream below %+v", p)
+		}
+	}
+
+	// password streams and key files have to meet the KDF floor
+	password := []byte("hunter2")
+	weak := PBKDF2{Iterations: 1000}
+	var pw bytes.Buffer
+	w, err := NewPasswordWriter(&pw, password, WithKDF(weak))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := SaveKeyFile(keyFile, key, password, WithKDF(weak)); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []SecurityPolicy{
+		{MinKDF: []KDF{PBKDF2{Iterations: 600_000}}},
+		{MinKDF: []KDF{Argon2id{Time: 1, Memory: 64}}},
+	} {
+		if _, err := NewPasswordReader(bytes.NewReader(pw.Bytes()), password, WithSecurityPolicy(p)); err == nil {
+			t.Fatalf("NewPasswordReader accepted %+v below %+v", weak, p)
+		}
+		if _, err := LoadKeyFile(keyFile, password, WithSecurityPolicy(p)); err == nil {
+			t.Fatalf("LoadKeyFile accepted %+v below %+v", weak, p)
+		}
+		if _, err := NewPasswordWriter(io.Discard, password, WithKDF(weak), WithSecurityPolicy(p)); err == nil {
+			t.Fatalf("NewPasswordWriter used %+v below %+v", weak, p)
+		}
+		if err := SaveKeyFile(keyFile+"2", key, password, WithKDF(weak), WithSecurityPolicy(p)); err == nil {
+			t.Fatalf("SaveKeyFile used %+v below %+v", weak, p)
+		}
+	}
+	floor := WithSecurityPolicy(SecurityPolicy{MinKDF: []KDF{weak, Argon2id{Time: 1, Memory: 64}}})
+	if _, err := NewPasswordReader(bytes.NewReader(pw.Bytes()), password, floor); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKeyFile(keyFile, password, floor); err != nil {
+		t.Fatal(err)
+	}
+
+	ok := WithSecurityPolicy(SecurityPolicy{Ciphers: []Cipher{DefaultCipher}, MinTagSize: 16, MinVersion: headerVersion})
+	if _, err := Decrypt(encrypted, key, ok); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewReader(bytes.NewReader(stream), key, ok); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestAAD makes sure data bound with WithAAD only decrypts with the same
+// data, for one-shot, streaming, random access and sealed boxes.
+func TestAAD(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	data := randBytes(100)
+	aad, other := WithAAD([]byte("record 1")), WithAAD([]byte("record 2"))
+
+	ct, err := Encrypt(data, key, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Decrypt(ct, key, aad); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	for _, opts := range [][]Option{nil, {other}} {
+		if _, err := Decrypt(ct, key, opts...); err == nil {
+			t.Fatal("Decrypt ignored the additional data")
+		}
+	}
+
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, key, aad, WithChunkSize(16))
+	w.Write(data)
+	w.Close()
+	stream := buf.Bytes()
+	for i, opts := range [][]Option{{aad}, nil, {other}} {
+		r, err := NewReader(bytes.NewReader(stream), key, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if (err == nil) != (i == 0) || (i == 0 && !bytes.Equal(got, data)) {
+			t.Fatalf("NewReader %d: got %v", i, err)
+		}
+		_, err = NewReaderAt(bytes.NewReader(stream), int64(len(stream)), key, opts...)
+		if (err == nil) != (i == 0) {
+			t.Fatalf("NewReaderAt %d: got %v", i, err)
+		}
+	}
+
+	pub, priv, _ := GenerateKeyPair()
+	box, _ := SealToPublicKey(data, pub, aad)
+	if _, err := OpenWithPrivateKey(box, priv, aad); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenWithPrivateKey(box, priv, other); err == nil {
+		t.Fatal("OpenWithPrivateKey ignored the additional data")
+	}
+}
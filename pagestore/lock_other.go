@@ -0,0 +1,13 @@
+//go:build !unix && !windows
+
+package pagestore
+
+import "errors"
+
+func lockFile(fd uintptr) error {
+	return errors.New("pagestore: file locking is not supported on this platform")
+}
+
+func unlockFile(fd uintptr) error {
+	return nil
+}
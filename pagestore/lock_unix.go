@@ -0,0 +1,21 @@
+//go:build unix
+
+package pagestore
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// lockFile takes an exclusive flock on fd without blocking.
+func lockFile(fd uintptr) error {
+	err := unix.Flock(int(fd), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return ErrLocked
+	}
+	return err
+}
+
+// unlockFile releases the lock taken by lockFile.
+func unlockFile(fd uintptr) error {
+	return unix.Flock(int(fd), unix.LOCK_UN)
+}
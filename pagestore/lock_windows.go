@@ -0,0 +1,21 @@
+package pagestore
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// lockFile takes an exclusive lock on the first byte of the file without
+// blocking, the same byte is locked by every Store so it works as a lock on
+// the whole file.
+func lockFile(fd uintptr) error {
+	err := windows.LockFileEx(windows.Handle(fd), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &windows.Overlapped{})
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return ErrLocked
+	}
+	return err
+}
+
+// unlockFile releases the lock taken by lockFile.
+func unlockFile(fd uintptr) error {
+	return windows.UnlockFileEx(windows.Handle(fd), 0, 1, 0, &windows.Overlapped{})
+}
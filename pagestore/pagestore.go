@@ -0,0 +1,987 @@
+// Package pagestore provides random access encrypted storage made of fixed
+// size pages. each page is sealed on its own, so any page can be read or
+// rewritten without touching the rest.
+//
+// pages are kept in physical slots found through a page map, which makes
+// copy-on-write snapshots cheap: a snapshot is a copy of the map and a page
+// is only copied when it is written while shared. writes are not persisted
+// until Sync commits the page maps, a store always opens in the state of its
+// last Sync.
+//
+// committed slots are never overwritten, and the root that points at the
+// committed state alternates between two positions, so a crash part way
+// through a Sync leaves the previous state intact and Open falls back to it.
+// see SyncPolicy for when data is flushed to stable storage.
+//
+// slots are bound to their position and the page maps are authenticated, so
+// pages can't be moved around without detection. a store replaced as a
+// whole by an older copy of itself can not be detected, callers that need
+// rollback protection must keep their own root of trust.
+//
+// nothing stops two processes from opening the same file and corrupting it
+// with interleaved commits, use WithLock to take an advisory lock.
+package pagestore
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/UlisseMini/crypt"
+)
+
+// the store starts with a header
+//
+//	magic | version | cipher | page size | store id
+//
+// followed by two sealed root records pointing at the committed metadata,
+// then the slots. each Sync writes the root the previous one didn't, the one
+// with the highest generation that authenticates is current. the header is
+// bound into every seal so slots and roots can't be copied between stores.
+const (
+	magic   = "CRYPTPS"
+	version = 3
+
+	headerSize = len(magic) + 1 + 1 + 4 + 16
+
+	// DefaultPageSize is the page size used when 0 is given to Create.
+	DefaultPageSize = 4096
+
+	// maxPageSize bounds the page size read from a header
+	maxPageSize = 1 << 20
+
+	// minPageSize leaves room for the metadata chain pointer
+	minPageSize = 16
+
+	// noSlot marks a page that has never been written, it reads as zeros
+	noSlot = ^uint64(0)
+)
+
+// kinds of sealed records, bound into their additional data
+const (
+	kindSlot byte = iota
+	kindRoot
+)
+
+// File is the storage a Store is kept in, *os.File satisfies it. if File also
+// has a Sync() error method it is used according to the SyncPolicy.
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// syncer is implemented by files that can flush to stable storage.
+type syncer interface {
+	Sync() error
+}
+
+// SyncPolicy controls when a Store flushes its File to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncFull flushes before and after writing the root on every Sync, so
+	// a Sync that returned is durable and a crash can only lose
+	// uncommitted writes. it is the default.
+	SyncFull SyncPolicy = iota
+
+	// SyncNone never flushes and leaves it to the operating system. a crash
+	// may lose recent commits and, if writes reach the disk out of order,
+	// leave the store unopenable or with pages from different commits.
+	SyncNone
+)
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithSyncPolicy sets when the Store flushes its File, the default is
+// SyncFull.
+func WithSyncPolicy(p SyncPolicy) Option {
+	return func(s *Store) {
+		s.policy = p
+	}
+}
+
+// ErrLocked is returned by Create and Open when WithLock is used and
+// another process holds the lock for longer than the wait.
+var ErrLocked = errors.New("pagestore: file is locked by another process")
+
+// WithLock takes an exclusive advisory lock on the File (flock on unix,
+// LockFileEx on windows) for as long as the Store is open, so two processes
+// can't write the same store. the File has to have an Fd() uintptr method,
+// like *os.File. if the lock is held wait is how long to keep trying before
+// returning ErrLocked, 0 gives up at once and a negative wait never does.
+// Close releases the lock.
+func WithLock(wait time.Duration) Option {
+	return func(s *Store) {
+		s.lock = true
+		s.lockWait = wait
+	}
+}
+
+// fder is implemented by files with a file descriptor or handle.
+type fder interface {
+	Fd() uintptr
+}
+
+// pageMap maps the pages of a store or snapshot to the slots holding them.
+type pageMap struct {
+	size  int64
+	pages []uint64
+
+	// deleted is set when a snapshot is deleted so views of it stop working
+	deleted bool
+}
+
+// Store is an encrypted io.ReadWriteSeeker, io.ReaderAt and io.WriterAt.
+// it is safe for concurrent use.
+type Store struct {
+	f    File
+	aead cipher.AEAD
+
+	// header is the raw store header
+	header   []byte
+	pageSize int
+
+	policy SyncPolicy
+
+	// lock is set by WithLock, locked once the lock is held
+	lock     bool
+	lockWait time.Duration
+	locked   bool
+
+	mu sync.Mutex
+
+	// head is the writable view, snaps the named snapshots
+	head  *pageMap
+	snaps map[string]*pageMap
+
+	// refs counts how many page maps use each slot
+	refs map[uint64]int
+
+	// dirty holds slots written since the last Sync, they aren't part of the
+	// committed state so they may be overwritten in place or freed at once.
+	dirty map[uint64]bool
+
+	// pendingFree holds committed slots that are no longer used, they become
+	// free once the next Sync no longer references them.
+	pendingFree []uint64
+
+	// free holds slots that can be reused, nSlots is one past the highest
+	// slot in the file.
+	free   []uint64
+	nSlots uint64
+
+	// metaSlots holds the committed metadata, gen counts commits
+	metaSlots []uint64
+	gen       uint64
+
+	// off is the offset used by Read, Write and Seek
+	off int64
+}
+
+// Create initialises a new store in f with the given page size, 0 means
+// DefaultPageSize. anything already in f is overwritten.
+func Create(f File, key *[32]byte, pageSize int, opts ...Option) (*Store, error) {
+	if pageSize == 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize < minPageSize || pageSize > maxPageSize {
+		return nil, errors.New("pagestore: page size must be between 16 bytes and 1 MiB")
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic...)
+	header = append(header, version, byte(crypt.DefaultCipher))
+	header = binary.BigEndian.AppendUint32(header, uint32(pageSize))
+	header = header[:headerSize]
+	if _, err := io.ReadFull(rand.Reader, header[headerSize-16:]); err != nil {
+		return nil, err
+	}
+
+	s, err := newStore(f, key, header, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.WriteAt(header, 0); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.Sync(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Open opens a store previously made with Create, in the state of its last
+// complete Sync. slots no longer referenced by anything are reclaimed.
+func Open(f File, key *[32]byte, opts ...Option) (*Store, error) {
+	header := make([]byte, headerSize)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return nil, errors.New("pagestore: file is too short to hold a header")
+	}
+	if !bytes.HasPrefix(header, []byte(magic)) {
+		return nil, errors.New("pagestore: not a page store")
+	}
+	if header[len(magic)] != version {
+		return nil, errors.New("pagestore: unsupported version")
+	}
+
+	s, err := newStore(f, key, header, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.recover(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// newStore sets up an empty Store from a raw header.
+func newStore(f File, key *[32]byte, header []byte, opts []Option) (*Store, error) {
+	pageSize := int(binary.BigEndian.Uint32(header[len(magic)+2:]))
+	if pageSize < minPageSize || pageSize > maxPageSize {
+		return nil, errors.New("pagestore: invalid page size")
+	}
+
+	aead, err := crypt.Cipher(header[len(magic)+1]).NewAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		f:        f,
+		aead:     aead,
+		header:   header,
+		pageSize: pageSize,
+	}
+	s.reset()
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// the header never changes once written, so it is fine that Open read
+	// it before locking
+	if s.lock {
+		if err := s.acquire(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// acquire takes the lock for WithLock, polling until lockWait is up.
+func (s *Store) acquire() error {
+	f, ok := s.f.(fder)
+	if !ok {
+		return errors.New("pagestore: WithLock needs a File with an Fd method")
+	}
+
+	deadline := time.Now().Add(s.lockWait)
+	delay := time.Millisecond
+	for {
+		err := lockFile(f.Fd())
+		if err == nil {
+			s.locked = true
+			return nil
+		} else if err != ErrLocked {
+			return err
+		}
+
+		if s.lockWait >= 0 && !time.Now().Before(deadline) {
+			return ErrLocked
+		}
+		if s.lockWait >= 0 {
+			delay = min(delay, time.Until(deadline))
+		}
+		time.Sleep(delay)
+		delay = min(delay*2, 100*time.Millisecond)
+	}
+}
+
+// Close releases the lock taken by WithLock. it doesn't Sync or close the
+// File, uncommitted writes are lost unless Sync is called first. the File
+// has to stay open until Close has returned.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.locked {
+		return nil
+	}
+	s.locked = false
+	return unlockFile(s.f.(fder).Fd())
+}
+
+// reset clears all in memory state.
+func (s *Store) reset() {
+	s.head = &pageMap{}
+	s.snaps = make(map[string]*pageMap)
+	s.refs = make(map[uint64]int)
+	s.dirty = make(map[uint64]bool)
+	s.pendingFree = nil
+	s.free = nil
+	s.nSlots = 0
+	s.metaSlots = nil
+	s.gen = 0
+}
+
+// PageSize returns the size of the plaintext in each page.
+func (s *Store) PageSize() int {
+	return s.pageSize
+}
+
+// Size returns the length of the plaintext held in the store.
+func (s *Store) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.head.size
+}
+
+// rootSize is the on disk size of the sealed root record.
+func (s *Store) rootSize() int64 {
+	return int64(s.aead.NonceSize() + 24 + s.aead.Overhead())
+}
+
+// rootOffset returns where root record n, 0 or 1, starts in the file.
+func (s *Store) rootOffset(n uint64) int64 {
+	return int64(headerSize) + int64(n)*s.rootSize()
+}
+
+// slotOffset returns where slot n starts in the file.
+func (s *Store) slotOffset(n uint64) int64 {
+	stride := int64(s.pageSize + s.aead.NonceSize() + s.aead.Overhead())
+	return s.rootOffset(2) + int64(n)*stride
+}
+
+// aad returns the additional data for a record of kind at position n.
+func (s *Store) aad(kind byte, n uint64) []byte {
+	b := append(bytes.Clone(s.header), kind)
+	return binary.BigEndian.AppendUint64(b, n)
+}
+
+// seal encrypts plaintext as a record of kind at position n and writes it at
+// off.
+func (s *Store) seal(kind byte, n uint64, plaintext []byte, off int64) error {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	_, err := s.f.WriteAt(s.aead.Seal(nonce, nonce, plaintext, s.aad(kind, n)), off)
+	return err
+}
+
+// open reads size bytes of sealed data for a record of kind at position n
+// from off and decrypts it.
+func (s *Store) open(kind byte, n uint64, size int, off int64) ([]byte, error) {
+	sealed := make([]byte, s.aead.NonceSize()+size+s.aead.Overhead())
+	if _, err := s.f.ReadAt(sealed, off); err != nil {
+		if err == io.EOF {
+			return nil, errors.New("pagestore: file is truncated")
+		}
+		return nil, err
+	}
+
+	plain, err := s.aead.Open(nil, sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():], s.aad(kind, n))
+	if err != nil {
+		return nil, errors.New("pagestore: page failed to authenticate")
+	}
+
+	return plain, nil
+}
+
+// alloc returns an unused slot, it is marked dirty since it can't be part of
+// the committed state.
+func (s *Store) alloc() uint64 {
+	var n uint64
+	if len(s.free) != 0 {
+		n = s.free[len(s.free)-1]
+		s.free = s.free[:len(s.free)-1]
+	} else {
+		n = s.nSlots
+		s.nSlots++
+	}
+
+	s.dirty[n] = true
+	return n
+}
+
+// release drops a reference to slot n, freeing it once nothing uses it.
+func (s *Store) release(n uint64) {
+	s.refs[n]--
+	if s.refs[n] > 0 {
+		return
+	}
+
+	delete(s.refs, n)
+	if s.dirty[n] {
+		delete(s.dirty, n)
+		s.free = append(s.free, n)
+	} else {
+		s.pendingFree = append(s.pendingFree, n)
+	}
+}
+
+// readPage returns the plaintext of page n of pm, unwritten pages read as
+// zeros.
+func (s *Store) readPage(pm *pageMap, n int64) ([]byte, error) {
+	if n >= int64(len(pm.pages)) || pm.pages[n] == noSlot {
+		return make([]byte, s.pageSize), nil
+	}
+
+	slot := pm.pages[n]
+	return s.open(kindSlot, slot, s.pageSize, s.slotOffset(slot))
+}
+
+// writePage stores page as page n of the head, copying it to a new slot
+// unless the current one is unshared and uncommitted.
+func (s *Store) writePage(n int64, page []byte) error {
+	for int64(len(s.head.pages)) <= n {
+		s.head.pages = append(s.head.pages, noSlot)
+	}
+
+	old := s.head.pages[n]
+	if old != noSlot && s.refs[old] == 1 && s.dirty[old] {
+		return s.seal(kindSlot, old, page, s.slotOffset(old))
+	}
+
+	slot := s.alloc()
+	if err := s.seal(kindSlot, slot, page, s.slotOffset(slot)); err != nil {
+		return err
+	}
+
+	s.head.pages[n] = slot
+	s.refs[slot]++
+	if old != noSlot {
+		s.release(old)
+	}
+
+	return nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (s *Store) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readAt(s.head, p, off)
+}
+
+func (s *Store) readAt(pm *pageMap, p []byte, off int64) (int, error) {
+	if pm.deleted {
+		return 0, errors.New("pagestore: snapshot was deleted")
+	}
+	if off < 0 {
+		return 0, errors.New("pagestore: negative offset")
+	}
+
+	total := 0
+	for len(p) != 0 && off < pm.size {
+		n, in := off/int64(s.pageSize), int(off%int64(s.pageSize))
+		page, err := s.readPage(pm, n)
+		if err != nil {
+			return total, err
+		}
+
+		// don't read past the end of the plaintext
+		end := s.pageSize
+		if rest := pm.size - n*int64(s.pageSize); rest < int64(end) {
+			end = int(rest)
+		}
+
+		c := copy(p, page[in:end])
+		p = p[c:]
+		off += int64(c)
+		total += c
+	}
+
+	if len(p) != 0 {
+		return total, io.EOF
+	}
+	return total, nil
+}
+
+// WriteAt implements io.WriterAt, writing past the end grows the store and
+// any gap reads as zeros.
+func (s *Store) WriteAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeAt(p, off)
+}
+
+func (s *Store) writeAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("pagestore: negative offset")
+	}
+
+	total := 0
+	for len(p) != 0 {
+		n, in := off/int64(s.pageSize), int(off%int64(s.pageSize))
+
+		var page []byte
+		if in == 0 && len(p) >= s.pageSize {
+			// the whole page is replaced so there is no need to read it
+			page = make([]byte, s.pageSize)
+		} else {
+			var err error
+			if page, err = s.readPage(s.head, n); err != nil {
+				return total, err
+			}
+		}
+
+		c := copy(page[in:], p)
+		if err := s.writePage(n, page); err != nil {
+			return total, err
+		}
+
+		p = p[c:]
+		off += int64(c)
+		total += c
+	}
+
+	if off > s.head.size {
+		s.head.size = off
+	}
+
+	return total, nil
+}
+
+// Read implements io.Reader.
+func (s *Store) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, err := s.readAt(s.head, p, s.off)
+	s.off += int64(n)
+	if err == io.EOF && n != 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Write implements io.Writer.
+func (s *Store) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, err := s.writeAt(p, s.off)
+	s.off += int64(n)
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (s *Store) Seek(offset int64, whence int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	off, err := seek(s.off, s.head.size, offset, whence)
+	if err != nil {
+		return 0, err
+	}
+
+	s.off = off
+	return off, nil
+}
+
+// seek computes a new offset for io.Seeker implementations.
+func seek(cur, size, offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += cur
+	case io.SeekEnd:
+		offset += size
+	default:
+		return 0, errors.New("pagestore: invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("pagestore: negative position")
+	}
+	return offset, nil
+}
+
+// Snapshot records the current contents of the store under name, sharing
+// every page with it until either side is written. like writes, it is not
+// persisted until the next Sync.
+func (s *Store) Snapshot(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.snaps[name]; ok {
+		return errors.New("pagestore: snapshot " + name + " already exists")
+	}
+	if len(name) > 0xffff {
+		return errors.New("pagestore: snapshot name is too long")
+	}
+
+	pm := &pageMap{
+		size:  s.head.size,
+		pages: append([]uint64(nil), s.head.pages...),
+	}
+	for _, slot := range pm.pages {
+		if slot != noSlot {
+			s.refs[slot]++
+		}
+	}
+
+	s.snaps[name] = pm
+	return nil
+}
+
+// DeleteSnapshot removes the snapshot name, pages only it used are reclaimed.
+// views of the snapshot stop working.
+func (s *Store) DeleteSnapshot(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pm, ok := s.snaps[name]
+	if !ok {
+		return errors.New("pagestore: no snapshot " + name)
+	}
+
+	for _, slot := range pm.pages {
+		if slot != noSlot {
+			s.release(slot)
+		}
+	}
+
+	pm.deleted = true
+	delete(s.snaps, name)
+	return nil
+}
+
+// Snapshots returns the names of all snapshots in sorted order.
+func (s *Store) Snapshots() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.snaps))
+	for name := range s.snaps {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// View returns a read only view of the snapshot name, it keeps returning the
+// contents at the time of the snapshot while the store is written.
+func (s *Store) View(name string) (*View, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pm, ok := s.snaps[name]
+	if !ok {
+		return nil, errors.New("pagestore: no snapshot " + name)
+	}
+
+	return &View{s: s, pm: pm}, nil
+}
+
+// View is a read only io.ReadSeeker and io.ReaderAt over a snapshot.
+type View struct {
+	s   *Store
+	pm  *pageMap
+	off int64
+}
+
+// Size returns the length of the snapshot.
+func (v *View) Size() int64 {
+	return v.pm.size
+}
+
+// ReadAt implements io.ReaderAt.
+func (v *View) ReadAt(p []byte, off int64) (int, error) {
+	v.s.mu.Lock()
+	defer v.s.mu.Unlock()
+	return v.s.readAt(v.pm, p, off)
+}
+
+// Read implements io.Reader.
+func (v *View) Read(p []byte) (int, error) {
+	n, err := v.ReadAt(p, v.off)
+	v.off += int64(n)
+	if err == io.EOF && n != 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (v *View) Seek(offset int64, whence int) (int64, error) {
+	off, err := seek(v.off, v.pm.size, offset, whence)
+	if err != nil {
+		return 0, err
+	}
+
+	v.off = off
+	return off, nil
+}
+
+// Sync commits every write, snapshot and deletion since the last Sync. the
+// metadata is written to fresh slots before the root is switched over to
+// it, and slots the old state used are only reused afterwards.
+func (s *Store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta := s.marshalMeta()
+
+	// write the metadata as a chain of slots, each starting with the next
+	chunk := s.pageSize - 8
+	var slots []uint64
+	for i := 0; i == 0 || i*chunk < len(meta); i++ {
+		slots = append(slots, s.alloc())
+	}
+	for i, slot := range slots {
+		next := noSlot
+		if i+1 < len(slots) {
+			next = slots[i+1]
+		}
+
+		page := make([]byte, s.pageSize)
+		binary.BigEndian.PutUint64(page, next)
+		copy(page[8:], meta[min(i*chunk, len(meta)):])
+
+		if err := s.seal(kindSlot, slot, page, s.slotOffset(slot)); err != nil {
+			return err
+		}
+	}
+
+	// every page and the metadata must be on disk before the root can
+	// point at them
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	gen := s.gen + 1
+	root := make([]byte, 0, 24)
+	root = binary.BigEndian.AppendUint64(root, gen)
+	root = binary.BigEndian.AppendUint64(root, slots[0])
+	root = binary.BigEndian.AppendUint64(root, uint64(len(meta)))
+	if err := s.seal(kindRoot, gen%2, root, s.rootOffset(gen%2)); err != nil {
+		return err
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	// the old metadata and anything released since the last commit are now
+	// unreferenced
+	s.free = append(s.free, s.metaSlots...)
+	s.free = append(s.free, s.pendingFree...)
+	s.pendingFree = nil
+	s.metaSlots = slots
+	s.dirty = make(map[uint64]bool)
+	s.gen = gen
+	return nil
+}
+
+// flush syncs the file to stable storage if the policy asks for it.
+func (s *Store) flush() error {
+	if f, ok := s.f.(syncer); ok && s.policy == SyncFull {
+		return f.Sync()
+	}
+
+	return nil
+}
+
+// recover loads the newest committed state that is intact. a crash during
+// Sync can leave the newest root torn, or pointing at data that never made
+// it to disk, in which case the previous commit is used.
+func (s *Store) recover() error {
+	var roots [][]byte
+	for i := uint64(0); i < 2; i++ {
+		if root, err := s.open(kindRoot, i, 24, s.rootOffset(i)); err == nil {
+			roots = append(roots, root)
+		}
+	}
+
+	// try the highest generation first
+	sort.Slice(roots, func(i, j int) bool {
+		return binary.BigEndian.Uint64(roots[i]) > binary.BigEndian.Uint64(roots[j])
+	})
+
+	err := errors.New("pagestore: no intact root record")
+	for _, root := range roots {
+		s.reset()
+		if err = s.load(root); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// load reads the committed state root points at and rebuilds the reference
+// counts and free list from it.
+func (s *Store) load(root []byte) error {
+	s.gen = binary.BigEndian.Uint64(root)
+	slot := binary.BigEndian.Uint64(root[8:])
+	metaLen := binary.BigEndian.Uint64(root[16:])
+
+	chunk := uint64(s.pageSize - 8)
+	meta := make([]byte, 0, metaLen)
+	for i := uint64(0); i == 0 || i*chunk < metaLen; i++ {
+		if slot == noSlot {
+			return errors.New("pagestore: metadata is truncated")
+		}
+
+		page, err := s.open(kindSlot, slot, s.pageSize, s.slotOffset(slot))
+		if err != nil {
+			return err
+		}
+
+		s.metaSlots = append(s.metaSlots, slot)
+		meta = append(meta, page[8:8+min(chunk, metaLen-uint64(len(meta)))]...)
+		slot = binary.BigEndian.Uint64(page)
+	}
+
+	if err := s.unmarshalMeta(meta); err != nil {
+		return err
+	}
+
+	// count references and find the end of the used slots
+	used := make(map[uint64]bool)
+	for _, slot := range s.metaSlots {
+		used[slot] = true
+	}
+	for _, pm := range s.pageMaps() {
+		for _, slot := range pm.pages {
+			if slot != noSlot {
+				s.refs[slot]++
+				used[slot] = true
+			}
+		}
+	}
+	for slot := range used {
+		s.nSlots = max(s.nSlots, slot+1)
+	}
+
+	// anything below the end that nothing uses is garbage from writes that
+	// were never committed
+	for slot := uint64(0); slot < s.nSlots; slot++ {
+		if !used[slot] {
+			s.free = append(s.free, slot)
+		}
+	}
+
+	return nil
+}
+
+// pageMaps returns the head followed by every snapshot.
+func (s *Store) pageMaps() []*pageMap {
+	maps := []*pageMap{s.head}
+	for _, name := range sortedKeys(s.snaps) {
+		maps = append(maps, s.snaps[name])
+	}
+	return maps
+}
+
+// marshalMeta encodes the head and snapshots as
+//
+//	snapshot count | head | (name length | name | map)...
+//
+// where each map is its size, page count and slots as big endian uint64s.
+func (s *Store) marshalMeta() []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(s.snaps)))
+	b = appendPageMap(b, s.head)
+	for _, name := range sortedKeys(s.snaps) {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(name)))
+		b = append(b, name...)
+		b = appendPageMap(b, s.snaps[name])
+	}
+
+	return b
+}
+
+// appendPageMap appends the encoding of pm to b.
+func appendPageMap(b []byte, pm *pageMap) []byte {
+	b = binary.BigEndian.AppendUint64(b, uint64(pm.size))
+	b = binary.BigEndian.AppendUint64(b, uint64(len(pm.pages)))
+	for _, slot := range pm.pages {
+		b = binary.BigEndian.AppendUint64(b, slot)
+	}
+	return b
+}
+
+// unmarshalMeta decodes metadata made by marshalMeta.
+func (s *Store) unmarshalMeta(b []byte) error {
+	errMeta := errors.New("pagestore: malformed metadata")
+	if len(b) < 4 {
+		return errMeta
+	}
+
+	n := binary.BigEndian.Uint32(b)
+	b = b[4:]
+
+	var err error
+	if s.head, b, err = readPageMap(b); err != nil {
+		return err
+	}
+
+	for i := uint32(0); i < n; i++ {
+		if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+			return errMeta
+		}
+
+		name := string(b[2 : 2+binary.BigEndian.Uint16(b)])
+		b = b[2+len(name):]
+		if s.snaps[name], b, err = readPageMap(b); err != nil {
+			return err
+		}
+	}
+
+	if len(b) != 0 {
+		return errMeta
+	}
+	return nil
+}
+
+// readPageMap decodes a map made by appendPageMap from the front of b.
+func readPageMap(b []byte) (*pageMap, []byte, error) {
+	errMeta := errors.New("pagestore: malformed metadata")
+	if len(b) < 16 {
+		return nil, nil, errMeta
+	}
+
+	pm := &pageMap{size: int64(binary.BigEndian.Uint64(b))}
+	n := binary.BigEndian.Uint64(b[8:])
+	b = b[16:]
+	if pm.size < 0 || n > uint64(len(b))/8 {
+		return nil, nil, errMeta
+	}
+
+	pm.pages = make([]uint64, n)
+	for i := range pm.pages {
+		pm.pages[i] = binary.BigEndian.Uint64(b)
+		b = b[8:]
+	}
+
+	return pm, b, nil
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string]*pageMap) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,361 @@
+package pagestore
+
+import (
+	"bytes"
+	"io"
+	mrand "math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/UlisseMini/crypt"
+)
+
+// memFile is an in memory File.
+type memFile struct {
+	b []byte
+}
+
+func (m *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m.b)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, m.b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(m.b) {
+		m.b = append(m.b, make([]byte, end-len(m.b))...)
+	}
+
+	return copy(m.b[off:], p), nil
+}
+
+// randKey returns a random key for encryption
+func randKey() *[32]byte {
+	key, err := crypt.GenerateKey()
+	if err != nil {
+		panic(err)
+	}
+
+	return (*[32]byte)(key)
+}
+
+// TestRandomAccess does random writes against the store and a plain byte
+// slice and makes sure they always agree, including after reopening.
+func TestRandomAccess(t *testing.T) {
+	t.Parallel()
+	f := &memFile{}
+	key := randKey()
+
+	s, err := Create(f, key, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var model []byte
+	rng := mrand.New(mrand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		off := rng.Int63n(1000)
+		p := make([]byte, rng.Intn(150))
+		rng.Read(p)
+
+		if _, err := s.WriteAt(p, off); err != nil {
+			t.Fatal(err)
+		}
+		if end := int(off) + len(p); end > len(model) {
+			model = append(model, make([]byte, end-len(model))...)
+		}
+		copy(model[off:], p)
+	}
+
+	// nothing is persisted until Sync
+	if s2, err := Open(f, key); err != nil || s2.Size() != 0 {
+		t.Fatalf("unsynced writes were persisted: %v", err)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(f, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Size() != int64(len(model)) {
+		t.Fatalf("size %d, want %d", s.Size(), len(model))
+	}
+
+	got, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, model) {
+		t.Fatal("store contents do not match")
+	}
+
+	// reads crossing the end return what is there and io.EOF
+	buf := make([]byte, 10)
+	n, err := s.ReadAt(buf, int64(len(model)-4))
+	if n != 4 || err != io.EOF {
+		t.Fatalf("ReadAt at the end got %d, %v", n, err)
+	}
+}
+
+// TestTamper makes sure modified and swapped pages are caught.
+func TestTamper(t *testing.T) {
+	t.Parallel()
+	f := &memFile{}
+	key := randKey()
+
+	s, err := Create(f, key, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write(bytes.Repeat([]byte("a"), 16*3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// swap the slots of pages 0 and 1
+	a, b := s.slotOffset(s.head.pages[0]), s.slotOffset(s.head.pages[1])
+	stride := b - a
+	swapped := &memFile{b: bytes.Clone(f.b)}
+	copy(swapped.b[a:b], f.b[b:b+stride])
+	copy(swapped.b[b:b+stride], f.b[a:b])
+
+	s, err = Open(swapped, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadAt(make([]byte, 1), 0); err == nil {
+		t.Fatal("swapped page was accepted")
+	}
+
+	// flip a bit in the last page
+	last := s.slotOffset(s.head.pages[2])
+	f.b[last] ^= 1
+	s, err = Open(f, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadAt(make([]byte, 1), 16*2); err == nil {
+		t.Fatal("modified page was accepted")
+	}
+
+	if _, err := Open(f, randKey()); err == nil {
+		t.Fatal("opened with the wrong key")
+	}
+}
+
+// TestSnapshots makes sure snapshots keep their contents while the store is
+// written, survive reopening, and give their pages back when deleted.
+func TestSnapshots(t *testing.T) {
+	t.Parallel()
+	f := &memFile{}
+	key := randKey()
+
+	s, err := Create(f, key, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v1 := bytes.Repeat([]byte("1"), 100)
+	if _, err := s.WriteAt(v1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Snapshot("v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Snapshot("v1"); err == nil {
+		t.Fatal("duplicate snapshot name was accepted")
+	}
+
+	// the snapshot and head share every page until one is written
+	slots := len(s.refs)
+	v2 := bytes.Repeat([]byte("2"), 40)
+	if _, err := s.WriteAt(v2, 10); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.refs) != slots+2 {
+		t.Fatalf("writing 2 pages used %d new slots", len(s.refs)-slots)
+	}
+
+	want := bytes.Clone(v1)
+	copy(want[10:], v2)
+
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = Open(f, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := io.ReadAll(s); !bytes.Equal(got, want) {
+		t.Fatal("head contents do not match")
+	}
+
+	v, err := s.View("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(v); !bytes.Equal(got, v1) {
+		t.Fatal("snapshot contents do not match")
+	}
+
+	if err := s.DeleteSnapshot("v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.ReadAt(make([]byte, 1), 0); err == nil {
+		t.Fatal("view of a deleted snapshot still works")
+	}
+	if len(s.Snapshots()) != 0 || len(s.refs) != 4 {
+		t.Fatalf("deleting the snapshot left %d snapshots and %d slots", len(s.Snapshots()), len(s.refs))
+	}
+}
+
+// syncFile is a memFile that counts calls to Sync.
+type syncFile struct {
+	memFile
+	syncs int
+}
+
+func (f *syncFile) Sync() error {
+	f.syncs++
+	return nil
+}
+
+// TestRecover checks that a torn root falls back to the previous commit,
+// and that the sync policy is followed.
+func TestRecover(t *testing.T) {
+	t.Parallel()
+	f := &syncFile{}
+	key := randKey()
+
+	s, err := Create(f, key, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write([]byte("old contents")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if f.syncs == 0 {
+		t.Fatal("file was never synced")
+	}
+
+	// commit a change to the start
+	if _, err := s.WriteAt([]byte("new"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	root := s.rootOffset(s.gen % 2)
+
+	s, err = Open(f, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(s)
+	if string(got) != "new contents" {
+		t.Fatalf("got %q after a clean commit", got)
+	}
+
+	// tear the newest root
+	f.b[root+5] ^= 1
+	s, err = Open(f, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ = io.ReadAll(s)
+	if string(got) != "old contents" {
+		t.Fatalf("got %q after a torn commit", got)
+	}
+
+	// tear the other one as well
+	f.b[s.rootOffset(s.gen%2)+5] ^= 1
+	if _, err := Open(f, key); err == nil {
+		t.Fatal("opened with no intact root")
+	}
+
+	f = &syncFile{}
+	s, err = Create(f, key, 16, WithSyncPolicy(SyncNone))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if f.syncs != 0 {
+		t.Fatal("file was synced with SyncNone")
+	}
+}
+
+// TestLock opens the same file twice with WithLock, as two processes would.
+func TestLock(t *testing.T) {
+	if runtime.GOOS == "plan9" || runtime.GOOS == "js" || runtime.GOOS == "wasip1" {
+		t.Skip("no file locking on " + runtime.GOOS)
+	}
+	t.Parallel()
+	key := randKey()
+	path := filepath.Join(t.TempDir(), "store")
+
+	f1, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+	s, err := Create(f1, key, 0, WithLock(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f2, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f2.Close()
+	if _, err := Open(f2, key, WithLock(0)); err != ErrLocked {
+		t.Fatalf("got %v, want ErrLocked", err)
+	}
+	start := time.Now()
+	if _, err := Open(f2, key, WithLock(50*time.Millisecond)); err != ErrLocked {
+		t.Fatalf("got %v, want ErrLocked", err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("gave up before the wait was over")
+	}
+
+	// released while waiting
+	closed := make(chan error, 1)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		closed <- s.Close()
+	}()
+	s2, err := Open(f2, key, WithLock(-1))
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s2.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Create(&memFile{}, key, 0, WithLock(0)); err == nil {
+		t.Fatal("locked a File without an Fd")
+	}
+}
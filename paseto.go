@@ -0,0 +1,244 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// PASETO v4.local tokens (https://github.com/paseto-standard/paseto-spec),
+// a JSON claims payload encrypted with XChaCha20 and authenticated with
+// keyed BLAKE2b together with an optional cleartext footer. there is no
+// algorithm in the token to pick, unlike JWT.
+const (
+	pasetoHeader   = "v4.local."
+	pasetoNonceLen = 32
+	pasetoTagLen   = 32
+
+	pasetoEncKeyInfo  = "paseto-encryption-key"
+	pasetoAuthKeyInfo = "paseto-auth-key-for-aead"
+)
+
+// ErrPasetoExpired is returned by VerifyPaseto for a token that is past its
+// exp claim or not yet at its nbf claim.
+var ErrPasetoExpired = errors.New("crypt: PASETO token has expired")
+
+// IssuePaseto encrypts claims as a v4.local token with key. if ttl isn't 0
+// the iat and exp claims are set, claims itself is left alone. footer is
+// authenticated but not encrypted, anyone holding the token can read it.
+func IssuePaseto(key *[32]byte, claims map[string]any, ttl time.Duration, footer []byte) (string, error) {
+	c := make(map[string]any, len(claims)+2)
+	for k, v := range claims {
+		c[k] = v
+	}
+	if ttl != 0 {
+		now := time.Now().UTC().Truncate(time.Second)
+		c["iat"] = now.Format(time.RFC3339)
+		c["exp"] = now.Add(ttl).Format(time.RFC3339)
+	}
+
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := newNonce(randReader, pasetoNonceLen)
+	if err != nil {
+		return "", err
+	}
+	return sealPaseto(key, nonce, payload, footer)
+}
+
+// VerifyPaseto decrypts a v4.local token made with key, returning its
+// claims and footer. exp and nbf claims are checked when present.
+func VerifyPaseto(token string, key *[32]byte) (claims map[string]any, footer []byte, err error) {
+	payload, footer, err := openPaseto(key, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	if err := d.Decode(&claims); err != nil || claims == nil {
+		return nil, nil, errors.New("crypt: PASETO payload is not a JSON object")
+	}
+
+	now := time.Now()
+	if exp, err := pasetoTime(claims, "exp"); err != nil {
+		return nil, nil, err
+	} else if !exp.IsZero() && !now.Before(exp) {
+		return nil, nil, ErrPasetoExpired
+	}
+	if nbf, err := pasetoTime(claims, "nbf"); err != nil {
+		return nil, nil, err
+	} else if now.Before(nbf) {
+		return nil, nil, ErrPasetoExpired
+	}
+
+	return claims, footer, nil
+}
+
+// pasetoKID is the footer the Keyring writes, PASETO's registered kid
+// claim holding the key id.
+type pasetoKID struct {
+	KID string `json:"kid"`
+}
+
+// IssuePaseto is IssuePaseto with the primary key, its id is put in the
+// footer as the kid claim.
+func (k *Keyring) IssuePaseto(claims map[string]any, ttl time.Duration) (string, error) {
+	id, key, err := k.Primary()
+	if err != nil {
+		return "", err
+	}
+
+	footer, err := json.Marshal(pasetoKID{KID: strconv.FormatUint(uint64(id), 10)})
+	if err != nil {
+		return "", err
+	}
+	return IssuePaseto(key, claims, ttl, footer)
+}
+
+// VerifyPaseto is VerifyPaseto with the key named by the token's footer.
+func (k *Keyring) VerifyPaseto(token string) (map[string]any, error) {
+	// the footer can be read before decrypting, it is checked along with
+	// the rest of the token by the key it names
+	_, footer, ok := splitPaseto(token)
+	if !ok {
+		return nil, errors.New("crypt: not a PASETO v4.local token")
+	}
+	var kid pasetoKID
+	if err := json.Unmarshal(footer, &kid); err != nil {
+		return nil, errors.New("crypt: PASETO token has no key id")
+	}
+	id, err := strconv.ParseUint(kid.KID, 10, 32)
+	if err != nil {
+		return nil, errors.New("crypt: PASETO token has no key id")
+	}
+
+	key, err := k.Key(uint32(id))
+	if err != nil {
+		return nil, err
+	}
+	claims, _, err := VerifyPaseto(token, key)
+	return claims, err
+}
+
+// pasetoTime returns the time claim name, the zero time if there is none.
+func pasetoTime(claims map[string]any, name string) (time.Time, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, errors.New("crypt: PASETO " + name + " claim is not a string")
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errors.New("crypt: PASETO " + name + " claim is not an RFC 3339 time")
+	}
+	return t, nil
+}
+
+// sealPaseto encrypts payload with nonce n.
+func sealPaseto(key *[32]byte, n, payload, footer []byte) (string, error) {
+	ek, n2, ak := pasetoKeys(key, n)
+	c, err := chacha20.NewUnauthenticatedCipher(ek, n2)
+	if err != nil {
+		return "", err
+	}
+	ct := make([]byte, len(payload))
+	c.XORKeyStream(ct, payload)
+
+	body := append(append(bytes.Clone(n), ct...), pasetoTag(ak, n, ct, footer)...)
+	token := pasetoHeader + b64url.EncodeToString(body)
+	if len(footer) > 0 {
+		token += "." + b64url.EncodeToString(footer)
+	}
+	return token, nil
+}
+
+// openPaseto checks and decrypts a token, returning its payload and footer.
+func openPaseto(key *[32]byte, token string) (payload, footer []byte, err error) {
+	body, footer, ok := splitPaseto(token)
+	if !ok || len(body) < pasetoNonceLen+pasetoTagLen {
+		return nil, nil, errors.New("crypt: not a PASETO v4.local token")
+	}
+	n := body[:pasetoNonceLen]
+	ct := body[pasetoNonceLen : len(body)-pasetoTagLen]
+	tag := body[len(body)-pasetoTagLen:]
+
+	ek, n2, ak := pasetoKeys(key, n)
+	if subtle.ConstantTimeCompare(tag, pasetoTag(ak, n, ct, footer)) != 1 {
+		return nil, nil, errors.New("crypt: PASETO token failed to authenticate")
+	}
+
+	c, err := chacha20.NewUnauthenticatedCipher(ek, n2)
+	if err != nil {
+		return nil, nil, err
+	}
+	payload = make([]byte, len(ct))
+	c.XORKeyStream(payload, ct)
+	return payload, footer, nil
+}
+
+// splitPaseto decodes the body and footer of a token.
+func splitPaseto(token string) (body, footer []byte, ok bool) {
+	rest, ok := strings.CutPrefix(token, pasetoHeader)
+	if !ok {
+		return nil, nil, false
+	}
+	b, f, hasFooter := strings.Cut(rest, ".")
+
+	body, err := b64url.DecodeString(b)
+	if err != nil {
+		return nil, nil, false
+	}
+	if hasFooter {
+		if footer, err = b64url.DecodeString(f); err != nil || len(footer) == 0 {
+			return nil, nil, false
+		}
+	}
+	return body, footer, true
+}
+
+// pasetoKeys splits key into the encryption key, XChaCha20 nonce and
+// authentication key for nonce n.
+func pasetoKeys(key *[32]byte, n []byte) (ek, n2, ak []byte) {
+	h, _ := blake2b.New(32+chacha20.NonceSizeX, key[:])
+	h.Write([]byte(pasetoEncKeyInfo))
+	h.Write(n)
+	tmp := h.Sum(nil)
+
+	h, _ = blake2b.New(32, key[:])
+	h.Write([]byte(pasetoAuthKeyInfo))
+	h.Write(n)
+	return tmp[:32], tmp[32:], h.Sum(nil)
+}
+
+// pasetoTag is the BLAKE2b MAC of the pre-authentication encoding of the
+// token, there is never an implicit assertion.
+func pasetoTag(ak, n, ct, footer []byte) []byte {
+	h, _ := blake2b.New(pasetoTagLen, ak)
+	h.Write(pasetoPAE([]byte(pasetoHeader), n, ct, footer, nil))
+	return h.Sum(nil)
+}
+
+// pasetoPAE is PASETO's pre-authentication encoding, the number of pieces and
+// then each piece prefixed with its length, all as 64 bit little endian.
+func pasetoPAE(pieces ...[]byte) []byte {
+	b := binary.LittleEndian.AppendUint64(nil, uint64(len(pieces)))
+	for _, p := range pieces {
+		b = binary.LittleEndian.AppendUint64(b, uint64(len(p)))
+		b = append(b, p...)
+	}
+	return b
+}
@@ -0,0 +1,117 @@
+package crypt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPaseto round trips a token with a footer and makes sure tampering
+// with any part of it is caught.
+func TestPaseto(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	claims := map[string]any{"sub": "1234", "scope": "read"}
+
+	token, err := IssuePaseto(key, claims, time.Hour, []byte("footer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := claims["exp"]; ok {
+		t.Fatal("claims were changed")
+	}
+	got, footer, err := VerifyPaseto(token, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["sub"] != "1234" || got["exp"] == nil || string(footer) != "footer" {
+		t.Fatalf("got %v %q", got, footer)
+	}
+
+	if _, _, err := VerifyPaseto(token, randKey()); err == nil {
+		t.Fatal("verified with the wrong key")
+	}
+	body := token[:strings.LastIndex(token, ".")]
+	bad := []string{
+		body,
+		body + "." + b64url.EncodeToString([]byte("other")),
+		strings.Replace(token, "v4.local.", "v4.public.", 1),
+		token[:len(pasetoHeader)+10],
+	}
+	for _, b := range bad {
+		if _, _, err := VerifyPaseto(b, key); err == nil {
+			t.Fatalf("verified %q", b)
+		}
+	}
+
+	expired, err := IssuePaseto(key, claims, -time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := VerifyPaseto(expired, key); !errors.Is(err, ErrPasetoExpired) {
+		t.Fatalf("got %v for an expired token", err)
+	}
+	early, _ := IssuePaseto(key, map[string]any{"nbf": time.Now().Add(time.Hour).Format(time.RFC3339)}, 0, nil)
+	if _, _, err := VerifyPaseto(early, key); !errors.Is(err, ErrPasetoExpired) {
+		t.Fatalf("got %v for a token not valid yet", err)
+	}
+}
+
+// TestPasetoVector checks against a token made by an independent
+// implementation, using the key and nonce of the spec's test vectors.
+func TestPasetoVector(t *testing.T) {
+	t.Parallel()
+	const token = "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwZGCbY9V7WU6abu74MmcUE8YWAiaArVI8XJyblDqbgE7mrzMY1fnz_d86YnJhUeWwJJawFu9R6s9-A.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9"
+	const payload = `{"data":"this is a secret message","exp":"2100-01-01T00:00:00+00:00"}`
+	const footer = `{"kid":"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN"}`
+	key := &[32]byte{}
+	for i := range key {
+		key[i] = 0x70 + byte(i)
+	}
+
+	got, err := sealPaseto(key, make([]byte, pasetoNonceLen), []byte(payload), []byte(footer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != token {
+		t.Fatalf("got %s", got)
+	}
+	claims, _, err := VerifyPaseto(token, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["data"] != "this is a secret message" {
+		t.Fatalf("got %v", claims)
+	}
+}
+
+// TestKeyringPaseto makes sure tokens name their key and keep verifying
+// after the primary changes.
+func TestKeyringPaseto(t *testing.T) {
+	t.Parallel()
+	kr := NewKeyring()
+	kr.Add(1, randKey())
+	kr.Add(2, randKey())
+
+	token, err := kr.IssuePaseto(map[string]any{"sub": "a"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr.SetPrimary(2)
+	claims, err := kr.VerifyPaseto(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "a" {
+		t.Fatalf("got %v", claims)
+	}
+
+	kr.Remove(1)
+	if _, err := kr.VerifyPaseto(token); err == nil {
+		t.Fatal("verified with a removed key")
+	}
+	if _, err := kr.VerifyPaseto(strings.Replace(token, "v4", "v3", 1)); err == nil {
+		t.Fatal("verified a v3 token")
+	}
+}
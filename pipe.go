@@ -0,0 +1,78 @@
+package crypt
+
+import (
+	"errors"
+	"io"
+)
+
+// EncryptPipe copies src to dst encrypted with key until src reaches
+// io.EOF, to put integrity protection in the middle of a pipeline such as
+// tar | crypt pipe | ssh. unlike a Writer, which holds data back until a
+// whole chunk is full, whatever a Read of src returns is sealed and written
+// right away, so a slow or interactive producer isn't stalled.
+//
+// the pipe is a record log, see RecordWriter, closed at the end so
+// DecryptPipe can tell a finished pipe from one that was cut off. it
+// returns how many bytes were read from src. WithCipher picks the cipher
+// and WithChunkSize the largest record.
+func EncryptPipe(dst io.Writer, src io.Reader, key *[32]byte, opts ...Option) (int64, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return 0, err
+	}
+	w, err := NewRecordWriter(dst, key, opts...)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, min(c.chunkSize, MaxRecordSize))
+	var total int64
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if err := w.Append(buf[:n]); err != nil {
+				return total, err
+			}
+			total += int64(n)
+		}
+		if err == io.EOF {
+			return total, w.Close()
+		} else if err != nil {
+			return total, err
+		}
+	}
+}
+
+// DecryptPipe copies a pipe made by EncryptPipe from src to dst, writing
+// every record as soon as it has been authenticated. it returns nil once
+// the pipe has been closed and an error wrapping ErrTruncated if src ends
+// before that, in which case what was written to dst is authentic but its
+// end is missing. it returns how many bytes were written to dst.
+func DecryptPipe(dst io.Writer, src io.Reader, key *[32]byte, opts ...Option) (int64, error) {
+	r, err := NewRecordReader(src, key, opts...)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for {
+		record, closed, err := r.next()
+		if err != nil {
+			return total, err
+		}
+		if closed {
+			// like a stream, nothing may follow the end
+			var b [1]byte
+			if n, _ := io.ReadFull(src, b[:]); n != 0 {
+				return total, errors.New("crypt: data after the end of the pipe")
+			}
+			return total, nil
+		}
+
+		n, err := dst.Write(record)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestPipe(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	data := randBytes(100_000)
+
+	var enc bytes.Buffer
+	n, err := EncryptPipe(&enc, iotest.HalfReader(bytes.NewReader(data)), key, WithChunkSize(4096))
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("EncryptPipe = %d, %v", n, err)
+	}
+	var dec bytes.Buffer
+	if n, err := DecryptPipe(&dec, bytes.NewReader(enc.Bytes()), key); err != nil || n != int64(len(data)) {
+		t.Fatalf("DecryptPipe = %d, %v", n, err)
+	}
+	if !bytes.Equal(dec.Bytes(), data) {
+		t.Fatal("data did not round trip")
+	}
+
+	// cut off, damaged and with something after the end
+	ct := enc.Bytes()
+	if _, err := DecryptPipe(io.Discard, bytes.NewReader(ct[:len(ct)/2]), key); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("cut off pipe: %v", err)
+	}
+	damaged := bytes.Clone(ct)
+	damaged[len(damaged)/2] ^= 1
+	if _, err := DecryptPipe(io.Discard, bytes.NewReader(damaged), key); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("damaged pipe: %v", err)
+	}
+	if _, err := DecryptPipe(io.Discard, bytes.NewReader(append(bytes.Clone(ct), 0)), key); err == nil {
+		t.Fatal("data after the end was accepted")
+	}
+	if _, err := DecryptPipe(io.Discard, bytes.NewReader(ct), randKey()); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("wrong key: %v", err)
+	}
+}
+
+// chanWriter sends everything written to it on a channel.
+type chanWriter chan []byte
+
+func (c chanWriter) Write(p []byte) (int, error) {
+	c <- bytes.Clone(p)
+	return len(p), nil
+}
+
+// TestPipeLatency checks data gets through the pipe while the producer is
+// still going, rather than waiting for a chunk to fill up.
+func TestPipeLatency(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	srcR, srcW := io.Pipe()
+	midR, midW := io.Pipe()
+	out := make(chanWriter)
+
+	go func() {
+		_, err := EncryptPipe(midW, srcR, key)
+		midW.CloseWithError(err)
+	}()
+	done := make(chan error, 1)
+	go func() {
+		_, err := DecryptPipe(out, midR, key)
+		done <- err
+	}()
+
+	for _, msg := range []string{"hello", "world"} {
+		srcW.Write([]byte(msg))
+		select {
+		case got := <-out:
+			if string(got) != msg {
+				t.Fatalf("got %q, want %q", got, msg)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("data is held back")
+		}
+	}
+	srcW.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,29 @@
+package crypt
+
+import "sync"
+
+// bufPools holds a pool of buffers for each size of stream buffer, which
+// comes in a few sizes (concurrency times a chunk or frame) over and over,
+// so programs opening many streams don't allocate them all anew.
+var bufPools sync.Map
+
+// getBuf returns a zeroed buffer of n bytes.
+func getBuf(n int) []byte {
+	if p, ok := bufPools.Load(n); ok {
+		if b, ok := p.(*sync.Pool).Get().(*[]byte); ok {
+			return *b
+		}
+	}
+	return make([]byte, n)
+}
+
+// putBuf returns b to its pool once nothing uses it, clearing it since it
+// may hold plaintext.
+func putBuf(b []byte) {
+	clear(b)
+	p, ok := bufPools.Load(len(b))
+	if !ok {
+		p, _ = bufPools.LoadOrStore(len(b), &sync.Pool{})
+	}
+	p.(*sync.Pool).Put(&b)
+}
@@ -0,0 +1,27 @@
+package crypt
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestBufPool makes sure pooled buffers come back the right size and
+// without what was in them.
+func TestBufPool(t *testing.T) {
+	t.Parallel()
+	for _, n := range []int{1, 4097, 1 << 16} {
+		b := getBuf(n)
+		for i := range b {
+			b[i] = 0xff
+		}
+		putBuf(b)
+
+		for range 3 {
+			b := getBuf(n)
+			if len(b) != n || !bytes.Equal(b, make([]byte, n)) {
+				t.Fatalf("%d: got a %d byte buffer, zeroed %v", n, len(b), bytes.Equal(b, make([]byte, len(b))))
+			}
+			putBuf(b)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package crypt
+
+// WithProgress makes a Reader or Writer call fn after every batch of
+// chunks it seals or opens, with the total plaintext bytes and chunks so
+// far, for progress bars and throughput metrics. fn is called on the
+// goroutine calling Read or Write and should return quickly.
+func WithProgress(fn func(bytes, chunks int64)) Option {
+	return func(c *config) error {
+		c.progress = fn
+		return nil
+	}
+}
+
+// progress counts what a Reader or Writer has processed for WithProgress.
+type progress struct {
+	fn            func(bytes, chunks int64)
+	bytes, chunks int64
+}
+
+// add records a batch and reports the totals.
+func (p *progress) add(bytes, chunks int) {
+	if p.fn == nil {
+		return
+	}
+
+	p.bytes += int64(bytes)
+	p.chunks += int64(chunks)
+	p.fn(p.bytes, p.chunks)
+}
@@ -0,0 +1,42 @@
+package crypt
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// TestProgress makes sure the totals reported add up to the stream.
+func TestProgress(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 100
+	data := randBytes(10*chunkSize + 30)
+
+	for _, c := range []int{1, 3} {
+		var calls int
+		var bytesDone, chunksDone int64
+		report := func(b, n int64) {
+			if b < bytesDone || n <= chunksDone {
+				t.Fatalf("progress went from %d/%d to %d/%d", bytesDone, chunksDone, b, n)
+			}
+			calls++
+			bytesDone, chunksDone = b, n
+		}
+
+		var buf bytes.Buffer
+		w, _ := NewWriter(&buf, key, WithChunkSize(chunkSize), WithConcurrency(c), WithProgress(report))
+		w.Write(data)
+		w.Close()
+		if bytesDone != int64(len(data)) || chunksDone != 11 || calls < 11/c {
+			t.Fatalf("concurrency %d: writer reported %d bytes, %d chunks in %d calls", c, bytesDone, chunksDone, calls)
+		}
+
+		calls, bytesDone, chunksDone = 0, 0, 0
+		r, _ := NewReader(&buf, key, WithConcurrency(c), WithProgress(report))
+		io.Copy(io.Discard, r)
+		if bytesDone != int64(len(data)) || chunksDone != 11 || calls < 11/c {
+			t.Fatalf("concurrency %d: reader reported %d bytes, %d chunks in %d calls", c, bytesDone, chunksDone, calls)
+		}
+	}
+}
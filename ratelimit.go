@@ -0,0 +1,69 @@
+package crypt
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// WithRateLimit makes a Reader or Writer wait between batches of chunks so
+// that on average no more than bytesPerSecond of stream go through it, to
+// keep a big encryption from saturating a shared disk or network. a burst
+// is at most one batch, a chunk per unit of concurrency, and time spent
+// idle earns at most a second of credit. waits end early when the context
+// from WithContext is done.
+func WithRateLimit(bytesPerSecond int64) Option {
+	return func(c *config) error {
+		if bytesPerSecond <= 0 {
+			return errors.New("crypt: rate limit must be positive")
+		}
+
+		c.rateLimit = bytesPerSecond
+		return nil
+	}
+}
+
+// limiter paces a stream for WithRateLimit.
+type limiter struct {
+	rate float64
+
+	// start is when the current run of bytes began
+	start time.Time
+	bytes int64
+}
+
+// newLimiter returns a limiter for rate bytes per second, nil for none.
+func newLimiter(rate int64) *limiter {
+	if rate == 0 {
+		return nil
+	}
+	return &limiter{rate: float64(rate)}
+}
+
+// wait blocks until n more bytes may go through, or ctx is done.
+func (l *limiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+
+	now := time.Now()
+	due := l.start.Add(time.Duration(float64(l.bytes) / l.rate * float64(time.Second)))
+	if l.start.IsZero() || now.Sub(due) > time.Second {
+		// first use, or idle long enough to have earned too much credit
+		l.start, l.bytes, due = now, 0, now
+	}
+	l.bytes += int64(n)
+
+	d := due.Sub(now)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
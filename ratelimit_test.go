@@ -0,0 +1,57 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// TestRateLimit makes sure streams are paced and a cancelled context cuts
+// a wait short.
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	data := randBytes(200 << 10)
+	const rate = 1 << 20
+
+	start := time.Now()
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, key, WithChunkSize(16<<10), WithRateLimit(rate))
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the first batch is free
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Fatalf("wrote %d bytes in %v at %d bytes per second", buf.Len(), d, rate)
+	}
+
+	start = time.Now()
+	r, _ := NewReader(bytes.NewReader(buf.Bytes()), key, WithRateLimit(rate))
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("got %v", err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Fatalf("read %d bytes in %v at %d bytes per second", buf.Len(), d, rate)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w, _ = NewWriter(io.Discard, key, WithChunkSize(16<<10), WithRateLimit(1), WithContext(ctx))
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start = time.Now()
+	_, err := w.Write(data)
+	if !errors.Is(err, context.Canceled) || time.Since(start) > 5*time.Second {
+		t.Fatalf("got %v after %v", err, time.Since(start))
+	}
+	if err := w.Close(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Close after cancel gave %v", err)
+	}
+
+	r, _ = NewReader(bytes.NewReader(buf.Bytes()), key, WithContext(ctx))
+	if _, err := io.ReadAll(r); !errors.Is(err, context.Canceled) {
+		t.Fatalf("reading with a done context gave %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"errors"
+	"io"
+)
+
+// ReaderAt decrypts arbitrary ranges of a stream made by Writer, reading
+// and authenticating only the chunks that hold the range. it implements
+// io.ReaderAt, wrap it with io.NewSectionReader(r, 0, r.Size()) for Read
+// and Seek. it is safe for concurrent use if the underlying io.ReaderAt is.
+type ReaderAt struct {
+	// r is the underlying stream, starting with its header
+	r io.ReaderAt
+
+	// the AEAD to be used
+	aead cipher.AEAD
+
+	// header is the stream header as authenticated in the additional data
+	// of every chunk, headerLen the length of the raw header
+	header    []byte
+	headerLen int
+
+	chunkSize int
+
+	// group is the number of chunks per parity chunk, 0 without parity
+	group int
+
+	// chunks is the number of chunks in the stream, size the plaintext
+	// length
+	chunks int64
+	size   int64
+
+	// metadata is from the header, see WithMetadata
+	metadata *Metadata
+}
+
+// NewReaderAt returns a ReaderAt for the size byte stream in r, decrypting
+// with key. the chunk size and cipher come from the stream header, and the
+// final chunk is authenticated straight away so a truncated stream is
+// caught here rather than by the first read near its end.
+func NewReaderAt(r io.ReaderAt, size int64, key *[32]byte, opts ...Option) (*ReaderAt, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// only size bytes of r belong to the stream
+	sr := io.NewSectionReader(r, 0, size)
+	h, raw, err := readHeader(sr)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.policy.check(h.cipher, h.version); err != nil {
+		return nil, err
+	}
+
+	aead, err := streamAEAD(h, key)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := h.parityGroup()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := h.fields[fieldDetached]; ok {
+		return nil, errors.New("crypt: ReaderAt can't read streams with detached tags")
+	}
+	if _, ok := h.fields[fieldSigner]; ok {
+		return nil, errors.New("crypt: ReaderAt can't verify signed streams")
+	}
+	metadata, err := h.metadata()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkStreamTTL(h); err != nil {
+		return nil, err
+	}
+	if err := checkFingerprint(h, key); err != nil {
+		return nil, err
+	}
+
+	ra := &ReaderAt{
+		r:         sr,
+		aead:      aead,
+		header:    append(h.aad(), c.aad...),
+		headerLen: len(raw),
+		chunkSize: h.chunkSize,
+		group:     group,
+		metadata:  metadata,
+	}
+
+	// every chunk but the final one is full, and the final one holds at
+	// least its overhead. each group of chunks, the last one included, is
+	// followed by a full size parity chunk
+	overhead := int64(ra.ChunkOverhead())
+	frame := overhead + int64(h.chunkSize)
+	body := size - int64(len(raw))
+	if group != 0 {
+		span := int64(group+1) * frame
+		body -= (body + span - 1) / span * frame
+		if body < 0 {
+			return nil, ErrTruncated
+		}
+	}
+	ra.chunks = body / frame
+	if rem := body % frame; rem >= overhead {
+		ra.chunks++
+	} else if rem != 0 || ra.chunks == 0 {
+		return nil, ErrTruncated
+	}
+	ra.size = body - ra.chunks*overhead
+
+	// if the last chunk isn't final the stream was cut on a chunk boundary
+	if _, err := ra.readChunk(make([]byte, frame), ra.chunks-1); err != nil {
+		return nil, err
+	}
+
+	return ra, nil
+}
+
+// Size returns the length of the plaintext.
+func (r *ReaderAt) Size() int64 {
+	return r.size
+}
+
+// ReadAt decrypts len(p) bytes of plaintext starting at off into p.
+func (r *ReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("crypt: negative offset")
+	}
+
+	buf := make([]byte, r.ChunkOverhead()+r.chunkSize)
+	for n < len(p) && off < r.size {
+		i := off / int64(r.chunkSize)
+		plain, err := r.readChunk(buf, i)
+		if err != nil {
+			return n, err
+		}
+
+		c := copy(p[n:], plain[off-i*int64(r.chunkSize):])
+		n += c
+		off += int64(c)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readChunk reads and decrypts chunk i using buf, which must be big enough
+// for a full chunk.
+func (r *ReaderAt) readChunk(buf []byte, i int64) ([]byte, error) {
+	last := i == r.chunks-1
+	frames := i
+	if r.group != 0 {
+		frames += i / int64(r.group)
+	}
+	off := int64(r.headerLen) + frames*int64(len(buf))
+	if last {
+		// with parity the final chunk isn't the end of the file
+		buf = buf[:r.size-i*int64(r.chunkSize)+int64(r.ChunkOverhead())]
+	}
+	n, err := r.r.ReadAt(buf, off)
+	if err != nil && !(err == io.EOF && last) {
+		if err == io.EOF {
+			err = ErrTruncated
+		}
+		return nil, &ChunkError{Chunk: i, Offset: off, Err: err}
+	}
+
+	plain, final, err := openChunk(r.aead, newChunkAAD(r.header), buf[:n], uint64(i))
+	if err != nil {
+		return nil, &ChunkError{Chunk: i, Offset: off, Err: err}
+	}
+	if final != last {
+		// a final chunk before the end means something was appended
+		if final {
+			return nil, &ChunkError{Chunk: i + 1, Offset: off + int64(len(buf)), Err: errors.New("crypt: data after the final chunk")}
+		}
+		return nil, &ChunkError{Chunk: i + 1, Offset: off + int64(len(buf)), Err: ErrTruncated}
+	}
+
+	return plain, nil
+}
+
+// NonceSize returns the size of the nonce stored in front of each chunk.
+func (r *ReaderAt) NonceSize() int {
+	return r.aead.NonceSize()
+}
+
+// Overhead returns the size of the authentication tag on each chunk.
+func (r *ReaderAt) Overhead() int {
+	return r.aead.Overhead()
+}
+
+// ChunkOverhead returns how many bytes each chunk on the wire is larger than
+// the plaintext it holds.
+func (r *ReaderAt) ChunkOverhead() int {
+	return sequenceSize + r.aead.NonceSize() + r.aead.Overhead()
+}
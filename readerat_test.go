@@ -0,0 +1,113 @@
+package crypt
+
+import (
+	"bytes"
+	"io"
+	mrand "math/rand"
+	"testing"
+)
+
+// sealStream returns data written through a Writer with the given chunk
+// size.
+func sealStream(t *testing.T, key *[32]byte, data []byte, chunkSize int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key, WithChunkSize(chunkSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// TestReaderAt compares random ranges read through a ReaderAt with the
+// plaintext.
+func TestReaderAt(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 32
+
+	for _, size := range []int{0, 1, chunkSize, 5*chunkSize + 9} {
+		data := randBytes(size)
+		stream := sealStream(t, key, data, chunkSize)
+
+		r, err := NewReaderAt(bytes.NewReader(stream), int64(len(stream)), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Size() != int64(size) {
+			t.Fatalf("Size() = %d, want %d", r.Size(), size)
+		}
+
+		for i := 0; i < 50; i++ {
+			off := mrand.Intn(size + 1)
+			p := make([]byte, mrand.Intn(3*chunkSize))
+			n, err := r.ReadAt(p, int64(off))
+			if want := min(len(p), size-off); n != want {
+				t.Fatalf("ReadAt(%d, %d) read %d, want %d", len(p), off, n, want)
+			}
+			if n < len(p) && err != io.EOF {
+				t.Fatalf("short ReadAt gave %v", err)
+			}
+			if !bytes.Equal(p[:n], data[off:off+n]) {
+				t.Fatalf("ReadAt(%d, %d) returned the wrong data", len(p), off)
+			}
+		}
+
+		// seeking through a section reader
+		sr := io.NewSectionReader(r, 0, r.Size())
+		if _, err := sr.Seek(int64(size/2), io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		rest, err := io.ReadAll(sr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rest, data[size/2:]) {
+			t.Fatal("Seek then Read returned the wrong data")
+		}
+	}
+}
+
+// TestReaderAtTamper makes sure truncation is caught up front and a
+// modified chunk only breaks reads that touch it.
+func TestReaderAtTamper(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 32
+	data := randBytes(4 * chunkSize)
+	stream := sealStream(t, key, data, chunkSize)
+
+	r, err := NewReaderAt(bytes.NewReader(stream), int64(len(stream)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := r.ChunkOverhead() + chunkSize
+	headerLen := len(stream) - 4*frame
+
+	// cut on a chunk boundary, and part way through a chunk
+	for _, n := range []int{len(stream) - frame, len(stream) - 5} {
+		if _, err := NewReaderAt(bytes.NewReader(stream[:n]), int64(n), key); err == nil {
+			t.Fatalf("stream cut to %d bytes was accepted", n)
+		}
+	}
+
+	tampered := bytes.Clone(stream)
+	tampered[headerLen+frame+sequenceSize+r.NonceSize()] ^= 1
+	r, err = NewReaderAt(bytes.NewReader(tampered), int64(len(tampered)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadAt(make([]byte, chunkSize), 0); err != nil {
+		t.Fatal("untouched chunk failed:", err)
+	}
+	if _, err := r.ReadAt(make([]byte, 2), chunkSize-1); err == nil {
+		t.Fatal("modified chunk was accepted")
+	}
+}
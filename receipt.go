@@ -0,0 +1,96 @@
+package crypt
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// a receipt is laid out as
+//
+//	version | plaintext digest | plaintext length | ciphertext digest | time | key fingerprint | signature
+//
+// where the digests are SHA-256, length and time (unix seconds) are big
+// endian 64 bit integers and the fingerprint is 16 bytes. the signature is
+// ed25519 over receiptContext followed by everything before it.
+const (
+	receiptVersion = 1
+	receiptBody    = 1 + 32 + 8 + 32 + 8 + 16
+	receiptSize    = receiptBody + ed25519.SignatureSize
+	receiptContext = "crypt receipt v1\x00"
+)
+
+// Receipt is a signed statement that a ciphertext holds a given plaintext,
+// made by EncryptWithReceipt.
+type Receipt struct {
+	// PlaintextDigest is the SHA-256 of the plaintext and PlaintextLen its
+	// length
+	PlaintextDigest [32]byte
+	PlaintextLen    int64
+
+	// CiphertextDigest is the SHA-256 of the ciphertext
+	CiphertextDigest [32]byte
+
+	// Time is when the receipt was made, to the second
+	Time time.Time
+
+	// KeyFingerprint identifies the key without revealing it
+	KeyFingerprint string
+}
+
+// EncryptWithReceipt is Encrypt that also returns a receipt signed by
+// signer, binding the ciphertext to the plaintext's digest and length. the
+// receipt can later prove what the ciphertext holds without decrypting it,
+// e.g. for notarization. the plaintext digest is not hidden, so anyone with
+// the receipt can confirm a guess of the plaintext.
+func EncryptWithReceipt(plaintext []byte, key *[32]byte, signer ed25519.PrivateKey, opts ...Option) (ciphertext, receipt []byte, err error) {
+	ciphertext, err = Encrypt(plaintext, key, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fp, err := deriveKey(key, fingerprintInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pd, cd := sha256.Sum256(plaintext), sha256.Sum256(ciphertext)
+	receipt = make([]byte, 0, receiptSize)
+	receipt = append(receipt, receiptVersion)
+	receipt = append(receipt, pd[:]...)
+	receipt = binary.BigEndian.AppendUint64(receipt, uint64(len(plaintext)))
+	receipt = append(receipt, cd[:]...)
+	receipt = binary.BigEndian.AppendUint64(receipt, uint64(time.Now().Unix()))
+	receipt = append(receipt, fp[:16]...)
+	receipt = append(receipt, ed25519.Sign(signer, append([]byte(receiptContext), receipt...))...)
+
+	return ciphertext, receipt, nil
+}
+
+// VerifyReceipt checks receipt was signed by pub and returns its contents.
+// the caller compares the digests with the ciphertext and plaintext they
+// have.
+func VerifyReceipt(receipt []byte, pub ed25519.PublicKey) (*Receipt, error) {
+	if len(receipt) != receiptSize || receipt[0] != receiptVersion {
+		return nil, errors.New("crypt: malformed receipt")
+	}
+
+	body, sig := receipt[:receiptBody], receipt[receiptBody:]
+	if !ed25519.Verify(pub, append([]byte(receiptContext), body...), sig) {
+		return nil, errors.New("crypt: receipt is not signed by the key")
+	}
+
+	r := &Receipt{}
+	p := body[1:]
+	p = p[copy(r.PlaintextDigest[:], p):]
+	r.PlaintextLen = int64(binary.BigEndian.Uint64(p))
+	p = p[8:]
+	p = p[copy(r.CiphertextDigest[:], p):]
+	r.Time = time.Unix(int64(binary.BigEndian.Uint64(p)), 0)
+	r.KeyFingerprint = hex.EncodeToString(p[8:])
+
+	return r, nil
+}
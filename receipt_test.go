@@ -0,0 +1,50 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+// TestReceipt makes sure a receipt matches what was encrypted and only
+// verifies unmodified with the signer's key.
+func TestReceipt(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randBytes(smallSize)
+
+	encrypted, receipt, err := EncryptWithReceipt(data, key, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := VerifyReceipt(receipt, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, _ := fingerprint(key)
+	if r.PlaintextDigest != sha256.Sum256(data) || r.PlaintextLen != int64(len(data)) ||
+		r.CiphertextDigest != sha256.Sum256(encrypted) || r.KeyFingerprint != fp ||
+		time.Since(r.Time) > time.Minute {
+		t.Fatalf("receipt does not match: %+v", r)
+	}
+
+	for i := range receipt {
+		tampered := bytes.Clone(receipt)
+		tampered[i] ^= 1
+		if _, err := VerifyReceipt(tampered, pub); err == nil {
+			t.Fatalf("flipping byte %d was not detected", i)
+		}
+	}
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	if _, err := VerifyReceipt(receipt, other); err == nil {
+		t.Fatal("verified with the wrong key")
+	}
+}
@@ -0,0 +1,235 @@
+package crypt
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// a stream for several recipients is sealed with a random data key, which
+// is wrapped once for each recipient in the recipients header field as a
+// list of stanzas, each
+//
+//	type | length | body
+//
+// with type a byte and length a big endian uint16.
+const (
+	// stanzaKey wraps the data key with a symmetric key, the body is
+	// Encrypt output with recipientInfo as additional data
+	stanzaKey = 1
+
+	// stanzaX25519 wraps the data key for an X25519 public key, the body
+	// is SealToPublicKey output
+	stanzaX25519 = 2
+
+	// stanzaSSHEd25519 and stanzaSSHRSA wrap the data key for an SSH key,
+	// see ssh.go
+	stanzaSSHEd25519 = 3
+	stanzaSSHRSA     = 4
+
+	// stanzaHybrid wraps the data key for an ML-KEM-768 + X25519 key, see
+	// hybrid.go
+	stanzaHybrid = 5
+)
+
+// Recipient is someone a stream can be encrypted for with
+// NewRecipientWriter, *Key, *PublicKey and *HybridPublicKey are
+// recipients.
+type Recipient interface {
+	// wrap seals the data key for the recipient, returning the stanza
+	wrap(c Cipher, dataKey *[32]byte) (typ byte, body []byte, err error)
+}
+
+// Identity can decrypt streams made by NewRecipientWriter for the matching
+// Recipient, *Key, *PrivateKey and *HybridPrivateKey are identities.
+type Identity interface {
+	// unwrap returns the data key in a stanza, or errNotForIdentity if the
+	// stanza is not for this identity
+	unwrap(typ byte, body []byte) (*[32]byte, error)
+}
+
+// errNotForIdentity is returned by Identity.unwrap for stanzas meant for
+// someone else.
+var errNotForIdentity = errors.New("crypt: stanza is not for this identity")
+
+func (k *Key) wrap(c Cipher, dataKey *[32]byte) (byte, []byte, error) {
+	body, err := encrypt(randReader, c, dataKey[:], (*[32]byte)(k), []byte(recipientInfo))
+	return stanzaKey, body, err
+}
+
+func (k *Key) unwrap(typ byte, body []byte) (*[32]byte, error) {
+	if typ != stanzaKey {
+		return nil, errNotForIdentity
+	}
+
+	// there is nothing to say which key a stanza is for, so failing to
+	// open it just means it is someone else's
+	b, err := decrypt(body, (*[32]byte)(k), []byte(recipientInfo))
+	if err != nil || len(b) != 32 {
+		return nil, errNotForIdentity
+	}
+
+	return (*[32]byte)(b), nil
+}
+
+func (k *PublicKey) wrap(c Cipher, dataKey *[32]byte) (byte, []byte, error) {
+	body, err := SealToPublicKey(dataKey[:], k, WithCipher(c))
+	return stanzaX25519, body, err
+}
+
+func (k *PrivateKey) unwrap(typ byte, body []byte) (*[32]byte, error) {
+	if typ != stanzaX25519 {
+		return nil, errNotForIdentity
+	}
+
+	b, err := OpenWithPrivateKey(body, k)
+	if err != nil || len(b) != 32 {
+		return nil, errNotForIdentity
+	}
+
+	return (*[32]byte)(b), nil
+}
+
+// NewRecipientWriter is NewWriter for a random data key, wrapped for each
+// of recipients in the stream header. any one of them can decrypt the
+// stream with NewRecipientReader, and recipients can later be changed with
+// RewriteRecipients without touching the encrypted chunks.
+func NewRecipientWriter(w io.Writer, recipients []Recipient, opts ...Option) (*Writer, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := &[32]byte{}
+	if _, err := io.ReadFull(c.random, dataKey[:]); err != nil {
+		return nil, err
+	}
+
+	stanzas, err := wrapDataKey(c.cipher, dataKey, recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	return newWriter(w, dataKey, c, map[byte][]byte{fieldRecipients: stanzas})
+}
+
+// NewRecipientReader is NewReader for streams made by NewRecipientWriter,
+// the data key is unwrapped with identity.
+func NewRecipientReader(r io.Reader, identity Identity, opts ...Option) (*Reader, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	h, _, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.policy.check(h.cipher, h.version); err != nil {
+		return nil, err
+	}
+
+	dataKey, err := unwrapDataKey(h, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return newReader(r, dataKey, c, h)
+}
+
+// RewriteRecipients copies the stream in src to dst with its data key
+// wrapped for recipients instead, identity must be one of the current
+// recipients. only the header changes, the chunks are copied as they are,
+// so this is quick even for huge streams, and writing the new header over
+// the old one in place works if it is the same size. the data key stays the
+// same, so a removed recipient who kept it can still decrypt, use
+// ReEncrypt to lock them out for good.
+func RewriteRecipients(dst io.Writer, src io.Reader, identity Identity, recipients []Recipient) error {
+	h, _, err := readHeader(src)
+	if err != nil {
+		return err
+	}
+
+	dataKey, err := unwrapDataKey(h, identity)
+	if err != nil {
+		return err
+	}
+
+	stanzas, err := wrapDataKey(h.cipher, dataKey, recipients)
+	if err != nil {
+		return err
+	}
+	h.fields[fieldRecipients] = stanzas
+
+	raw, err := h.marshal()
+	if err != nil {
+		return err
+	}
+	if _, err := dst.Write(raw); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// wrapDataKey returns the recipients header field for dataKey.
+func wrapDataKey(c Cipher, dataKey *[32]byte, recipients []Recipient) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("crypt: no recipients")
+	}
+
+	var stanzas []byte
+	for _, r := range recipients {
+		typ, body, err := r.wrap(c, dataKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > math.MaxUint16 {
+			return nil, errors.New("crypt: recipient stanza is too large to fit in the header")
+		}
+
+		stanzas = append(stanzas, typ)
+		stanzas = binary.BigEndian.AppendUint16(stanzas, uint16(len(body)))
+		stanzas = append(stanzas, body...)
+	}
+
+	// the other fields are checked with the whole header when it is
+	// marshalled
+	if len(stanzas) > math.MaxUint16 {
+		return nil, errors.New("crypt: too many recipients to fit in the header")
+	}
+
+	return stanzas, nil
+}
+
+// unwrapDataKey finds the stanza in h for identity and returns the data
+// key from it.
+func unwrapDataKey(h *header, identity Identity) (*[32]byte, error) {
+	stanzas, ok := h.fields[fieldRecipients]
+	if !ok {
+		return nil, errors.New("crypt: stream has no recipients")
+	}
+
+	for len(stanzas) != 0 {
+		if len(stanzas) < 3 {
+			return nil, errBadHeader("malformed recipient stanza")
+		}
+		typ, n := stanzas[0], int(binary.BigEndian.Uint16(stanzas[1:]))
+		stanzas = stanzas[3:]
+		if len(stanzas) < n {
+			return nil, errBadHeader("malformed recipient stanza")
+		}
+
+		dataKey, err := identity.unwrap(typ, stanzas[:n])
+		if err == nil {
+			return dataKey, nil
+		} else if err != errNotForIdentity {
+			return nil, err
+		}
+		stanzas = stanzas[n:]
+	}
+
+	return nil, &detailError{msg: "crypt: stream is not encrypted for this identity", err: ErrKeyNotFound}
+}
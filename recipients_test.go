@@ -0,0 +1,114 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestRecipients encrypts for a symmetric key and a public key, then swaps
+// one recipient for another without touching the chunks.
+func TestRecipients(t *testing.T) {
+	t.Parallel()
+	shared, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randBytes(5*64 + 7)
+
+	var buf bytes.Buffer
+	w, err := NewRecipientWriter(&buf, []Recipient{shared, pub}, WithChunkSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+
+	read := func(stream []byte, id Identity) ([]byte, error) {
+		r, err := NewRecipientReader(bytes.NewReader(stream), id)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	for _, id := range []Identity{shared, priv} {
+		got, err := read(stream, id)
+		if err != nil {
+			t.Fatalf("%T: %v", id, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%T: data did not round trip", id)
+		}
+	}
+
+	stranger, _ := GenerateKey()
+	if _, err := read(stream, stranger); err == nil {
+		t.Fatal("a stranger read the stream")
+	}
+
+	// replace the shared key with a new one
+	newPub, newPriv, _ := GenerateKeyPair()
+	var rewritten bytes.Buffer
+	if err := RewriteRecipients(&rewritten, bytes.NewReader(stream), priv, []Recipient{pub, newPub}); err != nil {
+		t.Fatal(err)
+	}
+	body := func(s []byte) []byte {
+		h, raw, err := readHeader(bytes.NewReader(s))
+		if err != nil || h == nil {
+			t.Fatal(err)
+		}
+		return s[len(raw):]
+	}
+	if !bytes.Equal(body(rewritten.Bytes()), body(stream)) {
+		t.Fatal("chunks changed")
+	}
+	if got, err := read(rewritten.Bytes(), newPriv); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("new recipient can't read: %v", err)
+	}
+	if _, err := read(rewritten.Bytes(), shared); err == nil {
+		t.Fatal("removed recipient can still read")
+	}
+
+	if _, err := NewRecipientWriter(&bytes.Buffer{}, nil); err == nil {
+		t.Fatal("stream with no recipients was made")
+	}
+}
+
+// TestRecipientsHeaderTooLarge makes sure a header that doesn't fit is
+// refused when the writer is made, not found out when reading it back.
+func TestRecipientsHeaderTooLarge(t *testing.T) {
+	t.Parallel()
+	recipients := make([]Recipient, 800)
+	for i := range recipients {
+		recipients[i] = (*Key)(randKey())
+	}
+	meta := Metadata{Extra: map[string]string{"notes": strings.Repeat("x", 16000)}}
+
+	// each fits on its own
+	if _, err := NewRecipientWriter(&bytes.Buffer{}, recipients); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRecipientWriter(&bytes.Buffer{}, recipients[:1], WithMetadata(meta)); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := NewRecipientWriter(&buf, recipients, WithMetadata(meta)); !errors.Is(err, errHeaderTooLarge) {
+		t.Fatalf("oversized header: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatal("oversized header was written")
+	}
+}
@@ -0,0 +1,331 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+	"iter"
+)
+
+// record logs start with recordLogMagic, a version, the cipher and a random
+// log ID. each record is length (4) | nonce | ciphertext | tag, sealed with
+// the header, its sequence number and its length as additional data under a
+// key derived from the key given and the log ID. Close appends an empty
+// record with the top bit of its sequence number set to mark a clean end,
+// a resumed log carries on numbering after it.
+const (
+	recordLogMagic   = "CRYPTLOG"
+	recordLogVersion = 1
+	recordLogIDSize  = 16
+	recordLogHeader  = len(recordLogMagic) + 1 + 1 + recordLogIDSize
+
+	// the key of each record log is derived from the key given
+	recordLogInfo = "crypt record log v1\x00"
+)
+
+// MaxRecordSize is the largest record a record log holds.
+const MaxRecordSize = maxChunkSize
+
+// recordLog is the state shared by RecordWriter and RecordReader.
+type recordLog struct {
+	aead   cipher.AEAD
+	header []byte
+
+	// seq is the sequence number of the next record
+	seq uint64
+}
+
+func newRecordLog(h []byte, key *[32]byte) (*recordLog, error) {
+	logKey, err := deriveKey(key, recordLogInfo+string(h[len(h)-recordLogIDSize:]))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := Cipher(h[len(recordLogMagic)+1]).NewAEAD(logKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordLog{aead: aead, header: bytes.Clone(h)}, nil
+}
+
+// aad returns the additional data of a record with the length prefix n.
+func (l *recordLog) aad(seq uint64, n []byte) []byte {
+	aad := binary.BigEndian.AppendUint64(bytes.Clone(l.header), seq)
+	return append(aad, n...)
+}
+
+// RecordWriter appends records to an encrypted, append only log such as a
+// write ahead log or an audit log. each record is sealed on its own with
+// its position in the log, so records can't be reordered, dropped from the
+// middle or repeated. Close marks the end of the log, a RecordReader
+// reports a log without it as possibly truncated.
+type RecordWriter struct {
+	*recordLog
+	w      io.Writer
+	random io.Reader
+	buf    []byte
+	err    error
+}
+
+// NewRecordWriter writes the header of a new log to w and returns a
+// RecordWriter appending to it. WithCipher picks the cipher.
+func NewRecordWriter(w io.Writer, key *[32]byte, opts ...Option) (*RecordWriter, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.policy.check(c.cipher, headerVersion); err != nil {
+		return nil, err
+	}
+
+	h := append([]byte(recordLogMagic), recordLogVersion, byte(c.cipher))
+	id, err := newNonce(c.random, recordLogIDSize)
+	if err != nil {
+		return nil, err
+	}
+	h = append(h, id...)
+	if _, err := w.Write(h); err != nil {
+		return nil, err
+	}
+
+	l, err := newRecordLog(h, key)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordWriter{recordLog: l, w: w, random: c.random}, nil
+}
+
+// ResumeRecordWriter reads the existing log in log, checking every record,
+// and returns a RecordWriter that appends after them to w, usually the
+// same file opened for appending. the log may or may not have been closed,
+// but it can't end in a partial record: truncate it to the Offset a
+// RecordReader stopped at first.
+func ResumeRecordWriter(log io.Reader, w io.Writer, key *[32]byte, opts ...Option) (*RecordWriter, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewRecordReader(log, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		_, err := r.Next()
+		if err == io.EOF || errors.Is(err, errLogNotClosed) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return &RecordWriter{recordLog: r.recordLog, w: w, random: c.random}, nil
+}
+
+// Append seals record and writes it to the log with a single Write. the
+// log is only as durable as the underlying writer makes it, sync files
+// after appends that have to survive a crash.
+func (w *RecordWriter) Append(record []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(record) > MaxRecordSize {
+		return errors.New("crypt: record is larger than MaxRecordSize")
+	}
+	if w.seq&finalChunk != 0 {
+		return errors.New("crypt: record log is full")
+	}
+
+	if err := w.append(record, w.seq); err != nil {
+		w.err = err
+		return err
+	}
+	w.seq++
+	return nil
+}
+
+// Close appends the record marking a clean end of the log, it does not
+// close the underlying writer.
+func (w *RecordWriter) Close() error {
+	if w.err == ErrClosed {
+		return nil
+	} else if w.err != nil {
+		return w.err
+	}
+
+	if err := w.append(nil, w.seq|finalChunk); err != nil {
+		w.err = err
+		return err
+	}
+	w.seq++
+	w.err = ErrClosed
+	return nil
+}
+
+func (w *RecordWriter) append(record []byte, seq uint64) error {
+	ns := w.aead.NonceSize()
+	n := ns + len(record) + w.aead.Overhead()
+	buf := w.buf[:0]
+	buf = binary.BigEndian.AppendUint32(buf, uint32(n))
+	nonce, err := newNonce(w.random, ns)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, nonce...)
+	buf = w.aead.Seal(buf, nonce, record, w.aad(seq, buf[:4]))
+	w.buf = buf
+
+	nw, err := w.w.Write(buf)
+	if err == nil && nw != len(buf) {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+// errLogNotClosed is returned at the end of a log that wasn't closed.
+var errLogNotClosed = &detailError{msg: "crypt: record log ends without being closed, records may be missing", err: ErrTruncated}
+
+// RecordReader reads the records of a log made by RecordWriter in order,
+// authenticating each one.
+type RecordReader struct {
+	*recordLog
+	r io.Reader
+
+	// offset is the end of the last good record, closed whether the log
+	// has been closed as far as it has been read
+	offset int64
+	closed bool
+
+	buf []byte
+	err error
+}
+
+// NewRecordReader reads the header of the log in r.
+func NewRecordReader(r io.Reader, key *[32]byte, opts ...Option) (*RecordReader, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	h := make([]byte, recordLogHeader)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return nil, errBadHeader("not a record log")
+	}
+	if string(h[:len(recordLogMagic)]) != recordLogMagic {
+		return nil, errBadHeader("not a record log")
+	}
+	if h[len(recordLogMagic)] != recordLogVersion {
+		return nil, errBadHeader("unsupported record log version")
+	}
+	if err := c.policy.check(Cipher(h[len(recordLogMagic)+1]), headerVersion); err != nil {
+		return nil, err
+	}
+
+	l, err := newRecordLog(h, key)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordReader{recordLog: l, r: r, offset: int64(len(h))}, nil
+}
+
+// Next returns the next record, which is only valid until the following
+// call. at the end of a closed log it returns io.EOF. a log that ends
+// without being closed, or ends in a partial record, gives an error
+// wrapping ErrTruncated: the records before it are good but ones after
+// them may have been lost. a record that fails to authenticate gives a
+// ChunkError.
+func (r *RecordReader) Next() ([]byte, error) {
+	for r.err == nil {
+		record, closed, err := r.next()
+		if err != nil {
+			r.err = err
+			break
+		}
+		r.closed = closed
+		if !closed {
+			return record, nil
+		}
+	}
+	return nil, r.err
+}
+
+// All iterates over the records, stopping after the first error, which is yielded with a nil record. a clean end
+// of the log isn't an error.
+func (r *RecordReader) All() iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		for {
+			record, err := r.Next()
+			if err == io.EOF {
+				return
+			}
+			if !yield(record, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Seq returns the number of records read so far, close marks included.
+func (r *RecordReader) Seq() uint64 {
+	return r.seq
+}
+
+// Offset returns where the last good record ends in the log, the point to
+// truncate a damaged log to before resuming it.
+func (r *RecordReader) Offset() int64 {
+	return r.offset
+}
+
+// next reads and opens one record, closed is set for a close mark.
+func (r *RecordReader) next() (record []byte, closed bool, err error) {
+	var length [4]byte
+	n, err := io.ReadFull(r.r, length[:])
+	switch {
+	case err == io.EOF && r.closed:
+		return nil, false, io.EOF
+	case err == io.EOF:
+		return nil, false, errLogNotClosed
+	case err == io.ErrUnexpectedEOF:
+		return nil, false, &detailError{msg: "crypt: record log ends in a partial record", err: ErrTruncated}
+	case err != nil:
+		return nil, false, err
+	}
+
+	size := int(binary.BigEndian.Uint32(length[:]))
+	ns, overhead := r.aead.NonceSize(), r.aead.Overhead()
+	if size < ns+overhead || size > ns+MaxRecordSize+overhead {
+		return nil, false, r.recordError(ErrAuthenticationFailed)
+	}
+	if cap(r.buf) < size {
+		r.buf = make([]byte, size)
+	}
+	buf := r.buf[:size]
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, false, &detailError{msg: "crypt: record log ends in a partial record", err: ErrTruncated}
+		}
+		return nil, false, err
+	}
+
+	// a close mark is empty and has the top bit of its sequence number set
+	seq := r.seq
+	if size == ns+overhead {
+		_, err := r.aead.Open(nil, buf[:ns], buf[ns:], r.aad(seq|finalChunk, length[:]))
+		closed = err == nil
+	}
+	plain := buf[ns:ns]
+	if !closed {
+		if plain, err = r.aead.Open(plain, buf[:ns], buf[ns:], r.aad(seq, length[:])); err != nil {
+			return nil, false, r.recordError(ErrAuthenticationFailed)
+		}
+	}
+
+	r.seq++
+	r.offset += int64(n + size)
+	return plain, closed, nil
+}
+
+func (r *RecordReader) recordError(err error) error {
+	return &ChunkError{Chunk: int64(r.seq), Offset: r.offset, Err: err}
+}
@@ -0,0 +1,133 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// readRecords reads every record of log, returning them and the error
+// that ended the log.
+func readRecords(t *testing.T, log []byte, key *[32]byte) ([]string, error) {
+	t.Helper()
+	r, err := NewRecordReader(bytes.NewReader(log), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []string
+	for record, err := range r.All() {
+		if err != nil {
+			return records, err
+		}
+		records = append(records, string(record))
+	}
+	return records, nil
+}
+
+func TestRecordLog(t *testing.T) {
+	key := randKey()
+	var log bytes.Buffer
+	w, err := NewRecordWriter(&log, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for i := range 10 {
+		rec := fmt.Sprintf("record %d", i)
+		if i == 5 {
+			rec = ""
+		}
+		if err := w.Append([]byte(rec)); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, rec)
+	}
+
+	// before Close the log reads as possibly truncated
+	got, err := readRecords(t, log.Bytes(), key)
+	if !errors.Is(err, ErrTruncated) || fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("unclosed log: %q, %v", got, err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Append([]byte("late")); err != ErrClosed {
+		t.Fatalf("Append after Close: %v", err)
+	}
+	got, err = readRecords(t, log.Bytes(), key)
+	if err != nil || fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("closed log: %q, %v", got, err)
+	}
+
+	// resume and append more
+	w, err = ResumeRecordWriter(bytes.NewReader(log.Bytes()), &log, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Append([]byte("resumed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err = readRecords(t, log.Bytes(), key)
+	if err != nil || fmt.Sprint(got) != fmt.Sprint(append(want, "resumed")) {
+		t.Fatalf("resumed log: %q, %v", got, err)
+	}
+}
+
+func TestRecordLogDamage(t *testing.T) {
+	key := randKey()
+	var log bytes.Buffer
+	w, err := NewRecordWriter(&log, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ends []int
+	for i := range 5 {
+		w.Append([]byte(fmt.Sprintf("record %d", i)))
+		ends = append(ends, log.Len())
+	}
+	w.Close()
+	b := log.Bytes()
+
+	// a torn final write
+	got, err := readRecords(t, b[:ends[4]-3], key)
+	if !errors.Is(err, ErrTruncated) || len(got) != 4 {
+		t.Fatalf("torn log: %q, %v", got, err)
+	}
+	r, _ := NewRecordReader(bytes.NewReader(b[:ends[4]-3]), key)
+	for _, err := r.Next(); err == nil; _, err = r.Next() {
+	}
+	if r.Offset() != int64(ends[3]) {
+		t.Fatalf("Offset = %d, want %d", r.Offset(), ends[3])
+	}
+
+	// a record dropped from the middle
+	dropped := append(bytes.Clone(b[:ends[1]]), b[ends[2]:]...)
+	got, err = readRecords(t, dropped, key)
+	var ce *ChunkError
+	if !errors.As(err, &ce) || ce.Chunk != 2 || len(got) != 2 {
+		t.Fatalf("dropped record: %q, %v", got, err)
+	}
+
+	// a flipped bit
+	flipped := bytes.Clone(b)
+	flipped[ends[0]+10] ^= 1
+	if _, err := readRecords(t, flipped, key); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("flipped bit: %v", err)
+	}
+
+	// a huge length doesn't allocate
+	huge := append(bytes.Clone(b[:recordLogHeader]), 0xff, 0xff, 0xff, 0xff)
+	if _, err := readRecords(t, huge, key); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("huge length: %v", err)
+	}
+
+	if _, err := ResumeRecordWriter(bytes.NewReader(b[:ends[4]-3]), io.Discard, key); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("resuming a torn log: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package crypt
+
+import "io"
+
+// ReEncrypt decrypts the stream in src with oldKey and writes it to dst
+// encrypted with newKey, one chunk at a time so nothing close to the whole
+// plaintext is held in memory. the new stream keeps the chunk size and
+// cipher of the old one unless opts say otherwise, header fields such as a
+// key ID or KDF are not carried over. it returns the number of plaintext
+// bytes. if it fails part way through dst holds a partial stream, which a
+// Reader will reject as truncated.
+func ReEncrypt(dst io.Writer, src io.Reader, oldKey, newKey *[32]byte, opts ...Option) (int64, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return 0, err
+	}
+
+	h, _, err := readHeader(src)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.policy.check(h.cipher, h.version); err != nil {
+		return 0, err
+	}
+
+	r, err := newReader(src, oldKey, c, h)
+	if err != nil {
+		return 0, err
+	}
+
+	keep := []Option{WithChunkSize(h.chunkSize), WithCipher(h.cipher)}
+	if _, ok := h.fields[fieldNoncePrefix]; ok {
+		keep = append(keep, WithCounterNonces())
+	}
+	if interval, _ := h.rekeyInterval(); interval != 0 {
+		keep = append(keep, WithRekeyAfter(int64(interval)*int64(h.chunkSize)))
+	}
+	opts = append(keep, opts...)
+	w, err := NewWriter(dst, newKey, opts...)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := r.WriteTo(w)
+	if err != nil {
+		return n, err
+	}
+
+	return n, w.Close()
+}
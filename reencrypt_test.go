@@ -0,0 +1,71 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// TestReEncrypt makes sure the new stream only opens with the new key and
+// keeps the old parameters, and a damaged source is not passed on as a
+// complete stream.
+func TestReEncrypt(t *testing.T) {
+	t.Parallel()
+	oldKey, newKey := randKey(), randKey()
+	data := randBytes(10*64 + 1)
+
+	var old bytes.Buffer
+	w, err := NewWriter(&old, oldKey, WithChunkSize(64), WithCipher(ChaCha20Poly1305))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := old.Bytes()
+
+	var out bytes.Buffer
+	n, err := ReEncrypt(&out, bytes.NewReader(stream), oldKey, newKey, WithConcurrency(4))
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("ReEncrypt gave %d, %v", n, err)
+	}
+	if len(out.Bytes()) != len(stream) {
+		t.Fatal("parameters were not kept")
+	}
+
+	r, err := NewReader(bytes.NewReader(out.Bytes()), newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("data did not round trip")
+	}
+
+	r, err = NewReader(bytes.NewReader(out.Bytes()), oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("old key still opens the new stream")
+	}
+
+	out.Reset()
+	if _, err := ReEncrypt(&out, bytes.NewReader(stream[:len(stream)-1]), oldKey, newKey); err == nil {
+		t.Fatal("truncated source was accepted")
+	}
+	r, err = NewReader(&out, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("partial output gave %v, want ErrTruncated", err)
+	}
+}
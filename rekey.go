@@ -0,0 +1,154 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// rekeyCacheSize is how many epochs a rekeyAEAD keeps AEADs for, enough
+// for a batch of chunks to span a boundary without deriving keys twice.
+const rekeyCacheSize = 4
+
+// WithRekeyAfter makes a Writer switch to a new key after every n bytes of
+// plaintext, rounded down to whole chunks but at least one, to stay well
+// within the amount of data a cipher can safely seal with one key. each key
+// is derived from the one before with HKDF, starting from the stream key.
+// the interval is recorded in the header, so Readers know where the
+// switches are without being told.
+func WithRekeyAfter(n int64) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return errors.New("crypt: rekey interval must be positive")
+		}
+
+		c.rekey = n
+		return nil
+	}
+}
+
+// rekeyInterval returns the chunks per key of a stream, 0 if it doesn't
+// rekey.
+func (h *header) rekeyInterval() (uint64, error) {
+	v, ok := h.fields[fieldRekey]
+	if !ok {
+		return 0, nil
+	}
+	if len(v) != 8 || binary.BigEndian.Uint64(v) == 0 {
+		return 0, errBadHeader("invalid rekey field")
+	}
+
+	return binary.BigEndian.Uint64(v), nil
+}
+
+// nextKey ratchets key to the one for the next epoch.
+func nextKey(key *[32]byte) (*[32]byte, error) {
+	b, err := hkdf.Key(sha256.New, key[:], nil, rekeyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	return (*[32]byte)(b), nil
+}
+
+// rekeyAEAD picks the key for a chunk from the sequence number at the end
+// of its additional data (see chunkAAD), epoch seq/interval. it is safe for
+// concurrent use, chunks are sealed and opened in parallel.
+type rekeyAEAD struct {
+	newAEAD  func(key *[32]byte) (cipher.AEAD, error)
+	interval uint64
+
+	// first is the AEAD of epoch 0, whose sizes every epoch shares
+	first cipher.AEAD
+
+	mu sync.Mutex
+
+	// base is the stream key, needed to go back to an earlier epoch.
+	// latest is the key of epoch latestEpoch, for going forward
+	base        *[32]byte
+	latest      *[32]byte
+	latestEpoch uint64
+	cache       map[uint64]cipher.AEAD
+}
+
+func newRekeyAEAD(newAEAD func(key *[32]byte) (cipher.AEAD, error), key *[32]byte, interval uint64) (*rekeyAEAD, error) {
+	first, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	base := *key
+	return &rekeyAEAD{
+		newAEAD:  newAEAD,
+		interval: interval,
+		first:    first,
+		base:     &base,
+		latest:   &base,
+		cache:    map[uint64]cipher.AEAD{0: first},
+	}, nil
+}
+
+func (r *rekeyAEAD) NonceSize() int {
+	return r.first.NonceSize()
+}
+
+func (r *rekeyAEAD) Overhead() int {
+	return r.first.Overhead()
+}
+
+// epoch returns the AEAD for the chunk with additional data aad.
+func (r *rekeyAEAD) epoch(aad []byte) (cipher.AEAD, error) {
+	if len(aad) < sequenceSize {
+		return nil, errors.New("crypt: rekeyAEAD used for something other than a chunk")
+	}
+	e := binary.BigEndian.Uint64(aad[len(aad)-sequenceSize:]) &^ finalChunk / r.interval
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if aead, ok := r.cache[e]; ok {
+		return aead, nil
+	}
+
+	if e < r.latestEpoch {
+		r.latest, r.latestEpoch = r.base, 0
+	}
+	for r.latestEpoch < e {
+		key, err := nextKey(r.latest)
+		if err != nil {
+			return nil, err
+		}
+		r.latest, r.latestEpoch = key, r.latestEpoch+1
+	}
+	aead, err := r.newAEAD(r.latest)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(r.cache) == rekeyCacheSize {
+		oldest := e
+		for k := range r.cache {
+			oldest = min(oldest, k)
+		}
+		delete(r.cache, oldest)
+	}
+	r.cache[e] = aead
+	return aead, nil
+}
+
+func (r *rekeyAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	aead, err := r.epoch(additionalData)
+	if err != nil {
+		panic(err) // only for additional data too short to be a chunk's
+	}
+	return aead.Seal(dst, nonce, plaintext, additionalData)
+}
+
+func (r *rekeyAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := r.epoch(additionalData)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(dst, nonce, ciphertext, additionalData)
+}
@@ -0,0 +1,79 @@
+package crypt
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// TestRekey makes sure streams that rekey round trip, seal each epoch
+// with the ratcheted key and can be read out of order by ReaderAt.
+func TestRekey(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 16
+	data := randBytes(11*chunkSize + 5)
+
+	for _, counter := range []bool{false, true} {
+		opts := []Option{WithChunkSize(chunkSize), WithRekeyAfter(3*chunkSize + 7), WithConcurrency(4)}
+		if counter {
+			opts = append(opts, WithCounterNonces())
+		}
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, key, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		stream := buf.Bytes()
+
+		info, err := Inspect(bytes.NewReader(stream))
+		if err != nil || info.RekeyInterval != 3 {
+			t.Fatalf("got %+v, %v", info, err)
+		}
+
+		for _, c := range []int{1, 5} {
+			r, err := NewReader(bytes.NewReader(stream), key, WithConcurrency(c))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("concurrency %d: got %v", c, err)
+			}
+		}
+
+		ra, err := NewReaderAt(bytes.NewReader(stream), int64(len(stream)), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, off := range []int64{10 * chunkSize, 2, 7 * chunkSize, 4 * chunkSize} {
+			got := make([]byte, 5)
+			if _, err := ra.ReadAt(got, off); err != nil || !bytes.Equal(got, data[off:off+5]) {
+				t.Fatalf("ReadAt %d: got %v", off, err)
+			}
+		}
+
+		if counter {
+			continue
+		}
+		// chunk 4 is in the second epoch, sealed with the next key
+		h, raw, _ := readHeader(bytes.NewReader(stream))
+		frame := sequenceSize + DefaultCipher.nonceSize() + DefaultCipher.tagSize() + chunkSize
+		next, _ := nextKey(key)
+		aead, _ := DefaultCipher.NewAEAD(next)
+		chunk := bytes.Clone(stream[len(raw)+4*frame : len(raw)+5*frame])
+		if plain, _, err := openChunk(aead, newChunkAAD(h.aad()), chunk, 4); err != nil || !bytes.Equal(plain, data[4*chunkSize:5*chunkSize]) {
+			t.Fatalf("chunk 4 didn't open with the next key: %v", err)
+		}
+	}
+
+	if _, err := NewWriter(io.Discard, key, WithRekeyAfter(1<<30), WithArchiveProfile()); err == nil {
+		t.Fatal("rekeying was allowed with the archive profile")
+	}
+	if _, err := NewWriter(io.Discard, key, WithRekeyAfter(0)); err == nil {
+		t.Fatal("rekeyed after 0 bytes")
+	}
+}
@@ -0,0 +1,311 @@
+// Package ringbuf provides a fixed size encrypted ring buffer of records
+// kept in a file, for holding a rolling window of sensitive data such as
+// diagnostics at rest. once the ring is full each new record overwrites the
+// oldest, so the file never grows past the size given to Create.
+//
+// every slot holds one record padded to the record size, so the file reveals
+// how many records have been written but not how long they are. slots are
+// bound to their position and each record carries a sequence number, so
+// records can't be moved between slots and an old record put back in place
+// of a newer one is detected. like pagestore, a ring replaced as a whole by
+// an older copy of itself can not be detected, and neither can old records
+// put back over only the newest ones since that looks the same.
+//
+// a record is written with a single WriteAt, a crash part way through can
+// leave that one slot failing to authenticate. Records reports it rather
+// than hiding it, use Reset to start over.
+package ringbuf
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/UlisseMini/crypt"
+)
+
+// the file is a header
+//
+//	magic | version | cipher | slots | record size | ring id
+//
+// followed by the slots, each the nonce and sealed sequence number (8
+// bytes), record length (4 bytes) and record padded to the record size. a
+// slot that was never written is all zeros. the header and slot index are
+// the additional data of every slot.
+const (
+	magic   = "CRYPTRB"
+	version = 1
+
+	headerSize = len(magic) + 1 + 1 + 4 + 4 + 16
+
+	// recordHeaderSize is the sequence number and length in front of each
+	// record
+	recordHeaderSize = 8 + 4
+
+	// MaxRecordSize bounds the record size given to Create.
+	MaxRecordSize = 1 << 20
+
+	// maxSlots bounds the slot count given to Create.
+	maxSlots = 1 << 24
+)
+
+// File is the storage a Ring is kept in, *os.File satisfies it.
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// Record is a record read back from a Ring.
+type Record struct {
+	// Seq numbers the records in the order they were appended, starting
+	// from 0
+	Seq uint64
+
+	Data []byte
+}
+
+// Ring is an encrypted ring buffer of records, safe for concurrent use.
+type Ring struct {
+	f    File
+	aead cipher.AEAD
+
+	// header is the raw ring header
+	header     []byte
+	slots      int
+	recordSize int
+
+	mu sync.Mutex
+
+	// next is the sequence number of the next record
+	next uint64
+}
+
+// Create initialises a ring in f holding the last slots records of up to
+// recordSize bytes each, writing the whole file up front. anything already
+// in f is overwritten.
+func Create(f File, key *[32]byte, slots, recordSize int) (*Ring, error) {
+	if slots < 1 || slots > maxSlots {
+		return nil, errors.New("ringbuf: slots must be between 1 and 16777216")
+	}
+	if recordSize < 1 || recordSize > MaxRecordSize {
+		return nil, errors.New("ringbuf: record size must be between 1 byte and 1 MiB")
+	}
+
+	// records are rewritten forever, so random nonces need to be long
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic...)
+	header = append(header, version, byte(crypt.XChaCha20Poly1305))
+	header = binary.BigEndian.AppendUint32(header, uint32(slots))
+	header = binary.BigEndian.AppendUint32(header, uint32(recordSize))
+	header = header[:headerSize]
+	if _, err := io.ReadFull(rand.Reader, header[headerSize-16:]); err != nil {
+		return nil, err
+	}
+
+	r, err := newRing(f, key, header)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Reset(); err != nil {
+		return nil, err
+	}
+	if _, err := f.WriteAt(header, 0); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Open opens a ring made by Create, new records follow the newest one in
+// it.
+func Open(f File, key *[32]byte) (*Ring, error) {
+	header := make([]byte, headerSize)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return nil, errors.New("ringbuf: file is too short to hold a header")
+	}
+	if !bytes.HasPrefix(header, []byte(magic)) {
+		return nil, errors.New("ringbuf: not a ring buffer")
+	}
+	if header[len(magic)] != version {
+		return nil, errors.New("ringbuf: unsupported version")
+	}
+
+	r, err := newRing(f, key, header)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := r.Records()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) != 0 {
+		r.next = records[len(records)-1].Seq + 1
+	}
+
+	return r, nil
+}
+
+// newRing sets up a Ring from a raw header.
+func newRing(f File, key *[32]byte, header []byte) (*Ring, error) {
+	p := header[len(magic)+2:]
+	slots, recordSize := int(binary.BigEndian.Uint32(p)), int(binary.BigEndian.Uint32(p[4:]))
+	if slots < 1 || slots > maxSlots || recordSize < 1 || recordSize > MaxRecordSize {
+		return nil, errors.New("ringbuf: invalid header")
+	}
+
+	aead, err := crypt.Cipher(header[len(magic)+1]).NewAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Ring{f: f, aead: aead, header: header, slots: slots, recordSize: recordSize}, nil
+}
+
+// Slots returns how many records the ring holds.
+func (r *Ring) Slots() int {
+	return r.slots
+}
+
+// RecordSize returns the largest record the ring takes.
+func (r *Ring) RecordSize() int {
+	return r.recordSize
+}
+
+// slotSize is the size of a slot in the file.
+func (r *Ring) slotSize() int {
+	return r.aead.NonceSize() + recordHeaderSize + r.recordSize + r.aead.Overhead()
+}
+
+// slotOffset returns where slot n starts in the file.
+func (r *Ring) slotOffset(n int) int64 {
+	return int64(headerSize) + int64(n)*int64(r.slotSize())
+}
+
+// aad returns the additional data for slot n.
+func (r *Ring) aad(n int) []byte {
+	return binary.BigEndian.AppendUint64(bytes.Clone(r.header), uint64(n))
+}
+
+// Append adds record to the ring, overwriting the oldest record once the
+// ring is full.
+func (r *Ring) Append(record []byte) error {
+	if len(record) > r.recordSize {
+		return errors.New("ringbuf: record is larger than the record size")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	plain := make([]byte, recordHeaderSize+r.recordSize)
+	binary.BigEndian.PutUint64(plain, r.next)
+	binary.BigEndian.PutUint32(plain[8:], uint32(len(record)))
+	copy(plain[recordHeaderSize:], record)
+
+	nonce := make([]byte, r.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	n := int(r.next % uint64(r.slots))
+	if _, err := r.f.WriteAt(r.aead.Seal(nonce, nonce, plain, r.aad(n)), r.slotOffset(n)); err != nil {
+		return err
+	}
+
+	r.next++
+	return nil
+}
+
+// Records decrypts every record in the ring, oldest first.
+func (r *Ring) Records() ([]Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var records []Record
+	empty := false
+	sealed := make([]byte, r.slotSize())
+	for n := range r.slots {
+		if _, err := r.f.ReadAt(sealed, r.slotOffset(n)); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, errors.New("ringbuf: file is truncated")
+			}
+			return nil, err
+		}
+		if !slices.ContainsFunc(sealed, func(b byte) bool { return b != 0 }) {
+			empty = true
+			continue
+		}
+
+		ns := r.aead.NonceSize()
+		plain, err := r.aead.Open(nil, sealed[:ns], sealed[ns:], r.aad(n))
+		if err != nil {
+			return nil, errors.New("ringbuf: record failed to authenticate")
+		}
+		seq := binary.BigEndian.Uint64(plain)
+		length := int(binary.BigEndian.Uint32(plain[8:]))
+		if seq%uint64(r.slots) != uint64(n) || length > r.recordSize {
+			return nil, errors.New("ringbuf: invalid record")
+		}
+
+		records = append(records, Record{Seq: seq, Data: plain[recordHeaderSize : recordHeaderSize+length]})
+	}
+
+	slices.SortFunc(records, func(a, b Record) int { return cmp.Compare(a.Seq, b.Seq) })
+
+	// the records have to be consecutive, anything else means a slot holds
+	// a record older than the ones around it
+	for i := 1; i < len(records); i++ {
+		if records[i].Seq != records[i-1].Seq+1 {
+			return nil, errors.New("ringbuf: ring holds a stale record")
+		}
+	}
+	if len(records) != 0 && records[len(records)-1].Seq+1 < r.next {
+		return nil, errors.New("ringbuf: ring holds a stale record")
+	}
+
+	// once the ring has wrapped around no slot can be empty
+	if empty && len(records) != 0 && records[len(records)-1].Seq >= uint64(r.slots) {
+		return nil, errors.New("ringbuf: ring is missing a record")
+	}
+
+	return records, nil
+}
+
+// Export writes every record in the ring to w decrypted, oldest first, each
+// prefixed with its length as a big endian uint32.
+func (r *Ring) Export(w io.Writer) error {
+	records, err := r.Records()
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		b := binary.BigEndian.AppendUint32(nil, uint32(len(rec.Data)))
+		if _, err := w.Write(append(b, rec.Data...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset empties the ring by zeroing every slot, sequence numbers start from
+// 0 again.
+func (r *Ring) Reset() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	zeros := make([]byte, r.slotSize())
+	for n := range r.slots {
+		if _, err := r.f.WriteAt(zeros, r.slotOffset(n)); err != nil {
+			return err
+		}
+	}
+
+	r.next = 0
+	return nil
+}
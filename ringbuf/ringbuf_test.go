@@ -0,0 +1,151 @@
+package ringbuf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// memFile is an in memory File.
+type memFile struct {
+	b []byte
+}
+
+func (m *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m.b)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, m.b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(m.b) {
+		m.b = append(m.b, make([]byte, end-len(m.b))...)
+	}
+
+	return copy(m.b[off:], p), nil
+}
+
+func randKey() *[32]byte {
+	key := &[32]byte{}
+	rand.Read(key[:])
+	return key
+}
+
+// TestRing appends past the size of the ring and makes sure only the
+// newest records are kept, across reopening the file.
+func TestRing(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	f, err := os.Create(filepath.Join(t.TempDir(), "ring"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := Create(f, key, 4, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _ := f.Stat()
+	size := st.Size()
+
+	for i := range 3 {
+		if err := r.Append([]byte("record " + strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err = Open(f, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 3; i < 10; i++ {
+		r.Append([]byte("record " + strconv.Itoa(i)))
+	}
+
+	records, err := r.Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("got %d records", len(records))
+	}
+	for i, rec := range records {
+		if want := 6 + i; rec.Seq != uint64(want) || string(rec.Data) != "record "+strconv.Itoa(want) {
+			t.Fatalf("record %d is %d %q", i, rec.Seq, rec.Data)
+		}
+	}
+	if st, _ := f.Stat(); st.Size() != size {
+		t.Fatalf("file grew from %d to %d bytes", size, st.Size())
+	}
+
+	var buf bytes.Buffer
+	if err := r.Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if n := binary.BigEndian.Uint32(buf.Bytes()); n != 8 || string(buf.Bytes()[4:12]) != "record 6" {
+		t.Fatalf("export starts with %q", buf.Bytes()[:12])
+	}
+
+	if err := r.Append(make([]byte, 33)); err == nil {
+		t.Fatal("appended a record over the record size")
+	}
+	if _, err := Open(f, randKey()); err == nil {
+		t.Fatal("opened with the wrong key")
+	}
+
+	if err := r.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if records, err := r.Records(); err != nil || len(records) != 0 {
+		t.Fatalf("reset left %d records, %v", len(records), err)
+	}
+}
+
+// TestTamper makes sure damaged, moved and replayed slots are caught.
+func TestTamper(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	f := &memFile{}
+	r, err := Create(f, key, 3, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slot := func(n int) []byte {
+		off := r.slotOffset(n)
+		return f.b[off : off+int64(r.slotSize())]
+	}
+
+	r.Append([]byte("a"))
+	r.Append([]byte("b"))
+	old := bytes.Clone(slot(1))
+	for _, s := range []string{"c", "d", "e", "f"} {
+		r.Append([]byte(s))
+	}
+	good := bytes.Clone(f.b)
+
+	tamper := map[string]func(){
+		"flipped bit":   func() { slot(2)[5] ^= 1 },
+		"swapped slots": func() { s := bytes.Clone(slot(0)); copy(slot(0), slot(1)); copy(slot(1), s) },
+		"replayed":      func() { copy(slot(1), old) },
+		"zeroed":        func() { clear(slot(1)) },
+		"truncated":     func() { f.b = f.b[:len(f.b)-1] },
+	}
+	for name, fn := range tamper {
+		f.b = bytes.Clone(good)
+		fn()
+		if _, err := Open(f, key); err == nil {
+			t.Fatalf("%s: opened", name)
+		}
+	}
+}
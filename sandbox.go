@@ -0,0 +1,123 @@
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// sandboxEnv marks a process started by a Sandbox as a worker.
+const sandboxEnv = "CRYPT_SANDBOX_WORKER"
+
+// Sandbox decrypts untrusted input in a separate process, so a bug in
+// parsing or decryption can at worst take over a process that holds one
+// key and one ciphertext, not the service that started it. the worker is
+// either the current program re-executed, which must call SandboxMain first
+// thing in main, or a separate binary such as cmd/crypt-worker.
+type Sandbox struct {
+	// Path is the worker binary, empty means the current program
+	Path string
+
+	// SysProcAttr is passed on to the worker, use it to drop privileges,
+	// e.g. set Credential to run as an unprivileged user, or Cloneflags
+	// for new namespaces on linux
+	SysProcAttr *syscall.SysProcAttr
+}
+
+// SandboxMain runs the sandbox worker and exits if the process was started
+// by a Sandbox, otherwise it returns straight away. programs that use a
+// Sandbox without a Path must call it at the start of main.
+func SandboxMain() {
+	if os.Getenv(sandboxEnv) == "" {
+		return
+	}
+
+	if err := SandboxWorker(os.Stdout, os.Stdin); err != nil {
+		os.Stderr.WriteString(err.Error())
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// SandboxWorker serves one request from a Sandbox, reading the key and
+// ciphertext from src and writing the plaintext to dst. it is what
+// SandboxMain and cmd/crypt-worker run.
+func SandboxWorker(dst io.Writer, src io.Reader) error {
+	key := &[32]byte{}
+	if _, err := io.ReadFull(src, key[:]); err != nil {
+		return errors.New("crypt: sandbox request has no key")
+	}
+
+	// streams start with a header, anything else is from Encrypt
+	br := bufio.NewReader(src)
+	if magic, _ := br.Peek(len(headerMagic)); string(magic) == headerMagic {
+		r, err := NewReader(br, key)
+		if err != nil {
+			return err
+		}
+
+		bw := bufio.NewWriter(dst)
+		if _, err := r.WriteTo(bw); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+
+	ciphertext, err := io.ReadAll(br)
+	if err != nil {
+		return err
+	}
+	plaintext, err := Decrypt(ciphertext, key)
+	if err != nil {
+		return err
+	}
+
+	_, err = dst.Write(plaintext)
+	return err
+}
+
+// Decrypt is Decrypt run in the sandbox.
+func (s *Sandbox) Decrypt(ciphertext []byte, key *[32]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := s.DecryptStream(&buf, bytes.NewReader(ciphertext), key); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DecryptStream decrypts src, either a stream or output from Encrypt, in the
+// sandbox and writes the plaintext to dst. like a Reader, plaintext from
+// authenticated chunks may have been written to dst before an error.
+func (s *Sandbox) DecryptStream(dst io.Writer, src io.Reader, key *[32]byte) error {
+	path := s.Path
+	if path == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		path = exe
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(path)
+	cmd.Env = []string{sandboxEnv + "=1"}
+	cmd.Stdin = io.MultiReader(bytes.NewReader(key[:]), src)
+	cmd.Stdout = dst
+	cmd.Stderr = &stderr
+	cmd.SysProcAttr = s.SysProcAttr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.New("crypt: sandbox: " + msg)
+		}
+		return err
+	}
+
+	return nil
+}
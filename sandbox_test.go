@@ -0,0 +1,39 @@
+package crypt
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// TestMain lets the test binary act as a sandbox worker.
+func TestMain(m *testing.M) {
+	SandboxMain()
+	os.Exit(m.Run())
+}
+
+// TestSandbox decrypts both formats in a worker process.
+func TestSandbox(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	data := randBytes(200)
+	s := &Sandbox{}
+
+	encrypted, err := Encrypt(data, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ciphertext := range [][]byte{encrypted, sealStream(t, key, data, 64)} {
+		got, err := s.Decrypt(ciphertext, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatal("data did not round trip")
+		}
+	}
+
+	if _, err := s.Decrypt(encrypted, randKey()); err == nil {
+		t.Fatal("wrong key was accepted")
+	}
+}
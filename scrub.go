@@ -0,0 +1,81 @@
+package crypt
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// sensitive holds the buffers registered with RegisterSensitive.
+var sensitive struct {
+	mu   sync.Mutex
+	next int
+	bufs map[int][]byte
+}
+
+// RegisterSensitive records b, e.g. a key or a plaintext buffer, to be
+// zeroed by WipeSensitive, ScrubOnPanic and ScrubOnSignal, so it doesn't
+// end up in a core dump or crash report. call unregister once b is no
+// longer in use.
+func RegisterSensitive(b []byte) (unregister func()) {
+	sensitive.mu.Lock()
+	defer sensitive.mu.Unlock()
+
+	if sensitive.bufs == nil {
+		sensitive.bufs = make(map[int][]byte)
+	}
+	id := sensitive.next
+	sensitive.next++
+	sensitive.bufs[id] = b
+
+	return func() {
+		sensitive.mu.Lock()
+		defer sensitive.mu.Unlock()
+		delete(sensitive.bufs, id)
+	}
+}
+
+// WipeSensitive zeroes every buffer registered with RegisterSensitive.
+// anything still using them will see zeros afterwards, it is meant for when
+// the process is about to die.
+func WipeSensitive() {
+	sensitive.mu.Lock()
+	defer sensitive.mu.Unlock()
+
+	for _, b := range sensitive.bufs {
+		clear(b)
+	}
+}
+
+// ScrubOnPanic wipes the registered buffers if the goroutine is panicking,
+// then carries on panicking. it must be deferred directly:
+//
+//	defer crypt.ScrubOnPanic()
+//
+// panics in other goroutines are not seen, each one needs its own.
+func ScrubOnPanic() {
+	if r := recover(); r != nil {
+		WipeSensitive()
+		panic(r)
+	}
+}
+
+// ScrubOnSignal wipes the registered buffers when the process receives one
+// of sigs, then delivers the signal again with its default behaviour, so
+// e.g. SIGQUIT or SIGABRT still dump core, just without the keys. signals
+// the Go runtime turns into panics, like SIGSEGV in Go code, are covered by
+// ScrubOnPanic instead.
+func ScrubOnSignal(sigs ...os.Signal) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+
+	go func() {
+		sig := <-c
+		WipeSensitive()
+		signal.Reset(sigs...)
+
+		if p, err := os.FindProcess(os.Getpid()); err == nil {
+			p.Signal(sig)
+		}
+	}()
+}
@@ -0,0 +1,32 @@
+package crypt
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestScrub makes sure registered buffers are wiped on a panic, and
+// unregistered ones are left alone.
+func TestScrub(t *testing.T) {
+	key, kept := randBytes(32), randBytes(32)
+	unregister := RegisterSensitive(key)
+	defer unregister()
+	RegisterSensitive(kept)()
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic was swallowed")
+			}
+		}()
+		defer ScrubOnPanic()
+		panic("boom")
+	}()
+
+	if !bytes.Equal(key, make([]byte, 32)) {
+		t.Fatal("registered buffer was not wiped")
+	}
+	if bytes.Equal(kept, make([]byte, 32)) {
+		t.Fatal("unregistered buffer was wiped")
+	}
+}
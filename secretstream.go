@@ -0,0 +1,402 @@
+package crypt
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/poly1305"
+)
+
+// this file implements libsodium's crypto_secretstream_xchacha20poly1305,
+// so streams can be exchanged with anything built on libsodium. a stream is
+// a 24 byte header followed by messages, each 17 bytes longer than its
+// plaintext and carrying a tag. libsodium leaves framing the messages to
+// the application, SecretStreamWriter and SecretStreamReader use the common
+// one of fixed size messages with the last one tagged SecretStreamFinal.
+
+// secretstream message tags, the same values as libsodium's.
+const (
+	SecretStreamMessage byte = 0
+	SecretStreamPush    byte = 1
+	SecretStreamRekey   byte = 2
+	SecretStreamFinal   byte = SecretStreamPush | SecretStreamRekey
+)
+
+const (
+	// SecretStreamHeaderSize is the size of the header at the start of a
+	// secretstream.
+	SecretStreamHeaderSize = 24
+
+	// SecretStreamOverhead is how much longer a message is than its
+	// plaintext, a tag byte and a poly1305 tag.
+	SecretStreamOverhead = 1 + poly1305.TagSize
+
+	secretStreamCounterSize = 4
+	secretStreamINonceSize  = 8
+)
+
+// secretStream is the state shared by SecretStreamEncoder and
+// SecretStreamDecoder, a key and a nonce made of a little endian counter and
+// the inonce.
+type secretStream struct {
+	k     [32]byte
+	nonce [chacha20.NonceSize]byte
+}
+
+// SecretStreamEncoder encrypts secretstream messages one at a time.
+type SecretStreamEncoder struct {
+	secretStream
+}
+
+// SecretStreamDecoder decrypts secretstream messages one at a time, in the
+// order they were encrypted.
+type SecretStreamDecoder struct {
+	secretStream
+}
+
+// NewSecretStreamEncoder returns an encoder and the header that has to be
+// sent before its messages.
+func NewSecretStreamEncoder(key *[32]byte) (*SecretStreamEncoder, []byte, error) {
+	header, err := newNonce(randReader, SecretStreamHeaderSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	e := &SecretStreamEncoder{}
+	if err := e.init(key, header); err != nil {
+		return nil, nil, err
+	}
+
+	return e, header, nil
+}
+
+// NewSecretStreamDecoder returns a decoder for the stream starting with
+// header.
+func NewSecretStreamDecoder(key *[32]byte, header []byte) (*SecretStreamDecoder, error) {
+	if len(header) != SecretStreamHeaderSize {
+		return nil, errors.New("crypt: invalid secretstream header")
+	}
+
+	d := &SecretStreamDecoder{}
+	if err := d.init(key, header); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (s *secretStream) init(key *[32]byte, header []byte) error {
+	k, err := chacha20.HChaCha20(key[:], header[:16])
+	if err != nil {
+		return err
+	}
+
+	copy(s.k[:], k)
+	s.resetCounter()
+	copy(s.nonce[secretStreamCounterSize:], header[16:])
+	return nil
+}
+
+func (s *secretStream) resetCounter() {
+	binary.LittleEndian.PutUint32(s.nonce[:secretStreamCounterSize], 1)
+}
+
+// Push encrypts msg with additional data ad and tag. a message tagged
+// SecretStreamRekey or SecretStreamFinal rekeys the stream after it.
+func (e *SecretStreamEncoder) Push(msg, ad []byte, tag byte) ([]byte, error) {
+	out := make([]byte, len(msg)+SecretStreamOverhead)
+
+	c, mac, err := e.stream(ad)
+	if err != nil {
+		return nil, err
+	}
+
+	// the tag goes in a 64 byte block of its own, keystream block 1
+	var block [64]byte
+	block[0] = tag
+	c.XORKeyStream(block[:], block[:])
+	mac.Write(block[:])
+	out[0] = block[0]
+
+	// the message starts at keystream block 2
+	ct := out[1 : 1+len(msg)]
+	c.XORKeyStream(ct, msg)
+
+	tagOut := e.finish(mac, ad, ct)
+	copy(out[1+len(msg):], tagOut[:])
+	e.advance(tag, tagOut[:])
+	return out, nil
+}
+
+// Rekey switches to a new key without telling the other side, the other
+// side has to call Rekey at the same point in the stream.
+func (s *secretStream) Rekey() {
+	var buf [32 + secretStreamINonceSize]byte
+	copy(buf[:], s.k[:])
+	copy(buf[32:], s.nonce[secretStreamCounterSize:])
+
+	c, err := chacha20.NewUnauthenticatedCipher(s.k[:], s.nonce[:])
+	if err != nil {
+		panic(err) // only happens for the wrong key or nonce length
+	}
+	c.XORKeyStream(buf[:], buf[:])
+
+	copy(s.k[:], buf[:32])
+	copy(s.nonce[secretStreamCounterSize:], buf[32:])
+	s.resetCounter()
+}
+
+// Pull decrypts msg with additional data ad, returning its plaintext
+// and tag.
+func (d *SecretStreamDecoder) Pull(msg, ad []byte) ([]byte, byte, error) {
+	if len(msg) < SecretStreamOverhead {
+		return nil, 0, errors.New("crypt: secretstream message is too short")
+	}
+	ct := msg[1 : len(msg)-poly1305.TagSize]
+
+	c, mac, err := d.stream(ad)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var block [64]byte
+	block[0] = msg[0]
+	c.XORKeyStream(block[:], block[:])
+	tag := block[0]
+	block[0] = msg[0]
+	mac.Write(block[:])
+
+	want := d.finish(mac, ad, ct)
+	if subtle.ConstantTimeCompare(want[:], msg[len(msg)-poly1305.TagSize:]) != 1 {
+		return nil, 0, errors.New("crypt: secretstream message failed to authenticate")
+	}
+
+	out := make([]byte, len(ct))
+	c.XORKeyStream(out, ct)
+	d.advance(tag, want[:])
+	return out, tag, nil
+}
+
+// stream returns the cipher for the current message, positioned at block 1,
+// and a poly1305 MAC keyed from block 0 with ad already written.
+func (s *secretStream) stream(ad []byte) (*chacha20.Cipher, *poly1305.MAC, error) {
+	c, err := chacha20.NewUnauthenticatedCipher(s.k[:], s.nonce[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var polyKey [32]byte
+	c.XORKeyStream(polyKey[:], polyKey[:])
+	c.SetCounter(1)
+
+	var pad [16]byte
+	mac := poly1305.New(&polyKey)
+	mac.Write(ad)
+	mac.Write(pad[:(16-len(ad)%16)%16])
+	return c, mac, nil
+}
+
+// finish authenticates the ciphertext and the lengths of it and ad, after
+// the tag block.
+func (s *secretStream) finish(mac *poly1305.MAC, ad, ct []byte) [poly1305.TagSize]byte {
+	// libsodium computes the padding as (0x10 - 64 + mlen) & 0xf, which is
+	// mlen & 0xf rather than what it pads up to, it has to match
+	var pad [16]byte
+	mac.Write(ct)
+	mac.Write(pad[:len(ct)%16])
+
+	var lens [16]byte
+	binary.LittleEndian.PutUint64(lens[:8], uint64(len(ad)))
+	binary.LittleEndian.PutUint64(lens[8:], uint64(64+len(ct)))
+	mac.Write(lens[:])
+
+	var tag [poly1305.TagSize]byte
+	mac.Sum(tag[:0])
+	return tag
+}
+
+// advance mixes the MAC into the nonce and moves on to the next message,
+// rekeying when asked to or when the counter wraps.
+func (s *secretStream) advance(tag byte, mac []byte) {
+	for i := range secretStreamINonceSize {
+		s.nonce[secretStreamCounterSize+i] ^= mac[i]
+	}
+
+	counter := binary.LittleEndian.Uint32(s.nonce[:secretStreamCounterSize]) + 1
+	binary.LittleEndian.PutUint32(s.nonce[:secretStreamCounterSize], counter)
+	if tag&SecretStreamRekey != 0 || counter == 0 {
+		s.Rekey()
+	}
+}
+
+// SecretStreamWriter encrypts a stream of bytes as a secretstream of
+// messages holding the chunk size set by WithChunkSize, the last one tagged
+// SecretStreamFinal. the reader has to use the same chunk size.
+type SecretStreamWriter struct {
+	w   io.Writer
+	enc *SecretStreamEncoder
+
+	// buf holds a chunk of plaintext, a full chunk is held back in case it
+	// is the final one
+	buf       []byte
+	chunkSize int
+	err       error
+}
+
+// NewSecretStreamWriter writes the secretstream header to w and returns a
+// SecretStreamWriter. only WithChunkSize applies, libsodium has a single
+// cipher. Close must be called to write the final message.
+func NewSecretStreamWriter(w io.Writer, key *[32]byte, opts ...Option) (*SecretStreamWriter, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	enc, header, err := NewSecretStreamEncoder(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &SecretStreamWriter{
+		w:         w,
+		enc:       enc,
+		buf:       make([]byte, 0, c.chunkSize),
+		chunkSize: c.chunkSize,
+	}, nil
+}
+
+// Write encrypts p, messages are written once they are full and more data
+// arrives.
+func (w *SecretStreamWriter) Write(p []byte) (total int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	for len(p) > 0 {
+		if len(w.buf) == w.chunkSize {
+			if err := w.flush(SecretStreamMessage); err != nil {
+				return total, err
+			}
+		}
+
+		n := min(len(p), w.chunkSize-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		total += n
+	}
+
+	return total, nil
+}
+
+// Close writes the final message, it does not close the underlying writer.
+func (w *SecretStreamWriter) Close() error {
+	if w.err == ErrClosed {
+		return nil
+	} else if w.err != nil {
+		return w.err
+	}
+
+	if err := w.flush(SecretStreamFinal); err != nil {
+		return err
+	}
+	w.err = ErrClosed
+	return nil
+}
+
+func (w *SecretStreamWriter) flush(tag byte) error {
+	msg, err := w.enc.Push(w.buf, nil, tag)
+	if err == nil {
+		_, err = w.w.Write(msg)
+	}
+	if err != nil {
+		w.err = err
+		return err
+	}
+
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// SecretStreamReader decrypts a stream made by SecretStreamWriter, or by
+// libsodium with the same framing.
+type SecretStreamReader struct {
+	r   io.Reader
+	dec *SecretStreamDecoder
+
+	buf   []byte
+	plain []byte
+	eof   bool
+}
+
+// NewSecretStreamReader reads the secretstream header from r and returns a
+// SecretStreamReader, the chunk size set by WithChunkSize must match the
+// writer's.
+func NewSecretStreamReader(r io.Reader, key *[32]byte, opts ...Option) (*SecretStreamReader, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, SecretStreamHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrTruncated
+	}
+	dec, err := NewSecretStreamDecoder(key, header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretStreamReader{r: r, dec: dec, buf: make([]byte, c.chunkSize+SecretStreamOverhead)}, nil
+}
+
+// Read decrypts data into p.
+func (r *SecretStreamReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next decrypts the next message, only the final one may be short and
+// nothing may follow it.
+func (r *SecretStreamReader) next() error {
+	n, err := io.ReadFull(r.r, r.buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		if n < SecretStreamOverhead {
+			return ErrTruncated
+		}
+	} else if err != nil {
+		return err
+	}
+
+	plain, tag, err := r.dec.Pull(r.buf[:n], nil)
+	if err != nil {
+		return err
+	}
+
+	if tag == SecretStreamFinal {
+		var b [1]byte
+		if n, _ := io.ReadFull(r.r, b[:]); n != 0 {
+			return errors.New("crypt: trailing data after secretstream final message")
+		}
+		r.eof = true
+	} else if n < len(r.buf) {
+		return ErrTruncated
+	}
+
+	r.plain = plain
+	return nil
+}
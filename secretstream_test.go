@@ -0,0 +1,135 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+)
+
+// secretStreamVector was made by libsodium with the key 0, 1, ... 31, the
+// first line is the header.
+var secretStreamVector = []struct {
+	msg, ad string
+	tag     byte
+	ct      string
+}{
+	{ct: "0fc1b8f1ab912911b9a6d75f79524bf79963dd6aef7edadd"},
+	{"hello", "ad1", SecretStreamMessage, "b841623c58c282a694c021d05b87fd234229b37bcd8d"},
+	{string(bytes.Repeat([]byte{'x'}, 100)), "", SecretStreamRekey, "d5941fb3011723401917862e3b913de1b3e2b30cc274f4a9e98df40c628ad7edf864561c4ab2a18715994c1aabc17f5f721a07bf9781c1b0ff0061da9c6f29498b095312ea842f97d2898e1b7add93845373decfef84c9a6f2f3b16f5c630c3547f5d332ba31790df7856ed8ab5ffcded16035eed6"},
+	{"", "", SecretStreamPush, "fea1b9dfd3c945bebf1e8e08d8674a2116"},
+	{"after rekey", "ad", SecretStreamMessage, "7e111cd06db820b9a7cdab49df403b702f23e3d43bb1e630c3d01671"},
+	{"bye", "", SecretStreamFinal, "3b1768b1ec70a18b7e053744975648f235e366a4"},
+}
+
+// TestSecretStreamVector decrypts messages made by libsodium and makes sure
+// encrypting them again with the same header gives the same bytes.
+func TestSecretStreamVector(t *testing.T) {
+	t.Parallel()
+	var key [32]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	header, _ := hex.DecodeString(secretStreamVector[0].ct)
+	d, err := NewSecretStreamDecoder(&key, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &SecretStreamEncoder{}
+	if err := e.init(&key, header); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, v := range secretStreamVector[1:] {
+		ct, _ := hex.DecodeString(v.ct)
+		msg, tag, err := d.Pull(ct, []byte(v.ad))
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if string(msg) != v.msg || tag != v.tag {
+			t.Fatalf("message %d: got %q tag %d", i, msg, tag)
+		}
+
+		got, err := e.Push([]byte(v.msg), []byte(v.ad), v.tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, ct) {
+			t.Fatalf("message %d encrypted differently", i)
+		}
+	}
+}
+
+// TestSecretStreamRekey checks an explicit Rekey on both sides.
+func TestSecretStreamRekey(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	e, header, err := NewSecretStreamEncoder(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewSecretStreamDecoder(key, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.Rekey()
+	ct, _ := e.Push([]byte("hi"), nil, SecretStreamMessage)
+	if _, _, err := d.Pull(ct, nil); err == nil {
+		t.Fatal("decrypted without rekeying")
+	}
+
+	d, _ = NewSecretStreamDecoder(key, header)
+	d.Rekey()
+	msg, _, err := d.Pull(ct, nil)
+	if err != nil || string(msg) != "hi" {
+		t.Fatalf("got %q, %v", msg, err)
+	}
+}
+
+// TestSecretStreamWriter round trips streams of sizes around the chunk size
+// and checks truncated streams are caught.
+func TestSecretStreamWriter(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 1024
+
+	for _, size := range []int{0, 1, chunkSize, chunkSize + 1, 5 * chunkSize} {
+		data := randBytes(size)
+		var buf bytes.Buffer
+		w, err := NewSecretStreamWriter(&buf, key, WithChunkSize(chunkSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := NewSecretStreamReader(bytes.NewReader(buf.Bytes()), key, WithChunkSize(chunkSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("size %d did not round trip", size)
+		}
+
+		// cut at a message boundary, so only the missing final tag gives it
+		// away
+		if size > chunkSize {
+			cut := SecretStreamHeaderSize + chunkSize + SecretStreamOverhead
+			r, _ := NewSecretStreamReader(bytes.NewReader(buf.Bytes()[:cut]), key, WithChunkSize(chunkSize))
+			if _, err := io.ReadAll(r); !errors.Is(err, ErrTruncated) {
+				t.Fatalf("size %d: got %v for a truncated stream", size, err)
+			}
+		}
+	}
+}
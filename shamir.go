@@ -0,0 +1,146 @@
+package crypt
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// shares are version (1) | threshold (1) | x (1) | y (32) | check (8).
+// every byte of the key is split with its own random polynomial over
+// GF(2^8), y holds the polynomials at x. check is the same in every share
+// of a key, derived from the key so a wrong combination is caught.
+const (
+	shareVersion = 1
+	shareSize    = 3 + 32 + shareCheckSize
+
+	shareCheckSize = 8
+	shareCheckInfo = "crypt key share check v1\x00"
+)
+
+// SplitKey splits key into n shares such that any k of them give back the
+// key with CombineKey while fewer reveal nothing about it, so a master key
+// can be held by n operators and recovered by any k. 1 < k <= n <= 255.
+//
+// shares hold a check value derived from the key, so the wrong shares are
+// caught rather than combining to a wrong key. it can't stop a share holder
+// from handing over a forged share.
+func SplitKey(key *[32]byte, n, k int) ([][]byte, error) {
+	if k < 2 || k > n || n > 255 {
+		return nil, errors.New("crypt: SplitKey needs 1 < k <= n <= 255")
+	}
+	check, err := shareCheck(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// coeffs[i] holds the k-1 random coefficients of byte i's polynomial
+	coeffs := make([]byte, 32*(k-1))
+	if _, err := io.ReadFull(randReader, coeffs); err != nil {
+		return nil, err
+	}
+	defer clear(coeffs)
+
+	shares := make([][]byte, n)
+	for s := range shares {
+		x := byte(s + 1)
+		share := append(make([]byte, 0, shareSize), shareVersion, byte(k), x)
+		for i, b := range key {
+			// Horner's method, highest coefficient first
+			var y byte
+			for j := k - 2; j >= 0; j-- {
+				y = gfMul(y, x) ^ coeffs[i*(k-1)+j]
+			}
+			share = append(share, gfMul(y, x)^b)
+		}
+		shares[s] = append(share, check...)
+	}
+	return shares, nil
+}
+
+// CombineKey recovers a key from at least the threshold of its shares made
+// by SplitKey. it fails with ErrAuthenticationFailed if the shares don't
+// belong together.
+func CombineKey(shares [][]byte) (*[32]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("crypt: no shares")
+	}
+	for _, s := range shares {
+		if len(s) != shareSize || s[0] != shareVersion {
+			return nil, errors.New("crypt: invalid key share")
+		}
+	}
+	k := int(shares[0][1])
+	if len(shares) < k {
+		return nil, errors.New("crypt: need at least " + strconv.Itoa(k) + " shares")
+	}
+	shares = shares[:k]
+	seen := make(map[byte]bool, k)
+	for _, s := range shares {
+		if int(s[1]) != k || s[2] == 0 || seen[s[2]] {
+			return nil, errors.New("crypt: key shares don't belong together")
+		}
+		seen[s[2]] = true
+	}
+
+	// Lagrange interpolation at 0, basis[i] is the weight of share i
+	basis := make([]byte, k)
+	for i, si := range shares {
+		num, den := byte(1), byte(1)
+		for j, sj := range shares {
+			if i != j {
+				num = gfMul(num, sj[2])
+				den = gfMul(den, sj[2]^si[2])
+			}
+		}
+		basis[i] = gfMul(num, gfInv(den))
+	}
+
+	key := &[32]byte{}
+	for b := range key {
+		for i, s := range shares {
+			key[b] ^= gfMul(s[3+b], basis[i])
+		}
+	}
+
+	check, err := shareCheck(key)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(check, shares[0][3+32:]) != 1 {
+		return nil, &detailError{msg: "crypt: key shares don't combine to their key", err: ErrAuthenticationFailed}
+	}
+	return key, nil
+}
+
+// shareCheck returns the check value the shares of key carry.
+func shareCheck(key *[32]byte) ([]byte, error) {
+	check, err := deriveKey(key, shareCheckInfo)
+	if err != nil {
+		return nil, err
+	}
+	return check[:shareCheckSize], nil
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial, without branching
+// or table lookups on the values.
+func gfMul(a, b byte) byte {
+	var p byte
+	for range 8 {
+		p ^= a & -(b & 1)
+		a = a<<1 ^ 0x1b&-(a>>7)
+		b >>= 1
+	}
+	return p
+}
+
+// gfInv returns the inverse of a in GF(2^8), a^254.
+func gfInv(a byte) byte {
+	r := a
+	for range 6 {
+		a = gfMul(a, a)
+		r = gfMul(r, a)
+	}
+	return gfMul(r, r)
+}
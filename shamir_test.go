@@ -0,0 +1,61 @@
+package crypt
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestGF256(t *testing.T) {
+	// FIPS 197 section 4.2
+	if got := gfMul(0x57, 0x83); got != 0xc1 {
+		t.Fatalf("0x57 * 0x83 = %#x", got)
+	}
+	for a := 1; a < 256; a++ {
+		if gfMul(byte(a), gfInv(byte(a))) != 1 {
+			t.Fatalf("%#x has no inverse", a)
+		}
+	}
+}
+
+func TestSplitKey(t *testing.T) {
+	key := randKey()
+	shares, err := SplitKey(key, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pick := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var subset [][]byte
+		for _, i := range pick {
+			subset = append(subset, shares[i])
+		}
+		got, err := CombineKey(subset)
+		if err != nil || *got != *key {
+			t.Fatalf("shares %v: %v", pick, err)
+		}
+	}
+
+	if _, err := CombineKey(shares[:2]); err == nil {
+		t.Fatal("two shares of a 3 of 5 split combined")
+	}
+	if _, err := CombineKey([][]byte{shares[0], shares[0], shares[1]}); err == nil {
+		t.Fatal("repeated share accepted")
+	}
+
+	other, _ := SplitKey(randKey(), 5, 3)
+	if _, err := CombineKey([][]byte{shares[0], shares[1], other[2]}); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("mixed shares: %v", err)
+	}
+	bad := slices.Clone(shares[1])
+	bad[10] ^= 1
+	if _, err := CombineKey([][]byte{shares[0], bad, shares[2]}); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("corrupted share: %v", err)
+	}
+
+	for _, nk := range [][2]int{{3, 1}, {2, 3}, {256, 2}} {
+		if _, err := SplitKey(key, nk[0], nk[1]); err == nil {
+			t.Errorf("SplitKey(n=%d, k=%d) accepted", nk[0], nk[1])
+		}
+	}
+}
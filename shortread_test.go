@@ -0,0 +1,146 @@
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// TestShortReads decrypts from sources that return less than a chunk per
+// Read, as sockets, pipes and bufio do, and ones that return io.EOF along
+// with the last of the data.
+func TestShortReads(t *testing.T) {
+	key := randKey()
+	data := randBytes(5*1024 + 17)
+
+	sources := map[string]func(b []byte) (io.Reader, func()){
+		"one byte": func(b []byte) (io.Reader, func()) {
+			return iotest.OneByteReader(bytes.NewReader(b)), func() {}
+		},
+		"half": func(b []byte) (io.Reader, func()) {
+			return iotest.HalfReader(bytes.NewReader(b)), func() {}
+		},
+		"eof with data": func(b []byte) (io.Reader, func()) {
+			return iotest.DataErrReader(bytes.NewReader(b)), func() {}
+		},
+		"bufio": func(b []byte) (io.Reader, func()) {
+			return bufio.NewReaderSize(bytes.NewReader(b), 16), func() {}
+		},
+		"pipe": func(b []byte) (io.Reader, func()) {
+			pr, pw := io.Pipe()
+			go func() {
+				// odd sized writes so reads never line up with chunks
+				for len(b) > 0 {
+					n := min(len(b), 333)
+					pw.Write(b[:n])
+					b = b[n:]
+				}
+				pw.Close()
+			}()
+			return pr, func() { pr.Close() }
+		},
+		"socket": func(b []byte) (io.Reader, func()) {
+			client, server := net.Pipe()
+			go func() {
+				for len(b) > 0 {
+					n := min(len(b), 100)
+					server.Write(b[:n])
+					b = b[n:]
+				}
+				server.Close()
+			}()
+			return client, func() { client.Close() }
+		},
+	}
+
+	streams := map[string][]Option{
+		"default":        nil,
+		"concurrency":    {WithConcurrency(4)},
+		"archive":        {WithArchiveProfile()},
+		"counter nonces": {WithCounterNonces()},
+	}
+
+	for sname, opts := range streams {
+		var ct bytes.Buffer
+		w, err := NewWriter(&ct, key, append(opts, WithChunkSize(1024))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		for name, source := range sources {
+			t.Run(sname+"/"+name, func(t *testing.T) {
+				src, done := source(ct.Bytes())
+				defer done()
+				r, err := NewReader(src, key, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := io.ReadAll(r)
+				if err != nil || !bytes.Equal(got, data) {
+					t.Fatalf("read %d of %d bytes: %v", len(got), len(data), err)
+				}
+			})
+		}
+
+		t.Run(sname+"/truncated", func(t *testing.T) {
+			src := iotest.OneByteReader(bytes.NewReader(ct.Bytes()[:ct.Len()-1]))
+			r, err := NewReader(src, key, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadAll(r); err == nil {
+				t.Fatal("truncated stream read without error")
+			}
+		})
+	}
+}
+
+// TestReadDeadline makes sure a Reader on a socket carries on after a read
+// deadline passes in the middle of a chunk.
+func TestReadDeadline(t *testing.T) {
+	key := randKey()
+	data := randBytes(3*1024 + 5)
+	var ct bytes.Buffer
+	w, _ := NewWriter(&ct, key, WithChunkSize(1024))
+	w.Write(data)
+	w.Close()
+	stream := ct.Bytes()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	resume := make(chan bool)
+	go func() {
+		// the header and half the first chunk, then nothing until the
+		// reader has timed out
+		server.Write(stream[:len(stream)/4])
+		<-resume
+		server.Write(stream[len(stream)/4:])
+		server.Close()
+	}()
+
+	r, err := NewReader(client, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := r.Read(make([]byte, 10)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	client.SetReadDeadline(time.Time{})
+	close(resume)
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("after the timeout: %v", err)
+	}
+}
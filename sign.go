@@ -0,0 +1,135 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"slices"
+)
+
+// signed streams carry the signer's public key in the header and end with
+// an ed25519 signature inside the final chunk, over the SHA-512 of
+// signatureContext, the chunks' additional data header and the plaintext.
+// signing inside the encryption proves who wrote the plaintext even when
+// the key is shared by many writers, which the AEAD alone can't.
+const signatureContext = "crypt signature v1\x00"
+
+// WithSigner makes a Writer sign the stream with key, readers then need
+// WithTrustedSigners. the signature takes 64 bytes in the final chunk.
+func WithSigner(key ed25519.PrivateKey) Option {
+	return func(c *config) error {
+		if len(key) != ed25519.PrivateKeySize {
+			return errors.New("crypt: invalid ed25519 private key")
+		}
+
+		c.signer = key
+		return nil
+	}
+}
+
+// WithTrustedSigners makes a Reader accept streams signed by any of keys
+// and nothing else, unsigned streams included. the signature is checked
+// when the final chunk is read, before any of the last batch of chunks is
+// released. earlier chunks are authenticated but their origin is only
+// known at the end, use WithholdUntilComplete as well to release nothing
+// before the signature is checked.
+func WithTrustedSigners(keys ...ed25519.PublicKey) Option {
+	return func(c *config) error {
+		for _, k := range keys {
+			if len(k) != ed25519.PublicKeySize {
+				return errors.New("crypt: invalid ed25519 public key")
+			}
+		}
+
+		c.trusted = slices.Clone(keys)
+		return nil
+	}
+}
+
+// Signer returns the key that signed the stream once Read has returned
+// io.EOF, nil before that or for an unsigned stream.
+func (r *Reader) Signer() ed25519.PublicKey {
+	if r.verifier == nil || !r.verifier.verified {
+		return nil
+	}
+	return r.verifier.key
+}
+
+// newSignDigest starts the digest a signature is made over.
+func newSignDigest(header []byte) hash.Hash {
+	h := sha512.New()
+	h.Write([]byte(signatureContext))
+	h.Write(header)
+	return h
+}
+
+// signature returns the signature made by w's signer over everything
+// written so far.
+func (w *Writer) signature() []byte {
+	return ed25519.Sign(w.signer, w.digest.Sum(nil))
+}
+
+// signVerifier holds back the last 64 bytes of plaintext from a Reader,
+// which at the end of the stream are the signature.
+type signVerifier struct {
+	key    ed25519.PublicKey
+	digest hash.Hash
+
+	held     []byte
+	verified bool
+}
+
+// newSignVerifier checks that the signer of a stream is trusted, nil means
+// the stream isn't signed.
+func newSignVerifier(signer []byte, trusted []ed25519.PublicKey, header []byte) (*signVerifier, error) {
+	if signer == nil {
+		if trusted != nil {
+			return nil, errors.New("crypt: stream is not signed")
+		}
+		return nil, nil
+	}
+
+	if trusted == nil {
+		return nil, errors.New("crypt: stream is signed, use WithTrustedSigners")
+	}
+	if !slices.ContainsFunc(trusted, func(k ed25519.PublicKey) bool { return bytes.Equal(k, signer) }) {
+		return nil, errors.New("crypt: stream is signed by an untrusted key")
+	}
+
+	return &signVerifier{key: signer, digest: newSignDigest(header)}, nil
+}
+
+// push takes the plaintext of a batch of chunks and returns what can be
+// released, keeping the last 64 bytes seen. the chunks may point into a
+// buffer that is reused, so what is kept is copied.
+func (s *signVerifier) push(chunks [][]byte) [][]byte {
+	var out [][]byte
+	for _, p := range chunks {
+		if len(p) >= ed25519.SignatureSize {
+			out = append(out, s.held, p[:len(p)-ed25519.SignatureSize])
+			s.held = bytes.Clone(p[len(p)-ed25519.SignatureSize:])
+		} else {
+			all := append(s.held, p...)
+			k := max(0, len(all)-ed25519.SignatureSize)
+			out = append(out, all[:k])
+			s.held = bytes.Clone(all[k:])
+		}
+	}
+
+	for _, p := range out {
+		s.digest.Write(p)
+	}
+	return out
+}
+
+// verify checks the signature at the end of the stream.
+func (s *signVerifier) verify() error {
+	if len(s.held) != ed25519.SignatureSize || !ed25519.Verify(s.key, s.digest.Sum(nil), s.held) {
+		return errors.New("crypt: stream signature is invalid")
+	}
+
+	s.verified = true
+	return nil
+}
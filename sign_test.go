@@ -0,0 +1,109 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// signStream writes data as a stream signed by priv.
+func signStream(t *testing.T, key *[32]byte, priv ed25519.PrivateKey, data []byte, opts ...Option) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key, append(opts, WithSigner(priv))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(w, iotest.HalfReader(bytes.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestSigner round trips signed streams of sizes around the chunk and
+// signature sizes, with chunks smaller than a signature too.
+func TestSigner(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	other, _, _ := ed25519.GenerateKey(nil)
+
+	for _, chunkSize := range []int{16, 100} {
+		for _, size := range []int{0, 1, 63, 64, 65, 100, 250} {
+			data := randBytes(size)
+			stream := signStream(t, key, priv, data, WithChunkSize(chunkSize), WithConcurrency(2))
+
+			for _, opts := range [][]Option{{}, {WithConcurrency(3)}, {WithholdUntilComplete(1 << 20)}} {
+				r, err := NewReader(bytes.NewReader(stream), key, append(opts, WithTrustedSigners(other, pub))...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r.Signer() != nil {
+					t.Fatal("signer reported before the end")
+				}
+				got, err := io.ReadAll(iotest.OneByteReader(r))
+				if err != nil {
+					t.Fatalf("chunk size %d size %d: %v", chunkSize, size, err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatalf("chunk size %d size %d: data did not round trip", chunkSize, size)
+				}
+				if !bytes.Equal(r.Signer(), pub) {
+					t.Fatal("wrong signer reported")
+				}
+			}
+
+			if _, err := NewReader(bytes.NewReader(stream), key); err == nil {
+				t.Fatal("read a signed stream without trusted signers")
+			}
+			if _, err := NewReader(bytes.NewReader(stream), key, WithTrustedSigners(other)); err == nil {
+				t.Fatal("accepted an untrusted signer")
+			}
+		}
+	}
+}
+
+// TestSignerForged makes sure someone holding the shared key can't pass a
+// stream off as signed by someone else.
+func TestSignerForged(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	_, mallory, _ := ed25519.GenerateKey(nil)
+	data := randBytes(100)
+
+	// mallory signs with their own key but claims pub in the header
+	stream := signStream(t, key, mallory, data, WithChunkSize(16))
+	h, raw, _ := readHeader(bytes.NewReader(stream))
+	h.fields[fieldSigner] = pub
+	forged := append(h.encode(), stream[len(raw):]...)
+	r, err := NewReader(bytes.NewReader(forged), key, WithTrustedSigners(pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("forged signer accepted")
+	}
+
+	// a real signature over different data, none of the changed data is
+	// released
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, key, WithChunkSize(16), WithSigner(priv))
+	w.Write(data[:50])
+	w.buf[0] ^= 1 // change the last chunk after it was signed
+	w.Close()
+	r, _ = NewReader(bytes.NewReader(buf.Bytes()), key, WithTrustedSigners(pub))
+	got, err := readUntilError(r)
+	if err == io.EOF || len(got) > 48 {
+		t.Fatalf("released %d bytes and %v", len(got), err)
+	}
+
+	if _, err := NewReader(bytes.NewReader(sealStream(t, key, data, 16)), key, WithTrustedSigners(pub)); err == nil {
+		t.Fatal("accepted an unsigned stream")
+	}
+}
@@ -0,0 +1,249 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// gcmSIV implements AES-256-GCM-SIV as defined in RFC 8452. unlike GCM,
+// reusing a nonce only reveals whether two messages were identical rather
+// than breaking authentication, which makes it a safer choice when many
+// messages are encrypted under one key or the RNG can't be trusted.
+type gcmSIV struct {
+	// block is keyed with the key-generating key
+	block cipher.Block
+}
+
+// errOpen is returned for any message that fails authentication, like
+// crypto/cipher it gives no detail about why.
+var errOpen = errors.New("cipher: message authentication failed")
+
+// gcmSIVMaxPlaintext is the largest plaintext RFC 8452 allows, 2^36 bytes.
+const gcmSIVMaxPlaintext = 1 << 36
+
+// newGCMSIV returns AES-256-GCM-SIV keyed with key.
+func newGCMSIV(key *[32]byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &gcmSIV{block: block}, nil
+}
+
+func (g *gcmSIV) NonceSize() int { return 12 }
+func (g *gcmSIV) Overhead() int  { return 16 }
+
+// Seal appends the encryption of plaintext followed by its tag to dst.
+func (g *gcmSIV) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != g.NonceSize() {
+		panic("crypt: incorrect nonce length given to GCM-SIV")
+	}
+	if uint64(len(plaintext)) > gcmSIVMaxPlaintext || uint64(len(additionalData)) > gcmSIVMaxPlaintext {
+		panic("crypt: message too large for GCM-SIV")
+	}
+
+	authKey, enc := g.deriveKeys(nonce)
+	tag := g.tag(authKey, enc, nonce, plaintext, additionalData)
+
+	ret, out := sliceForAppend(dst, len(plaintext)+len(tag))
+	ctr(enc, tag, out[:len(plaintext)], plaintext)
+	copy(out[len(plaintext):], tag[:])
+	return ret
+}
+
+// Open decrypts and authenticates ciphertext, appending the plaintext to dst.
+func (g *gcmSIV) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != g.NonceSize() {
+		panic("crypt: incorrect nonce length given to GCM-SIV")
+	}
+	if len(ciphertext) < g.Overhead() || uint64(len(ciphertext)) > gcmSIVMaxPlaintext+16 {
+		return nil, errOpen
+	}
+
+	var tag [16]byte
+	copy(tag[:], ciphertext[len(ciphertext)-16:])
+	ciphertext = ciphertext[:len(ciphertext)-16]
+
+	authKey, enc := g.deriveKeys(nonce)
+
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	ctr(enc, tag, out, ciphertext)
+
+	expected := g.tag(authKey, enc, nonce, out, additionalData)
+	if subtle.ConstantTimeCompare(expected[:], tag[:]) != 1 {
+		clear(out)
+		return nil, errOpen
+	}
+
+	return ret, nil
+}
+
+// deriveKeys derives the per-nonce authentication and encryption keys.
+func (g *gcmSIV) deriveKeys(nonce []byte) (authKey [16]byte, enc cipher.Block) {
+	var in, out [16]byte
+	var encKey [32]byte
+	copy(in[4:], nonce)
+
+	for i := uint32(0); i < 6; i++ {
+		binary.LittleEndian.PutUint32(in[:4], i)
+		g.block.Encrypt(out[:], in[:])
+		if i < 2 {
+			copy(authKey[i*8:], out[:8])
+		} else {
+			copy(encKey[(i-2)*8:], out[:8])
+		}
+	}
+
+	enc, err := aes.NewCipher(encKey[:])
+	if err != nil {
+		// a 32 byte key can't fail
+		panic(err)
+	}
+
+	return authKey, enc
+}
+
+// tag computes the authentication tag over plaintext and additionalData.
+func (g *gcmSIV) tag(authKey [16]byte, enc cipher.Block, nonce, plaintext, additionalData []byte) [16]byte {
+	p := newPolyval(authKey)
+	p.update(additionalData)
+	p.update(plaintext)
+
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(additionalData))*8)
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(plaintext))*8)
+	p.update(lengths[:])
+
+	s := p.sum()
+	for i := range nonce {
+		s[i] ^= nonce[i]
+	}
+	s[15] &= 0x7f
+
+	var tag [16]byte
+	enc.Encrypt(tag[:], s[:])
+	return tag
+}
+
+// ctr xors src with the GCM-SIV keystream starting at counter block tag.
+func ctr(enc cipher.Block, tag [16]byte, dst, src []byte) {
+	block := tag
+	block[15] |= 0x80
+	counter := binary.LittleEndian.Uint32(block[:4])
+
+	var ks [16]byte
+	for len(src) > 0 {
+		binary.LittleEndian.PutUint32(block[:4], counter)
+		enc.Encrypt(ks[:], block[:])
+		counter++
+
+		n := subtle.XORBytes(dst, src, ks[:])
+		dst, src = dst[n:], src[n:]
+	}
+}
+
+// polyval is the POLYVAL universal hash from RFC 8452, elements of
+// GF(2^128) are held little endian as two words.
+type polyval struct {
+	// table[i] is H·x^-128·x^i, so multiplying by H·x^-128 (the "dot"
+	// operation) is just xoring the entries for each set bit.
+	table [128][2]uint64
+	s     [2]uint64
+}
+
+// newPolyval returns a POLYVAL instance keyed with h.
+func newPolyval(h [16]byte) *polyval {
+	v := [2]uint64{
+		binary.LittleEndian.Uint64(h[:8]),
+		binary.LittleEndian.Uint64(h[8:]),
+	}
+	for i := 0; i < 128; i++ {
+		v = mulXInv(v)
+	}
+
+	p := &polyval{}
+	for i := range p.table {
+		p.table[i] = v
+		v = mulX(v)
+	}
+
+	return p
+}
+
+// update absorbs b zero padded to a multiple of 16 bytes.
+func (p *polyval) update(b []byte) {
+	var block [16]byte
+	for len(b) > 0 {
+		n := copy(block[:], b)
+		clear(block[n:])
+		b = b[n:]
+
+		x := [2]uint64{
+			p.s[0] ^ binary.LittleEndian.Uint64(block[:8]),
+			p.s[1] ^ binary.LittleEndian.Uint64(block[8:]),
+		}
+
+		// every entry is read and masked rather than picked by branching
+		// on the bits of x, which depend on the key and the message
+		var r [2]uint64
+		for i := 0; i < 128; i++ {
+			m := -(x[i/64] >> (i % 64) & 1)
+			r[0] ^= p.table[i][0] & m
+			r[1] ^= p.table[i][1] & m
+		}
+		p.s = r
+	}
+}
+
+// sum returns the current hash.
+func (p *polyval) sum() [16]byte {
+	var out [16]byte
+	binary.LittleEndian.PutUint64(out[:8], p.s[0])
+	binary.LittleEndian.PutUint64(out[8:], p.s[1])
+	return out
+}
+
+// polyvalReduce holds the low terms of the POLYVAL polynomial
+// x^128 + x^127 + x^126 + x^121 + 1, in the high word.
+const polyvalReduce = 1<<63 | 1<<62 | 1<<57
+
+// mulX multiplies v by x.
+func mulX(v [2]uint64) [2]uint64 {
+	carry := -(v[1] >> 63)
+	v[1] = v[1]<<1 | v[0]>>63
+	v[0] <<= 1
+	v[0] ^= 1 & carry
+	v[1] ^= polyvalReduce & carry
+
+	return v
+}
+
+// mulXInv multiplies v by x^-1.
+func mulXInv(v [2]uint64) [2]uint64 {
+	// add the polynomial if v is odd so it divides evenly by x
+	odd := v[0] & 1
+	v[0] ^= odd
+	v[1] ^= polyvalReduce & -odd
+
+	v[0] = v[0]>>1 | v[1]<<63
+	v[1] = v[1]>>1 | odd<<63
+	return v
+}
+
+// sliceForAppend extends in by n bytes, returning the whole slice and the
+// new tail, like the helper of the same name in crypto/cipher.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+
+	tail = head[len(in):]
+	return
+}
@@ -0,0 +1,70 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestGCMSIV checks AES-256-GCM-SIV against the test vectors in RFC 8452
+// appendix C.2, with and without additional data, and makes sure tampering
+// is caught.
+func TestGCMSIV(t *testing.T) {
+	t.Parallel()
+	key := &[32]byte{0: 1}
+	nonce := make([]byte, 12)
+	nonce[0] = 3
+
+	tt := []struct {
+		aad, plaintext, want string
+	}{
+		{"", "", "07f5f4169bbf55a8400cd47ea6fd400f"},
+		{"", "0100000000000000", "c2ef328e5c71c83b843122130f7364b761e0b97427e3df28"},
+		{"", "010000000000000000000000", "9aab2aeb3faa0a34aea8e2b18ca50da9ae6559e48fd10f6e5c9ca17e"},
+		{"", "01000000000000000000000000000000", "85a01b63025ba19b7fd3ddfc033b3e76c9eac6fa700942702e90862383c6c366"},
+		{"", "0100000000000000000000000000000002000000000000000000000000000000", "4a6a9db4c8c6549201b9edb53006cba821ec9cf850948a7c86c68ac7539d027fe819e63abcd020b006a976397632eb5d"},
+		{"", "010000000000000000000000000000000200000000000000000000000000000003000000000000000000000000000000", "c00d121893a9fa603f48ccc1ca3c57ce7499245ea0046db16c53c7c66fe717e39cf6c748837b61f6ee3adcee17534ed5790bc96880a99ba804bd12c0e6a22cc4"},
+		{"", "01000000000000000000000000000000020000000000000000000000000000000300000000000000000000000000000004000000000000000000000000000000", "c2d5160a1f8683834910acdafc41fbb1632d4a353e8b905ec9a5499ac34f96c7e1049eb080883891a4db8caaa1f99dd004d80487540735234e3744512c6f90ce112864c269fc0d9d88c61fa47e39aa08"},
+		{"01", "0200000000000000", "1de22967237a813291213f267e3b452f02d01ae33e4ec854"},
+		{"01", "020000000000000000000000", "163d6f9cc1b346cd453a2e4cc1a4a19ae800941ccdc57cc8413c277f"},
+		{"01", "02000000000000000000000000000000", "c91545823cc24f17dbb0e9e807d5ec17b292d28ff61189e8e49f3875ef91aff7"},
+		{"01", "0200000000000000000000000000000003000000000000000000000000000000", "07dad364bfc2b9da89116d7bef6daaaf6f255510aa654f920ac81b94e8bad365aea1bad12702e1965604374aab96dbbc"},
+		{"01", "020000000000000000000000000000000300000000000000000000000000000004000000000000000000000000000000", "c67a1f0f567a5198aa1fcc8e3f21314336f7f51ca8b1af61feac35a86416fa47fbca3b5f749cdf564527f2314f42fe2503332742b228c647173616cfd44c54eb"},
+		{"01", "02000000000000000000000000000000030000000000000000000000000000000400000000000000000000000000000005000000000000000000000000000000", "67fd45e126bfb9a79930c43aad2d36967d3f0e4d217c1e551f59727870beefc98cb933a8fce9de887b1e40799988db1fc3f91880ed405b2dd298318858467c895bde0285037c5de81e5b570a049b62a0"},
+		{"010000000000000000000000", "02000000", "22b3f4cd1835e517741dfddccfa07fa4661b74cf"},
+		{"010000000000000000000000000000000200", "0300000000000000000000000000000004000000", "43dd0163cdb48f9fe3212bf61b201976067f342bb879ad976d8242acc188ab59cabfe307"},
+		{"0100000000000000000000000000000002000000", "030000000000000000000000000000000400", "462401724b5ce6588d5a54aae5375513a075cfcdf5042112aa29685c912fc2056543"},
+	}
+
+	aead, err := newGCMSIV(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range tt {
+		aad, _ := hex.DecodeString(tc.aad)
+		plaintext, _ := hex.DecodeString(tc.plaintext)
+		want, _ := hex.DecodeString(tc.want)
+
+		got := aead.Seal(nil, nonce, plaintext, aad)
+		if !bytes.Equal(got, want) {
+			t.Fatalf("Seal(%s, %s) = %x, want %x", tc.plaintext, tc.aad, got, want)
+		}
+
+		opened, err := aead.Open(nil, nonce, got, aad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(opened, plaintext) {
+			t.Fatalf("Open = %x, want %s", opened, tc.plaintext)
+		}
+
+		if _, err := aead.Open(nil, nonce, got, append(aad, 0)); err == nil {
+			t.Fatal("wrong additional data was accepted")
+		}
+		got[0] ^= 1
+		if _, err := aead.Open(nil, nonce, got, aad); err == nil {
+			t.Fatal("tampered ciphertext was accepted")
+		}
+	}
+}
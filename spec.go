@@ -0,0 +1,19 @@
+package crypt
+
+import (
+	"bytes"
+	_ "embed"
+)
+
+// formatSpec is the machine readable description of the stream format in
+// spec/format.json, spec/vectors.json holds conformance vectors generated
+// from this package for implementations in other languages.
+//
+//go:embed spec/format.json
+var formatSpec []byte
+
+// FormatSpec returns the machine readable description of the stream format
+// as JSON.
+func FormatSpec() []byte {
+	return bytes.Clone(formatSpec)
+}
@@ -0,0 +1,239 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"testing"
+	"testing/cryptotest"
+)
+
+var update = flag.Bool("update", false, "regenerate spec/vectors.json")
+
+// vector is a conformance vector, streams are hex. vectors with Error set
+// must fail to decrypt, for vectors with Derived set Key is the master key
+// the stream key is derived from with the object_id field. Identity is
+// the 32 byte seed of an ML-KEM-768 + X25519 private key for streams with
+// a hybrid recipient.
+type vector struct {
+	Name      string `json:"name"`
+	Key       string `json:"key,omitempty"`
+	Derived   bool   `json:"derived,omitempty"`
+	Identity  string `json:"identity,omitempty"`
+	Password  string `json:"password,omitempty"`
+	Plaintext string `json:"plaintext,omitempty"`
+	Stream    string `json:"stream"`
+	Error     bool   `json:"error,omitempty"`
+}
+
+const vectorsPath = "spec/vectors.json"
+
+// makeVectors encrypts the vectors with deterministic randomness, so the
+// same code always gives the same streams.
+func makeVectors(t *testing.T) []vector {
+	old := randReader
+	randReader = rand.NewChaCha8([32]byte{'c', 'r', 'y', 'p', 't'})
+	defer func() { randReader = old }()
+
+	key := &[32]byte{}
+	for i := range key {
+		key[i] = byte(i)
+	}
+	plaintext := []byte("the quick brown fox jumps over the lazy dog")
+
+	seal := func(data []byte, opts ...Option) []byte {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, key, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	var vectors []vector
+	add := func(name string, stream []byte, pt []byte, fail bool) {
+		v := vector{Name: name, Key: hex.EncodeToString(key[:]), Stream: hex.EncodeToString(stream), Error: fail}
+		if !fail {
+			v.Plaintext = hex.EncodeToString(pt)
+		}
+		vectors = append(vectors, v)
+	}
+
+	for _, c := range ciphers {
+		add(c.String(), seal(plaintext, WithCipher(c), WithChunkSize(16)), plaintext, false)
+	}
+	add("empty", seal(nil), nil, false)
+	add("exact chunks", seal(plaintext[:32], WithChunkSize(16)), plaintext[:32], false)
+	add("archive", seal(plaintext, WithArchiveProfile(), WithChunkSize(4)), plaintext, false)
+
+	var buf bytes.Buffer
+	w, err := NewPasswordWriter(&buf, []byte("password"), WithKDF(PBKDF2{Iterations: 1000}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plaintext)
+	w.Close()
+	vectors = append(vectors, vector{
+		Name:      "password",
+		Password:  "password",
+		Plaintext: hex.EncodeToString(plaintext),
+		Stream:    hex.EncodeToString(buf.Bytes()),
+	})
+
+	stream := seal(plaintext, WithChunkSize(16))
+	h, raw, _ := readHeader(bytes.NewReader(stream))
+	frame := sequenceSize + h.cipher.nonceSize() + h.cipher.tagSize() + 16
+	add("truncated", stream[:len(raw)+2*frame], nil, true)
+	swapped := bytes.Clone(stream)
+	copy(swapped[len(raw):], stream[len(raw)+frame:len(raw)+2*frame])
+	copy(swapped[len(raw)+frame:], stream[len(raw):len(raw)+frame])
+	add("reordered", swapped, nil, true)
+	flipped := bytes.Clone(stream)
+	flipped[len(flipped)-1] ^= 1
+	add("flipped bit", flipped, nil, true)
+	add("trailing data", append(bytes.Clone(stream), 0), nil, true)
+	add("stream id", seal(plaintext, WithStreamID(), WithChunkSize(16)), plaintext, false)
+
+	// the key of this one is derived from the vector key and the object
+	// id in the header
+	var derived bytes.Buffer
+	w, err = NewDerivedWriter(&derived, key, "bucket/object", WithChunkSize(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plaintext)
+	w.Close()
+	add("object id", derived.Bytes(), plaintext, false)
+	vectors[len(vectors)-1].Derived = true
+
+	// HPKE takes its randomness from crypto/rand, which is made
+	// deterministic for the key pair and stanza
+	cryptotest.SetGlobalRandom(t, 1)
+	pub, priv, err := GenerateHybridKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, _ := priv.Bytes()
+	var hybrid bytes.Buffer
+	w, err = NewRecipientWriter(&hybrid, []Recipient{pub}, WithChunkSize(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plaintext)
+	w.Close()
+	vectors = append(vectors, vector{
+		Name:      "hybrid recipient",
+		Identity:  hex.EncodeToString(seed),
+		Plaintext: hex.EncodeToString(plaintext),
+		Stream:    hex.EncodeToString(hybrid.Bytes()),
+	})
+
+	return vectors
+}
+
+// TestVectors decrypts every conformance vector and makes sure this package
+// still produces exactly the same streams. run with -update after changing
+// the format on purpose.
+func TestVectors(t *testing.T) {
+	vectors := makeVectors(t)
+	if *update {
+		b, err := json.MarshalIndent(vectors, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(vectorsPath, append(b, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := os.ReadFile(vectorsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored []vector
+	if err := json.Unmarshal(b, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != len(vectors) {
+		t.Fatalf("%s has %d vectors, want %d", vectorsPath, len(stored), len(vectors))
+	}
+
+	for i, v := range stored {
+		if v != vectors[i] {
+			t.Fatalf("%s: stream changed, run with -update if that was on purpose", v.Name)
+		}
+
+		stream, _ := hex.DecodeString(v.Stream)
+		var r io.Reader
+		if v.Password != "" {
+			r, err = NewPasswordReader(bytes.NewReader(stream), []byte(v.Password))
+		} else if v.Identity != "" {
+			seed, _ := hex.DecodeString(v.Identity)
+			var priv *HybridPrivateKey
+			if priv, err = ParseHybridPrivateKey(seed); err == nil {
+				r, err = NewRecipientReader(bytes.NewReader(stream), priv)
+			}
+		} else if v.Derived {
+			key, _ := hex.DecodeString(v.Key)
+			r, err = NewDerivedReader(bytes.NewReader(stream), (*[32]byte)(key))
+		} else {
+			key, _ := hex.DecodeString(v.Key)
+			r, err = NewReader(bytes.NewReader(stream), (*[32]byte)(key))
+		}
+		var got []byte
+		if err == nil {
+			got, err = io.ReadAll(r)
+		}
+
+		if v.Error {
+			if err == nil {
+				t.Fatalf("%s: decrypted", v.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", v.Name, err)
+		}
+		if hex.EncodeToString(got) != v.Plaintext {
+			t.Fatalf("%s: wrong plaintext", v.Name)
+		}
+	}
+}
+
+// TestFormatSpec makes sure the spec is valid JSON and lists every cipher
+// and header field.
+func TestFormatSpec(t *testing.T) {
+	t.Parallel()
+	var spec struct {
+		Fields  map[string]json.RawMessage `json:"fields"`
+		Ciphers map[string]struct {
+			Name      string `json:"name"`
+			NonceSize int    `json:"nonce_size"`
+			TagSize   int    `json:"tag_size"`
+		} `json:"ciphers"`
+	}
+	if err := json.Unmarshal(FormatSpec(), &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range ciphers {
+		s, ok := spec.Ciphers[string('0'+byte(c))]
+		if !ok || s.NonceSize != c.nonceSize() || s.TagSize != c.tagSize() {
+			t.Fatalf("spec is wrong about %s", c)
+		}
+	}
+	for typ := range knownHeaderFields {
+		if _, ok := spec.Fields[strconv.Itoa(int(typ))]; !ok {
+			t.Fatalf("spec is missing header field %d", typ)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package crypt
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+)
+
+// sqlKeyring is the keyring and options EncryptedString and EncryptedBytes
+// use, see SetSQLKeyring.
+var sqlKeyring atomic.Pointer[sqlConfig]
+
+type sqlConfig struct {
+	keyring *Keyring
+	opts    []Option
+}
+
+// SetSQLKeyring sets the keyring EncryptedString and EncryptedBytes encrypt
+// with, opts apply as for Encrypt. Value and Scan can't be given one, so it
+// is set once for the program, usually at startup.
+func SetSQLKeyring(k *Keyring, opts ...Option) {
+	sqlKeyring.Store(&sqlConfig{keyring: k, opts: opts})
+}
+
+// EncryptedString is a string stored encrypted in a database column, it is
+// encrypted by Value with the primary key of the keyring set with
+// SetSQLKeyring and decrypted by Scan with whichever key it was written
+// with, so columns keep working while keys are rotated. the column has to
+// hold binary data, a BLOB or bytea. NULL needs sql.Null[EncryptedString].
+//
+// values aren't bound to their row or column, someone who can write to the
+// database can copy one value over another.
+type EncryptedString string
+
+// EncryptedBytes is EncryptedString for []byte, NULL scans to nil.
+type EncryptedBytes []byte
+
+// Value implements driver.Valuer.
+func (s EncryptedString) Value() (driver.Value, error) {
+	return sqlEncrypt([]byte(s))
+}
+
+// Scan implements sql.Scanner.
+func (s *EncryptedString) Scan(src any) error {
+	if src == nil {
+		return errors.New("crypt: can't scan NULL into EncryptedString, use sql.Null")
+	}
+	b, err := sqlDecrypt(src)
+	if err != nil {
+		return err
+	}
+	*s = EncryptedString(b)
+	return nil
+}
+
+// Value implements driver.Valuer.
+func (b EncryptedBytes) Value() (driver.Value, error) {
+	if b == nil {
+		return nil, nil
+	}
+	return sqlEncrypt(b)
+}
+
+// Scan implements sql.Scanner.
+func (b *EncryptedBytes) Scan(src any) error {
+	if src == nil {
+		*b = nil
+		return nil
+	}
+	plain, err := sqlDecrypt(src)
+	if err != nil {
+		return err
+	}
+	*b = plain
+	return nil
+}
+
+// sqlEncrypt encrypts plaintext with the primary key, prefixed with its id
+// as a big endian uint32.
+func sqlEncrypt(plaintext []byte) ([]byte, error) {
+	c := sqlKeyring.Load()
+	if c == nil {
+		return nil, errors.New("crypt: no keyring set with SetSQLKeyring")
+	}
+	id, key, err := c.keyring.Primary()
+	if err != nil {
+		return nil, err
+	}
+	ct, err := Encrypt(plaintext, key, c.opts...)
+	if err != nil {
+		return nil, err
+	}
+	return append(binary.BigEndian.AppendUint32(nil, id), ct...), nil
+}
+
+// sqlDecrypt decrypts a value written by sqlEncrypt, which drivers may
+// hand over as a string.
+func sqlDecrypt(src any) ([]byte, error) {
+	c := sqlKeyring.Load()
+	if c == nil {
+		return nil, errors.New("crypt: no keyring set with SetSQLKeyring")
+	}
+	var b []byte
+	switch src := src.(type) {
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	default:
+		return nil, errors.New("crypt: encrypted column holds neither bytes nor a string")
+	}
+	if len(b) < 4 {
+		return nil, errors.New("crypt: encrypted column value is too short")
+	}
+	key, err := c.keyring.Key(binary.BigEndian.Uint32(b))
+	if err != nil {
+		return nil, err
+	}
+	return Decrypt(b[4:], key, c.opts...)
+}
+
+var (
+	_ driver.Valuer = EncryptedString("")
+	_ sql.Scanner   = (*EncryptedString)(nil)
+	_ driver.Valuer = EncryptedBytes(nil)
+	_ sql.Scanner   = (*EncryptedBytes)(nil)
+)
@@ -0,0 +1,69 @@
+package crypt
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestEncryptedColumns(t *testing.T) {
+	k := NewKeyring()
+	if err := k.Add(1, randKey()); err != nil {
+		t.Fatal(err)
+	}
+	SetSQLKeyring(k)
+	defer sqlKeyring.Store(nil)
+
+	v, err := EncryptedString("4111 1111 1111 1111").Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := v.([]byte)
+
+	// rotate, the old value still scans
+	if err := k.Add(2, randKey()); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetPrimary(2); err != nil {
+		t.Fatal(err)
+	}
+	var s EncryptedString
+	if err := s.Scan(string(old)); err != nil || s != "4111 1111 1111 1111" {
+		t.Fatalf("Scan = %q, %v", s, err)
+	}
+
+	v, err = EncryptedBytes("token").Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := v.([]byte)[3]; id != 2 {
+		t.Fatalf("written with key %d", id)
+	}
+	var b EncryptedBytes
+	if err := b.Scan(v); err != nil || string(b) != "token" {
+		t.Fatalf("Scan = %q, %v", b, err)
+	}
+
+	// NULL
+	if v, err := EncryptedBytes(nil).Value(); v != nil || err != nil {
+		t.Fatalf("nil Value = %v, %v", v, err)
+	}
+	if err := b.Scan(nil); err != nil || b != nil {
+		t.Fatalf("Scan(nil) = %q, %v", b, err)
+	}
+	var ns sql.Null[EncryptedString]
+	if err := ns.Scan(nil); err != nil || ns.Valid {
+		t.Fatalf("sql.Null Scan: %v", err)
+	}
+
+	old[len(old)-1] ^= 1
+	if err := s.Scan(old); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("tampered value: %v", err)
+	}
+	if err := k.Remove(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Scan(old); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("removed key: %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package crypt
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"math/big"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// streams can be encrypted for the ssh-ed25519 and ssh-rsa keys people
+// already have, like age does. the body of each stanza starts with a tag,
+// the first 4 bytes of the SHA-256 of the SSH public key, so identities can
+// skip stanzas for other keys without trying them.
+//
+// ed25519 keys are converted to X25519 and the rest of the body is
+// SealToPublicKey output. rsa keys wrap the data key with RSA-OAEP-SHA256
+// with sshRSALabel as the label.
+const (
+	sshTagSize  = 4
+	sshRSALabel = "crypt ssh-rsa v1"
+)
+
+// ParseSSHRecipient parses a public key in authorized_keys format, e.g. a
+// line from ~/.ssh/id_ed25519.pub, as a Recipient. ssh-ed25519 and ssh-rsa
+// keys are supported.
+func ParseSSHRecipient(line []byte) (Recipient, error) {
+	pk, _, _, _, err := ssh.ParseAuthorizedKey(line)
+	if err != nil {
+		return nil, err
+	}
+	tag := sshTag(pk)
+
+	cpk, ok := pk.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, errors.New("crypt: unsupported ssh key type " + pk.Type())
+	}
+
+	switch k := cpk.CryptoPublicKey().(type) {
+	case ed25519.PublicKey:
+		pub, err := ed25519ToX25519(k)
+		if err != nil {
+			return nil, err
+		}
+		return &sshEd25519Recipient{tag: tag, pub: pub}, nil
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("crypt: ssh-rsa keys must be at least 2048 bits")
+		}
+		return &sshRSARecipient{tag: tag, pub: k}, nil
+	}
+
+	return nil, errors.New("crypt: unsupported ssh key type " + pk.Type())
+}
+
+// ParseSSHIdentity parses an unencrypted SSH private key file, e.g.
+// ~/.ssh/id_ed25519, as an Identity.
+func ParseSSHIdentity(pemBytes []byte) (Identity, error) {
+	k, err := ssh.ParseRawPrivateKey(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return sshIdentity(k)
+}
+
+// ParseSSHIdentityWithPassphrase is ParseSSHIdentity for keys protected by
+// a passphrase.
+func ParseSSHIdentityWithPassphrase(pemBytes, passphrase []byte) (Identity, error) {
+	k, err := ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return sshIdentity(k)
+}
+
+// sshIdentity wraps a private key from x/crypto/ssh.
+func sshIdentity(k any) (Identity, error) {
+	switch k := k.(type) {
+	case *ed25519.PrivateKey:
+		return newSSHEd25519Identity(*k)
+	case ed25519.PrivateKey:
+		return newSSHEd25519Identity(k)
+	case *rsa.PrivateKey:
+		pk, err := ssh.NewPublicKey(&k.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		return &sshRSAIdentity{tag: sshTag(pk), priv: k}, nil
+	}
+
+	return nil, errors.New("crypt: unsupported ssh private key type")
+}
+
+// sshTag returns the tag for an SSH public key.
+func sshTag(pk ssh.PublicKey) [sshTagSize]byte {
+	sum := sha256.Sum256(pk.Marshal())
+	return [sshTagSize]byte(sum[:])
+}
+
+// sshEd25519Recipient is an ssh-ed25519 public key converted to X25519.
+type sshEd25519Recipient struct {
+	tag [sshTagSize]byte
+	pub *PublicKey
+}
+
+func (r *sshEd25519Recipient) wrap(c Cipher, dataKey *[32]byte) (byte, []byte, error) {
+	box, err := SealToPublicKey(dataKey[:], r.pub, WithCipher(c))
+	return stanzaSSHEd25519, append(r.tag[:], box...), err
+}
+
+// sshEd25519Identity is an ssh-ed25519 private key converted to X25519.
+type sshEd25519Identity struct {
+	tag  [sshTagSize]byte
+	priv *PrivateKey
+}
+
+func newSSHEd25519Identity(k ed25519.PrivateKey) (Identity, error) {
+	pk, err := ssh.NewPublicKey(k.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	// the X25519 scalar is the hashed seed, the same one ed25519 uses
+	h := sha512.Sum512(k.Seed())
+	return &sshEd25519Identity{tag: sshTag(pk), priv: (*PrivateKey)(h[:32])}, nil
+}
+
+func (id *sshEd25519Identity) unwrap(typ byte, body []byte) (*[32]byte, error) {
+	if typ != stanzaSSHEd25519 || len(body) < sshTagSize || [sshTagSize]byte(body) != id.tag {
+		return nil, errNotForIdentity
+	}
+
+	b, err := OpenWithPrivateKey(body[sshTagSize:], id.priv)
+	if err != nil || len(b) != 32 {
+		return nil, errNotForIdentity
+	}
+
+	return (*[32]byte)(b), nil
+}
+
+// sshRSARecipient is an ssh-rsa public key.
+type sshRSARecipient struct {
+	tag [sshTagSize]byte
+	pub *rsa.PublicKey
+}
+
+func (r *sshRSARecipient) wrap(c Cipher, dataKey *[32]byte) (byte, []byte, error) {
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), randReader, r.pub, dataKey[:], []byte(sshRSALabel))
+	return stanzaSSHRSA, append(r.tag[:], wrapped...), err
+}
+
+// sshRSAIdentity is an ssh-rsa private key.
+type sshRSAIdentity struct {
+	tag  [sshTagSize]byte
+	priv *rsa.PrivateKey
+}
+
+func (id *sshRSAIdentity) unwrap(typ byte, body []byte) (*[32]byte, error) {
+	if typ != stanzaSSHRSA || len(body) < sshTagSize || [sshTagSize]byte(body) != id.tag {
+		return nil, errNotForIdentity
+	}
+
+	b, err := rsa.DecryptOAEP(sha256.New(), nil, id.priv, body[sshTagSize:], []byte(sshRSALabel))
+	if err != nil || len(b) != 32 {
+		return nil, errNotForIdentity
+	}
+
+	return (*[32]byte)(b), nil
+}
+
+// curve25519P is the field prime 2^255 - 19.
+var curve25519P, _ = new(big.Int).SetString("7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffed", 16)
+
+// ed25519ToX25519 converts an ed25519 public key to the X25519 public key
+// of the same secret, u = (1 + y) / (1 - y) (RFC 7748 section 4.1).
+func ed25519ToX25519(pub ed25519.PublicKey) (*PublicKey, error) {
+	// y is little endian with the sign of x in the top bit
+	le := [32]byte(pub)
+	le[31] &= 0x7f
+	var be [32]byte
+	for i := range le {
+		be[31-i] = le[i]
+	}
+	y := new(big.Int).SetBytes(be[:])
+	if y.Cmp(curve25519P) >= 0 {
+		return nil, errors.New("crypt: invalid ed25519 public key")
+	}
+
+	one := big.NewInt(1)
+	num := new(big.Int).Add(one, y)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, curve25519P)
+	if den.Sign() == 0 {
+		return nil, errors.New("crypt: invalid ed25519 public key")
+	}
+	u := num.Mul(num, den.ModInverse(den, curve25519P))
+	u.Mod(u, curve25519P)
+
+	out := &PublicKey{}
+	u.FillBytes(be[:])
+	for i := range be {
+		out[31-i] = be[i]
+	}
+	return out, nil
+}
@@ -0,0 +1,120 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/pem"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshKeys returns a private key in the format it would be on disk and the
+// matching authorized_keys line.
+func sshKeys(t *testing.T, priv crypto.Signer) (privPEM, pubLine []byte) {
+	t.Helper()
+	block, err := ssh.MarshalPrivateKey(priv, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(block), ssh.MarshalAuthorizedKey(pk)
+}
+
+// TestSSH encrypts a stream for an ed25519 and an rsa SSH key and makes sure
+// each private key can read it.
+func TestSSH(t *testing.T) {
+	t.Parallel()
+	_, edPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPriv, err := rsa.GenerateKey(randReader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var recipients []Recipient
+	var identities []Identity
+	for _, priv := range []crypto.Signer{edPriv, rsaPriv} {
+		privPEM, pubLine := sshKeys(t, priv)
+		r, err := ParseSSHRecipient(pubLine)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := ParseSSHIdentity(privPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recipients = append(recipients, r)
+		identities = append(identities, id)
+	}
+
+	data := randBytes(100)
+	var buf bytes.Buffer
+	w, err := NewRecipientWriter(&buf, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range identities {
+		r, err := NewRecipientReader(bytes.NewReader(buf.Bytes()), id)
+		if err != nil {
+			t.Fatalf("%T: %v", id, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%T: data did not round trip", id)
+		}
+	}
+
+	_, other, _ := ed25519.GenerateKey(nil)
+	otherPEM, _ := sshKeys(t, other)
+	id, err := ParseSSHIdentity(otherPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRecipientReader(bytes.NewReader(buf.Bytes()), id); err == nil {
+		t.Fatal("another ssh key read the stream")
+	}
+}
+
+// TestEd25519ToX25519 checks the converted public key matches the X25519
+// public key of the converted private key.
+func TestEd25519ToX25519(t *testing.T) {
+	t.Parallel()
+	for range 10 {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, err := ed25519ToX25519(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id, err := newSSHEd25519Identity(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *id.(*sshEd25519Identity).priv.Public() != *x {
+			t.Fatal("converted keys don't match")
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package crypt
+
+import "encoding/hex"
+
+// streamIDSize is the size of the random ID WithStreamID gives a stream.
+const streamIDSize = 16
+
+// WithStreamID makes NewWriter give the stream a random ID, stored in its
+// header where every chunk authenticates it. Writer.ID, Reader.ID, Inspect
+// and the ChunkErrors of a Reader report it, so logs, metrics and stored
+// objects can be tied to the same stream across systems. the ID isn't
+// secret.
+func WithStreamID() Option {
+	return func(c *config) error {
+		c.streamID = true
+		return nil
+	}
+}
+
+// ID returns the stream ID in hex, see WithStreamID, empty for a stream
+// without one.
+func (w *Writer) ID() string {
+	return w.id
+}
+
+// ID returns the stream ID in hex, see WithStreamID, empty for a stream
+// without one.
+func (r *Reader) ID() string {
+	return r.id
+}
+
+// streamID returns the stream ID of h in hex, empty if it has none.
+func (h *header) streamID() (string, error) {
+	b, ok := h.fields[fieldStreamID]
+	if !ok {
+		return "", nil
+	}
+	if len(b) != streamIDSize {
+		return "", errBadHeader("invalid stream id field")
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,60 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStreamID(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	data := randBytes(1000)
+
+	var ct bytes.Buffer
+	w, err := NewWriter(&ct, key, WithStreamID(), WithChunkSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.ID()) != 2*streamIDSize {
+		t.Fatalf("Writer.ID = %q", w.ID())
+	}
+
+	info, err := Inspect(bytes.NewReader(ct.Bytes()))
+	if err != nil || info.StreamID != w.ID() {
+		t.Fatalf("Inspect reports %q, %v", info.StreamID, err)
+	}
+	r, err := NewReader(bytes.NewReader(ct.Bytes()), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ID() != w.ID() {
+		t.Fatalf("Reader.ID = %q, want %q", r.ID(), w.ID())
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("round trip: %v", err)
+	}
+
+	// errors name the stream
+	damaged := bytes.Clone(ct.Bytes())
+	damaged[len(damaged)-5] ^= 1
+	r, _ = NewReader(bytes.NewReader(damaged), key)
+	_, err = io.ReadAll(r)
+	var ce *ChunkError
+	if !errors.As(err, &ce) || ce.StreamID != w.ID() || !strings.Contains(err.Error(), w.ID()) {
+		t.Fatalf("damaged stream: %v", err)
+	}
+
+	// every stream gets its own, and streams without one have none
+	w2, _ := NewWriter(io.Discard, key, WithStreamID())
+	w3, _ := NewWriter(io.Discard, key)
+	if w2.ID() == w.ID() || w3.ID() != "" {
+		t.Fatalf("IDs %q and %q", w2.ID(), w3.ID())
+	}
+}
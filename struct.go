@@ -0,0 +1,333 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MarshalStruct returns the JSON encoding of v, like json.Marshal, with
+// every field tagged `crypt:"encrypt"` encrypted with key and stored as a
+// base64 string. other fields are left as they are, so records can keep
+// searchable metadata next to their secrets. tagged fields are found in
+// nested structs, pointers, slices, arrays and maps with string keys, json
+// tags are followed for names, omitempty and "-".
+//
+// each encrypted field is bound to its path in the document, such as
+// /users/3/password, so values can't be moved around within it. they can
+// still be copied between documents, WithAAD binds them to one.
+func MarshalStruct(v any, key *[32]byte, opts ...Option) ([]byte, error) {
+	s, err := newStructCoder(key, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.marshal(reflect.ValueOf(v), "")
+}
+
+// UnmarshalStruct decodes JSON written by MarshalStruct into v, which must
+// be a pointer, decrypting the tagged fields. opts must match the ones
+// given to MarshalStruct.
+func UnmarshalStruct(data []byte, v any, key *[32]byte, opts ...Option) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("crypt: UnmarshalStruct needs a non nil pointer")
+	}
+	s, err := newStructCoder(key, opts)
+	if err != nil {
+		return err
+	}
+	return s.unmarshal(data, rv.Elem(), "")
+}
+
+// structCoder holds what MarshalStruct and UnmarshalStruct encrypt with.
+type structCoder struct {
+	key  *[32]byte
+	opts []Option
+	aad  []byte
+}
+
+func newStructCoder(key *[32]byte, opts []Option) (*structCoder, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &structCoder{key: key, opts: opts, aad: c.aad}, nil
+}
+
+// fieldOpts returns the options a field at path is encrypted with, the
+// path follows any additional data given.
+func (s *structCoder) fieldOpts(path string) []Option {
+	aad := append(slices.Clip(s.aad), 0)
+	return append(slices.Clip(s.opts), WithAAD(append(aad, path...)))
+}
+
+var pathEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// childPath returns the JSON pointer of name inside path.
+func childPath(path, name string) string {
+	return path + "/" + pathEscaper.Replace(name)
+}
+
+func (s *structCoder) marshal(v reflect.Value, path string) ([]byte, error) {
+	if !v.IsValid() {
+		return []byte("null"), nil
+	}
+	t := v.Type()
+	if !hasEncryptedFields(t, nil) {
+		return json.Marshal(v.Interface())
+	}
+
+	var buf bytes.Buffer
+	switch t.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return []byte("null"), nil
+		}
+		return s.marshal(v.Elem(), path)
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && v.IsNil() {
+			return []byte("null"), nil
+		}
+		buf.WriteByte('[')
+		for i := range v.Len() {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			b, err := s.marshal(v.Index(i), childPath(path, strconv.Itoa(i)))
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(b)
+		}
+		buf.WriteByte(']')
+	case reflect.Map:
+		if v.IsNil() {
+			return []byte("null"), nil
+		}
+		keys := v.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return strings.Compare(a.String(), b.String()) })
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			b, err := s.marshal(v.MapIndex(k), childPath(path, k.String()))
+			if err != nil {
+				return nil, err
+			}
+			writeJSONKey(&buf, k.String())
+			buf.Write(b)
+		}
+		buf.WriteByte('}')
+	case reflect.Struct:
+		buf.WriteByte('{')
+		first := true
+		for _, f := range structFields(t) {
+			fv := v.FieldByIndex(f.index)
+			if f.omitEmpty && fv.IsZero() {
+				continue
+			}
+
+			var b []byte
+			var err error
+			if f.encrypt {
+				b, err = s.encryptField(fv, childPath(path, f.name))
+			} else {
+				b, err = s.marshal(fv, childPath(path, f.name))
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !first {
+				buf.WriteByte(',')
+			}
+			first = false
+			writeJSONKey(&buf, f.name)
+			buf.Write(b)
+		}
+		buf.WriteByte('}')
+	}
+	return buf.Bytes(), nil
+}
+
+// encryptField returns the encrypted JSON encoding of v as a JSON string.
+func (s *structCoder) encryptField(v reflect.Value, path string) ([]byte, error) {
+	plain, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	ct, err := Encrypt(plain, s.key, s.fieldOpts(path)...)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(base64.StdEncoding.EncodeToString(ct))
+}
+
+func writeJSONKey(buf *bytes.Buffer, k string) {
+	b, _ := json.Marshal(k)
+	buf.Write(b)
+	buf.WriteByte(':')
+}
+
+func (s *structCoder) unmarshal(data []byte, v reflect.Value, path string) error {
+	t := v.Type()
+	if !hasEncryptedFields(t, nil) {
+		return json.Unmarshal(data, v.Addr().Interface())
+	}
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		if t.Kind() != reflect.Struct && t.Kind() != reflect.Array {
+			v.SetZero()
+		}
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return s.unmarshal(data, v.Elem(), path)
+	case reflect.Slice, reflect.Array:
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		if t.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(t, len(items), len(items)))
+		}
+		for i := range min(len(items), v.Len()) {
+			if err := s.unmarshal(items[i], v.Index(i), childPath(path, strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		var items map[string]json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(t, len(items)))
+		}
+		for k, item := range items {
+			e := reflect.New(t.Elem()).Elem()
+			if err := s.unmarshal(item, e, childPath(path, k)); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), e)
+		}
+	case reflect.Struct:
+		var items map[string]json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		for _, f := range structFields(t) {
+			raw, ok := items[f.name]
+			if !ok {
+				continue
+			}
+			fv := v.FieldByIndex(f.index)
+			var err error
+			if f.encrypt {
+				err = s.decryptField(raw, fv, childPath(path, f.name))
+			} else {
+				err = s.unmarshal(raw, fv, childPath(path, f.name))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decryptField decrypts a JSON string written by encryptField into v.
+func (s *structCoder) decryptField(raw []byte, v reflect.Value, path string) error {
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return nil
+	}
+	var enc string
+	if err := json.Unmarshal(raw, &enc); err != nil {
+		return errors.New("crypt: encrypted field " + path + " is not a string")
+	}
+	ct, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return errors.New("crypt: encrypted field " + path + " is not base64")
+	}
+	plain, err := Decrypt(ct, s.key, s.fieldOpts(path)...)
+	if err != nil {
+		return &detailError{msg: "crypt: decrypting field " + path + ": " + err.Error(), err: err}
+	}
+	return json.Unmarshal(plain, v.Addr().Interface())
+}
+
+// structField is a field of a struct as MarshalStruct sees it.
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	encrypt   bool
+}
+
+// structFields returns the fields of t that are encoded, with embedded
+// structs flattened as encoding/json does.
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, flags, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for _, ef := range structFields(f.Type) {
+				ef.index = append([]int{i}, ef.index...)
+				fields = append(fields, ef)
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, structField{
+			name:      name,
+			index:     []int{i},
+			omitEmpty: slices.Contains(strings.Split(flags, ","), "omitempty"),
+			encrypt:   f.Tag.Get("crypt") == "encrypt",
+		})
+	}
+	return fields
+}
+
+// hasEncryptedFields reports whether values of t can hold a field tagged
+// to be encrypted, seen stops recursive types going round forever.
+func hasEncryptedFields(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return hasEncryptedFields(t.Elem(), seen)
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String && hasEncryptedFields(t.Elem(), seen)
+	case reflect.Struct:
+		if seen == nil {
+			seen = make(map[reflect.Type]bool)
+		}
+		seen[t] = true
+		for _, f := range structFields(t) {
+			if f.encrypt || hasEncryptedFields(t.FieldByIndex(f.index).Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
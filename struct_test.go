@@ -0,0 +1,91 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testCredential struct {
+	Host     string `json:"host"`
+	Password string `json:"password" crypt:"encrypt"`
+}
+
+type testAccount struct {
+	testCredential
+	ID      int                        `json:"id"`
+	Email   string                     `json:"email"`
+	SSN     string                     `json:"ssn,omitempty" crypt:"encrypt"`
+	Tokens  []string                   `crypt:"encrypt"`
+	Backups []testCredential           `json:"backups"`
+	ByName  map[string]*testCredential `json:"by_name"`
+	Next    *testAccount               `json:"next,omitempty"`
+	Skip    string                     `json:"-"`
+}
+
+func TestMarshalStruct(t *testing.T) {
+	key := randKey()
+	in := testAccount{
+		testCredential: testCredential{Host: "db", Password: "hunter2"},
+		ID:             7,
+		Email:          "a@example.com",
+		SSN:            "078-05-1120",
+		Tokens:         []string{"tok1", "tok2"},
+		Backups:        []testCredential{{Host: "b1", Password: "pw1"}, {Host: "b2", Password: "pw2"}},
+		ByName:         map[string]*testCredential{"x": {Host: "x", Password: "pwx"}},
+		Next:           &testAccount{ID: 8, Email: "b@example.com"},
+		Skip:           "skipped",
+	}
+
+	data, err := MarshalStruct(in, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter2", "078-05-1120", "tok1", "pw1", "pwx", "skipped"} {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Fatalf("%q in output: %s", secret, data)
+		}
+	}
+	var plain map[string]any
+	if err := json.Unmarshal(data, &plain); err != nil {
+		t.Fatal(err)
+	}
+	if plain["email"] != "a@example.com" || plain["host"] != "db" {
+		t.Fatalf("metadata not kept: %s", data)
+	}
+	if _, ok := plain["next"].(map[string]any)["ssn"]; ok {
+		t.Fatal("omitempty ignored")
+	}
+
+	var out testAccount
+	if err := UnmarshalStruct(data, &out, key); err != nil {
+		t.Fatal(err)
+	}
+	in.Skip = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("got %+v, want %+v", out, in)
+	}
+
+	// values can't be moved within a document
+	backups := plain["backups"].([]any)
+	backups[0].(map[string]any)["password"] = backups[1].(map[string]any)["password"]
+	swapped, _ := json.Marshal(plain)
+	if err := UnmarshalStruct(swapped, &out, key); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("swapped field: %v", err)
+	}
+
+	// types without tagged fields are plain JSON
+	plainIn := struct {
+		A int
+		B []string `json:"b"`
+	}{1, []string{"x"}}
+	data, err = MarshalStruct(plainIn, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := json.Marshal(plainIn); !bytes.Equal(data, want) {
+		t.Fatalf("got %s, want %s", data, want)
+	}
+}
@@ -0,0 +1,200 @@
+package crypt
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+)
+
+// EncryptPath writes the file or directory tree at root to dst as a tar
+// archive encrypted like NewWriter with key and opts. paths in the archive
+// are relative to root, permissions and modification times are kept and
+// symlinks are stored as links, never followed. only regular files,
+// directories and symlinks are stored, sockets, fifos and devices are
+// skipped. ownership is recorded but not restored by DecryptPath.
+func EncryptPath(dst io.Writer, root string, key *[32]byte, opts ...Option) error {
+	w, err := NewWriter(dst, key, opts...)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			if d.IsDir() {
+				return nil
+			}
+			name = filepath.Base(p)
+		}
+		return writeTarEntry(tw, p, filepath.ToSlash(name), d)
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// writeTarEntry writes the header and contents of the file at p as name.
+func writeTarEntry(tw *tar.Writer, p, name string, d fs.DirEntry) error {
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+
+	var link string
+	switch mode := info.Mode(); {
+	case mode.IsRegular(), mode.IsDir():
+	case mode&fs.ModeSymlink != 0:
+		if link, err = os.Readlink(p); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	h, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	h.Name = name
+	if info.IsDir() {
+		h.Name += "/"
+	}
+	h.Format = tar.FormatPAX
+	if err := tw.WriteHeader(h); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// DecryptPath restores a tree written by EncryptPath into dir, creating it
+// if needed. existing files are overwritten. entries can't be written
+// outside dir, whether through their names or through symlinks, including
+// ones already in dir.
+//
+// chunks are authenticated as they are read, but the archive is only known
+// to be complete when DecryptPath returns nil, on an error dir may hold a
+// partial tree.
+func DecryptPath(dir string, src io.Reader, key *[32]byte, opts ...Option) error {
+	r, err := NewReader(src, key, opts...)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	// directories stay writable until everything is extracted, then get
+	// their own mode and time, deepest first
+	var dirs []*tar.Header
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Localize(path.Clean(h.Name))
+		if err != nil || name == "." {
+			return errors.New("crypt: archive entry " + h.Name + " is outside the destination")
+		}
+		if parent := filepath.Dir(name); parent != "." {
+			if err := root.MkdirAll(parent, 0o700); err != nil {
+				return err
+			}
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := root.MkdirAll(name, 0o700); err != nil {
+				return err
+			}
+			h.Name = name
+			dirs = append(dirs, h)
+		case tar.TypeReg:
+			if err := extractTarFile(root, name, h, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := root.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			if err := root.Symlink(h.Linkname, name); err != nil {
+				return err
+			}
+		default:
+			return errors.New("crypt: unsupported archive entry type for " + h.Name)
+		}
+	}
+
+	// the tar end marker can come before the end of the stream, read the
+	// rest so the final chunk is authenticated
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+
+	slices.Reverse(dirs)
+	for _, h := range dirs {
+		if err := root.Chmod(h.Name, h.FileInfo().Mode().Perm()); err != nil {
+			return err
+		}
+		if err := root.Chtimes(h.Name, h.ModTime, h.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractTarFile writes the contents of a regular file entry to name.
+func extractTarFile(root *os.Root, name string, h *tar.Header, r io.Reader) error {
+	f, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(h.FileInfo().Mode().Perm()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return root.Chtimes(name, h.ModTime, h.ModTime)
+}
@@ -0,0 +1,157 @@
+package crypt
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEncryptPath(t *testing.T) {
+	key := randKey()
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "a", "b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	big := randBytes(3*DefaultBlockSize + 5)
+	files := map[string][]byte{
+		"top.txt":       []byte("top"),
+		"a/b/big.bin":   big,
+		"a/empty":       nil,
+		"a/b/script.sh": []byte("#!/bin/sh\n"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(src, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(src, "a/b/script.sh"), 0o751); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("b/big.bin", filepath.Join(src, "a", "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "a", "b"), 0o555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(src, "a", "b"), 0o755)
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "top.txt"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	var ct bytes.Buffer
+	if err := EncryptPath(&ct, src, key); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "restored")
+	if err := DecryptPath(dst, bytes.NewReader(ct.Bytes()), key); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(dst, "a", "b"), 0o755)
+
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: contents differ", name)
+		}
+	}
+	if st, err := os.Stat(filepath.Join(dst, "a/b/script.sh")); err != nil || st.Mode().Perm() != 0o751 {
+		t.Errorf("script.sh mode = %v, %v", st.Mode(), err)
+	}
+	if st, err := os.Stat(filepath.Join(dst, "a/b")); err != nil || st.Mode().Perm() != 0o555 {
+		t.Errorf("a/b mode = %v, %v", st.Mode(), err)
+	}
+	if st, err := os.Stat(filepath.Join(dst, "top.txt")); err != nil || !st.ModTime().Equal(mtime) {
+		t.Errorf("top.txt mtime = %v, %v", st.ModTime(), err)
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "a", "link")); err != nil || link != "b/big.bin" {
+		t.Errorf("link = %q, %v", link, err)
+	}
+
+	// a single file is stored under its base name
+	ct.Reset()
+	if err := EncryptPath(&ct, filepath.Join(src, "top.txt"), key); err != nil {
+		t.Fatal(err)
+	}
+	dst = t.TempDir()
+	if err := DecryptPath(dst, &ct, key); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(dst, "top.txt")); err != nil || string(got) != "top" {
+		t.Errorf("single file: %q, %v", got, err)
+	}
+}
+
+func TestDecryptPathTruncated(t *testing.T) {
+	key := randKey()
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "f"), randBytes(2*DefaultBlockSize), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var ct bytes.Buffer
+	if err := EncryptPath(&ct, src, key); err != nil {
+		t.Fatal(err)
+	}
+	err := DecryptPath(t.TempDir(), bytes.NewReader(ct.Bytes()[:ct.Len()-1]), key)
+	if err == nil {
+		t.Fatal("truncated archive restored without error")
+	}
+}
+
+// sealTar encrypts a hand made tar archive.
+func sealTar(t *testing.T, key *[32]byte, headers ...*tar.Header) []byte {
+	var ct bytes.Buffer
+	w, err := NewWriter(&ct, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(w)
+	for _, h := range headers {
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Size > 0 {
+			tw.Write(make([]byte, h.Size))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return ct.Bytes()
+}
+
+func TestDecryptPathEscape(t *testing.T) {
+	key := randKey()
+	outside := t.TempDir()
+
+	for name, headers := range map[string][]*tar.Header{
+		"dotdot":   {{Name: "../escaped", Typeflag: tar.TypeReg, Size: 1, Mode: 0o600}},
+		"absolute": {{Name: filepath.Join(outside, "escaped"), Typeflag: tar.TypeReg, Size: 1, Mode: 0o600}},
+		"symlink": {
+			{Name: "out", Typeflag: tar.TypeSymlink, Linkname: outside},
+			{Name: "out/escaped", Typeflag: tar.TypeReg, Size: 1, Mode: 0o600},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := DecryptPath(t.TempDir(), bytes.NewReader(sealTar(t, key, headers...)), key)
+			if err == nil {
+				t.Fatal("escaping entry restored without error")
+			}
+			if _, err := os.Stat(filepath.Join(outside, "escaped")); !errors.Is(err, os.ErrNotExist) {
+				t.Fatal("file written outside the destination")
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package crypt
+
+import "strconv"
+
+// TeeWriter encrypts one plaintext stream to several Writers in a single
+// pass, such as a local backup and a copy for an offsite key, without
+// reading the source twice. each Writer keeps its own key, recipients and
+// options, so the outputs share nothing but the plaintext.
+//
+// a write that fails on any destination ends the stream for all of them,
+// since the outputs could no longer hold the same plaintext.
+type TeeWriter struct {
+	writers []*Writer
+	err     error
+}
+
+// NewTeeWriter returns a TeeWriter writing to writers, made with NewWriter,
+// NewRecipientWriter and the like. the TeeWriter owns them from then on,
+// Close closes them all.
+func NewTeeWriter(writers ...*Writer) *TeeWriter {
+	return &TeeWriter{writers: writers}
+}
+
+// Write writes p to every destination in turn.
+func (t *TeeWriter) Write(p []byte) (int, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	for i, w := range t.writers {
+		if _, err := w.Write(p); err != nil {
+			t.err = teeError(i, err)
+			return 0, t.err
+		}
+	}
+	return len(p), nil
+}
+
+// Close closes every destination, even once one has failed, returning the
+// first error. calling it again does nothing.
+func (t *TeeWriter) Close() error {
+	if t.err == ErrClosed {
+		return nil
+	}
+
+	err := t.err
+	for i, w := range t.writers {
+		if cerr := w.Close(); cerr != nil && err == nil {
+			err = teeError(i, cerr)
+		}
+	}
+	if err != nil {
+		t.err = err
+		return err
+	}
+	t.err = ErrClosed
+	return nil
+}
+
+// teeError says which destination err came from.
+func teeError(i int, err error) error {
+	return &detailError{msg: "crypt: destination " + strconv.Itoa(i) + ": " + err.Error(), err: err}
+}
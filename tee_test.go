@@ -0,0 +1,84 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestTeeWriter(t *testing.T) {
+	local, offsite := randKey(), randKey()
+	pub, priv, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randBytes(3*DefaultBlockSize + 11)
+
+	var a, b, c bytes.Buffer
+	wa, _ := NewWriter(&a, local)
+	wb, _ := NewWriter(&b, offsite, WithCipher(ChaCha20Poly1305))
+	wc, err := NewRecipientWriter(&c, []Recipient{pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tee := NewTeeWriter(wa, wb, wc)
+	if _, err := io.Copy(tee, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tee.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tee.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+
+	ra, _ := NewReader(&a, local)
+	rb, _ := NewReader(&b, offsite)
+	rc, err := NewRecipientReader(&c, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range []io.Reader{ra, rb, rc} {
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("destination %d: %v", i, err)
+		}
+	}
+}
+
+func TestTeeWriterError(t *testing.T) {
+	key := randKey()
+	failed := errors.New("disk full")
+
+	var ok bytes.Buffer
+	w1, _ := NewWriter(&ok, key, WithChunkSize(1024))
+	w2, err := NewWriter(&failingWriter{n: 2000, err: failed}, key, WithChunkSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tee := NewTeeWriter(w1, w2)
+
+	if _, err := tee.Write(randBytes(5000)); !errors.Is(err, failed) {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := tee.Write([]byte("more")); !errors.Is(err, failed) {
+		t.Fatalf("Write after failure: %v", err)
+	}
+	if err := tee.Close(); !errors.Is(err, failed) {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+// failingWriter fails once n bytes have been written.
+type failingWriter struct {
+	n   int
+	err error
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		return 0, w.err
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
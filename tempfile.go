@@ -0,0 +1,151 @@
+package crypt
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// TempFile is scratch space on disk that never holds plaintext, for
+// spooling uploads and the like. it is written like a file, encrypted as a
+// stream under a random key that only exists in memory, then read back
+// with Read, ReadAt and Seek. the first read ends the writing: the final
+// chunk is sealed and later writes fail. Close removes the file, and as
+// the key is gone with the TempFile a file left behind by a crash can't be
+// decrypted.
+type TempFile struct {
+	f    *os.File
+	key  *[32]byte
+	opts []Option
+
+	// w encrypts writes until the first read, r decrypts after it
+	w *Writer
+	r *io.SectionReader
+
+	err error
+}
+
+// CreateTemp creates a TempFile in dir, named like os.CreateTemp names
+// files from pattern. opts are used to encrypt, WithChunkSize and
+// WithConcurrency are the ones that matter.
+func CreateTemp(dir, pattern string, opts ...Option) (*TempFile, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	key := &[32]byte{}
+	if _, err := io.ReadFull(c.random, key[:]); err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(f, key, opts...)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return &TempFile{f: f, key: key, opts: opts, w: w}, nil
+}
+
+// Name returns the path of the file on disk.
+func (t *TempFile) Name() string {
+	return t.f.Name()
+}
+
+// Write encrypts p to the file, it fails once reading has started.
+func (t *TempFile) Write(p []byte) (int, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	if t.w == nil {
+		return 0, errors.New("crypt: temp file can't be written after it has been read")
+	}
+
+	n, err := t.w.Write(p)
+	if err != nil {
+		t.err = err
+	}
+	return n, err
+}
+
+// Read decrypts from the current offset, which starts at 0.
+func (t *TempFile) Read(p []byte) (int, error) {
+	if err := t.finish(); err != nil {
+		return 0, err
+	}
+	return t.r.Read(p)
+}
+
+// ReadAt decrypts len(p) bytes starting at off.
+func (t *TempFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := t.finish(); err != nil {
+		return 0, err
+	}
+	return t.r.ReadAt(p, off)
+}
+
+// Seek sets the offset of the next Read.
+func (t *TempFile) Seek(offset int64, whence int) (int64, error) {
+	if err := t.finish(); err != nil {
+		return 0, err
+	}
+	return t.r.Seek(offset, whence)
+}
+
+// Size returns how much plaintext the file holds, it ends the writing like
+// a read does.
+func (t *TempFile) Size() (int64, error) {
+	if err := t.finish(); err != nil {
+		return 0, err
+	}
+	return t.r.Size(), nil
+}
+
+// Close removes the file and forgets the key.
+func (t *TempFile) Close() error {
+	if t.err == ErrClosed {
+		return nil
+	}
+	t.err = ErrClosed
+	clear(t.key[:])
+
+	err := t.f.Close()
+	if rerr := os.Remove(t.f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// finish seals the final chunk the first time it is called and opens the
+// stream for reading.
+func (t *TempFile) finish() error {
+	if t.err != nil {
+		return t.err
+	}
+	if t.r != nil {
+		return nil
+	}
+
+	err := t.w.Close()
+	t.w = nil
+	var st os.FileInfo
+	if err == nil {
+		st, err = t.f.Stat()
+	}
+	var r *ReaderAt
+	if err == nil {
+		r, err = NewReaderAt(t.f, st.Size(), t.key, t.opts...)
+	}
+	if err != nil {
+		t.err = err
+		return err
+	}
+
+	t.r = io.NewSectionReader(r, 0, r.Size())
+	return nil
+}
@@ -0,0 +1,76 @@
+package crypt
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestCreateTemp(t *testing.T) {
+	dir := t.TempDir()
+	tf, err := CreateTemp(dir, "upload-*", WithChunkSize(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := randBytes(12345)
+	for p := plain; len(p) > 0; p = p[min(len(p), 777):] {
+		if _, err := tf.Write(p[:min(len(p), 777)]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	onDisk, err := os.ReadFile(tf.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(onDisk, plain[:64]) {
+		t.Fatal("plaintext on disk")
+	}
+
+	if size, err := tf.Size(); err != nil || size != int64(len(plain)) {
+		t.Fatalf("Size = %d, %v", size, err)
+	}
+	got, err := io.ReadAll(tf)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if _, err := tf.Seek(5000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 100)
+	if _, err := io.ReadFull(tf, buf); err != nil || !bytes.Equal(buf, plain[5000:5100]) {
+		t.Fatalf("Read after Seek: %v", err)
+	}
+	if _, err := tf.ReadAt(buf, 12300); err != io.EOF || !bytes.Equal(buf[:45], plain[12300:]) {
+		t.Fatalf("ReadAt at the end: %v", err)
+	}
+
+	if _, err := tf.Write([]byte("more")); err == nil {
+		t.Fatal("Write after Read succeeded")
+	}
+
+	if err := tf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tf.Name()); !os.IsNotExist(err) {
+		t.Fatal("temp file not removed")
+	}
+	if _, err := tf.Read(buf); err != ErrClosed {
+		t.Fatalf("Read after Close: %v", err)
+	}
+}
+
+func TestCreateTempEmpty(t *testing.T) {
+	tf, err := CreateTemp(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tf.Close()
+
+	got, err := io.ReadAll(tf)
+	if err != nil || len(got) != 0 {
+		t.Fatalf("ReadAll = %q, %v", got, err)
+	}
+}
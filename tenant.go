@@ -0,0 +1,165 @@
+package crypt
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// TenantKeyring hands out a separate key per tenant and binds the tenant ID
+// into every ciphertext as additional authenticated data, so data sealed for
+// one tenant can never be opened as another tenant's, even if a bug passes
+// the wrong tenant ID or key around.
+type TenantKeyring struct {
+	// fetch returns the key for a tenant, it is only called on a cache miss
+	fetch func(tenant string) (*[32]byte, error)
+
+	// approve is asked before anything that lets the caller decrypt, see
+	// RequireApproval. it is guarded by mu
+	approve func(tenant string) error
+
+	mu   sync.Mutex
+	keys map[string]*[32]byte
+
+	// fetching holds the fetches in progress, so concurrent misses for a
+	// tenant wait for one fetch instead of making their own
+	fetching map[string]*tenantFetch
+}
+
+// tenantFetch is a fetch in progress, key and err are set before done is
+// closed.
+type tenantFetch struct {
+	done chan struct{}
+	key  *[32]byte
+	err  error
+}
+
+// NewTenantKeyring returns a keyring that derives each tenant's key from
+// master with HKDF.
+func NewTenantKeyring(master *[32]byte) *TenantKeyring {
+	// copy master so the caller can't change it from under us
+	m := *master
+	return NewTenantKeyringFunc(func(tenant string) (*[32]byte, error) {
+		return deriveKey(&m, tenantKeyInfo+tenant)
+	})
+}
+
+// NewTenantKeyringFunc returns a keyring that gets each tenant's key from
+// fetch, e.g. from a KMS. fetch is called once per tenant and the result is
+// cached, concurrent calls for a tenant share one fetch and failures are
+// not cached. a nil key or a panic in fetch is a failure. the keyring's lock isn't held while fetch runs, so a slow
+// KMS only holds up callers for that tenant.
+func NewTenantKeyringFunc(fetch func(tenant string) (*[32]byte, error)) *TenantKeyring {
+	return &TenantKeyring{
+		fetch:    fetch,
+		keys:     make(map[string]*[32]byte),
+		fetching: make(map[string]*tenantFetch),
+	}
+}
+
+// RequireApproval makes Decrypt and Key call approve first and fail with
+// its error unless it returns nil, Encrypt is not affected. this is meant for
+// break-glass access to high value data, where approve checks e.g. a second
+// operator's signed approval token. it can be set or replaced while the
+// keyring is in use, calls already past the check aren't affected.
+func (k *TenantKeyring) RequireApproval(approve func(tenant string) error) {
+	k.mu.Lock()
+	k.approve = approve
+	k.mu.Unlock()
+}
+
+// Key returns a copy of the key for tenant, changing it doesn't change the
+// keyring's.
+func (k *TenantKeyring) Key(tenant string) (*[32]byte, error) {
+	// the key can decrypt as well as Decrypt can
+	if err := k.approved(tenant); err != nil {
+		return nil, err
+	}
+
+	key, err := k.key(tenant)
+	if err != nil {
+		return nil, err
+	}
+	c := *key
+	return &c, nil
+}
+
+// approved asks the approval hook, if there is one, about tenant.
+func (k *TenantKeyring) approved(tenant string) error {
+	k.mu.Lock()
+	approve := k.approve
+	k.mu.Unlock()
+	if approve == nil {
+		return nil
+	}
+
+	return approve(tenant)
+}
+
+// key returns the cached key for tenant without asking for approval, it
+// must not be handed to the caller.
+func (k *TenantKeyring) key(tenant string) (key *[32]byte, err error) {
+	k.mu.Lock()
+	if cached, ok := k.keys[tenant]; ok {
+		k.mu.Unlock()
+		return cached, nil
+	}
+	if f, ok := k.fetching[tenant]; ok {
+		k.mu.Unlock()
+		<-f.done
+		return f.key, f.err
+	}
+	f := &tenantFetch{done: make(chan struct{})}
+	k.fetching[tenant] = f
+	k.mu.Unlock()
+
+	// whatever fetch does, the waiters have to be let go, so a panic is
+	// turned into the error of this fetch
+	defer func() {
+		if r := recover(); r != nil {
+			f.key, f.err = nil, errors.New("crypt: fetching the key of tenant "+strconv.Quote(tenant)+" panicked: "+fmt.Sprint(r))
+			key, err = f.key, f.err
+		}
+
+		k.mu.Lock()
+		if f.err == nil {
+			k.keys[tenant] = f.key
+		}
+		delete(k.fetching, tenant)
+		k.mu.Unlock()
+		close(f.done)
+	}()
+
+	fetched, err := k.fetch(tenant)
+	if err == nil && fetched == nil {
+		err = errors.New("crypt: no key was fetched for tenant " + strconv.Quote(tenant))
+	}
+	if err == nil {
+		// copy the key so fetch can't change it from under us either
+		c := *fetched
+		f.key = &c
+	}
+	f.err = err
+	return f.key, f.err
+}
+
+// Encrypt encrypts plaintext for tenant, see the package level Encrypt.
+func (k *TenantKeyring) Encrypt(tenant string, plaintext []byte) ([]byte, error) {
+	key, err := k.key(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	return encrypt(randReader, DefaultCipher, plaintext, key, []byte(tenant))
+}
+
+// Decrypt decrypts ciphertext made by Encrypt for the same tenant.
+func (k *TenantKeyring) Decrypt(tenant string, ciphertext []byte) ([]byte, error) {
+	key, err := k.Key(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	return decrypt(ciphertext, key, []byte(tenant))
+}
@@ -0,0 +1,168 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestTenantKeyring makes sure tenants can read their own data but not each
+// others, even when handed the other tenants key.
+func TestTenantKeyring(t *testing.T) {
+	t.Parallel()
+	k := NewTenantKeyring(randKey())
+	data := randBytes(smallSize)
+
+	encrypted, err := k.Encrypt("alice", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := k.Decrypt("alice", encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatalf("[%X] != [%X]", decrypted, data)
+	}
+
+	if _, err := k.Decrypt("bob", encrypted); err == nil {
+		t.Fatal("bob decrypted alice's data")
+	}
+
+	// the right key without the tenant binding should still fail
+	aliceKey, err := k.Key("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decrypt(encrypted, aliceKey); err == nil {
+		t.Fatal("decrypted without the tenant ID")
+	}
+}
+
+// TestTenantKeyringFetch makes sure concurrent misses share one fetch, a
+// slow fetch doesn't hold up other tenants and Key hands out copies.
+func TestTenantKeyringFetch(t *testing.T) {
+	t.Parallel()
+	var fetches atomic.Int32
+	unblock := make(chan struct{})
+	k := NewTenantKeyringFunc(func(tenant string) (*[32]byte, error) {
+		if tenant == "alice" {
+			fetches.Add(1)
+			<-unblock
+		}
+		return randKey(), nil
+	})
+
+	var wg sync.WaitGroup
+	keys := make([]*[32]byte, 8)
+	for i := range keys {
+		wg.Go(func() {
+			key, err := k.Key("alice")
+			if err != nil {
+				t.Error(err)
+			}
+			keys[i] = key
+		})
+	}
+
+	if _, err := k.Key("bob"); err != nil {
+		t.Fatal(err)
+	}
+	close(unblock)
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("alice's key was fetched %d times", n)
+	}
+	for _, key := range keys[1:] {
+		if *key != *keys[0] {
+			t.Fatal("callers got different keys")
+		}
+	}
+
+	keys[0][0] ^= 1
+	if key, _ := k.Key("alice"); *key != *keys[1] {
+		t.Fatal("changing a key from Key changed the keyring's")
+	}
+}
+
+// TestTenantKeyringFetchFailure makes sure a fetch returning no key or
+// panicking fails, and doesn't leave later callers waiting.
+func TestTenantKeyringFetchFailure(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int32
+	k := NewTenantKeyringFunc(func(tenant string) (*[32]byte, error) {
+		if calls.Add(1) > 2 {
+			return randKey(), nil
+		}
+		if tenant == "nil" {
+			return nil, nil
+		}
+		panic("kms client broke")
+	})
+
+	if _, err := k.Key("nil"); err == nil {
+		t.Fatal("nil key was accepted")
+	}
+	if _, err := k.Key("panic"); err == nil || !strings.Contains(err.Error(), "kms client broke") {
+		t.Fatalf("panicking fetch: %v", err)
+	}
+
+	// failures aren't cached, so the next call fetches again
+	done := make(chan error, 1)
+	go func() {
+		_, err := k.Key("panic")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("caller is stuck after a panicking fetch")
+	}
+}
+
+// TestTenantApproval makes sure decrypting needs approval once it is
+// required, and encrypting doesn't.
+func TestTenantApproval(t *testing.T) {
+	t.Parallel()
+	k := NewTenantKeyring(randKey())
+	errDenied := errors.New("denied")
+	approved := false
+	k.RequireApproval(func(tenant string) error {
+		if !approved || tenant != "alice" {
+			return errDenied
+		}
+		return nil
+	})
+
+	encrypted, err := k.Encrypt("alice", randBytes(smallSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Decrypt("alice", encrypted); err != errDenied {
+		t.Fatalf("Decrypt without approval gave %v", err)
+	}
+	if _, err := k.Key("alice"); err != errDenied {
+		t.Fatalf("Key without approval gave %v", err)
+	}
+
+	approved = true
+	if _, err := k.Decrypt("alice", encrypted); err != nil {
+		t.Fatal(err)
+	}
+	// approval can be required while the keyring is in use
+	var wg sync.WaitGroup
+	wg.Go(func() { k.RequireApproval(func(string) error { return errDenied }) })
+	k.Decrypt("alice", encrypted)
+	wg.Wait()
+	if _, err := k.Decrypt("alice", encrypted); err != errDenied {
+		t.Fatalf("Decrypt after replacing the approval gave %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package crypt
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+// Timelock is an external service that wraps keys so they can only be
+// unwrapped after a given time, e.g. an escrow or a timelock encryption
+// network. the time is enforced by the service, not by this package.
+type Timelock interface {
+	// Wrap returns key wrapped so that Unwrap refuses it before notBefore.
+	Wrap(key *[32]byte, notBefore time.Time) ([]byte, error)
+
+	// Unwrap returns the key in wrapped, or an error if it is too early.
+	Unwrap(wrapped []byte) (*[32]byte, error)
+}
+
+// EncryptTimelocked encrypts plaintext with a fresh key that is wrapped by
+// tl, so the result can't be decrypted before notBefore. useful for
+// embargoed releases and dead man's switches. output takes the form
+// wrapped length|wrapped key|ciphertext, where wrapped length is a big
+// endian uint16 and ciphertext is what Encrypt produces, with the wrapped
+// key as its additional data.
+func EncryptTimelocked(plaintext []byte, tl Timelock, notBefore time.Time, opts ...Option) ([]byte, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &[32]byte{}
+	if _, err := io.ReadFull(c.random, key[:]); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := tl.Wrap(key, notBefore)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) > math.MaxUint16 {
+		return nil, errors.New("crypt: wrapped key is too large")
+	}
+
+	ciphertext, err := encrypt(c.random, c.cipher, plaintext, key, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 2, 2+len(wrapped)+len(ciphertext))
+	binary.BigEndian.PutUint16(out, uint16(len(wrapped)))
+	out = append(out, wrapped...)
+	return append(out, ciphertext...), nil
+}
+
+// DecryptTimelocked decrypts data made by EncryptTimelocked, it fails with
+// the error from tl if the key can't be unwrapped yet.
+func DecryptTimelocked(ciphertext []byte, tl Timelock) ([]byte, error) {
+	if len(ciphertext) < 2 {
+		return nil, errors.New("crypt: timelocked ciphertext is too short")
+	}
+	n := int(binary.BigEndian.Uint16(ciphertext))
+	ciphertext = ciphertext[2:]
+	if len(ciphertext) < n {
+		return nil, errors.New("crypt: timelocked ciphertext is too short")
+	}
+	wrapped := ciphertext[:n]
+
+	key, err := tl.Unwrap(wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	return decrypt(ciphertext[n:], key, wrapped)
+}
@@ -0,0 +1,77 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeTimelock is a Timelock that seals keys with its own key and a clock
+// that can be moved.
+type fakeTimelock struct {
+	key *[32]byte
+	now time.Time
+}
+
+var errTooEarly = errors.New("too early")
+
+func (f *fakeTimelock) Wrap(key *[32]byte, notBefore time.Time) ([]byte, error) {
+	b := binary.BigEndian.AppendUint64(nil, uint64(notBefore.Unix()))
+	return Encrypt(append(b, key[:]...), f.key)
+}
+
+func (f *fakeTimelock) Unwrap(wrapped []byte) (*[32]byte, error) {
+	b, err := Decrypt(wrapped, f.key)
+	if err != nil {
+		return nil, err
+	}
+	if f.now.Unix() < int64(binary.BigEndian.Uint64(b)) {
+		return nil, errTooEarly
+	}
+
+	return (*[32]byte)(b[8:]), nil
+}
+
+// TestTimelock makes sure timelocked data can only be read once the service
+// allows it, and that the wrapped key can't be swapped.
+func TestTimelock(t *testing.T) {
+	t.Parallel()
+	start := time.Now()
+	tl := &fakeTimelock{key: randKey(), now: start}
+	data := randBytes(smallSize)
+
+	encrypted, err := EncryptTimelocked(data, tl, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptTimelocked(encrypted, tl); err != errTooEarly {
+		t.Fatalf("decrypting early gave %v", err)
+	}
+
+	tl.now = start.Add(2 * time.Hour)
+	decrypted, err := DecryptTimelocked(encrypted, tl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatalf("[%X] != [%X]", decrypted, data)
+	}
+
+	// the wrapped key is authenticated, one with an earlier time can't be
+	// put in its place
+	other, err := EncryptTimelocked(data, tl, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 2 + int(binary.BigEndian.Uint16(encrypted))
+	swapped := append(other[:n:n], encrypted[n:]...)
+	if _, err := DecryptTimelocked(swapped, tl); err == nil {
+		t.Fatal("swapped wrapped key was accepted")
+	}
+
+	if _, err := DecryptTimelocked(encrypted[:1], tl); err == nil {
+		t.Fatal("truncated ciphertext was accepted")
+	}
+}
@@ -0,0 +1,103 @@
+package crypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// common bucket sizes for TimeTagger.
+const (
+	HourBucket = time.Hour
+	DayBucket  = 24 * time.Hour
+)
+
+// TimeTagSize is the size of a tag from TimeTagger.
+const TimeTagSize = 16
+
+// maxTimeTags limits how many tags Tags will return, so a typo in a range
+// doesn't allocate gigabytes.
+const maxTimeTags = 1 << 20
+
+// TimeTagger makes opaque tags for coarse timestamps, so encrypted records
+// can be stored next to a tag and a server that can't read them can still
+// partition them by time and prune old ones when the client tells it which
+// tags to drop.
+//
+// a tag is HMAC-SHA256 of the bucket number, the timestamp rounded down to
+// the bucket size, under a key derived from the master key.
+//
+// WHAT THIS LEAKS: every record in the same bucket gets the same tag, so
+// anyone who sees the tags learns which records were made in the same
+// bucket and how many records each bucket has. tags are not ordered, but
+// someone who watches records arrive (or sees the list of tags the client
+// asks to prune) can usually work out which bucket is which, so assume the
+// server learns the bucket of every record. pick the largest bucket you can
+// live with; tags never reveal anything finer than the bucket.
+type TimeTagger struct {
+	key    []byte
+	bucket time.Duration
+}
+
+// NewTimeTagger returns a TimeTagger with a key derived from master and the
+// given bucket size, which must be a whole number of seconds.
+func NewTimeTagger(master *[32]byte, bucket time.Duration) (*TimeTagger, error) {
+	if bucket < time.Second || bucket%time.Second != 0 {
+		return nil, errors.New("crypt: time tag bucket must be a whole number of seconds")
+	}
+
+	key, err := deriveKey(master, timeTagInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TimeTagger{key: key[:], bucket: bucket}, nil
+}
+
+// Tag returns the tag for the bucket containing ts.
+func (t *TimeTagger) Tag(ts time.Time) []byte {
+	return t.tag(t.index(ts))
+}
+
+// Tags returns the tags of every bucket from the one containing from to the
+// one containing to, oldest first. to prune records older than a cutoff,
+// pass the tags from the oldest record time to the cutoff to the server.
+func (t *TimeTagger) Tags(from, to time.Time) ([][]byte, error) {
+	first, last := t.index(from), t.index(to)
+	if last < first {
+		return nil, errors.New("crypt: time tag range ends before it starts")
+	}
+	if last-first >= maxTimeTags {
+		return nil, errors.New("crypt: time tag range covers too many buckets")
+	}
+
+	tags := make([][]byte, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		tags = append(tags, t.tag(i))
+	}
+	return tags, nil
+}
+
+// index returns the number of the bucket containing ts, counting from the
+// unix epoch.
+func (t *TimeTagger) index(ts time.Time) int64 {
+	secs := int64(t.bucket / time.Second)
+	unix := ts.Unix()
+	i := unix / secs
+	if unix < 0 && unix%secs != 0 {
+		i-- // round down, not towards zero
+	}
+	return i
+}
+
+// tag returns the tag for bucket i.
+func (t *TimeTagger) tag(i int64) []byte {
+	m := hmac.New(sha256.New, t.key)
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(t.bucket/time.Second))
+	binary.BigEndian.PutUint64(b[8:], uint64(i))
+	m.Write(b[:])
+	return m.Sum(nil)[:TimeTagSize]
+}
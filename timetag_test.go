@@ -0,0 +1,82 @@
+package crypt
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestTimeTagger makes sure times in the same bucket share a tag and times
+// in different buckets, or with a different key, don't.
+func TestTimeTagger(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	tt, err := NewTimeTagger(key, HourBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Date(2024, 3, 1, 10, 5, 0, 0, time.UTC)
+	tag := tt.Tag(ts)
+	if len(tag) != TimeTagSize {
+		t.Fatalf("tag is %d bytes", len(tag))
+	}
+	if !bytes.Equal(tag, tt.Tag(ts.Add(50*time.Minute))) {
+		t.Fatal("same hour got different tags")
+	}
+	if bytes.Equal(tag, tt.Tag(ts.Add(time.Hour))) {
+		t.Fatal("different hours got the same tag")
+	}
+
+	day, err := NewTimeTagger(key, DayBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(tag, day.Tag(ts)) {
+		t.Fatal("bucket size doesn't change the tag")
+	}
+	other, err := NewTimeTagger(randKey(), HourBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(tag, other.Tag(ts)) {
+		t.Fatal("key doesn't change the tag")
+	}
+
+	// times before the epoch round down too
+	neg := time.Unix(-1, 0)
+	if bytes.Equal(tt.Tag(neg), tt.Tag(time.Unix(0, 0))) {
+		t.Fatal("-1s is in the same bucket as 0")
+	}
+
+	if _, err := NewTimeTagger(key, time.Millisecond); err == nil {
+		t.Fatal("sub-second bucket accepted")
+	}
+}
+
+// TestTimeTags checks Tags returns every bucket in the range.
+func TestTimeTags(t *testing.T) {
+	t.Parallel()
+	tt, err := NewTimeTagger(randKey(), DayBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := time.Date(2024, 1, 30, 23, 0, 0, 0, time.UTC)
+	tags, err := tt.Tags(from, from.Add(48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 3 {
+		t.Fatalf("got %d tags, want 3", len(tags))
+	}
+	for i, tag := range tags {
+		if !bytes.Equal(tag, tt.Tag(from.Add(time.Duration(i)*DayBucket))) {
+			t.Fatalf("tag %d is wrong", i)
+		}
+	}
+
+	if _, err := tt.Tags(from, from.Add(-DayBucket)); err == nil {
+		t.Fatal("backwards range accepted")
+	}
+}
@@ -0,0 +1,356 @@
+package crypt
+
+import (
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+)
+
+// Tink keysets (https://developers.google.com/tink) as JSON, cleartext or
+// encrypted by a KMS. only 32 byte AEAD keys are imported, any of them can
+// be used with every cipher here. keys are exported as AES256_GCM keys with
+// the TINK output prefix, the same as Tink's own AES256_GCM template, so
+// Tink can use the keyset for its own AEAD too.
+const (
+	tinkTypePrefix        = "type.googleapis.com/google.crypto.tink."
+	tinkAESGCM            = tinkTypePrefix + "AesGcmKey"
+	tinkAESGCMSIV         = tinkTypePrefix + "AesGcmSivKey"
+	tinkChaCha20Poly1305  = tinkTypePrefix + "ChaCha20Poly1305Key"
+	tinkXChaCha20Poly1305 = tinkTypePrefix + "XChaCha20Poly1305Key"
+
+	tinkEnabled   = "ENABLED"
+	tinkSymmetric = "SYMMETRIC"
+	tinkPrefix    = "TINK"
+)
+
+// TinkAEAD is what encrypts and decrypts a Tink keyset, usually a key held
+// by a KMS. it has the same methods as Tink's tink.AEAD, so a Tink KMS
+// client's AEAD can be used as is.
+type TinkAEAD interface {
+	Encrypt(plaintext, associatedData []byte) ([]byte, error)
+	Decrypt(ciphertext, associatedData []byte) ([]byte, error)
+}
+
+// tinkKeyset is Tink's Keyset message as JSON.
+type tinkKeyset struct {
+	PrimaryKeyID uint32    `json:"primaryKeyId"`
+	Key          []tinkKey `json:"key"`
+}
+
+type tinkKey struct {
+	KeyData          tinkKeyData `json:"keyData"`
+	Status           string      `json:"status"`
+	KeyID            uint32      `json:"keyId"`
+	OutputPrefixType string      `json:"outputPrefixType"`
+}
+
+type tinkKeyData struct {
+	TypeURL string `json:"typeUrl"`
+
+	// Value is the key's own protobuf message, encoding/json uses standard
+	// base64 for it like protobuf's JSON mapping does
+	Value           []byte `json:"value"`
+	KeyMaterialType string `json:"keyMaterialType"`
+}
+
+// tinkEncryptedKeyset is Tink's EncryptedKeyset message as JSON, the keyset
+// info is only there for people reading it.
+type tinkEncryptedKeyset struct {
+	EncryptedKeyset []byte          `json:"encryptedKeyset"`
+	KeysetInfo      *tinkKeysetInfo `json:"keysetInfo,omitempty"`
+}
+
+type tinkKeysetInfo struct {
+	PrimaryKeyID uint32        `json:"primaryKeyId"`
+	KeyInfo      []tinkKeyInfo `json:"keyInfo"`
+}
+
+type tinkKeyInfo struct {
+	TypeURL          string `json:"typeUrl"`
+	Status           string `json:"status"`
+	KeyID            uint32 `json:"keyId"`
+	OutputPrefixType string `json:"outputPrefixType"`
+}
+
+// ParseTinkKeyset returns a Keyring holding the enabled keys of a
+// cleartext Tink keyset in JSON, with the keyset's primary key as its
+// primary.
+func ParseTinkKeyset(data []byte) (*Keyring, error) {
+	var ks tinkKeyset
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return nil, errors.New("crypt: invalid Tink keyset: " + err.Error())
+	}
+	return ks.keyring()
+}
+
+// ParseEncryptedTinkKeyset is ParseTinkKeyset for a keyset encrypted with
+// kek, associatedData has to match what it was encrypted with.
+func ParseEncryptedTinkKeyset(data []byte, kek TinkAEAD, associatedData []byte) (*Keyring, error) {
+	var eks tinkEncryptedKeyset
+	if err := json.Unmarshal(data, &eks); err != nil || len(eks.EncryptedKeyset) == 0 {
+		return nil, errors.New("crypt: invalid encrypted Tink keyset")
+	}
+
+	b, err := kek.Decrypt(eks.EncryptedKeyset, associatedData)
+	if err != nil {
+		return nil, errors.New("crypt: can't decrypt Tink keyset: " + err.Error())
+	}
+	defer clear(b)
+
+	ks, err := unmarshalTinkKeyset(b)
+	if err != nil {
+		return nil, err
+	}
+	return ks.keyring()
+}
+
+// MarshalTink returns the keyring as a cleartext Tink keyset in JSON, the
+// keys are in it unprotected.
+func (k *Keyring) MarshalTink() ([]byte, error) {
+	ks, err := k.tinkKeyset()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ks)
+}
+
+// MarshalEncryptedTink returns the keyring as a Tink keyset in JSON,
+// encrypted with kek and associatedData.
+func (k *Keyring) MarshalEncryptedTink(kek TinkAEAD, associatedData []byte) ([]byte, error) {
+	ks, err := k.tinkKeyset()
+	if err != nil {
+		return nil, err
+	}
+
+	b := ks.marshal()
+	defer clear(b)
+	ct, err := kek.Encrypt(b, associatedData)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &tinkKeysetInfo{PrimaryKeyID: ks.PrimaryKeyID}
+	for _, key := range ks.Key {
+		info.KeyInfo = append(info.KeyInfo, tinkKeyInfo{
+			TypeURL:          key.KeyData.TypeURL,
+			Status:           key.Status,
+			KeyID:            key.KeyID,
+			OutputPrefixType: key.OutputPrefixType,
+		})
+	}
+	return json.Marshal(tinkEncryptedKeyset{EncryptedKeyset: ct, KeysetInfo: info})
+}
+
+// tinkKeyset converts the keyring, sorted by id so the output is stable.
+func (k *Keyring) tinkKeyset() (*tinkKeyset, error) {
+	primary, _, err := k.Primary()
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	ks := &tinkKeyset{PrimaryKeyID: primary}
+	for id, key := range k.keys {
+		// AesGcmKey is version (field 1, 0 so left out) and key_value
+		// (field 3)
+		value := append([]byte{3<<3 | 2, 32}, key[:]...)
+		ks.Key = append(ks.Key, tinkKey{
+			KeyData:          tinkKeyData{TypeURL: tinkAESGCM, Value: value, KeyMaterialType: tinkSymmetric},
+			Status:           tinkEnabled,
+			KeyID:            id,
+			OutputPrefixType: tinkPrefix,
+		})
+	}
+	slices.SortFunc(ks.Key, func(a, b tinkKey) int { return cmp.Compare(a.KeyID, b.KeyID) })
+	return ks, nil
+}
+
+// keyring builds a Keyring from the enabled keys, disabled and destroyed
+// keys are left out.
+func (ks *tinkKeyset) keyring() (*Keyring, error) {
+	kr := NewKeyring()
+	for _, key := range ks.Key {
+		if key.Status != tinkEnabled {
+			continue
+		}
+		if key.KeyData.KeyMaterialType != tinkSymmetric {
+			return nil, errors.New("crypt: Tink key " + strconv.FormatUint(uint64(key.KeyID), 10) + " is not a symmetric key")
+		}
+
+		// ChaCha20Poly1305Key has key_value as field 2, the others as
+		// field 3
+		field := 3
+		switch key.KeyData.TypeURL {
+		case tinkAESGCM, tinkAESGCMSIV, tinkXChaCha20Poly1305:
+		case tinkChaCha20Poly1305:
+			field = 2
+		default:
+			return nil, errors.New("crypt: unsupported Tink key type " + key.KeyData.TypeURL)
+		}
+		fields, err := protoFields(key.KeyData.Value)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields[field]) != 32 {
+			return nil, errors.New("crypt: Tink key " + strconv.FormatUint(uint64(key.KeyID), 10) + " is not 32 bytes")
+		}
+
+		if err := kr.Add(key.KeyID, (*[32]byte)(fields[field])); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := kr.SetPrimary(ks.PrimaryKeyID); err != nil {
+		return nil, errors.New("crypt: Tink keyset's primary key is missing or not enabled")
+	}
+	return kr, nil
+}
+
+// tink enums as numbers, for the binary encoding of an encrypted keyset
+var (
+	tinkStatus       = []string{"UNKNOWN_STATUS", tinkEnabled, "DISABLED", "DESTROYED"}
+	tinkPrefixType   = []string{"UNKNOWN_PREFIX", tinkPrefix, "LEGACY", "RAW", "CRUNCHY"}
+	tinkMaterialType = []string{"UNKNOWN_KEYMATERIAL", "SYMMETRIC", "ASYMMETRIC_PRIVATE", "ASYMMETRIC_PUBLIC", "REMOTE"}
+)
+
+// marshal encodes the keyset as the Keyset protobuf message, which is what
+// gets encrypted.
+func (ks *tinkKeyset) marshal() []byte {
+	var b []byte
+	b = protoVarint(b, 1, uint64(ks.PrimaryKeyID))
+	for _, key := range ks.Key {
+		var data []byte
+		data = protoBytes(data, 1, []byte(key.KeyData.TypeURL))
+		data = protoBytes(data, 2, key.KeyData.Value)
+		data = protoVarint(data, 3, uint64(slices.Index(tinkMaterialType, key.KeyData.KeyMaterialType)))
+
+		var kb []byte
+		kb = protoBytes(kb, 1, data)
+		kb = protoVarint(kb, 2, uint64(slices.Index(tinkStatus, key.Status)))
+		kb = protoVarint(kb, 3, uint64(key.KeyID))
+		kb = protoVarint(kb, 4, uint64(slices.Index(tinkPrefixType, key.OutputPrefixType)))
+		b = protoBytes(b, 2, kb)
+	}
+	return b
+}
+
+// unmarshalTinkKeyset decodes a Keyset protobuf message.
+func unmarshalTinkKeyset(b []byte) (*tinkKeyset, error) {
+	ks := &tinkKeyset{}
+	err := protoEach(b, func(num int, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			ks.PrimaryKeyID = uint32(v)
+		case 2:
+			key := tinkKey{}
+			err := protoEach(data, func(num int, v uint64, data []byte) error {
+				switch num {
+				case 1:
+					return protoEach(data, func(num int, v uint64, data []byte) error {
+						switch num {
+						case 1:
+							key.KeyData.TypeURL = string(data)
+						case 2:
+							key.KeyData.Value = data
+						case 3:
+							key.KeyData.KeyMaterialType = tinkEnum(tinkMaterialType, v)
+						}
+						return nil
+					})
+				case 2:
+					key.Status = tinkEnum(tinkStatus, v)
+				case 3:
+					key.KeyID = uint32(v)
+				case 4:
+					key.OutputPrefixType = tinkEnum(tinkPrefixType, v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ks.Key = append(ks.Key, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+func tinkEnum(names []string, v uint64) string {
+	if v >= uint64(len(names)) {
+		return ""
+	}
+	return names[v]
+}
+
+// just enough protobuf for Tink's messages: varints and length delimited
+// fields.
+
+func protoVarint(b []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(num)<<3)
+	return binary.AppendUvarint(b, v)
+}
+
+func protoBytes(b []byte, num int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(num)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// protoEach calls fn for every field in b, with the value of varint fields
+// or the contents of length delimited ones.
+func protoEach(b []byte, fn func(num int, v uint64, data []byte) error) error {
+	invalid := errors.New("crypt: invalid Tink keyset encoding")
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 || tag>>3 == 0 || tag>>3 > 1<<29 {
+			return invalid
+		}
+		b = b[n:]
+
+		var v uint64
+		var data []byte
+		switch tag & 7 {
+		case 0:
+			if v, n = binary.Uvarint(b); n <= 0 {
+				return invalid
+			}
+			b = b[n:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return invalid
+			}
+			data, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			return invalid
+		}
+
+		if err := fn(int(tag>>3), v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// protoFields returns the length delimited fields of a flat message.
+func protoFields(b []byte) (map[int][]byte, error) {
+	fields := make(map[int][]byte)
+	err := protoEach(b, func(num int, v uint64, data []byte) error {
+		fields[num] = data
+		return nil
+	})
+	return fields, err
+}
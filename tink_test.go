@@ -0,0 +1,125 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// testKEK is a TinkAEAD standing in for a KMS.
+type testKEK struct{ key *[32]byte }
+
+func (k testKEK) Encrypt(plaintext, ad []byte) ([]byte, error) {
+	gcm, _ := newGCM(k.key)
+	nonce, _ := newNonce(randReader, gcm.NonceSize())
+	return gcm.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+func (k testKEK) Decrypt(ciphertext, ad []byte) ([]byte, error) {
+	gcm, _ := newGCM(k.key)
+	return gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], ad)
+}
+
+// TestTink exports a keyring as a cleartext and an encrypted keyset and
+// reads streams back with the imported keyrings.
+func TestTink(t *testing.T) {
+	t.Parallel()
+	kr := NewKeyring()
+	kr.Add(7, randKey())
+	kr.Add(3, randKey())
+	kr.SetPrimary(3)
+
+	var buf bytes.Buffer
+	w, err := kr.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("hello"))
+	w.Close()
+
+	plain, err := kr.MarshalTink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kek := testKEK{randKey()}
+	encrypted, err := kr.MarshalEncryptedTink(kek, []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted, []byte("\"value\"")) {
+		t.Fatalf("encrypted keyset holds keys: %s", encrypted)
+	}
+
+	a, err := ParseTinkKeyset(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ParseEncryptedTinkKeyset(encrypted, kek, []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, got := range []*Keyring{a, b} {
+		if id, _, _ := got.Primary(); id != 3 {
+			t.Fatalf("primary is %d", id)
+		}
+		for _, id := range []uint32{3, 7} {
+			want, _ := kr.Key(id)
+			if k, err := got.Key(id); err != nil || *k != *want {
+				t.Fatalf("key %d is wrong", id)
+			}
+		}
+	}
+
+	if _, err := ParseEncryptedTinkKeyset(encrypted, kek, []byte("other")); err == nil {
+		t.Fatal("decrypted with the wrong associated data")
+	}
+}
+
+// TestTinkKeyTypes imports every supported key type and skips keys that
+// aren't enabled.
+func TestTinkKeyTypes(t *testing.T) {
+	t.Parallel()
+	key := bytes.Repeat([]byte{1}, 32)
+	value := func(field byte) string {
+		return base64.StdEncoding.EncodeToString(append([]byte{0x08, 0x00, field<<3 | 2, 32}, key...))
+	}
+	entry := func(typ, value, status string, id string) string {
+		return `{"keyData":{"typeUrl":"type.googleapis.com/google.crypto.tink.` + typ + `","value":"` + value +
+			`","keyMaterialType":"SYMMETRIC"},"status":"` + status + `","keyId":` + id + `,"outputPrefixType":"TINK"}`
+	}
+
+	keyset := `{"primaryKeyId":1,"key":[` + strings.Join([]string{
+		entry("AesGcmKey", value(3), "ENABLED", "1"),
+		entry("AesGcmSivKey", value(3), "ENABLED", "2"),
+		entry("ChaCha20Poly1305Key", value(2), "ENABLED", "3"),
+		entry("XChaCha20Poly1305Key", value(3), "ENABLED", "4"),
+		entry("HmacKey", value(3), "DISABLED", "5"),
+	}, ",") + `]}`
+	kr, err := ParseTinkKeyset([]byte(keyset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := uint32(1); id <= 4; id++ {
+		if k, err := kr.Key(id); err != nil || !bytes.Equal(k[:], key) {
+			t.Fatalf("key %d is wrong", id)
+		}
+	}
+	if _, err := kr.Key(5); err == nil {
+		t.Fatal("imported a disabled key")
+	}
+
+	// from Tink's documentation, a 128 bit AES-GCM key
+	small := `{"primaryKeyId":42818733,"key":[{"keyData":{"typeUrl":"type.googleapis.com/google.crypto.tink.AesGcmKey","value":"GhCC74uJ+2f4qlpaHwR4ylNQ","keyMaterialType":"SYMMETRIC"},"status":"ENABLED","keyId":42818733,"outputPrefixType":"TINK"}]}`
+	bad := []string{
+		small,
+		`{"primaryKeyId":9,"key":[` + entry("AesGcmKey", value(3), "ENABLED", "1") + `]}`,
+		`{"primaryKeyId":1,"key":[` + entry("HmacKey", value(3), "ENABLED", "1") + `]}`,
+		`{"primaryKeyId":1,"key":[` + entry("AesGcmKey", "GhA=", "ENABLED", "1") + `]}`,
+	}
+	for _, b := range bad {
+		if _, err := ParseTinkKeyset([]byte(b)); err == nil {
+			t.Fatalf("parsed %s", b)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// DecryptAny decrypts data made by Encrypt with whichever of keys it was
+// encrypted with, returning the index of that key. it is for ciphertexts
+// from before key ids, such as when moving historical data into a Keyring.
+// each key is tried in turn, so it costs up to one Decrypt per key. if no
+// key works the error matches ErrKeyNotFound, whether the key is missing
+// or the ciphertext was tampered with.
+func DecryptAny(ciphertext []byte, keys ...*[32]byte) (plaintext []byte, i int, err error) {
+	for i, key := range keys {
+		plaintext, err = Decrypt(ciphertext, key)
+		if err == nil {
+			return plaintext, i, nil
+		}
+		if !errors.Is(err, ErrAuthenticationFailed) {
+			return nil, -1, err
+		}
+	}
+	return nil, -1, noKeyError(len(keys))
+}
+
+// WithCandidateKeys makes NewReader try each of keys on a stream, after the
+// key given to it if that isn't nil, for streams from before key ids. the
+// first chunk is opened with each key in turn until one authenticates, a
+// stream written with WithKeyFingerprint is matched by its fingerprint
+// instead. Reader.MatchedKey says which key it was. streams with detached
+// tags can't be tried.
+func WithCandidateKeys(keys ...*[32]byte) Option {
+	return func(c *config) error {
+		c.candidates = append(c.candidates, keys...)
+		return nil
+	}
+}
+
+// MatchedKey returns which of the keys tried because of WithCandidateKeys
+// the stream was written with, nil without the option.
+func (r *Reader) MatchedKey() *[32]byte {
+	return r.matched
+}
+
+// trialKey finds which of key and c.candidates the stream with header h
+// was written with. it returns r with whatever it had to read put back.
+func trialKey(r io.Reader, key *[32]byte, c *config, h *header) (io.Reader, *[32]byte, error) {
+	var keys []*[32]byte
+	if key != nil {
+		keys = append(keys, key)
+	}
+	keys = append(keys, c.candidates...)
+	if len(keys) == 0 {
+		return nil, nil, errors.New("crypt: no keys to try")
+	}
+
+	if want, ok := h.fields[fieldFingerprint]; ok {
+		for _, k := range keys {
+			if fp, err := rawFingerprint(k); err == nil && subtle.ConstantTimeCompare(fp, want) == 1 {
+				return r, k, nil
+			}
+		}
+		return nil, nil, noKeyError(len(keys))
+	}
+	if _, ok := h.fields[fieldDetached]; ok {
+		return nil, nil, errors.New("crypt: candidate keys can't be tried on a stream with detached tags")
+	}
+
+	aead, err := streamAEAD(h, keys[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	first := make([]byte, sequenceSize+aead.NonceSize()+h.chunkSize+aead.Overhead())
+	n, err := io.ReadFull(r, first)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			err = ErrTruncated
+		}
+		return nil, nil, err
+	}
+	first = first[:n]
+	r = io.MultiReader(bytes.NewReader(first), r)
+
+	header := append(h.aad(), c.aad...)
+	for _, k := range keys {
+		aead, err := streamAEAD(h, k)
+		if err != nil {
+			return nil, nil, err
+		}
+		// openChunk decrypts in place
+		if _, _, err := openChunk(aead, newChunkAAD(header), bytes.Clone(first), 0); err != ErrAuthenticationFailed {
+			return r, k, nil
+		}
+	}
+	return nil, nil, noKeyError(len(keys))
+}
+
+func noKeyError(n int) error {
+	return &detailError{msg: "crypt: none of the " + strconv.Itoa(n) + " keys decrypts the data", err: ErrKeyNotFound}
+}
@@ -0,0 +1,66 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestDecryptAny(t *testing.T) {
+	t.Parallel()
+	keys := []*[32]byte{randKey(), randKey(), randKey()}
+	data := randBytes(100)
+
+	ct, err := Encrypt(data, keys[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, i, err := DecryptAny(ct, keys...)
+	if err != nil || i != 1 || !bytes.Equal(got, data) {
+		t.Fatalf("DecryptAny = %d, %v", i, err)
+	}
+
+	if _, i, err := DecryptAny(ct, keys[0], keys[2]); !errors.Is(err, ErrKeyNotFound) || i != -1 {
+		t.Fatalf("without the key: %d, %v", i, err)
+	}
+}
+
+func TestWithCandidateKeys(t *testing.T) {
+	keys := []*[32]byte{randKey(), randKey(), randKey()}
+
+	for _, size := range []int{0, 100, 3*1024 + 1} {
+		for _, fingerprint := range []bool{false, true} {
+			data := randBytes(size)
+			opts := []Option{WithChunkSize(1024)}
+			if fingerprint {
+				opts = append(opts, WithKeyFingerprint())
+			}
+			var ct bytes.Buffer
+			w, err := NewWriter(&ct, keys[2], opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(data)
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := NewReader(bytes.NewReader(ct.Bytes()), nil, WithCandidateKeys(keys...))
+			if err != nil {
+				t.Fatalf("%d bytes: %v", size, err)
+			}
+			if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("%d bytes: %v", size, err)
+			}
+			if r.MatchedKey() != keys[2] {
+				t.Fatalf("%d bytes: matched the wrong key", size)
+			}
+
+			_, err = NewReader(bytes.NewReader(ct.Bytes()), keys[0], WithCandidateKeys(keys[1]))
+			if !errors.Is(err, ErrKeyNotFound) {
+				t.Fatalf("%d bytes without the key: %v", size, err)
+			}
+		}
+	}
+}
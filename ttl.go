@@ -0,0 +1,68 @@
+package crypt
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// timestampSize is the size of a timestamp recorded by WithTimestamp.
+const timestampSize = 8
+
+// WithTimestamp records when data was encrypted, so WithTTL can refuse it
+// once it gets too old. Encrypt puts the time in seconds ahead of its usual
+// output and authenticates it, so the result can only be decrypted with
+// WithTimestamp or WithTTL. streams record it in their header.
+//
+// the time is not secret, anyone holding the ciphertext can read it.
+func WithTimestamp() Option {
+	return func(c *config) error {
+		c.timestamp = true
+		return nil
+	}
+}
+
+// WithTTL makes Decrypt, NewReader and NewReaderAt refuse data encrypted
+// with WithTimestamp more than ttl ago with ErrExpired, and data without a
+// timestamp. the timestamp is checked against the local clock, timestamps
+// in the future are accepted.
+func WithTTL(ttl time.Duration) Option {
+	return func(c *config) error {
+		if ttl <= 0 {
+			return errors.New("crypt: TTL must be positive")
+		}
+		c.ttl = ttl
+		return nil
+	}
+}
+
+// DecryptWithTTL is Decrypt with WithTTL, for ciphertexts from Encrypt with
+// WithTimestamp such as password reset links and download tokens.
+func DecryptWithTTL(ciphertext []byte, key *[32]byte, ttl time.Duration, opts ...Option) ([]byte, error) {
+	return Decrypt(ciphertext, key, append(opts, WithTTL(ttl))...)
+}
+
+// checkTTL returns ErrExpired if the timestamp issued is older than the
+// TTL allows, nothing without a TTL.
+func (c *config) checkTTL(issued []byte) error {
+	if c.ttl == 0 {
+		return nil
+	}
+	t := time.Unix(int64(binary.BigEndian.Uint64(issued)), 0)
+	if time.Since(t) > c.ttl {
+		return ErrExpired
+	}
+	return nil
+}
+
+// checkStreamTTL is checkTTL for the timestamp in a stream header.
+func (c *config) checkStreamTTL(h *header) error {
+	if c.ttl == 0 {
+		return nil
+	}
+	issued, ok := h.fields[fieldTimestamp]
+	if !ok || len(issued) != timestampSize {
+		return errors.New("crypt: WithTTL given for a stream without a timestamp")
+	}
+	return c.checkTTL(issued)
+}
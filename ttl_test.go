@@ -0,0 +1,95 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDecryptWithTTL(t *testing.T) {
+	key := randKey()
+	ct, err := Encrypt([]byte("reset token"), key, WithTimestamp(), WithAAD([]byte("reset")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecryptWithTTL(ct, key, time.Minute, WithAAD([]byte("reset")))
+	if err != nil || string(got) != "reset token" {
+		t.Fatalf("DecryptWithTTL = %q, %v", got, err)
+	}
+	if _, err := Decrypt(ct, key, WithTimestamp(), WithAAD([]byte("reset"))); err != nil {
+		t.Fatalf("Decrypt with WithTimestamp: %v", err)
+	}
+
+	// backdating the timestamp breaks authentication
+	old := bytes.Clone(ct)
+	binary.BigEndian.PutUint64(old, uint64(time.Now().Add(-time.Hour).Unix()))
+	if _, err := Decrypt(old, key, WithTimestamp(), WithAAD([]byte("reset"))); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("changed timestamp: %v", err)
+	}
+	if _, err := DecryptWithTTL(old, key, time.Minute, WithAAD([]byte("reset"))); !errors.Is(err, ErrExpired) {
+		t.Fatalf("old ciphertext: %v", err)
+	}
+
+	plain, _ := Encrypt([]byte("x"), key)
+	if _, err := DecryptWithTTL(plain, key, time.Minute); err == nil {
+		t.Fatal("ciphertext without a timestamp accepted")
+	}
+	if _, err := DecryptWithTTL(ct, key, 0); err == nil {
+		t.Fatal("zero TTL accepted")
+	}
+}
+
+func TestStreamTTL(t *testing.T) {
+	key := randKey()
+	data := randBytes(100)
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key, WithTimestamp())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), key, WithTTL(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read: %v", err)
+	}
+	if _, err := NewReaderAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()), key, WithTTL(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := Inspect(bytes.NewReader(buf.Bytes()))
+	if err != nil || time.Since(info.Timestamp) > time.Minute {
+		t.Fatalf("Inspect timestamp %v, %v", info.Timestamp, err)
+	}
+
+	// a stream from a minute ago
+	h, raw, err := readHeader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.fields[fieldTimestamp] = binary.BigEndian.AppendUint64(nil, uint64(time.Now().Add(-time.Minute).Unix()))
+	old := append(h.encode(), buf.Bytes()[len(raw):]...)
+	if _, err := NewReader(bytes.NewReader(old), key, WithTTL(time.Second)); !errors.Is(err, ErrExpired) {
+		t.Fatalf("old stream: %v", err)
+	}
+	if _, err := NewReaderAt(bytes.NewReader(old), int64(len(old)), key, WithTTL(time.Second)); !errors.Is(err, ErrExpired) {
+		t.Fatalf("old stream with ReaderAt: %v", err)
+	}
+	// the timestamp is authenticated with the header
+	r, err = NewReader(bytes.NewReader(old), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("changed timestamp: %v", err)
+	}
+}
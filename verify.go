@@ -0,0 +1,38 @@
+package crypt
+
+import "io"
+
+// Verify authenticates every chunk of the stream in r with key, checking
+// their order and that the stream ends with its final chunk, without
+// decrypting anything anywhere but in place in its own buffer. it returns
+// nil for an intact stream, a *ChunkError naming the first bad chunk, or
+// an error about the header or signature. options apply as for NewReader,
+// a signed stream is verified too if WithTrustedSigners is given.
+func Verify(r io.Reader, key *[32]byte, opts ...Option) error {
+	c, err := newConfig(opts)
+	if err != nil {
+		return err
+	}
+	c.withhold = 0
+
+	h, _, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	if err := c.policy.check(h.cipher, h.version); err != nil {
+		return err
+	}
+	sr, err := newReader(r, key, c, h)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if err := sr.next(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		sr.plain = nil
+	}
+}
@@ -0,0 +1,64 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// TestVerify makes sure Verify accepts intact streams and names the right
+// chunk for damaged ones.
+func TestVerify(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	const chunkSize = 16
+	stream := sealStream(t, key, randBytes(5*chunkSize+3), chunkSize)
+	if err := Verify(bytes.NewReader(stream), key); err != nil {
+		t.Fatal(err)
+	}
+
+	h, raw, _ := readHeader(bytes.NewReader(stream))
+	frame := sequenceSize + h.cipher.nonceSize() + h.cipher.tagSize() + chunkSize
+	for _, chunk := range []int{0, 2, 5} {
+		damaged := bytes.Clone(stream)
+		damaged[len(raw)+chunk*frame+sequenceSize+1] ^= 1
+
+		var ce *ChunkError
+		err := Verify(bytes.NewReader(damaged), key)
+		if !errors.As(err, &ce) || ce.Chunk != int64(chunk) || ce.Offset != int64(len(raw)+chunk*frame) {
+			t.Fatalf("chunk %d: got %v", chunk, err)
+		}
+	}
+
+	var ce *ChunkError
+	err := Verify(bytes.NewReader(stream[:len(raw)+3*frame]), key)
+	if !errors.As(err, &ce) || ce.Chunk != 3 || !errors.Is(err, ErrTruncated) {
+		t.Fatalf("truncated: got %v", err)
+	}
+	if err := Verify(bytes.NewReader(stream), randKey()); err == nil {
+		t.Fatal("verified with the wrong key")
+	}
+}
+
+// TestVerifyArchive makes sure offsets account for parity chunks.
+func TestVerifyArchive(t *testing.T) {
+	t.Parallel()
+	key := randKey()
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, key, WithArchiveProfile(), WithChunkSize(4))
+	w.Write(randBytes(4 * 40))
+	w.Close()
+	stream := buf.Bytes()
+	if err := Verify(bytes.NewReader(stream), key); err != nil {
+		t.Fatal(err)
+	}
+
+	_, raw, _ := readHeader(bytes.NewReader(stream))
+	frame := sequenceSize + Cascade.nonceSize() + Cascade.tagSize() + 4
+	off := len(raw) + (20+1)*frame // chunk 20 follows one parity chunk
+	stream[off+sequenceSize] ^= 1
+	var ce *ChunkError
+	if err := Verify(bytes.NewReader(stream), key); !errors.As(err, &ce) || ce.Chunk != 20 || ce.Offset != int64(off) {
+		t.Fatalf("got %v", err)
+	}
+}
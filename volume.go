@@ -0,0 +1,309 @@
+package crypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// volumeManifestInfo derives the key volume manifests are authenticated
+// with.
+const volumeManifestInfo = "crypt volume manifest v1\x00"
+
+// VolumeName returns the name of volume n of a volume set, numbered from 1:
+// base.001, base.002 and so on. the manifest is base.manifest.
+func VolumeName(base string, n int) string {
+	s := strconv.Itoa(n)
+	for len(s) < 3 {
+		s = "0" + s
+	}
+	return base + "." + s
+}
+
+// volumeManifest lists the volumes of a set, MAC is HMAC-SHA256 over the
+// JSON of the rest with a key derived from the stream key.
+type volumeManifest struct {
+	Volumes []volumeEntry `json:"volumes"`
+	MAC     []byte        `json:"mac,omitempty"`
+}
+
+type volumeEntry struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 []byte `json:"sha256"`
+}
+
+// mac returns the MAC of m under key.
+func (m *volumeManifest) mac(key *[32]byte) ([]byte, error) {
+	macKey, err := deriveKey(key, volumeManifestInfo)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(volumeManifest{Volumes: m.Volumes})
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha256.New, macKey[:])
+	h.Write(b)
+	return h.Sum(nil), nil
+}
+
+// VolumeWriter encrypts a stream like NewWriter into numbered files of at
+// most maxSize bytes each, for media with a file size limit such as FAT32
+// or optical discs. Close writes a manifest listing every volume with its
+// size and SHA-256, authenticated with a key derived from the stream key.
+// NewVolumeReader checks it so a missing or cut short volume is named
+// before anything is read, and a damaged or swapped one once it has been
+// read if the stream's own checks haven't caught it by then.
+type VolumeWriter struct {
+	*Writer
+	out *volumeOut
+}
+
+// NewVolumeWriter returns a VolumeWriter writing the volumes of base, see
+// VolumeName, encrypted with key. existing files of the same names are
+// replaced.
+func NewVolumeWriter(base string, maxSize int64, key *[32]byte, opts ...Option) (*VolumeWriter, error) {
+	if maxSize <= 0 {
+		return nil, errors.New("crypt: volume size must be positive")
+	}
+
+	out := &volumeOut{base: base, max: maxSize, key: key}
+	w, err := NewWriter(out, key, opts...)
+	if err != nil {
+		out.abort()
+		return nil, err
+	}
+	return &VolumeWriter{Writer: w, out: out}, nil
+}
+
+// Close ends the stream, closes the last volume and writes the manifest.
+func (v *VolumeWriter) Close() error {
+	if err := v.Writer.Close(); err != nil {
+		v.out.abort()
+		return err
+	}
+	return v.out.finish()
+}
+
+// volumeOut writes to a new volume whenever the current one is full.
+type volumeOut struct {
+	base string
+	max  int64
+	key  *[32]byte
+
+	f        *os.File
+	sum      hash.Hash
+	written  int64
+	manifest volumeManifest
+	err      error
+}
+
+func (o *volumeOut) Write(p []byte) (int, error) {
+	if o.err != nil {
+		return 0, o.err
+	}
+
+	total := 0
+	for len(p) > 0 {
+		if o.f == nil || o.written == o.max {
+			if err := o.next(); err != nil {
+				o.err = err
+				return total, err
+			}
+		}
+		n := int(min(int64(len(p)), o.max-o.written))
+		if _, err := o.f.Write(p[:n]); err != nil {
+			o.err = err
+			return total, err
+		}
+		o.sum.Write(p[:n])
+		o.written += int64(n)
+		total += n
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// next closes the current volume and starts the next.
+func (o *volumeOut) next() error {
+	if err := o.closeVolume(); err != nil {
+		return err
+	}
+	name := VolumeName(o.base, len(o.manifest.Volumes)+1)
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	o.f, o.sum, o.written = f, sha256.New(), 0
+	return nil
+}
+
+// closeVolume syncs and closes the current volume, adding it to the
+// manifest.
+func (o *volumeOut) closeVolume() error {
+	if o.f == nil {
+		return nil
+	}
+	err := o.f.Sync()
+	if cerr := o.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	o.manifest.Volumes = append(o.manifest.Volumes, volumeEntry{Name: filepath.Base(o.f.Name()), Size: o.written, SHA256: o.sum.Sum(nil)})
+	o.f = nil
+	return nil
+}
+
+// finish closes the last volume and writes the manifest.
+func (o *volumeOut) finish() error {
+	if o.err == ErrClosed {
+		return nil
+	}
+	if o.err != nil {
+		return o.err
+	}
+	if err := o.closeVolume(); err != nil {
+		o.err = err
+		return err
+	}
+
+	mac, err := o.manifest.mac(o.key)
+	if err != nil {
+		return err
+	}
+	o.manifest.MAC = mac
+	b, err := json.MarshalIndent(o.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(o.base+".manifest", append(b, '\n'), 0o644); err != nil {
+		o.err = err
+		return err
+	}
+	o.err = ErrClosed
+	return nil
+}
+
+// abort closes the current volume, leaving whatever was written.
+func (o *volumeOut) abort() {
+	if o.f != nil {
+		o.f.Close()
+		o.f = nil
+	}
+}
+
+// VolumeReader decrypts a stream written by a VolumeWriter, checking each
+// volume against the manifest as it is read.
+type VolumeReader struct {
+	*Reader
+	in *volumeIn
+}
+
+// NewVolumeReader opens the volumes of base, decrypting them with key. the
+// manifest has to authenticate and every volume it lists has to be there
+// at the right size before anything is read.
+func NewVolumeReader(base string, key *[32]byte, opts ...Option) (*VolumeReader, error) {
+	b, err := os.ReadFile(base + ".manifest")
+	if err != nil {
+		return nil, err
+	}
+	var m volumeManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, errors.New("crypt: invalid volume manifest: " + err.Error())
+	}
+	mac, err := m.mac(key)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, m.MAC) {
+		return nil, &detailError{msg: "crypt: volume manifest failed to authenticate", err: ErrAuthenticationFailed}
+	}
+	if len(m.Volumes) == 0 {
+		return nil, errors.New("crypt: volume manifest lists no volumes")
+	}
+
+	dir := filepath.Dir(base)
+	for i, v := range m.Volumes {
+		if v.Name != filepath.Base(VolumeName(base, i+1)) {
+			return nil, errors.New("crypt: volume manifest is not for " + base)
+		}
+		st, err := os.Stat(filepath.Join(dir, v.Name))
+		if err != nil {
+			return nil, errors.New("crypt: volume " + strconv.Itoa(i+1) + " is missing: " + err.Error())
+		}
+		if st.Size() != v.Size {
+			return nil, errors.New("crypt: volume " + strconv.Itoa(i+1) + " is " + strconv.FormatInt(st.Size(), 10) + " bytes, the manifest says " + strconv.FormatInt(v.Size, 10))
+		}
+	}
+
+	in := &volumeIn{dir: dir, volumes: m.Volumes}
+	r, err := NewReader(in, key, opts...)
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	return &VolumeReader{Reader: r, in: in}, nil
+}
+
+// Close closes the volume being read.
+func (v *VolumeReader) Close() error {
+	return v.in.Close()
+}
+
+// volumeIn reads the volumes in order, checking each against its digest
+// once it has been read.
+type volumeIn struct {
+	dir     string
+	volumes []volumeEntry
+	i       int
+	f       *os.File
+	sum     hash.Hash
+}
+
+func (in *volumeIn) Read(p []byte) (int, error) {
+	for {
+		if in.f == nil {
+			if in.i == len(in.volumes) {
+				return 0, io.EOF
+			}
+			f, err := os.Open(filepath.Join(in.dir, in.volumes[in.i].Name))
+			if err != nil {
+				return 0, errors.New("crypt: volume " + strconv.Itoa(in.i+1) + " is missing: " + err.Error())
+			}
+			in.f, in.sum = f, sha256.New()
+		}
+
+		n, err := in.f.Read(p)
+		in.sum.Write(p[:n])
+		if err == io.EOF {
+			in.f.Close()
+			in.f = nil
+			if !hmac.Equal(in.sum.Sum(nil), in.volumes[in.i].SHA256) {
+				return n, errors.New("crypt: volume " + strconv.Itoa(in.i+1) + " doesn't match the manifest, it was damaged or swapped")
+			}
+			in.i++
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (in *volumeIn) Close() error {
+	if in.f == nil {
+		return nil
+	}
+	err := in.f.Close()
+	in.f = nil
+	return err
+}
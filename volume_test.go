@@ -0,0 +1,99 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeVolumes(t *testing.T, base string, key *[32]byte, data []byte) {
+	t.Helper()
+	w, err := NewVolumeWriter(base, 3000, key, WithChunkSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readVolumes(base string, key *[32]byte) ([]byte, error) {
+	r, err := NewVolumeReader(base, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func TestVolumes(t *testing.T) {
+	key := randKey()
+	base := filepath.Join(t.TempDir(), "backup")
+	data := randBytes(10000)
+	writeVolumes(t, base, key, data)
+
+	for n := 1; n <= 4; n++ {
+		st, err := os.Stat(VolumeName(base, n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n < 4 && st.Size() != 3000 {
+			t.Fatalf("volume %d is %d bytes", n, st.Size())
+		}
+	}
+	if _, err := os.Stat(VolumeName(base, 5)); err == nil {
+		t.Fatal("too many volumes")
+	}
+
+	got, err := readVolumes(base, key)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read: %v", err)
+	}
+
+	if _, err := readVolumes(base, randKey()); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("wrong key: %v", err)
+	}
+}
+
+func TestVolumesDamaged(t *testing.T) {
+	key := randKey()
+	data := randBytes(10000)
+
+	for name, damage := range map[string]func(base string){
+		"missing": func(base string) { os.Remove(VolumeName(base, 2)) },
+		"swapped": func(base string) {
+			os.Rename(VolumeName(base, 1), base+".tmp")
+			os.Rename(VolumeName(base, 2), VolumeName(base, 1))
+			os.Rename(base+".tmp", VolumeName(base, 2))
+		},
+		"flipped byte": func(base string) {
+			b, _ := os.ReadFile(VolumeName(base, 3))
+			b[10] ^= 1
+			os.WriteFile(VolumeName(base, 3), b, 0o644)
+		},
+		"manifest": func(base string) {
+			b, _ := os.ReadFile(base + ".manifest")
+			os.WriteFile(base+".manifest", bytes.Replace(b, []byte(`"size": 3000`), []byte(`"size": 2999`), 1), 0o644)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			base := filepath.Join(t.TempDir(), "backup")
+			writeVolumes(t, base, key, data)
+			damage(base)
+			_, err := readVolumes(base, key)
+			if err == nil {
+				t.Fatal("damaged volumes read without error")
+			}
+			if name == "missing" && !strings.Contains(err.Error(), "volume 2 is missing") {
+				t.Fatalf("missing volume: %v", err)
+			}
+		})
+	}
+}